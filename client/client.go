@@ -0,0 +1,127 @@
+// Package client calls the osai APIs from Go: the terminal API and the file
+// API, as served together by osai serve.
+//
+//	c := client.New("http://localhost:8080", os.Getenv("OSAI_API_KEY"))
+//	session, err := c.CreateSession(ctx, &client.SessionOptions{Name: "build"})
+//	...
+//	output, err := c.Exec(ctx, session.ID, &client.CommandRequest{Command: "go test ./..."})
+//
+// Failed calls return an *Error carrying the API's error code.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Client calls the terminal and file APIs. Its fields may be changed before
+// it is first used.
+type Client struct {
+	TerminalURL string // Base URL of version 1 of the terminal API
+	FilesURL    string // Base URL of version 1 of the file API
+	APIKey      string // Sent as a bearer token when set
+	HTTPClient  *http.Client
+}
+
+// New returns a client for the APIs served by osai serve at serverURL, such
+// as http://localhost:8080. APIs run on their own can be reached by setting
+// TerminalURL and FilesURL, though they then do not share sessions.
+func New(serverURL string, apiKey string) *Client {
+	serverURL = strings.TrimSuffix(serverURL, "/")
+	return &Client{
+		TerminalURL: serverURL + "/term/v1",
+		FilesURL:    serverURL + "/fs/v1",
+		APIKey:      apiKey,
+		HTTPClient:  http.DefaultClient,
+	}
+}
+
+// Error is an error answered by an API
+type Error struct {
+	StatusCode int    `json:"-"`
+	Message    string `json:"error"`
+	Code       string `json:"code"` // Such as SESSION_NOT_FOUND
+	RequestID  string `json:"requestId,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("%s (HTTP %d)", e.Message, e.StatusCode)
+	}
+	return fmt.Sprintf("%s (%s)", e.Message, e.Code)
+}
+
+// IsCode reports whether err is an API error with code
+func IsCode(err error, code string) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+// do sends a request with a JSON body, when body is not nil, and decodes
+// the JSON response into result, when it is not nil
+func (c *Client) do(ctx context.Context, method string, url string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// send sends an authenticated request, returning the response when it
+// succeeded and an *Error otherwise
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < http.StatusBadRequest {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	apiErr := &Error{StatusCode: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if json.Unmarshal(data, apiErr) != nil || apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	return nil, apiErr
+}
+
+// sessionURL returns the URL of a path under a session of an API
+func sessionURL(base string, sessionID string, path string) string {
+	return base + "/sessions/" + sessionID + path
+}
@@ -0,0 +1,133 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CommandRequest describes a command to run in a session. The terminal API
+// accepts more options, described in its reference.
+type CommandRequest struct {
+	Command     string            `json:"command"`
+	Timeout     int               `json:"timeout,omitempty"` // In seconds, 0 means none
+	Environment map[string]string `json:"environment,omitempty"`
+	Cwd         string            `json:"cwd,omitempty"` // Inside the session working directory
+	Secrets     []string          `json:"secrets,omitempty"`
+}
+
+// CommandOutput is the result of a command that ran to completion
+type CommandOutput struct {
+	Command       string  `json:"command"`
+	ExitCode      int     `json:"exitCode"`
+	Stdout        string  `json:"stdout"`
+	Stderr        string  `json:"stderr"`
+	ExecutionTime float64 `json:"executionTime"` // In seconds
+}
+
+// Process is a command running, or that ran, in the background
+type Process struct {
+	ID        string    `json:"id"`
+	Command   string    `json:"command"`
+	StartTime time.Time `json:"startTime"`
+	IsRunning bool      `json:"isRunning"`
+	ExitCode  int       `json:"exitCode,omitempty"`
+	PID       int       `json:"pid,omitempty"`
+}
+
+// OutputEvent is a line of a process's output, or its exit
+type OutputEvent struct {
+	Type      string    `json:"type"` // stdout, stderr or exit
+	Line      string    `json:"line,omitempty"`
+	Data      string    `json:"data,omitempty"` // Base64 encoded chunk of a raw mode process
+	ExitCode  int       `json:"exitCode"`
+	Timestamp time.Time `json:"timestamp"`
+	Replay    bool      `json:"replay,omitempty"` // Output from before the stream was opened
+}
+
+// Exec runs a command in a session and waits for it to finish
+func (c *Client) Exec(ctx context.Context, sessionID string, req *CommandRequest) (*CommandOutput, error) {
+	var output CommandOutput
+	if err := c.do(ctx, http.MethodPost, sessionURL(c.TerminalURL, sessionID, "/commands"), req, &output); err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+// StartProcess starts a command in the background
+func (c *Client) StartProcess(ctx context.Context, sessionID string, req *CommandRequest) (*Process, error) {
+	var process Process
+	if err := c.do(ctx, http.MethodPost, sessionURL(c.TerminalURL, sessionID, "/processes"), req, &process); err != nil {
+		return nil, err
+	}
+	return &process, nil
+}
+
+// ListProcesses returns a session's running and recently completed
+// processes, oldest first
+func (c *Client) ListProcesses(ctx context.Context, sessionID string) ([]Process, error) {
+	var result struct {
+		Processes map[string]Process `json:"processes"` // By ID
+	}
+	if err := c.do(ctx, http.MethodGet, sessionURL(c.TerminalURL, sessionID, "/processes"), nil, &result); err != nil {
+		return nil, err
+	}
+
+	processes := make([]Process, 0, len(result.Processes))
+	for _, process := range result.Processes {
+		processes = append(processes, process)
+	}
+	sort.Slice(processes, func(i, j int) bool {
+		return processes[i].StartTime.Before(processes[j].StartTime)
+	})
+	return processes, nil
+}
+
+// StreamOutput calls handle with each line a process outputs until it
+// exits, handle returns an error or ctx is done. With replay, the output
+// buffered before the call comes first.
+func (c *Client) StreamOutput(ctx context.Context, sessionID string, processID string, replay bool, handle func(*OutputEvent) error) error {
+	url := sessionURL(c.TerminalURL, sessionID, "/processes/"+processID+"/events")
+	if !replay {
+		url += "?replay=false"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Events are single data lines; comments keep the stream alive
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event OutputEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return err
+		}
+		if err := handle(&event); err != nil {
+			return err
+		}
+		if event.Type == "exit" {
+			return nil
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// UploadResult describes a file written by Upload
+type UploadResult struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// fileURL returns the URL of a file relative to a session's working
+// directory, under route
+func (c *Client) fileURL(sessionID string, route string, path string) string {
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return sessionURL(c.FilesURL, sessionID, route+strings.Join(segments, "/"))
+}
+
+// ReadFile returns the content of a text file in a session's working
+// directory
+func (c *Client) ReadFile(ctx context.Context, sessionID string, path string) ([]byte, error) {
+	var result struct {
+		Content string `json:"content"`
+	}
+	if err := c.do(ctx, http.MethodGet, c.fileURL(sessionID, "/files/", path), nil, &result); err != nil {
+		return nil, err
+	}
+	return []byte(result.Content), nil
+}
+
+// WriteFile writes a text file in a session's working directory, creating
+// it and its parent directories when they do not exist
+func (c *Client) WriteFile(ctx context.Context, sessionID string, path string, content []byte) error {
+	body := map[string]string{"content": string(content)}
+	return c.do(ctx, http.MethodPost, c.fileURL(sessionID, "/files/", path), body, nil)
+}
+
+// Upload writes a file in a session's working directory with the bytes read
+// from r as they are, creating it and its parent directories when they do
+// not exist. Unlike WriteFile it keeps binary content intact.
+func (c *Client) Upload(ctx context.Context, sessionID string, path string, r io.Reader) (*UploadResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.fileURL(sessionID, "/upload/", path), r)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Files []UploadResult `json:"files"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if len(result.Files) != 1 {
+		return nil, errors.New("unexpected upload response")
+	}
+	return &result.Files[0], nil
+}
+
+// Download opens the content of a file in a session's working directory as
+// it is, streamed rather than loaded whole like ReadFile. The caller closes
+// it.
+func (c *Client) Download(ctx context.Context, sessionID string, path string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.fileURL(sessionID, "/raw/", path), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
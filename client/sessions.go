@@ -0,0 +1,107 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Session is a session shared by the terminal and file APIs
+type Session struct {
+	ID         string                 `json:"id"`
+	Name       string                 `json:"name,omitempty"`
+	Tags       []string               `json:"tags,omitempty"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+	Owner      string                 `json:"owner,omitempty"`
+	WorkingDir string                 `json:"workingDir"`
+	CreatedAt  time.Time              `json:"createdAt"`
+	LastActive time.Time              `json:"lastActive"`
+	ExpiresAt  time.Time              `json:"expiresAt,omitzero"`
+	TTLSeconds *int64                 `json:"ttlSeconds,omitempty"` // Unset when the session never expires
+}
+
+// SessionOptions are the optional settings of a new session
+type SessionOptions struct {
+	Name          string                 `json:"name,omitempty"`
+	Tags          []string               `json:"tags,omitempty"`
+	Metadata      map[string]interface{} `json:"metadata,omitempty"`
+	ExpirySeconds int                    `json:"expirySeconds,omitempty"`
+	NeverExpire   bool                   `json:"neverExpire,omitempty"`
+}
+
+// ActivityEntry records one thing done in a session
+type ActivityEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	Category  string    `json:"category"`
+	Target    string    `json:"target,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Message   string    `json:"message"`
+}
+
+// ActivityPage is a page of a session's activity, oldest first
+type ActivityPage struct {
+	Activity []ActivityEntry `json:"activity"`
+	Count    int             `json:"count"`
+	Total    int             `json:"total"`
+	Offset   int             `json:"offset"`
+	HasMore  bool            `json:"hasMore"`
+}
+
+// CreateSession creates a session; opts may be nil
+func (c *Client) CreateSession(ctx context.Context, opts *SessionOptions) (*Session, error) {
+	if opts == nil {
+		opts = &SessionOptions{}
+	}
+	var session Session
+	if err := c.do(ctx, http.MethodPost, c.TerminalURL+"/sessions", opts, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetSession returns a session without counting as activity
+func (c *Client) GetSession(ctx context.Context, sessionID string) (*Session, error) {
+	var session Session
+	if err := c.do(ctx, http.MethodGet, sessionURL(c.TerminalURL, sessionID, ""), nil, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ListSessions returns the sessions the API key may use, those with every
+// tag in tags when any are given
+func (c *Client) ListSessions(ctx context.Context, tags ...string) ([]Session, error) {
+	query := url.Values{"tag": tags}
+	var result struct {
+		Sessions []Session `json:"sessions"`
+	}
+	if err := c.do(ctx, http.MethodGet, c.TerminalURL+"/sessions?"+query.Encode(), nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Sessions, nil
+}
+
+// DeleteSession deletes a session, stopping its processes
+func (c *Client) DeleteSession(ctx context.Context, sessionID string) error {
+	return c.do(ctx, http.MethodDelete, sessionURL(c.TerminalURL, sessionID, ""), nil, nil)
+}
+
+// SetWorkingDirectory sets the directory commands run in and file paths are
+// relative to
+func (c *Client) SetWorkingDirectory(ctx context.Context, sessionID string, dir string) error {
+	body := map[string]string{"workingDirectory": dir}
+	return c.do(ctx, http.MethodPut, sessionURL(c.TerminalURL, sessionID, "/cwd"), body, nil)
+}
+
+// Activity returns the most recent limit entries of a session's activity,
+// or all of them when limit is 0
+func (c *Client) Activity(ctx context.Context, sessionID string, limit int) (*ActivityPage, error) {
+	query := url.Values{"limit": {strconv.Itoa(limit)}}
+	var page ActivityPage
+	if err := c.do(ctx, http.MethodGet, sessionURL(c.TerminalURL, sessionID, "/activity?"+query.Encode()), nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"osai/client"
+)
+
+// exitCode ends the command with a status, such as the exit code of a
+// command it ran, without printing anything more
+type exitCode int
+
+func (code exitCode) Error() string {
+	return fmt.Sprintf("exit status %d", int(code))
+}
+
+// stringList is a flag that may be repeated
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *stringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
+// runClient runs a command that drives a server through the client, and
+// returns the status to exit with. The server is found at OSAI_URL, by
+// default http://localhost:8080, with the key in OSAI_API_KEY.
+func runClient(command string, args []string) int {
+	serverURL := os.Getenv("OSAI_URL")
+	if serverURL == "" {
+		serverURL = "http://localhost:8080"
+	}
+	c := client.New(serverURL, os.Getenv("OSAI_API_KEY"))
+
+	// Interrupting stops the request, such as a tail being followed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var err error
+	switch command {
+	case "session":
+		err = sessionCommand(ctx, c, args)
+	case "exec":
+		err = execCommand(ctx, c, args)
+	case "cp":
+		err = copyCommand(ctx, c, args)
+	case "tail":
+		err = tailCommand(ctx, c, args)
+	case "ps":
+		err = processesCommand(ctx, c, args)
+	}
+
+	var code exitCode
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &code):
+		return int(code)
+	case errors.Is(err, flag.ErrHelp):
+		return 2
+	}
+	fmt.Fprintf(os.Stderr, "osai %s: %v\n", command, err)
+	return 1
+}
+
+// sessionFlag adds the -session flag, which defaults to OSAI_SESSION
+func sessionFlag(flags *flag.FlagSet) *string {
+	return flags.String("session", os.Getenv("OSAI_SESSION"), "session to use, also set by OSAI_SESSION")
+}
+
+// requireSession returns the session to use, or an error saying how to
+// choose one
+func requireSession(sessionID string) (string, error) {
+	if sessionID == "" {
+		return "", errors.New("no session: set -session or OSAI_SESSION, or create one with osai session create")
+	}
+	return sessionID, nil
+}
+
+const sessionUsage = `Usage: osai session <command> [arguments]
+
+Commands:
+  create [-name name] [-tag tag]... [-cwd dir]   Create a session and print its ID
+  list [-tag tag]...                             List sessions
+  show <id>                                      Print a session as JSON
+  activity [-limit n] <id>                       Print what was done in a session
+  delete <id>                                    Delete a session and stop its processes
+`
+
+func sessionCommand(ctx context.Context, c *client.Client, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, sessionUsage)
+		return exitCode(2)
+	}
+
+	flags := flag.NewFlagSet("session "+args[0], flag.ContinueOnError)
+	var tags stringList
+	switch args[0] {
+	case "create":
+		name := flags.String("name", "", "name of the session")
+		flags.Var(&tags, "tag", "tag of the session, may be repeated")
+		cwd := flags.String("cwd", "", "working directory of the session")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		session, err := c.CreateSession(ctx, &client.SessionOptions{Name: *name, Tags: tags})
+		if err != nil {
+			return err
+		}
+		if *cwd != "" {
+			if err := c.SetWorkingDirectory(ctx, session.ID, *cwd); err != nil {
+				return err
+			}
+		}
+		fmt.Println(session.ID)
+		return nil
+
+	case "list":
+		flags.Var(&tags, "tag", "only list sessions with this tag, may be repeated")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		sessions, err := c.ListSessions(ctx, tags...)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tWORKING DIR\tLAST ACTIVE")
+		for _, session := range sessions {
+			lastActive := session.LastActive.Local().Format(time.DateTime)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", session.ID, session.Name, session.WorkingDir, lastActive)
+		}
+		return w.Flush()
+
+	case "show":
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return errors.New("usage: osai session show <id>")
+		}
+		session, err := c.GetSession(ctx, flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(session)
+
+	case "activity":
+		limit := flags.Int("limit", 20, "number of most recent entries to print, 0 for all")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return errors.New("usage: osai session activity [-limit n] <id>")
+		}
+		page, err := c.Activity(ctx, flags.Arg(0), *limit)
+		if err != nil {
+			return err
+		}
+		for _, entry := range page.Activity {
+			fmt.Printf("%s  %-9s %-7s %s\n", entry.Timestamp.Local().Format(time.DateTime), entry.Category, entry.Outcome, entry.Message)
+		}
+		return nil
+
+	case "delete":
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return errors.New("usage: osai session delete <id>")
+		}
+		return c.DeleteSession(ctx, flags.Arg(0))
+	}
+
+	fmt.Fprintf(os.Stderr, "osai session: unknown command %q\n\n%s", args[0], sessionUsage)
+	return exitCode(2)
+}
+
+// execCommand runs a command in a session, printing its output and exiting
+// with its exit code, or starts it in the background and prints the ID to
+// tail it by:
+//
+//	osai exec -- go test ./...
+//	osai exec -background -- npm run dev
+func execCommand(ctx context.Context, c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("exec", flag.ContinueOnError)
+	session := sessionFlag(flags)
+	timeout := flags.Int("timeout", 0, "seconds to let the command run, 0 for no limit")
+	cwd := flags.String("cwd", "", "directory to run in, inside the session working directory")
+	background := flags.Bool("background", false, "start the command in the background and print its process ID")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	sessionID, err := requireSession(*session)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("usage: osai exec [-session id] [-timeout seconds] [-cwd dir] [-background] -- command")
+	}
+
+	req := &client.CommandRequest{
+		Command: strings.Join(flags.Args(), " "),
+		Timeout: *timeout,
+		Cwd:     *cwd,
+	}
+	if *background {
+		process, err := c.StartProcess(ctx, sessionID, req)
+		if err != nil {
+			return err
+		}
+		fmt.Println(process.ID)
+		return nil
+	}
+	output, err := c.Exec(ctx, sessionID, req)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(os.Stdout, output.Stdout)
+	fmt.Fprint(os.Stderr, output.Stderr)
+	return exitStatus(output.ExitCode)
+}
+
+// copyCommand copies a file to or from a session. The path in the session,
+// relative to its working directory, starts with a colon:
+//
+//	osai cp main.go :src/main.go
+//	osai cp :build.log build.log
+func copyCommand(ctx context.Context, c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("cp", flag.ContinueOnError)
+	session := sessionFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	sessionID, err := requireSession(*session)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errors.New("usage: osai cp [-session id] <local> :<remote>, or :<remote> <local>")
+	}
+
+	source, destination := flags.Arg(0), flags.Arg(1)
+	sourcePath, sourceRemote := strings.CutPrefix(source, ":")
+	destinationPath, destinationRemote := strings.CutPrefix(destination, ":")
+	// Files are uploaded and downloaded as they are, so binaries copy intact
+	switch {
+	case !sourceRemote && destinationRemote:
+		file, err := os.Open(sourcePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = c.Upload(ctx, sessionID, destinationPath, file)
+		return err
+	case sourceRemote && !destinationRemote:
+		content, err := c.Download(ctx, sessionID, sourcePath)
+		if err != nil {
+			return err
+		}
+		defer content.Close()
+		file, err := os.Create(destinationPath)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(file, content); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	}
+	return errors.New("exactly one of the paths must be in the session, starting with a colon")
+}
+
+// tailCommand prints the output of a background process as it runs, and
+// exits with its exit code once it exits
+func tailCommand(ctx context.Context, c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("tail", flag.ContinueOnError)
+	session := sessionFlag(flags)
+	newOnly := flags.Bool("new", false, "skip the output the process printed before")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	sessionID, err := requireSession(*session)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: osai tail [-session id] [-new] <processId>")
+	}
+
+	code := -1
+	err = c.StreamOutput(ctx, sessionID, flags.Arg(0), !*newOnly, func(event *client.OutputEvent) error {
+		switch event.Type {
+		case "stdout":
+			fmt.Fprintln(os.Stdout, event.Line)
+		case "stderr":
+			fmt.Fprintln(os.Stderr, event.Line)
+		case "exit":
+			code = event.ExitCode
+		}
+		return nil
+	})
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if code < 0 {
+		return errors.New("output stream ended before the process exited")
+	}
+	return exitStatus(code)
+}
+
+// processesCommand lists the background processes of a session
+func processesCommand(ctx context.Context, c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("ps", flag.ContinueOnError)
+	session := sessionFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	sessionID, err := requireSession(*session)
+	if err != nil {
+		return err
+	}
+
+	processes, err := c.ListProcesses(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tSTARTED\tCOMMAND")
+	for _, process := range processes {
+		status := "running"
+		if !process.IsRunning {
+			status = fmt.Sprintf("exited %d", process.ExitCode)
+		}
+		started := process.StartTime.Local().Format(time.DateTime)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", process.ID, status, started, process.Command)
+	}
+	return w.Flush()
+}
+
+// exitStatus returns the error that exits with the exit code of a command
+func exitStatus(code int) error {
+	if code == 0 {
+		return nil
+	}
+	return exitCode(code)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// dashboardFiles is the web dashboard, a page that calls the terminal API
+// from the browser
+//
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// Prefix the dashboard is served under
+const dashboardPrefix = "/ui/"
+
+// dashboard serves the web dashboard, an operator console listing sessions
+// with their processes, live output, file changes and command history.
+// It holds no data of its own: the browser calls the APIs with the key the
+// operator enters.
+func dashboard() http.Handler {
+	files, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix(dashboardPrefix, http.FileServer(http.FS(files)))
+}
@@ -0,0 +1,323 @@
+// Operator console for osai serve. It only calls the terminal API, which
+// holds the sessions both APIs share and records file operations in their
+// activity.
+"use strict";
+
+const terminalAPI = "/term/v1";
+const refreshInterval = 5000;
+
+const state = {
+  apiKey: localStorage.getItem("osai.apiKey") || "",
+  sessionID: null,
+  processID: null,
+  stream: null, // AbortController of the output being followed
+};
+
+const $ = (id) => document.getElementById(id);
+
+// api calls the terminal API, throwing the API's error message on failure
+async function api(path, options = {}) {
+  const headers = Object.assign({}, options.headers);
+  if (state.apiKey) {
+    headers["Authorization"] = "Bearer " + state.apiKey;
+  }
+  const response = await fetch(terminalAPI + path, Object.assign({}, options, { headers }));
+  if (!response.ok) {
+    let message = response.statusText;
+    try {
+      const body = await response.json();
+      message = body.error + (body.code ? " (" + body.code + ")" : "");
+    } catch (e) {
+      // Not an API error body
+    }
+    const error = new Error(message);
+    error.status = response.status;
+    throw error;
+  }
+  return response;
+}
+
+async function getJSON(path) {
+  const response = await api(path);
+  return response.json();
+}
+
+function setStatus(text, kind) {
+  const status = $("status");
+  status.textContent = text;
+  status.className = "status " + (kind || "");
+}
+
+// el creates an element with text content, never parsing it as HTML
+function el(tag, text, className) {
+  const element = document.createElement(tag);
+  if (text !== undefined && text !== null) {
+    element.textContent = text;
+  }
+  if (className) {
+    element.className = className;
+  }
+  return element;
+}
+
+function row(cells) {
+  const tr = document.createElement("tr");
+  for (const cell of cells) {
+    const td = document.createElement("td");
+    if (cell instanceof Node) {
+      td.appendChild(cell);
+    } else {
+      td.textContent = cell;
+    }
+    tr.appendChild(td);
+  }
+  return tr;
+}
+
+function formatTime(value) {
+  if (!value) {
+    return "";
+  }
+  return new Date(value).toLocaleString();
+}
+
+function formatDuration(seconds) {
+  if (seconds === undefined || seconds === null) {
+    return "never";
+  }
+  if (seconds < 60) {
+    return seconds + "s";
+  }
+  if (seconds < 3600) {
+    return Math.floor(seconds / 60) + "m";
+  }
+  return Math.floor(seconds / 3600) + "h " + Math.floor((seconds % 3600) / 60) + "m";
+}
+
+function sessionLabel(session) {
+  return session.name ? session.name + " (" + session.id.slice(0, 8) + ")" : session.id;
+}
+
+async function refreshSessions() {
+  let result;
+  try {
+    result = await getJSON("/sessions");
+  } catch (error) {
+    setStatus(error.status === 401 ? "API key required" : error.message, "error");
+    return;
+  }
+  setStatus("connected", "ok");
+
+  const sessions = result.sessions || [];
+  sessions.sort((a, b) => new Date(b.lastActive) - new Date(a.lastActive));
+  $("session-count").textContent = "(" + sessions.length + ")";
+  $("no-sessions").hidden = sessions.length > 0;
+
+  const tbody = $("sessions");
+  tbody.replaceChildren();
+  for (const session of sessions) {
+    const tr = row([
+      el("code", sessionLabel(session)),
+      session.workingDir || "not set",
+      formatTime(session.lastActive),
+      formatDuration(session.ttlSeconds),
+    ]);
+    if (session.id === state.sessionID) {
+      tr.classList.add("selected");
+    }
+    tr.addEventListener("click", () => selectSession(session));
+    tbody.appendChild(tr);
+  }
+
+  if (state.sessionID && !sessions.some((session) => session.id === state.sessionID)) {
+    closeSession();
+  }
+}
+
+function selectSession(session) {
+  if (state.sessionID !== session.id) {
+    stopOutput();
+    $("output").replaceChildren();
+    $("output-title").textContent = "";
+  }
+  state.sessionID = session.id;
+  $("session-title").textContent = sessionLabel(session) + (session.workingDir ? " in " + session.workingDir : "");
+  $("session-panel").hidden = false;
+  refreshSessions();
+  refreshSession();
+}
+
+function closeSession() {
+  stopOutput();
+  state.sessionID = null;
+  $("session-panel").hidden = true;
+}
+
+async function refreshSession() {
+  const sessionID = state.sessionID;
+  if (!sessionID) {
+    return;
+  }
+  const base = "/sessions/" + encodeURIComponent(sessionID);
+  const [processes, files, history] = await Promise.allSettled([
+    getJSON(base + "/processes"),
+    getJSON(base + "/activity?category=file&limit=20"),
+    getJSON(base + "/history?limit=20"),
+  ]);
+  // The selection changed while loading
+  if (sessionID !== state.sessionID) {
+    return;
+  }
+
+  if (processes.status === "fulfilled") {
+    renderProcesses(Object.values(processes.value.processes || {}));
+  }
+  if (files.status === "fulfilled") {
+    renderLog($("files"), files.value.activity || [], (entry) => [entry.timestamp, entry.message, entry.outcome]);
+  }
+  if (history.status === "fulfilled") {
+    renderLog($("history"), history.value.history || [], (entry) => {
+      let outcome = "running";
+      if (entry.exitCode !== undefined && entry.exitCode !== null) {
+        outcome = entry.exitCode === 0 ? "success" : "failure";
+      }
+      return [entry.timestamp, entry.command, outcome];
+    });
+  }
+}
+
+function renderProcesses(processes) {
+  processes.sort((a, b) => new Date(b.startTime) - new Date(a.startTime));
+  $("no-processes").hidden = processes.length > 0;
+
+  const tbody = $("processes");
+  tbody.replaceChildren();
+  for (const process of processes) {
+    const status = process.isRunning
+      ? el("span", "running", "running")
+      : el("span", "exited " + (process.exitCode || 0), process.exitCode ? "failure" : "success");
+
+    const actions = document.createElement("span");
+    const follow = el("button", "Output");
+    follow.addEventListener("click", () => followOutput(process));
+    actions.appendChild(follow);
+    if (process.isRunning) {
+      const stop = el("button", "Stop");
+      stop.addEventListener("click", () => stopProcess(process));
+      actions.appendChild(stop);
+    }
+
+    tbody.appendChild(row([el("code", process.command), status, formatTime(process.startTime), actions]));
+  }
+}
+
+// renderLog lists entries newest first; fields returns the time, text and
+// outcome of an entry
+function renderLog(list, entries, fields) {
+  list.replaceChildren();
+  if (entries.length === 0) {
+    list.appendChild(el("li", "Nothing yet", "empty"));
+    return;
+  }
+  for (const entry of entries.slice().reverse()) {
+    const [timestamp, text, outcome] = fields(entry);
+    const li = document.createElement("li");
+    li.appendChild(el("time", formatTime(timestamp)));
+    li.appendChild(el("span", text, outcome));
+    list.appendChild(li);
+  }
+}
+
+async function stopProcess(process) {
+  if (!confirm("Send SIGTERM to " + process.command + "?")) {
+    return;
+  }
+  try {
+    await api("/sessions/" + encodeURIComponent(state.sessionID) + "/processes/" + encodeURIComponent(process.id) + "/signal", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ signal: "SIGTERM" }),
+    });
+  } catch (error) {
+    alert(error.message);
+  }
+  refreshSession();
+}
+
+function stopOutput() {
+  if (state.stream) {
+    state.stream.abort();
+    state.stream = null;
+  }
+  state.processID = null;
+}
+
+function appendOutput(text, className) {
+  const output = $("output");
+  // Keep following the end unless the operator scrolled up
+  const atEnd = output.scrollTop + output.clientHeight >= output.scrollHeight - 4;
+  output.appendChild(el("span", text + "\n", className));
+  if (atEnd) {
+    output.scrollTop = output.scrollHeight;
+  }
+}
+
+// followOutput streams a process's output from its Server-Sent Events.
+// EventSource cannot send the API key, so the stream is read with fetch.
+async function followOutput(process) {
+  stopOutput();
+  const controller = new AbortController();
+  state.stream = controller;
+  state.processID = process.id;
+  $("output").replaceChildren();
+  $("output-title").textContent = process.command;
+
+  const path = "/sessions/" + encodeURIComponent(state.sessionID) + "/processes/" + encodeURIComponent(process.id) + "/events";
+  try {
+    const response = await api(path, { signal: controller.signal, headers: { Accept: "text/event-stream" } });
+    const reader = response.body.getReader();
+    const decoder = new TextDecoder();
+    let buffered = "";
+    for (;;) {
+      const { value, done } = await reader.read();
+      if (done) {
+        break;
+      }
+      buffered += decoder.decode(value, { stream: true });
+      const lines = buffered.split("\n");
+      buffered = lines.pop();
+      for (const line of lines) {
+        if (!line.startsWith("data: ")) {
+          continue;
+        }
+        const event = JSON.parse(line.slice(6));
+        if (event.type === "exit") {
+          appendOutput("[exited with code " + event.exitCode + "]", "exit");
+          refreshSession();
+        } else if (event.data) {
+          appendOutput(atob(event.data), event.type);
+        } else {
+          appendOutput(event.line, event.type);
+        }
+      }
+    }
+  } catch (error) {
+    if (error.name !== "AbortError") {
+      appendOutput("[" + error.message + "]", "stderr");
+    }
+  }
+}
+
+$("api-key").value = state.apiKey;
+$("key-form").addEventListener("submit", (event) => {
+  event.preventDefault();
+  state.apiKey = $("api-key").value.trim();
+  localStorage.setItem("osai.apiKey", state.apiKey);
+  refreshSessions();
+});
+
+refreshSessions();
+setInterval(() => {
+  refreshSessions();
+  refreshSession();
+}, refreshInterval);
@@ -0,0 +1,60 @@
+// Command osai runs the file and terminal APIs together, and drives them
+// from the command line:
+//
+//	osai serve [-port 8080]
+//	osai session create -cwd ~/project
+//	osai exec -- go test ./...
+//
+// Each API can still be run on its own with the fileAPI and terminalAPI
+// commands.
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+const usage = `Usage: osai <command> [arguments]
+
+Commands:
+  serve      Serve the file API under /fs, the terminal API under /term and
+             a web dashboard under /ui
+  session    Create, list, inspect and delete sessions
+  exec       Run a command in a session, or start it in the background
+  ps         List the background processes of a session
+  tail       Print the output of a background process as it runs
+  cp         Copy a file to or from a session
+
+Commands other than serve call the server at OSAI_URL, by default
+http://localhost:8080, with the API key in OSAI_API_KEY. They act on the
+session given by -session or OSAI_SESSION:
+
+  export OSAI_SESSION=$(osai session create -cwd "$PWD")
+  osai exec -- go test ./...
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "serve":
+		err = serve(os.Args[2:])
+	case "session", "exec", "ps", "tail", "cp":
+		os.Exit(runClient(os.Args[1], os.Args[2:]))
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "osai: unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		slog.Error("osai "+os.Args[1]+" failed", "error", err)
+		os.Exit(1)
+	}
+}
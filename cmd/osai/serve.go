@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+
+	fileapp "fileAPI/app"
+	terminalapp "terminalAPI/app"
+	terminalservices "terminalAPI/services"
+)
+
+// Prefixes the APIs are mounted under
+const (
+	filesPrefix    = "/fs"
+	terminalPrefix = "/term"
+)
+
+// serve runs both APIs in one process on one port, sharing sessions: a
+// session created through either API can be used with both. Each API reads
+// its own section of the config file as when run on its own, except for its
+// port.
+func serve(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	port := flags.Int("port", 8080, "port to serve both APIs on, also set by OSAI_PORT")
+	withDashboard := flags.Bool("dashboard", true, "serve the web dashboard under /ui/")
+	if value := os.Getenv("OSAI_PORT"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > 65535 {
+			return fmt.Errorf("invalid OSAI_PORT: %s", value)
+		}
+		*port = n
+	}
+	flags.Parse(args)
+
+	// Both APIs log through the same structured logger
+	if err := terminalservices.ConfigureLogging(); err != nil {
+		return err
+	}
+
+	terminal, err := terminalapp.New()
+	if err != nil {
+		return fmt.Errorf("terminal API: %w", err)
+	}
+	files, err := fileapp.New()
+	if err != nil {
+		return fmt.Errorf("file API: %w", err)
+	}
+	// terminalAPI's sessions have everything the file API needs
+	files.Sessions.SetSessionProvider(&sharedSessions{sessionManager: terminal.Sessions})
+
+	mux := http.NewServeMux()
+	mux.Handle(terminalPrefix+"/", mount(terminalPrefix, terminal.Echo))
+	mux.Handle(filesPrefix+"/", mount(filesPrefix, files.Echo))
+	if *withDashboard {
+		mux.Handle(dashboardPrefix, dashboard())
+		mux.Handle("GET /{$}", http.RedirectHandler(dashboardPrefix, http.StatusFound))
+	}
+
+	errs := make(chan error, 3)
+	if err := terminal.StartGRPC(errs); err != nil {
+		return fmt.Errorf("terminal API: %w", err)
+	}
+	if err := files.StartGRPC(errs); err != nil {
+		return fmt.Errorf("file API: %w", err)
+	}
+	go func() {
+		slog.Info("starting osai server", "port", *port, "files", filesPrefix, "terminal", terminalPrefix, "dashboard", *withDashboard)
+		errs <- http.ListenAndServe(fmt.Sprintf(":%d", *port), mux)
+	}()
+	return <-errs
+}
+
+// mount serves an API under prefix. The API sees its usual paths, and the
+// prefix in X-Forwarded-Prefix so its OpenAPI spec can name it.
+func mount(prefix string, api http.Handler) http.Handler {
+	stripped := http.StripPrefix(prefix, api)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("X-Forwarded-Prefix", prefix)
+		stripped.ServeHTTP(w, r)
+	})
+}
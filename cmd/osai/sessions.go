@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	fileservices "fileAPI/services"
+	terminalservices "terminalAPI/services"
+)
+
+// sharedSessions gives the file API the sessions of the terminal API. The
+// file API gets copies of the sessions, so the fields it reads are kept in
+// one place. Sessions are synced with a shared session store around each
+// call, as the terminal API does around each request.
+type sharedSessions struct {
+	sessionManager *terminalservices.SessionManager
+}
+
+func (s *sharedSessions) CreateSession(opts *fileservices.SessionOptions) (*fileservices.Session, error) {
+	session, err := s.sessionManager.CreateSession(&terminalservices.SessionOptions{
+		Name:          opts.Name,
+		Tags:          opts.Tags,
+		Metadata:      opts.Metadata,
+		ExpirySeconds: opts.ExpirySeconds,
+		NeverExpire:   opts.NeverExpire,
+		Remote:        terminalRemote(opts.Remote),
+		Backups:       opts.Backups,
+		Owner:         opts.Owner,
+		AllowRemote:   opts.AllowRemote,
+	})
+	if err != nil {
+		return nil, fileError(err)
+	}
+	return fileSession(session), nil
+}
+
+func (s *sharedSessions) GetSession(id string) (*fileservices.Session, error) {
+	s.sessionManager.SyncSession(id)
+	session, err := s.sessionManager.GetSession(id)
+	if err != nil {
+		return nil, fileError(err)
+	}
+	return fileSession(session), s.sessionManager.SaveSession(id)
+}
+
+func (s *sharedSessions) PeekSession(id string) (*fileservices.Session, error) {
+	s.sessionManager.SyncSession(id)
+	session, err := s.sessionManager.PeekSession(id)
+	if err != nil {
+		return nil, fileError(err)
+	}
+	return fileSession(session), nil
+}
+
+func (s *sharedSessions) UpdateSession(id string, update *fileservices.SessionUpdate) (*fileservices.Session, error) {
+	s.sessionManager.SyncSession(id)
+	session, err := s.sessionManager.UpdateSession(id, &terminalservices.SessionUpdate{
+		Name:     update.Name,
+		Tags:     update.Tags,
+		Metadata: update.Metadata,
+	})
+	if err != nil {
+		return nil, fileError(err)
+	}
+	return fileSession(session), s.sessionManager.SaveSession(id)
+}
+
+func (s *sharedSessions) SessionOwner(id string) (string, bool) {
+	s.sessionManager.SyncSession(id)
+	return s.sessionManager.SessionOwner(id)
+}
+
+func (s *sharedSessions) DeleteSession(id string) error {
+	s.sessionManager.SyncSession(id)
+	return fileError(s.sessionManager.DeleteSession(id))
+}
+
+func (s *sharedSessions) SetWorkingDirectory(id string, dir string) error {
+	s.sessionManager.SyncSession(id)
+	if err := s.sessionManager.SetWorkingDirectory(id, dir); err != nil {
+		return fileError(err)
+	}
+	return s.sessionManager.SaveSession(id)
+}
+
+func (s *sharedSessions) LogActivity(id string, entry fileservices.ActivityEntry) error {
+	s.sessionManager.SyncSession(id)
+	if err := s.sessionManager.LogActivity(id, terminalservices.ActivityEntry{
+		Category: entry.Category,
+		Target:   entry.Target,
+		Outcome:  entry.Outcome,
+		Message:  entry.Message,
+	}); err != nil {
+		return fileError(err)
+	}
+	return s.sessionManager.SaveSession(id)
+}
+
+func (s *sharedSessions) GetActivity(id string, query *fileservices.ActivityQuery) (*fileservices.ActivityPage, error) {
+	s.sessionManager.SyncSession(id)
+	page, err := s.sessionManager.GetActivity(id, &terminalservices.ActivityQuery{
+		Category: query.Category,
+		Outcome:  query.Outcome,
+		Since:    query.Since,
+		Until:    query.Until,
+		Limit:    query.Limit,
+		Offset:   query.Offset,
+	})
+	if err != nil {
+		return nil, fileError(err)
+	}
+	return &fileservices.ActivityPage{
+		Activity: fileActivity(page.Activity),
+		Count:    page.Count,
+		Total:    page.Total,
+		Offset:   page.Offset,
+		HasMore:  page.HasMore,
+	}, nil
+}
+
+func (s *sharedSessions) GetAllSessions(owner string) []*fileservices.Session {
+	var sessions []*fileservices.Session
+	for _, session := range s.sessionManager.GetAllSessions(owner) {
+		sessions = append(sessions, fileSession(session))
+	}
+	return sessions
+}
+
+// fileSession copies the fields of a terminal session the file API has
+func fileSession(session *terminalservices.Session) *fileservices.Session {
+	session.Lock.Lock()
+	defer session.Lock.Unlock()
+	return &fileservices.Session{
+		ID:            session.ID,
+		CreatedAt:     session.CreatedAt,
+		LastActive:    session.LastActive,
+		WorkingDir:    session.WorkingDir,
+		IsActive:      session.IsActive,
+		ExpiresAt:     session.ExpiresAt,
+		ExpirySeconds: session.ExpirySeconds,
+		NeverExpire:   session.NeverExpire,
+		ActivityLog:   fileActivity(session.ActivityLog),
+		Owner:         session.Owner,
+		Name:          session.Name,
+		Tags:          append([]string(nil), session.Tags...),
+		Metadata:      session.Metadata,
+		Remote:        fileRemote(session.Remote),
+		Backups:       session.Backups,
+	}
+}
+
+// terminalRemote converts a remote configuration of the file API; the
+// remote shell keeps its default
+func terminalRemote(remote *fileservices.RemoteConfig) *terminalservices.RemoteConfig {
+	if remote == nil {
+		return nil
+	}
+	return &terminalservices.RemoteConfig{
+		Host:           remote.Host,
+		Port:           remote.Port,
+		User:           remote.User,
+		IdentityFile:   remote.IdentityFile,
+		KnownHostsFile: remote.KnownHostsFile,
+	}
+}
+
+// fileRemote copies the remote configuration of a terminal session, which
+// the file API connects to over SFTP
+func fileRemote(remote *terminalservices.RemoteConfig) *fileservices.RemoteConfig {
+	if remote == nil {
+		return nil
+	}
+	return &fileservices.RemoteConfig{
+		Host:           remote.Host,
+		Port:           remote.Port,
+		User:           remote.User,
+		IdentityFile:   remote.IdentityFile,
+		KnownHostsFile: remote.KnownHostsFile,
+	}
+}
+
+// fileActivity copies activity entries of the terminal API
+func fileActivity(entries []terminalservices.ActivityEntry) []fileservices.ActivityEntry {
+	var copied []fileservices.ActivityEntry
+	for _, entry := range entries {
+		copied = append(copied, fileservices.ActivityEntry(entry))
+	}
+	return copied
+}
+
+// fileError reports errors of the terminal API's sessions as the file API's
+// errors, so it answers them with the same status and code as on its own
+func fileError(err error) error {
+	for terminalErr, fileErr := range map[error]error{
+		terminalservices.ErrSessionNotFound:      fileservices.ErrSessionNotFound,
+		terminalservices.ErrInvalidSessionLabels: fileservices.ErrInvalidSessionLabels,
+		terminalservices.ErrInvalidExpiry:        fileservices.ErrInvalidExpiry,
+		terminalservices.ErrDirectoryNotAllowed:  fileservices.ErrDirectoryNotAllowed,
+		terminalservices.ErrInvalidRemote:        fileservices.ErrInvalidRemote,
+		terminalservices.ErrRemoteUnsupported:    fileservices.ErrRemoteUnsupported,
+		terminalservices.ErrRemoteForbidden:      fileservices.ErrRemoteForbidden,
+	} {
+		if err == terminalErr {
+			return fileErr
+		}
+		if errors.Is(err, terminalErr) {
+			return fmt.Errorf("%w: %s", fileErr, strings.TrimPrefix(err.Error(), terminalErr.Error()+": "))
+		}
+	}
+	return err
+}
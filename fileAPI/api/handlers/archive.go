@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+	"github.com/labstack/echo/v4"
+	"fileAPI/services"
+)
+
+// CreateArchive packs files and directories into a zip or tar.gz archive
+func (h *FileHandler) CreateArchive(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	var req services.ArchiveRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	if len(req.Paths) == 0 || req.Destination == "" {
+		return errorMessage(c, http.StatusBadRequest, "Paths and destination are required")
+	}
+	
+	result, err := h.fileService.CreateArchive(sessionID, &req)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusCreated, result)
+}
+
+// ExtractArchive unpacks an archive, reporting the outcome for each file
+func (h *FileHandler) ExtractArchive(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	var req services.ExtractArchiveRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	if req.Source == "" {
+		return errorMessage(c, http.StatusBadRequest, "Source is required")
+	}
+	
+	result, err := h.fileService.ExtractArchive(sessionID, &req)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusOK, result)
+}
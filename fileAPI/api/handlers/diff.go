@@ -27,16 +27,24 @@ func (h *DiffHandler) GenerateDiff(c echo.Context) error {
 	
 	var req services.DiffRequest
 	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid request body",
-		})
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	switch req.Mode {
+	case "", services.DiffModeText:
+	case services.DiffModeStructural:
+		result, err := h.diffService.StructuralDiff(sessionID, &req)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, err)
+		}
+		return c.JSON(http.StatusOK, result)
+	default:
+		return errorMessage(c, http.StatusBadRequest, "mode must be text or structural")
 	}
 	
 	response, err := h.diffService.GenerateDiff(sessionID, &req)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusInternalServerError, err)
 	}
 	
 	return c.JSON(http.StatusOK, response)
@@ -47,20 +55,82 @@ func (h *DiffHandler) ApplyPatch(c echo.Context) error {
 	
 	var req services.PatchRequest
 	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid request body",
-		})
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	opts, err := writeOptions(c)
+	if err != nil {
+		return errorMessage(c, http.StatusBadRequest, err.Error())
+	}
+	
+	dryRun, err := queryBool(c, "dryRun")
+	if err != nil {
+		return errorMessage(c, http.StatusBadRequest, err.Error())
+	}
+	if dryRun {
+		result, err := h.diffService.PlanPatch(sessionID, &req, opts)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, err)
+		}
+		return c.JSON(http.StatusOK, result)
+	}
+	
+	result, err := h.diffService.ApplyPatch(sessionID, &req, opts)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusOK, result)
+}
+
+// PreviewPatch applies a patch in memory, returning its result, the diff it
+// makes and the lines it adds and removes, without writing anything
+func (h *DiffHandler) PreviewPatch(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	var req services.PatchRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	preview, err := h.diffService.PreviewPatch(sessionID, &req)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusOK, preview)
+}
+
+// Merge merges the changes two versions made to a base text, with markers
+// around the conflicts and a list of them
+func (h *DiffHandler) Merge(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	var req services.MergeRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	result, err := h.diffService.Merge(sessionID, &req)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusOK, result)
+}
+
+// DiffDirectories compares two directories, or a directory with a
+// snapshot, listing the files added, removed and modified
+func (h *DiffHandler) DiffDirectories(c echo.Context) error {
+	var req services.DirDiffRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
 	}
 	
-	result, err := h.diffService.ApplyPatch(sessionID, &req)
+	result, err := h.diffService.DiffDirectories(c.Param("sessionId"), &req)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusInternalServerError, err)
 	}
 	
-	return c.JSON(http.StatusOK, map[string]string{
-		"result": result,
-		"path":   req.FilePath,
-	})
+	return c.JSON(http.StatusOK, result)
 }
@@ -27,11 +27,14 @@ func (h *DirectoryHandler) ListDirectories(c echo.Context) error {
 		path = "."
 	}
 	
-	dirs, err := h.dirService.ListDirectories(sessionID, path)
+	followSymlinks, err := queryBool(c, "followSymlinks")
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return errorMessage(c, http.StatusBadRequest, err.Error())
+	}
+	
+	dirs, err := h.dirService.ListDirectories(sessionID, path, followSymlinks)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
 	}
 	
 	return c.JSON(http.StatusOK, map[string]interface{}{
@@ -45,9 +48,7 @@ func (h *DirectoryHandler) CreateDirectory(c echo.Context) error {
 	path := c.Param("*")
 	
 	if err := h.dirService.CreateDirectory(sessionID, path); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusInternalServerError, err)
 	}
 	
 	return c.JSON(http.StatusCreated, map[string]string{
@@ -56,17 +57,40 @@ func (h *DirectoryHandler) CreateDirectory(c echo.Context) error {
 	})
 }
 
+// DeleteDirectory moves a directory to the session's trash, answering with
+// its trash entry, or deletes it with ?permanent=true
 func (h *DirectoryHandler) DeleteDirectory(c echo.Context) error {
 	sessionID := c.Param("sessionId")
 	path := c.Param("*")
+	permanent, err := queryBool(c, "permanent")
+	if err != nil {
+		return errorMessage(c, http.StatusBadRequest, err.Error())
+	}
 	
-	if err := h.dirService.DeleteDirectory(sessionID, path); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+	dryRun, err := queryBool(c, "dryRun")
+	if err != nil {
+		return errorMessage(c, http.StatusBadRequest, err.Error())
+	}
+	if dryRun {
+		plan, err := h.dirService.PlanDeleteDirectory(sessionID, path, permanent)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, err)
+		}
+		return c.JSON(http.StatusOK, plan)
 	}
 	
-	return c.NoContent(http.StatusNoContent)
+	entry, err := h.dirService.DeleteDirectory(sessionID, path, permanent)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	if entry == nil {
+		return c.NoContent(http.StatusNoContent)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Directory moved to the trash",
+		"trash":   entry,
+	})
 }
 
 // New method to get directory tree
@@ -85,11 +109,14 @@ func (h *DirectoryHandler) GetDirectoryTree(c echo.Context) error {
 		}
 	}
 	
-	tree, err := h.dirService.GetDirectoryTree(sessionID, path, depth)
+	followSymlinks, err := queryBool(c, "followSymlinks")
+	if err != nil {
+		return errorMessage(c, http.StatusBadRequest, err.Error())
+	}
+	
+	tree, err := h.dirService.GetDirectoryTree(sessionID, path, depth, followSymlinks)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusInternalServerError, err)
 	}
 	
 	return c.JSON(http.StatusOK, map[string]interface{}{
@@ -105,9 +132,7 @@ func (h *DirectoryHandler) GetDirectorySize(c echo.Context) error {
 	
 	size, err := h.dirService.CalculateDirectorySize(sessionID, path)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusInternalServerError, err)
 	}
 	
 	return c.JSON(http.StatusOK, map[string]interface{}{
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"mime"
+	"net/http"
+	"path/filepath"
+	"github.com/labstack/echo/v4"
+)
+
+// DownloadFile streams a file as it is, unlike GetFile, which loads it
+// into a JSON string. The Content-Type follows the file's extension, or
+// its first bytes without one, and Range and conditional requests are
+// answered as for static files. It downloads as an attachment unless
+// ?inline=true asks to display it.
+func (h *FileHandler) DownloadFile(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	path := c.Param("*")
+	
+	inline, err := queryBool(c, "inline")
+	if err != nil {
+		return errorMessage(c, http.StatusBadRequest, err.Error())
+	}
+	
+	file, info, err := h.fileService.OpenFile(sessionID, path)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	defer file.Close()
+	
+	disposition := "attachment"
+	if inline {
+		disposition = "inline"
+	}
+	header := c.Response().Header()
+	header.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": info.Name()}))
+	// Files are the session's, not the API's: keep a browser from running
+	// scripts in them with the API's origin
+	header.Set("Content-Security-Policy", "sandbox")
+	header.Set("X-Content-Type-Options", "nosniff")
+	
+	http.ServeContent(c.Response(), c.Request(), filepath.Base(path), info.ModTime(), file)
+	return nil
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/labstack/echo/v4"
+)
+
+// Encodings of file content in JSON bodies. Text is sent as it is; base64
+// and hex carry any bytes, so binaries round-trip intact.
+const (
+	EncodingUTF8   = "utf8"
+	EncodingBase64 = "base64"
+	EncodingHex    = "hex"
+)
+
+// contentEncoding returns the encoding a request asks for with ?encoding=,
+// or else fallback, which may be empty for the default utf8
+func contentEncoding(c echo.Context, fallback string) (string, error) {
+	encoding := c.QueryParam("encoding")
+	if encoding == "" {
+		encoding = fallback
+	}
+	switch encoding {
+	case "", EncodingUTF8, "utf-8":
+		return EncodingUTF8, nil
+	case EncodingBase64, EncodingHex:
+		return encoding, nil
+	}
+	return "", fmt.Errorf("Invalid encoding %q, expected utf8, base64 or hex", encoding)
+}
+
+// encodeContent returns file content in encoding
+func encodeContent(content []byte, encoding string) string {
+	switch encoding {
+	case EncodingBase64:
+		return base64.StdEncoding.EncodeToString(content)
+	case EncodingHex:
+		return hex.EncodeToString(content)
+	}
+	return string(content)
+}
+
+// decodeContent returns the bytes of file content sent in encoding
+func decodeContent(content string, encoding string) ([]byte, error) {
+	switch encoding {
+	case EncodingBase64:
+		decoded, err := base64.StdEncoding.DecodeString(content)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid base64 content: %v", err)
+		}
+		return decoded, nil
+	case EncodingHex:
+		decoded, err := hex.DecodeString(content)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid hex content: %v", err)
+		}
+		return decoded, nil
+	}
+	return []byte(content), nil
+}
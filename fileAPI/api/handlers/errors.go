@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+
+	"fileAPI/services"
+	"github.com/labstack/echo/v4"
+)
+
+// Error codes, which clients can check instead of parsing messages. Errors
+// without a more specific code get the code of their status.
+const (
+	CodeInvalidRequest       = "INVALID_REQUEST"
+	CodeUnauthorized         = "UNAUTHORIZED"
+	CodeForbidden            = "FORBIDDEN"
+	CodeNotFound             = "NOT_FOUND"
+	CodeMethodNotAllowed     = "METHOD_NOT_ALLOWED"
+	CodeConflict             = "CONFLICT"
+	CodePayloadTooLarge      = "PAYLOAD_TOO_LARGE"
+	CodeUnsupportedMediaType = "UNSUPPORTED_MEDIA_TYPE"
+	CodeRateLimited          = "RATE_LIMITED"
+	CodeInternal             = "INTERNAL_ERROR"
+	CodeNotImplemented       = "NOT_IMPLEMENTED"
+	CodeBadGateway           = "BAD_GATEWAY"
+	CodeUnavailable          = "UNAVAILABLE"
+	CodeTimeout              = "TIMEOUT"
+	CodeSessionNotFound      = "SESSION_NOT_FOUND"
+	CodeInvalidSessionLabels = "INVALID_SESSION_LABELS"
+	CodeInvalidExpiry        = "INVALID_EXPIRY"
+	CodeWorkingDirNotSet     = "WORKING_DIR_NOT_SET"
+	CodePathOutsideRoot      = "PATH_OUTSIDE_ROOT"
+	CodeDirectoryNotAllowed  = "DIRECTORY_NOT_ALLOWED"
+	CodeFileNotFound         = "FILE_NOT_FOUND"
+	CodeFileExists           = "FILE_EXISTS"
+	CodePermissionDenied     = "PERMISSION_DENIED"
+	CodeUploadTooLarge       = "UPLOAD_TOO_LARGE"
+	CodeIsDirectory          = "IS_DIRECTORY"
+	CodeInvalidRange         = "INVALID_RANGE"
+	CodeEditFailed           = "EDIT_FAILED"
+	CodeInvalidTarget        = "INVALID_TARGET"
+	CodeNotSymlink           = "NOT_A_SYMLINK"
+	CodeInvalidArchive       = "INVALID_ARCHIVE"
+	CodeFetchNotFound        = "FETCH_NOT_FOUND"
+	CodeFetchFailed          = "FETCH_FAILED"
+	CodeFetchTooLarge        = "FETCH_TOO_LARGE"
+	CodeChecksumMismatch     = "CHECKSUM_MISMATCH"
+	CodeRemoteUnsupported    = "REMOTE_UNSUPPORTED"
+	CodeInvalidRemote        = "INVALID_REMOTE"
+	CodeRemoteForbidden      = "REMOTE_FORBIDDEN"
+	CodeTrashNotFound        = "TRASH_NOT_FOUND"
+	CodeVersionNotFound      = "VERSION_NOT_FOUND"
+	CodeInvalidSearch        = "INVALID_SEARCH"
+	CodeSnapshotNotFound     = "SNAPSHOT_NOT_FOUND"
+	CodeSnapshotsDisabled    = "SNAPSHOTS_DISABLED"
+	CodeBinaryFile           = "BINARY_FILE"
+	CodeInvalidQuery         = "INVALID_QUERY"
+	CodeInvalidData          = "INVALID_DATA"
+	CodeInvalidPatch         = "INVALID_PATCH"
+	CodeNotGitRepository     = "NOT_A_GIT_REPOSITORY"
+	CodeGitRefNotFound       = "GIT_REF_NOT_FOUND"
+)
+
+// ErrorResponse is the body of every error response
+type ErrorResponse struct {
+	Error     string `json:"error"`               // For people; may change
+	Code      string `json:"code"`                // For programs; stable
+	RequestID string `json:"requestId,omitempty"` // Also in the X-Request-ID header and the logs
+}
+
+// serviceErrors gives the errors of the services and the file system the
+// same status and code wherever they are returned
+var serviceErrors = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{services.ErrSessionNotFound, http.StatusNotFound, CodeSessionNotFound},
+	{services.ErrInvalidSessionLabels, http.StatusBadRequest, CodeInvalidSessionLabels},
+	{services.ErrInvalidExpiry, http.StatusBadRequest, CodeInvalidExpiry},
+	{services.ErrNoWorkingDir, http.StatusConflict, CodeWorkingDirNotSet},
+	{services.ErrDirectoryNotAllowed, http.StatusForbidden, CodeDirectoryNotAllowed},
+	{services.ErrIsDirectory, http.StatusBadRequest, CodeIsDirectory},
+	{services.ErrInvalidRange, http.StatusBadRequest, CodeInvalidRange},
+	{services.ErrEditFailed, http.StatusUnprocessableEntity, CodeEditFailed},
+	{services.ErrInvalidTarget, http.StatusBadRequest, CodeInvalidTarget},
+	{services.ErrNotSymlink, http.StatusBadRequest, CodeNotSymlink},
+	{services.ErrInvalidArchive, http.StatusBadRequest, CodeInvalidArchive},
+	{services.ErrFetchNotFound, http.StatusNotFound, CodeFetchNotFound},
+	{services.ErrFetchFailed, http.StatusBadGateway, CodeFetchFailed},
+	{services.ErrFetchTooLarge, http.StatusRequestEntityTooLarge, CodeFetchTooLarge},
+	{services.ErrChecksumMismatch, http.StatusUnprocessableEntity, CodeChecksumMismatch},
+	{services.ErrRemoteUnsupported, http.StatusBadRequest, CodeRemoteUnsupported},
+	{services.ErrInvalidRemote, http.StatusBadRequest, CodeInvalidRemote},
+	{services.ErrRemoteForbidden, http.StatusForbidden, CodeRemoteForbidden},
+	{services.ErrTrashNotFound, http.StatusNotFound, CodeTrashNotFound},
+	{services.ErrVersionNotFound, http.StatusNotFound, CodeVersionNotFound},
+	{services.ErrInvalidSearch, http.StatusBadRequest, CodeInvalidSearch},
+	{services.ErrSnapshotNotFound, http.StatusNotFound, CodeSnapshotNotFound},
+	{services.ErrSnapshotsDisabled, http.StatusConflict, CodeSnapshotsDisabled},
+	{services.ErrBinaryFile, http.StatusUnsupportedMediaType, CodeBinaryFile},
+	{services.ErrInvalidQuery, http.StatusBadRequest, CodeInvalidQuery},
+	{services.ErrInvalidData, http.StatusUnprocessableEntity, CodeInvalidData},
+	{services.ErrInvalidPatch, http.StatusBadRequest, CodeInvalidPatch},
+	{services.ErrNotGitRepository, http.StatusBadRequest, CodeNotGitRepository},
+	{services.ErrGitRefNotFound, http.StatusNotFound, CodeGitRefNotFound},
+	{fs.ErrNotExist, http.StatusNotFound, CodeFileNotFound},
+	{fs.ErrExist, http.StatusConflict, CodeFileExists},
+	{fs.ErrPermission, http.StatusForbidden, CodePermissionDenied},
+}
+
+// ErrorJSON writes an error response carrying the request's ID
+func ErrorJSON(c echo.Context, status int, code string, message string) error {
+	return c.JSON(status, ErrorResponse{
+		Error:     message,
+		Code:      code,
+		RequestID: c.Response().Header().Get(echo.HeaderXRequestID),
+	})
+}
+
+// errorMessage writes an error response with the code of its status
+func errorMessage(c echo.Context, status int, message string) error {
+	return ErrorJSON(c, status, StatusCode(status), message)
+}
+
+// respondError writes an error response for a failed operation. Paths
+// leading outside the session working directory or the allowed directories
+// get 403, request bodies over their limit 413, other known errors their own
+// status and code, the rest status.
+func respondError(c echo.Context, status int, err error) error {
+	status, code := errorStatus(err, status)
+	return ErrorJSON(c, status, code, err.Error())
+}
+
+// errorStatus returns the status and code of err, falling back to status
+// and its code for errors the services do not name
+func errorStatus(err error, status int) (int, string) {
+	var escape *services.PathEscapeError
+	if errors.As(err, &escape) {
+		return http.StatusForbidden, CodePathOutsideRoot
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge, CodeUploadTooLarge
+	}
+	for _, known := range serviceErrors {
+		if errors.Is(err, known.err) {
+			return known.status, known.code
+		}
+	}
+	return status, StatusCode(status)
+}
+
+// StatusCode returns the code of errors with an HTTP status
+func StatusCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInvalidRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusRequestEntityTooLarge:
+		return CodePayloadTooLarge
+	case http.StatusUnsupportedMediaType:
+		return CodeUnsupportedMediaType
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusNotImplemented:
+		return CodeNotImplemented
+	case http.StatusBadGateway:
+		return CodeBadGateway
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	case http.StatusGatewayTimeout:
+		return CodeTimeout
+	}
+	if status < http.StatusInternalServerError {
+		return CodeInvalidRequest
+	}
+	return CodeInternal
+}
+
+// HTTPErrorHandler answers errors returned by routes and middleware, such as
+// unknown paths and methods, in the same form as the handlers
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	status := http.StatusInternalServerError
+	message := http.StatusText(status)
+	var httpError *echo.HTTPError
+	if errors.As(err, &httpError) {
+		status = httpError.Code
+		message = http.StatusText(status)
+		if text, ok := httpError.Message.(string); ok {
+			message = text
+		}
+	} else {
+		slog.Error("request failed", "error", err, services.LogKeyRequestID, c.Response().Header().Get(echo.HeaderXRequestID))
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(status)
+	} else {
+		err = ErrorJSON(c, status, StatusCode(status), message)
+	}
+	if err != nil {
+		slog.Warn("failed to write error response", "error", err)
+	}
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"fileAPI/services"
+)
+
+func TestPathEscapeErrorStatus(t *testing.T) {
+	escape := &services.PathEscapeError{Path: "../secret"}
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"path escape", escape, http.StatusForbidden, CodePathOutsideRoot},
+		{"wrapped path escape", fmt.Errorf("read failed: %w", escape), http.StatusForbidden, CodePathOutsideRoot},
+		{"directory not allowed", services.ErrDirectoryNotAllowed, http.StatusForbidden, CodeDirectoryNotAllowed},
+		{"unknown error", fmt.Errorf("disk on fire"), http.StatusInternalServerError, CodeInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+			rec := c.Response().Writer.(*httptest.ResponseRecorder)
+			if err := respondError(c, http.StatusInternalServerError, tt.err); err != nil {
+				t.Fatal(err)
+			}
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			var body ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Code != tt.code {
+				t.Errorf("code = %q, want %q", body.Code, tt.code)
+			}
+		})
+	}
+}
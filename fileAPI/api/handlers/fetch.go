@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+	"github.com/labstack/echo/v4"
+	"fileAPI/services"
+)
+
+type FetchHandler struct {
+	fetchService *services.FetchService
+}
+
+func NewFetchHandler(sm *services.SessionManager) *FetchHandler {
+	return &FetchHandler{
+		fetchService: services.NewFetchService(sm),
+	}
+}
+
+// Fetch starts downloading a URL to a file, answering at once with the
+// fetch to follow, or with wait once it ends
+func (h *FetchHandler) Fetch(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	var req services.FetchRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	if req.URL == "" || req.Path == "" {
+		return errorMessage(c, http.StatusBadRequest, "URL and path are required")
+	}
+	
+	info, err := h.fetchService.Fetch(sessionID, &req)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	if info.IsRunning {
+		return c.JSON(http.StatusAccepted, info)
+	}
+	return c.JSON(http.StatusCreated, info)
+}
+
+// ListFetches lists the running and recently finished fetches of a session
+func (h *FetchHandler) ListFetches(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"fetches": h.fetchService.List(sessionID),
+	})
+}
+
+// GetFetch returns the progress of a fetch, or how it ended
+func (h *FetchHandler) GetFetch(c echo.Context) error {
+	info, err := h.fetchService.Get(c.Param("sessionId"), c.Param("fetchId"))
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusOK, info)
+}
+
+// CancelFetch stops a running fetch
+func (h *FetchHandler) CancelFetch(c echo.Context) error {
+	if err := h.fetchService.Cancel(c.Param("sessionId"), c.Param("fetchId")); err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Fetch canceled",
+	})
+}
+
+// StreamFetchEvents streams the progress of a fetch as Server-Sent Events,
+// in the format of the terminal API's process output, until it ends. The
+// events sent before the client connected come first, unless ?replay=false.
+func (h *FetchHandler) StreamFetchEvents(c echo.Context) error {
+	sub, err := h.fetchService.Subscribe(c.Param("sessionId"), c.Param("fetchId"))
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	defer sub.Close()
+	
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.WriteHeader(http.StatusOK)
+	
+	if c.QueryParam("replay") != "false" {
+		for _, event := range sub.Replay {
+			event.Replay = true
+			writeFetchEvent(res, event)
+		}
+		res.Flush()
+	}
+	
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+	
+	for {
+		select {
+		case event, ok := <-sub.Events:
+			if !ok {
+				return nil
+			}
+			writeFetchEvent(res, event)
+			res.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(res, ": keepalive\n\n")
+			res.Flush()
+		case <-c.Request().Context().Done():
+			return nil
+		}
+	}
+}
+
+// writeFetchEvent writes a single event in text/event-stream format
+func writeFetchEvent(res *echo.Response, event services.FetchEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event.Type, data)
+}
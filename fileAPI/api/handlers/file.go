@@ -1,13 +1,15 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
 	"github.com/labstack/echo/v4"
 	"fileAPI/services"
 )
 
 type FileRequest struct {
-	Content string `json:"content"`
+	Content  string `json:"content"`
+	Encoding string `json:"encoding,omitempty"` // Of content: utf8 (default), base64 or hex; ?encoding= takes precedence
 }
 
 type BatchReadRequest struct {
@@ -18,10 +20,15 @@ type BatchFilesRequest struct {
 	Files map[string]string `json:"files"`
 }
 
-type SearchRequest struct {
-	Pattern   string `json:"pattern"`
-	Path      string `json:"path"`
-	Recursive bool   `json:"recursive"`
+type MoveRequest struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Overwrite   bool   `json:"overwrite"` // Replace an existing destination
+}
+
+type SymlinkRequest struct {
+	Path   string `json:"path"`   // Of the link
+	Target string `json:"target"` // Relative to the link's directory, as with ln -s
 }
 
 type FileHandler struct {
@@ -40,17 +47,68 @@ func (h *FileHandler) GetFile(c echo.Context) error {
 	sessionID := c.Param("sessionId")
 	path := c.Param("*")
 	
-	content, err := h.fileService.ReadFile(sessionID, path)
+	encoding, err := contentEncoding(c, "")
 	if err != nil {
-		return c.JSON(http.StatusNotFound, map[string]string{
-			"error": err.Error(),
-		})
+		return errorMessage(c, http.StatusBadRequest, err.Error())
 	}
 	
-	return c.JSON(http.StatusOK, map[string]string{
-		"path":    path,
-		"content": string(content),
-	})
+	req, err := sectionRequest(c)
+	if err != nil {
+		return errorMessage(c, http.StatusBadRequest, err.Error())
+	}
+	
+	section, err := h.fileService.ReadFileSection(sessionID, path, req)
+	if err != nil {
+		return respondError(c, http.StatusNotFound, err)
+	}
+	// Binary files come in base64 unless text is asked for, which they
+	// would not survive
+	if section.Binary && encoding == EncodingUTF8 {
+		if c.QueryParam("encoding") != "" {
+			return respondError(c, http.StatusUnsupportedMediaType, fmt.Errorf("%w: %s, read it with ?encoding=base64", services.ErrBinaryFile, path))
+		}
+		encoding = EncodingBase64
+	}
+	
+	response := map[string]interface{}{
+		"path":       path,
+		"content":    encodeContent(section.Content, encoding),
+		"encoding":   encoding,
+		"isBinary":   section.Binary,
+		"mimeType":   section.MimeType,
+		"size":       section.Size,
+		"totalLines": section.TotalLines,
+	}
+	if req.ByLines() {
+		response["startLine"] = section.StartLine
+		response["endLine"] = section.EndLine
+	} else if req.Offset != 0 || req.Length != 0 {
+		response["offset"] = section.Offset
+		response["length"] = len(section.Content)
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// sectionRequest reads the part of a file a read asks for, with ?offset=
+// and ?length= in bytes or ?startLine= and ?endLine=
+func sectionRequest(c echo.Context) (*services.SectionRequest, error) {
+	req := &services.SectionRequest{}
+	offset, err := queryInt(c, "offset", 0)
+	if err != nil {
+		return nil, err
+	}
+	length, err := queryInt(c, "length", 0)
+	if err != nil {
+		return nil, err
+	}
+	req.Offset, req.Length = int64(offset), int64(length)
+	if req.StartLine, err = queryInt(c, "startLine", 0); err != nil {
+		return nil, err
+	}
+	if req.EndLine, err = queryInt(c, "endLine", 0); err != nil {
+		return nil, err
+	}
+	return req, nil
 }
 
 func (h *FileHandler) ListFiles(c echo.Context) error {
@@ -60,11 +118,14 @@ func (h *FileHandler) ListFiles(c echo.Context) error {
 		path = "."
 	}
 	
-	files, err := h.fileService.ListFiles(sessionID, path)
+	followSymlinks, err := queryBool(c, "followSymlinks")
+	if err != nil {
+		return errorMessage(c, http.StatusBadRequest, err.Error())
+	}
+	
+	files, err := h.fileService.ListFiles(sessionID, path, followSymlinks)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusInternalServerError, err)
 	}
 	
 	return c.JSON(http.StatusOK, map[string]interface{}{
@@ -79,15 +140,32 @@ func (h *FileHandler) CreateFile(c echo.Context) error {
 	
 	var req FileRequest
 	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid request body",
-		})
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	content, err := requestContent(c, &req)
+	if err != nil {
+		return errorMessage(c, http.StatusBadRequest, err.Error())
+	}
+	
+	opts, err := writeOptions(c)
+	if err != nil {
+		return errorMessage(c, http.StatusBadRequest, err.Error())
+	}
+	dryRun, err := queryBool(c, "dryRun")
+	if err != nil {
+		return errorMessage(c, http.StatusBadRequest, err.Error())
+	}
+	if dryRun {
+		plan, err := h.fileService.PlanCreate(sessionID, path, content, opts)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, err)
+		}
+		return c.JSON(http.StatusOK, plan)
 	}
 	
-	if err := h.fileService.CreateFile(sessionID, path, []byte(req.Content)); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+	if err := h.fileService.CreateFile(sessionID, path, content, opts); err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
 	}
 	
 	return c.JSON(http.StatusCreated, map[string]string{
@@ -102,34 +180,248 @@ func (h *FileHandler) UpdateFile(c echo.Context) error {
 	
 	var req FileRequest
 	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid request body",
-		})
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
 	}
 	
-	if err := h.fileService.UpdateFile(sessionID, path, []byte(req.Content)); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+	content, err := requestContent(c, &req)
+	if err != nil {
+		return errorMessage(c, http.StatusBadRequest, err.Error())
 	}
 	
-	return c.JSON(http.StatusOK, map[string]string{
+	opts, err := writeOptions(c)
+	if err != nil {
+		return errorMessage(c, http.StatusBadRequest, err.Error())
+	}
+	dryRun, err := queryBool(c, "dryRun")
+	if err != nil {
+		return errorMessage(c, http.StatusBadRequest, err.Error())
+	}
+	if dryRun {
+		plan, err := h.fileService.PlanUpdate(sessionID, path, content, opts)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, err)
+		}
+		return c.JSON(http.StatusOK, plan)
+	}
+	
+	version, err := h.fileService.UpdateFile(sessionID, path, content, opts)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	response := map[string]interface{}{
 		"message": "File updated successfully",
 		"path":    path,
+	}
+	if version != nil {
+		response["backup"] = version
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// EditFile applies line-based operations to a file in order, all or none of
+// them, and returns the diff they made
+func (h *FileHandler) EditFile(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	path := c.Param("*")
+	
+	var req services.EditRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	result, err := h.fileService.EditFile(sessionID, path, &req)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusOK, result)
+}
+
+// Move moves or renames a file or directory
+func (h *FileHandler) Move(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	var req MoveRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	if req.Source == "" || req.Destination == "" {
+		return errorMessage(c, http.StatusBadRequest, "Source and destination are required")
+	}
+	
+	dryRun, err := queryBool(c, "dryRun")
+	if err != nil {
+		return errorMessage(c, http.StatusBadRequest, err.Error())
+	}
+	if dryRun {
+		plan, err := h.fileService.PlanMove(sessionID, req.Source, req.Destination, req.Overwrite)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, err)
+		}
+		return c.JSON(http.StatusOK, plan)
+	}
+	
+	if err := h.fileService.Move(sessionID, req.Source, req.Destination, req.Overwrite); err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusOK, map[string]string{
+		"message":     "Moved successfully",
+		"source":      req.Source,
+		"destination": req.Destination,
 	})
 }
 
+// Copy copies a file, or a directory with what it holds, reporting the
+// outcome for each file
+func (h *FileHandler) Copy(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	var req services.CopyRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	if req.Source == "" || req.Destination == "" {
+		return errorMessage(c, http.StatusBadRequest, "Source and destination are required")
+	}
+	
+	result, err := h.fileService.Copy(sessionID, &req)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusOK, result)
+}
+
+// Sync makes a destination directory mirror a source directory, or with
+// ?dryRun=true reports what it would change
+func (h *FileHandler) Sync(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	var req services.SyncRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	if req.Source == "" || req.Destination == "" {
+		return errorMessage(c, http.StatusBadRequest, "Source and destination are required")
+	}
+	
+	dryRun, err := queryBool(c, "dryRun")
+	if err != nil {
+		return errorMessage(c, http.StatusBadRequest, err.Error())
+	}
+	
+	result, err := h.fileService.Sync(sessionID, &req, dryRun)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// CreateSymlink creates a symlink to a path inside the working directory
+func (h *FileHandler) CreateSymlink(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	var req SymlinkRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	if req.Path == "" || req.Target == "" {
+		return errorMessage(c, http.StatusBadRequest, "Path and target are required")
+	}
+	
+	if err := h.fileService.CreateSymlink(sessionID, req.Path, req.Target); err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusCreated, map[string]string{
+		"message": "Symlink created successfully",
+		"path":    req.Path,
+		"target":  req.Target,
+	})
+}
+
+// ReadSymlink returns where a symlink points
+func (h *FileHandler) ReadSymlink(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	path := c.Param("*")
+	
+	info, err := h.fileService.ReadSymlink(sessionID, path)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusOK, info)
+}
+
+// writeOptions reads how a write replaces content from the backup, atomic
+// and lineEndings query parameters; writes are atomic unless atomic is
+// false
+func writeOptions(c echo.Context) (services.WriteOptions, error) {
+	backup, err := queryBool(c, "backup")
+	if err != nil {
+		return services.WriteOptions{}, err
+	}
+	atomic := true
+	if c.QueryParam("atomic") != "" {
+		if atomic, err = queryBool(c, "atomic"); err != nil {
+			return services.WriteOptions{}, err
+		}
+	}
+	lineEndings := c.QueryParam("lineEndings")
+	switch lineEndings {
+	case "", services.LineEndingsPreserve, services.LineEndingsLF, services.LineEndingsCRLF:
+	default:
+		return services.WriteOptions{}, fmt.Errorf("Invalid lineEndings %q, expected preserve, lf or crlf", lineEndings)
+	}
+	return services.WriteOptions{Backup: backup, InPlace: !atomic, LineEndings: lineEndings}, nil
+}
+
+// requestContent returns the bytes of a file request's content, decoded
+// from the encoding the request names
+func requestContent(c echo.Context, req *FileRequest) ([]byte, error) {
+	encoding, err := contentEncoding(c, req.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	return decodeContent(req.Content, encoding)
+}
+
+// DeleteFile moves a file to the session's trash, answering with its trash
+// entry, or deletes it with ?permanent=true
 func (h *FileHandler) DeleteFile(c echo.Context) error {
 	sessionID := c.Param("sessionId")
 	path := c.Param("*")
+	permanent, err := queryBool(c, "permanent")
+	if err != nil {
+		return errorMessage(c, http.StatusBadRequest, err.Error())
+	}
 	
-	if err := h.fileService.DeleteFile(sessionID, path); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+	dryRun, err := queryBool(c, "dryRun")
+	if err != nil {
+		return errorMessage(c, http.StatusBadRequest, err.Error())
+	}
+	if dryRun {
+		plan, err := h.fileService.PlanDeleteFile(sessionID, path, permanent)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, err)
+		}
+		return c.JSON(http.StatusOK, plan)
 	}
 	
-	return c.NoContent(http.StatusNoContent)
+	entry, err := h.fileService.DeleteFile(sessionID, path, permanent)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	if entry == nil {
+		return c.NoContent(http.StatusNoContent)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "File moved to the trash",
+		"trash":   entry,
+	})
 }
 
 // Utility specifically for LLM assistance
@@ -142,14 +434,12 @@ func (h *FileHandler) ExtractContent(c echo.Context) error {
 	
 	var req ExtractRequest
 	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid request body",
-		})
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
 	}
 	
 	result := make(map[string]string)
 	for _, path := range req.Files {
-		content, err := h.fileService.ReadFile(sessionID, path)
+		content, err := h.fileService.ReadTextFile(sessionID, path)
 		if err != nil {
 			result[path] = "ERROR: " + err.Error()
 		} else {
@@ -160,37 +450,77 @@ func (h *FileHandler) ExtractContent(c echo.Context) error {
 	return c.JSON(http.StatusOK, result)
 }
 
-// Search for content in files
+// Search for content in files. With ?stream=ndjson or ?stream=sse, matches
+// are streamed as they are found.
 func (h *FileHandler) SearchContent(c echo.Context) error {
 	sessionID := c.Param("sessionId")
 	
-	var req SearchRequest
+	var req services.SearchRequest
 	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid request body",
-		})
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
 	}
 	
 	if req.Path == "" {
 		req.Path = "."
 	}
+	switch stream := c.QueryParam("stream"); stream {
+	case "":
+	case streamNDJSON, streamSSE:
+		return h.streamSearch(c, sessionID, &req, stream)
+	default:
+		return errorMessage(c, http.StatusBadRequest, "stream must be ndjson or sse")
+	}
 	
-	results, err := h.fileService.SearchInFiles(sessionID, req.Path, req.Pattern, req.Recursive)
+	result, err := h.fileService.Search(c.Request().Context(), sessionID, &req)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusInternalServerError, err)
 	}
 	
+	results := result.ByFile()
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"pattern":       req.Pattern,
 		"path":          req.Path,
 		"recursive":     req.Recursive,
 		"matchedFiles":  len(results),
 		"results":       results,
+		"matches":       result.Matches,
+		"matchCount":    result.MatchCount,
+		"nameMatches":   result.NameMatches,
+		"truncated":     result.Truncated,
+		"nextCursor":    result.NextCursor,
+		"engine":        result.Engine,
 	})
 }
 
+// ReplaceContent replaces a pattern across files, or with ?dryRun=true
+// returns the diff each file would get
+func (h *FileHandler) ReplaceContent(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	var req services.ReplaceRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	if req.Path == "" {
+		req.Path = "."
+	}
+	
+	dryRun, err := queryBool(c, "dryRun")
+	if err != nil {
+		return errorMessage(c, http.StatusBadRequest, err.Error())
+	}
+	opts, err := writeOptions(c)
+	if err != nil {
+		return errorMessage(c, http.StatusBadRequest, err.Error())
+	}
+	
+	result, err := h.fileService.Replace(c.Request().Context(), sessionID, &req, opts, dryRun)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
 // New method to list files with metadata
 func (h *FileHandler) ListFilesWithMetadata(c echo.Context) error {
 	sessionID := c.Param("sessionId")
@@ -199,11 +529,14 @@ func (h *FileHandler) ListFilesWithMetadata(c echo.Context) error {
 		path = "."
 	}
 	
-	files, err := h.fileService.ListFilesWithMetadata(sessionID, path)
+	followSymlinks, err := queryBool(c, "followSymlinks")
+	if err != nil {
+		return errorMessage(c, http.StatusBadRequest, err.Error())
+	}
+	
+	files, err := h.fileService.ListFilesWithMetadata(sessionID, path, followSymlinks)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusInternalServerError, err)
 	}
 	
 	return c.JSON(http.StatusOK, map[string]interface{}{
@@ -219,23 +552,53 @@ func (h *FileHandler) GetFileMetadata(c echo.Context) error {
 	
 	metadata, err := h.fileService.GetFileMetadata(sessionID, path)
 	if err != nil {
-		return c.JSON(http.StatusNotFound, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusNotFound, err)
 	}
 	
 	return c.JSON(http.StatusOK, metadata)
 }
 
+// GetTextStats counts the lines, words and bytes of a text file, so it can
+// be sized up before it is read
+func (h *FileHandler) GetTextStats(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	path := c.Param("*")
+	
+	stats, err := h.fileService.TextStats(sessionID, path)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusOK, stats)
+}
+
+// QueryData returns the values a jq-style query selects from a JSON, YAML,
+// TOML or CSV file
+func (h *FileHandler) QueryData(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	var req services.QueryRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	if req.Path == "" || req.Query == "" {
+		return errorMessage(c, http.StatusBadRequest, "Path and query are required")
+	}
+	
+	result, err := h.fileService.Query(sessionID, &req)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
 // New method for batch reading files
 func (h *FileHandler) BatchReadFiles(c echo.Context) error {
 	sessionID := c.Param("sessionId")
 	
 	var req BatchReadRequest
 	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid request body",
-		})
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
 	}
 	
 	results := h.fileService.BatchReadFiles(sessionID, req.Files)
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"fileAPI/services"
+)
+
+type GitHandler struct {
+	gitService *services.GitService
+}
+
+func NewGitHandler(sm *services.SessionManager) *GitHandler {
+	return &GitHandler{
+		gitService: services.NewGitService(sm),
+	}
+}
+
+// DiffFile diffs a file in the working tree against the file at ?ref=, a
+// revision of its git repository, HEAD by default
+func (h *GitHandler) DiffFile(c echo.Context) error {
+	result, err := h.gitService.DiffFile(c.Param("sessionId"), c.Param("*"), c.QueryParam("ref"))
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusOK, result)
+}
+
+// GetStatus reports the status of the repository the working directory,
+// or ?path=, is in, with the changed files under it
+func (h *GitHandler) GetStatus(c echo.Context) error {
+	status, err := h.gitService.Status(c.Param("sessionId"), c.QueryParam("path"))
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusOK, status)
+}
+
+// Stage stages the changes of paths, returning the repository's status
+func (h *GitHandler) Stage(c echo.Context) error {
+	var req services.GitStageRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	status, err := h.gitService.Stage(c.Param("sessionId"), &req)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusOK, status)
+}
+
+// Unstage unstages the changes of paths, returning the repository's status
+func (h *GitHandler) Unstage(c echo.Context) error {
+	var req services.GitStageRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	status, err := h.gitService.Unstage(c.Param("sessionId"), &req)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusOK, status)
+}
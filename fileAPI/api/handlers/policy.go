@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"fileAPI/services"
+)
+
+type PolicyHandler struct {
+	policyService *services.PolicyService
+}
+
+func NewPolicyHandler(ps *services.PolicyService) *PolicyHandler {
+	return &PolicyHandler{
+		policyService: ps,
+	}
+}
+
+func (h *PolicyHandler) GetPolicy(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.policyService.GetPolicy())
+}
+
+func (h *PolicyHandler) SetPolicy(c echo.Context) error {
+	var req services.Policy
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	if err := h.policyService.SetPolicy(&req); err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	return c.JSON(http.StatusOK, h.policyService.GetPolicy())
+}
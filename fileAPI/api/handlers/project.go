@@ -31,9 +31,7 @@ func (h *ProjectHandler) GetProjectSummary(c echo.Context) error {
 	
 	summary, err := h.projectService.GetProjectSummary(sessionID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusInternalServerError, err)
 	}
 	
 	return c.JSON(http.StatusOK, summary)
@@ -53,9 +51,7 @@ func (h *ProjectHandler) ExtractCodeContext(c echo.Context) error {
 	
 	context, err := h.projectService.ExtractCodeContext(sessionID, maxFiles)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusInternalServerError, err)
 	}
 	
 	return c.JSON(http.StatusOK, context)
@@ -78,9 +74,7 @@ func (h *ProjectHandler) ExportFileStructure(c echo.Context) error {
 	
 	structure, err := h.fileService.ExportFileStructure(sessionID, path, depth)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusInternalServerError, err)
 	}
 	
 	return c.JSON(http.StatusOK, map[string]interface{}{
@@ -105,11 +99,14 @@ func (h *ProjectHandler) GetDirectoryTree(c echo.Context) error {
 		}
 	}
 	
-	tree, err := h.dirService.GetDirectoryTree(sessionID, path, depth)
+	followSymlinks, err := queryBool(c, "followSymlinks")
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return errorMessage(c, http.StatusBadRequest, err.Error())
+	}
+	
+	tree, err := h.dirService.GetDirectoryTree(sessionID, path, depth, followSymlinks)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
 	}
 	
 	return c.JSON(http.StatusOK, map[string]interface{}{
@@ -127,12 +124,20 @@ func (h *ProjectHandler) BatchCreateFiles(c echo.Context) error {
 	
 	var req BatchFileRequest
 	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid request body",
-		})
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
 	}
 	
-	results := h.fileService.BatchCreateFiles(sessionID, req.Files)
+	dryRun, err := queryBool(c, "dryRun")
+	if err != nil {
+		return errorMessage(c, http.StatusBadRequest, err.Error())
+	}
+	
+	var results []services.BatchResult
+	if dryRun {
+		results = h.fileService.PlanBatchCreate(sessionID, req.Files)
+	} else {
+		results = h.fileService.BatchCreateFiles(sessionID, req.Files)
+	}
 	
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"results": results,
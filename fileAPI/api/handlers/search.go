@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"github.com/labstack/echo/v4"
+	"fileAPI/services"
+)
+
+// Formats a search streams its matches in
+const (
+	streamNDJSON = "ndjson"
+	streamSSE    = "sse"
+)
+
+// streamSearch streams the events of a search as newline-delimited JSON or
+// Server-Sent Events, ending with its summary or the error that ended it.
+// Match event IDs are cursors, so an SSE client reconnecting with
+// Last-Event-ID continues after the last match it got. Errors found before
+// the first event are answered as with any other request.
+func (h *FileHandler) streamSearch(c echo.Context, sessionID string, req *services.SearchRequest, format string) error {
+	if value := c.Request().Header.Get("Last-Event-ID"); value != "" && format == streamSSE && req.Cursor == "" {
+		req.Cursor = value
+	}
+	
+	res := c.Response()
+	started := false
+	write := func(event services.SearchEvent) error {
+		if !started {
+			if format == streamSSE {
+				res.Header().Set(echo.HeaderContentType, "text/event-stream")
+				res.Header().Set(echo.HeaderCacheControl, "no-cache")
+				res.Header().Set(echo.HeaderConnection, "keep-alive")
+			} else {
+				res.Header().Set(echo.HeaderContentType, "application/x-ndjson")
+			}
+			res.WriteHeader(http.StatusOK)
+			started = true
+		}
+		if err := writeSearchEvent(res, event, format); err != nil {
+			return err
+		}
+		res.Flush()
+		return nil
+	}
+	
+	result, err := h.fileService.SearchStream(c.Request().Context(), sessionID, req, write)
+	if err != nil {
+		if !started {
+			return respondError(c, http.StatusInternalServerError, err)
+		}
+		if c.Request().Context().Err() == nil {
+			write(services.SearchEvent{Type: services.SearchEventError, Error: err.Error()})
+		}
+		return nil
+	}
+	return write(services.SearchEvent{
+		Type:       services.SearchEventEnd,
+		MatchCount: result.MatchCount,
+		Truncated:  result.Truncated,
+		NextCursor: result.NextCursor,
+		Engine:     result.Engine,
+	})
+}
+
+// writeSearchEvent writes an event of a search as a line of JSON, or in
+// text/event-stream format named after its type and identified by its
+// cursor
+func writeSearchEvent(res *echo.Response, event services.SearchEvent, format string) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if format == streamNDJSON {
+		_, err = fmt.Fprintf(res, "%s\n", data)
+		return err
+	}
+	if event.Cursor != "" {
+		if _, err := fmt.Fprintf(res, "id: %s\n", event.Cursor); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
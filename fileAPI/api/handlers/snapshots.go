@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+	"github.com/labstack/echo/v4"
+	"fileAPI/services"
+)
+
+type SnapshotHandler struct {
+	snapshotService *services.SnapshotService
+}
+
+func NewSnapshotHandler(sm *services.SessionManager) *SnapshotHandler {
+	return &SnapshotHandler{
+		snapshotService: services.NewSnapshotService(sm),
+	}
+}
+
+// CreateSnapshot takes a snapshot of a directory to compare it against
+// later
+func (h *SnapshotHandler) CreateSnapshot(c echo.Context) error {
+	var req services.SnapshotRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	if req.Path == "" {
+		req.Path = "."
+	}
+	
+	snapshot, err := h.snapshotService.Create(c.Param("sessionId"), &req)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusCreated, snapshot)
+}
+
+// ListSnapshots lists the directory snapshots of a session, newest first
+func (h *SnapshotHandler) ListSnapshots(c echo.Context) error {
+	listing, err := h.snapshotService.List(c.Param("sessionId"))
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusOK, listing)
+}
+
+// GetSnapshot returns a directory snapshot
+func (h *SnapshotHandler) GetSnapshot(c echo.Context) error {
+	snapshot, err := h.snapshotService.Get(c.Param("sessionId"), c.Param("snapshotId"))
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusOK, snapshot)
+}
+
+// DeleteSnapshot deletes a directory snapshot
+func (h *SnapshotHandler) DeleteSnapshot(c echo.Context) error {
+	if err := h.snapshotService.Delete(c.Param("sessionId"), c.Param("snapshotId")); err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.NoContent(http.StatusNoContent)
+}
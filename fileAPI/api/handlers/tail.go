@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"github.com/labstack/echo/v4"
+	"fileAPI/services"
+)
+
+// TailFile returns the last ?lines= lines of a file, 100 by default. With
+// ?follow=true it streams them as Server-Sent Events instead, followed by
+// each line appended to the file until the client goes away. Event IDs are
+// offsets in the file, so a client reconnecting with Last-Event-ID resumes
+// where it left off rather than at the last lines again.
+func (h *FileHandler) TailFile(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	path := c.Param("*")
+	
+	lines, err := queryInt(c, "lines", services.DefaultTailLines)
+	if err != nil {
+		return errorMessage(c, http.StatusBadRequest, err.Error())
+	}
+	follow, err := queryBool(c, "follow")
+	if err != nil {
+		return errorMessage(c, http.StatusBadRequest, err.Error())
+	}
+	
+	offset := int64(-1)
+	if value := c.Request().Header.Get("Last-Event-ID"); value != "" && follow {
+		if offset, err = strconv.ParseInt(value, 10, 64); err != nil || offset < 0 {
+			return errorMessage(c, http.StatusBadRequest, "Invalid Last-Event-ID header")
+		}
+		lines = 0
+	}
+	
+	result, err := h.fileService.TailFile(sessionID, path, lines)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	if !follow {
+		return c.JSON(http.StatusOK, result)
+	}
+	if offset < 0 {
+		offset = result.Size
+	}
+	
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	defer cancel()
+	events := make(chan services.TailEvent)
+	done := make(chan error, 1)
+	go func() {
+		done <- h.fileService.FollowFile(ctx, sessionID, path, offset, func(event services.TailEvent) error {
+			select {
+			case events <- event:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.WriteHeader(http.StatusOK)
+	
+	for _, line := range result.Lines {
+		writeTailEvent(res, services.TailEvent{Type: "line", Line: line, Offset: offset})
+	}
+	res.Flush()
+	
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+	
+	for {
+		select {
+		case event := <-events:
+			writeTailEvent(res, event)
+			res.Flush()
+		case err := <-done:
+			if err != nil && ctx.Err() == nil {
+				data, _ := json.Marshal(map[string]string{"error": err.Error()})
+				fmt.Fprintf(res, "event: error\ndata: %s\n\n", data)
+				res.Flush()
+			}
+			return nil
+		case <-heartbeat.C:
+			fmt.Fprint(res, ": keepalive\n\n")
+			res.Flush()
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// writeTailEvent writes an event of a followed file in text/event-stream
+// format, named after its type and identified by its offset
+func writeTailEvent(res *echo.Response, event services.TailEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", event.Offset, event.Type, data)
+}
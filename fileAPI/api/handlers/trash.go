@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net/http"
+	"github.com/labstack/echo/v4"
+	"fileAPI/services"
+)
+
+// RestoreRequest is the optional body of a trash restore
+type RestoreRequest struct {
+	Path string `json:"path,omitempty"` // Where to restore to, instead of where it was
+}
+
+type TrashHandler struct {
+	trashService *services.TrashService
+}
+
+func NewTrashHandler(sm *services.SessionManager) *TrashHandler {
+	return &TrashHandler{
+		trashService: services.NewTrashService(sm),
+	}
+}
+
+// ListTrash lists the deleted files and directories of a session, newest
+// first
+func (h *TrashHandler) ListTrash(c echo.Context) error {
+	listing, err := h.trashService.List(c.Param("sessionId"))
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusOK, listing)
+}
+
+// RestoreTrash moves a trash entry back to where it was, or to the path in
+// the body
+func (h *TrashHandler) RestoreTrash(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	// The body is optional; an empty request restores to the original path
+	var req RestoreRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	entry, err := h.trashService.Restore(sessionID, c.Param("trashId"), req.Path)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Restored from the trash",
+		"path":    entry.Path,
+		"trash":   entry,
+	})
+}
+
+// PurgeTrash permanently deletes a trash entry
+func (h *TrashHandler) PurgeTrash(c echo.Context) error {
+	if err := h.trashService.Purge(c.Param("sessionId"), c.Param("trashId")); err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.NoContent(http.StatusNoContent)
+}
+
+// EmptyTrash permanently deletes everything in a session's trash
+func (h *TrashHandler) EmptyTrash(c echo.Context) error {
+	purged, err := h.trashService.Empty(c.Param("sessionId"))
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Trash emptied",
+		"purged":  purged,
+	})
+}
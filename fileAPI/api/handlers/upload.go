@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"github.com/labstack/echo/v4"
+	"fileAPI/services"
+)
+
+// maxUploadSize bounds upload request bodies
+const maxUploadSize = 1 << 30
+
+// UploadFile writes files with the bytes of the request as they are, unlike
+// the JSON file routes, so images, archives and binaries survive. A
+// multipart/form-data body writes its file part to the path, or every file
+// part under its own name when the path is a directory: empty or ending in
+// a slash. Any other body is written to the path whole.
+func (h *FileHandler) UploadFile(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	path := c.Param("*")
+	req := c.Request()
+	req.Body = http.MaxBytesReader(c.Response(), req.Body, maxUploadSize)
+	
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get(echo.HeaderContentType))
+	if mediaType == echo.MIMEMultipartForm {
+		return h.uploadParts(c, sessionID, path)
+	}
+	
+	if path == "" || strings.HasSuffix(path, "/") {
+		return errorMessage(c, http.StatusBadRequest, "A raw upload needs a file path")
+	}
+	result, err := h.fileService.UploadFile(sessionID, path, req.Body)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"message": "File uploaded successfully",
+		"files":   []*services.UploadResult{result},
+	})
+}
+
+// uploadParts writes the file parts of a multipart upload, skipping its
+// other fields
+func (h *FileHandler) uploadParts(c echo.Context, sessionID string, path string) error {
+	reader, err := c.Request().MultipartReader()
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	toDir := path == "" || strings.HasSuffix(path, "/")
+	
+	results := []*services.UploadResult{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, err)
+		}
+		if part.FileName() == "" {
+			part.Close()
+			continue
+		}
+		
+		target := path
+		if toDir {
+			// Only the name of the file, never a path the client chose
+			name := filepath.Base(filepath.FromSlash(part.FileName()))
+			if name == "." || name == ".." || name == string(filepath.Separator) {
+				part.Close()
+				return errorMessage(c, http.StatusBadRequest, "Invalid file name "+part.FileName())
+			}
+			target = path + name
+		} else if len(results) > 0 {
+			part.Close()
+			return errorMessage(c, http.StatusBadRequest, "A multipart upload to a file path takes one file, only the first was written; upload to a directory path ending in / for more")
+		}
+		
+		result, err := h.fileService.UploadFile(sessionID, target, part)
+		part.Close()
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, err)
+		}
+		results = append(results, result)
+	}
+	
+	if len(results) == 0 {
+		return errorMessage(c, http.StatusBadRequest, "No file parts in the multipart upload")
+	}
+	message := "File uploaded successfully"
+	if len(results) > 1 {
+		message = "Files uploaded successfully"
+	}
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"message": message,
+		"files":   results,
+	})
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"net/http"
+	"github.com/labstack/echo/v4"
+	"fileAPI/services"
+)
+
+// VersionRestoreRequest is the body of a file version restore
+type VersionRestoreRequest struct {
+	Version int `json:"version"`
+}
+
+type VersionHandler struct {
+	versionService *services.VersionService
+}
+
+func NewVersionHandler(sm *services.SessionManager) *VersionHandler {
+	return &VersionHandler{
+		versionService: services.NewVersionService(sm),
+	}
+}
+
+// ListVersions lists the versions kept of a file, newest first
+func (h *VersionHandler) ListVersions(c echo.Context) error {
+	listing, err := h.versionService.List(c.Param("sessionId"), c.Param("*"))
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusOK, listing)
+}
+
+// DiffVersions compares two versions of a file, or a version with the file
+// as it is now when to is left out
+func (h *VersionHandler) DiffVersions(c echo.Context) error {
+	from, err := queryInt(c, "from", 0)
+	if err != nil {
+		return errorMessage(c, http.StatusBadRequest, err.Error())
+	}
+	to, err := queryInt(c, "to", 0)
+	if err != nil {
+		return errorMessage(c, http.StatusBadRequest, err.Error())
+	}
+	if from < 1 {
+		return errorMessage(c, http.StatusBadRequest, "from is required")
+	}
+	
+	diff, err := h.versionService.Diff(c.Param("sessionId"), c.Param("*"), from, to)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusOK, diff)
+}
+
+// RestoreVersion rolls a file back to a kept version, backing up the
+// content it replaces
+func (h *VersionHandler) RestoreVersion(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	path := c.Param("*")
+	
+	var req VersionRestoreRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	if req.Version < 1 {
+		return errorMessage(c, http.StatusBadRequest, "version is required")
+	}
+	
+	restored, backup, err := h.versionService.Restore(sessionID, path, req.Version)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	response := map[string]interface{}{
+		"message":  "File restored",
+		"path":     path,
+		"restored": restored,
+	}
+	if backup != nil {
+		response["backup"] = backup
+	}
+	return c.JSON(http.StatusOK, response)
+}
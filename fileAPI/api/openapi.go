@@ -0,0 +1,411 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"fileAPI/api/handlers"
+	"fileAPI/services"
+	"github.com/labstack/echo/v4"
+)
+
+// requestBodies are the structs routes bind their JSON request bodies to
+var requestBodies = map[string]interface{}{
+	"POST /sessions":                                   services.SessionOptions{},
+	"PATCH /sessions/:sessionId":                       services.SessionUpdate{},
+	"PUT /sessions/:sessionId/cwd":                     handlers.SessionRequest{},
+	"POST /sessions/:sessionId/files/*":                handlers.FileRequest{},
+	"PUT /sessions/:sessionId/files/*":                 handlers.FileRequest{},
+	"POST /sessions/:sessionId/edit/*":                 services.EditRequest{},
+	"POST /sessions/:sessionId/move":                   handlers.MoveRequest{},
+	"POST /sessions/:sessionId/copy":                   services.CopyRequest{},
+	"POST /sessions/:sessionId/sync":                   services.SyncRequest{},
+	"POST /sessions/:sessionId/symlinks":               handlers.SymlinkRequest{},
+	"POST /sessions/:sessionId/archive":                services.ArchiveRequest{},
+	"POST /sessions/:sessionId/archive/extract":        services.ExtractArchiveRequest{},
+	"POST /sessions/:sessionId/fetch":                  services.FetchRequest{},
+	"POST /sessions/:sessionId/trash/:trashId/restore": handlers.RestoreRequest{},
+	"POST /sessions/:sessionId/versions/*":             handlers.VersionRestoreRequest{},
+	"POST /sessions/:sessionId/snapshots":              services.SnapshotRequest{},
+	"POST /sessions/:sessionId/diff":                   services.DiffRequest{},
+	"POST /sessions/:sessionId/diff-dirs":              services.DirDiffRequest{},
+	"POST /sessions/:sessionId/git/stage":              services.GitStageRequest{},
+	"POST /sessions/:sessionId/git/unstage":            services.GitStageRequest{},
+	"POST /sessions/:sessionId/patch":                  services.PatchRequest{},
+	"POST /sessions/:sessionId/patch/preview":          services.PatchRequest{},
+	"POST /sessions/:sessionId/merge":                  services.MergeRequest{},
+	"POST /sessions/:sessionId/project/batch-create":   handlers.BatchFilesRequest{},
+	"POST /sessions/:sessionId/extract":                handlers.BatchReadRequest{},
+	"POST /sessions/:sessionId/search":                 services.SearchRequest{},
+	"POST /sessions/:sessionId/replace":                services.ReplaceRequest{},
+	"POST /sessions/:sessionId/batch-read":             handlers.BatchReadRequest{},
+	"POST /sessions/:sessionId/query":                  services.QueryRequest{},
+	"PUT /policy":                                      services.Policy{},
+}
+
+// OpenAPI serves an OpenAPI 3 description of the routes registered on e,
+// built on first use from the routes and the structs they bind
+func OpenAPI(e *echo.Echo, title string, version string) echo.HandlerFunc {
+	var once sync.Once
+	var doc map[string]interface{}
+	var spec []byte
+	return func(c echo.Context) error {
+		once.Do(func() {
+			doc = buildOpenAPI(e.Routes(), title, version)
+			spec, _ = json.MarshalIndent(doc, "", "  ")
+		})
+		// Behind a proxy or in the combined osai binary the routes are
+		// served under a prefix
+		if prefix := c.Request().Header.Get("X-Forwarded-Prefix"); prefix != "" {
+			prefixed := make(map[string]interface{}, len(doc)+1)
+			for key, value := range doc {
+				prefixed[key] = value
+			}
+			prefixed["servers"] = []interface{}{map[string]interface{}{"url": prefix + "/" + APIVersion}}
+			return c.JSONPretty(http.StatusOK, prefixed, "  ")
+		}
+		return c.Blob(http.StatusOK, echo.MIMEApplicationJSONCharsetUTF8, spec)
+	}
+}
+
+// SwaggerUI serves a page that browses the spec at openapi.json, next to it
+func SwaggerUI(c echo.Context) error {
+	return c.HTML(http.StatusOK, swaggerPage)
+}
+
+const swaggerPage = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>API documentation</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>SwaggerUIBundle({url: "openapi.json", dom_id: "#swagger-ui"});</script>
+</body>
+</html>
+`
+
+// routeParam matches the :name path parameters of echo routes
+var routeParam = regexp.MustCompile(`:(\w+)`)
+
+// openAPIBuilder collects the schemas of named structs as operations
+// refer to them
+type openAPIBuilder struct {
+	schemas map[string]interface{}
+	types   map[reflect.Type]string
+}
+
+func newOpenAPIBuilder() *openAPIBuilder {
+	return &openAPIBuilder{
+		schemas: map[string]interface{}{
+			"Error": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"error":     map[string]interface{}{"type": "string"},
+					"code":      map[string]interface{}{"type": "string"},
+					"requestId": map[string]interface{}{"type": "string"},
+				},
+				"required": []string{"error", "code"},
+			},
+		},
+		types: make(map[reflect.Type]string),
+	}
+}
+
+// apiOperation describes one route
+type apiOperation struct {
+	ID      string
+	Method  string
+	Path    string // In OpenAPI form, such as /sessions/{sessionId}
+	Summary string
+	Tag     string
+	Params  []string               // Path parameters
+	Body    map[string]interface{} // Schema of the request body, if any
+}
+
+// operations describes routes sorted by path and method
+func (b *openAPIBuilder) operations(routes []*echo.Route) []apiOperation {
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+
+	var operations []apiOperation
+	operationIDs := make(map[string]int)
+	for _, route := range routes {
+		path, params := openAPIPath(route.Path)
+		tag, name := handlerName(route.Name)
+		operation := apiOperation{
+			ID:      lowerFirst(name),
+			Method:  route.Method,
+			Path:    path,
+			Summary: sentence(name),
+			Tag:     tag,
+			Params:  params,
+		}
+		// A handler serving several routes gets numbered operations
+		if operationIDs[operation.ID]++; operationIDs[operation.ID] > 1 {
+			operation.ID += strconv.Itoa(operationIDs[operation.ID])
+		}
+		if body, ok := requestBodies[route.Method+" "+route.Path]; ok {
+			operation.Body = b.schema(reflect.TypeOf(body))
+		}
+		operations = append(operations, operation)
+	}
+	return operations
+}
+
+func buildOpenAPI(routes []*echo.Route, title string, version string) map[string]interface{} {
+	b := newOpenAPIBuilder()
+	paths := make(map[string]interface{})
+	for _, op := range b.operations(routes) {
+		item, ok := paths[op.Path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[op.Path] = item
+		}
+
+		operation := map[string]interface{}{
+			"operationId": op.ID,
+			"summary":     op.Summary,
+			"tags":        []string{op.Tag},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{"description": "Success"},
+				"default": map[string]interface{}{
+					"description": "Error",
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{
+							"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"},
+						},
+					},
+				},
+			},
+		}
+		if len(op.Params) > 0 {
+			var parameters []interface{}
+			for _, param := range op.Params {
+				parameters = append(parameters, map[string]interface{}{
+					"name":     param,
+					"in":       "path",
+					"required": true,
+					"schema":   map[string]interface{}{"type": "string"},
+				})
+			}
+			operation["parameters"] = parameters
+		}
+		if op.Body != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": op.Body,
+					},
+				},
+			}
+		}
+		item[strings.ToLower(op.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   title,
+			"version": version,
+		},
+		// Paths are given unversioned, as routes are registered
+		"servers": []interface{}{map[string]interface{}{"url": "/" + APIVersion}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": b.schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer"},
+				"apiKey": map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+		// Keys are only required when OSAI_API_KEYS is set
+		"security": []interface{}{
+			map[string]interface{}{},
+			map[string]interface{}{"bearer": []string{}},
+			map[string]interface{}{"apiKey": []string{}},
+		},
+	}
+}
+
+// openAPIPath converts an echo route path to OpenAPI form and returns its
+// parameters; a trailing wildcard becomes the path parameter
+func openAPIPath(path string) (string, []string) {
+	var params []string
+	for _, match := range routeParam.FindAllStringSubmatch(path, -1) {
+		params = append(params, match[1])
+	}
+	path = routeParam.ReplaceAllString(path, "{$1}")
+	if strings.HasSuffix(path, "*") {
+		path = strings.TrimSuffix(path, "*") + "{path}"
+		params = append(params, "path")
+	}
+	return path, params
+}
+
+// handlerName extracts the handler type, without its Handler suffix, and
+// method from a route name such as
+// "fileAPI/api/handlers.(*FileHandler).GetFile-fm". Plain
+// functions and the closures they return are tagged Server.
+func handlerName(routeName string) (string, string) {
+	name := strings.TrimSuffix(routeName, "-fm")
+	name = name[strings.LastIndex(name, "/")+1:]
+	parts := strings.Split(name, ".")
+	for len(parts) > 2 && strings.HasPrefix(parts[len(parts)-1], "func") {
+		parts = parts[:len(parts)-1]
+	}
+	method := parts[len(parts)-1]
+	if len(parts) == 3 && strings.HasPrefix(parts[1], "(") {
+		return strings.TrimSuffix(strings.Trim(parts[1], "(*)"), "Handler"), method
+	}
+	return "Server", method
+}
+
+// sentence turns a method name such as GetFile into "Get file"
+func sentence(name string) string {
+	var words []string
+	start := 0
+	for i, r := range name {
+		if i > 0 && unicode.IsUpper(r) && !unicode.IsUpper(rune(name[i-1])) {
+			words = append(words, name[start:i])
+			start = i
+		}
+	}
+	words = append(words, name[start:])
+	for i := 1; i < len(words); i++ {
+		if strings.ToUpper(words[i]) != words[i] {
+			words[i] = strings.ToLower(words[i])
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	rawJSONType   = reflect.TypeOf(json.RawMessage{})
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// schema returns the JSON schema of a type as encoding/json writes it.
+// Named structs are added to the components and referenced.
+func (b *openAPIBuilder) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == rawJSONType:
+		return map[string]interface{}{}
+	case t.Implements(marshalerType) || reflect.PointerTo(t).Implements(marshalerType):
+		// Written in a custom format
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		name, ok := b.types[t]
+		if !ok {
+			name = t.Name()
+			if _, taken := b.schemas[name]; taken {
+				// Same name in another package
+				pkg := pkgName(t)
+				name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+			}
+			b.types[t] = name
+			b.schemas[name] = map[string]interface{}{} // Placeholder for recursive types
+			b.schemas[name] = b.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+// structSchema describes the JSON fields of a struct, including those of
+// embedded structs. Requests may leave any field out, so none is required.
+func (b *openAPIBuilder) structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	b.addFields(t, properties)
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+}
+
+func (b *openAPIBuilder) addFields(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				b.addFields(embedded, properties)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = b.schema(field.Type)
+	}
+}
+
+// pkgName returns the last element of a type's package path
+func pkgName(t reflect.Type) string {
+	path := t.PkgPath()
+	return path[strings.LastIndex(path, "/")+1:]
+}
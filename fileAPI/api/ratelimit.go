@@ -0,0 +1,51 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"fileAPI/api/handlers"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"golang.org/x/time/rate"
+)
+
+// RateLimit allows each session requestsPerMinute requests to each endpoint,
+// with bursts of up to a minute's worth. Requests outside a session are
+// counted per API key, or per client address when authentication is
+// disabled. Limited requests get 429 with a Retry-After header.
+func RateLimit(requestsPerMinute int) echo.MiddlewareFunc {
+	// A token is returned every 60/requestsPerMinute seconds
+	retryAfter := strconv.Itoa(int(math.Ceil(60 / float64(requestsPerMinute))))
+
+	return middleware.RateLimiterWithConfig(middleware.RateLimiterConfig{
+		Store: middleware.NewRateLimiterMemoryStoreWithConfig(middleware.RateLimiterMemoryStoreConfig{
+			Rate:      rate.Limit(float64(requestsPerMinute) / 60),
+			Burst:     requestsPerMinute,
+			ExpiresIn: 3 * time.Minute,
+		}),
+		IdentifierExtractor: func(c echo.Context) (string, error) {
+			return rateLimitCaller(c) + " " + c.Request().Method + " " + c.Path(), nil
+		},
+		DenyHandler: func(c echo.Context, identifier string, err error) error {
+			c.Response().Header().Set("Retry-After", retryAfter)
+			return handlers.ErrorJSON(c, http.StatusTooManyRequests, handlers.CodeRateLimited,
+				fmt.Sprintf("rate limit of %d requests per minute exceeded", requestsPerMinute))
+		},
+	})
+}
+
+// rateLimitCaller identifies who a request is counted against: its session,
+// else its API key, else its client address
+func rateLimitCaller(c echo.Context) string {
+	if sessionID := c.Param("sessionId"); sessionID != "" {
+		return "session:" + sessionID
+	}
+	if principal := handlers.PrincipalFromContext(c); principal != nil {
+		return "key:" + principal.Name
+	}
+	return "ip:" + c.RealIP()
+}
@@ -3,22 +3,34 @@ package api
 import (
 	"github.com/labstack/echo/v4"
 	"fileAPI/api/handlers"
+	"fileAPI/config"
 	"fileAPI/services"
 )
 
-func SetupRoutes(e *echo.Echo, sm *services.SessionManager) {
+func SetupRoutes(e *echo.Echo, sm *services.SessionManager, policy *services.PolicyService, cfg *config.Config) {
 	// Create handlers
 	sessionHandler := handlers.NewSessionHandler(sm)
 	fileHandler := handlers.NewFileHandler(sm)
 	dirHandler := handlers.NewDirectoryHandler(sm)
 	diffHandler := handlers.NewDiffHandler(sm)
 	projectHandler := handlers.NewProjectHandler(sm)
+	fetchHandler := handlers.NewFetchHandler(sm)
+	trashHandler := handlers.NewTrashHandler(sm)
+	versionHandler := handlers.NewVersionHandler(sm)
+	snapshotHandler := handlers.NewSnapshotHandler(sm)
+	gitHandler := handlers.NewGitHandler(sm)
+	policyHandler := handlers.NewPolicyHandler(policy)
+	auditHandler := handlers.NewAuditHandler(sm.AuditService())
+	configHandler := handlers.NewConfigHandler(cfg)
 	
 	// Session routes
 	e.POST("/sessions", sessionHandler.CreateSession)
 	e.GET("/sessions/:sessionId", sessionHandler.GetSession)
+	e.PATCH("/sessions/:sessionId", sessionHandler.UpdateSession)
+	e.POST("/sessions/:sessionId/touch", sessionHandler.TouchSession)
 	e.DELETE("/sessions/:sessionId", sessionHandler.DeleteSession)
 	e.PUT("/sessions/:sessionId/cwd", sessionHandler.SetWorkingDirectory)
+	e.GET("/sessions/:sessionId/activity", sessionHandler.GetActivity)
 	e.GET("/sessions", sessionHandler.ListSessions) // New endpoint for listing all sessions
 	
 	// File routes
@@ -27,8 +39,25 @@ func SetupRoutes(e *echo.Echo, sm *services.SessionManager) {
 	e.POST("/sessions/:sessionId/files/*", fileHandler.CreateFile)
 	e.PUT("/sessions/:sessionId/files/*", fileHandler.UpdateFile)
 	e.DELETE("/sessions/:sessionId/files/*", fileHandler.DeleteFile)
+	e.POST("/sessions/:sessionId/upload/*", fileHandler.UploadFile) // Binary content, raw or multipart
+	e.GET("/sessions/:sessionId/raw/*", fileHandler.DownloadFile) // Binary content, streamed with Range support
+	e.POST("/sessions/:sessionId/edit/*", fileHandler.EditFile) // Line-based edits, returning a diff
+	e.POST("/sessions/:sessionId/move", fileHandler.Move) // Files and directories
+	e.POST("/sessions/:sessionId/copy", fileHandler.Copy) // Files and directories, filtered by globs
+	e.POST("/sessions/:sessionId/sync", fileHandler.Sync) // Mirror a directory into another
+	e.POST("/sessions/:sessionId/symlinks", fileHandler.CreateSymlink)
+	e.GET("/sessions/:sessionId/symlinks/*", fileHandler.ReadSymlink)
+	e.POST("/sessions/:sessionId/archive", fileHandler.CreateArchive) // zip or tar.gz
+	e.POST("/sessions/:sessionId/archive/extract", fileHandler.ExtractArchive) // Guarded against entries leading outside the destination
+	e.GET("/sessions/:sessionId/tail/*", fileHandler.TailFile) // Last lines, followed as Server-Sent Events
+	e.POST("/sessions/:sessionId/fetch", fetchHandler.Fetch) // Download an HTTP(S) URL in the background
+	e.GET("/sessions/:sessionId/fetch", fetchHandler.ListFetches)
+	e.GET("/sessions/:sessionId/fetch/:fetchId", fetchHandler.GetFetch)
+	e.DELETE("/sessions/:sessionId/fetch/:fetchId", fetchHandler.CancelFetch)
+	e.GET("/sessions/:sessionId/fetch/:fetchId/events", fetchHandler.StreamFetchEvents) // Progress as Server-Sent Events
 	e.GET("/sessions/:sessionId/files-metadata", fileHandler.ListFilesWithMetadata) // New endpoint for metadata
 	e.GET("/sessions/:sessionId/file-metadata/*", fileHandler.GetFileMetadata) // New endpoint for single file metadata
+	e.GET("/sessions/:sessionId/stats/*", fileHandler.GetTextStats) // Lines, words and bytes of a text file
 	
 	// Directory routes
 	e.GET("/sessions/:sessionId/directories", dirHandler.ListDirectories)
@@ -37,9 +66,35 @@ func SetupRoutes(e *echo.Echo, sm *services.SessionManager) {
 	e.GET("/sessions/:sessionId/directory-tree", dirHandler.GetDirectoryTree) // New endpoint for directory tree
 	e.GET("/sessions/:sessionId/directory-size/*", dirHandler.GetDirectorySize) // New endpoint for directory size
 	
+	// Trash routes, holding what file and directory deletions removed
+	e.GET("/sessions/:sessionId/trash", trashHandler.ListTrash)
+	e.DELETE("/sessions/:sessionId/trash", trashHandler.EmptyTrash)
+	e.POST("/sessions/:sessionId/trash/:trashId/restore", trashHandler.RestoreTrash)
+	e.DELETE("/sessions/:sessionId/trash/:trashId", trashHandler.PurgeTrash)
+	
+	// Version routes, holding the content file writes left and replaced
+	e.GET("/sessions/:sessionId/versions/*", versionHandler.ListVersions)
+	e.POST("/sessions/:sessionId/versions/*", versionHandler.RestoreVersion) // Roll back
+	e.GET("/sessions/:sessionId/version-diff/*", versionHandler.DiffVersions)
+	
+	// Directory snapshot routes, recording directories to compare them against
+	e.POST("/sessions/:sessionId/snapshots", snapshotHandler.CreateSnapshot)
+	e.GET("/sessions/:sessionId/snapshots", snapshotHandler.ListSnapshots)
+	e.GET("/sessions/:sessionId/snapshots/:snapshotId", snapshotHandler.GetSnapshot)
+	e.DELETE("/sessions/:sessionId/snapshots/:snapshotId", snapshotHandler.DeleteSnapshot)
+	
 	// Diff and patch routes
 	e.POST("/sessions/:sessionId/diff", diffHandler.GenerateDiff)
+	e.POST("/sessions/:sessionId/diff-dirs", diffHandler.DiffDirectories) // Added, removed and modified files
 	e.POST("/sessions/:sessionId/patch", diffHandler.ApplyPatch)
+	e.POST("/sessions/:sessionId/patch/preview", diffHandler.PreviewPatch) // Applied in memory only
+	e.POST("/sessions/:sessionId/merge", diffHandler.Merge) // Three-way merge with conflict markers
+	
+	// Git routes
+	e.GET("/sessions/:sessionId/git/status", gitHandler.GetStatus)
+	e.POST("/sessions/:sessionId/git/stage", gitHandler.Stage)
+	e.POST("/sessions/:sessionId/git/unstage", gitHandler.Unstage)
+	e.GET("/sessions/:sessionId/git/diff/*", gitHandler.DiffFile) // Against HEAD or ?ref=
 	
 	// Project routes (new)
 	e.GET("/sessions/:sessionId/project", projectHandler.GetProjectSummary)
@@ -50,5 +105,25 @@ func SetupRoutes(e *echo.Echo, sm *services.SessionManager) {
 	// Utility endpoints for LLMs
 	e.POST("/sessions/:sessionId/extract", fileHandler.ExtractContent)
 	e.POST("/sessions/:sessionId/search", fileHandler.SearchContent)
+	e.POST("/sessions/:sessionId/replace", fileHandler.ReplaceContent)
 	e.POST("/sessions/:sessionId/batch-read", fileHandler.BatchReadFiles) // New endpoint for reading multiple files
+	e.POST("/sessions/:sessionId/query", fileHandler.QueryData) // jq-style queries of JSON, YAML, TOML and CSV files
+	
+	// Access policy routes, always restricted to admin keys
+	e.GET("/policy", policyHandler.GetPolicy)
+	e.PUT("/policy", policyHandler.SetPolicy)
+	
+	// Audit log routes, admin only by default
+	e.GET("/audit", auditHandler.QueryAudit)
+	e.GET("/events", auditHandler.StreamEvents)
+	
+	// Effective configuration
+	e.GET("/config", configHandler.GetConfig)
+	
+	// API description, a browser for it and LLM tool definitions, open
+	// without an API key
+	e.GET("/openapi.json", OpenAPI(e, "File API", "1.0.0"))
+	e.GET("/docs", SwaggerUI)
+	e.GET("/tools/schema", ToolSchemas(e))
+	e.GET("/version", Version("fileAPI"))
 }
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// APIVersion is the version of the routes SetupRoutes registers. They are
+// served under its prefix, /v1, and at their unversioned paths for clients
+// written before the API was versioned. A later version with breaking
+// changes would register its routes under its own prefix, such as /v2,
+// which Versioning leaves alone.
+const APIVersion = "v1"
+
+// apiVersions are the versions of the API this server serves
+var apiVersions = []string{APIVersion}
+
+// HeaderAPIVersion names the version of the API that answered a request
+const HeaderAPIVersion = "X-API-Version"
+
+// BuildVersion is the version of the server, set at build time with
+// -ldflags "-X fileAPI/api.BuildVersion=1.2.3". Without it the version
+// of the main module is reported.
+var BuildVersion string
+
+// Versioning serves the routes of APIVersion under its prefix by removing
+// the prefix before routing, so routes, access policies and rate limits
+// keep naming unversioned paths. Requests to the unversioned paths are
+// still served but marked deprecated, with a Link to the versioned path.
+// It must be added with e.Pre so it runs before routing.
+func Versioning() echo.MiddlewareFunc {
+	prefix := "/" + APIVersion
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			path := req.URL.Path
+			switch {
+			case path == prefix || strings.HasPrefix(path, prefix+"/"):
+				req.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(path, prefix), "/")
+				if req.URL.RawPath != "" {
+					req.URL.RawPath = "/" + strings.TrimPrefix(strings.TrimPrefix(req.URL.RawPath, prefix), "/")
+				}
+				c.Response().Header().Set(HeaderAPIVersion, APIVersion)
+			case isVersionPath(path):
+				// Routes of another version match as registered
+			default:
+				c.Response().Header().Set(HeaderAPIVersion, APIVersion)
+				// Routes describing the API belong to no version
+				if !publicRoutes[req.Method+" "+path] {
+					successor := req.Header.Get("X-Forwarded-Prefix") + prefix + path
+					c.Response().Header().Set("Deprecation", "true")
+					c.Response().Header().Set("Link", "<"+successor+`>; rel="successor-version"`)
+				}
+			}
+			return next(c)
+		}
+	}
+}
+
+// isVersionPath reports whether path starts with a version prefix such as
+// /v2
+func isVersionPath(path string) bool {
+	segment := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+	if len(segment) < 2 || segment[0] != 'v' {
+		return false
+	}
+	for _, r := range segment[1:] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// VersionInfo describes the running server
+type VersionInfo struct {
+	Service     string   `json:"service"`
+	Version     string   `json:"version"`
+	APIVersion  string   `json:"apiVersion"`  // Version served at unversioned paths
+	APIVersions []string `json:"apiVersions"` // Versions served under their prefix
+	Commit      string   `json:"commit,omitempty"`
+	CommitTime  string   `json:"commitTime,omitempty"`
+	Modified    bool     `json:"modified,omitempty"` // Built from a tree with uncommitted changes
+	GoVersion   string   `json:"goVersion"`
+	Platform    string   `json:"platform"`
+}
+
+// Version serves the build information of the server and the versions of
+// the API it serves
+func Version(service string) echo.HandlerFunc {
+	info := VersionInfo{
+		Service:     service,
+		Version:     BuildVersion,
+		APIVersion:  APIVersion,
+		APIVersions: apiVersions,
+		GoVersion:   runtime.Version(),
+		Platform:    runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" {
+			info.Version = build.Main.Version
+		}
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				info.Commit = setting.Value
+			case "vcs.time":
+				info.CommitTime = setting.Value
+			case "vcs.modified":
+				info.Modified = setting.Value == "true"
+			}
+		}
+	}
+	return func(c echo.Context) error {
+		return c.JSON(http.StatusOK, info)
+	}
+}
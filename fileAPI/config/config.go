@@ -0,0 +1,264 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"fileAPI/services"
+)
+
+// Config holds the settings of the file API. Values come from the
+// defaults, then the config file, then environment variables. Secrets are
+// left out of its JSON, so it is served as is by GET /config.
+type Config struct {
+	Port int `json:"port"`
+	// Port of the gRPC API; 0 disables it
+	GRPCPort    int      `json:"grpcPort"`
+	CORSOrigins []string `json:"corsOrigins"`
+	// Directories sessions may work in; empty allows any directory
+	AllowedDirs []string `json:"allowedDirs"`
+	// Requests per minute per session and endpoint; 0 disables the limit
+	RateLimit     int      `json:"rateLimit"`
+	SessionExpiry Duration `json:"sessionExpiry"`
+	// How long deleted files stay in the trash
+	TrashRetention Duration `json:"trashRetention"`
+	// Record a version of every file write, not only requested backups,
+	// keeping at most maxFileVersions of maxVersionsSize bytes per file
+	VersionHistory  bool `json:"versionHistory"`
+	MaxFileVersions int  `json:"maxFileVersions"`
+	MaxVersionsSize int  `json:"maxVersionsSize"`
+	// Search with the rg binary when it is installed
+	SearchRipgrep bool `json:"searchRipgrep"`
+	// Redis server replicas share sessions through, such as
+	// redis://:password@host:6379/0; empty keeps sessions in memory. Not
+	// served, as it may hold a password.
+	RedisURL string `json:"-"`
+	// Where sessions are stored, "memory" or "redis"
+	SessionStore string `json:"sessionStore"`
+	// URLs session lifecycle events are posted to, and the key their
+	// payloads are signed with. Not served, as they may hold credentials.
+	SessionWebhooks []string `json:"-"`
+	WebhookSecret   string   `json:"-"`
+	// Config file the values were read from, if any
+	File string `json:"file,omitempty"`
+}
+
+// Duration is a time.Duration written as a string such as "24h" in the
+// config file and API
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return errors.New("durations must be strings such as \"30m\"")
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Default returns the settings used when nothing is configured
+func Default() *Config {
+	return &Config{
+		Port:            8080,
+		CORSOrigins:     []string{"*"},
+		SessionExpiry:   Duration(services.DefaultSessionExpiry),
+		TrashRetention:  Duration(services.DefaultTrashRetention),
+		VersionHistory:  true,
+		MaxFileVersions: services.DefaultMaxFileVersions,
+		MaxVersionsSize: services.DefaultMaxVersionsSize,
+	}
+}
+
+// FilePath returns the config file shared with terminalAPI: OSAI_CONFIG_FILE,
+// or ~/.osai/config.json
+func FilePath() string {
+	if path := os.Getenv("OSAI_CONFIG_FILE"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".osai", "config.json")
+}
+
+// Load reads the configuration. The config file is optional unless named by
+// OSAI_CONFIG_FILE. Its top-level corsOrigins, allowedDirs, rateLimit,
+// redisUrl, sessionWebhooks and webhookSecret apply to both services, and its
+// "files" object holds the settings of this one.
+func Load() (*Config, error) {
+	cfg := Default()
+
+	path := FilePath()
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := cfg.parseFile(data); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+		cfg.File = path
+	case !errors.Is(err, os.ErrNotExist) || os.Getenv("OSAI_CONFIG_FILE") != "":
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	cfg.SessionStore = "memory"
+	if cfg.RedisURL != "" {
+		cfg.SessionStore = "redis"
+	}
+	return cfg, nil
+}
+
+// parseFile applies the shared settings of a config file, then this
+// service's section
+func (cfg *Config) parseFile(data []byte) error {
+	var file struct {
+		CORSOrigins []string        `json:"corsOrigins"`
+		AllowedDirs []string        `json:"allowedDirs"`
+		RateLimit   int             `json:"rateLimit"`
+		RedisURL    string          `json:"redisUrl"`
+		Webhooks    []string        `json:"sessionWebhooks"`
+		Secret      string          `json:"webhookSecret"`
+		Files       json.RawMessage `json:"files"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	if file.CORSOrigins != nil {
+		cfg.CORSOrigins = file.CORSOrigins
+	}
+	if file.AllowedDirs != nil {
+		cfg.AllowedDirs = file.AllowedDirs
+	}
+	if file.RateLimit != 0 {
+		cfg.RateLimit = file.RateLimit
+	}
+	if file.RedisURL != "" {
+		cfg.RedisURL = file.RedisURL
+	}
+	if file.Webhooks != nil {
+		cfg.SessionWebhooks = file.Webhooks
+	}
+	if file.Secret != "" {
+		cfg.WebhookSecret = file.Secret
+	}
+	if file.Files != nil {
+		return json.Unmarshal(file.Files, cfg)
+	}
+	return nil
+}
+
+// applyEnv overrides settings from environment variables
+func (cfg *Config) applyEnv() error {
+	ints := map[string]*int{
+		"OSAI_FILES_PORT":              &cfg.Port,
+		"OSAI_FILES_GRPC_PORT":         &cfg.GRPCPort,
+		"OSAI_RATE_LIMIT":              &cfg.RateLimit,
+		"OSAI_FILES_MAX_FILE_VERSIONS": &cfg.MaxFileVersions,
+		"OSAI_FILES_MAX_VERSIONS_SIZE": &cfg.MaxVersionsSize,
+	}
+	for name, field := range ints {
+		if value := os.Getenv(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid %s: %s", name, value)
+			}
+			*field = n
+		}
+	}
+
+	durations := map[string]*Duration{
+		"OSAI_FILES_SESSION_EXPIRY":  &cfg.SessionExpiry,
+		"OSAI_FILES_TRASH_RETENTION": &cfg.TrashRetention,
+	}
+	for name, field := range durations {
+		if value := os.Getenv(name); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("invalid %s: %s", name, value)
+			}
+			*field = Duration(d)
+		}
+	}
+	if value := os.Getenv("OSAI_FILES_VERSION_HISTORY"); value != "" {
+		history, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid OSAI_FILES_VERSION_HISTORY: %s", value)
+		}
+		cfg.VersionHistory = history
+	}
+	if value := os.Getenv("OSAI_FILES_SEARCH_RIPGREP"); value != "" {
+		ripgrep, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid OSAI_FILES_SEARCH_RIPGREP: %s", value)
+		}
+		cfg.SearchRipgrep = ripgrep
+	}
+	if value := os.Getenv("OSAI_CORS_ORIGINS"); value != "" {
+		cfg.CORSOrigins = splitList(value)
+	}
+	if value := os.Getenv("OSAI_ALLOWED_DIRS"); value != "" {
+		cfg.AllowedDirs = filepath.SplitList(value)
+	}
+	if value := os.Getenv("OSAI_REDIS_URL"); value != "" {
+		cfg.RedisURL = value
+	}
+	if value := os.Getenv("OSAI_SESSION_WEBHOOKS"); value != "" {
+		cfg.SessionWebhooks = splitList(value)
+	}
+	if value := os.Getenv("OSAI_WEBHOOK_SECRET"); value != "" {
+		cfg.WebhookSecret = value
+	}
+	return nil
+}
+
+// validate rejects settings the service cannot run with
+func (cfg *Config) validate() error {
+	switch {
+	case cfg.Port <= 0 || cfg.Port > 65535:
+		return fmt.Errorf("invalid port: %d", cfg.Port)
+	case cfg.GRPCPort < 0 || cfg.GRPCPort > 65535 || cfg.GRPCPort == cfg.Port:
+		return fmt.Errorf("invalid grpcPort: %d", cfg.GRPCPort)
+	case cfg.SessionExpiry <= 0:
+		return errors.New("sessionExpiry must be positive")
+	case cfg.TrashRetention <= 0:
+		return errors.New("trashRetention must be positive")
+	case cfg.MaxFileVersions <= 0:
+		return errors.New("maxFileVersions must be positive")
+	case cfg.MaxVersionsSize <= 0:
+		return errors.New("maxVersionsSize must be positive")
+	case cfg.RateLimit < 0:
+		return errors.New("rateLimit must not be negative")
+	}
+	return nil
+}
+
+// splitList splits a comma-separated list, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,112 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"fileAPI/rpc/filespb"
+	"fileAPI/services"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type fileServer struct {
+	filespb.UnimplementedFileServiceServer
+	fileService *services.FileService
+}
+
+func (s *fileServer) ListFiles(ctx context.Context, req *filespb.ListFilesRequest) (*filespb.ListFilesResponse, error) {
+	path := req.Path
+	if path == "" {
+		path = "."
+	}
+	files, err := s.fileService.ListFilesWithMetadata(req.SessionId, path, false)
+	if err != nil {
+		return nil, statusError(errorCode(err, codes.Internal), err)
+	}
+	resp := &filespb.ListFilesResponse{}
+	for i := range files {
+		resp.Files = append(resp.Files, toFileMetadata(&files[i]))
+	}
+	return resp, nil
+}
+
+func (s *fileServer) GetFileMetadata(ctx context.Context, req *filespb.GetFileMetadataRequest) (*filespb.FileMetadata, error) {
+	meta, err := s.fileService.GetFileMetadata(req.SessionId, req.Path)
+	if err != nil {
+		return nil, statusError(errorCode(err, codes.NotFound), err)
+	}
+	return toFileMetadata(meta), nil
+}
+
+func (s *fileServer) ReadFile(ctx context.Context, req *filespb.ReadFileRequest) (*filespb.ReadFileResponse, error) {
+	content, err := s.fileService.ReadFile(req.SessionId, req.Path)
+	if err != nil {
+		return nil, statusError(errorCode(err, codes.NotFound), err)
+	}
+	return &filespb.ReadFileResponse{Content: content}, nil
+}
+
+func (s *fileServer) CreateFile(ctx context.Context, req *filespb.WriteFileRequest) (*filespb.FileMetadata, error) {
+	if err := s.fileService.CreateFile(req.SessionId, req.Path, req.Content, services.WriteOptions{}); err != nil {
+		return nil, statusError(errorCode(err, codes.Internal), err)
+	}
+	return s.GetFileMetadata(ctx, &filespb.GetFileMetadataRequest{SessionId: req.SessionId, Path: req.Path})
+}
+
+func (s *fileServer) UpdateFile(ctx context.Context, req *filespb.WriteFileRequest) (*filespb.FileMetadata, error) {
+	if _, err := s.fileService.UpdateFile(req.SessionId, req.Path, req.Content, services.WriteOptions{}); err != nil {
+		return nil, statusError(errorCode(err, codes.Internal), err)
+	}
+	return s.GetFileMetadata(ctx, &filespb.GetFileMetadataRequest{SessionId: req.SessionId, Path: req.Path})
+}
+
+func (s *fileServer) DeleteFile(ctx context.Context, req *filespb.DeleteFileRequest) (*filespb.DeleteFileResponse, error) {
+	if _, err := s.fileService.DeleteFile(req.SessionId, req.Path, false); err != nil {
+		return nil, statusError(errorCode(err, codes.Internal), err)
+	}
+	return &filespb.DeleteFileResponse{}, nil
+}
+
+func (s *fileServer) WatchFile(req *filespb.WatchFileRequest, stream filespb.FileService_WatchFileServer) error {
+	interval := time.Duration(req.IntervalMs) * time.Millisecond
+	err := s.fileService.WatchFile(stream.Context(), req.SessionId, req.Path, interval, func(event services.FileEvent) error {
+		return stream.Send(&filespb.FileEvent{
+			Type:     event.Type,
+			Path:     event.Path,
+			Metadata: toFileMetadata(event.Metadata),
+			Time:     timestamppb.New(event.Time),
+		})
+	})
+	if err != nil && stream.Context().Err() == nil {
+		return statusError(errorCode(err, codes.NotFound), err)
+	}
+	return nil
+}
+
+// errorCode returns the code for a failed file operation: PermissionDenied
+// when the path leads outside the session working directory or the allowed
+// directories, code otherwise
+func errorCode(err error, code codes.Code) codes.Code {
+	var escape *services.PathEscapeError
+	if errors.As(err, &escape) || errors.Is(err, services.ErrDirectoryNotAllowed) {
+		return codes.PermissionDenied
+	}
+	return code
+}
+
+func toFileMetadata(meta *services.FileMetadata) *filespb.FileMetadata {
+	if meta == nil {
+		return nil
+	}
+	return &filespb.FileMetadata{
+		Name:        meta.Name,
+		Path:        meta.Path,
+		Size:        meta.Size,
+		ModTime:     timestamppb.New(meta.ModTime),
+		IsDir:       meta.IsDir,
+		ContentType: meta.ContentType,
+		Permissions: meta.Permissions,
+	}
+}
@@ -0,0 +1,220 @@
+// Package rpc serves the gRPC API, which mirrors the session and file
+// routes of the REST API with less overhead per call and streams file
+// changes instead of requiring polling.
+package rpc
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"fileAPI/api"
+	"fileAPI/api/handlers"
+	"fileAPI/rpc/filespb"
+	"fileAPI/services"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// routes maps each RPC to the REST route it mirrors, whose scope in the
+// access policy the RPC requires
+var routes = map[string]string{
+	filespb.SessionService_CreateSession_FullMethodName:       "POST /sessions",
+	filespb.SessionService_GetSession_FullMethodName:          "GET /sessions/:sessionId",
+	filespb.SessionService_UpdateSession_FullMethodName:       "PATCH /sessions/:sessionId",
+	filespb.SessionService_TouchSession_FullMethodName:        "POST /sessions/:sessionId/touch",
+	filespb.SessionService_ListSessions_FullMethodName:        "GET /sessions",
+	filespb.SessionService_DeleteSession_FullMethodName:       "DELETE /sessions/:sessionId",
+	filespb.SessionService_SetWorkingDirectory_FullMethodName: "PUT /sessions/:sessionId/cwd",
+	filespb.FileService_ListFiles_FullMethodName:              "GET /sessions/:sessionId/files-metadata",
+	filespb.FileService_GetFileMetadata_FullMethodName:        "GET /sessions/:sessionId/file-metadata/*",
+	filespb.FileService_ReadFile_FullMethodName:               "GET /sessions/:sessionId/files/*",
+	filespb.FileService_CreateFile_FullMethodName:             "POST /sessions/:sessionId/files/*",
+	filespb.FileService_UpdateFile_FullMethodName:             "PUT /sessions/:sessionId/files/*",
+	filespb.FileService_DeleteFile_FullMethodName:             "DELETE /sessions/:sessionId/files/*",
+	filespb.FileService_WatchFile_FullMethodName:              "GET /sessions/:sessionId/files/*",
+}
+
+// NewServer returns a gRPC server for the session and file services. Calls are authenticated with the API keys of the REST API, sent
+// as authorization (bearer token) or x-api-key metadata, and no keys means
+// authentication is disabled. Sessions are restricted to the key that
+// created them as over REST.
+func NewServer(sm *services.SessionManager, keys []*api.APIKey, policy *services.PolicyService) *grpc.Server {
+	a := &authenticator{keys: keys, policy: policy, sessionManager: sm}
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(logUnary, a.unary),
+		grpc.ChainStreamInterceptor(logStream, a.stream),
+	)
+	filespb.RegisterSessionServiceServer(server, &sessionServer{sessionManager: sm})
+	filespb.RegisterFileServiceServer(server, &fileServer{fileService: services.NewFileService(sm)})
+	return server
+}
+
+// principalKey is where the authenticated caller is stored in the context
+type principalKey struct{}
+
+// principalFromContext returns the authenticated caller of an RPC, or nil
+// when authentication is disabled
+func principalFromContext(ctx context.Context) *handlers.Principal {
+	principal, _ := ctx.Value(principalKey{}).(*handlers.Principal)
+	return principal
+}
+
+// sessionOwner returns the owner to record on sessions the caller creates
+func sessionOwner(ctx context.Context) string {
+	if principal := principalFromContext(ctx); principal != nil {
+		return principal.Name
+	}
+	return ""
+}
+
+// sessionOwnerFilter returns the owner whose sessions the caller may list,
+// or "" for all of them
+func sessionOwnerFilter(ctx context.Context) string {
+	principal := principalFromContext(ctx)
+	if principal == nil || principal.Admin {
+		return ""
+	}
+	return principal.Name
+}
+
+// authenticator checks the API key, scope and session ownership of calls
+type authenticator struct {
+	keys           []*api.APIKey
+	policy         *services.PolicyService
+	sessionManager *services.SessionManager
+}
+
+func (a *authenticator) unary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := a.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.checkOwnership(ctx, req); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *authenticator) stream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx, authenticator: a})
+}
+
+// authenticate returns the context of a call with its caller, or an error
+// if the key is missing, unknown or lacks the scope the call requires
+func (a *authenticator) authenticate(ctx context.Context, fullMethod string) (context.Context, error) {
+	if len(a.keys) == 0 {
+		return ctx, nil
+	}
+
+	value := ""
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("authorization"); len(values) > 0 {
+		value = strings.TrimPrefix(values[0], "Bearer ")
+	} else if values := md.Get("x-api-key"); len(values) > 0 {
+		value = values[0]
+	}
+	if value == "" {
+		return nil, status.Error(codes.Unauthenticated, "API key required")
+	}
+	key := api.MatchAPIKey(a.keys, value)
+	if key == nil {
+		return nil, status.Error(codes.Unauthenticated, "Invalid API key")
+	}
+
+	method, path, _ := strings.Cut(routes[fullMethod], " ")
+	scope := a.policy.RequiredScope(method, path, services.ScopeWrite)
+	if !a.policy.HasScope(key.Grants, scope) {
+		return nil, status.Errorf(codes.PermissionDenied, "API key %s lacks the %s scope", key.Name, scope)
+	}
+
+	return context.WithValue(ctx, principalKey{}, &handlers.Principal{
+		Name:  key.Name,
+		Admin: a.policy.HasScope(key.Grants, services.ScopeAdmin),
+	}), nil
+}
+
+// checkOwnership reports sessions of other keys as not found, so their IDs
+// cannot be probed; admin keys can use every session
+func (a *authenticator) checkOwnership(ctx context.Context, req any) error {
+	principal := principalFromContext(ctx)
+	if principal == nil || principal.Admin {
+		return nil
+	}
+	request, ok := req.(interface{ GetSessionId() string })
+	if !ok || request.GetSessionId() == "" {
+		return nil
+	}
+	if owner, exists := a.sessionManager.SessionOwner(request.GetSessionId()); exists && owner != principal.Name {
+		return status.Error(codes.NotFound, "session not found or inactive")
+	}
+	return nil
+}
+
+// authenticatedStream carries the caller of a streaming call and checks
+// the session ownership of the request once it is received
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx           context.Context
+	authenticator *authenticator
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *authenticatedStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return s.authenticator.checkOwnership(s.ctx, m)
+}
+
+// logUnary logs every call as a structured line, as RequestLogger does for
+// REST requests
+func logUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	logCall(ctx, info.FullMethod, req, start, err)
+	return resp, err
+}
+
+func logStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	logCall(ss.Context(), info.FullMethod, nil, start, err)
+	return err
+}
+
+func logCall(ctx context.Context, fullMethod string, req any, start time.Time, err error) {
+	code := status.Code(err)
+	attrs := []any{
+		"method", fullMethod,
+		"code", code.String(),
+		"latency", time.Since(start),
+	}
+	if request, ok := req.(interface{ GetSessionId() string }); ok && request.GetSessionId() != "" {
+		attrs = append(attrs, services.LogKeySession, request.GetSessionId())
+	}
+
+	level := slog.LevelInfo
+	if err != nil {
+		attrs = append(attrs, "error", status.Convert(err).Message())
+	}
+	if code == codes.Internal || code == codes.Unknown {
+		level = slog.LevelError
+	}
+	slog.Log(ctx, level, "rpc", attrs...)
+}
+
+// statusError returns a status error with the message of err
+func statusError(code codes.Code, err error) error {
+	return status.Error(code, err.Error())
+}
@@ -0,0 +1,161 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+
+	"fileAPI/rpc/filespb"
+	"fileAPI/services"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type sessionServer struct {
+	filespb.UnimplementedSessionServiceServer
+	sessionManager *services.SessionManager
+}
+
+func (s *sessionServer) CreateSession(ctx context.Context, req *filespb.CreateSessionRequest) (*filespb.Session, error) {
+	if req.NeverExpire {
+		if principal := principalFromContext(ctx); principal != nil && !principal.Admin {
+			return nil, status.Error(codes.PermissionDenied, "only admin keys may create sessions that never expire")
+		}
+	}
+	session, err := s.sessionManager.CreateSession(&services.SessionOptions{
+		Name:          req.Name,
+		Tags:          req.Tags,
+		Metadata:      req.GetMetadata().AsMap(),
+		ExpirySeconds: int(req.ExpirySeconds),
+		NeverExpire:   req.NeverExpire,
+		Owner:         sessionOwner(ctx),
+	})
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, services.ErrInvalidSessionLabels) || errors.Is(err, services.ErrInvalidExpiry) {
+			code = codes.InvalidArgument
+		}
+		return nil, statusError(code, err)
+	}
+	return toSession(session), nil
+}
+
+func (s *sessionServer) GetSession(ctx context.Context, req *filespb.GetSessionRequest) (*filespb.Session, error) {
+	// Reading a session does not keep it alive, as over REST
+	session, err := s.sessionManager.PeekSession(req.SessionId)
+	if err != nil {
+		return nil, statusError(codes.NotFound, err)
+	}
+	return toSession(session), nil
+}
+
+func (s *sessionServer) TouchSession(ctx context.Context, req *filespb.TouchSessionRequest) (*filespb.Session, error) {
+	session, err := s.sessionManager.TouchSession(req.SessionId)
+	if err != nil {
+		return nil, statusError(codes.NotFound, err)
+	}
+	return toSession(session), nil
+}
+
+func (s *sessionServer) UpdateSession(ctx context.Context, req *filespb.UpdateSessionRequest) (*filespb.Session, error) {
+	paths := req.GetUpdateMask().GetPaths()
+	if len(paths) == 0 {
+		// Without a mask, change the fields that are set
+		if req.Name != "" {
+			paths = append(paths, "name")
+		}
+		if len(req.Tags) > 0 {
+			paths = append(paths, "tags")
+		}
+		if req.Metadata != nil {
+			paths = append(paths, "metadata")
+		}
+	}
+
+	update := &services.SessionUpdate{}
+	for _, path := range paths {
+		switch path {
+		case "name":
+			update.Name = &req.Name
+		case "tags":
+			update.Tags = append([]string{}, req.Tags...)
+		case "metadata":
+			update.Metadata = req.GetMetadata().AsMap()
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "unknown field in update_mask: %s", path)
+		}
+	}
+
+	session, err := s.sessionManager.UpdateSession(req.SessionId, update)
+	if err != nil {
+		code := codes.NotFound
+		if errors.Is(err, services.ErrInvalidSessionLabels) {
+			code = codes.InvalidArgument
+		}
+		return nil, statusError(code, err)
+	}
+	return toSession(session), nil
+}
+
+func (s *sessionServer) ListSessions(ctx context.Context, req *filespb.ListSessionsRequest) (*filespb.ListSessionsResponse, error) {
+	resp := &filespb.ListSessionsResponse{}
+	sessions := s.sessionManager.GetAllSessions(sessionOwnerFilter(ctx))
+	if len(req.Tags) > 0 {
+		sessions = services.SessionsWithTags(sessions, req.Tags)
+	}
+	for _, session := range sessions {
+		resp.Sessions = append(resp.Sessions, toSession(session))
+	}
+	return resp, nil
+}
+
+func (s *sessionServer) DeleteSession(ctx context.Context, req *filespb.DeleteSessionRequest) (*filespb.DeleteSessionResponse, error) {
+	if err := s.sessionManager.DeleteSession(req.SessionId); err != nil {
+		return nil, statusError(codes.NotFound, err)
+	}
+	return &filespb.DeleteSessionResponse{}, nil
+}
+
+func (s *sessionServer) SetWorkingDirectory(ctx context.Context, req *filespb.SetWorkingDirectoryRequest) (*filespb.Session, error) {
+	if err := s.sessionManager.SetWorkingDirectory(req.SessionId, req.WorkingDirectory); err != nil {
+		return nil, statusError(errorCode(err, codes.InvalidArgument), err)
+	}
+	session, err := s.sessionManager.GetSession(req.SessionId)
+	if err != nil {
+		return nil, statusError(codes.NotFound, err)
+	}
+	return toSession(session), nil
+}
+
+func toSession(session *services.Session) *filespb.Session {
+	pbSession := &filespb.Session{
+		Id:            session.ID,
+		CreatedAt:     timestamppb.New(session.CreatedAt),
+		LastActive:    timestamppb.New(session.LastActive),
+		ExpirySeconds: int32(session.ExpirySeconds),
+		NeverExpire:   session.NeverExpire,
+		WorkingDir:    session.WorkingDir,
+		IsActive:      session.IsActive,
+		Owner:         session.Owner,
+		Name:          session.Name,
+		Tags:          session.Tags,
+		Metadata:      toStruct(session.Metadata),
+	}
+	if ttl, expires := session.TTL(); expires {
+		pbSession.ExpiresAt = timestamppb.New(session.ExpiresAt)
+		pbSession.TtlSeconds = proto.Int64(int64(ttl.Seconds()))
+	}
+	return pbSession
+}
+
+// toStruct converts session metadata, which comes from JSON and so always
+// converts
+func toStruct(metadata map[string]interface{}) *structpb.Struct {
+	if len(metadata) == 0 {
+		return nil
+	}
+	value, _ := structpb.NewStruct(metadata)
+	return value
+}
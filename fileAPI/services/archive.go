@@ -0,0 +1,317 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Archive formats
+const (
+	ArchiveZip   = "zip"
+	ArchiveTarGz = "tar.gz"
+)
+
+// ErrInvalidArchive is returned for archives that cannot be read, in a
+// format that is not supported, or that extract to more than
+// MaxExtractSize
+var ErrInvalidArchive = errors.New("invalid archive")
+
+// ArchiveRequest packs files and directories into an archive. Entries are
+// named by their path in the working directory. Include and Exclude are
+// glob patterns matched as in a copy, against those paths and names.
+type ArchiveRequest struct {
+	Paths       []string `json:"paths"`
+	Destination string   `json:"destination"`      // The archive
+	Format      string   `json:"format,omitempty"` // zip or tar.gz; by default from the destination's extension
+	Include     []string `json:"include,omitempty"`
+	Exclude     []string `json:"exclude,omitempty"`
+	Overwrite   bool     `json:"overwrite"` // Replace an existing archive
+}
+
+// ArchiveResult describes an archive written
+type ArchiveResult struct {
+	Path   string `json:"path"`
+	Format string `json:"format"`
+	Files  int    `json:"files"` // Files and symlinks, not counting directories
+	Size   int64  `json:"size"`  // Of the archive
+}
+
+// archiveFormat returns the format of an archive, as asked for or from the
+// extension of its name
+func archiveFormat(format string, name string) (string, error) {
+	if format == "" {
+		switch lower := strings.ToLower(name); {
+		case strings.HasSuffix(lower, ".zip"):
+			format = ArchiveZip
+		case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+			format = ArchiveTarGz
+		default:
+			return "", fmt.Errorf("%w: cannot tell the format of %s, set format to zip or tar.gz", ErrInvalidArchive, name)
+		}
+	}
+	if format != ArchiveZip && format != ArchiveTarGz {
+		return "", fmt.Errorf("%w: unsupported format %q, use zip or tar.gz", ErrInvalidArchive, format)
+	}
+	return format, nil
+}
+
+// CreateArchive packs files and directories, with everything in them, into
+// a zip or tar.gz archive, creating its parent directories. Symlinks are
+// stored as links. The archive is written to a temporary file renamed into
+// place, so a failure leaves any existing archive as it was.
+func (fs *FileService) CreateArchive(sessionID string, req *ArchiveRequest) (result *ArchiveResult, err error) {
+	defer func() {
+		detail := strings.Join(req.Paths, ", ")
+		if result != nil {
+			detail += fmt.Sprintf(": %d files", result.Files)
+		}
+		fs.sessionManager.Audit(sessionID, "file.archive", req.Destination, detail, err)
+	}()
+
+	format, err := archiveFormat(req.Format, req.Destination)
+	if err != nil {
+		return nil, err
+	}
+	for _, pattern := range append(append([]string{}, req.Include...), req.Exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%w: invalid pattern %q", ErrInvalidTarget, pattern)
+		}
+	}
+	root, err := fs.GetFilePath(sessionID, ".")
+	if err != nil {
+		return nil, err
+	}
+	destinationPath, err := fs.GetFilePath(sessionID, req.Destination)
+	if err != nil {
+		return nil, err
+	}
+	sources := make([]string, len(req.Paths))
+	for i, path := range req.Paths {
+		if sources[i], err = fs.GetFilePath(sessionID, path); err != nil {
+			return nil, err
+		}
+		if _, err := os.Lstat(sources[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	if existing, err := os.Lstat(destinationPath); err == nil {
+		if existing.IsDir() {
+			return nil, fmt.Errorf("%w: %s is a directory", ErrInvalidTarget, req.Destination)
+		}
+		if !req.Overwrite {
+			return nil, fmt.Errorf("%s: %w", req.Destination, os.ErrExist)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(destinationPath), 0755); err != nil {
+		return nil, err
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(destinationPath), "."+filepath.Base(destinationPath)+".archive-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(out.Name())
+
+	writer := newArchiveWriter(format, out)
+	files := 0
+	added := make(map[string]bool)
+	for _, source := range sources {
+		err := filepath.WalkDir(source, func(path string, entry os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			// The archive being written is never part of itself
+			if path == destinationPath || path == out.Name() {
+				return nil
+			}
+			name, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			if name == "." {
+				return nil
+			}
+			if matchesAny(req.Exclude, name) {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if added[name] {
+				return nil
+			}
+			// With Include, directories are stored only for the files in them
+			if entry.IsDir() && len(req.Include) > 0 {
+				return nil
+			}
+			if !entry.IsDir() && len(req.Include) > 0 && !matchesAny(req.Include, name) {
+				return nil
+			}
+			added[name] = true
+
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			if err := writer.add(filepath.ToSlash(name), path, info); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			if !info.IsDir() {
+				files++
+			}
+			return nil
+		})
+		if err != nil {
+			writer.Close()
+			out.Close()
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		out.Close()
+		return nil, err
+	}
+	if err := out.Chmod(0644); err != nil {
+		out.Close()
+		return nil, err
+	}
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(out.Name(), destinationPath); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(destinationPath)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityFile,
+		Target:   req.Destination,
+		Message:  fmt.Sprintf("Archived %d files into %s", files, req.Destination),
+	})
+	return &ArchiveResult{
+		Path:   req.Destination,
+		Format: format,
+		Files:  files,
+		Size:   info.Size(),
+	}, nil
+}
+
+// archiveWriter adds files, directories and symlinks to an archive
+type archiveWriter interface {
+	add(name string, path string, info os.FileInfo) error
+	Close() error
+}
+
+func newArchiveWriter(format string, w io.Writer) archiveWriter {
+	if format == ArchiveZip {
+		return &zipWriter{zip.NewWriter(w)}
+	}
+	gz := gzip.NewWriter(w)
+	return &tarGzWriter{gz: gz, tar: tar.NewWriter(gz)}
+}
+
+type zipWriter struct {
+	zip *zip.Writer
+}
+
+// add stores a symlink with its target as content, as zip and unzip do
+func (z *zipWriter) add(name string, path string, info os.FileInfo) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	switch {
+	case info.IsDir():
+		header.Name += "/"
+		_, err := z.zip.CreateHeader(header)
+		return err
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		w, err := z.zip.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, target)
+		return err
+	case info.Mode().IsRegular():
+		header.Method = zip.Deflate
+		w, err := z.zip.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		return copyFrom(w, path)
+	}
+	return fmt.Errorf("%w: not a regular file, directory or symlink", ErrInvalidTarget)
+}
+
+func (z *zipWriter) Close() error {
+	return z.zip.Close()
+}
+
+type tarGzWriter struct {
+	gz  *gzip.Writer
+	tar *tar.Writer
+}
+
+func (t *tarGzWriter) add(name string, path string, info os.FileInfo) error {
+	target := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if target, err = os.Readlink(path); err != nil {
+			return err
+		}
+	} else if !info.IsDir() && !info.Mode().IsRegular() {
+		return fmt.Errorf("%w: not a regular file, directory or symlink", ErrInvalidTarget)
+	}
+	header, err := tar.FileInfoHeader(info, target)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	if err := t.tar.WriteHeader(header); err != nil {
+		return err
+	}
+	if info.Mode().IsRegular() {
+		return copyFrom(t.tar, path)
+	}
+	return nil
+}
+
+func (t *tarGzWriter) Close() error {
+	if err := t.tar.Close(); err != nil {
+		t.gz.Close()
+		return err
+	}
+	return t.gz.Close()
+}
+
+// copyFrom copies the content of the file at path to w
+func copyFrom(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err
+}
@@ -0,0 +1,339 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MaxExtractSize bounds the bytes an extraction writes, so that a small
+// archive cannot fill the disk
+const MaxExtractSize = 4 << 30
+
+// maxLinkTarget bounds the target of a symlink stored in a zip archive
+const maxLinkTarget = 4096
+
+// ExtractArchiveRequest unpacks an archive into a directory
+type ExtractArchiveRequest struct {
+	Source      string `json:"source"`                // The archive
+	Destination string `json:"destination,omitempty"` // Created if missing; the working directory by default
+	Format      string `json:"format,omitempty"`      // zip or tar.gz; by default from the source's extension
+	Overwrite   bool   `json:"overwrite"`             // Replace existing files
+}
+
+// ExtractArchiveResult lists the outcome of extracting each file and
+// symlink. Entries that would land outside the destination, files that
+// exist without Overwrite and entries of other types fail without stopping
+// the rest.
+type ExtractArchiveResult struct {
+	Source      string        `json:"source"`
+	Destination string        `json:"destination"`
+	Extracted   int           `json:"extracted"`
+	Failed      int           `json:"failed"`
+	Results     []BatchResult `json:"results"` // Path is the entry's name, Result where it was written
+}
+
+// archiveEntry is an entry read from an archive
+type archiveEntry struct {
+	Name    string
+	Mode    os.FileMode // ModeIrregular for types that are not extracted
+	ModTime time.Time
+	Link    string // Target of a symlink
+}
+
+// ExtractArchive unpacks a zip or tar.gz archive into a directory. Entry
+// names that are absolute or lead outside the destination through ".." or
+// through symlinks, whether already there or extracted earlier, are
+// refused, as are symlinks pointing outside it. Setuid and similar bits
+// are dropped. An archive that would write more than MaxExtractSize stops
+// the extraction, leaving what was extracted until then.
+func (fs *FileService) ExtractArchive(sessionID string, req *ExtractArchiveRequest) (result *ExtractArchiveResult, err error) {
+	destination := req.Destination
+	if destination == "" {
+		destination = "."
+	}
+	defer func() {
+		detail := "to " + destination
+		if result != nil {
+			detail += fmt.Sprintf(": %d extracted, %d failed", result.Extracted, result.Failed)
+		}
+		fs.sessionManager.Audit(sessionID, "file.extract", req.Source, detail, err)
+	}()
+
+	format, err := archiveFormat(req.Format, req.Source)
+	if err != nil {
+		return nil, err
+	}
+	sourcePath, err := fs.GetFilePath(sessionID, req.Source)
+	if err != nil {
+		return nil, err
+	}
+	destinationPath, err := fs.GetFilePath(sessionID, destination)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s: %w", req.Source, ErrIsDirectory)
+	}
+	if err := os.MkdirAll(destinationPath, 0755); err != nil {
+		return nil, err
+	}
+
+	result = &ExtractArchiveResult{
+		Source:      req.Source,
+		Destination: destination,
+		Results:     []BatchResult{},
+	}
+	record := func(name string, target string, err error) {
+		entry := BatchResult{Path: name, Success: err == nil}
+		if err != nil {
+			entry.Error = err.Error()
+			result.Failed++
+		} else {
+			entry.Result = filepath.Join(destination, target)
+			result.Extracted++
+		}
+		result.Results = append(result.Results, entry)
+	}
+
+	// Directories get their permissions and times once their content is in
+	type directory struct {
+		path    string
+		mode    os.FileMode
+		modTime time.Time
+	}
+	var directories []directory
+	remaining := int64(MaxExtractSize)
+
+	err = readArchive(format, sourcePath, func(entry *archiveEntry, content io.Reader) error {
+		name, ok := entryPath(entry.Name)
+		if !ok {
+			record(entry.Name, "", fmt.Errorf("%w: %s leads outside the destination", ErrInvalidTarget, entry.Name))
+			return nil
+		}
+		if name == "." {
+			return nil
+		}
+		target, err := confinePath(destinationPath, name)
+		if err != nil {
+			record(entry.Name, "", fmt.Errorf("%w: %s leads outside the destination", ErrInvalidTarget, entry.Name))
+			return nil
+		}
+
+		if entry.Mode.IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				record(entry.Name, "", err)
+				return nil
+			}
+			directories = append(directories, directory{target, entry.Mode.Perm(), entry.ModTime})
+			return nil
+		}
+		if !entry.Mode.IsRegular() && entry.Mode&os.ModeSymlink == 0 {
+			record(entry.Name, "", fmt.Errorf("%w: %s is not a regular file, directory or symlink", ErrInvalidTarget, entry.Name))
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			record(entry.Name, "", err)
+			return nil
+		}
+		if existing, err := os.Lstat(target); err == nil {
+			if existing.IsDir() {
+				record(entry.Name, "", fmt.Errorf("%w: %s is a directory", ErrInvalidTarget, name))
+				return nil
+			}
+			if !req.Overwrite {
+				record(entry.Name, "", fmt.Errorf("%s: %w", name, os.ErrExist))
+				return nil
+			}
+		}
+
+		if entry.Mode&os.ModeSymlink != 0 {
+			linked := filepath.Join(filepath.Dir(target), entry.Link)
+			if filepath.IsAbs(entry.Link) || !isWithin(destinationPath, linked) {
+				record(entry.Name, "", fmt.Errorf("%w: symlink %s points outside the destination", ErrInvalidTarget, entry.Name))
+				return nil
+			}
+			os.Remove(target)
+			if err := os.Symlink(entry.Link, target); err != nil {
+				record(entry.Name, "", err)
+				return nil
+			}
+			record(entry.Name, name, nil)
+			return nil
+		}
+
+		// Some zip tools store no permissions
+		perm := entry.Mode.Perm()
+		if perm == 0 {
+			perm = 0644
+		}
+		written, err := extractFile(target, content, perm, remaining)
+		remaining -= written
+		if remaining < 0 {
+			return fmt.Errorf("%w: extracts to more than %d bytes", ErrInvalidArchive, int64(MaxExtractSize))
+		}
+		if err == nil && !entry.ModTime.IsZero() {
+			err = os.Chtimes(target, entry.ModTime, entry.ModTime)
+		}
+		record(entry.Name, name, err)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(directories) - 1; i >= 0; i-- {
+		os.Chmod(directories[i].path, directories[i].mode|0700)
+		if !directories[i].modTime.IsZero() {
+			os.Chtimes(directories[i].path, directories[i].modTime, directories[i].modTime)
+		}
+	}
+
+	fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityDirectory,
+		Target:   destination,
+		Message:  fmt.Sprintf("Extracted %s into %s (%d extracted, %d failed)", req.Source, destination, result.Extracted, result.Failed),
+	})
+	return result, nil
+}
+
+// entryPath cleans the name of an archive entry into a relative path,
+// reporting false for absolute names and names leading up through ".."
+func entryPath(name string) (string, bool) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || filepath.IsAbs(name) {
+		return "", false
+	}
+	cleaned := path.Clean(name)
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", false
+	}
+	return filepath.FromSlash(cleaned), true
+}
+
+// extractFile writes content to path with perm, through a temporary file
+// renamed into place, reading at most limit bytes and one more to tell
+// whether there was more. It returns the bytes read.
+func extractFile(path string, content io.Reader, perm os.FileMode, limit int64) (int64, error) {
+	out, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".extract-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(out.Name())
+
+	written, err := io.Copy(out, io.LimitReader(content, limit+1))
+	if err != nil || written > limit {
+		out.Close()
+		return written, err
+	}
+	if err := out.Chmod(perm); err != nil {
+		out.Close()
+		return written, err
+	}
+	if err := out.Close(); err != nil {
+		return written, err
+	}
+	return written, os.Rename(out.Name(), path)
+}
+
+// readArchive calls extract with each entry of an archive and its content,
+// stopping at the first error extract returns
+func readArchive(format string, path string, extract func(*archiveEntry, io.Reader) error) error {
+	if format == ArchiveZip {
+		return readZip(path, extract)
+	}
+	return readTarGz(path, extract)
+}
+
+func readZip(path string, extract func(*archiveEntry, io.Reader) error) error {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	defer archive.Close()
+
+	for _, file := range archive.File {
+		entry := &archiveEntry{
+			Name:    file.Name,
+			Mode:    file.Mode(),
+			ModTime: file.Modified,
+		}
+		if entry.Mode&(os.ModeType&^(os.ModeDir|os.ModeSymlink)) != 0 {
+			entry.Mode = os.ModeIrregular
+		}
+		content, err := file.Open()
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidArchive, file.Name, err)
+		}
+		if entry.Mode&os.ModeSymlink != 0 {
+			link, err := io.ReadAll(io.LimitReader(content, maxLinkTarget))
+			if err != nil {
+				content.Close()
+				return fmt.Errorf("%w: %s: %v", ErrInvalidArchive, file.Name, err)
+			}
+			entry.Link = string(link)
+		}
+		err = extract(entry, content)
+		content.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readTarGz(path string, extract func(*archiveEntry, io.Reader) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	defer gz.Close()
+
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+
+		entry := &archiveEntry{
+			Name:    header.Name,
+			Mode:    os.FileMode(header.Mode).Perm(),
+			ModTime: header.ModTime,
+		}
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+		case tar.TypeDir:
+			entry.Mode |= os.ModeDir
+		case tar.TypeSymlink:
+			entry.Mode |= os.ModeSymlink
+			entry.Link = header.Linkname
+		case tar.TypeXGlobalHeader:
+			continue
+		default:
+			entry.Mode = os.ModeIrregular
+		}
+		if err := extract(entry, archive); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// WriteOptions are how a write replaces a file's content
+type WriteOptions struct {
+	// Keep the content being replaced as a version first
+	Backup bool
+	// Write into the file itself rather than through a temporary file
+	// renamed over it, for file systems that cannot rename over a file
+	InPlace bool
+	// Set the line endings of text content: preserve those of the file
+	// replaced, or make them all lf or crlf. Empty keeps them as sent.
+	LineEndings string
+}
+
+// writeFileContent replaces the content of a file on this host, atomically
+// unless inPlace
+func writeFileContent(path string, data []byte, perm os.FileMode, inPlace bool) error {
+	if inPlace {
+		return os.WriteFile(path, data, perm)
+	}
+	return writeFileAtomic(path, data, perm)
+}
+
+// writeFileAtomic replaces the content of a file through a temporary file
+// in its directory, synced to disk and renamed over it, so readers see the
+// old content or the new but never part of either, and a crash leaves one
+// of them. An existing file keeps its mode and, where the server may set
+// it, its owner; a symlink is written through to its target like
+// os.WriteFile does.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		target, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			// A dangling link is left for os.WriteFile to create its target
+			return os.WriteFile(path, data, perm)
+		}
+		path = target
+	}
+	existing, statErr := os.Stat(path)
+	if statErr == nil {
+		perm = existing.Mode().Perm()
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	done := false
+	defer func() {
+		if !done {
+			temp.Close()
+			os.Remove(temp.Name())
+		}
+	}()
+
+	if _, err := temp.Write(data); err != nil {
+		return err
+	}
+	if err := temp.Chmod(perm); err != nil {
+		return err
+	}
+	if statErr == nil {
+		if stat, ok := existing.Sys().(*syscall.Stat_t); ok {
+			// Only root, or the owner keeping its group, may do this
+			temp.Chown(int(stat.Uid), int(stat.Gid))
+		}
+	}
+	if err := temp.Sync(); err != nil {
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return err
+	}
+	done = true
+
+	// Make the rename itself durable; not every file system syncs
+	// directories, so failing to is not an error
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
@@ -1,27 +1,102 @@
 package services
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/sergi/go-diff/diffmatchpatch"
 )
 
+// ErrInvalidPatch is returned for patches that cannot be read
+var ErrInvalidPatch = errors.New("invalid patch")
+
+// Formats of patches: the text of diff-match-patch patches, as the diff
+// endpoint returns them, or a unified diff, as diff -u, git and language
+// models write them
+const (
+	PatchFormatDMP     = "dmp"
+	PatchFormatUnified = "unified"
+)
+
+// How a patch went: all of its hunks applied, some did, or none did and
+// nothing was written
+const (
+	PatchApplied = "applied"
+	PatchPartial = "partial"
+	PatchFailed  = "failed"
+)
+
+// What became of a hunk of a patch
+const (
+	HunkApplied  = "applied"
+	HunkRejected = "rejected"
+)
+
 type DiffService struct {
 	sessionManager *SessionManager
 	fileService    *FileService
 }
 
+// DiffRequest diffs two texts, each given as content or read from a path.
+// Mode is text, the default, or structural; Language is that of the texts
+// for a structural diff, which otherwise goes by the paths' extension.
 type DiffRequest struct {
 	OriginalPath string `json:"originalPath"`
 	ModifiedPath string `json:"modifiedPath"`
 	Original     string `json:"original,omitempty"`
 	Modified     string `json:"modified,omitempty"`
+	Mode         string `json:"mode,omitempty"`
+	Language     string `json:"language,omitempty"`
 }
 
+// PatchRequest applies patches to the original text, or with a file path
+// and a unified diff without original text, to the file. Fuzz and
+// IgnoreWhitespace are how far the lines of a unified diff's hunks may
+// differ from the text and still apply.
 type PatchRequest struct {
-	FilePath string `json:"filePath"`
-	Original string `json:"original"`
-	Patches  string `json:"patches"`
+	FilePath         string `json:"filePath"`
+	Original         string `json:"original"`
+	Patches          string `json:"patches"`
+	Format           string `json:"format,omitempty"` // dmp, the default, or unified
+	Fuzz             *int   `json:"fuzz,omitempty"`   // Lines of context a hunk may lose at each end, 2 by default
+	IgnoreWhitespace bool   `json:"ignoreWhitespace,omitempty"`
+}
+
+// PatchHunk is what became of a hunk, or a patch of a dmp patch text
+type PatchHunk struct {
+	Index      int    `json:"index"` // From 1, in the order of the patch
+	Header     string `json:"header"`
+	Status     string `json:"status"`               // applied or rejected
+	Line       int    `json:"line,omitempty"`       // Of the text where it applied, from 1
+	Offset     int    `json:"offset,omitempty"`     // Lines from where its header put it
+	Fuzz       int    `json:"fuzz,omitempty"`       // Lines of context left off to apply it
+	Whitespace bool   `json:"whitespace,omitempty"` // Whether it applied only ignoring whitespace
+	Reason     string `json:"reason,omitempty"`     // Why it was rejected
+}
+
+// PatchResult is the patched text and what became of each hunk. Status is
+// applied when all the hunks applied, partial when some did, and failed
+// when none did.
+type PatchResult struct {
+	Path     string      `json:"path"`
+	Result   string      `json:"result"`
+	Status   string      `json:"status"`
+	Applied  int         `json:"applied"`
+	Rejected int         `json:"rejected"`
+	Hunks    []PatchHunk `json:"hunks"`
+	Plan     *WritePlan  `json:"plan,omitempty"` // Of writing the result, for dry runs
+	original string      // The text patched
+}
+
+// PatchPreview is a patch applied in memory: its result, the diff from the
+// original text to it and the lines it adds and removes
+type PatchPreview struct {
+	PatchResult
+	Diff         string `json:"diff"`
+	LinesAdded   int    `json:"linesAdded"`
+	LinesRemoved int    `json:"linesRemoved"`
 }
 
 type DiffResponse struct {
@@ -40,7 +115,7 @@ func (ds *DiffService) GenerateDiff(sessionID string, req *DiffRequest) (*DiffRe
 	
 	// Get content either from files or directly from request
 	if req.OriginalPath != "" {
-		content, err := ds.fileService.ReadFile(sessionID, req.OriginalPath)
+		content, err := ds.fileService.ReadTextFile(sessionID, req.OriginalPath)
 		if err != nil {
 			return nil, err
 		}
@@ -50,7 +125,7 @@ func (ds *DiffService) GenerateDiff(sessionID string, req *DiffRequest) (*DiffRe
 	}
 	
 	if req.ModifiedPath != "" {
-		content, err := ds.fileService.ReadFile(sessionID, req.ModifiedPath)
+		content, err := ds.fileService.ReadTextFile(sessionID, req.ModifiedPath)
 		if err != nil {
 			return nil, err
 		}
@@ -64,46 +139,131 @@ func (ds *DiffService) GenerateDiff(sessionID string, req *DiffRequest) (*DiffRe
 	patches := dmp.PatchMake(originalContent, diffs)
 	patchesText := dmp.PatchToText(patches)
 	
-	fmt.Printf("[TERMINAL] Session %s: Generated diff between %s and %s\n", 
-		sessionID, req.OriginalPath, req.ModifiedPath)
+	ds.sessionManager.Audit(sessionID, "diff.generate", req.OriginalPath, "against "+req.ModifiedPath, nil)
 	
 	return &DiffResponse{
 		Patches: patchesText,
 	}, nil
 }
 
-func (ds *DiffService) ApplyPatch(sessionID string, req *PatchRequest) (string, error) {
-	dmp := diffmatchpatch.New()
-	patches, err := dmp.PatchFromText(req.Patches)
+// ApplyPatch applies patches to the original text, and writes the result
+// to the file at the request's path when it has one, as UpdateFile does.
+// A patch none of whose hunks applied leaves the file alone.
+func (ds *DiffService) ApplyPatch(sessionID string, req *PatchRequest, opts WriteOptions) (result *PatchResult, err error) {
+	var detail string
+	defer func() {
+		ds.sessionManager.Audit(sessionID, "patch.apply", req.FilePath, detail, err)
+	}()
+	
+	result, err = ds.patch(sessionID, req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	detail = fmt.Sprintf("%d of %d patches applied", result.Applied, len(result.Hunks))
 	
-	result, applied := dmp.PatchApply(patches, req.Original)
-	
-	// Check if all patches were applied
-	allApplied := true
-	for _, v := range applied {
-		if !v {
-			allApplied = false
-			break
+	// If a file path is provided, update the file
+	if req.FilePath != "" && result.Status != PatchFailed {
+		if _, err := ds.fileService.updateFile(sessionID, req.FilePath, []byte(result.Result), opts, "patch"); err != nil {
+			return nil, err
 		}
 	}
 	
-	if !allApplied {
-		fmt.Printf("[TERMINAL] Session %s: Warning - Not all patches were applied to %s\n", 
-			sessionID, req.FilePath)
-	}
+	return result, nil
+}
+
+// PreviewPatch applies a patch in memory as ApplyPatch would, without
+// writing its result or checking that it could be written
+func (ds *DiffService) PreviewPatch(sessionID string, req *PatchRequest) (preview *PatchPreview, err error) {
+	defer func() {
+		detail := ""
+		if preview != nil {
+			detail = fmt.Sprintf("%d of %d patches would apply", preview.Applied, len(preview.Hunks))
+		}
+		ds.sessionManager.Audit(sessionID, "patch.preview", req.FilePath, detail, err)
+	}()
 	
-	// If a file path is provided, update the file
+	result, err := ds.patch(sessionID, req)
+	if err != nil {
+		return nil, err
+	}
+	path := req.FilePath
+	if path == "" {
+		path = "text"
+	}
+	preview = &PatchPreview{PatchResult: *result, Diff: UnifiedDiff(path, result.original, result.Result)}
+	preview.LinesAdded, preview.LinesRemoved = diffStats(result.original, result.Result)
+	return preview, nil
+}
+
+// patch applies a request's patches to its original text, or to its file
+// for a unified diff without one; hunks that do not apply are left out of
+// the result
+func (ds *DiffService) patch(sessionID string, req *PatchRequest) (*PatchResult, error) {
+	// Refuse a target outside the working directory before patching anything
 	if req.FilePath != "" {
-		if err := ds.fileService.UpdateFile(sessionID, req.FilePath, []byte(result)); err != nil {
-			return "", err
+		if _, err := ds.fileService.GetFilePath(sessionID, req.FilePath); err != nil {
+			return nil, err
 		}
-		fmt.Printf("[TERMINAL] Session %s: Applied patches to file %s\n", sessionID, req.FilePath)
-	} else {
-		fmt.Printf("[TERMINAL] Session %s: Generated patched content\n", sessionID)
+	}
+	fuzz := DefaultPatchFuzz
+	if req.Fuzz != nil {
+		fuzz = *req.Fuzz
+	}
+	if fuzz < 0 || fuzz > MaxPatchFuzz {
+		return nil, fmt.Errorf("%w: fuzz must be between 0 and %d", ErrInvalidPatch, MaxPatchFuzz)
+	}
+	
+	result := &PatchResult{Path: req.FilePath, original: req.Original}
+	switch req.Format {
+	case "", PatchFormatDMP:
+		dmp := diffmatchpatch.New()
+		patches, err := dmp.PatchFromText(req.Patches)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+		}
+		var applied []bool
+		result.Result, applied = dmp.PatchApply(patches, req.Original)
+		for i, ok := range applied {
+			hunk := PatchHunk{Index: i + 1, Header: strings.SplitN(patches[i].String(), "\n", 2)[0], Status: HunkApplied}
+			if !ok {
+				hunk.Status, hunk.Reason = HunkRejected, "no match for its context"
+			}
+			result.Hunks = append(result.Hunks, hunk)
+		}
+	case PatchFormatUnified:
+		hunks, err := parseUnifiedDiff(req.Patches)
+		if err != nil {
+			return nil, err
+		}
+		original := req.Original
+		if original == "" && req.FilePath != "" {
+			content, err := ds.fileService.ReadTextFile(sessionID, req.FilePath)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+			original = string(content)
+		}
+		result.original = original
+		result.Result, result.Hunks = applyUnifiedDiff(original, hunks, fuzz, req.IgnoreWhitespace)
+	default:
+		return nil, fmt.Errorf("%w: unknown format %q, expected dmp or unified", ErrInvalidPatch, req.Format)
 	}
 	
+	result.Hunks = append([]PatchHunk{}, result.Hunks...)
+	for _, hunk := range result.Hunks {
+		if hunk.Status == HunkApplied {
+			result.Applied++
+		} else {
+			result.Rejected++
+		}
+	}
+	switch {
+	case result.Rejected == 0:
+		result.Status = PatchApplied
+	case result.Applied == 0:
+		result.Status = PatchFailed
+	default:
+		result.Status = PatchPartial
+	}
 	return result, nil
 }
@@ -0,0 +1,279 @@
+package services
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// maxDirDiffContent is the size up to which a directory comparison diffs
+// the content of a file
+const maxDirDiffContent = 1 << 20
+
+// Statuses of the entries a directory comparison reports
+const (
+	DirChangeAdded    = "added"
+	DirChangeRemoved  = "removed"
+	DirChangeModified = "modified"
+)
+
+// DirDiffRequest compares the directory at OriginalPath with the one at
+// ModifiedPath, or a snapshot with ModifiedPath, by default the directory
+// it was taken of. Include and Exclude are glob patterns, as in a copy,
+// choosing the entries compared; a snapshot only covers those its own
+// patterns chose. With Contents, changed text files come with a diff.
+type DirDiffRequest struct {
+	OriginalPath string   `json:"originalPath,omitempty"`
+	ModifiedPath string   `json:"modifiedPath,omitempty"`
+	Snapshot     string   `json:"snapshot,omitempty"`
+	Contents     bool     `json:"contents"`
+	Include      []string `json:"include,omitempty"`
+	Exclude      []string `json:"exclude,omitempty"`
+}
+
+// DirChange is an entry added, removed or modified between two directories
+type DirChange struct {
+	Path         string `json:"path"` // Relative to the compared directories
+	Status       string `json:"status"`
+	Type         string `json:"type"`                   // file, dir or symlink
+	OriginalType string `json:"originalType,omitempty"` // When the type changed
+	Size         int64  `json:"size,omitempty"`
+	OriginalSize int64  `json:"originalSize,omitempty"`
+	Diff         string `json:"diff,omitempty"`
+	Binary       bool   `json:"binary,omitempty"`
+}
+
+// DirDiff is what changed from one directory to another, in the order of
+// the paths compared by name at each level
+type DirDiff struct {
+	OriginalPath string      `json:"originalPath"`
+	ModifiedPath string      `json:"modifiedPath"`
+	Snapshot     string      `json:"snapshot,omitempty"`
+	Changes      []DirChange `json:"changes"`
+	Added        int         `json:"added"`
+	Removed      int         `json:"removed"`
+	Modified     int         `json:"modified"`
+	Unchanged    int         `json:"unchanged"` // Files and symlinks
+}
+
+// dirSide is one of the two sides of a directory comparison, a directory
+// or a snapshot
+type dirSide struct {
+	root       string               // Of a directory
+	sessionDir string               // Of a snapshot, where it keeps contents
+	entries    map[string]treeEntry // By slash-separated path
+}
+
+// checksum returns the SHA-256 of a file, "" when it cannot be read
+func (side *dirSide) checksum(entry *treeEntry) string {
+	if entry.SHA256 == "" && side.root != "" {
+		entry.SHA256, _ = fileChecksum(filepath.Join(side.root, filepath.FromSlash(entry.Path)))
+	}
+	return entry.SHA256
+}
+
+// content returns the content of a file, nil when it is binary and ok
+// false when it is too large to diff or not kept by the snapshot
+func (side *dirSide) content(entry treeEntry) (content []byte, ok bool) {
+	if entry.Size > maxDirDiffContent {
+		return nil, false
+	}
+	path := filepath.Join(side.root, filepath.FromSlash(entry.Path))
+	if side.root == "" {
+		if !entry.Stored {
+			return nil, false
+		}
+		path = filepath.Join(side.sessionDir, "objects", entry.SHA256)
+	}
+	content, err := readText(path)
+	return content, err == nil
+}
+
+// DiffDirectories compares two directories, or a directory with a
+// snapshot. Files are read for their checksums only when their sizes are
+// the same, and for their content only with Contents.
+func (ds *DiffService) DiffDirectories(sessionID string, req *DirDiffRequest) (result *DirDiff, err error) {
+	defer func() {
+		detail := "against " + req.ModifiedPath
+		if result != nil {
+			detail = fmt.Sprintf("against %s: %d added, %d removed, %d modified", result.ModifiedPath, result.Added, result.Removed, result.Modified)
+		}
+		target := req.OriginalPath
+		if req.Snapshot != "" {
+			target = "snapshot " + req.Snapshot
+		}
+		ds.sessionManager.Audit(sessionID, "diff.dirs", target, detail, err)
+	}()
+
+	for _, pattern := range append(append([]string{}, req.Include...), req.Exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%w: invalid pattern %q", ErrInvalidTarget, pattern)
+		}
+	}
+	if req.Snapshot == "" && (req.OriginalPath == "" || req.ModifiedPath == "") {
+		return nil, fmt.Errorf("%w: originalPath and modifiedPath, or a snapshot, are required", ErrInvalidTarget)
+	}
+	if req.Snapshot != "" && req.OriginalPath != "" {
+		return nil, fmt.Errorf("%w: compare either originalPath or a snapshot", ErrInvalidTarget)
+	}
+
+	result = &DirDiff{OriginalPath: req.OriginalPath, ModifiedPath: req.ModifiedPath, Snapshot: req.Snapshot}
+	var original, modified *dirSide
+	if req.Snapshot != "" {
+		if _, err := ds.sessionManager.GetSession(sessionID); err != nil {
+			return nil, err
+		}
+		manifest, err := ds.sessionManager.readSnapshot(sessionID, req.Snapshot)
+		if err != nil {
+			return nil, err
+		}
+		original = newDirSide("", filterTree(manifest.Entries, req.Include, req.Exclude))
+		original.sessionDir = ds.sessionManager.sessionVersionsDir(sessionID)
+		result.OriginalPath = manifest.Path
+		if result.ModifiedPath == "" {
+			result.ModifiedPath = manifest.Path
+		}
+		// The directory is listed as the snapshot was, to compare the
+		// entries it covers
+		root, entries, err := ds.scanDir(sessionID, result.ModifiedPath, manifest.Include, manifest.Exclude)
+		if err != nil {
+			return nil, err
+		}
+		modified = newDirSide(root, filterTree(entries, req.Include, req.Exclude))
+	} else {
+		root, entries, err := ds.scanDir(sessionID, req.OriginalPath, req.Include, req.Exclude)
+		if err != nil {
+			return nil, err
+		}
+		original = newDirSide(root, entries)
+		if root, entries, err = ds.scanDir(sessionID, req.ModifiedPath, req.Include, req.Exclude); err != nil {
+			return nil, err
+		}
+		modified = newDirSide(root, entries)
+	}
+
+	paths := make([]string, 0, len(original.entries)+len(modified.entries))
+	for path := range original.entries {
+		paths = append(paths, path)
+	}
+	for path := range modified.entries {
+		if _, ok := original.entries[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return compareWalkOrder(filepath.FromSlash(paths[i]), filepath.FromSlash(paths[j])) < 0
+	})
+
+	result.Changes = []DirChange{}
+	for _, path := range paths {
+		before, inOriginal := original.entries[path]
+		after, inModified := modified.entries[path]
+		var change DirChange
+		switch {
+		case !inOriginal:
+			change = DirChange{Path: path, Status: DirChangeAdded, Type: after.Type, Size: after.Size}
+			result.Added++
+		case !inModified:
+			change = DirChange{Path: path, Status: DirChangeRemoved, Type: before.Type, OriginalSize: before.Size}
+			result.Removed++
+		case before.Type != after.Type:
+			change = DirChange{Path: path, Status: DirChangeModified, Type: after.Type, OriginalType: before.Type, Size: after.Size, OriginalSize: before.Size}
+			result.Modified++
+		case before.Type == treeDir:
+			continue
+		case before.Type == treeSymlink && before.Target == after.Target,
+			before.Type == treeFile && before.Size == after.Size && original.checksum(&before) == modified.checksum(&after):
+			result.Unchanged++
+			continue
+		default:
+			change = DirChange{Path: path, Status: DirChangeModified, Type: after.Type, Size: after.Size, OriginalSize: before.Size}
+			result.Modified++
+		}
+		if req.Contents && (before.Type == treeFile || after.Type == treeFile) {
+			addContentDiff(&change, original, before, modified, after)
+		}
+		result.Changes = append(result.Changes, change)
+	}
+	return result, nil
+}
+
+// scanDir lists the entries of a directory of a session for a comparison
+func (ds *DiffService) scanDir(sessionID string, path string, include []string, exclude []string) (string, []treeEntry, error) {
+	fullPath, err := ds.fileService.GetFilePath(sessionID, path)
+	if err != nil {
+		return "", nil, err
+	}
+	entries, err := scanTree(fullPath, include, exclude)
+	return fullPath, entries, err
+}
+
+// newDirSide returns a side of a comparison holding entries, of the
+// directory at root or of a snapshot for an empty root
+func newDirSide(root string, entries []treeEntry) *dirSide {
+	side := &dirSide{root: root, entries: make(map[string]treeEntry, len(entries))}
+	for _, entry := range entries {
+		side.entries[entry.Path] = entry
+	}
+	return side
+}
+
+// addContentDiff sets the diff of a changed file, from nothing when it was
+// added and to nothing when it was removed. It is left out for binary
+// files and those too large to diff or whose content a snapshot lacks.
+func addContentDiff(change *DirChange, original *dirSide, before treeEntry, modified *dirSide, after treeEntry) {
+	var texts [2]string
+	for i, side := range []struct {
+		dir   *dirSide
+		entry treeEntry
+	}{{original, before}, {modified, after}} {
+		if side.entry.Type != treeFile {
+			continue
+		}
+		content, ok := side.dir.content(side.entry)
+		if !ok {
+			return
+		}
+		if content == nil {
+			change.Binary = true
+			return
+		}
+		texts[i] = string(content)
+	}
+	change.Diff = UnifiedDiff(change.Path, texts[0], texts[1])
+}
+
+// filterTree keeps the entries, listed in the order of a walk, that
+// include and exclude choose as scanTree does
+func filterTree(entries []treeEntry, include []string, exclude []string) []treeEntry {
+	kept := []treeEntry{}
+	var excluded []string
+	for _, entry := range entries {
+		rel := filepath.FromSlash(entry.Path)
+		if below(excluded, rel) {
+			continue
+		}
+		if matchesAny(exclude, rel) {
+			if entry.Type == treeDir {
+				excluded = append(excluded, rel)
+			}
+			continue
+		}
+		if entry.Type != treeDir && len(include) > 0 && !matchesAny(include, rel) {
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	return kept
+}
+
+// below reports whether a path lies below any of dirs
+func below(dirs []string, path string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,396 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrSnapshotNotFound is returned for directory snapshots that do not
+// exist or have been deleted
+var ErrSnapshotNotFound = errors.New("directory snapshot not found")
+
+// ErrSnapshotsDisabled is returned for snapshots taken while file versions,
+// which keep their content, are off
+var ErrSnapshotsDisabled = errors.New("directory snapshots are disabled")
+
+// maxSnapshotContent is how many bytes of file content a snapshot keeps
+// for diffs; files past it are compared by checksum only
+const maxSnapshotContent = 256 << 20
+
+// Types of the entries of a directory tree
+const (
+	treeFile    = "file"
+	treeDir     = "dir"
+	treeSymlink = "symlink"
+)
+
+// treeEntry is a file, directory or symlink below a directory
+type treeEntry struct {
+	Path    string      `json:"path"` // Relative to the directory
+	Type    string      `json:"type"`
+	Size    int64       `json:"size,omitempty"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"modTime"`
+	SHA256  string      `json:"sha256,omitempty"` // Of files, when known
+	Target  string      `json:"target,omitempty"` // Of symlinks
+	Stored  bool        `json:"stored,omitempty"` // Content kept by a snapshot
+}
+
+// DirSnapshot records the files of a directory at a point in time, to
+// compare the directory against later: their checksums, and the content of
+// the files up to the size of file versions for diffs
+type DirSnapshot struct {
+	ID         string    `json:"id"`
+	Path       string    `json:"path"`       // Relative to the working directory
+	WorkingDir string    `json:"workingDir"` // The working directory it was taken in
+	Include    []string  `json:"include,omitempty"`
+	Exclude    []string  `json:"exclude,omitempty"`
+	Files      int       `json:"files"`
+	Size       int64     `json:"size"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// snapshotManifest is a snapshot as kept, with its entries
+type snapshotManifest struct {
+	DirSnapshot
+	Entries []treeEntry `json:"entries"`
+}
+
+// SnapshotRequest takes a snapshot of the directory at Path. Include and
+// Exclude are glob patterns, as in a copy, choosing the entries it covers.
+type SnapshotRequest struct {
+	Path    string   `json:"path"`
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// SnapshotListing is the directory snapshots of a session, newest first
+type SnapshotListing struct {
+	Snapshots []DirSnapshot `json:"snapshots"`
+	Count     int           `json:"count"`
+	Enabled   bool          `json:"enabled"` // False when file versions are off
+}
+
+// scanTree lists the entries below a directory in the order of a walk,
+// leaving out those excluded, with the directories they match and what is
+// in them, and with include the files it does not match. Files are not
+// read; their checksums are left for fileChecksum.
+func scanTree(root string, include []string, exclude []string) ([]treeEntry, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%w: %s is not a directory", ErrInvalidTarget, filepath.Base(root))
+	}
+	entries := []treeEntry{}
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // Skip entries that cannot be read
+		}
+		if path == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		if matchesAny(exclude, rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		entry := treeEntry{Path: filepath.ToSlash(rel), Mode: info.Mode().Perm(), ModTime: info.ModTime()}
+		switch {
+		case d.IsDir():
+			entry.Type = treeDir
+		case info.Mode()&os.ModeSymlink != 0:
+			entry.Type = treeSymlink
+			entry.Target, _ = os.Readlink(path)
+		case info.Mode().IsRegular():
+			entry.Type = treeFile
+			entry.Size = info.Size()
+		default:
+			return nil // Devices, sockets and pipes have no content to compare
+		}
+		if entry.Type != treeDir && len(include) > 0 && !matchesAny(include, rel) {
+			return nil
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// fileChecksum returns the SHA-256 of a file's content
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// snapshotsDir returns where the snapshots of a session are kept, "" when
+// file versions are off
+func (sm *SessionManager) snapshotsDir(sessionID string) string {
+	sessionDir := sm.sessionVersionsDir(sessionID)
+	if sessionDir == "" {
+		return ""
+	}
+	return filepath.Join(sessionDir, "snapshots")
+}
+
+// readSnapshot returns a snapshot of a session with its entries
+func (sm *SessionManager) readSnapshot(sessionID string, id string) (*snapshotManifest, error) {
+	dir := sm.snapshotsDir(sessionID)
+	if _, err := uuid.Parse(id); err != nil || dir == "" {
+		return nil, ErrSnapshotNotFound
+	}
+	data, err := os.ReadFile(filepath.Join(dir, id+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrSnapshotNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var manifest snapshotManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s: %v", id, err)
+	}
+	return &manifest, nil
+}
+
+// snapshotObjects adds the contents the snapshots of a session keep to
+// used, reporting false when one could not be read
+func snapshotObjects(sessionDir string, used map[string]bool) bool {
+	manifests, err := os.ReadDir(filepath.Join(sessionDir, "snapshots"))
+	if errors.Is(err, os.ErrNotExist) {
+		return true
+	}
+	if err != nil {
+		return false
+	}
+	for _, entry := range manifests {
+		data, err := os.ReadFile(filepath.Join(sessionDir, "snapshots", entry.Name()))
+		if err != nil {
+			return false
+		}
+		var manifest snapshotManifest
+		if json.Unmarshal(data, &manifest) != nil {
+			return false
+		}
+		for _, file := range manifest.Entries {
+			if file.Stored {
+				used[file.SHA256] = true
+			}
+		}
+	}
+	return true
+}
+
+// SnapshotService takes, lists and deletes snapshots of directories
+type SnapshotService struct {
+	sessionManager *SessionManager
+	fileService    *FileService
+}
+
+func NewSnapshotService(sm *SessionManager) *SnapshotService {
+	return &SnapshotService{
+		sessionManager: sm,
+		fileService:    NewFileService(sm),
+	}
+}
+
+// Create takes a snapshot of a directory. Its files are read twice: for
+// their checksums, and then with the store locked for the contents it does
+// not hold yet, which only files that did not change in between give.
+func (ss *SnapshotService) Create(sessionID string, req *SnapshotRequest) (snapshot *DirSnapshot, err error) {
+	defer func() {
+		detail := ""
+		if snapshot != nil {
+			detail = fmt.Sprintf("%d files", snapshot.Files)
+		}
+		ss.sessionManager.Audit(sessionID, "snapshot.create", req.Path, detail, err)
+	}()
+
+	for _, pattern := range append(append([]string{}, req.Include...), req.Exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%w: invalid pattern %q", ErrInvalidTarget, pattern)
+		}
+	}
+	fullPath, err := ss.fileService.GetFilePath(sessionID, req.Path)
+	if err != nil {
+		return nil, err
+	}
+	settings := ss.sessionManager.versions.settings()
+	if settings.dir == "" {
+		return nil, fmt.Errorf("%w: they keep content with file versions, which are off", ErrSnapshotsDisabled)
+	}
+	session, err := ss.sessionManager.PeekSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := scanTree(fullPath, req.Include, req.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &snapshotManifest{
+		DirSnapshot: DirSnapshot{
+			ID:         uuid.New().String(),
+			Path:       req.Path,
+			WorkingDir: session.WorkingDir,
+			Include:    req.Include,
+			Exclude:    req.Exclude,
+			CreatedAt:  time.Now().UTC(),
+		},
+		Entries: entries,
+	}
+	for i := range entries {
+		if entries[i].Type != treeFile {
+			continue
+		}
+		// A file that cannot be read is listed without a checksum, and
+		// compared by size
+		entries[i].SHA256, _ = fileChecksum(filepath.Join(fullPath, entries[i].Path))
+		manifest.Files++
+		manifest.Size += entries[i].Size
+	}
+
+	sessionDir := filepath.Join(settings.dir, sessionID)
+	ss.sessionManager.versions.mutex.Lock()
+	defer ss.sessionManager.versions.mutex.Unlock()
+	var stored int64
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Type != treeFile || entry.SHA256 == "" || entry.Size > settings.maxSize || stored+entry.Size > maxSnapshotContent {
+			continue
+		}
+		objectPath := filepath.Join(sessionDir, "objects", entry.SHA256)
+		if _, err := os.Stat(objectPath); err != nil {
+			content, err := os.ReadFile(filepath.Join(fullPath, entry.Path))
+			if err != nil {
+				continue
+			}
+			if sum := sha256.Sum256(content); hex.EncodeToString(sum[:]) != entry.SHA256 {
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(objectPath), 0700); err != nil {
+				return nil, err
+			}
+			if err := writeFileAtomic(objectPath, content, 0600); err != nil {
+				return nil, err
+			}
+		}
+		entry.Stored = true
+		stored += entry.Size
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(sessionDir, "snapshots"), 0700); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(filepath.Join(sessionDir, "snapshots", manifest.ID+".json"), data, 0600); err != nil {
+		return nil, err
+	}
+
+	ss.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityDirectory,
+		Target:   req.Path,
+		Message:  fmt.Sprintf("Took a snapshot of %s (%d files)", req.Path, manifest.Files),
+	})
+	return &manifest.DirSnapshot, nil
+}
+
+// List returns the snapshots of a session, newest first
+func (ss *SnapshotService) List(sessionID string) (*SnapshotListing, error) {
+	if _, err := ss.sessionManager.GetSession(sessionID); err != nil {
+		return nil, err
+	}
+	dir := ss.sessionManager.snapshotsDir(sessionID)
+	if dir == "" {
+		return &SnapshotListing{Snapshots: []DirSnapshot{}}, nil
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	listing := &SnapshotListing{Snapshots: []DirSnapshot{}, Enabled: true}
+	for _, file := range files {
+		id := file.Name()[:len(file.Name())-len(filepath.Ext(file.Name()))]
+		if manifest, err := ss.sessionManager.readSnapshot(sessionID, id); err == nil {
+			listing.Snapshots = append(listing.Snapshots, manifest.DirSnapshot)
+		}
+	}
+	sort.Slice(listing.Snapshots, func(i, j int) bool {
+		return listing.Snapshots[i].CreatedAt.After(listing.Snapshots[j].CreatedAt)
+	})
+	listing.Count = len(listing.Snapshots)
+	return listing, nil
+}
+
+// Get returns a snapshot of a session
+func (ss *SnapshotService) Get(sessionID string, id string) (*DirSnapshot, error) {
+	if _, err := ss.sessionManager.GetSession(sessionID); err != nil {
+		return nil, err
+	}
+	manifest, err := ss.sessionManager.readSnapshot(sessionID, id)
+	if err != nil {
+		return nil, err
+	}
+	return &manifest.DirSnapshot, nil
+}
+
+// Delete deletes a snapshot, and the contents only it kept
+func (ss *SnapshotService) Delete(sessionID string, id string) (err error) {
+	defer func() {
+		ss.sessionManager.Audit(sessionID, "snapshot.delete", id, "", err)
+	}()
+
+	if _, err := ss.sessionManager.GetSession(sessionID); err != nil {
+		return err
+	}
+	manifest, err := ss.sessionManager.readSnapshot(sessionID, id)
+	if err != nil {
+		return err
+	}
+	sessionDir := ss.sessionManager.sessionVersionsDir(sessionID)
+	ss.sessionManager.versions.mutex.Lock()
+	defer ss.sessionManager.versions.mutex.Unlock()
+	if err := os.Remove(filepath.Join(sessionDir, "snapshots", id+".json")); err != nil {
+		return err
+	}
+	removeUnusedObjects(sessionDir)
+
+	ss.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityDirectory,
+		Target:   manifest.Path,
+		Message:  "Deleted the snapshot of " + manifest.Path,
+	})
+	return nil
+}
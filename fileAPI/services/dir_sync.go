@@ -0,0 +1,270 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Actions a sync takes on the entries of its destination
+const (
+	SyncCreate = "create"
+	SyncUpdate = "update"
+	SyncDelete = "delete"
+)
+
+// SyncRequest makes the directory at Destination mirror the one at Source.
+// Files differ when their sizes or modification times do, or with
+// Checksum their SHA-256 checksums. Exclude lists glob patterns, as in a
+// copy: excluded entries are neither copied nor deleted. With Delete, the
+// entries of the destination the source lacks are moved to the trash, or
+// removed with Permanent.
+type SyncRequest struct {
+	Source      string   `json:"source"`
+	Destination string   `json:"destination"`
+	Delete      bool     `json:"delete"`
+	Permanent   bool     `json:"permanent"`
+	Checksum    bool     `json:"checksum"`
+	Exclude     []string `json:"exclude,omitempty"`
+}
+
+// SyncAction is what a sync did, or would do, to an entry of the
+// destination
+type SyncAction struct {
+	Path   string `json:"path"` // Relative to the synced directories
+	Action string `json:"action"`
+	Type   string `json:"type"` // file, dir or symlink
+	Size   int64  `json:"size,omitempty"`
+	Trash  string `json:"trash,omitempty"` // ID of the trash entry holding what it replaced or deleted
+	Error  string `json:"error,omitempty"`
+}
+
+// SyncResult sums up a sync. Actions lists the entries created, updated
+// and deleted, in the order of their paths; unchanged entries are only
+// counted.
+type SyncResult struct {
+	DryRun      bool         `json:"dryRun,omitempty"`
+	Source      string       `json:"source"`
+	Destination string       `json:"destination"`
+	Created     int          `json:"created"`
+	Updated     int          `json:"updated"`
+	Deleted     int          `json:"deleted"`
+	Unchanged   int          `json:"unchanged"`
+	Failed      int          `json:"failed"`
+	Bytes       int64        `json:"bytes"` // Of the files copied
+	Actions     []SyncAction `json:"actions"`
+}
+
+// Sync makes a directory mirror another, copying the files and symlinks
+// that are missing or differ with their permissions and modification
+// times, so syncing again finds nothing to do. An entry that fails is
+// reported without stopping the rest. With dryRun, the actions are only
+// reported.
+func (fs *FileService) Sync(sessionID string, req *SyncRequest, dryRun bool) (result *SyncResult, err error) {
+	defer func() {
+		if dryRun {
+			return
+		}
+		detail := "to " + req.Destination
+		if result != nil {
+			detail += fmt.Sprintf(": %d created, %d updated, %d deleted, %d failed", result.Created, result.Updated, result.Deleted, result.Failed)
+		}
+		fs.sessionManager.Audit(sessionID, "file.sync", req.Source, detail, err)
+	}()
+
+	for _, pattern := range req.Exclude {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%w: invalid pattern %q", ErrInvalidTarget, pattern)
+		}
+	}
+	sourcePath, destinationPath, info, err := fs.transferPaths(sessionID, req.Source, req.Destination)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%w: %s is not a directory", ErrInvalidTarget, req.Source)
+	}
+	// Deleting what the source lacks would delete the source itself
+	if isWithin(destinationPath, sourcePath) {
+		return nil, fmt.Errorf("%w: cannot sync directory %s into a directory holding it", ErrInvalidTarget, req.Source)
+	}
+	sources, err := scanTree(sourcePath, nil, req.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	destinations := map[string]treeEntry{}
+	var existing []treeEntry
+	if info, err := os.Lstat(destinationPath); err == nil {
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%w: %s is not a directory", ErrInvalidTarget, req.Destination)
+		}
+		if existing, err = scanTree(destinationPath, nil, req.Exclude); err != nil {
+			return nil, err
+		}
+		for _, entry := range existing {
+			destinations[entry.Path] = entry
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	} else if !dryRun {
+		if err := os.MkdirAll(destinationPath, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	result = &SyncResult{DryRun: dryRun, Source: req.Source, Destination: req.Destination, Actions: []SyncAction{}}
+	record := func(action SyncAction, err error) {
+		if err != nil {
+			action.Error = err.Error()
+			result.Failed++
+		} else {
+			switch action.Action {
+			case SyncCreate:
+				result.Created++
+			case SyncUpdate:
+				result.Updated++
+			case SyncDelete:
+				result.Deleted++
+			}
+			if action.Action != SyncDelete && action.Type == treeFile {
+				result.Bytes += action.Size
+			}
+		}
+		result.Actions = append(result.Actions, action)
+	}
+	// remove deletes an entry of the destination, into the trash unless
+	// the request is permanent
+	remove := func(action *SyncAction, target string) error {
+		if !req.Permanent {
+			entry, err := fs.sessionManager.moveToTrash(sessionID, filepath.Join(req.Destination, filepath.FromSlash(action.Path)), target)
+			if err != nil || entry != nil {
+				if entry != nil {
+					action.Trash = entry.ID
+				}
+				return err
+			}
+		}
+		return os.RemoveAll(target)
+	}
+
+	// Directories get their permissions and times once their content is in
+	var directories []treeEntry
+	// Entries below a deleted or replaced directory go with it
+	var removed []string
+	synced := make(map[string]bool, len(sources))
+	for _, source := range sources {
+		synced[source.Path] = true
+		rel := filepath.FromSlash(source.Path)
+		sourceFile := filepath.Join(sourcePath, rel)
+		target := filepath.Join(destinationPath, rel)
+		destination, exists := destinations[source.Path]
+		action := SyncAction{Path: source.Path, Action: SyncCreate, Type: source.Type, Size: source.Size}
+		if exists {
+			if syncedEntry(source, destination, sourceFile, target, req.Checksum) {
+				result.Unchanged++
+				if source.Type == treeDir {
+					directories = append(directories, source)
+				}
+				continue
+			}
+			action.Action = SyncUpdate
+			if destination.Type == treeDir && source.Type != treeDir {
+				removed = append(removed, rel)
+			}
+		}
+		if dryRun {
+			record(action, nil)
+			continue
+		}
+
+		var err error
+		// An entry of another type is replaced, and a directory is kept so
+		// its content is synced rather than copied again
+		switch {
+		case exists && destination.Type != source.Type:
+			err = remove(&action, target)
+		case exists && source.Type == treeSymlink:
+			err = os.Remove(target)
+		}
+		if err == nil {
+			switch source.Type {
+			case treeDir:
+				err = os.MkdirAll(target, 0755)
+				directories = append(directories, source)
+			case treeSymlink:
+				err = os.Symlink(source.Target, target)
+			default:
+				err = copyTree(sourceFile, target)
+			}
+		}
+		record(action, err)
+	}
+
+	if req.Delete {
+		for _, destination := range existing {
+			rel := filepath.FromSlash(destination.Path)
+			if synced[destination.Path] || below(removed, rel) {
+				continue
+			}
+			action := SyncAction{Path: destination.Path, Action: SyncDelete, Type: destination.Type, Size: destination.Size}
+			if destination.Type == treeDir {
+				removed = append(removed, rel)
+			}
+			if dryRun {
+				record(action, nil)
+				continue
+			}
+			record(action, remove(&action, filepath.Join(destinationPath, rel)))
+		}
+	}
+
+	if !dryRun {
+		for i := len(directories) - 1; i >= 0; i-- {
+			target := filepath.Join(destinationPath, filepath.FromSlash(directories[i].Path))
+			os.Chmod(target, directories[i].Mode)
+			os.Chtimes(target, directories[i].ModTime, directories[i].ModTime)
+		}
+	}
+	sort.SliceStable(result.Actions, func(i, j int) bool {
+		return compareWalkOrder(filepath.FromSlash(result.Actions[i].Path), filepath.FromSlash(result.Actions[j].Path)) < 0
+	})
+
+	if !dryRun {
+		fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+			Category: ActivityDirectory,
+			Target:   req.Destination,
+			Message:  fmt.Sprintf("Synced %s to %s (%d created, %d updated, %d deleted, %d failed)", req.Source, req.Destination, result.Created, result.Updated, result.Deleted, result.Failed),
+		})
+	}
+	return result, nil
+}
+
+// syncedEntry reports whether an entry of the destination already mirrors
+// the source's. Files compare by size and modification time, or with
+// checksum by their content; directories only by type, as what they hold
+// is synced on its own.
+func syncedEntry(source treeEntry, destination treeEntry, sourcePath string, destinationPath string, checksum bool) bool {
+	if source.Type != destination.Type {
+		return false
+	}
+	switch source.Type {
+	case treeDir:
+		return true
+	case treeSymlink:
+		return source.Target == destination.Target
+	}
+	if source.Size != destination.Size || source.Mode != destination.Mode {
+		return false
+	}
+	if !checksum {
+		return source.ModTime.Equal(destination.ModTime)
+	}
+	sourceSum, err := fileChecksum(sourcePath)
+	if err != nil {
+		return false
+	}
+	destinationSum, err := fileChecksum(destinationPath)
+	return err == nil && sourceSum == destinationSum
+}
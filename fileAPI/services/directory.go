@@ -9,11 +9,14 @@ import (
 )
 
 type DirectoryEntry struct {
-	Name      string    `json:"name"`
-	Path      string    `json:"path"`
-	Size      int64     `json:"size,omitempty"`
-	IsDir     bool      `json:"isDir"`
-	Children  []DirectoryEntry `json:"children,omitempty"`
+	Name       string           `json:"name"`
+	Path       string           `json:"path"`
+	Size       int64            `json:"size,omitempty"`
+	IsDir      bool             `json:"isDir"`
+	IsSymlink  bool             `json:"isSymlink,omitempty"`
+	LinkTarget string           `json:"linkTarget,omitempty"` // Where a symlink points, as stored in it
+	Loop       bool             `json:"loop,omitempty"`       // A followed symlink back to a directory above it, not descended into
+	Children   []DirectoryEntry `json:"children,omitempty"`
 }
 
 type DirectoryService struct {
@@ -26,84 +29,158 @@ func NewDirectoryService(sm *SessionManager) *DirectoryService {
 	}
 }
 
-func (ds *DirectoryService) ListDirectories(sessionID string, relativePath string) ([]string, error) {
-	session, err := ds.sessionManager.GetSession(sessionID)
-	if err != nil {
-		return nil, err
-	}
-	
-	fullPath := filepath.Join(session.WorkingDir, relativePath)
-	
-	files, err := ioutil.ReadDir(fullPath)
+// ListDirectories lists the names of the directories in a directory, and
+// with followSymlinks the symlinks leading to a directory inside the
+// working directory
+func (ds *DirectoryService) ListDirectories(sessionID string, relativePath string, followSymlinks bool) ([]string, error) {
+	entries, err := ds.sessionManager.listDir(sessionID, relativePath, followSymlinks)
 	if err != nil {
 		return nil, err
 	}
 	
 	var dirs []string
-	for _, file := range files {
-		if file.IsDir() {
-			dirs = append(dirs, file.Name())
+	for _, entry := range entries {
+		if entry.Info.IsDir() {
+			dirs = append(dirs, entry.Info.Name())
 		}
 	}
 	
-	ds.sessionManager.LogActivity(sessionID, fmt.Sprintf("Listed %d directories in %s", len(dirs), relativePath))
-	fmt.Printf("[TERMINAL] Session %s: Listed %d directories in %s\n", sessionID, len(dirs), relativePath)
+	ds.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityDirectory,
+		Target:   relativePath,
+		Message:  fmt.Sprintf("Listed %d directories in %s", len(dirs), relativePath),
+	})
+	ds.sessionManager.Audit(sessionID, "directory.list", relativePath, fmt.Sprintf("%d directories", len(dirs)), nil)
 	return dirs, nil
 }
 
-func (ds *DirectoryService) CreateDirectory(sessionID string, relativePath string) error {
-	session, err := ds.sessionManager.GetSession(sessionID)
+func (ds *DirectoryService) CreateDirectory(sessionID string, relativePath string) (err error) {
+	defer func() {
+		ds.sessionManager.Audit(sessionID, "directory.create", relativePath, "", err)
+	}()
+	
+	remote, err := ds.sessionManager.remoteFiles(sessionID)
 	if err != nil {
 		return err
 	}
-	
-	fullPath := filepath.Join(session.WorkingDir, relativePath)
-	
-	if err := os.MkdirAll(fullPath, 0755); err != nil {
-		return err
+	if remote != nil {
+		if err := remote.mkdirAll(relativePath); err != nil {
+			return err
+		}
+	} else {
+		fullPath, err := ds.sessionManager.ResolvePath(sessionID, relativePath)
+		if err != nil {
+			return err
+		}
+		
+		if err := os.MkdirAll(fullPath, 0755); err != nil {
+			return err
+		}
 	}
 	
-	ds.sessionManager.LogActivity(sessionID, fmt.Sprintf("Created directory %s", relativePath))
-	fmt.Printf("[TERMINAL] Session %s: Created directory %s\n", sessionID, relativePath)
+	ds.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityDirectory,
+		Target:   relativePath,
+		Message:  "Created directory " + relativePath,
+	})
 	return nil
 }
 
-func (ds *DirectoryService) DeleteDirectory(sessionID string, relativePath string) error {
-	session, err := ds.sessionManager.GetSession(sessionID)
+// DeleteDirectory deletes a directory and everything in it, moving it to
+// the session's trash unless permanent or the trash is off, and returns its
+// trash entry. Directories of remote sessions are always deleted
+// permanently.
+func (ds *DirectoryService) DeleteDirectory(sessionID string, relativePath string, permanent bool) (entry *TrashEntry, err error) {
+	defer func() {
+		detail := "permanent"
+		if entry != nil {
+			detail = "trash " + entry.ID
+		}
+		ds.sessionManager.Audit(sessionID, "directory.delete", relativePath, detail, err)
+	}()
+	
+	remote, err := ds.sessionManager.remoteFiles(sessionID)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	
-	fullPath := filepath.Join(session.WorkingDir, relativePath)
-	
-	if err := os.RemoveAll(fullPath); err != nil {
-		return err
+	if remote != nil {
+		if err := remote.remove(relativePath, true); err != nil {
+			return nil, err
+		}
+	} else {
+		fullPath, err := ds.sessionManager.ResolvePath(sessionID, relativePath)
+		if err != nil {
+			return nil, err
+		}
+		
+		if !permanent {
+			if root, err := ds.sessionManager.ResolvePath(sessionID, "."); err == nil && root == fullPath {
+				return nil, fmt.Errorf("%w: cannot delete the working directory", ErrInvalidTarget)
+			}
+			if entry, err = ds.sessionManager.moveToTrash(sessionID, relativePath, fullPath); err != nil {
+				return nil, err
+			}
+		}
+		if entry == nil {
+			if err := os.RemoveAll(fullPath); err != nil {
+				return nil, err
+			}
+		}
 	}
 	
-	ds.sessionManager.LogActivity(sessionID, fmt.Sprintf("Deleted directory %s", relativePath))
-	fmt.Printf("[TERMINAL] Session %s: Deleted directory %s\n", sessionID, relativePath)
-	return nil
+	message := "Deleted directory " + relativePath
+	if entry != nil {
+		message = "Moved directory " + relativePath + " to the trash"
+	}
+	ds.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityDirectory,
+		Target:   relativePath,
+		Message:  message,
+	})
+	return entry, nil
 }
 
-func (ds *DirectoryService) GetDirectoryTree(sessionID string, relativePath string, maxDepth int) ([]DirectoryEntry, error) {
-	session, err := ds.sessionManager.GetSession(sessionID)
+// GetDirectoryTree returns the entries below a directory, maxDepth levels
+// deep or all of them for 0. With followSymlinks, symlinks to directories
+// inside the working directory are descended into, except those looping
+// back to a directory above them.
+func (ds *DirectoryService) GetDirectoryTree(sessionID string, relativePath string, maxDepth int, followSymlinks bool) ([]DirectoryEntry, error) {
+	fullPath, err := ds.sessionManager.ResolvePath(sessionID, relativePath)
+	if err != nil {
+		return nil, err
+	}
+	root, err := ds.sessionManager.realWorkingDir(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	realPath, err := filepath.EvalSymlinks(fullPath)
 	if err != nil {
 		return nil, err
 	}
 	
-	fullPath := filepath.Join(session.WorkingDir, relativePath)
-	
-	entries, err := ds.buildDirectoryTree(fullPath, relativePath, 0, maxDepth)
+	walk := &treeWalk{root: root, follow: followSymlinks, ancestors: map[string]bool{realPath: true}}
+	entries, err := ds.buildDirectoryTree(walk, fullPath, relativePath, 0, maxDepth)
 	if err != nil {
 		return nil, err
 	}
 	
-	ds.sessionManager.LogActivity(sessionID, fmt.Sprintf("Generated directory tree for %s", relativePath))
-	fmt.Printf("[TERMINAL] Session %s: Generated directory tree for %s\n", sessionID, relativePath)
+	ds.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityDirectory,
+		Target:   relativePath,
+		Message:  "Generated directory tree for " + relativePath,
+	})
+	ds.sessionManager.Audit(sessionID, "directory.tree", relativePath, "", nil)
 	return entries, nil
 }
 
-func (ds *DirectoryService) buildDirectoryTree(fullPath, relativePath string, currentDepth, maxDepth int) ([]DirectoryEntry, error) {
+// treeWalk is the state of building a directory tree
+type treeWalk struct {
+	root      string          // Real working directory, which followed symlinks stay inside
+	follow    bool            // Whether symlinks are followed
+	ancestors map[string]bool // Real paths of the directories being built, to stop loops
+}
+
+func (ds *DirectoryService) buildDirectoryTree(walk *treeWalk, fullPath, relativePath string, currentDepth, maxDepth int) ([]DirectoryEntry, error) {
 	if maxDepth > 0 && currentDepth >= maxDepth {
 		return nil, nil
 	}
@@ -114,11 +191,14 @@ func (ds *DirectoryService) buildDirectoryTree(fullPath, relativePath string, cu
 	}
 	
 	entries := make([]DirectoryEntry, 0, len(files))
-	for _, file := range files {
+	for _, link := range files {
+		file, target := lookEntry(walk.root, fullPath, link, walk.follow)
 		entry := DirectoryEntry{
-			Name:  file.Name(),
-			Path:  filepath.Join(relativePath, file.Name()),
-			IsDir: file.IsDir(),
+			Name:       file.Name(),
+			Path:       filepath.Join(relativePath, file.Name()),
+			IsDir:      file.IsDir(),
+			IsSymlink:  link.Mode()&os.ModeSymlink != 0,
+			LinkTarget: target,
 		}
 		
 		if !file.IsDir() {
@@ -127,7 +207,21 @@ func (ds *DirectoryService) buildDirectoryTree(fullPath, relativePath string, cu
 			// Recursively build children for directories if within depth limit
 			childPath := filepath.Join(fullPath, file.Name())
 			childRelPath := filepath.Join(relativePath, file.Name())
-			children, err := ds.buildDirectoryTree(childPath, childRelPath, currentDepth+1, maxDepth)
+			
+			// A followed symlink can lead back to a directory being built
+			realPath, err := filepath.EvalSymlinks(childPath)
+			if err != nil {
+				return nil, err
+			}
+			if walk.ancestors[realPath] {
+				entry.Loop = true
+				entries = append(entries, entry)
+				continue
+			}
+			
+			walk.ancestors[realPath] = true
+			children, err := ds.buildDirectoryTree(walk, childPath, childRelPath, currentDepth+1, maxDepth)
+			delete(walk.ancestors, realPath)
 			if err != nil {
 				return nil, err
 			}
@@ -141,13 +235,11 @@ func (ds *DirectoryService) buildDirectoryTree(fullPath, relativePath string, cu
 }
 
 func (ds *DirectoryService) CalculateDirectorySize(sessionID string, relativePath string) (int64, error) {
-	session, err := ds.sessionManager.GetSession(sessionID)
+	fullPath, err := ds.sessionManager.ResolvePath(sessionID, relativePath)
 	if err != nil {
 		return 0, err
 	}
 	
-	fullPath := filepath.Join(session.WorkingDir, relativePath)
-	
 	var size int64
 	err = filepath.Walk(fullPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -163,8 +255,12 @@ func (ds *DirectoryService) CalculateDirectorySize(sessionID string, relativePat
 		return 0, err
 	}
 	
-	ds.sessionManager.LogActivity(sessionID, fmt.Sprintf("Calculated size for directory %s: %d bytes", relativePath, size))
-	fmt.Printf("[TERMINAL] Session %s: Calculated size for directory %s: %d bytes\n", sessionID, relativePath, size)
+	ds.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityDirectory,
+		Target:   relativePath,
+		Message:  fmt.Sprintf("Calculated size for directory %s: %d bytes", relativePath, size),
+	})
+	ds.sessionManager.Audit(sessionID, "directory.size", relativePath, fmt.Sprintf("%d bytes", size), nil)
 	return size, nil
 }
 
@@ -174,7 +270,10 @@ func (ds *DirectoryService) FindDirectories(sessionID string, baseDir, pattern s
 		return nil, err
 	}
 	
-	fullPath := filepath.Join(session.WorkingDir, baseDir)
+	fullPath, err := ds.sessionManager.ResolvePath(sessionID, baseDir)
+	if err != nil {
+		return nil, err
+	}
 	
 	var matches []string
 	
@@ -206,8 +305,12 @@ func (ds *DirectoryService) FindDirectories(sessionID string, baseDir, pattern s
 		return nil
 	})
 	
-	ds.sessionManager.LogActivity(sessionID, fmt.Sprintf("Found %d directories matching '%s' in %s", len(matches), pattern, baseDir))
-	fmt.Printf("[TERMINAL] Session %s: Found %d directories matching '%s' in %s\n", sessionID, len(matches), pattern, baseDir)
+	ds.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityDirectory,
+		Target:   baseDir,
+		Message:  fmt.Sprintf("Found %d directories matching '%s' in %s", len(matches), pattern, baseDir),
+	})
+	ds.sessionManager.Audit(sessionID, "directory.find", baseDir, fmt.Sprintf("pattern '%s' matched %d directories", pattern, len(matches)), nil)
 	
 	if err != nil {
 		return nil, err
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// writeAccess is the access(2) mode asking for write permission
+const writeAccess = 0x2
+
+// WritePlan is what a write would do, returned by dry runs in place of
+// doing it. Dry runs check paths, permissions and conflicts as the write
+// would, and fail with the same errors, but leave the disk untouched.
+type WritePlan struct {
+	DryRun      bool   `json:"dryRun"`
+	Operation   string `json:"operation"` // create, update, patch, delete or move
+	Path        string `json:"path"`
+	Destination string `json:"destination,omitempty"` // Where a move would put the path
+	Exists      bool   `json:"exists"`                // Whether the path exists now
+	IsDir       bool   `json:"isDir,omitempty"`
+	Overwrites  bool   `json:"overwrites,omitempty"` // Whether an existing file would be replaced
+	Size        int64  `json:"size"`                 // Bytes that would be written, or deleted
+	Trash       bool   `json:"trash,omitempty"`      // Whether a deletion would go to the trash
+	Diff        string `json:"diff,omitempty"`       // Of the file's content, for writes
+	// Of patches, how many would apply
+	Applied int `json:"applied,omitempty"`
+	Patches int `json:"patches,omitempty"`
+}
+
+// checkWritable refuses to plan a write the server's user could not make:
+// to an existing path it cannot write, or below a directory it cannot
+// write, or below a file
+func checkWritable(fullPath string) error {
+	if _, err := os.Lstat(fullPath); err == nil {
+		return checkAccess(fullPath)
+	}
+	dir := filepath.Dir(fullPath)
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%w: %s is not a directory", ErrInvalidTarget, filepath.Base(dir))
+			}
+			return checkAccess(dir)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return err
+		}
+		dir = parent
+	}
+}
+
+// checkAccess returns os.ErrPermission when the server's user may not
+// write path
+func checkAccess(path string) error {
+	if err := syscall.Access(path, writeAccess); err != nil {
+		return &os.PathError{Op: "write", Path: filepath.Base(path), Err: err}
+	}
+	return nil
+}
+
+// planContentWrite plans writing content to a file, as creates, updates
+// and patches do, with the line endings opts ask for
+func (fs *FileService) planContentWrite(sessionID string, relativePath string, content []byte, opts WriteOptions, operation string) (*WritePlan, error) {
+	fullPath, err := fs.GetFilePath(sessionID, relativePath)
+	if err != nil {
+		return nil, err
+	}
+	plan := &WritePlan{DryRun: true, Operation: operation, Path: relativePath}
+
+	var current []byte
+	info, err := os.Stat(fullPath)
+	switch {
+	case err == nil && info.IsDir():
+		return nil, fmt.Errorf("%w: %s", ErrIsDirectory, relativePath)
+	case err == nil:
+		if current, err = os.ReadFile(fullPath); err != nil {
+			return nil, err
+		}
+		plan.Exists = true
+		plan.Overwrites = true
+	case !errors.Is(err, os.ErrNotExist) && !errors.Is(err, syscall.ENOTDIR):
+		// A file where a parent directory should be is reported below
+		return nil, err
+	}
+	if err := checkWritable(fullPath); err != nil {
+		return nil, err
+	}
+	content = withLineEndings(content, current, opts.LineEndings)
+	plan.Size = int64(len(content))
+	plan.Diff = UnifiedDiff(relativePath, string(current), string(content))
+	return plan, nil
+}
+
+// PlanCreate is the dry run of CreateFile
+func (fs *FileService) PlanCreate(sessionID string, relativePath string, content []byte, opts WriteOptions) (*WritePlan, error) {
+	return fs.planContentWrite(sessionID, relativePath, content, opts, "create")
+}
+
+// PlanUpdate is the dry run of UpdateFile, with the diff of the update
+func (fs *FileService) PlanUpdate(sessionID string, relativePath string, content []byte, opts WriteOptions) (*WritePlan, error) {
+	return fs.planContentWrite(sessionID, relativePath, content, opts, "update")
+}
+
+// PlanBatchCreate is the dry run of BatchCreateFiles, planning each file
+func (fs *FileService) PlanBatchCreate(sessionID string, files map[string]string) []BatchResult {
+	results := make([]BatchResult, 0, len(files))
+	for path, content := range files {
+		plan, err := fs.PlanCreate(sessionID, path, []byte(content), WriteOptions{})
+		if err != nil {
+			results = append(results, BatchResult{Path: path, Success: false, Error: err.Error()})
+		} else {
+			results = append(results, BatchResult{Path: path, Success: true, Result: plan})
+		}
+	}
+	return results
+}
+
+// planDelete plans deleting a file or directory, to the trash unless
+// permanent or the trash is off
+func (sm *SessionManager) planDelete(sessionID string, relativePath string, fullPath string, permanent bool) (*WritePlan, error) {
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAccess(filepath.Dir(fullPath)); err != nil {
+		return nil, err
+	}
+	return &WritePlan{
+		DryRun:    true,
+		Operation: "delete",
+		Path:      relativePath,
+		Exists:    true,
+		IsDir:     info.IsDir(),
+		Size:      treeSize(fullPath, info),
+		Trash:     !permanent && sm.sessionTrashDir(sessionID) != "",
+	}, nil
+}
+
+// PlanDeleteFile is the dry run of DeleteFile
+func (fs *FileService) PlanDeleteFile(sessionID string, relativePath string, permanent bool) (*WritePlan, error) {
+	fullPath, err := fs.GetFilePath(sessionID, relativePath)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := fs.sessionManager.planDelete(sessionID, relativePath, fullPath, permanent)
+	if err != nil {
+		return nil, err
+	}
+	// Only empty directories can be deleted here, and never to the trash
+	if plan.IsDir {
+		if !permanent {
+			return nil, fmt.Errorf("%w: %s", ErrIsDirectory, relativePath)
+		}
+		if entries, err := os.ReadDir(fullPath); err == nil && len(entries) > 0 {
+			return nil, fmt.Errorf("%w: directory %s is not empty", ErrInvalidTarget, relativePath)
+		}
+	}
+	return plan, nil
+}
+
+// PlanDeleteDirectory is the dry run of DeleteDirectory
+func (ds *DirectoryService) PlanDeleteDirectory(sessionID string, relativePath string, permanent bool) (*WritePlan, error) {
+	fullPath, err := ds.sessionManager.ResolvePath(sessionID, relativePath)
+	if err != nil {
+		return nil, err
+	}
+	if !permanent {
+		if root, err := ds.sessionManager.ResolvePath(sessionID, "."); err == nil && root == fullPath {
+			return nil, fmt.Errorf("%w: cannot delete the working directory", ErrInvalidTarget)
+		}
+	}
+	return ds.sessionManager.planDelete(sessionID, relativePath, fullPath, permanent)
+}
+
+// PlanMove is the dry run of Move
+func (fs *FileService) PlanMove(sessionID string, source string, destination string, overwrite bool) (*WritePlan, error) {
+	sourcePath, destinationPath, info, err := fs.transferPaths(sessionID, source, destination)
+	if err != nil {
+		return nil, err
+	}
+	plan := &WritePlan{
+		DryRun:      true,
+		Operation:   "move",
+		Path:        source,
+		Destination: destination,
+		Exists:      true,
+		IsDir:       info.IsDir(),
+		Size:        treeSize(sourcePath, info),
+	}
+	if _, err := os.Lstat(destinationPath); err == nil {
+		if !overwrite {
+			return nil, fmt.Errorf("%s: %w", destination, os.ErrExist)
+		}
+		plan.Overwrites = true
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err := checkAccess(filepath.Dir(sourcePath)); err != nil {
+		return nil, err
+	}
+	if err := checkWritable(destinationPath); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// PlanPatch is the dry run of ApplyPatch: the patched text and, with a
+// file path, the plan of writing it
+func (ds *DiffService) PlanPatch(sessionID string, req *PatchRequest, opts WriteOptions) (*PatchResult, error) {
+	result, err := ds.patch(sessionID, req)
+	if err != nil {
+		return nil, err
+	}
+	plan := &WritePlan{DryRun: true, Operation: "patch", Size: int64(len(result.Result))}
+	if req.FilePath != "" {
+		if plan, err = ds.fileService.planContentWrite(sessionID, req.FilePath, []byte(result.Result), opts, "patch"); err != nil {
+			return nil, err
+		}
+	}
+	plan.Applied = result.Applied
+	plan.Patches = len(result.Hunks)
+	result.Plan = plan
+	return result, nil
+}
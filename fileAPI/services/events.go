@@ -0,0 +1,126 @@
+package services
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// Subscribers are sent at most eventBufferSize events ahead of what they
+// have read, and reconnecting clients are replayed up to recentEventsSize
+const (
+	eventBufferSize  = 256
+	recentEventsSize = 1000
+)
+
+// Event is an audited operation, as streamed to subscribers. IDs increase
+// with every event the server publishes.
+type Event struct {
+	ID uint64 `json:"id"`
+	AuditEntry
+}
+
+// EventFilter selects streamed events. Zero fields match everything, and
+// each of Operations also matches the operations below it.
+type EventFilter struct {
+	SessionID    string
+	Operations   []string
+	FailuresOnly bool
+}
+
+// EventSubscription receives the events published after it was made, until
+// it is closed. Events are dropped rather than wait for a subscriber that
+// falls behind.
+type EventSubscription struct {
+	Events <-chan Event
+	events chan Event
+	filter EventFilter
+	stream *eventStream
+}
+
+// eventStream fans out published events to subscribers
+type eventStream struct {
+	mutex       sync.Mutex
+	lastID      uint64
+	recent      []Event
+	subscribers map[*EventSubscription]struct{}
+}
+
+// Subscribe streams the events matching filter. Events after lastEventID
+// that are still kept are returned to be sent first, so a client can resume
+// where it stopped.
+func (as *AuditService) Subscribe(filter EventFilter, lastEventID uint64) (*EventSubscription, []Event) {
+	stream := &as.events
+	events := make(chan Event, eventBufferSize)
+	sub := &EventSubscription{Events: events, events: events, filter: filter, stream: stream}
+
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	var replay []Event
+	if lastEventID > 0 {
+		for _, event := range stream.recent {
+			if event.ID > lastEventID && filter.matches(&event.AuditEntry) {
+				replay = append(replay, event)
+			}
+		}
+	}
+	if stream.subscribers == nil {
+		stream.subscribers = make(map[*EventSubscription]struct{})
+	}
+	stream.subscribers[sub] = struct{}{}
+	return sub, replay
+}
+
+// Close stops the subscription
+func (sub *EventSubscription) Close() {
+	sub.stream.mutex.Lock()
+	defer sub.stream.mutex.Unlock()
+	if _, exists := sub.stream.subscribers[sub]; exists {
+		delete(sub.stream.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// publish sends an entry to every subscriber it matches
+func (s *eventStream) publish(entry AuditEntry) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.lastID++
+	event := Event{ID: s.lastID, AuditEntry: entry}
+	s.recent = append(s.recent, event)
+	if len(s.recent) > recentEventsSize {
+		s.recent = s.recent[len(s.recent)-recentEventsSize:]
+	}
+
+	for sub := range s.subscribers {
+		if !sub.filter.matches(&entry) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			slog.Debug("event dropped for a slow subscriber", "id", event.ID)
+		}
+	}
+}
+
+// matches reports whether an entry passes the filter
+func (f *EventFilter) matches(entry *AuditEntry) bool {
+	if f.SessionID != "" && entry.SessionID != f.SessionID {
+		return false
+	}
+	if f.FailuresOnly && entry.Result != AuditFailure {
+		return false
+	}
+	if len(f.Operations) == 0 {
+		return true
+	}
+	for _, operation := range f.Operations {
+		if entry.Operation == operation || strings.HasPrefix(entry.Operation, operation+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,508 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Fetch limits: the size a download may reach unless the request sets
+// another, up to MaxFetchSize, and how long it may take by default
+const (
+	DefaultFetchMaxSize = 1 << 30
+	MaxFetchSize        = 16 << 30
+	DefaultFetchTimeout = 30 * time.Minute
+)
+
+// fetchProgressInterval is how often a running fetch reports its progress,
+// maxFetchEvents how many of its events are kept for subscribers joining
+// late and maxFinishedFetches how many finished fetches are kept to query
+const (
+	fetchProgressInterval = time.Second
+	maxFetchEvents        = 1000
+	maxFinishedFetches    = 100
+)
+
+var (
+	// ErrFetchNotFound is returned for fetch IDs the session does not have
+	ErrFetchNotFound = errors.New("fetch not found")
+	// ErrFetchFailed is returned when the server cannot be reached or does
+	// not answer with the file, and when a fetch is canceled or times out
+	ErrFetchFailed = errors.New("fetch failed")
+	// ErrFetchTooLarge is returned for downloads larger than their limit
+	ErrFetchTooLarge = errors.New("download exceeds its size limit")
+	// ErrChecksumMismatch is returned when a download does not have the
+	// checksum the request expects
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// FetchRequest downloads an HTTP or HTTPS URL to a file
+type FetchRequest struct {
+	URL       string            `json:"url"`
+	Path      string            `json:"path"`              // An existing directory gets the file named as in the URL
+	SHA256    string            `json:"sha256,omitempty"`  // Expected checksum, hex encoded; a mismatch discards the download
+	MaxSize   int64             `json:"maxSize,omitempty"` // In bytes, DefaultFetchMaxSize by default
+	Timeout   int               `json:"timeout,omitempty"` // In seconds, DefaultFetchTimeout by default
+	Headers   map[string]string `json:"headers,omitempty"` // Sent with the request, such as Authorization; never recorded
+	Overwrite bool              `json:"overwrite"`         // Replace an existing file
+	Wait      bool              `json:"wait"`              // Respond once the download ends instead of once it starts
+}
+
+// Validate checks the URL, size limit, timeout and checksum of the request
+func (r *FetchRequest) Validate() error {
+	u, err := url.Parse(r.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url must be an http or https URL", ErrInvalidTarget)
+	}
+	if r.MaxSize < 0 || r.MaxSize > MaxFetchSize {
+		return fmt.Errorf("%w: maxSize must be between 0 and %d", ErrInvalidRange, int64(MaxFetchSize))
+	}
+	if r.Timeout < 0 {
+		return fmt.Errorf("%w: timeout cannot be negative", ErrInvalidRange)
+	}
+	if r.SHA256 != "" {
+		if sum, err := hex.DecodeString(r.SHA256); err != nil || len(sum) != sha256.Size {
+			return fmt.Errorf("%w: sha256 must be 64 hexadecimal characters", ErrInvalidTarget)
+		}
+	}
+	return nil
+}
+
+// FetchInfo describes a running or finished fetch
+type FetchInfo struct {
+	ID        string     `json:"id"`
+	URL       string     `json:"url"`
+	Path      string     `json:"path"`
+	StartTime time.Time  `json:"startTime"`
+	EndTime   *time.Time `json:"endTime,omitempty"`
+	IsRunning bool       `json:"isRunning"`
+	Bytes     int64      `json:"bytes"`            // Downloaded so far
+	Total     int64      `json:"total,omitempty"`  // When the server sends a Content-Length
+	SHA256    string     `json:"sha256,omitempty"` // Of the file written
+	Error     string     `json:"error,omitempty"`
+}
+
+// FetchEvent reports the progress of a fetch in the shape of the output
+// events of a terminal API process, so the same clients can follow both:
+// progress lines come as stdout, a failure as stderr, and the end as exit
+// with code 0 for success and 1 for failure
+type FetchEvent struct {
+	Type      string    `json:"type"` // stdout, stderr or exit
+	Line      string    `json:"line,omitempty"`
+	ExitCode  int       `json:"exitCode"`
+	Timestamp time.Time `json:"timestamp"`
+	Replay    bool      `json:"replay,omitempty"` // Sent before the subscriber connected
+}
+
+// FetchSubscription receives the events of a fetch until it ends. Replay
+// holds the events sent before it was made.
+type FetchSubscription struct {
+	Replay []FetchEvent
+	Events <-chan FetchEvent
+	events chan FetchEvent
+	job    *fetchJob
+}
+
+// Close stops the subscription
+func (sub *FetchSubscription) Close() {
+	sub.job.mutex.Lock()
+	defer sub.job.mutex.Unlock()
+	if _, exists := sub.job.subscribers[sub.events]; exists {
+		delete(sub.job.subscribers, sub.events)
+		close(sub.events)
+	}
+}
+
+// fetchJob is a fetch and the events it sent
+type fetchJob struct {
+	sessionID   string
+	mutex       sync.Mutex
+	info        FetchInfo
+	events      []FetchEvent
+	subscribers map[chan FetchEvent]struct{}
+	cancel      context.CancelFunc
+	done        chan struct{}
+	err         error // Why it failed, once done
+}
+
+// send records an event and passes it to the subscribers, dropping it for
+// those that fall behind. Callers must hold job.mutex.
+func (job *fetchJob) send(event FetchEvent) {
+	event.Timestamp = time.Now()
+	job.events = append(job.events, event)
+	if len(job.events) > maxFetchEvents {
+		job.events = job.events[len(job.events)-maxFetchEvents:]
+	}
+	for events := range job.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// progress reports how much has been downloaded
+func (job *fetchJob) progress() {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	line := formatSize(job.info.Bytes)
+	if job.info.Total > 0 {
+		line = fmt.Sprintf("%s of %s (%d%%)", line, formatSize(job.info.Total), job.info.Bytes*100/job.info.Total)
+	}
+	job.send(FetchEvent{Type: "stdout", Line: line})
+}
+
+// Write counts downloaded bytes
+func (job *fetchJob) Write(p []byte) (int, error) {
+	job.mutex.Lock()
+	job.info.Bytes += int64(len(p))
+	job.mutex.Unlock()
+	return len(p), nil
+}
+
+// FetchService downloads files in the background, keeping their progress
+// for clients to poll or stream
+type FetchService struct {
+	files   *FileService
+	client  *http.Client
+	mutex   sync.Mutex
+	fetches map[string]*fetchJob
+}
+
+func NewFetchService(sm *SessionManager) *FetchService {
+	return &FetchService{
+		files:   NewFileService(sm),
+		client:  &http.Client{},
+		fetches: make(map[string]*fetchJob),
+	}
+}
+
+// Fetch starts downloading a URL to a file, creating its parent
+// directories. The download goes to a temporary file renamed into place
+// once complete and matching the expected checksum, so a failed fetch
+// leaves any existing file as it was. A running fetch keeps its session
+// alive, and stops if the session ends. With Wait, Fetch returns once the
+// download ends, with its error if it failed.
+func (fs *FetchService) Fetch(sessionID string, req *FetchRequest) (*FetchInfo, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	fullPath, err := fs.files.GetFilePath(sessionID, req.Path)
+	if err != nil {
+		return nil, err
+	}
+	relativePath := req.Path
+	if info, err := os.Stat(fullPath); err == nil && info.IsDir() {
+		u, _ := url.Parse(req.URL)
+		name := path.Base(u.Path)
+		if name == "/" || name == "." {
+			return nil, fmt.Errorf("%w: the URL names no file, so path has to", ErrInvalidTarget)
+		}
+		relativePath = filepath.Join(req.Path, name)
+		if fullPath, err = fs.files.GetFilePath(sessionID, relativePath); err != nil {
+			return nil, err
+		}
+	}
+	if existing, err := os.Lstat(fullPath); err == nil {
+		if existing.IsDir() {
+			return nil, fmt.Errorf("%s: %w", relativePath, ErrIsDirectory)
+		}
+		if !req.Overwrite {
+			return nil, fmt.Errorf("%s: %w", relativePath, os.ErrExist)
+		}
+	}
+
+	timeout := DefaultFetchTimeout
+	if req.Timeout > 0 {
+		timeout = time.Duration(req.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	job := &fetchJob{
+		sessionID: sessionID,
+		info: FetchInfo{
+			ID:        uuid.New().String(),
+			URL:       req.URL,
+			Path:      relativePath,
+			StartTime: time.Now(),
+			IsRunning: true,
+		},
+		subscribers: make(map[chan FetchEvent]struct{}),
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+	fs.mutex.Lock()
+	fs.fetches[job.info.ID] = job
+	fs.prune()
+	fs.mutex.Unlock()
+
+	go fs.run(ctx, job, req, fullPath)
+
+	if req.Wait {
+		<-job.done
+	}
+	info, err := fs.Get(sessionID, job.info.ID)
+	if err == nil && req.Wait {
+		err = job.err
+	}
+	return info, err
+}
+
+// run downloads in the background, reporting progress until it ends
+func (fs *FetchService) run(ctx context.Context, job *fetchJob, req *FetchRequest, fullPath string) {
+	defer job.cancel()
+
+	stopProgress := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(fetchProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				// Keeps the session alive, and stops with it
+				if _, err := fs.files.GetFilePath(job.sessionID, job.info.Path); err != nil {
+					job.cancel()
+					return
+				}
+				job.progress()
+			case <-stopProgress:
+				return
+			}
+		}
+	}()
+
+	sum, err := fs.download(ctx, job, req, fullPath)
+	close(stopProgress)
+	if err != nil {
+		switch {
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			err = fmt.Errorf("%w: timed out", ErrFetchFailed)
+		case errors.Is(ctx.Err(), context.Canceled):
+			err = fmt.Errorf("%w: canceled", ErrFetchFailed)
+		}
+	}
+
+	job.mutex.Lock()
+	end := time.Now()
+	job.info.EndTime = &end
+	job.info.IsRunning = false
+	exitCode := 0
+	job.err = err
+	if err != nil {
+		job.info.Error = err.Error()
+		job.send(FetchEvent{Type: "stderr", Line: err.Error()})
+		exitCode = 1
+	} else {
+		job.info.SHA256 = sum
+		job.send(FetchEvent{Type: "stdout", Line: fmt.Sprintf("Saved %s to %s, sha256 %s", formatSize(job.info.Bytes), job.info.Path, sum)})
+	}
+	job.send(FetchEvent{Type: "exit", ExitCode: exitCode})
+	for events := range job.subscribers {
+		delete(job.subscribers, events)
+		close(events)
+	}
+	bytes := job.info.Bytes
+	job.mutex.Unlock()
+	close(job.done)
+
+	fs.files.sessionManager.Audit(job.sessionID, "file.fetch", job.info.Path, fmt.Sprintf("from %s: %d bytes", req.URL, bytes), err)
+	if err == nil {
+		fs.files.sessionManager.LogActivity(job.sessionID, ActivityEntry{
+			Category: ActivityFile,
+			Target:   job.info.Path,
+			Message:  fmt.Sprintf("Fetched %s to %s (%d bytes)", req.URL, job.info.Path, bytes),
+		})
+	}
+}
+
+// download writes the body of the URL to fullPath, returning its checksum
+func (fs *FetchService) download(ctx context.Context, job *fetchJob, req *FetchRequest, fullPath string) (string, error) {
+	maxSize := req.MaxSize
+	if maxSize == 0 {
+		maxSize = DefaultFetchMaxSize
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, req.URL, nil)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrFetchFailed, err)
+	}
+	for name, value := range req.Headers {
+		httpReq.Header.Set(name, value)
+	}
+	resp, err := fs.client.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrFetchFailed, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("%w: the server answered %s", ErrFetchFailed, resp.Status)
+	}
+	if resp.ContentLength > maxSize {
+		return "", fmt.Errorf("%w: %d bytes, over %d", ErrFetchTooLarge, resp.ContentLength, maxSize)
+	}
+
+	job.mutex.Lock()
+	if resp.ContentLength > 0 {
+		job.info.Total = resp.ContentLength
+	}
+	job.mutex.Unlock()
+	job.progress()
+
+	dir := filepath.Dir(fullPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(fullPath)+".fetch-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(tmp, hash, job), io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("%w: %v", ErrFetchFailed, err)
+	}
+	if written > maxSize {
+		tmp.Close()
+		return "", fmt.Errorf("%w: over %d bytes", ErrFetchTooLarge, maxSize)
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if req.SHA256 != "" && !strings.EqualFold(sum, req.SHA256) {
+		tmp.Close()
+		return "", fmt.Errorf("%w: expected %s, got %s", ErrChecksumMismatch, strings.ToLower(req.SHA256), sum)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return sum, os.Rename(tmp.Name(), fullPath)
+}
+
+// prune forgets the oldest finished fetches beyond maxFinishedFetches.
+// Callers must hold fs.mutex.
+func (fs *FetchService) prune() {
+	var finished []*fetchJob
+	for _, job := range fs.fetches {
+		job.mutex.Lock()
+		if !job.info.IsRunning {
+			finished = append(finished, job)
+		}
+		job.mutex.Unlock()
+	}
+	if len(finished) <= maxFinishedFetches {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].info.StartTime.Before(finished[j].info.StartTime)
+	})
+	for _, job := range finished[:len(finished)-maxFinishedFetches] {
+		delete(fs.fetches, job.info.ID)
+	}
+}
+
+// job returns a fetch of the session
+func (fs *FetchService) job(sessionID string, fetchID string) (*fetchJob, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	job, exists := fs.fetches[fetchID]
+	if !exists || job.sessionID != sessionID {
+		return nil, fmt.Errorf("%w: %s", ErrFetchNotFound, fetchID)
+	}
+	return job, nil
+}
+
+// Get returns the state of a fetch
+func (fs *FetchService) Get(sessionID string, fetchID string) (*FetchInfo, error) {
+	job, err := fs.job(sessionID, fetchID)
+	if err != nil {
+		return nil, err
+	}
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	info := job.info
+	return &info, nil
+}
+
+// List returns the running and recently finished fetches of a session,
+// oldest first
+func (fs *FetchService) List(sessionID string) []FetchInfo {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fetches := []FetchInfo{}
+	for _, job := range fs.fetches {
+		if job.sessionID != sessionID {
+			continue
+		}
+		job.mutex.Lock()
+		fetches = append(fetches, job.info)
+		job.mutex.Unlock()
+	}
+	sort.Slice(fetches, func(i, j int) bool {
+		return fetches[i].StartTime.Before(fetches[j].StartTime)
+	})
+	return fetches
+}
+
+// Cancel stops a running fetch, discarding what it downloaded
+func (fs *FetchService) Cancel(sessionID string, fetchID string) error {
+	job, err := fs.job(sessionID, fetchID)
+	if err != nil {
+		return err
+	}
+	job.cancel()
+	<-job.done
+	return nil
+}
+
+// Subscribe streams the events of a fetch. The subscription's channel is
+// closed once the fetch ends, at once for a fetch that already has.
+func (fs *FetchService) Subscribe(sessionID string, fetchID string) (*FetchSubscription, error) {
+	job, err := fs.job(sessionID, fetchID)
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan FetchEvent, eventBufferSize)
+	sub := &FetchSubscription{Events: events, events: events, job: job}
+
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	sub.Replay = append([]FetchEvent(nil), job.events...)
+	if job.info.IsRunning {
+		job.subscribers[events] = struct{}{}
+	} else {
+		close(events)
+	}
+	return sub, nil
+}
+
+// formatSize formats a number of bytes for people, such as 12.5 MiB
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
@@ -1,8 +1,13 @@
 package services
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"io/ioutil"
 	"os"
@@ -19,6 +24,22 @@ type FileMetadata struct {
 	IsDir        bool      `json:"isDir"`
 	ContentType  string    `json:"contentType,omitempty"`
 	Permissions  string    `json:"permissions"`
+	IsSymlink    bool      `json:"isSymlink,omitempty"`
+	LinkTarget   string    `json:"linkTarget,omitempty"` // Where a symlink points, as stored in it
+	// Sniffed from the content of a single file's metadata, not listings
+	IsBinary     *bool     `json:"isBinary,omitempty"`
+	MimeType     string    `json:"mimeType,omitempty"`
+	LineEndings  string    `json:"lineEndings,omitempty"` // lf, crlf or mixed, of text files with line breaks
+}
+
+// ErrIsDirectory is returned for file operations on a directory
+var ErrIsDirectory = errors.New("path is a directory")
+
+// UploadResult describes a file written by an upload
+type UploadResult struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"` // Of the bytes written, to check them against the source
 }
 
 type FileService struct {
@@ -38,55 +59,48 @@ func NewFileService(sm *SessionManager) *FileService {
 	}
 }
 
+// GetFilePath resolves a path inside the session working directory; paths
+// leading outside it fail with a PathEscapeError
 func (fs *FileService) GetFilePath(sessionID string, relativePath string) (string, error) {
-	session, err := fs.sessionManager.GetSession(sessionID)
-	if err != nil {
-		return "", err
-	}
-	
-	if session.WorkingDir == "" {
-		return "", fmt.Errorf("working directory not set for session %s", sessionID)
-	}
-	
-	return filepath.Join(session.WorkingDir, relativePath), nil
+	return fs.sessionManager.ResolvePath(sessionID, relativePath)
 }
 
-func (fs *FileService) ListFiles(sessionID string, relativePath string) ([]string, error) {
-	fullPath, err := fs.GetFilePath(sessionID, relativePath)
-	if err != nil {
-		return nil, err
-	}
-	
-	files, err := ioutil.ReadDir(fullPath)
+// ListFiles lists the names of the files in a directory. Symlinks are
+// listed as files unless followSymlinks, which lists those leading to a
+// directory inside the working directory with the directories instead.
+func (fs *FileService) ListFiles(sessionID string, relativePath string, followSymlinks bool) ([]string, error) {
+	entries, err := fs.sessionManager.listDir(sessionID, relativePath, followSymlinks)
 	if err != nil {
 		return nil, err
 	}
 	
 	var fileNames []string
-	for _, file := range files {
-		if !file.IsDir() {
-			fileNames = append(fileNames, file.Name())
+	for _, entry := range entries {
+		if !entry.Info.IsDir() {
+			fileNames = append(fileNames, entry.Info.Name())
 		}
 	}
 	
-	fs.sessionManager.LogActivity(sessionID, fmt.Sprintf("Listed %d files in %s", len(fileNames), relativePath))
-	fmt.Printf("[TERMINAL] Session %s: Listed %d files in %s\n", sessionID, len(fileNames), relativePath)
+	fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityDirectory,
+		Target:   relativePath,
+		Message:  fmt.Sprintf("Listed %d files in %s", len(fileNames), relativePath),
+	})
+	fs.sessionManager.Audit(sessionID, "file.list", relativePath, fmt.Sprintf("%d files", len(fileNames)), nil)
 	return fileNames, nil
 }
 
-func (fs *FileService) ListFilesWithMetadata(sessionID string, relativePath string) ([]FileMetadata, error) {
-	fullPath, err := fs.GetFilePath(sessionID, relativePath)
-	if err != nil {
-		return nil, err
-	}
-	
-	files, err := ioutil.ReadDir(fullPath)
+// ListFilesWithMetadata lists the files in a directory as ListFiles does,
+// with their metadata. Followed symlinks report what they point to.
+func (fs *FileService) ListFilesWithMetadata(sessionID string, relativePath string, followSymlinks bool) ([]FileMetadata, error) {
+	entries, err := fs.sessionManager.listDir(sessionID, relativePath, followSymlinks)
 	if err != nil {
 		return nil, err
 	}
 	
 	var fileMetadata []FileMetadata
-	for _, file := range files {
+	for _, entry := range entries {
+		file := entry.Info
 		if !file.IsDir() {
 			meta := FileMetadata{
 				Name:     file.Name(),
@@ -95,6 +109,8 @@ func (fs *FileService) ListFilesWithMetadata(sessionID string, relativePath stri
 				ModTime:  file.ModTime(),
 				IsDir:    file.IsDir(),
 				Permissions: fs.formatPermissions(file.Mode()),
+				IsSymlink:   entry.IsSymlink,
+				LinkTarget:  entry.LinkTarget,
 			}
 			
 			// Try to determine content type (simple implementation)
@@ -106,10 +122,12 @@ func (fs *FileService) ListFilesWithMetadata(sessionID string, relativePath stri
 		}
 	}
 	
-	fs.sessionManager.LogActivity(sessionID, fmt.Sprintf("Listed %d files with metadata in %s", 
-		len(fileMetadata), relativePath))
-	fmt.Printf("[TERMINAL] Session %s: Listed %d files with metadata in %s\n", 
-		sessionID, len(fileMetadata), relativePath)
+	fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityDirectory,
+		Target:   relativePath,
+		Message:  fmt.Sprintf("Listed %d files with metadata in %s", len(fileMetadata), relativePath),
+	})
+	fs.sessionManager.Audit(sessionID, "file.list", relativePath, fmt.Sprintf("%d files with metadata", len(fileMetadata)), nil)
 	return fileMetadata, nil
 }
 
@@ -142,29 +160,85 @@ func (fs *FileService) getContentTypeByExt(ext string) string {
 	}
 }
 
-func (fs *FileService) ReadFile(sessionID string, relativePath string) ([]byte, error) {
-	fullPath, err := fs.GetFilePath(sessionID, relativePath)
+func (fs *FileService) ReadFile(sessionID string, relativePath string) (content []byte, err error) {
+	defer func() {
+		fs.sessionManager.Audit(sessionID, "file.read", relativePath, "", err)
+	}()
+	
+	remote, err := fs.sessionManager.remoteFiles(sessionID)
 	if err != nil {
 		return nil, err
 	}
-	
-	content, err := ioutil.ReadFile(fullPath)
+	if remote != nil {
+		content, err = remote.readFile(relativePath)
+	} else {
+		var fullPath string
+		if fullPath, err = fs.GetFilePath(sessionID, relativePath); err != nil {
+			return nil, err
+		}
+		content, err = ioutil.ReadFile(fullPath)
+	}
 	if err != nil {
 		return nil, err
 	}
 	
-	fs.sessionManager.LogActivity(sessionID, fmt.Sprintf("Read file %s", relativePath))
-	fmt.Printf("[TERMINAL] Session %s: Read file %s\n", sessionID, relativePath)
+	fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityFile,
+		Target:   relativePath,
+		Message:  "Read file " + relativePath,
+	})
 	return content, nil
 }
 
-func (fs *FileService) GetFileMetadata(sessionID string, relativePath string) (*FileMetadata, error) {
-	fullPath, err := fs.GetFilePath(sessionID, relativePath)
+// ReadTextFile reads a file as ReadFile does, refusing binary files, which
+// would not survive being sent as text
+func (fs *FileService) ReadTextFile(sessionID string, relativePath string) ([]byte, error) {
+	content, err := fs.ReadFile(sessionID, relativePath)
 	if err != nil {
 		return nil, err
 	}
+	if binary, _ := sniffContent(content, false); binary {
+		return nil, fmt.Errorf("%w: %s", ErrBinaryFile, relativePath)
+	}
+	return content, nil
+}
+
+// OpenFile opens a file to stream its content, returning its information
+// with it. The caller closes the file.
+func (fs *FileService) OpenFile(sessionID string, relativePath string) (file *os.File, info os.FileInfo, err error) {
+	defer func() {
+		fs.sessionManager.Audit(sessionID, "file.download", relativePath, "", err)
+	}()
 	
-	fileInfo, err := os.Stat(fullPath)
+	fullPath, err := fs.GetFilePath(sessionID, relativePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	
+	file, err = os.Open(fullPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err = file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if info.IsDir() {
+		file.Close()
+		return nil, nil, fmt.Errorf("%w: %s", ErrIsDirectory, relativePath)
+	}
+	
+	fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityFile,
+		Target:   relativePath,
+		Message:  "Downloaded file " + relativePath,
+	})
+	return file, info, nil
+}
+
+func (fs *FileService) GetFileMetadata(sessionID string, relativePath string) (*FileMetadata, error) {
+	fileInfo, linkInfo, linkTarget, err := fs.statFile(sessionID, relativePath)
 	if err != nil {
 		return nil, err
 	}
@@ -177,66 +251,310 @@ func (fs *FileService) GetFileMetadata(sessionID string, relativePath string) (*
 		IsDir:    fileInfo.IsDir(),
 		Permissions: fs.formatPermissions(fileInfo.Mode()),
 	}
+	if linkInfo.Mode()&os.ModeSymlink != 0 {
+		meta.IsSymlink = true
+		meta.LinkTarget = linkTarget
+	}
 	
 	if ext := filepath.Ext(fileInfo.Name()); ext != "" {
 		meta.ContentType = fs.getContentTypeByExt(ext)
 	}
+	// Files of remote sessions are not read for their type
+	if !fileInfo.IsDir() {
+		if fullPath, err := fs.GetFilePath(sessionID, relativePath); err == nil {
+			if binary, mimeType, err := sniffFile(fullPath); err == nil {
+				meta.IsBinary, meta.MimeType = &binary, mimeType
+				if !binary {
+					meta.LineEndings, _ = fileLineEndings(fullPath)
+				}
+			}
+		}
+	}
 	
-	fs.sessionManager.LogActivity(sessionID, fmt.Sprintf("Retrieved metadata for %s", relativePath))
-	fmt.Printf("[TERMINAL] Session %s: Retrieved metadata for %s\n", sessionID, relativePath)
+	fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityFile,
+		Target:   relativePath,
+		Message:  "Retrieved metadata for " + relativePath,
+	})
+	fs.sessionManager.Audit(sessionID, "file.metadata", relativePath, "", nil)
 	return meta, nil
 }
 
-func (fs *FileService) CreateFile(sessionID string, relativePath string, content []byte) error {
-	fullPath, err := fs.GetFilePath(sessionID, relativePath)
+// CreateFile writes a file, creating its parent directories, and replacing
+// any file there atomically unless opts.InPlace
+func (fs *FileService) CreateFile(sessionID string, relativePath string, content []byte, opts WriteOptions) (err error) {
+	defer func() {
+		fs.sessionManager.Audit(sessionID, "file.create", relativePath, "", err)
+	}()
+	
+	remote, err := fs.sessionManager.remoteFiles(sessionID)
 	if err != nil {
 		return err
 	}
+	if remote != nil {
+		content = opts.withLineEndings(content, func() ([]byte, error) { return remote.readFile(relativePath) })
+		if _, err := remote.writeFile(relativePath, bytes.NewReader(content), true); err != nil {
+			return err
+		}
+	} else {
+		fullPath, err := fs.GetFilePath(sessionID, relativePath)
+		if err != nil {
+			return err
+		}
+		content = opts.withLineEndings(content, func() ([]byte, error) { return os.ReadFile(fullPath) })
+		
+		// Make sure parent directory exists
+		dir := filepath.Dir(fullPath)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		
+		// Create the file
+		if _, err := fs.sessionManager.beforeWrite(sessionID, fullPath, opts.Backup); err != nil {
+			return fmt.Errorf("failed to back up %s: %w", relativePath, err)
+		}
+		if err := writeFileContent(fullPath, content, 0644, opts.InPlace); err != nil {
+			return err
+		}
+		fs.sessionManager.afterWrite(sessionID, fullPath, "create")
+	}
+	
+	fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityFile,
+		Target:   relativePath,
+		Message:  "Created file " + relativePath,
+	})
+	return nil
+}
+
+// UploadFile writes a file with the bytes read from r as they are, creating
+// its parent directories. The bytes go to a temporary file next to it that
+// is renamed into place once r is read, so a failed or interrupted upload
+// leaves any existing file untouched.
+func (fs *FileService) UploadFile(sessionID string, relativePath string, r io.Reader) (result *UploadResult, err error) {
+	defer func() {
+		detail := ""
+		if result != nil {
+			detail = fmt.Sprintf("%d bytes", result.Size)
+		}
+		fs.sessionManager.Audit(sessionID, "file.upload", relativePath, detail, err)
+	}()
+	
+	remote, err := fs.sessionManager.remoteFiles(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if remote != nil {
+		return fs.uploadRemoteFile(sessionID, remote, relativePath, r)
+	}
+	
+	fullPath, err := fs.GetFilePath(sessionID, relativePath)
+	if err != nil {
+		return nil, err
+	}
 	
-	// Make sure parent directory exists
 	dir := filepath.Dir(fullPath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
+		return nil, err
 	}
 	
-	// Create the file
-	if err := ioutil.WriteFile(fullPath, content, 0644); err != nil {
-		return err
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(fullPath)+".upload-*")
+	if err != nil {
+		return nil, err
 	}
+	defer os.Remove(tmp.Name())
 	
-	fs.sessionManager.LogActivity(sessionID, fmt.Sprintf("Created file %s", relativePath))
-	fmt.Printf("[TERMINAL] Session %s: Created file %s\n", sessionID, relativePath)
-	return nil
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if _, err := fs.sessionManager.beforeWrite(sessionID, fullPath, false); err != nil {
+		return nil, fmt.Errorf("failed to back up %s: %w", relativePath, err)
+	}
+	if err := os.Rename(tmp.Name(), fullPath); err != nil {
+		return nil, err
+	}
+	fs.sessionManager.afterWrite(sessionID, fullPath, "upload")
+	
+	fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityFile,
+		Target:   relativePath,
+		Message:  fmt.Sprintf("Uploaded file %s (%d bytes)", relativePath, size),
+	})
+	return &UploadResult{
+		Path:   relativePath,
+		Size:   size,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
 }
 
-func (fs *FileService) UpdateFile(sessionID string, relativePath string, content []byte) error {
-	fullPath, err := fs.GetFilePath(sessionID, relativePath)
+// uploadRemoteFile is UploadFile for a remote session, whose file is
+// written over SFTP the same way
+func (fs *FileService) uploadRemoteFile(sessionID string, remote *remoteFS, relativePath string, r io.Reader) (*UploadResult, error) {
+	hash := sha256.New()
+	size, err := remote.writeFile(relativePath, io.TeeReader(r, hash), true)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	
-	if err := ioutil.WriteFile(fullPath, content, 0644); err != nil {
-		return err
+	fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityFile,
+		Target:   relativePath,
+		Message:  fmt.Sprintf("Uploaded file %s (%d bytes) to %s", relativePath, size, remote.target),
+	})
+	return &UploadResult{
+		Path:   relativePath,
+		Size:   size,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// statFile returns the information of a file, what it points to when it is
+// a symlink, or the link itself when that is missing, and the link's target
+func (fs *FileService) statFile(sessionID string, relativePath string) (os.FileInfo, os.FileInfo, string, error) {
+	remote, err := fs.sessionManager.remoteFiles(sessionID)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if remote != nil {
+		return remote.stat(relativePath)
 	}
 	
-	fs.sessionManager.LogActivity(sessionID, fmt.Sprintf("Updated file %s", relativePath))
-	fmt.Printf("[TERMINAL] Session %s: Updated file %s\n", sessionID, relativePath)
-	return nil
+	fullPath, err := fs.GetFilePath(sessionID, relativePath)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	linkInfo, err := os.Lstat(fullPath)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	fileInfo, err := os.Stat(fullPath)
+	if err != nil {
+		fileInfo = linkInfo
+	}
+	target := ""
+	if linkInfo.Mode()&os.ModeSymlink != 0 {
+		target, _ = os.Readlink(fullPath)
+	}
+	return fileInfo, linkInfo, target, nil
 }
 
-func (fs *FileService) DeleteFile(sessionID string, relativePath string) error {
-	fullPath, err := fs.GetFilePath(sessionID, relativePath)
+// UpdateFile overwrites a file, atomically unless opts.InPlace, first
+// keeping its content as a version when opts.Backup is set or the session
+// or server back up every write, and returns that version
+func (fs *FileService) UpdateFile(sessionID string, relativePath string, content []byte, opts WriteOptions) (*FileVersion, error) {
+	return fs.updateFile(sessionID, relativePath, content, opts, "update")
+}
+
+// updateFile overwrites a file for operation, which names what replaced
+// the backed up content
+func (fs *FileService) updateFile(sessionID string, relativePath string, content []byte, opts WriteOptions, operation string) (version *FileVersion, err error) {
+	defer func() {
+		detail := ""
+		if version != nil {
+			detail = fmt.Sprintf("backup version %d", version.Version)
+		}
+		fs.sessionManager.Audit(sessionID, "file.update", relativePath, detail, err)
+	}()
+	
+	remote, err := fs.sessionManager.remoteFiles(sessionID)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if remote != nil {
+		if opts.Backup {
+			return nil, fmt.Errorf("backups are %w", ErrRemoteUnsupported)
+		}
+		content = opts.withLineEndings(content, func() ([]byte, error) { return remote.readFile(relativePath) })
+		if _, err := remote.writeFile(relativePath, bytes.NewReader(content), false); err != nil {
+			return nil, err
+		}
+	} else {
+		fullPath, err := fs.GetFilePath(sessionID, relativePath)
+		if err != nil {
+			return nil, err
+		}
+		
+		content = opts.withLineEndings(content, func() ([]byte, error) { return os.ReadFile(fullPath) })
+		if version, err = fs.sessionManager.beforeWrite(sessionID, fullPath, opts.Backup); err != nil {
+			return nil, fmt.Errorf("failed to back up %s: %w", relativePath, err)
+		}
+		if err := writeFileContent(fullPath, content, 0644, opts.InPlace); err != nil {
+			return nil, err
+		}
+		fs.sessionManager.afterWrite(sessionID, fullPath, operation)
 	}
 	
-	if err := os.Remove(fullPath); err != nil {
-		return err
+	fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityFile,
+		Target:   relativePath,
+		Message:  "Updated file " + relativePath,
+	})
+	return version, nil
+}
+
+// DeleteFile deletes a file, moving it to the session's trash unless
+// permanent or the trash is off, and returns its trash entry. Files of
+// remote sessions are always deleted permanently.
+func (fs *FileService) DeleteFile(sessionID string, relativePath string, permanent bool) (entry *TrashEntry, err error) {
+	defer func() {
+		detail := "permanent"
+		if entry != nil {
+			detail = "trash " + entry.ID
+		}
+		fs.sessionManager.Audit(sessionID, "file.delete", relativePath, detail, err)
+	}()
+	
+	remote, err := fs.sessionManager.remoteFiles(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if remote != nil {
+		if err := remote.remove(relativePath, false); err != nil {
+			return nil, err
+		}
+	} else {
+		fullPath, err := fs.GetFilePath(sessionID, relativePath)
+		if err != nil {
+			return nil, err
+		}
+		
+		if !permanent {
+			// The trash takes whole directories, which only the directory
+			// endpoints delete
+			if info, err := os.Lstat(fullPath); err == nil && info.IsDir() {
+				return nil, fmt.Errorf("%w: %s", ErrIsDirectory, relativePath)
+			}
+			if entry, err = fs.sessionManager.moveToTrash(sessionID, relativePath, fullPath); err != nil {
+				return nil, err
+			}
+		}
+		if entry == nil {
+			if err := os.Remove(fullPath); err != nil {
+				return nil, err
+			}
+		}
 	}
 	
-	fs.sessionManager.LogActivity(sessionID, fmt.Sprintf("Deleted file %s", relativePath))
-	fmt.Printf("[TERMINAL] Session %s: Deleted file %s\n", sessionID, relativePath)
-	return nil
+	message := "Deleted file " + relativePath
+	if entry != nil {
+		message = "Moved file " + relativePath + " to the trash"
+	}
+	fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityFile,
+		Target:   relativePath,
+		Message:  message,
+	})
+	return entry, nil
 }
 
 // Batch operations
@@ -246,7 +564,7 @@ func (fs *FileService) BatchReadFiles(sessionID string, relativePaths []string)
 	for _, path := range relativePaths {
 		result := BatchResult{Path: path}
 		
-		content, err := fs.ReadFile(sessionID, path)
+		content, err := fs.ReadTextFile(sessionID, path)
 		if err != nil {
 			result.Success = false
 			result.Error = err.Error()
@@ -258,8 +576,10 @@ func (fs *FileService) BatchReadFiles(sessionID string, relativePaths []string)
 		results = append(results, result)
 	}
 	
-	fs.sessionManager.LogActivity(sessionID, fmt.Sprintf("Batch read %d files", len(relativePaths)))
-	fmt.Printf("[TERMINAL] Session %s: Batch read %d files\n", sessionID, len(relativePaths))
+	fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityFile,
+		Message:  fmt.Sprintf("Batch read %d files", len(relativePaths)),
+	})
 	return results
 }
 
@@ -269,7 +589,7 @@ func (fs *FileService) BatchCreateFiles(sessionID string, files map[string]strin
 	for path, content := range files {
 		result := BatchResult{Path: path}
 		
-		err := fs.CreateFile(sessionID, path, []byte(content))
+		err := fs.CreateFile(sessionID, path, []byte(content), WriteOptions{})
 		if err != nil {
 			result.Success = false
 			result.Error = err.Error()
@@ -280,80 +600,11 @@ func (fs *FileService) BatchCreateFiles(sessionID string, files map[string]strin
 		results = append(results, result)
 	}
 	
-	fs.sessionManager.LogActivity(sessionID, fmt.Sprintf("Batch created %d files", len(files)))
-	fmt.Printf("[TERMINAL] Session %s: Batch created %d files\n", sessionID, len(files))
-	return results
-}
-
-func (fs *FileService) SearchInFiles(sessionID string, dir string, pattern string, recursive bool) (map[string][]string, error) {
-	fullPath, err := fs.GetFilePath(sessionID, dir)
-	if err != nil {
-		return nil, err
-	}
-	
-	results := make(map[string][]string)
-	
-	err = filepath.Walk(fullPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil // Skip files with errors, don't abort the entire walk
-		}
-		
-		// Handle directories based on recursive flag
-		if info.IsDir() {
-			// Skip subdirectories if not recursive and we're not at the root directory
-			if !recursive && path != fullPath {
-				return filepath.SkipDir
-			}
-			return nil // Continue to next entry
-		}
-		
-		// Get relative path from the search root directory
-		relPath, err := filepath.Rel(fullPath, path)
-		if err != nil {
-			return nil // Skip files with path issues
-		}
-		
-		// First check if the filename matches the pattern - this addresses the b.txt issue
-		if strings.Contains(strings.ToLower(info.Name()), strings.ToLower(pattern)) {
-			// File name matches, add an entry with a note that the name matched
-			results[relPath] = []string{"[Filename matches search pattern]"}
-			return nil // No need to check content if filename already matches
-		}
-		
-		// If filename doesn't match, check the content
-		content, err := ioutil.ReadFile(path)
-		if err != nil {
-			return nil // Skip files we can't read
-		}
-		
-		if strings.Contains(string(content), pattern) {
-			// Found match in content - add lines containing the pattern
-			lines := strings.Split(string(content), "\n")
-			var matches []string
-			
-			for _, line := range lines {
-				if strings.Contains(line, pattern) {
-					matches = append(matches, line)
-				}
-			}
-			
-			// Store matches against the relative path
-			results[relPath] = matches
-		}
-		
-		return nil
+	fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityFile,
+		Message:  fmt.Sprintf("Batch created %d files", len(files)),
 	})
-	
-	// Only return error if it's critical - not finding any matches is not an error
-	if err != nil && err != filepath.SkipDir {
-		return nil, err
-	}
-	
-	fs.sessionManager.LogActivity(sessionID, fmt.Sprintf("Searched for pattern '%s' in %s, found %d matching files", pattern, dir, len(results)))
-	fmt.Printf("[TERMINAL] Session %s: Searched for pattern '%s' in %s, found %d matching files\n", 
-		sessionID, pattern, dir, len(results))
-	
-	return results, nil
+	return results
 }
 
 // Export file structure as JSON
@@ -375,8 +626,12 @@ func (fs *FileService) ExportFileStructure(sessionID string, dir string, depth i
 		return "", err
 	}
 	
-	fs.sessionManager.LogActivity(sessionID, fmt.Sprintf("Exported file structure for %s", dir))
-	fmt.Printf("[TERMINAL] Session %s: Exported file structure for %s\n", sessionID, dir)
+	fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityDirectory,
+		Target:   dir,
+		Message:  "Exported file structure for " + dir,
+	})
+	fs.sessionManager.Audit(sessionID, "file.structure", dir, "", nil)
 	
 	return string(jsonData), nil
 }
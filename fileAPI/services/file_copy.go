@@ -0,0 +1,265 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// CopyRequest copies a file, or a directory with what it holds. Include
+// and Exclude are glob patterns, as in filepath.Match, matched against
+// the path of each entry below the source and against its name. Excluded
+// entries, and the directories they match with everything in them, are
+// left out; with Include, only the files it matches are copied.
+type CopyRequest struct {
+	Source      string   `json:"source"`
+	Destination string   `json:"destination"`
+	Overwrite   bool     `json:"overwrite"` // Replace existing files in the destination
+	Include     []string `json:"include,omitempty"`
+	Exclude     []string `json:"exclude,omitempty"`
+}
+
+// CopyResult lists the outcome of copying each file and symlink. Files
+// that exist in the destination fail without Overwrite, but do not stop
+// the copy.
+type CopyResult struct {
+	Source      string        `json:"source"`
+	Destination string        `json:"destination"`
+	Copied      int           `json:"copied"`
+	Failed      int           `json:"failed"`
+	Results     []BatchResult `json:"results"` // Path is the source, Result the destination
+}
+
+// Copy copies a file or directory, creating the destination's parent
+// directories and keeping permissions and modification times. A directory
+// copied onto an existing one merges into it.
+func (fs *FileService) Copy(sessionID string, req *CopyRequest) (result *CopyResult, err error) {
+	defer func() {
+		detail := "to " + req.Destination
+		if result != nil {
+			detail += fmt.Sprintf(": %d copied, %d failed", result.Copied, result.Failed)
+		}
+		fs.sessionManager.Audit(sessionID, "file.copy", req.Source, detail, err)
+	}()
+
+	for _, pattern := range append(append([]string{}, req.Include...), req.Exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%w: invalid pattern %q", ErrInvalidTarget, pattern)
+		}
+	}
+	sourcePath, destinationPath, info, err := fs.transferPaths(sessionID, req.Source, req.Destination)
+	if err != nil {
+		return nil, err
+	}
+
+	result = &CopyResult{
+		Source:      req.Source,
+		Destination: req.Destination,
+		Results:     []BatchResult{},
+	}
+	record := func(rel string, err error) {
+		entry := BatchResult{
+			Path:    filepath.Join(req.Source, rel),
+			Success: err == nil,
+			Result:  filepath.Join(req.Destination, rel),
+		}
+		if err != nil {
+			entry.Error = err.Error()
+			result.Failed++
+		} else {
+			result.Copied++
+		}
+		result.Results = append(result.Results, entry)
+	}
+
+	if !info.IsDir() {
+		record(".", copyEntry(sourcePath, destinationPath, info, req.Overwrite))
+	} else if err := copyDirectory(sourcePath, destinationPath, req, record); err != nil {
+		return nil, err
+	}
+
+	fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: transferCategory(info),
+		Target:   req.Source,
+		Message:  fmt.Sprintf("Copied %s to %s (%d copied, %d failed)", req.Source, req.Destination, result.Copied, result.Failed),
+	})
+	return result, nil
+}
+
+// copyDirectory copies what a directory holds into destination, passing
+// the outcome of each file and symlink to record
+func copyDirectory(source string, destination string, req *CopyRequest, record func(rel string, err error)) error {
+	// Directories get their permissions and times once their content is in
+	var directories []string
+	err := filepath.WalkDir(source, func(path string, entry fs.DirEntry, err error) error {
+		rel, relErr := filepath.Rel(source, path)
+		if relErr != nil {
+			return relErr
+		}
+		if err != nil {
+			// An unreadable directory fails on its own, leaving the rest
+			if rel == "." {
+				return err
+			}
+			record(rel, err)
+			return nil
+		}
+		if rel != "." && matchesAny(req.Exclude, rel) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		target := filepath.Join(destination, rel)
+		if entry.IsDir() {
+			// With Include, directories are made only for the files copied
+			if len(req.Include) == 0 || rel == "." {
+				if err := os.MkdirAll(target, 0755); err != nil {
+					return err
+				}
+				directories = append(directories, rel)
+			}
+			return nil
+		}
+		if len(req.Include) > 0 && !matchesAny(req.Include, rel) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			record(rel, err)
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			record(rel, err)
+			return nil
+		}
+		record(rel, copyEntry(path, target, info, req.Overwrite))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := len(directories) - 1; i >= 0; i-- {
+		info, err := os.Stat(filepath.Join(source, directories[i]))
+		if err != nil {
+			continue
+		}
+		target := filepath.Join(destination, directories[i])
+		os.Chmod(target, info.Mode().Perm())
+		os.Chtimes(target, info.ModTime(), info.ModTime())
+	}
+	return nil
+}
+
+// matchesAny reports whether any pattern matches the path below the copied
+// directory or its name
+func matchesAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, rel); matched {
+			return true
+		}
+		if matched, _ := filepath.Match(pattern, filepath.Base(rel)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// copyEntry copies a file or symlink, replacing an existing one only with
+// overwrite
+func copyEntry(source string, destination string, info os.FileInfo, overwrite bool) error {
+	existing, err := os.Lstat(destination)
+	if err == nil {
+		if !overwrite {
+			return fmt.Errorf("%s: %w", filepath.Base(destination), os.ErrExist)
+		}
+		if existing.IsDir() {
+			return fmt.Errorf("%w: %s is a directory", ErrInvalidTarget, filepath.Base(destination))
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if err := os.Remove(destination); err != nil {
+				return err
+			}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return copyTree(source, destination)
+}
+
+// copyTree copies a file, symlink or directory with everything in it,
+// keeping permissions and modification times. Symlinks are copied as links.
+// A file replaces an existing file atomically.
+func copyTree(source string, destination string) error {
+	info, err := os.Lstat(source)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(source)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, destination)
+	case info.IsDir():
+		// Writable until its content is in, whatever its permissions
+		if err := os.Mkdir(destination, 0700); err != nil {
+			return err
+		}
+		entries, err := os.ReadDir(source)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := copyTree(filepath.Join(source, entry.Name()), filepath.Join(destination, entry.Name())); err != nil {
+				return err
+			}
+		}
+		if err := os.Chmod(destination, info.Mode().Perm()); err != nil {
+			return err
+		}
+	case info.Mode().IsRegular():
+		if err := copyFile(source, destination, info.Mode().Perm()); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: %s is not a regular file, directory or symlink", ErrInvalidTarget, filepath.Base(source))
+	}
+	return os.Chtimes(destination, info.ModTime(), info.ModTime())
+}
+
+// copyFile copies the content of a regular file to destination with perm,
+// through a temporary file renamed into place
+func copyFile(source string, destination string, perm os.FileMode) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(destination), "."+filepath.Base(destination)+".copy-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Chmod(perm); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), destination)
+}
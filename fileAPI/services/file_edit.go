@@ -0,0 +1,213 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// ErrEditFailed is returned for an edit with an operation that does not
+// apply, such as a line past the end of the file or a pattern that matches
+// nothing
+var ErrEditFailed = errors.New("edit failed")
+
+// Operations of a line-based edit
+const (
+	EditInsertAfterLine = "insertAfterLine"
+	EditReplaceLines    = "replaceLines"
+	EditDeleteLines     = "deleteLines"
+	EditReplaceFirst    = "replaceFirst"
+	EditReplaceAll      = "replaceAll"
+)
+
+// EditOperation is one change of an edit. Lines are counted from 1, in the
+// file as the operations before it left it.
+type EditOperation struct {
+	Op          string `json:"op"`                    // insertAfterLine, replaceLines, deleteLines, replaceFirst or replaceAll
+	Line        int    `json:"line,omitempty"`        // For insertAfterLine; 0 inserts before the first line
+	Start       int    `json:"start,omitempty"`       // For replaceLines and deleteLines
+	End         int    `json:"end,omitempty"`         // Inclusive, Start when omitted
+	Text        string `json:"text,omitempty"`        // Lines to insert, or to replace with
+	Pattern     string `json:"pattern,omitempty"`     // Regular expression for replaceFirst and replaceAll
+	Replacement string `json:"replacement,omitempty"` // May refer to groups of the pattern as $1 or ${name}
+}
+
+// EditRequest is a list of operations applied to a file in order, all or
+// none of them
+type EditRequest struct {
+	Operations []EditOperation `json:"operations"`
+}
+
+// EditResult is the outcome of an edit
+type EditResult struct {
+	Path       string `json:"path"`
+	Changed    bool   `json:"changed"`
+	Diff       string `json:"diff"` // Unified diff of the file before and after
+	TotalLines int    `json:"totalLines"`
+}
+
+// EditFile applies line-based operations to a file in order. An operation
+// that does not apply fails the edit, leaving the file as it was.
+func (fs *FileService) EditFile(sessionID string, relativePath string, req *EditRequest) (result *EditResult, err error) {
+	defer func() {
+		fs.sessionManager.Audit(sessionID, "file.edit", relativePath, fmt.Sprintf("%d operations", len(req.Operations)), err)
+	}()
+
+	if len(req.Operations) == 0 {
+		return nil, fmt.Errorf("%w: no operations", ErrEditFailed)
+	}
+	fullPath, err := fs.GetFilePath(sessionID, relativePath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%w: %s", ErrIsDirectory, relativePath)
+	}
+	original, err := os.ReadFile(fullPath)
+	if err != nil {
+		return nil, err
+	}
+
+	content := newEditBuffer(string(original))
+	for i, op := range req.Operations {
+		if err := content.apply(&op); err != nil {
+			return nil, fmt.Errorf("%w: operation %d (%s): %v", ErrEditFailed, i+1, op.Op, err)
+		}
+	}
+	modified := content.String()
+
+	result = &EditResult{
+		Path:       relativePath,
+		Changed:    modified != string(original),
+		Diff:       UnifiedDiff(relativePath, string(original), modified),
+		TotalLines: len(content.lines),
+	}
+	if !result.Changed {
+		return result, nil
+	}
+	if _, err := fs.sessionManager.beforeWrite(sessionID, fullPath, false); err != nil {
+		return nil, fmt.Errorf("failed to back up %s: %w", relativePath, err)
+	}
+	if err := writeFileAtomic(fullPath, []byte(modified), info.Mode().Perm()); err != nil {
+		return nil, err
+	}
+	fs.sessionManager.afterWrite(sessionID, fullPath, "edit")
+
+	fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityFile,
+		Target:   relativePath,
+		Message:  fmt.Sprintf("Edited file %s (%d operations)", relativePath, len(req.Operations)),
+	})
+	return result, nil
+}
+
+// editBuffer is file content as lines, without their newlines
+type editBuffer struct {
+	lines        []string
+	finalNewline bool // Whether the last line ends with a newline
+}
+
+func newEditBuffer(content string) *editBuffer {
+	return &editBuffer{
+		lines:        splitLines(content),
+		finalNewline: content == "" || strings.HasSuffix(content, "\n"),
+	}
+}
+
+// splitLines splits text into lines, where a final newline does not start
+// another line
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+func (b *editBuffer) String() string {
+	if len(b.lines) == 0 {
+		return ""
+	}
+	content := strings.Join(b.lines, "\n")
+	if b.finalNewline {
+		content += "\n"
+	}
+	return content
+}
+
+// lineRange checks that start to end, or start alone when end is 0, are
+// lines of the buffer, returning the end
+func (b *editBuffer) lineRange(start int, end int) (int, error) {
+	if end == 0 {
+		end = start
+	}
+	if start < 1 || end < start {
+		return 0, fmt.Errorf("invalid line range %d-%d", start, end)
+	}
+	if end > len(b.lines) {
+		return 0, fmt.Errorf("line %d is past the end of the file, which has %d lines", end, len(b.lines))
+	}
+	return end, nil
+}
+
+func (b *editBuffer) apply(op *EditOperation) error {
+	switch op.Op {
+	case EditInsertAfterLine:
+		if op.Line < 0 || op.Line > len(b.lines) {
+			return fmt.Errorf("line %d is outside the file, which has %d lines", op.Line, len(b.lines))
+		}
+		b.splice(op.Line, op.Line, splitLines(op.Text))
+	case EditReplaceLines, EditDeleteLines:
+		end, err := b.lineRange(op.Start, op.End)
+		if err != nil {
+			return err
+		}
+		var replacement []string
+		if op.Op == EditReplaceLines {
+			replacement = splitLines(op.Text)
+		}
+		b.splice(op.Start-1, end, replacement)
+	case EditReplaceFirst, EditReplaceAll:
+		if op.Pattern == "" {
+			return errors.New("pattern is required")
+		}
+		re, err := regexp.Compile(op.Pattern)
+		if err != nil {
+			return err
+		}
+		content := b.String()
+		match := re.FindStringSubmatchIndex(content)
+		if match == nil {
+			return fmt.Errorf("pattern %q matches nothing", op.Pattern)
+		}
+		if op.Op == EditReplaceFirst {
+			replaced := re.ExpandString(nil, op.Replacement, content, match)
+			content = content[:match[0]] + string(replaced) + content[match[1]:]
+		} else {
+			content = re.ReplaceAllString(content, op.Replacement)
+		}
+		*b = *newEditBuffer(content)
+	default:
+		return fmt.Errorf("unknown operation %q", op.Op)
+	}
+	return nil
+}
+
+// splice replaces the lines from index start up to end with lines
+func (b *editBuffer) splice(start int, end int, lines []string) {
+	// Lines added at the end of a file keep its final newline, or gain one
+	// when they follow its last line
+	if end == len(b.lines) && len(lines) > 0 && start == end {
+		b.finalNewline = true
+	}
+	spliced := make([]string, 0, len(b.lines)-(end-start)+len(lines))
+	spliced = append(spliced, b.lines[:start]...)
+	spliced = append(spliced, lines...)
+	spliced = append(spliced, b.lines[end:]...)
+	b.lines = spliced
+}
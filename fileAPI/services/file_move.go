@@ -0,0 +1,111 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// ErrInvalidTarget is returned for a move or copy that cannot be made, such
+// as of the working directory or of a directory into itself
+var ErrInvalidTarget = errors.New("invalid source or destination")
+
+// Move moves or renames a file or directory, creating the parent
+// directories of the destination. An existing destination is replaced only
+// with overwrite. Within a file system the move is a rename, keeping
+// permissions and atomic; across file systems the source is copied, with
+// its permissions and modification times, then removed.
+func (fs *FileService) Move(sessionID string, source string, destination string, overwrite bool) (err error) {
+	defer func() {
+		fs.sessionManager.Audit(sessionID, "file.move", source, "to "+destination, err)
+	}()
+
+	sourcePath, destinationPath, info, err := fs.transferPaths(sessionID, source, destination)
+	if err != nil {
+		return err
+	}
+
+	if existing, err := os.Lstat(destinationPath); err == nil {
+		if !overwrite {
+			return fmt.Errorf("%s: %w", destination, os.ErrExist)
+		}
+		// A file replaces a file in the rename itself; directories have to
+		// make way first
+		if info.IsDir() || existing.IsDir() {
+			if err := os.RemoveAll(destinationPath); err != nil {
+				return err
+			}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(destinationPath), 0755); err != nil {
+		return err
+	}
+
+	if err := os.Rename(sourcePath, destinationPath); err != nil {
+		if !errors.Is(err, syscall.EXDEV) {
+			return err
+		}
+		if err := os.RemoveAll(destinationPath); err != nil {
+			return err
+		}
+		if err := copyTree(sourcePath, destinationPath); err != nil {
+			os.RemoveAll(destinationPath)
+			return err
+		}
+		if err := os.RemoveAll(sourcePath); err != nil {
+			return err
+		}
+	}
+
+	fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: transferCategory(info),
+		Target:   source,
+		Message:  fmt.Sprintf("Moved %s to %s", source, destination),
+	})
+	return nil
+}
+
+// transferPaths resolves the source and destination of a move or copy,
+// refusing the working directory itself and directories put inside
+// themselves. It returns the source's information.
+func (fs *FileService) transferPaths(sessionID string, source string, destination string) (string, string, os.FileInfo, error) {
+	sourcePath, err := fs.GetFilePath(sessionID, source)
+	if err != nil {
+		return "", "", nil, err
+	}
+	destinationPath, err := fs.GetFilePath(sessionID, destination)
+	if err != nil {
+		return "", "", nil, err
+	}
+	root, err := fs.GetFilePath(sessionID, ".")
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	info, err := os.Lstat(sourcePath)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if sourcePath == root || destinationPath == root {
+		return "", "", nil, fmt.Errorf("%w: the working directory itself", ErrInvalidTarget)
+	}
+	if sourcePath == destinationPath {
+		return "", "", nil, fmt.Errorf("%w: %s is both source and destination", ErrInvalidTarget, source)
+	}
+	if info.IsDir() && isWithin(sourcePath, destinationPath) {
+		return "", "", nil, fmt.Errorf("%w: cannot put directory %s inside itself", ErrInvalidTarget, source)
+	}
+	return sourcePath, destinationPath, info, nil
+}
+
+// transferCategory is the activity category of moving or copying info
+func transferCategory(info os.FileInfo) string {
+	if info.IsDir() {
+		return ActivityDirectory
+	}
+	return ActivityFile
+}
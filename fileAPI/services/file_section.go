@@ -0,0 +1,226 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrInvalidRange is returned for a section of a file that cannot be read
+var ErrInvalidRange = errors.New("invalid range")
+
+// SectionRequest selects part of a file: Length bytes from Offset, or the
+// lines from StartLine to EndLine, counted from 1 and inclusive. Zero
+// Length and EndLine run to the end of the file; a zero request selects
+// all of it.
+type SectionRequest struct {
+	Offset    int64
+	Length    int64
+	StartLine int
+	EndLine   int
+}
+
+// ByLines reports whether the request selects lines rather than bytes
+func (r *SectionRequest) ByLines() bool {
+	return r.StartLine != 0 || r.EndLine != 0
+}
+
+// Validate checks that the request selects either bytes or lines, in order
+func (r *SectionRequest) Validate() error {
+	if r.ByLines() && (r.Offset != 0 || r.Length != 0) {
+		return fmt.Errorf("%w: select bytes or lines, not both", ErrInvalidRange)
+	}
+	if r.Offset < 0 || r.Length < 0 {
+		return fmt.Errorf("%w: offset and length cannot be negative", ErrInvalidRange)
+	}
+	if r.StartLine < 0 || r.EndLine < 0 {
+		return fmt.Errorf("%w: lines are counted from 1", ErrInvalidRange)
+	}
+	if r.EndLine != 0 && r.EndLine < r.StartLine {
+		return fmt.Errorf("%w: end line %d is before start line %d", ErrInvalidRange, r.EndLine, r.StartLine)
+	}
+	return nil
+}
+
+// String describes the section, such as lines 200-260 or bytes 1024-end,
+// and is empty for the whole file
+func (r *SectionRequest) String() string {
+	if r.ByLines() {
+		start, end := max(r.StartLine, 1), "end"
+		if r.EndLine != 0 {
+			end = fmt.Sprint(r.EndLine)
+		}
+		return fmt.Sprintf("lines %d-%s", start, end)
+	}
+	if r.Offset != 0 || r.Length != 0 {
+		end := "end"
+		if r.Length != 0 {
+			end = fmt.Sprint(r.Offset + r.Length - 1)
+		}
+		return fmt.Sprintf("bytes %d-%s", r.Offset, end)
+	}
+	return ""
+}
+
+// FileSection is part of a file, and where it is in the whole
+type FileSection struct {
+	Content    []byte
+	Binary     bool   // Whether the whole file is, by its first bytes
+	MimeType   string // Of the whole file, by its first bytes
+	Size       int64  // Of the whole file, in bytes
+	TotalLines int   // Of the whole file
+	Offset     int64 // Of the first byte returned
+	StartLine  int   // Of the first line returned, for line requests
+	EndLine    int   // Of the last line returned, for line requests
+}
+
+// ReadFileSection reads part of a file, counting the bytes and lines of all
+// of it. The file is scanned rather than loaded, so only the section is
+// held in memory.
+func (fs *FileService) ReadFileSection(sessionID string, relativePath string, req *SectionRequest) (section *FileSection, err error) {
+	detail := req.String()
+	defer func() {
+		fs.sessionManager.Audit(sessionID, "file.read", relativePath, detail, err)
+	}()
+
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	fullPath, err := fs.GetFilePath(sessionID, relativePath)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%w: %s", ErrIsDirectory, relativePath)
+	}
+	binary, mimeType, err := sniffFile(fullPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.ByLines() {
+		section, err = readLines(file, req.StartLine, req.EndLine)
+	} else {
+		section, err = readBytes(file, req.Offset, req.Length)
+	}
+	if err != nil {
+		return nil, err
+	}
+	section.Size = info.Size()
+	section.Binary, section.MimeType = binary, mimeType
+
+	message := "Read file " + relativePath
+	if detail != "" {
+		message += " (" + detail + ")"
+	}
+	fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityFile,
+		Target:   relativePath,
+		Message:  message,
+	})
+	return section, nil
+}
+
+// readBytes reads length bytes from offset, or to the end for a zero
+// length, counting the lines of the whole file
+func readBytes(file *os.File, offset int64, length int64) (*FileSection, error) {
+	section := &FileSection{Offset: offset}
+	var content bytes.Buffer
+	var position int64
+	var last byte
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := file.Read(buffer)
+		chunk := buffer[:n]
+		section.TotalLines += bytes.Count(chunk, []byte{'\n'})
+		if n > 0 {
+			last = chunk[n-1]
+		}
+
+		// The part of the chunk inside the section
+		start, end := offset-position, offset+length-position
+		if length == 0 || end > int64(n) {
+			end = int64(n)
+		}
+		if start < 0 {
+			start = 0
+		}
+		if start < end {
+			content.Write(chunk[start:end])
+		}
+		position += int64(n)
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	// A last line without a newline still counts
+	if position > 0 && last != '\n' {
+		section.TotalLines++
+	}
+	section.Content = content.Bytes()
+	return section, nil
+}
+
+// readLines reads the lines from start to end, counting from 1, or to the
+// last line for a zero end, and counts the lines of the whole file
+func readLines(file *os.File, start int, end int) (*FileSection, error) {
+	if start == 0 {
+		start = 1
+	}
+	section := &FileSection{StartLine: start}
+	var content bytes.Buffer
+	var position int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadSlice('\n')
+		// Lines longer than the buffer come in pieces
+		for err == bufio.ErrBufferFull {
+			if section.TotalLines+1 >= start && (end == 0 || section.TotalLines+1 <= end) {
+				content.Write(line)
+			}
+			position += int64(len(line))
+			line, err = reader.ReadSlice('\n')
+		}
+		if len(line) > 0 {
+			section.TotalLines++
+			if section.TotalLines == start {
+				section.Offset = position - int64(content.Len())
+			}
+			if section.TotalLines >= start && (end == 0 || section.TotalLines <= end) {
+				content.Write(line)
+				section.EndLine = section.TotalLines
+			}
+			position += int64(len(line))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if section.EndLine == 0 {
+		// Past the end of the file: an empty range
+		section.EndLine = start - 1
+		section.Offset = position
+	}
+	section.Content = content.Bytes()
+	return section, nil
+}
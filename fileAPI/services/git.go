@@ -0,0 +1,473 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Git errors: ErrNotGitRepository for paths outside any repository,
+// ErrGitRefNotFound for revisions the repository does not have
+var (
+	ErrNotGitRepository = errors.New("not a git repository")
+	ErrGitRefNotFound   = errors.New("git revision not found")
+)
+
+// What a file diffed against a revision is in the working tree, and the
+// states of files in a repository's status
+const (
+	GitFileAdded     = "added"
+	GitFileDeleted   = "deleted"
+	GitFileModified  = "modified"
+	GitFileUnchanged = "unchanged"
+	GitFileUntracked = "untracked"
+	GitFileRenamed   = "renamed"
+	GitFileCopied    = "copied"
+	GitFileUnmerged  = "unmerged"
+)
+
+// GitFileDiff is how a file in the working tree differs from the file at a
+// revision
+type GitFileDiff struct {
+	Path         string `json:"path"`
+	Ref          string `json:"ref"`
+	Commit       string `json:"commit"`   // The revision's commit hash
+	RepoPath     string `json:"repoPath"` // Of the file in the repository
+	Status       string `json:"status"`   // added, deleted, modified or unchanged
+	Diff         string `json:"diff,omitempty"`
+	LinesAdded   int    `json:"linesAdded"`
+	LinesRemoved int    `json:"linesRemoved"`
+}
+
+// GitFileStatus is the state of a changed file: Staged is how the index
+// has it against HEAD, Unstaged how the working tree has it against the
+// index
+type GitFileStatus struct {
+	Path     string `json:"path"` // In the working directory
+	RepoPath string `json:"repoPath"`
+	Staged   string `json:"staged"`   // unchanged, added, modified, deleted, renamed, copied or unmerged
+	Unstaged string `json:"unstaged"` // unchanged, untracked, modified, deleted or unmerged
+}
+
+// GitStatus is the status of a repository, or of the files of a directory
+// in it: the branch and commit of HEAD, and the files that changed, by
+// path. Root is the repository's worktree, relative to the working
+// directory.
+type GitStatus struct {
+	Path      string          `json:"path"`
+	Root      string          `json:"root"`
+	Branch    string          `json:"branch,omitempty"` // Empty when HEAD is detached
+	Commit    string          `json:"commit,omitempty"` // Empty before the first commit
+	Clean     bool            `json:"clean"`
+	Staged    int             `json:"staged"`    // Files with staged changes
+	Unstaged  int             `json:"unstaged"`  // Tracked files with unstaged changes
+	Untracked int             `json:"untracked"` // Files git does not track or ignore
+	Files     []GitFileStatus `json:"files"`
+}
+
+// GitStageRequest stages or unstages paths of the working directory; a
+// directory stands for the changed files in it
+type GitStageRequest struct {
+	Paths []string `json:"paths"`
+}
+
+// GitService reads the git repositories of working directories
+type GitService struct {
+	sessionManager *SessionManager
+	fileService    *FileService
+}
+
+func NewGitService(sm *SessionManager) *GitService {
+	return &GitService{
+		sessionManager: sm,
+		fileService:    NewFileService(sm),
+	}
+}
+
+// openRepository opens the repository holding a path of the working
+// directory, returning it with the path's full path and its path in the
+// repository's worktree
+func (gs *GitService) openRepository(sessionID string, relativePath string) (*git.Repository, string, string, error) {
+	fullPath, err := gs.fileService.GetFilePath(sessionID, relativePath)
+	if err != nil {
+		return nil, "", "", err
+	}
+	realPath, err := resolveSymlinks(fullPath, 0)
+	if err != nil {
+		return nil, "", "", err
+	}
+	// A file may be missing from the working tree, so look from the nearest
+	// directory that exists
+	dir := realPath
+	for {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			break
+		}
+		if parent := filepath.Dir(dir); parent != dir {
+			dir = parent
+		} else {
+			break
+		}
+	}
+	repo, err := git.PlainOpenWithOptions(dir, &git.PlainOpenOptions{DetectDotGit: true, EnableDotGitCommonDir: true})
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		return nil, "", "", fmt.Errorf("%w: %s", ErrNotGitRepository, relativePath)
+	}
+	if err != nil {
+		return nil, "", "", err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, "", "", fmt.Errorf("%w: %s is in a bare repository", ErrNotGitRepository, relativePath)
+	}
+	root, err := filepath.EvalSymlinks(worktree.Filesystem.Root())
+	if err != nil {
+		return nil, "", "", err
+	}
+	repoPath, err := filepath.Rel(root, realPath)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return repo, fullPath, filepath.ToSlash(repoPath), nil
+}
+
+// resolveCommit returns the commit a revision names, such as HEAD, a
+// branch, a tag, a hash or HEAD~2
+func resolveCommit(repo *git.Repository, ref string) (*object.Commit, error) {
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrGitRefNotFound, ref)
+	}
+	commit, err := repo.CommitObject(*hash)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s is not a commit", ErrGitRefNotFound, ref)
+	}
+	return commit, nil
+}
+
+// DiffFile diffs a file in the working tree against the file at a
+// revision of its repository, HEAD by default, from the revision to the
+// working tree. A file missing from either side is added or deleted.
+func (gs *GitService) DiffFile(sessionID string, relativePath string, ref string) (result *GitFileDiff, err error) {
+	defer func() {
+		gs.sessionManager.Audit(sessionID, "git.diff", relativePath, "against "+ref, err)
+	}()
+
+	if ref == "" {
+		ref = "HEAD"
+	}
+	repo, fullPath, repoPath, err := gs.openRepository(sessionID, relativePath)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := resolveCommit(repo, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	var original string
+	committed := true
+	file, err := commit.File(repoPath)
+	if errors.Is(err, object.ErrFileNotFound) {
+		committed = false
+	} else if err != nil {
+		return nil, err
+	} else {
+		if binary, err := file.IsBinary(); err != nil {
+			return nil, err
+		} else if binary {
+			return nil, fmt.Errorf("%w: %s at %s", ErrBinaryFile, relativePath, ref)
+		}
+		if original, err = file.Contents(); err != nil {
+			return nil, err
+		}
+	}
+
+	var modified string
+	exists := true
+	if info, err := os.Stat(fullPath); errors.Is(err, os.ErrNotExist) {
+		exists = false
+	} else if err != nil {
+		return nil, err
+	} else if info.IsDir() {
+		return nil, fmt.Errorf("%w: %s", ErrIsDirectory, relativePath)
+	} else {
+		content, err := gs.fileService.ReadTextFile(sessionID, relativePath)
+		if err != nil {
+			return nil, err
+		}
+		modified = string(content)
+	}
+	if !committed && !exists {
+		return nil, fmt.Errorf("%s is neither in the working tree nor at %s: %w", relativePath, ref, os.ErrNotExist)
+	}
+
+	result = &GitFileDiff{
+		Path:     relativePath,
+		Ref:      ref,
+		Commit:   commit.Hash.String(),
+		RepoPath: repoPath,
+		Status:   GitFileModified,
+		Diff:     UnifiedDiff(repoPath, original, modified),
+	}
+	switch {
+	case !committed:
+		result.Status = GitFileAdded
+	case !exists:
+		result.Status = GitFileDeleted
+	case original == modified:
+		result.Status = GitFileUnchanged
+	}
+	result.LinesAdded, result.LinesRemoved = diffStats(original, modified)
+	return result, nil
+}
+
+// gitFileState names a status code of go-git
+func gitFileState(code git.StatusCode) string {
+	switch code {
+	case git.Untracked:
+		return GitFileUntracked
+	case git.Added:
+		return GitFileAdded
+	case git.Modified:
+		return GitFileModified
+	case git.Deleted:
+		return GitFileDeleted
+	case git.Renamed:
+		return GitFileRenamed
+	case git.Copied:
+		return GitFileCopied
+	case git.UpdatedButUnmerged:
+		return GitFileUnmerged
+	}
+	return GitFileUnchanged
+}
+
+// inGitPath tells whether a file of a repository is at or under a path of
+// it
+func inGitPath(file string, dir string) bool {
+	return dir == "." || file == dir || strings.HasPrefix(file, dir+"/")
+}
+
+// Status reports the status of the repository a path is in, listing the
+// changed files at or under the path, the whole working directory by
+// default. Files .gitignore ignores are left out.
+func (gs *GitService) Status(sessionID string, relativePath string) (status *GitStatus, err error) {
+	defer func() {
+		gs.sessionManager.Audit(sessionID, "git.status", relativePath, "", err)
+	}()
+
+	if relativePath == "" {
+		relativePath = "."
+	}
+	repo, _, repoPath, err := gs.openRepository(sessionID, relativePath)
+	if err != nil {
+		return nil, err
+	}
+	return gitStatus(repo, relativePath, repoPath)
+}
+
+// gitStatus reports the status of repo for a path of the working directory
+// that is repoPath in it
+func gitStatus(repo *git.Repository, relativePath string, repoPath string) (*GitStatus, error) {
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	files, err := worktree.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	toRoot, err := filepath.Rel(repoPath, ".")
+	if err != nil {
+		return nil, err
+	}
+	status := &GitStatus{
+		Path:  relativePath,
+		Root:  path.Join(filepath.ToSlash(relativePath), filepath.ToSlash(toRoot)),
+		Files: []GitFileStatus{},
+	}
+	if head, err := repo.Reference(plumbing.HEAD, false); err == nil && head.Type() == plumbing.SymbolicReference {
+		status.Branch = head.Target().Short()
+	}
+	if head, err := repo.Head(); err == nil {
+		status.Commit = head.Hash().String()
+	}
+
+	for file, state := range files {
+		if !inGitPath(file, repoPath) || (state.Staging == git.Unmodified && state.Worktree == git.Unmodified) {
+			continue
+		}
+		rel, err := filepath.Rel(repoPath, file)
+		if err != nil {
+			return nil, err
+		}
+		entry := GitFileStatus{
+			Path:     path.Join(filepath.ToSlash(relativePath), filepath.ToSlash(rel)),
+			RepoPath: file,
+			Staged:   gitFileState(state.Staging),
+			Unstaged: gitFileState(state.Worktree),
+		}
+		if state.Worktree == git.Untracked {
+			entry.Staged = GitFileUnchanged
+			status.Untracked++
+		} else {
+			if state.Staging != git.Unmodified {
+				status.Staged++
+			}
+			if state.Worktree != git.Unmodified {
+				status.Unstaged++
+			}
+		}
+		status.Files = append(status.Files, entry)
+	}
+	sort.Slice(status.Files, func(i, j int) bool {
+		return status.Files[i].RepoPath < status.Files[j].RepoPath
+	})
+	status.Clean = len(status.Files) == 0
+	return status, nil
+}
+
+// stagePaths opens the repository the paths of a request are in, which
+// must be the same for them all, returning it with the paths' paths in it
+// and the repository's root in the working directory
+func (gs *GitService) stagePaths(sessionID string, req *GitStageRequest) (*git.Repository, []string, string, error) {
+	if len(req.Paths) == 0 {
+		return nil, nil, "", fmt.Errorf("%w: no paths given", ErrInvalidTarget)
+	}
+	var repo *git.Repository
+	var root string
+	var repoPaths []string
+	for _, relativePath := range req.Paths {
+		pathRepo, _, repoPath, err := gs.openRepository(sessionID, relativePath)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		worktree, err := pathRepo.Worktree()
+		if err != nil {
+			return nil, nil, "", err
+		}
+		toRoot, err := filepath.Rel(repoPath, ".")
+		if err != nil {
+			return nil, nil, "", err
+		}
+		pathRoot := path.Join(filepath.ToSlash(relativePath), filepath.ToSlash(toRoot))
+		if repo == nil {
+			repo, root = pathRepo, pathRoot
+		} else if current, _ := repo.Worktree(); current.Filesystem.Root() != worktree.Filesystem.Root() {
+			return nil, nil, "", fmt.Errorf("%w: %s is not in the repository of %s", ErrInvalidTarget, relativePath, req.Paths[0])
+		}
+		repoPaths = append(repoPaths, repoPath)
+	}
+	return repo, repoPaths, root, nil
+}
+
+// Stage adds the changes of paths to the index of their repository, as git
+// add does, deletions included, and returns the repository's status
+func (gs *GitService) Stage(sessionID string, req *GitStageRequest) (status *GitStatus, err error) {
+	defer func() {
+		gs.sessionManager.Audit(sessionID, "git.stage", strings.Join(req.Paths, ", "), "", err)
+	}()
+
+	repo, repoPaths, root, err := gs.stagePaths(sessionID, req)
+	if err != nil {
+		return nil, err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	files, err := worktree.Status()
+	if err != nil {
+		return nil, err
+	}
+	for i, repoPath := range repoPaths {
+		// A path neither in the working tree nor the index has nothing to stage
+		_, changed := files[repoPath]
+		if _, err := worktree.Filesystem.Lstat(repoPath); errors.Is(err, os.ErrNotExist) && !changed {
+			return nil, fmt.Errorf("%s: %w", req.Paths[i], os.ErrNotExist)
+		}
+		if _, err := worktree.Add(repoPath); err != nil {
+			return nil, err
+		}
+	}
+
+	gs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityFile,
+		Target:   strings.Join(req.Paths, ", "),
+		Message:  "Staged " + strings.Join(req.Paths, ", "),
+	})
+	return gitStatus(repo, root, ".")
+}
+
+// Unstage takes the staged changes of paths out of the index of their
+// repository, as git restore --staged does, leaving the working tree as it
+// is, and returns the repository's status
+func (gs *GitService) Unstage(sessionID string, req *GitStageRequest) (status *GitStatus, err error) {
+	defer func() {
+		gs.sessionManager.Audit(sessionID, "git.unstage", strings.Join(req.Paths, ", "), "", err)
+	}()
+
+	repo, repoPaths, root, err := gs.stagePaths(sessionID, req)
+	if err != nil {
+		return nil, err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	files, err := worktree.Status()
+	if err != nil {
+		return nil, err
+	}
+	var staged []string
+	for file, state := range files {
+		if state.Staging == git.Unmodified || state.Staging == git.Untracked {
+			continue
+		}
+		for _, repoPath := range repoPaths {
+			if inGitPath(file, repoPath) {
+				staged = append(staged, file)
+				break
+			}
+		}
+	}
+
+	if len(staged) > 0 {
+		if _, err := repo.Head(); errors.Is(err, plumbing.ErrReferenceNotFound) {
+			// Before the first commit, unstaging takes files out of the index
+			index, err := repo.Storer.Index()
+			if err != nil {
+				return nil, err
+			}
+			for _, file := range staged {
+				if _, err := index.Remove(file); err != nil {
+					return nil, err
+				}
+			}
+			if err := repo.Storer.SetIndex(index); err != nil {
+				return nil, err
+			}
+		} else if err != nil {
+			return nil, err
+		} else if err := worktree.Restore(&git.RestoreOptions{Staged: true, Files: staged}); err != nil {
+			return nil, err
+		}
+	}
+
+	gs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityFile,
+		Target:   strings.Join(req.Paths, ", "),
+		Message:  fmt.Sprintf("Unstaged %d files", len(staged)),
+	})
+	return gitStatus(repo, root, ".")
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreRule is a pattern of a .gitignore file
+type ignoreRule struct {
+	pattern []string // Split at slashes
+	negate  bool     // Re-includes what earlier rules ignore
+	dirOnly bool     // Only matches directories
+	// Matched against the path below the .gitignore's directory rather
+	// than against the name, as patterns with a slash before their end are
+	anchored bool
+}
+
+// ignoreFile holds the rules of a .gitignore file, which apply to the
+// entries below its directory
+type ignoreFile struct {
+	dir   string
+	rules []ignoreRule
+}
+
+// readIgnoreFile reads the .gitignore file of a directory, nil when it has
+// none
+func readIgnoreFile(dir string) *ignoreFile {
+	file, err := os.Open(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	ignore := &ignoreFile{dir: dir}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		// An escaped leading ! or # is part of the pattern
+		if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		rule.anchored = strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+		rule.pattern = strings.Split(line, "/")
+		ignore.rules = append(ignore.rules, rule)
+	}
+	return ignore
+}
+
+// ignored reports whether the .gitignore files, from the outermost
+// directory in, leave out an entry. The last rule matching it decides, so
+// deeper files override the ones above them.
+func ignored(ignores []*ignoreFile, fullPath string, isDir bool) bool {
+	result := false
+	for _, ignore := range ignores {
+		rel, err := filepath.Rel(ignore.dir, fullPath)
+		if err != nil || rel == "." || outside(ignore.dir, fullPath) {
+			continue
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		for _, rule := range ignore.rules {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			var matched bool
+			if rule.anchored {
+				matched = matchSegments(rule.pattern, parts)
+			} else {
+				matched = matchSegments(rule.pattern, parts[len(parts)-1:])
+			}
+			if matched {
+				result = !rule.negate
+			}
+		}
+	}
+	return result
+}
+
+// matchSegments matches a path split at slashes against a pattern split
+// the same way, where a ** segment matches any number of segments
+func matchSegments(pattern []string, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchSegments(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	if matched, _ := path.Match(pattern[0], parts[0]); !matched {
+		return false
+	}
+	return matchSegments(pattern[1:], parts[1:])
+}
+
+// parentIgnoreFiles reads the .gitignore files of the directories above
+// target, outermost first, up to the root of the repository it is in but
+// not past root
+func parentIgnoreFiles(root string, target string) []*ignoreFile {
+	var ignores []*ignoreFile
+	for dir := target; dir != root; {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir || outside(root, parent) {
+			break
+		}
+		dir = parent
+		if ignore := readIgnoreFile(dir); ignore != nil {
+			ignores = append([]*ignoreFile{ignore}, ignores...)
+		}
+	}
+	return ignores
+}
+
+// outside reports whether path lies outside dir
+func outside(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+)
+
+// Line endings of text, and how writes set them: as the file had them
+// with LineEndingsPreserve, or all LF or CRLF
+const (
+	LineEndingsLF       = "lf"
+	LineEndingsCRLF     = "crlf"
+	LineEndingsMixed    = "mixed"
+	LineEndingsPreserve = "preserve"
+)
+
+// countLineEndings counts the CRLF line endings of content and the LF
+// ones without a CR. after tells whether a CR ended the content before.
+func countLineEndings(content []byte, after bool) (crlf int, lf int) {
+	for i, b := range content {
+		if b != '\n' {
+			continue
+		}
+		if (i > 0 && content[i-1] == '\r') || (i == 0 && after) {
+			crlf++
+		} else {
+			lf++
+		}
+	}
+	return crlf, lf
+}
+
+// lineEndingsOf names the line endings found: lf, crlf or mixed, or ""
+// when there are none
+func lineEndingsOf(crlf int, lf int) string {
+	switch {
+	case crlf > 0 && lf > 0:
+		return LineEndingsMixed
+	case crlf > 0:
+		return LineEndingsCRLF
+	case lf > 0:
+		return LineEndingsLF
+	}
+	return ""
+}
+
+// fileLineEndings reads a file for the line endings it uses
+func fileLineEndings(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	var crlf, lf int
+	cr := false
+	reader := bufio.NewReader(file)
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := reader.Read(buffer)
+		if n > 0 {
+			c, l := countLineEndings(buffer[:n], cr)
+			crlf, lf = crlf+c, lf+l
+			cr = buffer[n-1] == '\r'
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return lineEndingsOf(crlf, lf), nil
+}
+
+// withLineEndings sets the line endings of content written over current,
+// nil for a new file, as a write's option asks. Content keeps the line
+// endings it has without the option, with preserve when the file is new
+// or mixed, and when it is binary.
+func withLineEndings(content []byte, current []byte, option string) []byte {
+	if option == LineEndingsPreserve {
+		option = lineEndingsOf(countLineEndings(current, false))
+	}
+	if option != LineEndingsLF && option != LineEndingsCRLF {
+		return content
+	}
+	if binary, _ := sniffContent(content, false); binary {
+		return content
+	}
+	converted := bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+	if option == LineEndingsCRLF {
+		converted = bytes.ReplaceAll(converted, []byte("\n"), []byte("\r\n"))
+	}
+	return converted
+}
+
+// withLineEndings sets the line endings of content as the options ask,
+// reading the file it replaces with read when they are preserved
+func (opts WriteOptions) withLineEndings(content []byte, read func() ([]byte, error)) []byte {
+	var current []byte
+	if opts.LineEndings == LineEndingsPreserve {
+		current, _ = read()
+	}
+	return withLineEndings(content, current, opts.LineEndings)
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Markers of a conflict in a merged text, as git writes them
+const (
+	conflictOurs   = "<<<<<<<"
+	conflictBase   = "|||||||"
+	conflictSplit  = "======="
+	conflictTheirs = ">>>>>>>"
+)
+
+// MergeRequest merges the changes two versions made to a base text. Each
+// text is given as content or read from a path in the working directory.
+// With Diff3, conflicts also show the base's lines, as git's diff3 style
+// does.
+type MergeRequest struct {
+	Base       string `json:"base,omitempty"`
+	Ours       string `json:"ours,omitempty"`
+	Theirs     string `json:"theirs,omitempty"`
+	BasePath   string `json:"basePath,omitempty"`
+	OursPath   string `json:"oursPath,omitempty"`
+	TheirsPath string `json:"theirsPath,omitempty"`
+	Diff3      bool   `json:"diff3,omitempty"`
+}
+
+// MergeConflict is a part of the base both versions changed differently.
+// Lines count from 1: Line and EndLine are those of its markers in the
+// merged text, BaseLine and BaseLines the lines of the base it replaces.
+type MergeConflict struct {
+	Index     int    `json:"index"`
+	Line      int    `json:"line"`
+	EndLine   int    `json:"endLine"`
+	BaseLine  int    `json:"baseLine"`
+	BaseLines int    `json:"baseLines"`
+	Base      string `json:"base"`
+	Ours      string `json:"ours"`
+	Theirs    string `json:"theirs"`
+}
+
+// MergeResult is a merged text, with markers around its conflicts
+type MergeResult struct {
+	Result    string          `json:"result"`
+	Clean     bool            `json:"clean"` // Whether there were no conflicts
+	Conflicts []MergeConflict `json:"conflicts"`
+}
+
+// mergeChange is a change a version made to the base: the lines from start
+// to end replaced by lines
+type mergeChange struct {
+	start int
+	end   int
+	lines []string
+}
+
+// Merge merges ours and theirs, two versions of a base text, line by line
+// as diff3 and git do: parts of the base only one version changed take its
+// change, parts both changed alike take the change once, and parts they
+// changed differently, or changes next to each other, are conflicts, kept
+// between markers in the result and listed.
+func (ds *DiffService) Merge(sessionID string, req *MergeRequest) (result *MergeResult, err error) {
+	defer func() {
+		detail := ""
+		if result != nil {
+			detail = fmt.Sprintf("%d conflicts", len(result.Conflicts))
+		}
+		ds.sessionManager.Audit(sessionID, "diff.merge", req.OursPath, detail, err)
+	}()
+
+	texts := []*string{&req.Base, &req.Ours, &req.Theirs}
+	for i, path := range []string{req.BasePath, req.OursPath, req.TheirsPath} {
+		if path == "" {
+			continue
+		}
+		content, err := ds.fileService.ReadTextFile(sessionID, path)
+		if err != nil {
+			return nil, err
+		}
+		*texts[i] = string(content)
+	}
+	return merge3(req.Base, req.Ours, req.Theirs, mergeLabel(req.OursPath, "ours"), mergeLabel(req.TheirsPath, "theirs"), req.Diff3), nil
+}
+
+// mergeLabel names a version after its path, or else name
+func mergeLabel(path string, name string) string {
+	if path != "" {
+		return path
+	}
+	return name
+}
+
+// merge3 merges the changes ours and theirs made to base, labelling their
+// sides of conflicts
+func merge3(base string, ours string, theirs string, oursLabel string, theirsLabel string, diff3 bool) *MergeResult {
+	baseLines := splitAfterLines(base)
+	oursChanges, theirsChanges := mergeChanges(base, ours), mergeChanges(base, theirs)
+	ending := "\n"
+	if lineEndingsOf(countLineEndings([]byte(ours), false)) == LineEndingsCRLF {
+		ending = "\r\n"
+	}
+
+	result := &MergeResult{Conflicts: []MergeConflict{}}
+	var out []string
+	position := 0
+	for i, j := 0, 0; i < len(oursChanges) || j < len(theirsChanges); {
+		// The next group of changes: one, and every change of either version
+		// that overlaps or touches it, repeatedly
+		start, end := 0, 0
+		var oursGroup, theirsGroup []mergeChange
+		take := func(changes []mergeChange, k *int, group *[]mergeChange) bool {
+			if *k < len(changes) && (len(oursGroup)+len(theirsGroup) == 0 || changes[*k].start <= end) {
+				change := changes[*k]
+				if len(oursGroup)+len(theirsGroup) == 0 {
+					start, end = change.start, change.end
+				}
+				start, end = min(start, change.start), max(end, change.end)
+				*group = append(*group, change)
+				*k++
+				return true
+			}
+			return false
+		}
+		if j >= len(theirsChanges) || (i < len(oursChanges) && oursChanges[i].start <= theirsChanges[j].start) {
+			take(oursChanges, &i, &oursGroup)
+		} else {
+			take(theirsChanges, &j, &theirsGroup)
+		}
+		for take(oursChanges, &i, &oursGroup) || take(theirsChanges, &j, &theirsGroup) {
+			// Until neither version has a change reaching the group
+		}
+
+		out = append(out, baseLines[position:start]...)
+		position = end
+		oursText := applyChanges(baseLines, start, end, oursGroup)
+		theirsText := applyChanges(baseLines, start, end, theirsGroup)
+		switch {
+		case len(theirsGroup) == 0:
+			out = append(out, oursText...)
+			continue
+		case len(oursGroup) == 0:
+			out = append(out, theirsText...)
+			continue
+		case strings.Join(oursText, "") == strings.Join(theirsText, ""):
+			out = append(out, oursText...)
+			continue
+		}
+
+		conflict := MergeConflict{
+			Index:     len(result.Conflicts) + 1,
+			Line:      len(out) + 1,
+			BaseLine:  start + 1,
+			BaseLines: end - start,
+			Base:      strings.Join(baseLines[start:end], ""),
+			Ours:      strings.Join(oursText, ""),
+			Theirs:    strings.Join(theirsText, ""),
+		}
+		out = endLines(out, ending)
+		out = append(out, conflictOurs+" "+oursLabel+ending)
+		out = append(out, endLines(oursText, ending)...)
+		if diff3 {
+			out = append(out, conflictBase+" base"+ending)
+			out = append(out, endLines(baseLines[start:end], ending)...)
+		}
+		out = append(out, conflictSplit+ending)
+		out = append(out, endLines(theirsText, ending)...)
+		out = append(out, conflictTheirs+" "+theirsLabel+ending)
+		conflict.EndLine = len(out)
+		result.Conflicts = append(result.Conflicts, conflict)
+	}
+	out = append(out, baseLines[position:]...)
+
+	result.Result = strings.Join(out, "")
+	result.Clean = len(result.Conflicts) == 0
+	return result
+}
+
+// mergeChanges lists the changes modified made to base, in order
+func mergeChanges(base string, modified string) []mergeChange {
+	var changes []mergeChange
+	var change *mergeChange
+	position := 0
+	for _, line := range lineDiff(base, modified) {
+		if line.kind == ' ' {
+			position++
+			change = nil
+			continue
+		}
+		if change == nil {
+			changes = append(changes, mergeChange{start: position, end: position})
+			change = &changes[len(changes)-1]
+		}
+		if line.kind == '-' {
+			position++
+			change.end = position
+		} else {
+			change.lines = append(change.lines, line.text)
+		}
+	}
+	return changes
+}
+
+// applyChanges returns the lines of base from start to end with changes
+// made to them
+func applyChanges(base []string, start int, end int, changes []mergeChange) []string {
+	var lines []string
+	position := start
+	for _, change := range changes {
+		lines = append(lines, base[position:change.start]...)
+		lines = append(lines, change.lines...)
+		position = change.end
+	}
+	return append(lines, base[position:end]...)
+}
+
+// endLines returns lines with the last one ending in a newline, so a
+// conflict marker can follow it
+func endLines(lines []string, ending string) []string {
+	if n := len(lines); n > 0 && !strings.HasSuffix(lines[n-1], "\n") {
+		lines = append(append([]string{}, lines[:n-1]...), lines[n-1]+ending)
+	}
+	return lines
+}
+
+// splitAfterLines splits text into its lines, each with its newline
+func splitAfterLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
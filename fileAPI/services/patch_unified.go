@@ -0,0 +1,321 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Default and most lines of context a unified diff's hunk may lose at each
+// end and still apply, as patch's --fuzz counts them
+const (
+	DefaultPatchFuzz = 2
+	MaxPatchFuzz     = 10
+)
+
+// unifiedHunk is a hunk of a unified diff
+type unifiedHunk struct {
+	header string
+	// Line of the original the header puts the hunk at, from 1, or 0 when
+	// the header has no line numbers
+	oldStart int
+	lines    []diffLine
+	// Whether the hunk's last removed or added line ends the file without a
+	// newline
+	noNewlineOld bool
+	noNewlineNew bool
+}
+
+// oldLines returns the lines a hunk expects in the original: its context
+// and the lines it removes
+func (h *unifiedHunk) oldLines() []string {
+	var lines []string
+	for _, line := range h.lines {
+		if line.kind != '+' {
+			lines = append(lines, line.text)
+		}
+	}
+	return lines
+}
+
+// newLines returns the lines a hunk leaves: its context and the lines it
+// adds
+func (h *unifiedHunk) newLines() []string {
+	var lines []string
+	for _, line := range h.lines {
+		if line.kind != '-' {
+			lines = append(lines, line.text)
+		}
+	}
+	return lines
+}
+
+// parseUnifiedDiff reads the hunks of a unified diff of one file, as diff -u
+// and git print them or as language models write them: the file headers
+// may be missing, hunk headers may lack line numbers, their line counts
+// are not trusted, and a line without a prefix is taken as context, as is
+// an empty line whose space was trimmed
+func parseUnifiedDiff(text string) ([]*unifiedHunk, error) {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	var hunks []*unifiedHunk
+	var hunk *unifiedHunk
+	files := 0
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			hunk = &unifiedHunk{header: line, oldStart: hunkStart(line)}
+			hunks = append(hunks, hunk)
+			continue
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			hunk = nil
+			continue
+		case strings.HasPrefix(line, "+++ ") && hunk == nil:
+			if files++; files > 1 {
+				return nil, fmt.Errorf("%w: the diff changes more than one file", ErrInvalidPatch)
+			}
+			continue
+		case hunk == nil:
+			// diff --git, index and other lines before the first hunk
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, `\`):
+			if n := len(hunk.lines); n > 0 {
+				switch hunk.lines[n-1].kind {
+				case '-':
+					hunk.noNewlineOld = true
+				case '+':
+					hunk.noNewlineNew = true
+				default:
+					hunk.noNewlineOld, hunk.noNewlineNew = true, true
+				}
+			}
+		case strings.HasPrefix(line, "+"), strings.HasPrefix(line, "-"), strings.HasPrefix(line, " "):
+			hunk.lines = append(hunk.lines, diffLine{line[0], line[1:]})
+		default:
+			hunk.lines = append(hunk.lines, diffLine{' ', line})
+		}
+	}
+
+	if len(hunks) == 0 {
+		return nil, fmt.Errorf("%w: no hunks found", ErrInvalidPatch)
+	}
+	for _, hunk := range hunks {
+		// Blank lines ending a hunk are what separated it from the next
+		for n := len(hunk.lines); n > 0 && hunk.lines[n-1] == (diffLine{' ', ""}); n-- {
+			hunk.lines = hunk.lines[:n-1]
+		}
+	}
+	return hunks, nil
+}
+
+// hunkStart reads the line of the original a hunk header starts at, or 0
+// when it has none
+func hunkStart(header string) int {
+	fields := strings.Fields(strings.TrimPrefix(header, "@@"))
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "-") {
+		return 0
+	}
+	start, _, _ := strings.Cut(fields[0][1:], ",")
+	n, err := strconv.Atoi(start)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// applyUnifiedDiff applies the hunks of a unified diff to original one
+// after another, each where its lines are found nearest to where its
+// header puts it. A hunk whose lines are not found is tried again without
+// up to fuzz lines of context at each end, and with ignoreWhitespace with
+// the whitespace of lines disregarded; the lines of the original it keeps
+// stay as they are. Added lines get the original's line endings. Hunks
+// that do not apply are rejected with the reason and left out.
+func applyUnifiedDiff(original string, hunks []*unifiedHunk, fuzz int, ignoreWhitespace bool) (string, []PatchHunk) {
+	lines := strings.SplitAfter(original, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	ending := "\n"
+	if lineEndingsOf(countLineEndings([]byte(original), false)) == LineEndingsCRLF {
+		ending = "\r\n"
+	}
+
+	results := make([]PatchHunk, len(hunks))
+	// Lines the hunks applied so far added, less those they removed
+	delta := 0
+	for i, hunk := range hunks {
+		result := PatchHunk{Index: i + 1, Header: hunk.header, Status: HunkRejected}
+		expected := delta
+		if hunk.oldStart > 0 {
+			expected += hunk.oldStart - 1
+		}
+
+		at, trimmed, loose, found := findHunk(lines, hunk, expected, fuzz, ignoreWhitespace)
+		if !found {
+			result.Reason = rejectReason(lines, hunk, ignoreWhitespace)
+			results[i] = result
+			continue
+		}
+
+		// The hunk's lines without the context trimmed off, with the kept
+		// lines as the original has them
+		body := hunk.lines[trimmed[0] : len(hunk.lines)-trimmed[1]]
+		var replacement []string
+		position := at
+		for j, line := range body {
+			switch line.kind {
+			case ' ':
+				replacement = append(replacement, lines[position])
+				position++
+			case '-':
+				position++
+			case '+':
+				text := line.text + ending
+				if hunk.noNewlineNew && trimmed[1] == 0 && j == lastAdded(body) {
+					text = line.text
+				}
+				replacement = append(replacement, text)
+			}
+		}
+		removed := position - at
+		lines = append(lines[:at], append(replacement, lines[position:]...)...)
+		delta += len(replacement) - removed
+
+		result.Status = HunkApplied
+		result.Line = at + 1
+		if hunk.oldStart > 0 {
+			result.Offset = at - expected
+		}
+		result.Fuzz = max(trimmed[0], trimmed[1])
+		result.Whitespace = loose
+		results[i] = result
+	}
+
+	// Lines followed by others keep or get their newline
+	for i := 0; i < len(lines)-1; i++ {
+		if !strings.HasSuffix(lines[i], "\n") {
+			lines[i] += ending
+		}
+	}
+	return strings.Join(lines, ""), results
+}
+
+// lastAdded returns the index of the last added line of a hunk's lines
+func lastAdded(lines []diffLine) int {
+	for i := len(lines) - 1; i >= 0; i-- {
+		if lines[i].kind == '+' {
+			return i
+		}
+	}
+	return -1
+}
+
+// findHunk finds where the lines a hunk expects are in lines, nearest to
+// expected, first exactly and then ignoring whitespace, without ever more
+// context up to fuzz lines. It returns where the match starts, the
+// context lines left off at each end, and whether whitespace was ignored.
+func findHunk(lines []string, hunk *unifiedHunk, expected int, fuzz int, ignoreWhitespace bool) (int, [2]int, bool, bool) {
+	leading, trailing := 0, 0
+	for leading < len(hunk.lines) && hunk.lines[leading].kind == ' ' {
+		leading++
+	}
+	for trailing < len(hunk.lines)-leading && hunk.lines[len(hunk.lines)-1-trailing].kind == ' ' {
+		trailing++
+	}
+
+	for f := 0; f <= fuzz; f++ {
+		trimmed := [2]int{min(f, leading), min(f, trailing)}
+		if f > 0 && trimmed[0] < f && trimmed[1] < f {
+			// No context is left to leave off
+			break
+		}
+		body := hunk.lines[trimmed[0] : len(hunk.lines)-trimmed[1]]
+		var old []string
+		for _, line := range body {
+			if line.kind != '+' {
+				old = append(old, line.text)
+			}
+		}
+		// Lines removed from the end of a file without a newline must
+		// still end it
+		atEnd := hunk.noNewlineOld && trimmed[1] == 0
+		for _, loose := range []bool{false, true} {
+			if loose && !ignoreWhitespace {
+				break
+			}
+			if at, ok := findLines(lines, old, expected, loose, atEnd); ok {
+				return at, trimmed, loose, true
+			}
+		}
+	}
+	return 0, [2]int{}, false, false
+}
+
+// findLines finds where want is in lines, nearest to expected and before
+// it on ties. Lines compare without their endings, and loosely with all
+// runs of whitespace alike. With atEnd, want must end lines.
+func findLines(lines []string, want []string, expected int, loose bool, atEnd bool) (int, bool) {
+	if len(want) == 0 {
+		// Nothing to match: added lines go where the header puts them
+		return max(0, min(expected, len(lines))), true
+	}
+	matches := func(at int) bool {
+		if atEnd && at+len(want) != len(lines) {
+			return false
+		}
+		for j, text := range want {
+			if !sameLine(lines[at+j], text, loose) {
+				return false
+			}
+		}
+		return true
+	}
+	last := len(lines) - len(want)
+	expected = max(0, min(expected, last))
+	for distance := 0; distance <= len(lines); distance++ {
+		if at := expected - distance; at >= 0 && at <= last && matches(at) {
+			return at, true
+		}
+		if at := expected + distance; distance > 0 && at <= last && matches(at) {
+			return at, true
+		}
+		if expected-distance < 0 && expected+distance > last {
+			break
+		}
+	}
+	return 0, false
+}
+
+// sameLine tells whether a line of the original is a line of a hunk
+func sameLine(line string, text string, loose bool) bool {
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	text = strings.TrimSuffix(text, "\r")
+	if loose {
+		return strings.Join(strings.Fields(line), " ") == strings.Join(strings.Fields(text), " ")
+	}
+	return line == text
+}
+
+// rejectReason explains why a hunk did not apply: its lines are already
+// as it leaves them, or some of the lines it expects are not there
+func rejectReason(lines []string, hunk *unifiedHunk, ignoreWhitespace bool) string {
+	old, kept := hunk.oldLines(), hunk.newLines()
+	if at, ok := findLines(lines, kept, 0, ignoreWhitespace, false); ok && len(kept) > 0 {
+		return fmt.Sprintf("already applied: the lines it leaves are at line %d", at+1)
+	}
+	for _, text := range old {
+		found := false
+		for _, line := range lines {
+			if sameLine(line, text, ignoreWhitespace) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Sprintf("line not found: %q", text)
+		}
+	}
+	return "its lines are not found together in that order"
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxSymlinkDepth bounds how many dangling symlinks are followed in a row
+const maxSymlinkDepth = 40
+
+// PathEscapeError is returned for paths that lead outside the session
+// working directory, either through ".." or through a symlink
+type PathEscapeError struct {
+	Path string
+}
+
+func (e *PathEscapeError) Error() string {
+	return fmt.Sprintf("path %s is outside the session working directory", e.Path)
+}
+
+// ErrNoWorkingDir is returned for paths in a session whose working directory
+// has not been set
+var ErrNoWorkingDir = errors.New("working directory not set")
+
+// ResolvePath returns the absolute path of a path relative to the session
+// working directory, refusing paths that escape it
+func (sm *SessionManager) ResolvePath(sessionID string, relativePath string) (string, error) {
+	session, err := sm.GetSession(sessionID)
+	if err != nil {
+		return "", err
+	}
+
+	if session.WorkingDir == "" {
+		return "", fmt.Errorf("%w for session %s", ErrNoWorkingDir, sessionID)
+	}
+	if session.Remote != nil {
+		// Only operations that go through SFTP work on its files
+		return "", fmt.Errorf("%w: the working directory is on %s", ErrRemoteUnsupported, session.Remote.Target())
+	}
+
+	return confinePath(session.WorkingDir, relativePath)
+}
+
+// confinePath joins relativePath to root and checks the result stays inside
+// root, both as written and once symlinks are resolved. Absolute paths are
+// taken as relative to root.
+func confinePath(root string, relativePath string) (string, error) {
+	fullPath := filepath.Join(root, relativePath)
+	if !isWithin(root, fullPath) {
+		return "", &PathEscapeError{Path: relativePath}
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+
+	realPath, err := resolveSymlinks(fullPath, 0)
+	if err != nil {
+		return "", err
+	}
+
+	if !isWithin(realRoot, realPath) {
+		return "", &PathEscapeError{Path: relativePath}
+	}
+	return fullPath, nil
+}
+
+// resolveSymlinks resolves the symlinks in path. Parts of the path that do
+// not exist yet, such as a file about to be created, cannot be symlinks, so
+// only the longest existing prefix is resolved; a dangling symlink is
+// followed to where writing through it would create its target.
+func resolveSymlinks(path string, depth int) (string, error) {
+	existing := path
+	var missing []string
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		missing = append([]string{filepath.Base(existing)}, missing...)
+		existing = parent
+	}
+
+	realPath, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		target, readErr := os.Readlink(existing)
+		if readErr != nil || depth >= maxSymlinkDepth {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(existing), target)
+		}
+		if realPath, err = resolveSymlinks(target, depth+1); err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(append([]string{realPath}, missing...)...), nil
+}
+
+// isWithin reports whether path is root or lies below it
+func isWithin(root string, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// pathFixture builds a working directory with symlinks leading inside and
+// outside it, and a directory outside it, returning both
+func pathFixture(t *testing.T) (string, string) {
+	t.Helper()
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	outside := filepath.Join(base, "outside")
+	for _, dir := range []string{filepath.Join(root, "sub"), outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{filepath.Join(root, "sub", "file.txt"), filepath.Join(outside, "secret.txt")} {
+		if err := os.WriteFile(file, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"linkdir":      outside,
+		"linkfile":     filepath.Join(outside, "secret.txt"),
+		"relativeout":  "../outside",
+		"insidelink":   "sub",
+		"insidefile":   filepath.Join(root, "sub", "file.txt"),
+		"danglingout":  filepath.Join(outside, "new.txt"),
+		"danglingin":   filepath.Join(root, "sub", "new.txt"),
+		"danglingdeep": "danglingout",
+		"loop":         "loop",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root, outside
+}
+
+func TestConfinePath(t *testing.T) {
+	root, _ := pathFixture(t)
+
+	tests := []struct {
+		name   string
+		path   string
+		escape bool
+		err    bool // Fails otherwise than by escaping
+	}{
+		{name: "file", path: "sub/file.txt"},
+		{name: "root", path: "."},
+		{name: "empty", path: ""},
+		{name: "new file", path: "new.txt"},
+		{name: "new file in new directory", path: "a/b/new.txt"},
+		{name: "dot dot inside", path: "sub/../sub/file.txt"},
+		{name: "dot dot escape", path: "../outside/secret.txt", escape: true},
+		{name: "nested dot dot escape", path: "sub/../../outside", escape: true},
+		{name: "dot dot to root's parent", path: "..", escape: true},
+		{name: "absolute path", path: "/sub/file.txt"},
+		{name: "absolute system path", path: "/etc/passwd"},
+		{name: "absolute dot dot escape", path: "/../outside/secret.txt", escape: true},
+		{name: "symlinked directory outside", path: "linkdir", escape: true},
+		{name: "file in symlinked directory outside", path: "linkdir/secret.txt", escape: true},
+		{name: "relative symlink outside", path: "relativeout/secret.txt", escape: true},
+		{name: "symlinked file outside", path: "linkfile", escape: true},
+		{name: "symlinked directory inside", path: "insidelink/file.txt"},
+		{name: "symlinked file inside", path: "insidefile"},
+		{name: "dangling symlink outside", path: "danglingout", escape: true},
+		{name: "dangling symlink inside", path: "danglingin"},
+		{name: "chained dangling symlink outside", path: "danglingdeep", escape: true},
+		{name: "new file under symlinked parent outside", path: "linkdir/new.txt", escape: true},
+		{name: "new directory under symlinked parent outside", path: "linkdir/a/new.txt", escape: true},
+		{name: "new file under symlinked parent inside", path: "insidelink/new.txt"},
+		{name: "symlink loop", path: "loop", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := confinePath(root, tt.path)
+
+			var escape *PathEscapeError
+			switch {
+			case tt.escape:
+				if !errors.As(err, &escape) {
+					t.Fatalf("confinePath(%q) = %q, %v; want a PathEscapeError", tt.path, got, err)
+				}
+				if escape.Path != tt.path {
+					t.Errorf("PathEscapeError.Path = %q, want %q", escape.Path, tt.path)
+				}
+			case tt.err:
+				if err == nil || errors.As(err, &escape) {
+					t.Fatalf("confinePath(%q) = %q, %v; want an error other than a PathEscapeError", tt.path, got, err)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("confinePath(%q) failed: %v", tt.path, err)
+				}
+				if want := filepath.Join(root, tt.path); got != want {
+					t.Errorf("confinePath(%q) = %q, want %q", tt.path, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestResolvePath(t *testing.T) {
+	root, _ := pathFixture(t)
+	sm := NewSessionManager()
+	session, err := sm.CreateSession(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sm.ResolvePath(session.ID, "sub/file.txt"); !errors.Is(err, ErrNoWorkingDir) {
+		t.Fatalf("ResolvePath without a working directory = %v, want ErrNoWorkingDir", err)
+	}
+	if err := sm.SetWorkingDirectory(session.ID, root); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		escape bool
+	}{
+		{path: "sub/file.txt"},
+		{path: "../outside/secret.txt", escape: true},
+		{path: "linkdir/secret.txt", escape: true},
+		{path: "linkfile", escape: true},
+		{path: "danglingout", escape: true},
+		{path: "linkdir/new.txt", escape: true},
+	}
+	for _, tt := range tests {
+		got, err := sm.ResolvePath(session.ID, tt.path)
+		var escape *PathEscapeError
+		if tt.escape != errors.As(err, &escape) {
+			t.Errorf("ResolvePath(%q) = %q, %v; want escape %t", tt.path, got, err, tt.escape)
+		}
+	}
+}
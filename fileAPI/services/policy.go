@@ -0,0 +1,290 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Scopes granted to API keys. Read covers GET requests, session setup and
+// read-only queries, write covers changing files (fileAPI), execute covers
+// running commands and changing session state (terminalAPI), and admin
+// grants every scope.
+const (
+	ScopeRead    = "read"
+	ScopeWrite   = "write"
+	ScopeExecute = "execute"
+	ScopeAdmin   = "admin"
+)
+
+// Built-in roles
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+// defaultRoles are the scopes of the built-in roles; a policy may redefine
+// them or add its own
+var defaultRoles = map[string][]string{
+	RoleViewer:   {ScopeRead},
+	RoleOperator: {ScopeRead, ScopeWrite, ScopeExecute},
+	RoleAdmin:    {ScopeAdmin},
+}
+
+// defaultRouteScopes are routes that need another scope than their method
+// implies: session setup and read-only queries only need read, while
+// deleting sessions and reading the audit log or its event stream need
+// admin
+var defaultRouteScopes = map[string]string{
+	"POST /sessions":                          ScopeRead,
+	"PATCH /sessions/:sessionId":              ScopeRead,
+	"POST /sessions/:sessionId/touch":         ScopeRead,
+	"PUT /sessions/:sessionId/cwd":            ScopeRead,
+	"POST /sessions/:sessionId/diff":          ScopeRead,
+	"POST /sessions/:sessionId/diff-dirs":     ScopeRead,
+	"POST /sessions/:sessionId/patch/preview": ScopeRead,
+	"POST /sessions/:sessionId/merge":         ScopeRead,
+	"POST /sessions/:sessionId/extract":       ScopeRead,
+	"POST /sessions/:sessionId/search":        ScopeRead,
+	"POST /sessions/:sessionId/batch-read":    ScopeRead,
+	"POST /sessions/:sessionId/query":         ScopeRead,
+	"DELETE /sessions/:sessionId":             ScopeAdmin,
+	"GET /audit":                              ScopeAdmin,
+	"GET /events":                             ScopeAdmin,
+}
+
+// policyRoutes manage the policy itself and always need admin, so a policy
+// cannot lock admins out or hand policy changes to other keys
+var policyRoutes = map[string]bool{
+	"GET /policy": true,
+	"PUT /policy": true,
+}
+
+// Policy assigns scopes to roles and the scope each route needs. Routes
+// are keyed by method and route pattern, e.g. "POST /sessions/:sessionId/commands".
+// Routes that are not listed need the read scope for GET requests and the
+// service's default scope otherwise: write here and execute in terminalAPI.
+type Policy struct {
+	Roles  map[string][]string `json:"roles"`
+	Routes map[string]string   `json:"routes"`
+}
+
+// PolicyService holds the access policy, loaded from the policy file shared
+// with terminalAPI and replaceable through the API
+type PolicyService struct {
+	path   string
+	roles  map[string][]string
+	routes map[string]string
+	audit  *AuditService
+	mutex  sync.RWMutex
+}
+
+// NewPolicyService loads the policy from OSAI_POLICY_FILE, or from
+// ~/.osai/policy.json, falling back to the defaults when it does not exist
+func NewPolicyService(audit *AuditService) (*PolicyService, error) {
+	ps := &PolicyService{
+		path:  policyPath(),
+		audit: audit,
+	}
+
+	data, err := os.ReadFile(ps.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		var policy Policy
+		if err := json.Unmarshal(data, &policy); err != nil {
+			return nil, fmt.Errorf("invalid policy file %s: %w", ps.path, err)
+		}
+		if err := ps.apply(&policy); err != nil {
+			return nil, fmt.Errorf("invalid policy file %s: %w", ps.path, err)
+		}
+		slog.Info("loaded access policy", "path", ps.path)
+		return ps, nil
+	}
+
+	ps.apply(&Policy{})
+	return ps, nil
+}
+
+// policyPath returns where the policy is stored, shared with terminalAPI
+func policyPath() string {
+	if path := os.Getenv("OSAI_POLICY_FILE"); path != "" {
+		return path
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".osai", "policy.json")
+	}
+	return filepath.Join(os.TempDir(), "osai", "policy.json")
+}
+
+// apply validates a policy and makes it effective on top of the defaults
+func (ps *PolicyService) apply(policy *Policy) error {
+	roles := make(map[string][]string)
+	for role, scopes := range defaultRoles {
+		roles[role] = scopes
+	}
+	for role, scopes := range policy.Roles {
+		if role == RoleAdmin {
+			return errors.New("the admin role cannot be redefined")
+		}
+		if role == "" || isScope(role) {
+			return fmt.Errorf("invalid role name: %q", role)
+		}
+		for _, scope := range scopes {
+			if !isScope(scope) {
+				return fmt.Errorf("unknown scope %q in role %s", scope, role)
+			}
+		}
+		roles[role] = scopes
+	}
+
+	routes := make(map[string]string)
+	for route, scope := range defaultRouteScopes {
+		routes[route] = scope
+	}
+	for route, scope := range policy.Routes {
+		method, path, found := strings.Cut(route, " ")
+		if !found || method != strings.ToUpper(method) || !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("invalid route %q, expected a method and path such as \"POST /sessions\"", route)
+		}
+		if policyRoutes[route] {
+			return fmt.Errorf("route %s always requires admin", route)
+		}
+		if !isScope(scope) {
+			return fmt.Errorf("unknown scope %q for route %s", scope, route)
+		}
+		routes[route] = scope
+	}
+
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	ps.roles = roles
+	ps.routes = routes
+	return nil
+}
+
+// isScope reports whether name is a known scope
+func isScope(name string) bool {
+	switch name {
+	case ScopeRead, ScopeWrite, ScopeExecute, ScopeAdmin:
+		return true
+	}
+	return false
+}
+
+// GetPolicy returns the effective policy, defaults included
+func (ps *PolicyService) GetPolicy() *Policy {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	policy := &Policy{
+		Roles:  make(map[string][]string),
+		Routes: make(map[string]string),
+	}
+	for role, scopes := range ps.roles {
+		policy.Roles[role] = append([]string{}, scopes...)
+	}
+	for route, scope := range ps.routes {
+		policy.Routes[route] = scope
+	}
+	for route := range policyRoutes {
+		policy.Routes[route] = ScopeAdmin
+	}
+	return policy
+}
+
+// SetPolicy replaces the configured roles and routes and saves them to the
+// policy file. Built-in roles and routes that the policy does not mention
+// keep their defaults.
+func (ps *PolicyService) SetPolicy(policy *Policy) (err error) {
+	defer func() {
+		ps.audit.Record(AuditEntry{Operation: "policy.update", Target: ps.path}, err)
+	}()
+
+	if err := ps.apply(policy); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(policy, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ps.path), 0755); err != nil {
+		return err
+	}
+	tmp := ps.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, ps.path); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ValidateGrants checks that every grant is a known role or scope
+func (ps *PolicyService) ValidateGrants(grants []string) error {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	for _, grant := range grants {
+		if _, isRole := ps.roles[grant]; !isRole && !isScope(grant) {
+			roles := make([]string, 0, len(ps.roles))
+			for role := range ps.roles {
+				roles = append(roles, role)
+			}
+			sort.Strings(roles)
+			return fmt.Errorf("unknown role or scope %q, expected one of %s or a scope", grant, strings.Join(roles, ", "))
+		}
+	}
+	return nil
+}
+
+// HasScope reports whether roles and scopes granted to a key include scope,
+// directly or through admin
+func (ps *PolicyService) HasScope(grants []string, scope string) bool {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	for _, grant := range grants {
+		scopes, isRole := ps.roles[grant]
+		if !isRole {
+			scopes = []string{grant}
+		}
+		for _, granted := range scopes {
+			if granted == ScopeAdmin || granted == scope {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RequiredScope returns the scope a route needs: the policy's rule for it,
+// or read for GET and HEAD requests and defaultScope otherwise
+func (ps *PolicyService) RequiredScope(method string, path string, defaultScope string) string {
+	route := method + " " + path
+	if policyRoutes[route] {
+		return ScopeAdmin
+	}
+
+	ps.mutex.RLock()
+	scope, exists := ps.routes[route]
+	ps.mutex.RUnlock()
+	if exists {
+		return scope
+	}
+
+	if method == "GET" || method == "HEAD" {
+		return ScopeRead
+	}
+	return defaultScope
+}
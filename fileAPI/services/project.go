@@ -128,8 +128,12 @@ func (ps *ProjectService) GetProjectSummary(sessionID string) (*ProjectSummary,
 		return nil, err
 	}
 	
-	ps.sessionManager.LogActivity(sessionID, fmt.Sprintf("Generated project summary for %s", summary.Name))
-	fmt.Printf("[TERMINAL] Session %s: Generated project summary for %s\n", sessionID, summary.Name)
+	ps.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityProject,
+		Target:   summary.Name,
+		Message:  "Generated project summary for " + summary.Name,
+	})
+	ps.sessionManager.Audit(sessionID, "project.summary", summary.Name, "", nil)
 	
 	return summary, nil
 }
@@ -200,7 +204,7 @@ func (ps *ProjectService) ExtractCodeContext(sessionID string, maxFiles int) (*C
 			break
 		}
 		
-		content, err := ps.fileService.ReadFile(sessionID, keyFile)
+		content, err := ps.fileService.ReadTextFile(sessionID, keyFile)
 		if err == nil {
 			fileInfo, err := ps.createFileInfo(sessionID, keyFile, content)
 			if err == nil {
@@ -213,7 +217,7 @@ func (ps *ProjectService) ExtractCodeContext(sessionID string, maxFiles int) (*C
 	// If no key files were detected or read, try to read some files from the directory
 	if filesAdded == 0 {
 		// Get a list of files in the root directory
-		files, err := ps.fileService.ListFilesWithMetadata(sessionID, ".")
+		files, err := ps.fileService.ListFilesWithMetadata(sessionID, ".", false)
 		if err == nil && len(files) > 0 {
 			// Sort files by size (smaller files first, as they're likely config files)
 			sort.Slice(files, func(i, j int) bool {
@@ -231,7 +235,7 @@ func (ps *ProjectService) ExtractCodeContext(sessionID string, maxFiles int) (*C
 					continue
 				}
 				
-				content, err := ps.fileService.ReadFile(sessionID, file.Path)
+				content, err := ps.fileService.ReadTextFile(sessionID, file.Path)
 				if err == nil {
 					fileInfo, err := ps.createFileInfo(sessionID, file.Path, content)
 					if err == nil {
@@ -243,8 +247,11 @@ func (ps *ProjectService) ExtractCodeContext(sessionID string, maxFiles int) (*C
 		}
 	}
 	
-	ps.sessionManager.LogActivity(sessionID, fmt.Sprintf("Extracted code context with %d main files", len(context.MainFiles)))
-	fmt.Printf("[TERMINAL] Session %s: Extracted code context with %d main files\n", sessionID, len(context.MainFiles))
+	ps.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityProject,
+		Message:  fmt.Sprintf("Extracted code context with %d main files", len(context.MainFiles)),
+	})
+	ps.sessionManager.Audit(sessionID, "project.context", "", fmt.Sprintf("%d main files", len(context.MainFiles)), nil)
 	
 	return context, nil
 }
@@ -259,7 +266,7 @@ func (ps *ProjectService) createFileInfo(sessionID string, path string, content
 	
 	// Detect language based on file extension
 	ext := strings.ToLower(filepath.Ext(path))
-	fileInfo.Language = ps.detectLanguage(ext)
+	fileInfo.Language = detectLanguage(ext)
 	
 	// Extract dependencies based on file type
 	deps := ps.extractFileDependencies(string(content), ext)
@@ -271,7 +278,7 @@ func (ps *ProjectService) createFileInfo(sessionID string, path string, content
 }
 
 // detectLanguage detects the programming language from file extension
-func (ps *ProjectService) detectLanguage(ext string) string {
+func detectLanguage(ext string) string {
 	switch ext {
 	case ".go":
 		return "Go"
@@ -613,10 +620,6 @@ func (ps *ProjectService) extractCDependencies(content string) []string {
 // extractProjectDependencies extracts project-level dependencies
 func (ps *ProjectService) extractProjectDependencies(sessionID string) []string {
 	var dependencies []string
-	session, err := ps.sessionManager.GetSession(sessionID)
-	if err != nil {
-		return dependencies
-	}
 	
 	// Map of common dependency files and extraction functions
 	depFiles := map[string]func(string, string) []string{
@@ -632,7 +635,10 @@ func (ps *ProjectService) extractProjectDependencies(sessionID string) []string
 	
 	// Check each dependency file
 	for filename, extractFunc := range depFiles {
-		filePath := filepath.Join(session.WorkingDir, filename)
+		filePath, err := ps.sessionManager.ResolvePath(sessionID, filename)
+		if err != nil {
+			continue
+		}
 		if _, err := os.Stat(filePath); err == nil {
 			content, err := ioutil.ReadFile(filePath)
 			if err == nil {
@@ -0,0 +1,581 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Query errors: ErrInvalidQuery for queries that do not parse or do not
+// fit the data, ErrInvalidData for files that are not in their format
+var (
+	ErrInvalidQuery = errors.New("invalid query")
+	ErrInvalidData  = errors.New("invalid data")
+)
+
+// Formats of the data files a query reads
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+	FormatCSV  = "csv"
+	FormatTSV  = "tsv"
+)
+
+// maxQueryResults caps the values a query returns
+const maxQueryResults = 10000
+
+// QueryRequest asks for the values of a query in a data file. The format
+// comes from the file's extension unless Format names it.
+type QueryRequest struct {
+	Path   string `json:"path"`
+	Query  string `json:"query"`
+	Format string `json:"format,omitempty"` // json, yaml, toml, csv or tsv
+}
+
+// QueryResult holds the values a query selected, in the order it did
+type QueryResult struct {
+	Path      string        `json:"path"`
+	Format    string        `json:"format"`
+	Query     string        `json:"query"`
+	Results   []interface{} `json:"results"`
+	Count     int           `json:"count"`
+	Truncated bool          `json:"truncated,omitempty"`
+}
+
+// Query reads a JSON, YAML, TOML, CSV or TSV file and returns the values a
+// jq-style query selects from it. Files holding several documents, as
+// multi-document YAML and newline-delimited JSON do, are queried document
+// by document; CSV and TSV files are arrays of objects keyed by their
+// header row.
+func (fs *FileService) Query(sessionID string, req *QueryRequest) (result *QueryResult, err error) {
+	defer func() {
+		fs.sessionManager.Audit(sessionID, "file.query", req.Path, req.Query, err)
+	}()
+
+	query, err := parseQuery(req.Query)
+	if err != nil {
+		return nil, err
+	}
+	format := req.Format
+	if format == "" {
+		format = dataFormat(req.Path)
+	}
+	content, err := fs.ReadTextFile(sessionID, req.Path)
+	if err != nil {
+		return nil, err
+	}
+	documents, err := decodeData(content, format)
+	if err != nil {
+		return nil, err
+	}
+
+	result = &QueryResult{Path: req.Path, Format: format, Query: req.Query, Results: []interface{}{}}
+	for _, document := range documents {
+		values, err := query.eval(document)
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range values {
+			if len(result.Results) == maxQueryResults {
+				result.Truncated = true
+				break
+			}
+			result.Results = append(result.Results, value)
+		}
+	}
+	result.Count = len(result.Results)
+
+	fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityFile,
+		Target:   req.Path,
+		Message:  fmt.Sprintf("Queried %s for %s (%d results)", req.Path, req.Query, result.Count),
+	})
+	return result, nil
+}
+
+// dataFormat names the format of a data file by its extension, or "" when
+// it has none of theirs
+func dataFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".jsonl", ".ndjson", ".geojson":
+		return FormatJSON
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	case ".csv":
+		return FormatCSV
+	case ".tsv":
+		return FormatTSV
+	}
+	return ""
+}
+
+// decodeData parses the documents of a data file into the values
+// encoding/json gives: maps with string keys, slices, strings, numbers,
+// booleans and nil
+func decodeData(content []byte, format string) ([]interface{}, error) {
+	var documents []interface{}
+	switch format {
+	case FormatJSON:
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.UseNumber()
+		for {
+			var document interface{}
+			if err := decoder.Decode(&document); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidData, err)
+			}
+			documents = append(documents, document)
+		}
+	case FormatYAML:
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		for {
+			var document interface{}
+			if err := decoder.Decode(&document); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidData, err)
+			}
+			documents = append(documents, normalizeData(document))
+		}
+	case FormatTOML:
+		var document map[string]interface{}
+		if err := toml.Unmarshal(content, &document); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidData, err)
+		}
+		documents = append(documents, normalizeData(document))
+	case FormatCSV, FormatTSV:
+		reader := csv.NewReader(bytes.NewReader(content))
+		if format == FormatTSV {
+			reader.Comma = '\t'
+		}
+		reader.FieldsPerRecord = -1
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidData, err)
+		}
+		rows := []interface{}{}
+		for i := 1; i < len(records); i++ {
+			row := make(map[string]interface{}, len(records[0]))
+			for j, name := range records[0] {
+				if j < len(records[i]) {
+					row[name] = records[i][j]
+				} else {
+					row[name] = nil
+				}
+			}
+			rows = append(rows, row)
+		}
+		documents = append(documents, rows)
+	case "":
+		return nil, fmt.Errorf("%w: unknown format, expected format json, yaml, toml, csv or tsv", ErrInvalidQuery)
+	default:
+		return nil, fmt.Errorf("%w: unknown format %q, expected json, yaml, toml, csv or tsv", ErrInvalidQuery, format)
+	}
+	return documents, nil
+}
+
+// normalizeData turns the maps and slices YAML and TOML decode into the
+// ones encoding/json gives, so queries and responses handle them alike
+func normalizeData(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalizeData(item)
+		}
+		return v
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			converted[fmt.Sprint(key)] = normalizeData(item)
+		}
+		return converted
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeData(item)
+		}
+		return v
+	case []map[string]interface{}:
+		converted := make([]interface{}, len(v))
+		for i, item := range v {
+			converted[i] = normalizeData(item)
+		}
+		return converted
+	}
+	return value
+}
+
+// query is a parsed query: filters separated by pipes, each taking the
+// values of the one before
+type query [][]querySegment
+
+// querySegment is a step of a filter. Kinds are field, index, slice,
+// iterate and descend, which with a key selects that key of every object
+// below; keys and length are functions.
+type querySegment struct {
+	kind  string
+	key   string
+	index int
+	start *int
+	end   *int
+}
+
+// parseQuery parses a jq-style query: paths such as .scripts.build,
+// .items[0].name, .items[], .items[1:3], ."key.with.dots" or ..name, with
+// $ for . as JSONPath writes it and [*] for [], separated by pipes and
+// the functions keys and length
+func parseQuery(text string) (query, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, fmt.Errorf("%w: query is required", ErrInvalidQuery)
+	}
+	var q query
+	for _, filter := range splitPipes(text) {
+		filter = strings.TrimSpace(filter)
+		switch filter {
+		case "keys", "length":
+			q = append(q, []querySegment{{kind: filter}})
+			continue
+		}
+		segments, err := parseFilter(filter)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s in %q", ErrInvalidQuery, err.Error(), filter)
+		}
+		q = append(q, segments)
+	}
+	return q, nil
+}
+
+// splitPipes splits a query at the pipes outside its quoted keys
+func splitPipes(text string) []string {
+	var filters []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(text); i++ {
+		switch {
+		case quoted && text[i] == '\\':
+			i++
+		case text[i] == '"':
+			quoted = !quoted
+		case !quoted && text[i] == '|':
+			filters = append(filters, text[start:i])
+			start = i + 1
+		}
+	}
+	return append(filters, text[start:])
+}
+
+// parseFilter parses a path of a query
+func parseFilter(filter string) ([]querySegment, error) {
+	if strings.HasPrefix(filter, "$") {
+		filter = "." + filter[1:]
+	}
+	if !strings.HasPrefix(filter, ".") {
+		return nil, errors.New("paths start with .")
+	}
+	var segments []querySegment
+	for i := 0; i < len(filter); {
+		switch {
+		case strings.HasPrefix(filter[i:], ".."):
+			i += 2
+			segment := querySegment{kind: "descend"}
+			if i < len(filter) && isKeyByte(filter[i]) {
+				start := i
+				for i < len(filter) && isKeyByte(filter[i]) {
+					i++
+				}
+				segment.key = filter[start:i]
+			}
+			segments = append(segments, segment)
+		case filter[i] == '.':
+			i++
+			if i < len(filter) && filter[i] == '"' {
+				key, n, err := quotedKey(filter[i:])
+				if err != nil {
+					return nil, err
+				}
+				segments = append(segments, querySegment{kind: "field", key: key})
+				i += n
+			} else if i < len(filter) && isKeyByte(filter[i]) {
+				start := i
+				for i < len(filter) && isKeyByte(filter[i]) {
+					i++
+				}
+				segments = append(segments, querySegment{kind: "field", key: filter[start:i]})
+			}
+		case filter[i] == '[':
+			end := strings.IndexByte(filter[i:], ']')
+			if filter[i+1:min(i+2, len(filter))] == `"` {
+				key, n, err := quotedKey(filter[i+1:])
+				if err != nil {
+					return nil, err
+				}
+				end = n + 1
+				if i+end >= len(filter) || filter[i+end] != ']' {
+					return nil, errors.New("missing ]")
+				}
+				segments = append(segments, querySegment{kind: "field", key: key})
+				i += end + 1
+				continue
+			}
+			if end < 0 {
+				return nil, errors.New("missing ]")
+			}
+			segment, err := parseBrackets(strings.TrimSpace(filter[i+1 : i+end]))
+			if err != nil {
+				return nil, err
+			}
+			segments = append(segments, segment)
+			i += end + 1
+		default:
+			return nil, fmt.Errorf("unexpected %q", filter[i:])
+		}
+	}
+	return segments, nil
+}
+
+// parseBrackets parses what is between brackets: nothing or * to iterate,
+// an index, or a slice
+func parseBrackets(inside string) (querySegment, error) {
+	if inside == "" || inside == "*" {
+		return querySegment{kind: "iterate"}, nil
+	}
+	if from, to, ok := strings.Cut(inside, ":"); ok {
+		segment := querySegment{kind: "slice"}
+		for _, bound := range []struct {
+			text  string
+			value **int
+		}{{from, &segment.start}, {to, &segment.end}} {
+			if text := strings.TrimSpace(bound.text); text != "" {
+				n, err := strconv.Atoi(text)
+				if err != nil {
+					return segment, fmt.Errorf("invalid slice [%s]", inside)
+				}
+				*bound.value = &n
+			}
+		}
+		return segment, nil
+	}
+	n, err := strconv.Atoi(inside)
+	if err != nil {
+		return querySegment{}, fmt.Errorf("invalid index [%s]", inside)
+	}
+	return querySegment{kind: "index", index: n}, nil
+}
+
+// quotedKey reads the quoted key text starts with, returning it and the
+// bytes it took
+func quotedKey(text string) (string, int, error) {
+	for i := 1; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '"':
+			key, err := strconv.Unquote(text[:i+1])
+			if err != nil {
+				return "", 0, fmt.Errorf("invalid key %s", text[:i+1])
+			}
+			return key, i + 1, nil
+		}
+	}
+	return "", 0, errors.New("unterminated key")
+}
+
+// isKeyByte tells whether b may be part of a key written without quotes
+func isKeyByte(b byte) bool {
+	return b == '_' || b == '-' || b == '$' || b == '@' || b >= 0x80 ||
+		(b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9')
+}
+
+// eval returns the values the query selects from a document
+func (q query) eval(document interface{}) ([]interface{}, error) {
+	values := []interface{}{document}
+	for _, filter := range q {
+		for _, segment := range filter {
+			var next []interface{}
+			for _, value := range values {
+				selected, err := segment.apply(value)
+				if err != nil {
+					return nil, err
+				}
+				next = append(next, selected...)
+				if len(next) > maxQueryResults {
+					break
+				}
+			}
+			values = next
+		}
+	}
+	return values, nil
+}
+
+// apply returns the values a segment selects from a value. As in jq, keys
+// and indexes missing from a value, or of null, give null; they are
+// errors on values of other types.
+func (segment querySegment) apply(value interface{}) ([]interface{}, error) {
+	switch segment.kind {
+	case "field":
+		switch v := value.(type) {
+		case map[string]interface{}:
+			return []interface{}{v[segment.key]}, nil
+		case nil:
+			return []interface{}{nil}, nil
+		}
+		return nil, fmt.Errorf("%w: cannot index %s with %q", ErrInvalidQuery, typeName(value), segment.key)
+	case "index":
+		switch v := value.(type) {
+		case []interface{}:
+			i := segment.index
+			if i < 0 {
+				i += len(v)
+			}
+			if i < 0 || i >= len(v) {
+				return []interface{}{nil}, nil
+			}
+			return []interface{}{v[i]}, nil
+		case nil:
+			return []interface{}{nil}, nil
+		}
+		return nil, fmt.Errorf("%w: cannot index %s with %d", ErrInvalidQuery, typeName(value), segment.index)
+	case "slice":
+		switch v := value.(type) {
+		case []interface{}:
+			start, end := sliceBounds(segment.start, segment.end, len(v))
+			return []interface{}{v[start:end]}, nil
+		case string:
+			runes := []rune(v)
+			start, end := sliceBounds(segment.start, segment.end, len(runes))
+			return []interface{}{string(runes[start:end])}, nil
+		case nil:
+			return []interface{}{nil}, nil
+		}
+		return nil, fmt.Errorf("%w: cannot slice %s", ErrInvalidQuery, typeName(value))
+	case "iterate":
+		switch v := value.(type) {
+		case []interface{}:
+			return v, nil
+		case map[string]interface{}:
+			values := make([]interface{}, 0, len(v))
+			for _, key := range sortedKeys(v) {
+				values = append(values, v[key])
+			}
+			return values, nil
+		}
+		return nil, fmt.Errorf("%w: cannot iterate over %s", ErrInvalidQuery, typeName(value))
+	case "descend":
+		var values []interface{}
+		descend(value, func(v interface{}) {
+			if segment.key == "" {
+				values = append(values, v)
+			} else if object, ok := v.(map[string]interface{}); ok {
+				if item, ok := object[segment.key]; ok {
+					values = append(values, item)
+				}
+			}
+		})
+		return values, nil
+	case "keys":
+		switch v := value.(type) {
+		case map[string]interface{}:
+			keys := []interface{}{}
+			for _, key := range sortedKeys(v) {
+				keys = append(keys, key)
+			}
+			return []interface{}{keys}, nil
+		case []interface{}:
+			indexes := make([]interface{}, len(v))
+			for i := range v {
+				indexes[i] = i
+			}
+			return []interface{}{indexes}, nil
+		}
+		return nil, fmt.Errorf("%w: %s has no keys", ErrInvalidQuery, typeName(value))
+	case "length":
+		switch v := value.(type) {
+		case map[string]interface{}:
+			return []interface{}{len(v)}, nil
+		case []interface{}:
+			return []interface{}{len(v)}, nil
+		case string:
+			return []interface{}{utf8.RuneCountInString(v)}, nil
+		case nil:
+			return []interface{}{0}, nil
+		}
+		return nil, fmt.Errorf("%w: %s has no length", ErrInvalidQuery, typeName(value))
+	}
+	return nil, fmt.Errorf("%w: unknown step %s", ErrInvalidQuery, segment.kind)
+}
+
+// descend calls visit with a value and everything in it, parents first
+func descend(value interface{}, visit func(interface{})) {
+	visit(value)
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, key := range sortedKeys(v) {
+			descend(v[key], visit)
+		}
+	case []interface{}:
+		for _, item := range v {
+			descend(item, visit)
+		}
+	}
+}
+
+// sliceBounds resolves the bounds of a slice of length items, counting
+// negative ones from the end as Python does
+func sliceBounds(from *int, to *int, length int) (int, int) {
+	resolve := func(bound *int, otherwise int) int {
+		if bound == nil {
+			return otherwise
+		}
+		n := *bound
+		if n < 0 {
+			n += length
+		}
+		return max(0, min(n, length))
+	}
+	start, end := resolve(from, 0), resolve(to, length)
+	return start, max(start, end)
+}
+
+// sortedKeys returns the keys of an object in order
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// typeName names the type of a value as JSON does
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	case json.Number, int, int64, uint64, float64:
+		return "number"
+	}
+	return "string"
+}
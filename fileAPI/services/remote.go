@@ -0,0 +1,549 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// ErrRemoteUnsupported is returned for operations that need the working
+// directory on this host, such as archives or watching, in remote sessions
+var ErrRemoteUnsupported = errors.New("not supported for remote sessions")
+
+// ErrInvalidRemote is returned for remote configurations that cannot be used
+var ErrInvalidRemote = errors.New("invalid remote configuration")
+
+// ErrRemoteForbidden is returned when a key that is not an admin key asks for
+// a remote session, which would reach other machines with the server's own
+// SSH keys and agent
+var ErrRemoteForbidden = errors.New("only admin keys may create remote sessions")
+
+// remoteConnectTimeout bounds how long connecting to a remote machine takes
+const remoteConnectTimeout = 10 * time.Second
+
+// remoteHostPattern matches host names and addresses, and remoteUserPattern
+// user names, as terminalAPI accepts them
+var (
+	remoteHostPattern = regexp.MustCompile(`^[A-Za-z0-9_.:\[\]%-]+$`)
+	remoteUserPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+)
+
+// RemoteConfig describes the machine a remote session's working directory
+// lives on. Files are reached there over SFTP, authenticated by key, and the
+// host key must already be known: remote sessions never trust a host on
+// first use.
+type RemoteConfig struct {
+	Host           string `json:"host"`
+	Port           int    `json:"port,omitempty"` // Defaults to 22
+	User           string `json:"user"`
+	IdentityFile   string `json:"identityFile,omitempty"`   // Private key on this server; ~/.ssh keys and the agent otherwise
+	KnownHostsFile string `json:"knownHostsFile,omitempty"` // Defaults to ~/.ssh/known_hosts
+}
+
+// ApplyDefaults fills unset remote options and validates the rest
+func (cfg *RemoteConfig) ApplyDefaults() error {
+	if !remoteHostPattern.MatchString(cfg.Host) || strings.HasPrefix(cfg.Host, "-") {
+		return fmt.Errorf("%w: remote host is required and must be a host name or address", ErrInvalidRemote)
+	}
+	if !remoteUserPattern.MatchString(cfg.User) {
+		return fmt.Errorf("%w: remote user is required and must be a user name", ErrInvalidRemote)
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 22
+	}
+	if cfg.Port < 1 || cfg.Port > 65535 {
+		return fmt.Errorf("%w: invalid remote port %d", ErrInvalidRemote, cfg.Port)
+	}
+	for _, file := range []string{cfg.IdentityFile, cfg.KnownHostsFile} {
+		if file != "" && !filepath.IsAbs(file) {
+			return fmt.Errorf("%w: remote key files must be absolute paths: %s", ErrInvalidRemote, file)
+		}
+	}
+	return nil
+}
+
+// Target names the remote machine as user@host:port
+func (cfg *RemoteConfig) Target() string {
+	return cfg.User + "@" + cfg.Host + ":" + strconv.Itoa(cfg.Port)
+}
+
+// remotePool keeps one SFTP connection per remote machine and key, shared
+// by the sessions using it and dropped when the connection closes
+type remotePool struct {
+	mutex   sync.Mutex
+	clients map[string]*sftp.Client
+}
+
+// client returns the SFTP connection for cfg, connecting when there is none.
+// Connecting happens outside the lock, so an unreachable machine does not
+// hold up sessions on other ones.
+func (p *remotePool) client(cfg *RemoteConfig) (*sftp.Client, error) {
+	key := cfg.Target() + "|" + cfg.IdentityFile + "|" + cfg.KnownHostsFile
+	p.mutex.Lock()
+	client, exists := p.clients[key]
+	p.mutex.Unlock()
+	if exists {
+		return client, nil
+	}
+
+	conn, err := dialRemote(cfg)
+	if err != nil {
+		return nil, err
+	}
+	client, err = sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start SFTP on %s: %v", cfg.Target(), err)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if existing, exists := p.clients[key]; exists {
+		client.Close()
+		return existing, nil
+	}
+	if p.clients == nil {
+		p.clients = make(map[string]*sftp.Client)
+	}
+	p.clients[key] = client
+	go func() {
+		conn.Wait()
+		p.mutex.Lock()
+		if p.clients[key] == client {
+			delete(p.clients, key)
+		}
+		p.mutex.Unlock()
+		client.Close()
+	}()
+	return client, nil
+}
+
+// dialRemote opens an SSH connection to the machine in cfg, checking its
+// host key against the known hosts file
+func dialRemote(cfg *RemoteConfig) (*ssh.Client, error) {
+	knownHostsFile := cfg.KnownHostsFile
+	if knownHostsFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeys, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known hosts: %v", err)
+	}
+
+	auth, closeAgent, err := remoteAuth(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer closeAgent()
+
+	address := net.JoinHostPort(strings.Trim(cfg.Host, "[]"), strconv.Itoa(cfg.Port))
+	client, err := ssh.Dial("tcp", address, &ssh.ClientConfig{
+		User:              cfg.User,
+		Auth:              auth,
+		HostKeyCallback:   hostKeys,
+		HostKeyAlgorithms: knownHostAlgorithms(hostKeys, address),
+		Timeout:           remoteConnectTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach %s: %v", cfg.Target(), err)
+	}
+	return client, nil
+}
+
+// remoteAuth returns how to authenticate to a remote machine: with its
+// identity file, or like ssh with the default keys and the SSH agent. The
+// returned function closes the connection to the agent once connected.
+func remoteAuth(cfg *RemoteConfig) ([]ssh.AuthMethod, func(), error) {
+	closeAgent := func() {}
+	files := []string{cfg.IdentityFile}
+	if cfg.IdentityFile == "" {
+		home, _ := os.UserHomeDir()
+		files = []string{
+			filepath.Join(home, ".ssh", "id_ed25519"),
+			filepath.Join(home, ".ssh", "id_ecdsa"),
+			filepath.Join(home, ".ssh", "id_rsa"),
+		}
+	}
+
+	var signers []ssh.Signer
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err == nil {
+			var signer ssh.Signer
+			if signer, err = ssh.ParsePrivateKey(data); err == nil {
+				signers = append(signers, signer)
+				continue
+			}
+		}
+		// The default keys are optional; passphrase-protected ones need the agent
+		if cfg.IdentityFile != "" {
+			return nil, nil, fmt.Errorf("%w: cannot use identity file %s: %v", ErrInvalidRemote, file, err)
+		}
+	}
+
+	var methods []ssh.AuthMethod
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	if socket := os.Getenv("SSH_AUTH_SOCK"); cfg.IdentityFile == "" && socket != "" {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+			closeAgent = func() { conn.Close() }
+		}
+	}
+	if len(methods) == 0 {
+		return nil, nil, errors.New("no SSH key to authenticate to the remote machine with; set identityFile")
+	}
+	return methods, closeAgent, nil
+}
+
+// knownHostAlgorithms returns the host key algorithms matching the keys
+// known for address, so the server is asked for a key that can be checked
+// rather than the one it prefers. It asks the known hosts callback about a
+// throwaway key, which fails with the keys it expected.
+func knownHostAlgorithms(hostKeys ssh.HostKeyCallback, address string) []string {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil
+	}
+	probe, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return nil
+	}
+	host, port, _ := net.SplitHostPort(address)
+	portNumber, _ := strconv.Atoi(port)
+
+	var keyErr *knownhosts.KeyError
+	if err := hostKeys(address, &net.TCPAddr{IP: net.ParseIP(host), Port: portNumber}, probe); !errors.As(err, &keyErr) {
+		return nil
+	}
+	var algorithms []string
+	for _, known := range keyErr.Want {
+		switch keyType := known.Key.Type(); keyType {
+		case ssh.KeyAlgoRSA:
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA)
+		default:
+			algorithms = append(algorithms, keyType)
+		}
+	}
+	return algorithms
+}
+
+// remoteFS is a remote session's working directory, reached over SFTP
+type remoteFS struct {
+	client *sftp.Client
+	root   string
+	target string // user@host:port, for messages
+}
+
+// remoteFiles returns the working directory of a remote session, or nil for
+// sessions on this host
+func (sm *SessionManager) remoteFiles(sessionID string) (*remoteFS, error) {
+	session, err := sm.GetSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if session.Remote == nil {
+		return nil, nil
+	}
+	if session.WorkingDir == "" {
+		return nil, fmt.Errorf("%w for session %s", ErrNoWorkingDir, sessionID)
+	}
+	client, err := sm.remotes.client(session.Remote)
+	if err != nil {
+		return nil, err
+	}
+	return &remoteFS{client: client, root: session.WorkingDir, target: session.Remote.Target()}, nil
+}
+
+// resolve returns the remote path of a path relative to the working
+// directory, refusing paths that lead outside it as written or once their
+// symlinks are resolved
+func (r *remoteFS) resolve(relativePath string) (string, error) {
+	fullPath := path.Join(r.root, relativePath)
+	if !isWithin(r.root, fullPath) {
+		return "", &PathEscapeError{Path: relativePath}
+	}
+
+	realRoot, err := r.realPath(r.root)
+	if err != nil {
+		return "", err
+	}
+	realPath, err := r.realPath(fullPath)
+	if err != nil {
+		return "", err
+	}
+	if !isWithin(realRoot, realPath) {
+		return "", &PathEscapeError{Path: relativePath}
+	}
+	return fullPath, nil
+}
+
+// realPath resolves the symlinks in a remote path one part at a time, as
+// resolveSymlinks does locally: the parts that do not exist yet cannot be
+// symlinks, and a dangling symlink leads to where writing through it would
+// create its target. SFTP servers differ in whether their realpath resolves
+// symlinks, so it is not relied on.
+func (r *remoteFS) realPath(p string) (string, error) {
+	resolved := "/"
+	rest := strings.Split(p, "/")
+	links := 0
+	for len(rest) > 0 {
+		part := rest[0]
+		rest = rest[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = path.Dir(resolved)
+			continue
+		}
+
+		next := path.Join(resolved, part)
+		info, err := r.client.Lstat(next)
+		if errors.Is(err, os.ErrNotExist) {
+			return path.Join(append([]string{next}, rest...)...), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		if links++; links > maxSymlinkDepth {
+			return "", fmt.Errorf("too many levels of symlinks in %s", p)
+		}
+		target, err := r.client.ReadLink(next)
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			resolved = "/"
+		}
+		rest = append(strings.Split(target, "/"), rest...)
+	}
+	return resolved, nil
+}
+
+// listDir returns the entries of a remote directory as lookEntry reports
+// local ones: with follow, symlinks to what exists inside the working
+// directory report what they point to
+func (r *remoteFS) listDir(relativePath string, follow bool) ([]listedEntry, error) {
+	fullPath, err := r.resolve(relativePath)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := r.client.ReadDir(fullPath)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]listedEntry, 0, len(infos))
+	for _, info := range infos {
+		entry := listedEntry{Info: info}
+		if info.Mode()&os.ModeSymlink != 0 {
+			entry.IsSymlink = true
+			linkPath := path.Join(fullPath, info.Name())
+			entry.LinkTarget, _ = r.client.ReadLink(linkPath)
+			if follow {
+				if _, err := r.resolve(path.Join(relativePath, info.Name())); err == nil {
+					if target, err := r.client.Stat(linkPath); err == nil {
+						entry.Info = followedInfo{FileInfo: target, name: info.Name()}
+					}
+				}
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// readFile returns the content of a remote file
+func (r *remoteFS) readFile(relativePath string) ([]byte, error) {
+	fullPath, err := r.resolve(relativePath)
+	if err != nil {
+		return nil, err
+	}
+	file, err := r.client.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// stat returns the information of a remote path, what it points to when it
+// is a symlink, and the link's target
+func (r *remoteFS) stat(relativePath string) (info os.FileInfo, linkInfo os.FileInfo, target string, err error) {
+	fullPath, err := r.resolve(relativePath)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	linkInfo, err = r.client.Lstat(fullPath)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	info = linkInfo
+	if linkInfo.Mode()&os.ModeSymlink != 0 {
+		target, _ = r.client.ReadLink(fullPath)
+		if followed, err := r.client.Stat(fullPath); err == nil {
+			info = followed
+		}
+	}
+	return info, linkInfo, target, nil
+}
+
+// writeFile writes a remote file through a temporary file next to it,
+// renamed into place once written, creating its parent directories with
+// createParents. It returns the number of bytes written.
+func (r *remoteFS) writeFile(relativePath string, content io.Reader, createParents bool) (int64, error) {
+	fullPath, err := r.resolve(relativePath)
+	if err != nil {
+		return 0, err
+	}
+	dir := path.Dir(fullPath)
+	if createParents {
+		if err := r.client.MkdirAll(dir); err != nil {
+			return 0, err
+		}
+	}
+
+	tmp := path.Join(dir, fmt.Sprintf(".%s.upload-%d", path.Base(fullPath), time.Now().UnixNano()))
+	file, err := r.client.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return 0, err
+	}
+	defer r.client.Remove(tmp)
+
+	size, err := io.Copy(file, content)
+	if err == nil {
+		err = file.Chmod(0644)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := r.client.PosixRename(tmp, fullPath); err != nil {
+		// Servers without the posix-rename extension cannot replace files
+		r.client.Remove(fullPath)
+		if err := r.client.Rename(tmp, fullPath); err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
+}
+
+// remove deletes a remote file, or a whole directory with all
+func (r *remoteFS) remove(relativePath string, all bool) error {
+	fullPath, err := r.resolve(relativePath)
+	if err != nil {
+		return err
+	}
+	if fullPath == r.root {
+		return errors.New("cannot delete the working directory")
+	}
+	if all {
+		return r.client.RemoveAll(fullPath)
+	}
+	return r.client.Remove(fullPath)
+}
+
+// mkdirAll creates a remote directory and its parents
+func (r *remoteFS) mkdirAll(relativePath string) error {
+	fullPath, err := r.resolve(relativePath)
+	if err != nil {
+		return err
+	}
+	return r.client.MkdirAll(fullPath)
+}
+
+// checkRemoteDir verifies that an absolute directory exists on the machine
+// of a remote session
+func (sm *SessionManager) checkRemoteDir(cfg *RemoteConfig, dir string) error {
+	if !path.IsAbs(dir) {
+		return errors.New("the working directory of a remote session must be an absolute path")
+	}
+	client, err := sm.remotes.client(cfg)
+	if err != nil {
+		return err
+	}
+	info, err := client.Stat(dir)
+	if err != nil {
+		return errors.New("directory does not exist")
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return nil
+}
+
+// listedEntry is an entry of a listed directory, local or remote
+type listedEntry struct {
+	Info       os.FileInfo // What a followed symlink points to, the entry itself otherwise
+	IsSymlink  bool
+	LinkTarget string
+}
+
+// listDir returns the entries of a directory of a session, on this host or
+// its remote machine, for the listings of files and directories
+func (sm *SessionManager) listDir(sessionID string, relativePath string, follow bool) ([]listedEntry, error) {
+	remote, err := sm.remoteFiles(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if remote != nil {
+		return remote.listDir(relativePath, follow)
+	}
+
+	fullPath, err := sm.ResolvePath(sessionID, relativePath)
+	if err != nil {
+		return nil, err
+	}
+	root, err := sm.realWorkingDir(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := os.ReadDir(fullPath)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]listedEntry, 0, len(infos))
+	for _, dirEntry := range infos {
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		reported, target := lookEntry(root, fullPath, info, follow)
+		entries = append(entries, listedEntry{
+			Info:       reported,
+			IsSymlink:  info.Mode()&os.ModeSymlink != 0,
+			LinkTarget: target,
+		})
+	}
+	return entries, nil
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// ReplaceRequest replaces what Pattern matches in the files a search with
+// the same fields would read. Patterns match as in a search, with ^ and $
+// at the start and end of each line; in the Replacement of a regular
+// expression, $1 or ${name} stand for what a group matched, and $$ for a
+// dollar sign.
+type ReplaceRequest struct {
+	Pattern     string   `json:"pattern"`
+	Replacement string   `json:"replacement"`
+	Path        string   `json:"path"`
+	Recursive   bool     `json:"recursive"`
+	Regex       bool     `json:"regex"`
+	IgnoreCase  bool     `json:"ignoreCase"`
+	Include     []string `json:"include,omitempty"`
+	Exclude     []string `json:"exclude,omitempty"`
+	NoIgnore    bool     `json:"noIgnore"`
+}
+
+// ReplacedFile is what a replacement changed, or would change, in a file
+type ReplacedFile struct {
+	Path         string       `json:"path"` // Relative to the working directory
+	Replacements int          `json:"replacements"`
+	Lines        int          `json:"lines"`          // Lines the replacements changed
+	Diff         string       `json:"diff,omitempty"` // In dry runs
+	Backup       *FileVersion `json:"backup,omitempty"`
+}
+
+// ReplaceResult sums up the files a replacement changed, in the order a
+// search finds them
+type ReplaceResult struct {
+	DryRun       bool           `json:"dryRun,omitempty"`
+	Files        []ReplacedFile `json:"files"`
+	FilesChanged int            `json:"filesChanged"`
+	LinesChanged int            `json:"linesChanged"`
+	Replacements int            `json:"replacements"`
+}
+
+// replacement is a file's content before and after a replacement
+type replacement struct {
+	fullPath string
+	mode     os.FileMode
+	original []byte
+	replaced []byte
+	file     ReplacedFile
+}
+
+// Replace replaces a pattern across files, or with dryRun returns the diff
+// of each file instead. Every file is replaced through a temporary file
+// renamed over it, and the files already replaced are put back when one
+// fails, so either all of them change or none do.
+func (fs *FileService) Replace(ctx context.Context, sessionID string, req *ReplaceRequest, opts WriteOptions, dryRun bool) (result *ReplaceResult, err error) {
+	defer func() {
+		if dryRun {
+			return
+		}
+		detail := fmt.Sprintf("pattern '%s'", req.Pattern)
+		if result != nil {
+			detail += fmt.Sprintf(": %d replacements in %d files", result.Replacements, result.FilesChanged)
+		}
+		fs.sessionManager.Audit(sessionID, "file.replace", req.Path, detail, err)
+	}()
+
+	s, err := fs.newSearcher(sessionID, &SearchRequest{
+		Pattern:    req.Pattern,
+		Path:       req.Path,
+		Recursive:  req.Recursive,
+		Regex:      req.Regex,
+		IgnoreCase: req.IgnoreCase,
+		Include:    req.Include,
+		Exclude:    req.Exclude,
+		NoIgnore:   req.NoIgnore,
+	})
+	if err != nil {
+		return nil, err
+	}
+	matcher := regexp.MustCompile("(?m)" + s.lines.String())
+	replace := func(content string) string {
+		if req.Regex {
+			return matcher.ReplaceAllString(content, req.Replacement)
+		}
+		return matcher.ReplaceAllLiteralString(content, req.Replacement)
+	}
+	base := req.Path
+	if !s.isDir {
+		base = filepath.Dir(req.Path)
+	}
+
+	var replacements []*replacement
+	var walkErr error
+	s.walk(ctx, func(fullPath string, rel string, regular bool) bool {
+		if !regular {
+			return true
+		}
+		info, err := os.Stat(fullPath)
+		if err != nil {
+			walkErr = err
+			return false
+		}
+		content, err := readText(fullPath)
+		if err != nil {
+			walkErr = err
+			return false
+		}
+		locations := matcher.FindAllIndex(content, -1)
+		if content == nil || len(locations) == 0 {
+			return true
+		}
+		replaced := replace(string(content))
+		if replaced == string(content) {
+			return true
+		}
+		path := filepath.Join(base, rel)
+		replacements = append(replacements, &replacement{
+			fullPath: fullPath,
+			mode:     info.Mode().Perm(),
+			original: content,
+			replaced: []byte(replaced),
+			file: ReplacedFile{
+				Path:         path,
+				Replacements: len(locations),
+				Lines:        linesSpanned(content, locations),
+			},
+		})
+		return true
+	})
+	if walkErr != nil {
+		return nil, walkErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	result = &ReplaceResult{DryRun: dryRun, Files: []ReplacedFile{}}
+	for _, r := range replacements {
+		if dryRun {
+			if err := checkAccess(r.fullPath); err != nil {
+				return nil, err
+			}
+			r.file.Diff = UnifiedDiff(r.file.Path, string(r.original), string(r.replaced))
+		}
+		result.Files = append(result.Files, r.file)
+		result.FilesChanged++
+		result.LinesChanged += r.file.Lines
+		result.Replacements += r.file.Replacements
+	}
+	if dryRun {
+		return result, nil
+	}
+
+	for i, r := range replacements {
+		version, err := fs.sessionManager.beforeWrite(sessionID, r.fullPath, opts.Backup)
+		if err == nil {
+			err = writeFileContent(r.fullPath, r.replaced, r.mode, opts.InPlace)
+		}
+		if err != nil {
+			for _, done := range replacements[:i] {
+				if restoreErr := writeFileContent(done.fullPath, done.original, done.mode, opts.InPlace); restoreErr != nil {
+					sessionLog(sessionID).Warn("failed to put back a file after a failed replace", "path", done.file.Path, "error", restoreErr)
+				}
+			}
+			return nil, fmt.Errorf("replacing in %s: %w", r.file.Path, err)
+		}
+		result.Files[i].Backup = version
+		fs.sessionManager.afterWrite(sessionID, r.fullPath, "replace")
+	}
+
+	fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityFile,
+		Target:   req.Path,
+		Message:  fmt.Sprintf("Replaced '%s' %d times in %d files", req.Pattern, result.Replacements, result.FilesChanged),
+	})
+	return result, nil
+}
+
+// linesSpanned counts the lines the matches at locations are on
+func linesSpanned(content []byte, locations [][]int) int {
+	count, line, offset, last := 0, 0, 0, -1
+	for _, location := range locations {
+		line += bytes.Count(content[offset:location[0]], []byte("\n"))
+		offset = location[0]
+		first := max(line, last+1)
+		end := line + bytes.Count(content[location[0]:location[1]], []byte("\n"))
+		if end >= first {
+			count += end - first + 1
+			last = end
+		}
+	}
+	return count
+}
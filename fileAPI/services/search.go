@@ -0,0 +1,548 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidSearch is returned for a search whose pattern, globs or limits
+// are not valid
+var ErrInvalidSearch = errors.New("invalid search")
+
+// DefaultMaxSearchMatches is how many matching lines a search returns when
+// it does not set MaxMatches
+const DefaultMaxSearchMatches = 1000
+
+// Engines a search runs with
+const (
+	SearchEngineNative  = "native"
+	SearchEngineRipgrep = "ripgrep"
+)
+
+// Files whose first binarySniffSize bytes hold a NUL byte are binary and
+// not searched
+const binarySniffSize = 8000
+
+// How many files per worker a search reads ahead of the one it returns
+const searchReadAhead = 4
+
+// nameMatchNote stands in for the lines of a file found only by its name
+const nameMatchNote = "[Filename matches search pattern]"
+
+// SearchRequest searches the files below Path for lines matching Pattern,
+// a plain string unless Regex, in which case it is a regular expression in
+// Go's RE2 syntax. Before and After set how many lines around each match
+// to return, Context where they are 0. Include and Exclude are glob
+// patterns, matched as in a copy against the path of each entry below Path
+// and against its name.
+type SearchRequest struct {
+	Pattern    string   `json:"pattern"`
+	Path       string   `json:"path"`
+	Recursive  bool     `json:"recursive"`
+	Regex      bool     `json:"regex"`
+	IgnoreCase bool     `json:"ignoreCase"`
+	Context    int      `json:"context"`
+	Before     int      `json:"before"`
+	After      int      `json:"after"`
+	Include    []string `json:"include,omitempty"`
+	Exclude    []string `json:"exclude,omitempty"`
+	MaxMatches int      `json:"maxMatches"` // Of matching lines; DefaultMaxSearchMatches when 0
+	// Also read .git directories and what .gitignore files ignore
+	NoIgnore bool `json:"noIgnore"`
+	// Continues a search after the match a previous one returned it for
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// SearchMatch is a matching line. Line and Column count from 1, the column
+// in bytes to the start of the first match on the line.
+type SearchMatch struct {
+	Path   string   `json:"path"` // Relative to the searched directory
+	Line   int      `json:"line"`
+	Column int      `json:"column"`
+	Text   string   `json:"text"`
+	Before []string `json:"before,omitempty"`
+	After  []string `json:"after,omitempty"`
+}
+
+// SearchResult holds the matching lines in the order of the files and
+// lines they are on, and the files whose name matches. Truncated is set
+// when the search stopped at MaxMatches with more left to find, and
+// NextCursor then continues it.
+type SearchResult struct {
+	Matches     []SearchMatch `json:"matches"`
+	MatchCount  int           `json:"matchCount"`
+	NameMatches []string      `json:"nameMatches"`
+	Truncated   bool          `json:"truncated"`
+	NextCursor  string        `json:"nextCursor,omitempty"`
+	Engine      string        `json:"engine"` // What searched, native or ripgrep
+}
+
+// Types of the events of a streamed search
+const (
+	SearchEventMatch = "match"
+	SearchEventName  = "name"
+	SearchEventEnd   = "end"
+	SearchEventError = "error"
+)
+
+// SearchEvent is a step of a streamed search: a matching line, a file
+// whose name matches, the end of the search with its summary, or the error
+// that ended it. Matches and name matches come with the cursor continuing
+// after them.
+type SearchEvent struct {
+	Type       string       `json:"type"`
+	Match      *SearchMatch `json:"match,omitempty"`
+	Path       string       `json:"path,omitempty"` // Of a name match
+	Cursor     string       `json:"cursor,omitempty"`
+	MatchCount int          `json:"matchCount,omitempty"`
+	Truncated  bool         `json:"truncated,omitempty"`
+	NextCursor string       `json:"nextCursor,omitempty"`
+	Engine     string       `json:"engine,omitempty"`
+	Error      string       `json:"error,omitempty"`
+}
+
+// ByFile lists the matching lines of each file, and a note for files
+// found only by name
+func (r *SearchResult) ByFile() map[string][]string {
+	files := make(map[string][]string)
+	for _, match := range r.Matches {
+		files[match.Path] = append(files[match.Path], match.Text)
+	}
+	for _, name := range r.NameMatches {
+		if _, ok := files[name]; !ok {
+			files[name] = []string{nameMatchNote}
+		}
+	}
+	return files
+}
+
+// searchMatchers compiles the pattern for lines and for file names. Plain
+// patterns match names regardless of case, as they always have.
+func searchMatchers(req *SearchRequest) (lines *regexp.Regexp, names *regexp.Regexp, err error) {
+	expr := req.Pattern
+	if !req.Regex {
+		expr = regexp.QuoteMeta(expr)
+	}
+	flags := ""
+	if req.IgnoreCase {
+		flags = "(?i)"
+	}
+	if lines, err = regexp.Compile(flags + expr); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidSearch, err)
+	}
+	names = lines
+	if !req.Regex && !req.IgnoreCase {
+		names = regexp.MustCompile("(?i)" + expr)
+	}
+	return lines, names, nil
+}
+
+// validateSearch checks a search's pattern, globs and limits
+func validateSearch(req *SearchRequest) error {
+	if req.Pattern == "" {
+		return fmt.Errorf("%w: pattern is required", ErrInvalidSearch)
+	}
+	for _, pattern := range append(append([]string{}, req.Include...), req.Exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%w: invalid glob %q", ErrInvalidSearch, pattern)
+		}
+	}
+	if req.Context < 0 || req.Before < 0 || req.After < 0 {
+		return fmt.Errorf("%w: context lines must not be negative", ErrInvalidSearch)
+	}
+	if req.MaxMatches < 0 {
+		return fmt.Errorf("%w: maxMatches must not be negative", ErrInvalidSearch)
+	}
+	return nil
+}
+
+// Search finds the lines of files, and the file names, matching a pattern,
+// with a cursor to continue from when it stops at MaxMatches
+func (fs *FileService) Search(ctx context.Context, sessionID string, req *SearchRequest) (*SearchResult, error) {
+	matches, names := []SearchMatch{}, []string{}
+	result, err := fs.SearchStream(ctx, sessionID, req, func(event SearchEvent) error {
+		switch event.Type {
+		case SearchEventMatch:
+			matches = append(matches, *event.Match)
+		case SearchEventName:
+			names = append(names, event.Path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Matches = matches
+	result.NameMatches = names
+	return result, nil
+}
+
+// SearchStream runs a search, sending each match and file name match as
+// it is found, and returns the summary of the search without them. Files
+// are read in parallel, or by ripgrep when the server uses it, but matches
+// come in the order of a serial walk. Symlinks are matched by name but not
+// read through, as they may lead outside the working directory. The search
+// stops with the error send returns.
+func (fs *FileService) SearchStream(ctx context.Context, sessionID string, req *SearchRequest, send func(SearchEvent) error) (result *SearchResult, err error) {
+	defer func() {
+		detail := fmt.Sprintf("pattern '%s'", req.Pattern)
+		if result != nil {
+			detail += fmt.Sprintf(" matched %d lines", result.MatchCount)
+		}
+		fs.sessionManager.Audit(sessionID, "file.search", req.Path, detail, err)
+	}()
+
+	s, err := fs.newSearcher(sessionID, req)
+	if err != nil {
+		return nil, err
+	}
+	maxMatches := req.MaxMatches
+	if maxMatches == 0 {
+		maxMatches = DefaultMaxSearchMatches
+	}
+
+	sent := false
+	var sendErr error
+	var last string
+	collect := func(file *searchFile) bool {
+		if !s.cursor.keep(file) {
+			return true
+		}
+		if file.nameMatch {
+			last = encodeSearchCursor(file.path, 0)
+			sent = true
+			if sendErr = send(SearchEvent{Type: SearchEventName, Path: file.path, Cursor: last}); sendErr != nil {
+				return false
+			}
+		}
+		for _, match := range file.matches {
+			if result.MatchCount == maxMatches {
+				result.Truncated = true
+				return false
+			}
+			last = encodeSearchCursor(match.Path, match.Line)
+			sent = true
+			if sendErr = send(SearchEvent{Type: SearchEventMatch, Match: &match, Cursor: last}); sendErr != nil {
+				return false
+			}
+			result.MatchCount++
+		}
+		return true
+	}
+
+	if rg := fs.sessionManager.ripgrep; rg != "" {
+		result = &SearchResult{Engine: SearchEngineRipgrep}
+		err = s.ripgrep(ctx, rg, collect)
+		// What was sent cannot be taken back, so only a search that
+		// found nothing yet runs again
+		if err != nil && sendErr == nil && ctx.Err() == nil && !sent {
+			sessionLog(sessionID).Warn("ripgrep failed, searching without it", "error", err)
+			err = nil
+			result = nil
+		}
+	}
+	if result == nil {
+		result = &SearchResult{Engine: SearchEngineNative}
+		err = s.native(ctx, collect)
+	}
+	if sendErr != nil {
+		return nil, sendErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if result.Truncated {
+		result.NextCursor = last
+	}
+
+	fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityFile,
+		Target:   req.Path,
+		Message:  fmt.Sprintf("Searched for pattern '%s' in %s, found %d matching lines", req.Pattern, req.Path, result.MatchCount),
+	})
+	return result, nil
+}
+
+// searchFile is what searching a file found
+type searchFile struct {
+	path      string // Relative to the searched directory
+	nameMatch bool
+	matches   []SearchMatch
+}
+
+// searcher runs a search below a directory, or in a file
+type searcher struct {
+	req           *SearchRequest
+	root          string // The directory or file searched
+	isDir         bool
+	workingDir    string // .gitignore files above it are not read
+	cursor        *searchCursor
+	lines, names  *regexp.Regexp
+	before, after int
+}
+
+// newSearcher checks a search and resolves what it searches
+func (fs *FileService) newSearcher(sessionID string, req *SearchRequest) (*searcher, error) {
+	if err := validateSearch(req); err != nil {
+		return nil, err
+	}
+	lineMatcher, nameMatcher, err := searchMatchers(req)
+	if err != nil {
+		return nil, err
+	}
+	fullPath, err := fs.GetFilePath(sessionID, req.Path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	workingDir, err := fs.sessionManager.ResolvePath(sessionID, ".")
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := parseSearchCursor(req.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	s := &searcher{
+		req:        req,
+		root:       fullPath,
+		isDir:      info.IsDir(),
+		workingDir: workingDir,
+		cursor:     cursor,
+		lines:      lineMatcher,
+		names:      nameMatcher,
+		before:     req.Context,
+		after:      req.Context,
+	}
+	if req.Before > 0 {
+		s.before = req.Before
+	}
+	if req.After > 0 {
+		s.after = req.After
+	}
+	return s, nil
+}
+
+// native searches with a pool of workers reading files while the walk
+// goes on. Files are yielded in the order the walk finds them, and no more
+// than a few per worker are read ahead of the one being yielded; the
+// search stops when yield returns false.
+func (s *searcher) native(ctx context.Context, yield func(*searchFile) bool) error {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type job struct {
+		fullPath string
+		regular  bool
+		file     *searchFile
+		done     chan struct{}
+	}
+	workers := runtime.GOMAXPROCS(0)
+	jobs := make(chan *job)
+	pending := make(chan *job, searchReadAhead*workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if j.regular {
+					j.file.matches = s.scan(j.fullPath, j.file.path)
+				}
+				close(j.done)
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		defer close(pending)
+		s.walk(ctx, func(fullPath string, rel string, regular bool) bool {
+			j := &job{
+				fullPath: fullPath,
+				regular:  regular,
+				file:     &searchFile{path: rel, nameMatch: s.names.MatchString(filepath.Base(fullPath))},
+				done:     make(chan struct{}),
+			}
+			select {
+			case pending <- j:
+			case <-ctx.Done():
+				return false
+			}
+			select {
+			case jobs <- j:
+			case <-ctx.Done():
+				return false
+			}
+			return true
+		})
+	}()
+
+	// Once stopped, what is left is drained for the walk to end
+	stopped := false
+	for j := range pending {
+		if stopped {
+			continue
+		}
+		select {
+		case <-j.done:
+		case <-ctx.Done():
+			stopped = true
+			continue
+		}
+		if !yield(j.file) {
+			stopped = true
+			cancel()
+		}
+	}
+	wg.Wait()
+	return parent.Err()
+}
+
+// walk visits the files a search reads, in lexical order, and the symlinks
+// it matches by name, until visit returns false. Unless the search sets
+// NoIgnore, it leaves out .git directories and what .gitignore files in
+// the searched directory, and above it up to the repository's root, ignore.
+func (s *searcher) walk(ctx context.Context, visit func(fullPath string, rel string, regular bool) bool) {
+	if !s.isDir {
+		// A file searched by itself is named by its own name
+		visit(s.root, filepath.Base(s.root), true)
+		return
+	}
+	// The .gitignore files applying to the entries of each directory
+	ignores := make(map[string][]*ignoreFile)
+	if !s.req.NoIgnore {
+		ignores[s.root] = parentIgnoreFiles(s.workingDir, s.root)
+		if ignore := readIgnoreFile(s.root); ignore != nil {
+			ignores[s.root] = append(ignores[s.root], ignore)
+		}
+	}
+
+	filepath.WalkDir(s.root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil // Skip files with errors, don't abort the entire walk
+		}
+		if ctx.Err() != nil {
+			return filepath.SkipAll
+		}
+		if path == s.root {
+			return nil
+		}
+		relPath, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return nil
+		}
+		dir := filepath.Dir(path)
+		if d.IsDir() {
+			if !s.req.Recursive || matchesAny(s.req.Exclude, relPath) || s.cursor.passed(relPath, true) {
+				return filepath.SkipDir
+			}
+			if !s.req.NoIgnore {
+				if d.Name() == ".git" || ignored(ignores[dir], path, true) {
+					return filepath.SkipDir
+				}
+				ignores[path] = ignores[dir]
+				if ignore := readIgnoreFile(path); ignore != nil {
+					ignores[path] = append(slices.Clip(ignores[dir]), ignore)
+				}
+			}
+			return nil
+		}
+		if matchesAny(s.req.Exclude, relPath) || (len(s.req.Include) > 0 && !matchesAny(s.req.Include, relPath)) {
+			return nil
+		}
+		if s.cursor.passed(relPath, false) || ignored(ignores[dir], path, false) {
+			return nil
+		}
+		if !visit(path, relPath, d.Type().IsRegular()) {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+}
+
+// scan reads a file and returns its matching lines, none for a binary
+// file
+func (s *searcher) scan(fullPath string, rel string) []SearchMatch {
+	content, err := readText(fullPath)
+	if err != nil || content == nil || !s.lines.Match(content) {
+		return nil
+	}
+	return s.matchLines(rel, textLines(content))
+}
+
+// matchLines returns the lines of a file that match, with their context
+func (s *searcher) matchLines(rel string, lines []string) []SearchMatch {
+	var matches []SearchMatch
+	for i, line := range lines {
+		location := s.lines.FindStringIndex(line)
+		if location == nil {
+			continue
+		}
+		matches = append(matches, SearchMatch{Path: rel, Line: i + 1, Column: location[0] + 1, Text: line})
+	}
+	s.addContext(matches, lines)
+	return matches
+}
+
+// addContext adds the lines around each match of a file
+func (s *searcher) addContext(matches []SearchMatch, lines []string) {
+	for i := range matches {
+		line := matches[i].Line - 1
+		if line < 0 || line >= len(lines) {
+			continue
+		}
+		if s.before > 0 {
+			matches[i].Before = lines[max(0, line-s.before):line]
+		}
+		if s.after > 0 {
+			matches[i].After = lines[line+1 : min(len(lines), line+1+s.after)]
+		}
+	}
+}
+
+// textLines splits text into lines ending in either \n or \r\n
+func textLines(content []byte) []string {
+	lines := splitLines(string(content))
+	for i := range lines {
+		lines[i] = strings.TrimSuffix(lines[i], "\r")
+	}
+	return lines
+}
+
+// readText reads a file, or returns nil content for a binary file: one
+// with a NUL byte in its first binarySniffSize bytes, as git decides
+func readText(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	head := make([]byte, binarySniffSize)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	if bytes.IndexByte(head[:n], 0) >= 0 {
+		return nil, nil
+	}
+	rest, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	return append(head[:n], rest...), nil
+}
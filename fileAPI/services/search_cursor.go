@@ -0,0 +1,89 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// searchCursor is where a search continues: after a line of a file, named
+// relative to the searched directory, or after its name for line 0.
+// Searches find files in the order of their paths compared by name at each
+// level, so the files before the cursor's are skipped without being read.
+type searchCursor struct {
+	Path string `json:"p"`
+	Line int    `json:"l"`
+}
+
+// encodeSearchCursor returns the cursor continuing a search after a match,
+// or after a name match for line 0
+func encodeSearchCursor(path string, line int) string {
+	data, _ := json.Marshal(searchCursor{Path: path, Line: line})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// parseSearchCursor reads a cursor, nil when it is empty
+func parseSearchCursor(value string) (*searchCursor, error) {
+	if value == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid cursor", ErrInvalidSearch)
+	}
+	var cursor searchCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Path == "" || cursor.Line < 0 {
+		return nil, fmt.Errorf("%w: invalid cursor", ErrInvalidSearch)
+	}
+	return &cursor, nil
+}
+
+// passed reports whether an entry comes before the cursor's file, so
+// everything in it was searched before
+func (c *searchCursor) passed(rel string, isDir bool) bool {
+	if c == nil {
+		return false
+	}
+	if isDir && strings.HasPrefix(c.Path, rel+string(filepath.Separator)) {
+		return false
+	}
+	return compareWalkOrder(rel, c.Path) < 0
+}
+
+// keep drops from a file what a search found before the cursor, reporting
+// whether anything is left
+func (c *searchCursor) keep(file *searchFile) bool {
+	if c == nil {
+		return true
+	}
+	switch order := compareWalkOrder(file.path, c.Path); {
+	case order < 0:
+		return false
+	case order > 0:
+		return true
+	}
+	file.nameMatch = false
+	kept := file.matches[:0]
+	for _, match := range file.matches {
+		if match.Line > c.Line {
+			kept = append(kept, match)
+		}
+	}
+	file.matches = kept
+	return len(kept) > 0
+}
+
+// compareWalkOrder compares paths by name at each level, the order a walk
+// visits them in
+func compareWalkOrder(a string, b string) int {
+	aParts := strings.Split(a, string(filepath.Separator))
+	bParts := strings.Split(b, string(filepath.Separator))
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		if order := strings.Compare(aParts[i], bParts[i]); order != 0 {
+			return order
+		}
+	}
+	return len(aParts) - len(bParts)
+}
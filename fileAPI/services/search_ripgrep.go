@@ -0,0 +1,226 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// EnableRipgrep has searches run the rg binary found in PATH, which reads
+// files faster than the server does. Searches it fails run without it.
+func (sm *SessionManager) EnableRipgrep() error {
+	path, err := exec.LookPath("rg")
+	if err != nil {
+		return err
+	}
+	sm.ripgrep = path
+	return nil
+}
+
+// ripgrepText is text in rg's JSON output, given as bytes in base64 when
+// it is not UTF-8
+type ripgrepText struct {
+	Text  *string `json:"text"`
+	Bytes string  `json:"bytes"`
+}
+
+func (t ripgrepText) String() string {
+	if t.Text != nil {
+		return *t.Text
+	}
+	decoded, _ := base64.StdEncoding.DecodeString(t.Bytes)
+	return string(decoded)
+}
+
+// ripgrepMessage is a line of rg's JSON output
+type ripgrepMessage struct {
+	Type string `json:"type"` // begin, match, context, end or summary
+	Data struct {
+		Path       ripgrepText `json:"path"`
+		Lines      ripgrepText `json:"lines"`
+		LineNumber int         `json:"line_number"`
+		Submatches []struct {
+			Start int `json:"start"`
+		} `json:"submatches"`
+	} `json:"data"`
+}
+
+// ripgrepArgs are the options of rg searching as a request asks, as the
+// native search does
+func (s *searcher) ripgrepArgs() []string {
+	args := []string{"--no-config", "--no-messages", "--sort", "path", "--hidden",
+		"--no-require-git", "--no-ignore-global", "--no-ignore-exclude", "--no-ignore-dot"}
+	if !s.req.Regex {
+		args = append(args, "--fixed-strings")
+	}
+	if s.req.IgnoreCase {
+		args = append(args, "--ignore-case")
+	} else {
+		args = append(args, "--case-sensitive")
+	}
+	if s.req.NoIgnore {
+		args = append(args, "--no-ignore")
+	} else {
+		args = append(args, "--glob", "!.git")
+	}
+	if !s.req.Recursive {
+		args = append(args, "--max-depth", "1")
+	}
+	for _, pattern := range s.req.Include {
+		args = append(args, "--glob", pattern)
+	}
+	for _, pattern := range s.req.Exclude {
+		args = append(args, "--glob", "!"+pattern)
+	}
+	return args
+}
+
+// ripgrep searches with rg: first the names of the files it would read,
+// then their content, yielding the files in the order of their paths as
+// the native search does. Globs follow rg's rules, those of .gitignore
+// files.
+func (s *searcher) ripgrep(ctx context.Context, rg string, yield func(*searchFile) bool) error {
+	dir, targets := s.root, []string(nil)
+	if !s.isDir {
+		dir, targets = filepath.Dir(s.root), []string{filepath.Base(s.root)}
+	}
+
+	var names []string
+	err := runRipgrep(ctx, rg, dir, append(append(s.ripgrepArgs(), "--files", "--"), targets...), func(line []byte) (bool, error) {
+		if rel := string(line); s.names.MatchString(filepath.Base(rel)) {
+			names = append(names, rel)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	// Files whose names match are yielded among those whose content does
+	stopped := false
+	yieldNamesBefore := func(path string) bool {
+		for len(names) > 0 && (path == "" || compareWalkOrder(names[0], path) < 0) {
+			if !yield(&searchFile{path: names[0], nameMatch: true}) {
+				return false
+			}
+			names = names[1:]
+		}
+		return true
+	}
+
+	args := append(s.ripgrepArgs(), "--json", "--regexp", s.req.Pattern, "--")
+	var file *searchFile
+	err = runRipgrep(ctx, rg, dir, append(args, targets...), func(line []byte) (bool, error) {
+		var message ripgrepMessage
+		if err := json.Unmarshal(line, &message); err != nil {
+			return false, err
+		}
+		switch message.Type {
+		case "begin":
+			file = &searchFile{path: message.Data.Path.String()}
+		case "match":
+			if file == nil {
+				return false, errors.New("match outside a file")
+			}
+			match := SearchMatch{
+				Path: file.path,
+				Line: message.Data.LineNumber,
+				Text: strings.TrimSuffix(strings.TrimSuffix(message.Data.Lines.String(), "\n"), "\r"),
+			}
+			if len(message.Data.Submatches) > 0 {
+				match.Column = message.Data.Submatches[0].Start + 1
+			}
+			file.matches = append(file.matches, match)
+		case "end":
+			if file == nil {
+				return false, errors.New("end outside a file")
+			}
+			if s.before > 0 || s.after > 0 {
+				if content, err := os.ReadFile(filepath.Join(dir, file.path)); err == nil {
+					s.addContext(file.matches, textLines(content))
+				}
+			}
+			found := file
+			file = nil
+			if !yieldNamesBefore(found.path) {
+				stopped = true
+				return false, nil
+			}
+			if len(names) > 0 && names[0] == found.path {
+				found.nameMatch = true
+				names = names[1:]
+			}
+			if !yield(found) {
+				stopped = true
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err != nil || stopped {
+		return err
+	}
+	yieldNamesBefore("")
+	return nil
+}
+
+// runRipgrep runs rg in dir, passing each line of its output to line
+// until it returns false or an error
+func runRipgrep(ctx context.Context, rg string, dir string, args []string, line func([]byte) (bool, error)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, rg, args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	stopped := false
+	var lineErr error
+	reader := bufio.NewReader(stdout)
+	for {
+		text, err := reader.ReadBytes('\n')
+		if len(text) > 0 {
+			var more bool
+			if more, lineErr = line(bytes.TrimSuffix(text, []byte("\n"))); !more || lineErr != nil {
+				stopped = true
+				cancel()
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	err = cmd.Wait()
+	switch {
+	case lineErr != nil:
+		return fmt.Errorf("reading rg output: %w", lineErr)
+	case stopped || err == nil:
+		return nil
+	}
+	// rg exits with 1 when nothing matches, and with 2 when it could not
+	// read a file as well as when it could not search at all
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && (exit.ExitCode() == 1 || (exit.ExitCode() == 2 && stderr.Len() == 0)) {
+		return nil
+	}
+	if message := strings.TrimSpace(stderr.String()); message != "" {
+		return fmt.Errorf("%w: %s", err, message)
+	}
+	return err
+}
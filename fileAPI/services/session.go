@@ -3,7 +3,9 @@ package services
 import (
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
 	"sync"
 	"time"
@@ -17,21 +19,101 @@ type Session struct {
 	LastActive   time.Time `json:"lastActive"`
 	WorkingDir   string    `json:"workingDir"`
 	IsActive     bool      `json:"isActive"`
-	ExpiresAt    time.Time `json:"expiresAt"`
-	ActivityLog  []string  `json:"activityLog,omitempty"`
+	ExpiresAt    time.Time `json:"expiresAt,omitzero"` // Unset when the session never expires
+	ExpirySeconds int      `json:"expirySeconds,omitempty"` // Idle time before expiry; 0 uses the server's
+	NeverExpire  bool      `json:"neverExpire,omitempty"`   // Lives until deleted
+	ActivityLog  []ActivityEntry `json:"activityLog,omitempty"` // Recent activity; GET /sessions/{id}/activity has the rest
+	Owner        string    `json:"owner,omitempty"` // Name of the API key that created the session
+	// Labels orchestrators find their sessions by
+	Name         string    `json:"name,omitempty"`
+	Tags         []string  `json:"tags,omitempty"`
+	Metadata     map[string]interface{} `json:"metadata,omitempty"`
+	Remote       *RemoteConfig `json:"remote,omitempty"` // Machine the working directory lives on, reached over SFTP
+	Backups      bool      `json:"backups,omitempty"` // Keep the previous content of files before updates and patches
 }
 
+// SessionOptions are the optional settings accepted at session creation
+type SessionOptions struct {
+	// Labels to find the session by, changed later with UpdateSession
+	Name     string                 `json:"name,omitempty"`
+	Tags     []string               `json:"tags,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	// Seconds without activity before the session expires, instead of the
+	// server's sessionExpiry
+	ExpirySeconds int `json:"expirySeconds,omitempty"`
+	// Keep the session until it is deleted; only admin keys may set it
+	NeverExpire bool `json:"neverExpire,omitempty"`
+	// The machine the working directory lives on, instead of this host
+	Remote *RemoteConfig `json:"remote,omitempty"`
+	// Back up files before every update and patch, not only those that
+	// ask for it
+	Backups bool `json:"backups,omitempty"`
+	// Set from the authenticated API key, never from the request body
+	Owner string `json:"-"`
+	// Whether the session may be remote, which only admin keys may ask for;
+	// also never from the request body
+	AllowRemote bool `json:"-"`
+}
+
+// DefaultSessionExpiry is how long sessions live without activity unless
+// configured otherwise
+const DefaultSessionExpiry = 24 * time.Hour
+
+// SessionProvider keeps sessions for a SessionManager in place of its own.
+// The osai command shares terminalAPI's sessions this way, so a session
+// works with both APIs. Implementations record session operations in the
+// audit log themselves.
+type SessionProvider interface {
+	CreateSession(opts *SessionOptions) (*Session, error)
+	GetSession(id string) (*Session, error)
+	PeekSession(id string) (*Session, error)
+	UpdateSession(id string, update *SessionUpdate) (*Session, error)
+	SessionOwner(id string) (string, bool)
+	DeleteSession(id string) error
+	SetWorkingDirectory(id string, dir string) error
+	LogActivity(id string, entry ActivityEntry) error
+	GetActivity(id string, query *ActivityQuery) (*ActivityPage, error)
+	GetAllSessions(owner string) []*Session
+}
+
+// ErrSessionNotFound is returned for sessions that do not exist, have been
+// deleted or have expired
+var ErrSessionNotFound = errors.New("session not found or inactive")
+
 type SessionManager struct {
-	sessions      map[string]*Session
+	// Where sessions are kept, shared by replicas when it is Redis
+	store         SessionStore
 	mutex         sync.RWMutex
 	sessionExpiry time.Duration
 	cleanupTicker *time.Ticker
+	// Roots working directories must lie in; empty allows any directory
+	allowedDirs []string
+	// Records operations in sessions
+	audit *AuditService
+	// Keeps the sessions instead of this manager when set
+	provider SessionProvider
+	// Called with every session lifecycle event
+	eventListeners []func(event SessionEvent)
+	// Persists the activity of sessions
+	activity *activityLog
+	// SFTP connections to the machines of remote sessions
+	remotes *remotePool
+	// Where deleted files are kept until restored or purged
+	trash *trashBin
+	// Where the previous content of overwritten files is kept
+	versions *versionStore
+	// The rg binary searches run, if any
+	ripgrep string
 }
 
 func NewSessionManager() *SessionManager {
 	sm := &SessionManager{
-		sessions:      make(map[string]*Session),
-		sessionExpiry: 24 * time.Hour, // Default 24 hour expiry
+		store:         NewMemorySessionStore(),
+		sessionExpiry: DefaultSessionExpiry,
+		activity:      &activityLog{},
+		remotes:       &remotePool{},
+		trash:         &trashBin{},
+		versions:      &versionStore{},
 	}
 	
 	// Start cleanup routine
@@ -41,21 +123,100 @@ func NewSessionManager() *SessionManager {
 	return sm
 }
 
+// SetSessionExpiry sets how long sessions live without activity
+func (sm *SessionManager) SetSessionExpiry(expiry time.Duration) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	if expiry > 0 {
+		sm.sessionExpiry = expiry
+	}
+}
+
+// SetSessionProvider hands session keeping over to provider. Settings of
+// this manager, such as its expiry and allowed directories, then no longer
+// apply.
+func (sm *SessionManager) SetSessionProvider(provider SessionProvider) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.provider = provider
+}
+
+// sessionProvider returns the provider keeping sessions, or nil when this
+// manager keeps them
+func (sm *SessionManager) sessionProvider() SessionProvider {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	return sm.provider
+}
+
 func (sm *SessionManager) cleanupExpiredSessions() {
 	for range sm.cleanupTicker.C {
 		sm.mutex.Lock()
+		sessions, err := sm.store.List()
+		if err != nil {
+			slog.Warn("failed to list sessions for cleanup", "error", err)
+		}
 		now := time.Now()
-		for id, session := range sm.sessions {
-			if session.ExpiresAt.Before(now) {
-				delete(sm.sessions, id)
-				fmt.Printf("[TERMINAL] Expired inactive session: %s\n", id)
+		for _, session := range sessions {
+			if session.expired(now) {
+				if err := sm.store.Delete(session.ID); err != nil {
+					sessionLog(session.ID).Warn("failed to delete expired session", "error", err)
+					continue
+				}
+				sm.audit.Record(AuditEntry{Actor: session.Owner, SessionID: session.ID, Operation: "session.expire"}, nil)
+				sm.emitSessionEvent(SessionExpired, session)
 			}
 		}
 		sm.mutex.Unlock()
 	}
 }
 
-func (sm *SessionManager) CreateSession() (*Session, error) {
+// loadSession returns an active session from the store. Callers must hold
+// sm.mutex.
+func (sm *SessionManager) loadSession(id string) (*Session, error) {
+	session, err := sm.store.Load(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session: %w", err)
+	}
+	if session == nil || !session.IsActive {
+		return nil, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+// CreateSession starts a session owned by the API key named in opts, or by
+// nobody when authentication is disabled
+func (sm *SessionManager) CreateSession(opts *SessionOptions) (*Session, error) {
+	if opts == nil {
+		opts = &SessionOptions{}
+	}
+	if opts.Remote != nil && !opts.AllowRemote {
+		return nil, ErrRemoteForbidden
+	}
+	if provider := sm.sessionProvider(); provider != nil {
+		return provider.CreateSession(opts)
+	}
+	
+	if opts.ExpirySeconds < 0 {
+		return nil, fmt.Errorf("%w: expirySeconds must not be negative", ErrInvalidExpiry)
+	}
+	tags, err := validateSessionLabels(opts.Name, opts.Tags, opts.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	metadata := opts.Metadata
+	if len(metadata) == 0 {
+		metadata = nil
+	}
+	if opts.Remote != nil {
+		if err := opts.Remote.ApplyDefaults(); err != nil {
+			return nil, err
+		}
+		if opts.Backups {
+			return nil, fmt.Errorf("backups are %w", ErrRemoteUnsupported)
+		}
+	}
+	
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 	
@@ -64,105 +225,209 @@ func (sm *SessionManager) CreateSession() (*Session, error) {
 	session := &Session{
 		ID:           id,
 		CreatedAt:    now,
-		LastActive:   now,
 		WorkingDir:   "",
 		IsActive:     true,
-		ExpiresAt:    now.Add(sm.sessionExpiry),
-		ActivityLog:  []string{fmt.Sprintf("%s: Session created", now.Format(time.RFC3339))},
+		ExpirySeconds: opts.ExpirySeconds,
+		NeverExpire:  opts.NeverExpire,
+		Owner:        opts.Owner,
+		Name:         opts.Name,
+		Tags:         tags,
+		Metadata:     metadata,
+		Remote:       opts.Remote,
+		Backups:      opts.Backups,
 	}
+	sm.extendSession(session, now)
+	sm.addActivity(session, ActivityEntry{Category: ActivitySession, Message: "Session created"})
 	
-	sm.sessions[id] = session
-	fmt.Printf("[TERMINAL] Created new session: %s\n", id)
+	if err := sm.store.Save(session); err != nil {
+		return nil, fmt.Errorf("failed to store session: %w", err)
+	}
+	sm.audit.Record(AuditEntry{Actor: opts.Owner, SessionID: id, Operation: "session.create"}, nil)
+	sm.emitSessionEvent(SessionCreated, session)
 	return session, nil
 }
 
 func (sm *SessionManager) GetSession(id string) (*Session, error) {
-	sm.mutex.RLock()
-	defer sm.mutex.RUnlock()
+	if provider := sm.sessionProvider(); provider != nil {
+		return provider.GetSession(id)
+	}
+	
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
 	
-	session, exists := sm.sessions[id]
-	if !exists || !session.IsActive {
-		return nil, errors.New("session not found or inactive")
+	session, err := sm.loadSession(id)
+	if err != nil {
+		return nil, err
 	}
 	
 	// Update last active time and extend expiry
-	now := time.Now()
-	session.LastActive = now
-	session.ExpiresAt = now.Add(sm.sessionExpiry)
+	sm.extendSession(session, time.Now())
+	if err := sm.store.Save(session); err != nil {
+		return nil, fmt.Errorf("failed to store session: %w", err)
+	}
 	return session, nil
 }
 
+// PeekSession returns an active session without extending its expiry, for
+// reads that should not keep a session alive
+func (sm *SessionManager) PeekSession(id string) (*Session, error) {
+	if provider := sm.sessionProvider(); provider != nil {
+		return provider.PeekSession(id)
+	}
+	
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	return sm.loadSession(id)
+}
+
+// SessionOwner returns the owner of a session and whether it exists
+func (sm *SessionManager) SessionOwner(id string) (string, bool) {
+	if provider := sm.sessionProvider(); provider != nil {
+		return provider.SessionOwner(id)
+	}
+	
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	
+	session, err := sm.store.Load(id)
+	if err != nil || session == nil {
+		return "", false
+	}
+	return session.Owner, true
+}
+
 func (sm *SessionManager) DeleteSession(id string) error {
+	if provider := sm.sessionProvider(); provider != nil {
+		return provider.DeleteSession(id)
+	}
+	
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 	
-	if _, exists := sm.sessions[id]; !exists {
-		return errors.New("session not found")
+	session, err := sm.store.Load(id)
+	if err != nil {
+		return fmt.Errorf("failed to load session: %w", err)
+	}
+	if session == nil {
+		return ErrSessionNotFound
 	}
 	
-	delete(sm.sessions, id)
-	fmt.Printf("[TERMINAL] Deleted session: %s\n", id)
+	if err := sm.store.Delete(id); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	sm.audit.Record(AuditEntry{Actor: session.Owner, SessionID: id, Operation: "session.delete"}, nil)
+	sm.emitSessionEvent(SessionDeleted, session)
 	return nil
 }
 
-func (sm *SessionManager) SetWorkingDirectory(id string, dir string) error {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
-	
-	session, exists := sm.sessions[id]
-	if !exists || !session.IsActive {
-		return errors.New("session not found or inactive")
+func (sm *SessionManager) SetWorkingDirectory(id string, dir string) (err error) {
+	if provider := sm.sessionProvider(); provider != nil {
+		return provider.SetWorkingDirectory(id, dir)
 	}
 	
-	// Check if directory exists
-	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		return errors.New("directory does not exist")
+	// A remote directory is looked up over SFTP before taking the lock, so
+	// a slow machine does not hold up other sessions
+	remoteChecked := false
+	var remoteErr error
+	if peeked, err := sm.PeekSession(id); err == nil && peeked.Remote != nil {
+		remoteChecked = true
+		remoteErr = sm.checkRemoteDir(peeked.Remote, dir)
 	}
 	
-	// Get absolute path
-	absPath, err := filepath.Abs(dir)
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	
+	session, err := sm.loadSession(id)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		sm.audit.Record(AuditEntry{Actor: session.Owner, SessionID: id, Operation: "session.cwd", Target: dir}, err)
+		if err == nil {
+			sm.emitSessionEvent(SessionWorkingDirChanged, session)
+		}
+	}()
+	
+	var absPath string
+	message := ""
+	if session.Remote != nil {
+		// The allowed directories describe this host, so they do not apply
+		if !remoteChecked {
+			return ErrSessionNotFound
+		}
+		if remoteErr != nil {
+			return remoteErr
+		}
+		absPath = path.Clean(dir)
+		message = " on " + session.Remote.Target()
+	} else {
+		// Check if directory exists
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			return errors.New("directory does not exist")
+		}
+		
+		// Get absolute path
+		absPath, err = filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		
+		if err := sm.checkAllowedDir(absPath); err != nil {
+			return err
+		}
+	}
 	
 	now := time.Now()
 	session.WorkingDir = absPath
-	session.LastActive = now
-	session.ExpiresAt = now.Add(sm.sessionExpiry)
-	session.ActivityLog = append(session.ActivityLog, fmt.Sprintf("%s: Set working directory to %s", 
-		now.Format(time.RFC3339), absPath))
+	sm.extendSession(session, now)
+	sm.addActivity(session, ActivityEntry{
+		Category: ActivitySession,
+		Target:   absPath,
+		Message:  "Set working directory to " + absPath + message,
+	})
 	
-	fmt.Printf("[TERMINAL] Session %s: Set working directory to %s\n", id, absPath)
-	return nil
+	return sm.store.Save(session)
 }
 
-func (sm *SessionManager) LogActivity(id string, activity string) error {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
-	
-	session, exists := sm.sessions[id]
-	if !exists || !session.IsActive {
-		return errors.New("session not found or inactive")
+// LogActivity records something done in a session. The timestamp is set
+// here, and an empty outcome is a success.
+func (sm *SessionManager) LogActivity(id string, entry ActivityEntry) error {
+	if provider := sm.sessionProvider(); provider != nil {
+		return provider.LogActivity(id, entry)
 	}
 	
-	now := time.Now()
-	logEntry := fmt.Sprintf("%s: %s", now.Format(time.RFC3339), activity)
-	session.ActivityLog = append(session.ActivityLog, logEntry)
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
 	
-	// Maintain a reasonable log size
-	if len(session.ActivityLog) > 100 {
-		session.ActivityLog = session.ActivityLog[len(session.ActivityLog)-100:]
+	session, err := sm.loadSession(id)
+	if err != nil {
+		return err
 	}
 	
-	return nil
+	sm.addActivity(session, entry)
+	return sm.store.Save(session)
 }
 
-func (sm *SessionManager) GetAllSessions() []*Session {
+// GetAllSessions lists the sessions created by owner, or every session when
+// owner is empty
+func (sm *SessionManager) GetAllSessions(owner string) []*Session {
+	if provider := sm.sessionProvider(); provider != nil {
+		return provider.GetAllSessions(owner)
+	}
+	
 	sm.mutex.RLock()
 	defer sm.mutex.RUnlock()
 	
-	sessions := make([]*Session, 0, len(sm.sessions))
-	for _, session := range sm.sessions {
+	stored, err := sm.store.List()
+	if err != nil {
+		slog.Warn("failed to list sessions", "error", err)
+	}
+	
+	sessions := make([]*Session, 0, len(stored))
+	for _, session := range stored {
+		if owner != "" && session.Owner != owner {
+			continue
+		}
 		sessions = append(sessions, session)
 	}
 	
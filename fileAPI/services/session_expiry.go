@@ -0,0 +1,49 @@
+package services
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrInvalidExpiry is returned when a session is given an expiry it cannot
+// have
+var ErrInvalidExpiry = errors.New("invalid session expiry")
+
+// TouchSession keeps a session alive for another expiry period, as any
+// request in it does
+func (sm *SessionManager) TouchSession(id string) (*Session, error) {
+	return sm.GetSession(id)
+}
+
+// extendSession records activity in a session at now and pushes back its
+// expiry: by the session's own expiry if it has one, else the server's.
+// Callers must hold sm.mutex.
+func (sm *SessionManager) extendSession(session *Session, now time.Time) {
+	session.LastActive = now
+	if session.NeverExpire {
+		return
+	}
+	expiry := sm.sessionExpiry
+	if session.ExpirySeconds > 0 {
+		expiry = time.Duration(session.ExpirySeconds) * time.Second
+	}
+	session.ExpiresAt = now.Add(expiry)
+}
+
+// expired reports whether a session has outlived its expiry at now
+func (session *Session) expired(now time.Time) bool {
+	return !session.NeverExpire && session.ExpiresAt.Before(now)
+}
+
+// TTL returns how long a session has left unless it is used, and false if
+// it never expires
+func (session *Session) TTL() (time.Duration, bool) {
+	if session.NeverExpire {
+		return 0, false
+	}
+	ttl := time.Until(session.ExpiresAt)
+	if ttl < 0 {
+		ttl = 0
+	}
+	return ttl, true
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// SessionStore keeps the sessions of a SessionManager. With Redis, replicas
+// of the API behind a load balancer share their sessions.
+type SessionStore interface {
+	// Load returns a stored session, or nil if there is none
+	Load(id string) (*Session, error)
+	// Save stores a session until it expires
+	Save(session *Session) error
+	Delete(id string) error
+	List() ([]*Session, error)
+}
+
+// SetSessionStore sets where sessions are kept, in memory by default.
+// Sessions created before are not moved.
+func (sm *SessionManager) SetSessionStore(store SessionStore) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.store = store
+}
+
+// MemorySessionStore keeps sessions in this process, the default for a
+// single replica
+type MemorySessionStore struct {
+	sessions map[string]*Session
+	mutex    sync.RWMutex
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]*Session)}
+}
+
+func (s *MemorySessionStore) Load(id string) (*Session, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.sessions[id], nil
+}
+
+func (s *MemorySessionStore) Save(session *Session) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sessions[session.ID] = session
+	return nil
+}
+
+func (s *MemorySessionStore) Delete(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *MemorySessionStore) List() ([]*Session, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// redisTimeout bounds each call to Redis
+const redisTimeout = 5 * time.Second
+
+// RedisSessionStore keeps sessions in Redis as JSON, under keys that expire
+// with the sessions, so every replica using the same Redis sees them
+type RedisSessionStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisSessionStore connects to the Redis server at url, such as
+// redis://:password@host:6379/0, storing sessions under keys starting with
+// prefix
+func NewRedisSessionStore(url string, prefix string) (*RedisSessionStore, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	return &RedisSessionStore{client: client, prefix: prefix}, nil
+}
+
+func (s *RedisSessionStore) Load(id string) (*Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	data, err := s.client.Get(ctx, s.prefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *RedisSessionStore) Save(session *Session) error {
+	ttl, expires := session.TTL()
+	if !expires {
+		// Kept until deleted
+		ttl = 0
+	} else if ttl <= 0 {
+		return s.Delete(session.ID)
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return s.client.Set(ctx, s.prefix+session.ID, data, ttl).Err()
+}
+
+func (s *RedisSessionStore) Delete(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return s.client.Del(ctx, s.prefix+id).Err()
+}
+
+func (s *RedisSessionStore) List() ([]*Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	var keys []string
+	iter := s.client.Scan(ctx, 0, s.prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]*Session, 0, len(values))
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			// Expired between SCAN and MGET
+			continue
+		}
+		var session Session
+		if err := json.Unmarshal([]byte(data), &session); err != nil {
+			slog.Warn("skipping unreadable session in Redis", "key", keys[i], "error", err)
+			continue
+		}
+		sessions = append(sessions, &session)
+	}
+	return sessions, nil
+}
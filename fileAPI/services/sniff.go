@@ -0,0 +1,81 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrBinaryFile is returned for binary files read as text
+var ErrBinaryFile = errors.New("file is binary")
+
+// magicNumbers are the formats http.DetectContentType does not know, by
+// the bytes they start with
+var magicNumbers = []struct {
+	prefix   string
+	mimeType string
+}{
+	{"\x7fELF", "application/x-elf"},
+	{"\xcf\xfa\xed\xfe", "application/x-mach-binary"},
+	{"\xce\xfa\xed\xfe", "application/x-mach-binary"},
+	{"\xca\xfe\xba\xbe", "application/java-vm"}, // Also fat Mach-O binaries
+	{"MZ", "application/vnd.microsoft.portable-executable"},
+	{"SQLite format 3\x00", "application/vnd.sqlite3"},
+	{"\x28\xb5\x2f\xfd", "application/zstd"},
+	{"BZh", "application/x-bzip2"},
+	{"\xfd7zXZ\x00", "application/x-xz"},
+	{"7z\xbc\xaf\x27\x1c", "application/x-7z-compressed"},
+	{"!<arch>\n", "application/x-archive"},
+}
+
+// sniffContent tells from the start of a file, up to binarySniffSize
+// bytes, whether it is binary and its MIME type. Content is binary when it
+// holds a NUL byte or is not UTF-8, so it could not be read as text; the
+// magic numbers of formats then name its type. With truncated, the content
+// goes on past head, whose last character may be cut short.
+func sniffContent(head []byte, truncated bool) (binary bool, mimeType string) {
+	text := head
+	if truncated {
+		for i := len(head) - 1; i >= 0 && i >= len(head)-utf8.UTFMax; i-- {
+			if utf8.RuneStart(head[i]) {
+				if !utf8.FullRune(head[i:]) {
+					text = head[:i]
+				}
+				break
+			}
+		}
+	}
+	binary = bytes.IndexByte(head, 0) >= 0 || !utf8.Valid(text)
+	mimeType = http.DetectContentType(head)
+	if binary && (mimeType == "application/octet-stream" || strings.HasPrefix(mimeType, "text/")) {
+		mimeType = "application/octet-stream"
+		for _, magic := range magicNumbers {
+			if bytes.HasPrefix(head, []byte(magic.prefix)) {
+				mimeType = magic.mimeType
+				break
+			}
+		}
+	}
+	return binary, mimeType
+}
+
+// sniffFile tells whether a file is binary and its MIME type from its first
+// binarySniffSize bytes
+func sniffFile(path string) (binary bool, mimeType string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, "", err
+	}
+	defer file.Close()
+	head := make([]byte, binarySniffSize+1)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, "", err
+	}
+	binary, mimeType = sniffContent(head[:min(n, binarySniffSize)], n > binarySniffSize)
+	return binary, mimeType, nil
+}
@@ -0,0 +1,589 @@
+package services
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Modes of diffs: the patches of a text diff, or the declarations a
+// structural diff finds changed
+const (
+	DiffModeText       = "text"
+	DiffModeStructural = "structural"
+)
+
+// How a structural diff finds declarations: by parsing Go, by the
+// indentation of Python, or by matching braces in other languages
+const (
+	StructureGo          = "go"
+	StructureIndentation = "indentation"
+	StructureBraces      = "braces"
+)
+
+// What became of a declaration
+const (
+	DeclarationAdded    = "added"
+	DeclarationRemoved  = "removed"
+	DeclarationModified = "modified"
+	DeclarationRenamed  = "renamed"
+)
+
+// StructuralChange is a declaration added, removed, modified or renamed.
+// Lines count from 1, and LinesAdded and LinesRemoved are those of the
+// declaration itself, without the declarations nested in it. The lines of
+// a file outside its declarations are the declaration "(top level)".
+type StructuralChange struct {
+	Kind             string `json:"kind"` // Such as func, method, type, class or function
+	Name             string `json:"name"` // Qualified by the declarations it is in, as in Type.Method
+	Change           string `json:"change"`
+	OriginalName     string `json:"originalName,omitempty"` // Of a renamed declaration
+	Line             int    `json:"line,omitempty"`         // In the modified text
+	OriginalLine     int    `json:"originalLine,omitempty"` // In the original text
+	LinesAdded       int    `json:"linesAdded"`
+	LinesRemoved     int    `json:"linesRemoved"`
+	SignatureChanged bool   `json:"signatureChanged,omitempty"` // Of a function, beyond its body
+	WhitespaceOnly   bool   `json:"whitespaceOnly,omitempty"`
+}
+
+// StructuralDiff is the declarations that changed between two texts, those
+// of the modified text in its order and then those removed. ParseError is
+// why Go source that did not parse was diffed by its braces instead.
+type StructuralDiff struct {
+	Language   string             `json:"language,omitempty"`
+	Method     string             `json:"method"` // go, indentation or braces
+	Added      int                `json:"added"`
+	Removed    int                `json:"removed"`
+	Modified   int                `json:"modified"`
+	Renamed    int                `json:"renamed"`
+	Unchanged  int                `json:"unchanged"`
+	Changes    []StructuralChange `json:"changes"`
+	ParseError string             `json:"parseError,omitempty"`
+}
+
+// declaration is a declaration of a text: its lines from start to end,
+// counting from 0, and the declaration it is nested in
+type declaration struct {
+	kind      string
+	name      string
+	start     int
+	end       int
+	parent    int    // Index of the declaration it is in, or -1
+	signature string // Of a function, with whitespace collapsed
+	text      string // Its lines, without those of the declarations in it
+}
+
+// key identifies a declaration among those of its text
+func (d *declaration) key() string {
+	return d.kind + " " + d.name
+}
+
+// StructuralDiff diffs two texts as DiffRequest gives them by their
+// declarations rather than their lines: Go is parsed, Python is read by
+// its indentation, and other languages by their braces. The language is
+// the request's, or that of its paths' extension.
+func (ds *DiffService) StructuralDiff(sessionID string, req *DiffRequest) (result *StructuralDiff, err error) {
+	defer func() {
+		ds.sessionManager.Audit(sessionID, "diff.structural", req.OriginalPath, "against "+req.ModifiedPath, err)
+	}()
+
+	texts := []*string{&req.Original, &req.Modified}
+	for i, path := range []string{req.OriginalPath, req.ModifiedPath} {
+		if path == "" {
+			continue
+		}
+		content, err := ds.fileService.ReadTextFile(sessionID, path)
+		if err != nil {
+			return nil, err
+		}
+		*texts[i] = string(content)
+	}
+
+	language := req.Language
+	for _, path := range []string{req.ModifiedPath, req.OriginalPath} {
+		if language == "" && path != "" {
+			language = detectLanguage(strings.ToLower(filepath.Ext(path)))
+		}
+	}
+	return structuralDiff(req.Original, req.Modified, language), nil
+}
+
+// structuralDiff diffs the declarations of two texts in a language
+func structuralDiff(original string, modified string, language string) *StructuralDiff {
+	result := &StructuralDiff{Language: language, Changes: []StructuralChange{}}
+	originalLines, modifiedLines := splitAfterLines(original), splitAfterLines(modified)
+
+	var before, after []declaration
+	switch {
+	case strings.EqualFold(language, "Go"):
+		var err error
+		result.Method = StructureGo
+		if before, err = goDeclarations(original); err == nil {
+			after, err = goDeclarations(modified)
+		}
+		if err != nil {
+			result.ParseError = err.Error()
+			result.Method = StructureBraces
+			before, after = braceDeclarations(originalLines), braceDeclarations(modifiedLines)
+		}
+	case strings.EqualFold(language, "Python"):
+		result.Method = StructureIndentation
+		before, after = indentedDeclarations(originalLines), indentedDeclarations(modifiedLines)
+	default:
+		result.Method = StructureBraces
+		before, after = braceDeclarations(originalLines), braceDeclarations(modifiedLines)
+	}
+	before, after = withTopLevel(before, originalLines), withTopLevel(after, modifiedLines)
+
+	originals := make(map[string]*declaration, len(before))
+	for i := range before {
+		originals[before[i].key()] = &before[i]
+	}
+	matched := make(map[string]bool, len(before))
+	var added []int
+	for i := range after {
+		d := &after[i]
+		old, ok := originals[d.key()]
+		if !ok {
+			added = append(added, len(result.Changes))
+			result.Changes = append(result.Changes, StructuralChange{
+				Kind:   d.kind,
+				Name:   d.name,
+				Change: DeclarationAdded,
+				Line:   d.start + 1,
+			})
+			result.Changes[len(result.Changes)-1].LinesAdded, _ = diffStats("", d.text)
+			continue
+		}
+		matched[d.key()] = true
+		if old.text == d.text {
+			result.Unchanged++
+			continue
+		}
+		change := StructuralChange{
+			Kind:             d.kind,
+			Name:             d.name,
+			Change:           DeclarationModified,
+			Line:             d.start + 1,
+			OriginalLine:     old.start + 1,
+			SignatureChanged: old.signature != d.signature,
+			WhitespaceOnly:   strings.Join(strings.Fields(old.text), " ") == strings.Join(strings.Fields(d.text), " "),
+		}
+		change.LinesAdded, change.LinesRemoved = diffStats(old.text, d.text)
+		result.Changes = append(result.Changes, change)
+	}
+
+	for i := range before {
+		d := &before[i]
+		if matched[d.key()] {
+			continue
+		}
+		// A declaration added with the same text under another name was
+		// renamed
+		renamed := false
+		for _, j := range added {
+			change := &result.Changes[j]
+			if change.Change != DeclarationAdded || change.Kind != d.kind {
+				continue
+			}
+			text := after[indexOf(after, change.Kind, change.Name)].text
+			if strings.ReplaceAll(d.text, baseName(d.name), baseName(change.Name)) == text {
+				change.Change, change.OriginalName, change.OriginalLine = DeclarationRenamed, d.name, d.start+1
+				change.LinesAdded = 0
+				renamed = true
+				break
+			}
+		}
+		if renamed {
+			continue
+		}
+		change := StructuralChange{
+			Kind:         d.kind,
+			Name:         d.name,
+			Change:       DeclarationRemoved,
+			OriginalLine: d.start + 1,
+		}
+		_, change.LinesRemoved = diffStats(d.text, "")
+		result.Changes = append(result.Changes, change)
+	}
+
+	for _, change := range result.Changes {
+		switch change.Change {
+		case DeclarationAdded:
+			result.Added++
+		case DeclarationRemoved:
+			result.Removed++
+		case DeclarationModified:
+			result.Modified++
+		case DeclarationRenamed:
+			result.Renamed++
+		}
+	}
+	return result
+}
+
+// indexOf returns the index of the declaration of a kind and name
+func indexOf(declarations []declaration, kind string, name string) int {
+	for i := range declarations {
+		if declarations[i].kind == kind && declarations[i].name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// baseName is a declaration's own name, without those of the declarations
+// it is in
+func baseName(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// withTopLevel names the declarations after those they are in, tells apart
+// those of the same name by their order, fills in their text and adds the
+// lines outside them that are not blank as the declaration "(top level)"
+func withTopLevel(declarations []declaration, lines []string) []declaration {
+	seen := make(map[string]int)
+	covered := make([]bool, len(lines))
+	for i := range declarations {
+		d := &declarations[i]
+		if d.parent >= 0 {
+			d.name = declarations[d.parent].name + "." + d.name
+		}
+		if seen[d.key()]++; seen[d.key()] > 1 {
+			d.name += fmt.Sprintf("#%d", seen[d.key()])
+		}
+
+		// The lines of the declarations in it are left out, and the blank
+		// lines between them, so that adding one does not change it
+		nested := make([]bool, d.end-d.start)
+		for j := range declarations {
+			if declarations[j].parent == i {
+				for line := declarations[j].start; line < declarations[j].end; line++ {
+					nested[line-d.start] = true
+				}
+			}
+		}
+		var text strings.Builder
+		for line := d.start; line < d.end; line++ {
+			if !nested[line-d.start] && !(slices.Contains(nested, true) && strings.TrimSpace(lines[line]) == "") {
+				text.WriteString(lines[line])
+			}
+			covered[line] = true
+		}
+		d.text = text.String()
+	}
+
+	topLevel := declaration{kind: "other", name: "(top level)", start: -1, parent: -1}
+	var rest strings.Builder
+	for line, text := range lines {
+		if !covered[line] && strings.TrimSpace(text) != "" {
+			if topLevel.start < 0 {
+				topLevel.start = line
+			}
+			rest.WriteString(text)
+		}
+	}
+	topLevel.text = rest.String()
+	return append(declarations, topLevel)
+}
+
+// goDeclarations lists the declarations of Go source: its package clause,
+// imports, functions, methods, types, constants and variables, each with
+// its doc comment
+func goDeclarations(source string) ([]declaration, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", source, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	line := func(pos token.Pos) int {
+		return fset.Position(pos).Line - 1
+	}
+	span := func(doc *ast.CommentGroup, node ast.Node) (int, int) {
+		start := node.Pos()
+		if doc != nil {
+			start = doc.Pos()
+		}
+		return line(start), line(node.End()) + 1
+	}
+
+	start, end := span(file.Doc, file.Name)
+	start = min(start, line(file.Package))
+	declarations := []declaration{{kind: "package", name: file.Name.Name, start: start, end: end, parent: -1}}
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			d := declaration{kind: "func", name: decl.Name.Name, parent: -1}
+			if decl.Recv != nil && len(decl.Recv.List) > 0 {
+				d.kind, d.name = "method", receiverName(decl.Recv.List[0].Type)+"."+decl.Name.Name
+			}
+			d.start, d.end = span(decl.Doc, decl)
+			signatureEnd := decl.End()
+			if decl.Body != nil {
+				signatureEnd = decl.Body.Lbrace
+			}
+			d.signature = strings.Join(strings.Fields(source[fset.Position(decl.Pos()).Offset:fset.Position(signatureEnd).Offset]), " ")
+			declarations = append(declarations, d)
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				d := declaration{kind: decl.Tok.String(), parent: -1}
+				var doc *ast.CommentGroup
+				switch spec := spec.(type) {
+				case *ast.ImportSpec:
+					d.name, doc = spec.Path.Value, spec.Doc
+					if spec.Name != nil {
+						d.name = spec.Name.Name + " " + d.name
+					}
+				case *ast.TypeSpec:
+					d.name, doc = spec.Name.Name, spec.Doc
+				case *ast.ValueSpec:
+					var names []string
+					for _, name := range spec.Names {
+						names = append(names, name.Name)
+					}
+					d.name, doc = strings.Join(names, ", "), spec.Doc
+				}
+				if decl.Lparen.IsValid() {
+					d.start, d.end = span(doc, spec)
+				} else {
+					// The declaration of a single spec, with its doc comment
+					d.start, d.end = span(decl.Doc, decl)
+				}
+				declarations = append(declarations, d)
+			}
+		}
+	}
+	return declarations, nil
+}
+
+// receiverName is the name of a method's receiver type, without its
+// pointer or type parameters
+func receiverName(expr ast.Expr) string {
+	switch expr := expr.(type) {
+	case *ast.StarExpr:
+		return receiverName(expr.X)
+	case *ast.IndexExpr:
+		return receiverName(expr.X)
+	case *ast.IndexListExpr:
+		return receiverName(expr.X)
+	case *ast.ParenExpr:
+		return receiverName(expr.X)
+	case *ast.Ident:
+		return expr.Name
+	}
+	return "?"
+}
+
+// Keywords that open a declaration others are nested in, and those that
+// declare a function, in the languages read by their braces
+var (
+	containerKeywords = map[string]bool{
+		"class": true, "interface": true, "struct": true, "enum": true, "union": true,
+		"trait": true, "impl": true, "namespace": true, "module": true, "object": true,
+		"record": true, "extension": true, "protocol": true,
+	}
+	functionKeywords = map[string]bool{"function": true, "func": true, "fn": true, "fun": true, "def": true}
+	// Keywords of blocks of statements, which are not declarations
+	statementKeywords = map[string]bool{
+		"if": true, "else": true, "for": true, "foreach": true, "while": true, "do": true,
+		"switch": true, "case": true, "try": true, "catch": true, "finally": true, "with": true,
+		"return": true, "unsafe": true, "loop": true, "match": true, "using": true, "lock": true,
+	}
+	identifier = regexp.MustCompile(`[A-Za-z_$][\w$]*`)
+)
+
+// braceDeclarations lists the blocks between braces at the top level of a
+// text, and those in classes, structs, namespaces and the like, named
+// after the line that opens them. Braces in strings and comments are
+// skipped.
+func braceDeclarations(lines []string) []declaration {
+	type open struct {
+		declaration int // Index of the block's declaration, or -1
+		container   bool
+	}
+	var declarations []declaration
+	var stack []open
+	inComment := false
+	for number, line := range lines {
+		for i := 0; i < len(line); i++ {
+			switch {
+			case inComment:
+				if strings.HasPrefix(line[i:], "*/") {
+					inComment = false
+					i++
+				}
+			case strings.HasPrefix(line[i:], "/*"):
+				inComment = true
+				i++
+			case strings.HasPrefix(line[i:], "//"):
+				i = len(line)
+			case line[i] == '"' || line[i] == '\'' || line[i] == '`':
+				// To the end of the string, or of the line when it does not
+				// end there, as with the lifetimes of Rust
+				if end := closingQuote(line, i); end > 0 {
+					i = end
+				}
+			case line[i] == '{':
+				entry := open{declaration: -1}
+				parent := -1
+				if len(stack) > 0 {
+					parent = stack[len(stack)-1].declaration
+				}
+				if len(stack) == 0 || (stack[len(stack)-1].container && parent >= 0) {
+					header, start := line[:i], number
+					if strings.TrimSpace(header) == "" {
+						// A brace on a line of its own opens the block of the
+						// line before
+						for start > 0 && strings.TrimSpace(lines[start-1]) == "" {
+							start--
+						}
+						if start > 0 {
+							start--
+							header = lines[start]
+						}
+					}
+					kind, name := blockName(header)
+					if kind != "" {
+						entry.declaration = len(declarations)
+						entry.container = containerKeywords[kind]
+						declarations = append(declarations, declaration{kind: kind, name: name, start: start, end: number + 1, parent: parent})
+						if kind == "function" || functionKeywords[kind] {
+							declarations[entry.declaration].signature = strings.Join(strings.Fields(header), " ")
+						}
+					}
+				}
+				stack = append(stack, entry)
+			case line[i] == '}':
+				if len(stack) == 0 {
+					continue
+				}
+				if d := stack[len(stack)-1].declaration; d >= 0 {
+					declarations[d].end = number + 1
+				}
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	return declarations
+}
+
+// closingQuote returns the index of the quote closing the string opening
+// at start of line, or -1 when the line does not close it
+func closingQuote(line string, start int) int {
+	for i := start + 1; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case line[start]:
+			return i
+		}
+	}
+	return -1
+}
+
+// blockName tells the kind and name of a block from the text before its
+// brace: a keyword and the name after it, as in class Foo or fn main, a
+// function where the text has parentheses, or another block named after
+// what it is assigned to. Blocks of statements have no kind.
+func blockName(header string) (string, string) {
+	header = strings.TrimSpace(header)
+	words := identifier.FindAllString(header, -1)
+	if len(words) > 0 && statementKeywords[words[0]] {
+		return "", ""
+	}
+	for i, word := range words {
+		if !containerKeywords[word] && !functionKeywords[word] && word != "type" || i+1 == len(words) {
+			continue
+		}
+		rest := strings.TrimSpace(header[identifier.FindAllStringIndex(header, -1)[i][1]:])
+		switch {
+		case word == "impl":
+			// impl Trait for Type, with its generics
+			return word, strings.Join(strings.Fields(rest), " ")
+		case functionKeywords[word] && strings.HasPrefix(rest, "("):
+			// A method of Go, after its receiver
+			if _, after, found := strings.Cut(rest, ")"); found {
+				if names := identifier.FindAllString(after, 1); len(names) > 0 {
+					return word, names[0]
+				}
+			}
+		}
+		return word, words[i+1]
+	}
+	if before, _, found := strings.Cut(header, "("); found {
+		names := identifier.FindAllString(before, -1)
+		if len(names) > 0 {
+			return "function", names[len(names)-1]
+		}
+	}
+	if before, _, found := strings.Cut(header, "="); found {
+		if names := identifier.FindAllString(before, -1); len(names) > 0 {
+			return "block", names[len(names)-1]
+		}
+	}
+	if header == "" {
+		return "block", "(block)"
+	}
+	return "block", strings.Join(strings.Fields(header), " ")
+}
+
+// indentedDeclaration is a def or class line of Python, with the
+// decorators before it
+var indentedDeclaration = regexp.MustCompile(`^(\s*)(?:async\s+)?(def|class)\s+(\w+)`)
+
+// indentedDeclarations lists the functions and classes at the top level of
+// Python source and the methods of its classes, each with its decorators,
+// ending before the next line indented no further than it is
+func indentedDeclarations(lines []string) []declaration {
+	type open struct {
+		declaration int
+		indent      int
+	}
+	var declarations []declaration
+	var stack []open
+	lastCode := -1
+	for number, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		for len(stack) > 0 && indent <= stack[len(stack)-1].indent {
+			declarations[stack[len(stack)-1].declaration].end = lastCode + 1
+			stack = stack[:len(stack)-1]
+		}
+		lastCode = number
+
+		match := indentedDeclaration.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		parent := -1
+		if len(stack) > 0 {
+			parent = stack[len(stack)-1].declaration
+			if declarations[parent].kind != "class" {
+				// Functions nested in functions are part of them
+				continue
+			}
+		}
+		start := number
+		for start > 0 && strings.HasPrefix(strings.TrimSpace(lines[start-1]), "@") {
+			start--
+		}
+		d := declaration{kind: match[2], name: match[3], start: start, end: number + 1, parent: parent}
+		if d.kind == "def" {
+			d.signature = strings.Join(strings.Fields(trimmed), " ")
+		}
+		stack = append(stack, open{declaration: len(declarations), indent: indent})
+		declarations = append(declarations, d)
+	}
+	for _, entry := range stack {
+		declarations[entry.declaration].end = lastCode + 1
+	}
+	return declarations
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrNotSymlink is returned for symlink operations on a path that is not one
+var ErrNotSymlink = errors.New("not a symlink")
+
+// SymlinkInfo describes a symlink and what it points to
+type SymlinkInfo struct {
+	Path     string `json:"path"`
+	Target   string `json:"target"`             // As stored in the link
+	Resolved string `json:"resolved,omitempty"` // What it leads to, relative to the working directory
+	Exists   bool   `json:"exists"`             // Whether what it leads to exists
+	IsDir    bool   `json:"isDir"`
+	Outside  bool   `json:"outsideWorkingDir,omitempty"` // Leads outside the working directory, which is not followed
+}
+
+// realWorkingDir returns a session's working directory with its symlinks
+// resolved, the root symlinks have to stay inside to be followed
+func (sm *SessionManager) realWorkingDir(sessionID string) (string, error) {
+	root, err := sm.ResolvePath(sessionID, ".")
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(root)
+}
+
+// followedInfo is the information of what a symlink points to, under the
+// link's name
+type followedInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (i followedInfo) Name() string { return i.name }
+
+// lookEntry returns the information to report for entry, as read from
+// directory dir, and where it points when it is a symlink. With follow, a
+// symlink reports what it points to, when that exists inside root; links
+// leading outside it, dangling or looping report themselves.
+func lookEntry(root string, dir string, entry os.FileInfo, follow bool) (os.FileInfo, string) {
+	if entry.Mode()&os.ModeSymlink == 0 {
+		return entry, ""
+	}
+	path := filepath.Join(dir, entry.Name())
+	target, _ := os.Readlink(path)
+	if !follow {
+		return entry, target
+	}
+
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil || !isWithin(root, real) {
+		return entry, target
+	}
+	info, err := os.Stat(real)
+	if err != nil {
+		return entry, target
+	}
+	return followedInfo{info, entry.Name()}, target
+}
+
+// CreateSymlink creates a symlink at relativePath to target, creating its
+// parent directories. As with ln -s, a relative target is relative to the
+// link's directory. The target need not exist, but has to be inside the
+// working directory.
+func (fs *FileService) CreateSymlink(sessionID string, relativePath string, target string) (err error) {
+	defer func() {
+		fs.sessionManager.Audit(sessionID, "symlink.create", relativePath, "to "+target, err)
+	}()
+
+	if target == "" {
+		return fmt.Errorf("%w: target is required", ErrInvalidTarget)
+	}
+	linkPath, err := fs.GetFilePath(sessionID, relativePath)
+	if err != nil {
+		return err
+	}
+	root, err := fs.GetFilePath(sessionID, ".")
+	if err != nil {
+		return err
+	}
+
+	resolved := target
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(filepath.Dir(linkPath), target)
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || !isWithin(root, resolved) {
+		return &PathEscapeError{Path: target}
+	}
+	if _, err := confinePath(root, rel); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(linkPath), 0755); err != nil {
+		return err
+	}
+	if err := os.Symlink(target, linkPath); err != nil {
+		return err
+	}
+
+	fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityFile,
+		Target:   relativePath,
+		Message:  fmt.Sprintf("Created symlink %s to %s", relativePath, target),
+	})
+	return nil
+}
+
+// ReadSymlink returns where a symlink points. The link itself has to be in
+// the working directory, but may point outside it.
+func (fs *FileService) ReadSymlink(sessionID string, relativePath string) (info *SymlinkInfo, err error) {
+	defer func() {
+		fs.sessionManager.Audit(sessionID, "symlink.read", relativePath, "", err)
+	}()
+
+	// The link's directory is confined; the link is not followed
+	if filepath.Base(relativePath) == ".." {
+		return nil, &PathEscapeError{Path: relativePath}
+	}
+	dir, err := fs.GetFilePath(sessionID, filepath.Dir(relativePath))
+	if err != nil {
+		return nil, err
+	}
+	linkPath := filepath.Join(dir, filepath.Base(relativePath))
+
+	linkInfo, err := os.Lstat(linkPath)
+	if err != nil {
+		return nil, err
+	}
+	if linkInfo.Mode()&os.ModeSymlink == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotSymlink, relativePath)
+	}
+	target, err := os.Readlink(linkPath)
+	if err != nil {
+		return nil, err
+	}
+
+	info = &SymlinkInfo{Path: relativePath, Target: target}
+	root, err := fs.sessionManager.realWorkingDir(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	real, err := filepath.EvalSymlinks(linkPath)
+	if err != nil {
+		// Dangling: where it would lead as written
+		real = target
+		if !filepath.IsAbs(real) {
+			real = filepath.Join(filepath.Dir(linkPath), target)
+		}
+		if realDir, err := filepath.EvalSymlinks(dir); err == nil && !filepath.IsAbs(target) {
+			real = filepath.Join(realDir, target)
+		}
+	}
+	if !isWithin(root, real) {
+		info.Outside = true
+	} else {
+		info.Resolved, _ = filepath.Rel(root, real)
+		if stat, err := os.Stat(real); err == nil {
+			info.Exists = true
+			info.IsDir = stat.IsDir()
+		}
+	}
+
+	fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityFile,
+		Target:   relativePath,
+		Message:  "Read symlink " + relativePath,
+	})
+	return info, nil
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Tail limits: the most lines a tail returns, the longest line a followed
+// file buffers before sending it unfinished, and the most read at once
+const (
+	DefaultTailLines = 100
+	MaxTailLines     = 10000
+	maxTailLine      = 64 * 1024
+	maxTailRead      = 1024 * 1024
+)
+
+// TailInterval is how often a followed file is checked for appended lines
+const TailInterval = 250 * time.Millisecond
+
+// TailResult is the end of a file
+type TailResult struct {
+	Path  string   `json:"path"`
+	Lines []string `json:"lines"`
+	Size  int64    `json:"size"` // Of the file, where following it starts
+}
+
+// TailEvent reports lines appended to a followed file, or that it was
+// truncated or replaced and is read again from its start
+type TailEvent struct {
+	Type   string `json:"type"` // line, truncated or rotated
+	Line   string `json:"line,omitempty"`
+	Offset int64  `json:"offset"` // Just past the line, or where reading resumes
+}
+
+// TailFile returns the last lines of a file, without their newlines
+func (fs *FileService) TailFile(sessionID string, relativePath string, lines int) (result *TailResult, err error) {
+	defer func() {
+		fs.sessionManager.Audit(sessionID, "file.tail", relativePath, fmt.Sprintf("%d lines", lines), err)
+	}()
+
+	if lines < 0 || lines > MaxTailLines {
+		return nil, fmt.Errorf("%w: lines must be between 0 and %d", ErrInvalidRange, MaxTailLines)
+	}
+	fullPath, err := fs.GetFilePath(sessionID, relativePath)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%w: %s", ErrIsDirectory, relativePath)
+	}
+
+	last, err := lastLines(file, info.Size(), lines)
+	if err != nil {
+		return nil, err
+	}
+	fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityFile,
+		Target:   relativePath,
+		Message:  fmt.Sprintf("Read the last %d lines of %s", len(last), relativePath),
+	})
+	return &TailResult{Path: relativePath, Lines: last, Size: info.Size()}, nil
+}
+
+// lastLines reads the last n lines of the first size bytes of file,
+// backwards from the end so a large file is not read whole
+func lastLines(file *os.File, size int64, n int) ([]string, error) {
+	if n == 0 || size == 0 {
+		return []string{}, nil
+	}
+
+	const chunk = 32 * 1024
+	var tail []byte
+	end := size
+	// A final newline ends the last line rather than starting another
+	newlines := n
+	for end > 0 {
+		start := max(end-chunk, 0)
+		buffer := make([]byte, end-start)
+		if _, err := file.ReadAt(buffer, start); err != nil && err != io.EOF {
+			return nil, err
+		}
+		tail = append(buffer, tail...)
+		end = start
+
+		wanted := newlines
+		if tail[len(tail)-1] == '\n' {
+			wanted++
+		}
+		if bytes.Count(tail, []byte{'\n'}) >= wanted {
+			break
+		}
+	}
+
+	lines := splitLines(string(tail))
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+}
+
+// FollowFile calls send with each line appended to a file from offset on
+// until ctx is done, checking it every TailInterval. A file truncated, or
+// replaced as log rotation does, is read again from its start; one that is
+// missing is waited for. It ends with an error if the session goes away or
+// send fails.
+func (fs *FileService) FollowFile(ctx context.Context, sessionID string, relativePath string, offset int64, send func(TailEvent) error) error {
+	fullPath, err := fs.GetFilePath(sessionID, relativePath)
+	if err != nil {
+		return err
+	}
+	fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityFile,
+		Target:   relativePath,
+		Message:  "Started following " + relativePath,
+	})
+	fs.sessionManager.Audit(sessionID, "file.follow", relativePath, fmt.Sprintf("from %d", offset), nil)
+
+	var file *os.File
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+	var partial []byte
+
+	ticker := time.NewTicker(TailInterval)
+	defer ticker.Stop()
+	for {
+		// Checking the path keeps the session alive, and ends the follow
+		// when the session goes away
+		if _, err := fs.GetFilePath(sessionID, relativePath); err != nil {
+			return err
+		}
+
+		current, err := os.Stat(fullPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if file != nil && current != nil {
+			opened, err := file.Stat()
+			if err != nil {
+				return err
+			}
+			if !os.SameFile(opened, current) {
+				file.Close()
+				file, offset, partial = nil, 0, nil
+				if err := send(TailEvent{Type: "rotated"}); err != nil {
+					return err
+				}
+			}
+		}
+		if file == nil && current != nil {
+			if file, err = os.Open(fullPath); err != nil {
+				return err
+			}
+		}
+
+		if file != nil {
+			info, err := file.Stat()
+			if err != nil {
+				return err
+			}
+			if info.Size() < offset {
+				offset, partial = 0, nil
+				if err := send(TailEvent{Type: "truncated"}); err != nil {
+					return err
+				}
+			}
+			// Read in bounded chunks, however much was appended
+			for info.Size() > offset {
+				data := make([]byte, min(info.Size()-offset, maxTailRead))
+				n, err := file.ReadAt(data, offset)
+				if err != nil && err != io.EOF {
+					return err
+				}
+				if n == 0 {
+					break
+				}
+				offset += int64(n)
+				partial = append(partial, data[:n]...)
+
+				// Complete lines are sent; the rest waits for its newline
+				// unless it grows too long
+				for {
+					i := bytes.IndexByte(partial, '\n')
+					if i < 0 && len(partial) < maxTailLine {
+						break
+					}
+					line := partial
+					if i >= 0 {
+						line = partial[:i]
+						partial = partial[i+1:]
+					} else {
+						partial = nil
+					}
+					if err := send(TailEvent{Type: "line", Line: string(line), Offset: offset - int64(len(partial))}); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// commentSyntax is how a language writes comments: prefixes ending the line
+// in a comment, and the delimiters of block comments
+type commentSyntax struct {
+	line  []string
+	block [][2]string
+}
+
+var (
+	cComments      = commentSyntax{line: []string{"//"}, block: [][2]string{{"/*", "*/"}}}
+	hashComments   = commentSyntax{line: []string{"#"}}
+	markupComments = commentSyntax{block: [][2]string{{"<!--", "-->"}}}
+)
+
+// commentSyntaxes are the comments of the languages detectLanguage names;
+// languages without comments, such as JSON, are left out
+var commentSyntaxes = map[string]commentSyntax{
+	"Go":         cComments,
+	"JavaScript": cComments,
+	"TypeScript": cComments,
+	"JSX":        cComments,
+	"Java":       cComments,
+	"Rust":       cComments,
+	"C#":         cComments,
+	"C":          cComments,
+	"C++":        cComments,
+	"Swift":      cComments,
+	"Kotlin":     cComments,
+	"Scala":      cComments,
+	"Dart":       cComments,
+	"SCSS":       cComments,
+	"Less":       cComments,
+	"PHP":        {line: []string{"//", "#"}, block: [][2]string{{"/*", "*/"}}},
+	"CSS":        {block: [][2]string{{"/*", "*/"}}},
+	"Python":     hashComments,
+	"Shell":      hashComments,
+	"Perl":       hashComments,
+	"R":          hashComments,
+	"Elixir":     hashComments,
+	"YAML":       hashComments,
+	"Ruby":       {line: []string{"#"}, block: [][2]string{{"=begin", "=end"}}},
+	"SQL":        {line: []string{"--"}, block: [][2]string{{"/*", "*/"}}},
+	"Haskell":    {line: []string{"--"}, block: [][2]string{{"{-", "-}"}}},
+	"Lua":        {line: []string{"--"}, block: [][2]string{{"--[[", "]]"}}},
+	"Clojure":    {line: []string{";"}},
+	"HTML":       markupComments,
+	"XML":        markupComments,
+	"Markdown":   markupComments,
+}
+
+// TextStats sizes up a text file. Lines are blank, comments or code:
+// comments are the lines that start with one, or lie inside a block comment,
+// for the languages whose syntax is known; the rest of the lines that are
+// not blank are code. The longest line is measured in characters, without
+// its line ending.
+type TextStats struct {
+	Path              string `json:"path"`
+	Language          string `json:"language"`
+	Bytes             int64  `json:"bytes"`
+	Characters        int64  `json:"characters"`
+	Words             int    `json:"words"`
+	Lines             int    `json:"lines"`
+	BlankLines        int    `json:"blankLines"`
+	CommentLines      int    `json:"commentLines"`
+	CodeLines         int    `json:"codeLines"`
+	LongestLine       int    `json:"longestLine"`
+	LongestLineNumber int    `json:"longestLineNumber,omitempty"`
+	LineEndings       string `json:"lineEndings,omitempty"` // lf, crlf or mixed
+}
+
+// TextStats counts the lines, words, characters and bytes of a text file,
+// scanning it rather than loading it. Binary files are refused.
+func (fs *FileService) TextStats(sessionID string, relativePath string) (stats *TextStats, err error) {
+	defer func() {
+		fs.sessionManager.Audit(sessionID, "file.stats", relativePath, "", err)
+	}()
+
+	fullPath, err := fs.GetFilePath(sessionID, relativePath)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%w: %s", ErrIsDirectory, relativePath)
+	}
+	if binary, _, err := sniffFile(fullPath); err != nil {
+		return nil, err
+	} else if binary {
+		return nil, fmt.Errorf("%w: %s", ErrBinaryFile, relativePath)
+	}
+
+	language := detectLanguage(strings.ToLower(filepath.Ext(relativePath)))
+	stats, err = countText(file, commentSyntaxes[language])
+	if err != nil {
+		return nil, err
+	}
+	stats.Path, stats.Language = relativePath, language
+
+	fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityFile,
+		Target:   relativePath,
+		Message:  "Counted the lines of " + relativePath,
+	})
+	return stats, nil
+}
+
+// countText counts the lines of r as TextStats does, with the comments of
+// syntax
+func countText(r io.Reader, syntax commentSyntax) (*TextStats, error) {
+	stats := &TextStats{}
+	var crlf, lf int
+	// The end of the block comment the current line is in, if any
+	blockEnd := ""
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			stats.Lines++
+			stats.Bytes += int64(len(line))
+			stats.Characters += int64(utf8.RuneCount(line))
+			stats.Words += len(bytes.Fields(line))
+
+			text := bytes.TrimSuffix(line, []byte{'\n'})
+			if len(text) < len(line) {
+				if bytes.HasSuffix(text, []byte{'\r'}) {
+					text = text[:len(text)-1]
+					crlf++
+				} else {
+					lf++
+				}
+			}
+			if length := utf8.RuneCount(text); length > stats.LongestLine {
+				stats.LongestLine, stats.LongestLineNumber = length, stats.Lines
+			}
+
+			switch syntax.classify(string(bytes.TrimSpace(text)), &blockEnd) {
+			case "blank":
+				stats.BlankLines++
+			case "comment":
+				stats.CommentLines++
+			default:
+				stats.CodeLines++
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	stats.LineEndings = lineEndingsOf(crlf, lf)
+	return stats, nil
+}
+
+// classify tells whether a trimmed line is blank, a comment or code.
+// blockEnd holds the end of the block comment lines are in, and is updated
+// as the line opens or closes one. A line that closes a comment and goes on
+// with something else is code.
+func (syntax commentSyntax) classify(line string, blockEnd *string) string {
+	if line == "" {
+		return "blank"
+	}
+	if *blockEnd == "" {
+		for _, block := range syntax.block {
+			if strings.HasPrefix(line, block[0]) {
+				*blockEnd, line = block[1], line[len(block[0]):]
+				break
+			}
+		}
+	}
+	if *blockEnd != "" {
+		end := strings.Index(line, *blockEnd)
+		if end < 0 {
+			return "comment"
+		}
+		rest := strings.TrimSpace(line[end+len(*blockEnd):])
+		*blockEnd = ""
+		if rest != "" && syntax.classify(rest, blockEnd) == "code" {
+			return "code"
+		}
+		return "comment"
+	}
+	for _, prefix := range syntax.line {
+		if strings.HasPrefix(line, prefix) {
+			return "comment"
+		}
+	}
+	return "code"
+}
@@ -0,0 +1,420 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrTrashNotFound is returned for trash entries that do not exist, have
+// been restored or purged
+var ErrTrashNotFound = errors.New("trash entry not found")
+
+// DefaultTrashRetention is how long deleted files are kept in the trash
+// unless configured otherwise
+const DefaultTrashRetention = 7 * 24 * time.Hour
+
+// trashSweepInterval is how often expired trash entries are purged
+const trashSweepInterval = time.Hour
+
+// TrashEntry is a deleted file or directory kept in a session's trash
+type TrashEntry struct {
+	ID         string    `json:"id"`
+	Path       string    `json:"path"`       // Where it was, relative to the working directory
+	WorkingDir string    `json:"workingDir"` // The working directory it was deleted from
+	IsDir      bool      `json:"isDir"`
+	Size       int64     `json:"size"` // Of its files, for directories
+	DeletedAt  time.Time `json:"deletedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"` // When it is purged
+}
+
+// category returns the activity category of what an entry holds
+func (entry *TrashEntry) category() string {
+	if entry.IsDir {
+		return ActivityDirectory
+	}
+	return ActivityFile
+}
+
+// trashBin keeps deleted files under a directory of this host, one
+// directory per session holding one per entry: its entry.json and the
+// deleted file or directory as item. Until it is enabled, deletions are
+// permanent.
+type trashBin struct {
+	dir       string
+	retention time.Duration
+	mutex     sync.Mutex
+}
+
+// trashDirPath returns where deleted files are kept, or "" when
+// OSAI_TRASH_DIR is "off"
+func trashDirPath() string {
+	dir := os.Getenv("OSAI_TRASH_DIR")
+	if dir == "off" {
+		return ""
+	}
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "osai", "trash")
+		if home, err := os.UserHomeDir(); err == nil {
+			dir = filepath.Join(home, ".osai", "trash")
+		}
+	}
+	return dir
+}
+
+// EnableTrash moves deleted files and directories to a trash in
+// OSAI_TRASH_DIR, ~/.osai/trash by default, where they can be restored
+// until retention has passed or their session is gone. Setting
+// OSAI_TRASH_DIR to "off" keeps deletions permanent.
+func (sm *SessionManager) EnableTrash(retention time.Duration) error {
+	dir := trashDirPath()
+	if dir == "" {
+		return nil
+	}
+	if retention <= 0 {
+		retention = DefaultTrashRetention
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	sm.trash.mutex.Lock()
+	sm.trash.dir = dir
+	sm.trash.retention = retention
+	sm.trash.mutex.Unlock()
+
+	go func() {
+		for {
+			sm.sweepTrash()
+			time.Sleep(trashSweepInterval)
+		}
+	}()
+	return nil
+}
+
+// settings returns the trash directory, "" when deletions are
+// permanent, and how long entries are kept
+func (tb *trashBin) settings() (string, time.Duration) {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+	return tb.dir, tb.retention
+}
+
+// sessionTrashDir returns the trash directory of a session, or "" when
+// deletions are permanent
+func (sm *SessionManager) sessionTrashDir(sessionID string) string {
+	dir, _ := sm.trash.settings()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, sessionID)
+}
+
+// moveToTrash moves a file or directory of a session into its trash, or
+// returns nil when deletions are permanent
+func (sm *SessionManager) moveToTrash(sessionID string, relativePath string, fullPath string) (*TrashEntry, error) {
+	sessionDir := sm.sessionTrashDir(sessionID)
+	if sessionDir == "" {
+		return nil, nil
+	}
+	_, retention := sm.trash.settings()
+
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	session, err := sm.PeekSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	entry := &TrashEntry{
+		ID:         uuid.New().String(),
+		Path:       filepath.ToSlash(filepath.Clean(relativePath)),
+		WorkingDir: session.WorkingDir,
+		IsDir:      info.IsDir(),
+		Size:       treeSize(fullPath, info),
+		DeletedAt:  now,
+		ExpiresAt:  now.Add(retention),
+	}
+	sm.trash.mutex.Lock()
+	defer sm.trash.mutex.Unlock()
+	entryDir := filepath.Join(sessionDir, entry.ID)
+	if err := os.MkdirAll(entryDir, 0700); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(entryDir, "entry.json"), data, 0600); err != nil {
+		os.RemoveAll(entryDir)
+		return nil, err
+	}
+	if err := moveTree(fullPath, filepath.Join(entryDir, "item")); err != nil {
+		os.RemoveAll(entryDir)
+		return nil, err
+	}
+	return entry, nil
+}
+
+// moveTree renames a file or directory, or copies and removes it when it
+// is on another file system than its destination
+func moveTree(source string, destination string) error {
+	err := os.Rename(source, destination)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if err := copyTree(source, destination); err != nil {
+		os.RemoveAll(destination)
+		return err
+	}
+	return os.RemoveAll(source)
+}
+
+// treeSize returns the size of a file, or of the files in a directory
+func treeSize(path string, info os.FileInfo) int64 {
+	if !info.IsDir() {
+		return info.Size()
+	}
+	var size int64
+	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// readTrashEntry returns an entry of a session's trash
+func readTrashEntry(sessionDir string, id string) (*TrashEntry, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrTrashNotFound
+	}
+	data, err := os.ReadFile(filepath.Join(sessionDir, id, "entry.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrTrashNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entry TrashEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("invalid trash entry %s: %v", id, err)
+	}
+	return &entry, nil
+}
+
+// readTrash returns the entries of a session's trash, newest first
+func readTrash(sessionDir string) ([]TrashEntry, error) {
+	dirs, err := os.ReadDir(sessionDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []TrashEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := []TrashEntry{}
+	for _, dir := range dirs {
+		if entry, err := readTrashEntry(sessionDir, dir.Name()); err == nil {
+			entries = append(entries, *entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].DeletedAt.After(entries[j].DeletedAt)
+	})
+	return entries, nil
+}
+
+// sweepTrash purges the entries that outlived the retention, and the trash
+// of sessions that no longer exist
+func (sm *SessionManager) sweepTrash() {
+	dir, _ := sm.trash.settings()
+	sessions, err := os.ReadDir(dir)
+	if err != nil {
+		slog.Warn("failed to read trash", "path", dir, "error", err)
+		return
+	}
+	sm.trash.mutex.Lock()
+	defer sm.trash.mutex.Unlock()
+	now := time.Now()
+	for _, session := range sessions {
+		sessionDir := filepath.Join(dir, session.Name())
+		if _, err := sm.PeekSession(session.Name()); errors.Is(err, ErrSessionNotFound) {
+			os.RemoveAll(sessionDir)
+			continue
+		}
+		entries, err := readTrash(sessionDir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if now.After(entry.ExpiresAt) {
+				os.RemoveAll(filepath.Join(sessionDir, entry.ID))
+			}
+		}
+	}
+}
+
+// TrashListing is the content of a session's trash
+type TrashListing struct {
+	Entries []TrashEntry `json:"entries"`
+	Count   int          `json:"count"`
+	Size    int64        `json:"size"`
+	Enabled bool         `json:"enabled"` // False when deletions are permanent
+}
+
+// TrashService lists, restores and purges the files deleted in sessions
+type TrashService struct {
+	sessionManager *SessionManager
+}
+
+func NewTrashService(sm *SessionManager) *TrashService {
+	return &TrashService{
+		sessionManager: sm,
+	}
+}
+
+// List returns the entries of a session's trash, newest first
+func (ts *TrashService) List(sessionID string) (*TrashListing, error) {
+	if _, err := ts.sessionManager.GetSession(sessionID); err != nil {
+		return nil, err
+	}
+	sessionDir := ts.sessionManager.sessionTrashDir(sessionID)
+	if sessionDir == "" {
+		return &TrashListing{Entries: []TrashEntry{}}, nil
+	}
+
+	ts.sessionManager.trash.mutex.Lock()
+	entries, err := readTrash(sessionDir)
+	ts.sessionManager.trash.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	listing := &TrashListing{Entries: entries, Count: len(entries), Enabled: true}
+	for _, entry := range entries {
+		listing.Size += entry.Size
+	}
+	return listing, nil
+}
+
+// Restore moves a trash entry back to where it was, or to path when set,
+// relative to the session's current working directory. An existing file
+// there is not replaced.
+func (ts *TrashService) Restore(sessionID string, id string, path string) (entry *TrashEntry, err error) {
+	defer func() {
+		ts.sessionManager.Audit(sessionID, "trash.restore", id, path, err)
+	}()
+
+	sessionDir := ts.sessionManager.sessionTrashDir(sessionID)
+	if sessionDir == "" {
+		return nil, ErrTrashNotFound
+	}
+	ts.sessionManager.trash.mutex.Lock()
+	defer ts.sessionManager.trash.mutex.Unlock()
+
+	entry, err = readTrashEntry(sessionDir, id)
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		path = entry.Path
+	}
+	fullPath, err := ts.sessionManager.ResolvePath(sessionID, path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Lstat(fullPath); err == nil {
+		return nil, fmt.Errorf("%s: %w", path, os.ErrExist)
+	}
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return nil, err
+	}
+	if err := moveTree(filepath.Join(sessionDir, id, "item"), fullPath); err != nil {
+		return nil, err
+	}
+	os.RemoveAll(filepath.Join(sessionDir, id))
+
+	ts.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: entry.category(),
+		Target:   path,
+		Message:  "Restored " + path + " from the trash",
+	})
+	entry.Path = path
+	return entry, nil
+}
+
+// Purge permanently deletes a trash entry
+func (ts *TrashService) Purge(sessionID string, id string) (err error) {
+	defer func() {
+		ts.sessionManager.Audit(sessionID, "trash.purge", id, "", err)
+	}()
+
+	if _, err := ts.sessionManager.GetSession(sessionID); err != nil {
+		return err
+	}
+	sessionDir := ts.sessionManager.sessionTrashDir(sessionID)
+	if sessionDir == "" {
+		return ErrTrashNotFound
+	}
+	ts.sessionManager.trash.mutex.Lock()
+	defer ts.sessionManager.trash.mutex.Unlock()
+
+	entry, err := readTrashEntry(sessionDir, id)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(sessionDir, id)); err != nil {
+		return err
+	}
+
+	ts.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: entry.category(),
+		Target:   entry.Path,
+		Message:  "Purged " + entry.Path + " from the trash",
+	})
+	return nil
+}
+
+// Empty permanently deletes every entry of a session's trash, returning
+// how many there were
+func (ts *TrashService) Empty(sessionID string) (purged int, err error) {
+	defer func() {
+		ts.sessionManager.Audit(sessionID, "trash.empty", "", fmt.Sprintf("%d entries", purged), err)
+	}()
+
+	if _, err := ts.sessionManager.GetSession(sessionID); err != nil {
+		return 0, err
+	}
+	sessionDir := ts.sessionManager.sessionTrashDir(sessionID)
+	if sessionDir == "" {
+		return 0, nil
+	}
+	ts.sessionManager.trash.mutex.Lock()
+	defer ts.sessionManager.trash.mutex.Unlock()
+
+	entries, err := readTrash(sessionDir)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.RemoveAll(sessionDir); err != nil {
+		return 0, err
+	}
+
+	ts.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivitySession,
+		Message:  fmt.Sprintf("Emptied the trash of %d entries", len(entries)),
+	})
+	return len(entries), nil
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// Lines of unchanged context around each change in a unified diff
+const diffContext = 3
+
+// diffLine is a line of a unified diff: kept, removed or added
+type diffLine struct {
+	kind byte // ' ', '-' or '+'
+	text string
+}
+
+// UnifiedDiff returns the changes from original to modified as a unified
+// diff of their lines, as diff -u and git print it, naming both files
+// path. It is empty when they are the same.
+func UnifiedDiff(path string, original string, modified string) string {
+	if original == modified {
+		return ""
+	}
+	lines := lineDiff(original, modified)
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- a/%s\n+++ b/%s\n", path, path)
+	// Line numbers in the original and modified before each line
+	oldLine, newLine := make([]int, len(lines)+1), make([]int, len(lines)+1)
+	for i, line := range lines {
+		oldLine[i+1], newLine[i+1] = oldLine[i], newLine[i]
+		if line.kind != '+' {
+			oldLine[i+1]++
+		}
+		if line.kind != '-' {
+			newLine[i+1]++
+		}
+	}
+
+	for i := 0; i < len(lines); {
+		if lines[i].kind == ' ' {
+			i++
+			continue
+		}
+		// A hunk runs from the context before this change to the context
+		// after the last change within reach of it
+		start := max(i-diffContext, 0)
+		end := i
+		for j := i; j < len(lines) && j <= end+2*diffContext+1; j++ {
+			if lines[j].kind != ' ' {
+				end = j
+			}
+		}
+		end = min(end+diffContext+1, len(lines))
+
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n",
+			hunkRange(oldLine[start], oldLine[end]-oldLine[start]),
+			hunkRange(newLine[start], newLine[end]-newLine[start]))
+		for _, line := range lines[start:end] {
+			out.WriteByte(line.kind)
+			out.WriteString(line.text)
+			if !strings.HasSuffix(line.text, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return out.String()
+}
+
+// lineDiff returns the lines of original and modified in the order of a
+// diff of them, each kept, removed or added, with their newlines
+func lineDiff(original string, modified string) []diffLine {
+	// Diff the lines as runes, one for each distinct line
+	var texts []string
+	indexes := make(map[string]rune)
+	toRunes := func(content string) []rune {
+		var runes []rune
+		for _, line := range strings.SplitAfter(content, "\n") {
+			if line == "" {
+				continue
+			}
+			r, ok := indexes[line]
+			if !ok {
+				// Skipping the surrogates, which are not valid runes
+				r = rune(len(texts))
+				if r >= 0xD800 {
+					r += 0x800
+				}
+				indexes[line] = r
+				texts = append(texts, line)
+			}
+			runes = append(runes, r)
+		}
+		return runes
+	}
+	a, b := toRunes(original), toRunes(modified)
+	diffs := diffmatchpatch.New().DiffMainRunes(a, b, false)
+
+	var lines []diffLine
+	for _, diff := range diffs {
+		kind := byte(' ')
+		switch diff.Type {
+		case diffmatchpatch.DiffDelete:
+			kind = '-'
+		case diffmatchpatch.DiffInsert:
+			kind = '+'
+		}
+		for _, r := range diff.Text {
+			if r >= 0xE000 {
+				r -= 0x800
+			}
+			lines = append(lines, diffLine{kind, texts[r]})
+		}
+	}
+	return lines
+}
+
+// diffStats counts the lines modified adds to original and removes from it
+func diffStats(original string, modified string) (added int, removed int) {
+	for _, line := range lineDiff(original, modified) {
+		switch line.kind {
+		case '+':
+			added++
+		case '-':
+			removed++
+		}
+	}
+	return added, removed
+}
+
+// hunkRange formats the start and length of a hunk in one file, where
+// before is the number of lines before it
+func hunkRange(before int, length int) string {
+	if length == 0 {
+		return fmt.Sprintf("%d,0", before)
+	}
+	if length == 1 {
+		return fmt.Sprint(before + 1)
+	}
+	return fmt.Sprintf("%d,%d", before+1, length)
+}
@@ -0,0 +1,487 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrVersionNotFound is returned for file versions that were never kept or
+// have been dropped
+var ErrVersionNotFound = errors.New("file version not found")
+
+// DefaultMaxFileVersions is how many versions are kept of each file unless
+// configured otherwise; the oldest are dropped first
+const DefaultMaxFileVersions = 20
+
+// DefaultMaxVersionsSize is how many bytes the versions of each file may
+// take unless configured otherwise. Larger files are not versioned.
+const DefaultMaxVersionsSize = 64 << 20
+
+// versionSweepInterval is how often the versions of deleted sessions are
+// removed
+const versionSweepInterval = time.Hour
+
+// FileVersion is content a file had
+type FileVersion struct {
+	Version   int       `json:"version"` // Numbered from 1 for each file
+	SHA256    string    `json:"sha256"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+	// The write that left this content, such as update, patch, edit,
+	// replace or restore, or backup for content found in place before a write
+	Operation string `json:"operation"`
+}
+
+// versionIndex lists the versions kept of one file, oldest first
+type versionIndex struct {
+	Path     string        `json:"path"` // Absolute path on this host
+	Next     int           `json:"next"`
+	Versions []FileVersion `json:"versions"`
+}
+
+// versionSettings are how a version store keeps versions
+type versionSettings struct {
+	dir         string // Empty when files are overwritten without backups
+	history     bool   // Record the content of every write, not only backups
+	maxVersions int
+	maxSize     int64
+}
+
+// versionStore keeps file versions under a directory of this host, one
+// directory per session: the contents in objects, named by their SHA-256
+// so identical contents are kept once, and an index per file in paths.
+// Until it is enabled, files are overwritten without backups.
+type versionStore struct {
+	versionSettings
+	mutex sync.Mutex
+}
+
+// versionsDirPath returns where file versions are kept, or "" when
+// OSAI_VERSIONS_DIR is "off"
+func versionsDirPath() string {
+	dir := os.Getenv("OSAI_VERSIONS_DIR")
+	if dir == "off" {
+		return ""
+	}
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "osai", "versions")
+		if home, err := os.UserHomeDir(); err == nil {
+			dir = filepath.Join(home, ".osai", "versions")
+		}
+	}
+	return dir
+}
+
+// EnableVersions keeps file versions in OSAI_VERSIONS_DIR,
+// ~/.osai/versions by default, until their session is gone: with history,
+// the content of every write and what it replaced, and otherwise only the
+// backups writes ask for. Each file keeps at most maxVersions versions of
+// maxSize bytes in all. Setting OSAI_VERSIONS_DIR to "off" disables both.
+func (sm *SessionManager) EnableVersions(history bool, maxVersions int, maxSize int64) error {
+	dir := versionsDirPath()
+	if dir == "" {
+		return nil
+	}
+	if maxVersions <= 0 {
+		maxVersions = DefaultMaxFileVersions
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxVersionsSize
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	sm.versions.mutex.Lock()
+	sm.versions.versionSettings = versionSettings{
+		dir:         dir,
+		history:     history,
+		maxVersions: maxVersions,
+		maxSize:     maxSize,
+	}
+	sm.versions.mutex.Unlock()
+
+	go func() {
+		for {
+			sm.sweepVersions()
+			time.Sleep(versionSweepInterval)
+		}
+	}()
+	return nil
+}
+
+// settings returns how the store keeps versions
+func (vs *versionStore) settings() versionSettings {
+	vs.mutex.Lock()
+	defer vs.mutex.Unlock()
+	return vs.versionSettings
+}
+
+// sessionVersionsDir returns the versions directory of a session, or ""
+// when files are overwritten without backups
+func (sm *SessionManager) sessionVersionsDir(sessionID string) string {
+	dir := sm.versions.settings().dir
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, sessionID)
+}
+
+// backupsRequested reports whether a write should back up the file it
+// overwrites: when the request asks for it, the session always does, or
+// every write is recorded
+func (sm *SessionManager) backupsRequested(sessionID string, requested bool) bool {
+	if requested || sm.versions.settings().history {
+		return true
+	}
+	session, err := sm.PeekSession(sessionID)
+	return err == nil && session.Backups
+}
+
+// beforeWrite keeps the content a write is about to replace when backups
+// are requested, returning the version holding it, or nil when there is
+// none
+func (sm *SessionManager) beforeWrite(sessionID string, fullPath string, backup bool) (*FileVersion, error) {
+	if !sm.backupsRequested(sessionID, backup) {
+		return nil, nil
+	}
+	return sm.keepVersion(sessionID, fullPath, "backup")
+}
+
+// afterWrite records the content a write left when every write is
+// recorded. The write has already happened, so failures are only logged.
+func (sm *SessionManager) afterWrite(sessionID string, fullPath string, operation string) {
+	if !sm.versions.settings().history {
+		return
+	}
+	if _, err := sm.keepVersion(sessionID, fullPath, operation); err != nil {
+		sessionLog(sessionID).Warn("failed to record file version", "path", fullPath, "error", err)
+	}
+}
+
+// versionIndexPath returns where the version index of a file is kept
+func versionIndexPath(sessionDir string, fullPath string) string {
+	sum := sha256.Sum256([]byte(fullPath))
+	return filepath.Join(sessionDir, "paths", hex.EncodeToString(sum[:])+".json")
+}
+
+// readVersionIndex returns the version index of a file, empty when none
+// of its versions are kept
+func readVersionIndex(sessionDir string, fullPath string) (*versionIndex, error) {
+	data, err := os.ReadFile(versionIndexPath(sessionDir, fullPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return &versionIndex{Path: fullPath, Next: 1, Versions: []FileVersion{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var index versionIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("invalid version index of %s: %v", fullPath, err)
+	}
+	return &index, nil
+}
+
+// writeVersionIndex saves the version index of a file
+func writeVersionIndex(sessionDir string, index *versionIndex) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	path := versionIndexPath(sessionDir, index.Path)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+// keepVersion keeps the current content of a file as a new version,
+// returning nil when versions are disabled, there is no file, or it is too
+// large to keep. Content equal to the newest version is not kept twice;
+// that version is returned instead. The oldest versions are dropped once
+// the file has too many, or they take too much space.
+func (sm *SessionManager) keepVersion(sessionID string, fullPath string, operation string) (*FileVersion, error) {
+	settings := sm.versions.settings()
+	if settings.dir == "" {
+		return nil, nil
+	}
+	sessionDir := filepath.Join(settings.dir, sessionID)
+	info, err := os.Stat(fullPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() || info.Size() > settings.maxSize {
+		return nil, nil
+	}
+	content, err := os.ReadFile(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+
+	sm.versions.mutex.Lock()
+	defer sm.versions.mutex.Unlock()
+	index, err := readVersionIndex(sessionDir, fullPath)
+	if err != nil {
+		return nil, err
+	}
+	if n := len(index.Versions); n > 0 && index.Versions[n-1].SHA256 == hash {
+		return &index.Versions[n-1], nil
+	}
+
+	objectPath := filepath.Join(sessionDir, "objects", hash)
+	if _, err := os.Stat(objectPath); err != nil {
+		if err := os.MkdirAll(filepath.Dir(objectPath), 0700); err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomic(objectPath, content, 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	version := FileVersion{
+		Version:   index.Next,
+		SHA256:    hash,
+		Size:      int64(len(content)),
+		CreatedAt: time.Now().UTC(),
+		Operation: operation,
+	}
+	index.Next++
+	index.Versions = append(index.Versions, version)
+	var total int64
+	for _, kept := range index.Versions {
+		total += kept.Size
+	}
+	dropped := 0
+	for len(index.Versions)-dropped > 1 &&
+		(len(index.Versions)-dropped > settings.maxVersions || total > settings.maxSize) {
+		total -= index.Versions[dropped].Size
+		dropped++
+	}
+	index.Versions = append([]FileVersion(nil), index.Versions[dropped:]...)
+	if err := writeVersionIndex(sessionDir, index); err != nil {
+		return nil, err
+	}
+	if dropped > 0 {
+		removeUnusedObjects(sessionDir)
+	}
+	return &version, nil
+}
+
+// removeUnusedObjects deletes the contents no version or directory snapshot
+// of a session refers to any more. Callers must hold the store's mutex.
+func removeUnusedObjects(sessionDir string) {
+	indexes, err := os.ReadDir(filepath.Join(sessionDir, "paths"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	used := make(map[string]bool)
+	if !snapshotObjects(sessionDir, used) {
+		return
+	}
+	for _, entry := range indexes {
+		data, err := os.ReadFile(filepath.Join(sessionDir, "paths", entry.Name()))
+		if err != nil {
+			// Keep everything rather than lose a version still listed
+			return
+		}
+		var index versionIndex
+		if json.Unmarshal(data, &index) != nil {
+			return
+		}
+		for _, version := range index.Versions {
+			used[version.SHA256] = true
+		}
+	}
+
+	objects, err := os.ReadDir(filepath.Join(sessionDir, "objects"))
+	if err != nil {
+		return
+	}
+	for _, object := range objects {
+		if !used[object.Name()] {
+			os.Remove(filepath.Join(sessionDir, "objects", object.Name()))
+		}
+	}
+}
+
+// sweepVersions removes the versions of sessions that no longer exist
+func (sm *SessionManager) sweepVersions() {
+	sm.versions.mutex.Lock()
+	defer sm.versions.mutex.Unlock()
+	sessions, err := os.ReadDir(sm.versions.dir)
+	if err != nil {
+		slog.Warn("failed to read file versions", "path", sm.versions.dir, "error", err)
+		return
+	}
+	for _, session := range sessions {
+		if _, err := sm.PeekSession(session.Name()); errors.Is(err, ErrSessionNotFound) {
+			os.RemoveAll(filepath.Join(sm.versions.dir, session.Name()))
+		}
+	}
+}
+
+// VersionListing is the versions kept of a file, newest first
+type VersionListing struct {
+	Path     string        `json:"path"`
+	Versions []FileVersion `json:"versions"`
+	Count    int           `json:"count"`
+	Size     int64         `json:"size"`    // Of all its versions
+	Enabled  bool          `json:"enabled"` // False when files are overwritten without backups
+	History  bool          `json:"history"` // Whether every write is recorded
+}
+
+// VersionDiff is the changes between two versions of a file
+type VersionDiff struct {
+	Path    string `json:"path"`
+	From    int    `json:"from"`
+	To      int    `json:"to"` // 0 for the file as it is now
+	Changed bool   `json:"changed"`
+	Diff    string `json:"diff"` // Unified diff, empty when unchanged
+}
+
+// VersionService lists, compares and restores the versions kept of files
+type VersionService struct {
+	sessionManager *SessionManager
+}
+
+func NewVersionService(sm *SessionManager) *VersionService {
+	return &VersionService{
+		sessionManager: sm,
+	}
+}
+
+// List returns the versions kept of a file, newest first
+func (vs *VersionService) List(sessionID string, relativePath string) (*VersionListing, error) {
+	fullPath, err := vs.sessionManager.ResolvePath(sessionID, relativePath)
+	if err != nil {
+		return nil, err
+	}
+	listing := &VersionListing{Path: relativePath, Versions: []FileVersion{}}
+	settings := vs.sessionManager.versions.settings()
+	if settings.dir == "" {
+		return listing, nil
+	}
+
+	vs.sessionManager.versions.mutex.Lock()
+	index, err := readVersionIndex(filepath.Join(settings.dir, sessionID), fullPath)
+	vs.sessionManager.versions.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(index.Versions) - 1; i >= 0; i-- {
+		listing.Versions = append(listing.Versions, index.Versions[i])
+		listing.Size += index.Versions[i].Size
+	}
+	listing.Count = len(listing.Versions)
+	listing.Enabled = true
+	listing.History = settings.history
+	return listing, nil
+}
+
+// readVersion returns a kept version of the file at fullPath and its
+// content
+func (vs *VersionService) readVersion(sessionID string, fullPath string, relativePath string, number int) (*FileVersion, []byte, error) {
+	sessionDir := vs.sessionManager.sessionVersionsDir(sessionID)
+	if sessionDir == "" {
+		return nil, nil, fmt.Errorf("%w: version %d of %s", ErrVersionNotFound, number, relativePath)
+	}
+
+	vs.sessionManager.versions.mutex.Lock()
+	defer vs.sessionManager.versions.mutex.Unlock()
+	index, err := readVersionIndex(sessionDir, fullPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range index.Versions {
+		if index.Versions[i].Version == number {
+			content, err := os.ReadFile(filepath.Join(sessionDir, "objects", index.Versions[i].SHA256))
+			if err != nil {
+				return nil, nil, err
+			}
+			return &index.Versions[i], content, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("%w: version %d of %s", ErrVersionNotFound, number, relativePath)
+}
+
+// Diff compares two versions of a file, or a version with the file as it
+// is now when to is 0
+func (vs *VersionService) Diff(sessionID string, relativePath string, from int, to int) (*VersionDiff, error) {
+	fullPath, err := vs.sessionManager.ResolvePath(sessionID, relativePath)
+	if err != nil {
+		return nil, err
+	}
+	_, original, err := vs.readVersion(sessionID, fullPath, relativePath, from)
+	if err != nil {
+		return nil, err
+	}
+	var modified []byte
+	if to == 0 {
+		modified, err = os.ReadFile(fullPath)
+	} else {
+		_, modified, err = vs.readVersion(sessionID, fullPath, relativePath, to)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	diff := UnifiedDiff(relativePath, string(original), string(modified))
+	vs.sessionManager.Audit(sessionID, "file.versions.diff", relativePath, fmt.Sprintf("version %d against %d", from, to), nil)
+	return &VersionDiff{
+		Path:    relativePath,
+		From:    from,
+		To:      to,
+		Changed: !bytes.Equal(original, modified),
+		Diff:    diff,
+	}, nil
+}
+
+// Restore rolls a file back to a kept version, first keeping the content
+// it replaces as a version of its own. It returns the restored version and
+// the one holding the replaced content, nil when the file was missing.
+func (vs *VersionService) Restore(sessionID string, relativePath string, number int) (restored *FileVersion, backup *FileVersion, err error) {
+	defer func() {
+		vs.sessionManager.Audit(sessionID, "file.restore", relativePath, fmt.Sprintf("version %d", number), err)
+	}()
+
+	fullPath, err := vs.sessionManager.ResolvePath(sessionID, relativePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	restored, content, err := vs.readVersion(sessionID, fullPath, relativePath, number)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if backup, err = vs.sessionManager.beforeWrite(sessionID, fullPath, true); err != nil {
+		return nil, nil, fmt.Errorf("failed to back up %s: %w", relativePath, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return nil, nil, err
+	}
+	if err := writeFileAtomic(fullPath, content, 0644); err != nil {
+		return nil, nil, err
+	}
+	vs.sessionManager.afterWrite(sessionID, fullPath, "restore")
+
+	vs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityFile,
+		Target:   relativePath,
+		Message:  fmt.Sprintf("Restored %s to version %d", relativePath, number),
+	})
+	return restored, backup, nil
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileEvent reports a change to a watched file
+type FileEvent struct {
+	Type     string        `json:"type"` // created, modified or deleted
+	Path     string        `json:"path"`
+	Metadata *FileMetadata `json:"metadata,omitempty"` // Unset for deleted files
+	Time     time.Time     `json:"time"`
+}
+
+// DefaultWatchInterval is how often watched files are checked unless the
+// caller asks otherwise, and MinWatchInterval the most often they can be
+const (
+	DefaultWatchInterval = time.Second
+	MinWatchInterval     = 100 * time.Millisecond
+)
+
+// WatchFile polls a file, or the entries of a directory, and calls send for
+// every change until ctx is done. A file that is created, modified or
+// deleted is reported once per check. The watch keeps the session alive and
+// ends with an error if the session goes away or send fails.
+func (fs *FileService) WatchFile(ctx context.Context, sessionID string, relativePath string, interval time.Duration, send func(FileEvent) error) error {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	if interval < MinWatchInterval {
+		interval = MinWatchInterval
+	}
+
+	previous, err := fs.watchSnapshot(sessionID, relativePath)
+	if err != nil {
+		return err
+	}
+	fs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityFile,
+		Target:   relativePath,
+		Message:  "Started watching " + relativePath,
+	})
+	fs.sessionManager.Audit(sessionID, "file.watch", relativePath, "", nil)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			current, err := fs.watchSnapshot(sessionID, relativePath)
+			if err != nil {
+				return err
+			}
+			for path, meta := range current {
+				old, existed := previous[path]
+				switch {
+				case !existed:
+					err = send(FileEvent{Type: "created", Path: path, Metadata: meta, Time: now})
+				case !old.ModTime.Equal(meta.ModTime) || old.Size != meta.Size || old.Permissions != meta.Permissions:
+					err = send(FileEvent{Type: "modified", Path: path, Metadata: meta, Time: now})
+				}
+				if err != nil {
+					return err
+				}
+			}
+			for path := range previous {
+				if _, exists := current[path]; !exists {
+					if err := send(FileEvent{Type: "deleted", Path: path, Time: now}); err != nil {
+						return err
+					}
+				}
+			}
+			previous = current
+		}
+	}
+}
+
+// watchSnapshot returns the metadata of a file, or of the entries of a
+// directory, by path; a missing file gives an empty snapshot
+func (fs *FileService) watchSnapshot(sessionID string, relativePath string) (map[string]*FileMetadata, error) {
+	fullPath, err := fs.GetFilePath(sessionID, relativePath)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := make(map[string]*FileMetadata)
+	info, err := os.Stat(fullPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return snapshot, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		snapshot[relativePath] = fs.metadata(info, relativePath)
+		return snapshot, nil
+	}
+
+	entries, err := os.ReadDir(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			// Deleted since the directory was read
+			continue
+		}
+		path := filepath.Join(relativePath, entry.Name())
+		snapshot[path] = fs.metadata(info, path)
+	}
+	return snapshot, nil
+}
+
+// metadata describes a file found at path
+func (fs *FileService) metadata(info os.FileInfo, path string) *FileMetadata {
+	meta := &FileMetadata{
+		Name:        info.Name(),
+		Path:        path,
+		Size:        info.Size(),
+		ModTime:     info.ModTime(),
+		IsDir:       info.IsDir(),
+		Permissions: fs.formatPermissions(info.Mode()),
+	}
+	if ext := filepath.Ext(info.Name()); ext != "" && !info.IsDir() {
+		meta.ContentType = fs.getContentTypeByExt(ext)
+	}
+	return meta
+}
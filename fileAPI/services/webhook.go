@@ -0,0 +1,111 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// SignatureHeader carries the HMAC-SHA256 of a payload, as "sha256=<hex>",
+// when webhooks are signed
+const SignatureHeader = "X-OSAI-Signature"
+
+// SessionWebhooks POSTs session lifecycle events to the URLs an
+// orchestrator configured, retrying failed deliveries with exponential
+// backoff
+type SessionWebhooks struct {
+	urls           []string
+	secret         string
+	client         *http.Client
+	maxAttempts    int
+	initialBackoff time.Duration
+}
+
+// NewSessionWebhooks posts events to urls, signed with secret if set
+func NewSessionWebhooks(urls []string, secret string) (*SessionWebhooks, error) {
+	for _, callbackURL := range urls {
+		parsed, err := url.Parse(callbackURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid callback URL: %s", callbackURL)
+		}
+	}
+	return &SessionWebhooks{
+		urls:           urls,
+		secret:         secret,
+		client:         &http.Client{Timeout: 10 * time.Second},
+		maxAttempts:    5,
+		initialBackoff: time.Second,
+	}, nil
+}
+
+// Send delivers an event to every URL in the background
+func (w *SessionWebhooks) Send(event SessionEvent) {
+	for _, callbackURL := range w.urls {
+		go func(callbackURL string) {
+			if err := w.deliver(callbackURL, event); err != nil {
+				slog.Warn("webhook delivery failed", "url", callbackURL, "error", err)
+			}
+		}(callbackURL)
+	}
+}
+
+// deliver POSTs the payload until it is accepted, the receiver rejects it
+// outright or the attempts run out
+func (w *SessionWebhooks) deliver(callbackURL string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	backoff := w.initialBackoff
+	var lastErr error
+	for attempt := 1; attempt <= w.maxAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		req, err := http.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if w.secret != "" {
+			mac := hmac.New(sha256.New, []byte(w.secret))
+			mac.Write(body)
+			req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		}
+
+		resp, err := w.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			slog.Debug("webhook delivered", "url", callbackURL, "attempt", attempt)
+			return nil
+		}
+		lastErr = fmt.Errorf("receiver responded with status %d", resp.StatusCode)
+
+		// Client errors other than timeouts and throttling will not succeed on retry
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+			resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+			return lastErr
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("no delivery attempts made")
+	}
+	return fmt.Errorf("giving up after %d attempts: %w", w.maxAttempts, lastErr)
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"terminalAPI/api/handlers"
+	"terminalAPI/services"
+)
+
+// apiKeyContextKey is where the authenticated key is stored in the request
+// context
+const apiKeyContextKey = "apiKey"
+
+// publicRoutes are served without an API key; they describe the API and
+// expose nothing else
+var publicRoutes = map[string]bool{
+	"GET /openapi.json": true,
+	"GET /docs":         true,
+	"GET /tools/schema": true,
+	"GET /version":      true,
+}
+
+// APIKey is a static API key and the roles and scopes it grants
+type APIKey struct {
+	Name   string
+	Grants []string // Role names, such as operator, or scopes
+	hash   [sha256.Size]byte
+}
+
+// LoadAPIKeys parses OSAI_API_KEYS, a comma-separated list of
+// name:key[:grant+grant] entries shared with fileAPI, where each grant is a
+// role of the policy or a scope. A key without grants is an admin. No keys
+// means authentication is disabled.
+func LoadAPIKeys(policy *services.PolicyService) ([]*APIKey, error) {
+	value := strings.TrimSpace(os.Getenv("OSAI_API_KEYS"))
+	if value == "" {
+		return nil, nil
+	}
+
+	var keys []*APIKey
+	names := make(map[string]bool)
+	for i, entry := range strings.Split(value, ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			// Refer to the entry by position so the key is not logged
+			return nil, fmt.Errorf("invalid API key entry %d, expected name:key[:roles]", i+1)
+		}
+		if names[parts[0]] {
+			return nil, fmt.Errorf("duplicate API key name: %s", parts[0])
+		}
+		names[parts[0]] = true
+
+		key := &APIKey{
+			Name:   parts[0],
+			Grants: []string{services.RoleAdmin},
+			hash:   sha256.Sum256([]byte(parts[1])),
+		}
+		if len(parts) == 3 {
+			key.Grants = strings.Split(parts[2], "+")
+			if err := policy.ValidateGrants(key.Grants); err != nil {
+				return nil, fmt.Errorf("API key %s: %w", key.Name, err)
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// MatchAPIKey returns the key with the given value, or nil if there is none
+func MatchAPIKey(keys []*APIKey, value string) *APIKey {
+	hash := sha256.Sum256([]byte(value))
+	for _, key := range keys {
+		// Compare hashes in constant time so timing reveals nothing
+		if subtle.ConstantTimeCompare(hash[:], key.hash[:]) == 1 {
+			return key
+		}
+	}
+	return nil
+}
+
+// KeyAuth authenticates requests with a key in the Authorization header as
+// a bearer token or in the X-API-Key header, and checks that the key grants
+// the scope the policy requires for the route
+func KeyAuth(keys []*APIKey, policy *services.PolicyService) echo.MiddlewareFunc {
+	authenticate := middleware.KeyAuthWithConfig(middleware.KeyAuthConfig{
+		KeyLookup: "header:" + echo.HeaderAuthorization + ":Bearer ,header:X-API-Key",
+		Validator: func(value string, c echo.Context) (bool, error) {
+			key := MatchAPIKey(keys, value)
+			if key == nil {
+				return false, nil
+			}
+			c.Set(apiKeyContextKey, key)
+			handlers.SetPrincipal(c, &handlers.Principal{
+				Name:  key.Name,
+				Admin: policy.HasScope(key.Grants, services.ScopeAdmin),
+			})
+			return true, nil
+		},
+		ErrorHandler: func(err error, c echo.Context) error {
+			message := "Invalid API key"
+			var missing *middleware.ErrKeyAuthMissing
+			if errors.As(err, &missing) {
+				message = "API key required"
+			}
+			return handlers.ErrorJSON(c, http.StatusUnauthorized, handlers.CodeUnauthorized, message)
+		},
+	})
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		authenticated := authenticate(func(c echo.Context) error {
+			key := APIKeyFromContext(c)
+			scope := policy.RequiredScope(c.Request().Method, c.Path(), services.ScopeExecute)
+			if !policy.HasScope(key.Grants, scope) {
+				return handlers.ErrorJSON(c, http.StatusForbidden, handlers.CodeForbidden,
+					fmt.Sprintf("API key %s lacks the %s scope", key.Name, scope))
+			}
+			return next(c)
+		})
+		return func(c echo.Context) error {
+			if publicRoutes[c.Request().Method+" "+c.Path()] {
+				return next(c)
+			}
+			return authenticated(c)
+		}
+	}
+}
+
+// SessionOwnership restricts sessions to the API key that created them;
+// admin keys can use every session. Sessions of other keys are reported as
+// not found so their IDs cannot be probed.
+func SessionOwnership(sm *services.SessionManager) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			principal := handlers.PrincipalFromContext(c)
+			if principal == nil || principal.Admin {
+				return next(c)
+			}
+
+			sessionID := requestSessionID(c)
+			if sessionID == "" {
+				return next(c)
+			}
+			if owner, exists := sm.SessionOwner(sessionID); exists && owner != principal.Name {
+				return handlers.ErrorJSON(c, http.StatusNotFound, handlers.CodeSessionNotFound, services.ErrSessionNotFound.Error())
+			}
+			return next(c)
+		}
+	}
+}
+
+// APIKeyFromContext returns the key a request was authenticated with, or
+// nil when authentication is disabled
+func APIKeyFromContext(c echo.Context) *APIKey {
+	key, _ := c.Get(apiKeyContextKey).(*APIKey)
+	return key
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"terminalAPI/services"
+)
+
+type AnalysisHandler struct {
+	analysisService *services.AnalysisService
+}
+
+func NewAnalysisHandler(as *services.AnalysisService) *AnalysisHandler {
+	return &AnalysisHandler{
+		analysisService: as,
+	}
+}
+
+func (h *AnalysisHandler) AnalyzeCommand(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	var req services.AnalysisRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	analysis, err := h.analysisService.AnalyzeCommand(sessionID, &req)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	return c.JSON(http.StatusOK, analysis)
+}
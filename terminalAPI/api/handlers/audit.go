@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"terminalAPI/services"
+)
+
+// maxAuditEntries caps how many entries one query returns
+const maxAuditEntries = 1000
+
+type AuditHandler struct {
+	auditService *services.AuditService
+}
+
+func NewAuditHandler(as *services.AuditService) *AuditHandler {
+	return &AuditHandler{
+		auditService: as,
+	}
+}
+
+// QueryAudit returns audit entries, newest first, filtered by the session,
+// op, from and to query parameters
+func (h *AuditHandler) QueryAudit(c echo.Context) error {
+	from, err := queryTime(c, "from")
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	to, err := queryTime(c, "to")
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	limit, err := queryInt(c, "limit", 100)
+	if err != nil || limit <= 0 || limit > maxAuditEntries {
+		return errorMessage(c, http.StatusBadRequest, "Invalid limit parameter, expected 1 to 1000")
+	}
+	
+	entries, err := h.auditService.Query(services.AuditFilter{
+		SessionID: c.QueryParam("session"),
+		Operation: c.QueryParam("op"),
+		From:      from,
+		To:        to,
+		Limit:     limit,
+	})
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"entries": entries,
+		"count":   len(entries),
+	})
+}
+
+// StreamEvents streams audited operations across all sessions as
+// Server-Sent Events, filtered by the session, op and result query
+// parameters. A client reconnecting with Last-Event-ID first receives the
+// recent events it missed.
+func (h *AuditHandler) StreamEvents(c echo.Context) error {
+	filter := services.EventFilter{
+		SessionID:  c.QueryParam("session"),
+		Operations: c.QueryParams()["op"],
+	}
+	switch c.QueryParam("result") {
+	case "":
+	case services.AuditFailure:
+		filter.FailuresOnly = true
+	default:
+		return errorMessage(c, http.StatusBadRequest, "Invalid result parameter, expected failure")
+	}
+	
+	var lastEventID uint64
+	if value := c.Request().Header.Get("Last-Event-ID"); value != "" {
+		var err error
+		if lastEventID, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return errorMessage(c, http.StatusBadRequest, "Invalid Last-Event-ID header")
+		}
+	}
+	
+	sub, replay := h.auditService.Subscribe(filter, lastEventID)
+	defer sub.Close()
+	
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.WriteHeader(http.StatusOK)
+	
+	for _, event := range replay {
+		writeEvent(res, event)
+	}
+	res.Flush()
+	
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+	
+	for {
+		select {
+		case event, ok := <-sub.Events:
+			if !ok {
+				return nil
+			}
+			writeEvent(res, event)
+			res.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(res, ": keepalive\n\n")
+			res.Flush()
+		case <-c.Request().Context().Done():
+			return nil
+		}
+	}
+}
+
+// writeEvent writes an event in text/event-stream format, named after its
+// operation
+func writeEvent(res *echo.Response, event services.Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Operation, data)
+}
@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"terminalAPI/services"
@@ -22,16 +24,12 @@ func (h *CommandHandler) ExecuteCommand(c echo.Context) error {
 	
 	var req services.CommandRequest
 	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid request body",
-		})
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
 	}
 	
 	output, err := h.commandService.ExecuteCommand(sessionID, &req)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusInternalServerError, err)
 	}
 	
 	return c.JSON(http.StatusOK, output)
@@ -42,20 +40,40 @@ func (h *CommandHandler) ExecuteBatchCommands(c echo.Context) error {
 	
 	var req services.BatchCommandRequest
 	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid request body",
-		})
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
 	}
 	
+	startTime := time.Now()
 	outputs, err := h.commandService.ExecuteBatchCommands(sessionID, &req)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusInternalServerError, err)
 	}
 	
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"results": outputs,
 		"count":   len(outputs),
+		"executionTime": time.Since(startTime).Seconds(),
+	})
+}
+
+func (h *CommandHandler) DiscoverTools(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	// ?check=go,node,docker; without it a default set of tools is checked
+	var names []string
+	for _, name := range strings.Split(c.QueryParam("check"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	
+	tools, err := h.commandService.DiscoverTools(sessionID, names)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"tools": tools,
+		"count": len(tools),
 	})
 }
@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"terminalAPI/config"
+)
+
+type ConfigHandler struct {
+	config *config.Config
+}
+
+func NewConfigHandler(cfg *config.Config) *ConfigHandler {
+	return &ConfigHandler{
+		config: cfg,
+	}
+}
+
+// GetConfig returns the effective configuration, which holds no secrets
+func (h *ConfigHandler) GetConfig(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.config)
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"terminalAPI/services"
+)
+
+type DockerHandler struct {
+	dockerService *services.DockerService
+}
+
+func NewDockerHandler(ds *services.DockerService) *DockerHandler {
+	return &DockerHandler{
+		dockerService: ds,
+	}
+}
+
+// dockerError answers Docker service errors, passing through client errors
+// from the daemon such as an unknown container
+func dockerError(c echo.Context, err error) error {
+	status := http.StatusBadRequest
+	var apiError *services.DockerError
+	if errors.As(err, &apiError) {
+		status = apiError.Status
+		if status >= 500 {
+			status = http.StatusBadGateway
+		}
+	}
+	return respondError(c, status, err)
+}
+
+func (h *DockerHandler) ListContainers(c echo.Context) error {
+	all, _ := strconv.ParseBool(c.QueryParam("all"))
+	
+	containers, err := h.dockerService.ListContainers(all)
+	if err != nil {
+		return dockerError(c, err)
+	}
+	
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"containers": containers,
+		"count":      len(containers),
+	})
+}
+
+func (h *DockerHandler) ListImages(c echo.Context) error {
+	images, err := h.dockerService.ListImages()
+	if err != nil {
+		return dockerError(c, err)
+	}
+	
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"images": images,
+		"count":  len(images),
+	})
+}
+
+func (h *DockerHandler) GetLogs(c echo.Context) error {
+	tail, err := queryInt(c, "tail", 0)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	since, err := queryTime(c, "since")
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	timestamps, _ := strconv.ParseBool(c.QueryParam("timestamps"))
+	
+	logs, err := h.dockerService.GetLogs(c.Param("container"), &services.DockerLogQuery{
+		Tail:       tail,
+		Since:      since,
+		Timestamps: timestamps,
+	})
+	if err != nil {
+		return dockerError(c, err)
+	}
+	
+	return c.JSON(http.StatusOK, logs)
+}
+
+func (h *DockerHandler) Exec(c echo.Context) error {
+	var req services.DockerExecRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	result, err := h.dockerService.Exec(c.Param("container"), &req)
+	if err != nil {
+		return dockerError(c, err)
+	}
+	
+	return c.JSON(http.StatusOK, result)
+}
+
+func (h *DockerHandler) StartContainer(c echo.Context) error {
+	container := c.Param("container")
+	if err := h.dockerService.StartContainer(container); err != nil {
+		return dockerError(c, err)
+	}
+	
+	return c.JSON(http.StatusOK, map[string]string{
+		"message":   "Container started",
+		"container": container,
+	})
+}
+
+func (h *DockerHandler) StopContainer(c echo.Context) error {
+	timeout, err := queryInt(c, "timeout", 10)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	container := c.Param("container")
+	if err := h.dockerService.StopContainer(container, timeout); err != nil {
+		return dockerError(c, err)
+	}
+	
+	return c.JSON(http.StatusOK, map[string]string{
+		"message":   "Container stopped",
+		"container": container,
+	})
+}
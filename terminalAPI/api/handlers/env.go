@@ -30,9 +30,7 @@ func (h *EnvHandler) GetEnvVars(c echo.Context) error {
 	
 	envVars, err := h.envService.GetEnvVars(sessionID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusInternalServerError, err)
 	}
 	
 	return c.JSON(http.StatusOK, envVars)
@@ -44,15 +42,11 @@ func (h *EnvHandler) SetEnvVar(c echo.Context) error {
 	
 	var req EnvVarRequest
 	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid request body",
-		})
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
 	}
 	
 	if err := h.envService.SetEnvVar(sessionID, key, req.Value); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusInternalServerError, err)
 	}
 	
 	return c.JSON(http.StatusOK, map[string]string{
@@ -67,9 +61,7 @@ func (h *EnvHandler) UnsetEnvVar(c echo.Context) error {
 	// Simplified approach - just unset without additional checks
 	err := h.envService.UnsetEnvVar(sessionID, key)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusInternalServerError, err)
 	}
 	
 	// Always return success - no checking if it actually existed
@@ -86,18 +78,90 @@ func (h *EnvHandler) SetBatchEnvVars(c echo.Context) error {
 	
 	var req BatchEnvVarsRequest
 	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid request body",
-		})
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
 	}
 	
 	if err := h.envService.SetBatchEnvVars(sessionID, req.Variables); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusInternalServerError, err)
 	}
 	
 	return c.JSON(http.StatusOK, map[string]string{
 		"message": "Environment variables set",
 	})
 }
+
+func (h *EnvHandler) SaveProfile(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	var req services.SaveProfileRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	profile, err := h.envService.SaveProfile(sessionID, sessionOwner(c), sessionOwnerFilter(c), &req)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	return c.JSON(http.StatusCreated, profile)
+}
+
+func (h *EnvHandler) ListProfiles(c echo.Context) error {
+	profiles := h.envService.ListProfiles(sessionOwnerFilter(c))
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"profiles": profiles,
+		"count":    len(profiles),
+	})
+}
+
+func (h *EnvHandler) GetProfile(c echo.Context) error {
+	profile, err := h.envService.GetProfile(c.Param("name"), sessionOwnerFilter(c))
+	if err != nil {
+		return respondError(c, http.StatusNotFound, err)
+	}
+	
+	return c.JSON(http.StatusOK, profile)
+}
+
+func (h *EnvHandler) DeleteProfile(c echo.Context) error {
+	err := h.envService.DeleteProfile(c.Param("name"), sessionOwnerFilter(c))
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *EnvHandler) ApplyProfile(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	// The body is optional; by default the profile is merged into the session
+	var req services.ApplyProfileRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	result, err := h.envService.ApplyProfile(sessionID, c.Param("name"), sessionOwnerFilter(c), &req)
+	if err != nil {
+		return respondError(c, http.StatusNotFound, err)
+	}
+	
+	return c.JSON(http.StatusOK, result)
+}
+
+func (h *EnvHandler) LoadDotenv(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	// The body is optional; by default .env is loaded
+	var req services.DotenvRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	result, err := h.envService.LoadDotenv(sessionID, &req)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	return c.JSON(http.StatusOK, result)
+}
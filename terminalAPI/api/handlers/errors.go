@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"terminalAPI/services"
+)
+
+// Error codes, which clients can check instead of parsing messages. Errors
+// without a more specific code get the code of their status.
+const (
+	CodeInvalidRequest        = "INVALID_REQUEST"
+	CodeUnauthorized          = "UNAUTHORIZED"
+	CodeForbidden             = "FORBIDDEN"
+	CodeNotFound              = "NOT_FOUND"
+	CodeMethodNotAllowed      = "METHOD_NOT_ALLOWED"
+	CodeConflict              = "CONFLICT"
+	CodePayloadTooLarge       = "PAYLOAD_TOO_LARGE"
+	CodeRateLimited           = "RATE_LIMITED"
+	CodeInternal              = "INTERNAL_ERROR"
+	CodeNotImplemented        = "NOT_IMPLEMENTED"
+	CodeBadGateway            = "BAD_GATEWAY"
+	CodeUnavailable           = "UNAVAILABLE"
+	CodeTimeout               = "TIMEOUT"
+	CodeSessionNotFound       = "SESSION_NOT_FOUND"
+	CodeWorkingDirNotSet      = "WORKING_DIR_NOT_SET"
+	CodeInvalidSessionLabels  = "INVALID_SESSION_LABELS"
+	CodeInvalidExpiry         = "INVALID_EXPIRY"
+	CodeDirectoryNotAllowed   = "DIRECTORY_NOT_ALLOWED"
+	CodeInvalidShell          = "INVALID_SHELL"
+	CodeAliasNotFound         = "ALIAS_NOT_FOUND"
+	CodeProcessNotFound       = "PROCESS_NOT_FOUND"
+	CodeProcessNotRunning     = "PROCESS_NOT_RUNNING"
+	CodeProcessRunning        = "PROCESS_RUNNING"
+	CodeProcessLimitReached   = "PROCESS_LIMIT_REACHED"
+	CodeCommandLimitReached   = "COMMAND_LIMIT_REACHED"
+	CodeNotRawProcess         = "NOT_RAW_PROCESS"
+	CodeAlreadyRecording      = "ALREADY_RECORDING"
+	CodeSecretNotFound        = "SECRET_NOT_FOUND"
+	CodeProfileNotFound       = "PROFILE_NOT_FOUND"
+	CodeProfileNameTaken      = "PROFILE_NAME_TAKEN"
+	CodeTemplateNotFound      = "TEMPLATE_NOT_FOUND"
+	CodeInvalidTemplateParams = "INVALID_TEMPLATE_PARAMS"
+	CodeJobNotFound           = "JOB_NOT_FOUND"
+	CodeRecordingNotFound     = "RECORDING_NOT_FOUND"
+	CodeLogNotFound           = "LOG_NOT_FOUND"
+	CodeInvalidSnapshot       = "INVALID_SNAPSHOT"
+	CodeSnapshotTooLarge      = "SNAPSHOT_TOO_LARGE"
+	CodeDockerUnavailable     = "DOCKER_UNAVAILABLE"
+	CodePackageInstallOff     = "PACKAGE_INSTALL_DISABLED"
+	CodeNoPackageManager      = "NO_PACKAGE_MANAGER"
+	CodeUnsupportedMediaType  = "UNSUPPORTED_MEDIA_TYPE"
+	CodeInvalidStorage        = "INVALID_STORAGE_REQUEST"
+	CodeRemoteUnsupported     = "REMOTE_UNSUPPORTED"
+	CodeInvalidRemote         = "INVALID_REMOTE"
+	CodeRemoteForbidden       = "REMOTE_FORBIDDEN"
+	CodeInvalidSandbox        = "INVALID_SANDBOX"
+	CodeMountsForbidden       = "SANDBOX_MOUNTS_FORBIDDEN"
+	CodeInvalidEnvName        = "INVALID_ENV_NAME"
+)
+
+// ErrorResponse is the body of every error response
+type ErrorResponse struct {
+	Error     string `json:"error"`               // For people; may change
+	Code      string `json:"code"`                // For programs; stable
+	RequestID string `json:"requestId,omitempty"` // Also in the X-Request-ID header and the logs
+}
+
+// serviceErrors gives the errors of the services the same status and code
+// wherever they are returned
+var serviceErrors = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{services.ErrSessionNotFound, http.StatusNotFound, CodeSessionNotFound},
+	{services.ErrNoWorkingDir, http.StatusConflict, CodeWorkingDirNotSet},
+	{services.ErrInvalidSessionLabels, http.StatusBadRequest, CodeInvalidSessionLabels},
+	{services.ErrInvalidExpiry, http.StatusBadRequest, CodeInvalidExpiry},
+	{services.ErrDirectoryNotAllowed, http.StatusForbidden, CodeDirectoryNotAllowed},
+	{services.ErrInvalidShell, http.StatusBadRequest, CodeInvalidShell},
+	{services.ErrAliasNotFound, http.StatusNotFound, CodeAliasNotFound},
+	{services.ErrProcessNotFound, http.StatusNotFound, CodeProcessNotFound},
+	{services.ErrProcessNotRunning, http.StatusConflict, CodeProcessNotRunning},
+	{services.ErrProcessRunning, http.StatusConflict, CodeProcessRunning},
+	{services.ErrProcessLimitReached, http.StatusTooManyRequests, CodeProcessLimitReached},
+	{services.ErrNotRawProcess, http.StatusBadRequest, CodeNotRawProcess},
+	{services.ErrAlreadyRecording, http.StatusConflict, CodeAlreadyRecording},
+	{services.ErrSecretNotFound, http.StatusNotFound, CodeSecretNotFound},
+	{services.ErrProfileNotFound, http.StatusNotFound, CodeProfileNotFound},
+	{services.ErrProfileNameTaken, http.StatusConflict, CodeProfileNameTaken},
+	{services.ErrTemplateNotFound, http.StatusNotFound, CodeTemplateNotFound},
+	{services.ErrInvalidTemplateParams, http.StatusBadRequest, CodeInvalidTemplateParams},
+	{services.ErrJobNotFound, http.StatusNotFound, CodeJobNotFound},
+	{services.ErrRecordingNotFound, http.StatusNotFound, CodeRecordingNotFound},
+	{services.ErrLogNotFound, http.StatusNotFound, CodeLogNotFound},
+	{services.ErrInvalidSnapshot, http.StatusBadRequest, CodeInvalidSnapshot},
+	{services.ErrSnapshotTooLarge, http.StatusRequestEntityTooLarge, CodeSnapshotTooLarge},
+	{services.ErrDockerUnavailable, http.StatusServiceUnavailable, CodeDockerUnavailable},
+	{services.ErrPackageInstallDisabled, http.StatusForbidden, CodePackageInstallOff},
+	{services.ErrNoPackageManager, http.StatusNotImplemented, CodeNoPackageManager},
+	{services.ErrInvalidStorageRequest, http.StatusBadRequest, CodeInvalidStorage},
+	{services.ErrRemoteUnsupported, http.StatusBadRequest, CodeRemoteUnsupported},
+	{services.ErrInvalidRemote, http.StatusBadRequest, CodeInvalidRemote},
+	{services.ErrInvalidSandbox, http.StatusBadRequest, CodeInvalidSandbox},
+	{services.ErrSandboxMountsForbidden, http.StatusForbidden, CodeMountsForbidden},
+	{services.ErrRemoteForbidden, http.StatusForbidden, CodeRemoteForbidden},
+	{services.ErrInvalidEnvName, http.StatusBadRequest, CodeInvalidEnvName},
+}
+
+// ErrorJSON writes an error response carrying the request's ID
+func ErrorJSON(c echo.Context, status int, code string, message string) error {
+	return c.JSON(status, ErrorResponse{
+		Error:     message,
+		Code:      code,
+		RequestID: c.Response().Header().Get(echo.HeaderXRequestID),
+	})
+}
+
+// errorMessage writes an error response with the code of its status
+func errorMessage(c echo.Context, status int, message string) error {
+	return ErrorJSON(c, status, StatusCode(status), message)
+}
+
+// respondError writes an error response for a failed service call. Errors
+// of the services get their own status and code, others status.
+func respondError(c echo.Context, status int, err error) error {
+	status, code := errorStatus(err, status)
+	return ErrorJSON(c, status, code, err.Error())
+}
+
+// errorStatus returns the status and code of err, falling back to status
+// and its code for errors the services do not name
+func errorStatus(err error, status int) (int, string) {
+	for _, known := range serviceErrors {
+		if errors.Is(err, known.err) {
+			return known.status, known.code
+		}
+	}
+	return status, StatusCode(status)
+}
+
+// StatusCode returns the code of errors with an HTTP status
+func StatusCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInvalidRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusRequestEntityTooLarge:
+		return CodePayloadTooLarge
+	case http.StatusUnsupportedMediaType:
+		return CodeUnsupportedMediaType
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusNotImplemented:
+		return CodeNotImplemented
+	case http.StatusBadGateway:
+		return CodeBadGateway
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	case http.StatusGatewayTimeout:
+		return CodeTimeout
+	}
+	if status < http.StatusInternalServerError {
+		return CodeInvalidRequest
+	}
+	return CodeInternal
+}
+
+// HTTPErrorHandler answers errors returned by routes and middleware, such as
+// unknown paths and methods, in the same form as the handlers
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	status := http.StatusInternalServerError
+	message := http.StatusText(status)
+	var httpError *echo.HTTPError
+	if errors.As(err, &httpError) {
+		status = httpError.Code
+		message = http.StatusText(status)
+		if text, ok := httpError.Message.(string); ok {
+			message = text
+		}
+	} else {
+		slog.Error("request failed", "error", err, services.LogKeyRequestID, c.Response().Header().Get(echo.HeaderXRequestID))
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(status)
+	} else {
+		err = ErrorJSON(c, status, StatusCode(status), message)
+	}
+	if err != nil {
+		slog.Warn("failed to write error response", "error", err)
+	}
+}
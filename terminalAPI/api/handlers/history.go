@@ -1,8 +1,9 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
-	"strconv"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"terminalAPI/services"
@@ -10,41 +11,43 @@ import (
 
 type HistoryHandler struct {
 	historyService *services.HistoryService
+	sessionManager *services.SessionManager
 }
 
-func NewHistoryHandler(hs *services.HistoryService) *HistoryHandler {
+func NewHistoryHandler(hs *services.HistoryService, sm *services.SessionManager) *HistoryHandler {
 	return &HistoryHandler{
 		historyService: hs,
+		sessionManager: sm,
 	}
 }
 
 func (h *HistoryHandler) GetHistory(c echo.Context) error {
 	sessionID := c.Param("sessionId")
 	
-	// Parse limit parameter
-	limitStr := c.QueryParam("limit")
-	limit := 0
-	if limitStr != "" {
-		var err error
-		limit, err = strconv.Atoi(limitStr)
-		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{
-				"error": "Invalid limit parameter",
-			})
-		}
+	// Parse limit and offset parameters
+	var query services.HistoryQuery
+	var err error
+	if query.Limit, err = queryInt(c, "limit", 0); err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	if query.Offset, err = queryInt(c, "offset", 0); err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	// Parse the time range, given as RFC 3339 timestamps
+	if query.Since, err = queryTime(c, "since"); err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	if query.Until, err = queryTime(c, "until"); err != nil {
+		return respondError(c, http.StatusBadRequest, err)
 	}
 	
-	history, err := h.historyService.GetHistory(sessionID, limit)
+	page, err := h.historyService.GetHistory(sessionID, &query)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusBadRequest, err)
 	}
 	
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"history": history,
-		"count":   len(history),
-	})
+	return c.JSON(http.StatusOK, page)
 }
 
 func (h *HistoryHandler) SearchHistory(c echo.Context) error {
@@ -52,16 +55,12 @@ func (h *HistoryHandler) SearchHistory(c echo.Context) error {
 	query := c.QueryParam("query")
 	
 	if query == "" {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Query parameter is required",
-		})
+		return errorMessage(c, http.StatusBadRequest, "Query parameter is required")
 	}
 	
 	history, err := h.historyService.SearchHistory(sessionID, query)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusInternalServerError, err)
 	}
 	
 	return c.JSON(http.StatusOK, map[string]interface{}{
@@ -75,12 +74,88 @@ func (h *HistoryHandler) ClearHistory(c echo.Context) error {
 	sessionID := c.Param("sessionId")
 	
 	if err := h.historyService.ClearHistory(sessionID); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusInternalServerError, err)
 	}
 	
 	return c.JSON(http.StatusOK, map[string]string{
 		"message": "Command history cleared",
 	})
 }
+
+// ExportHistory downloads the history as a bash or zsh history file or JSON
+func (h *HistoryHandler) ExportHistory(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	format := c.QueryParam("format")
+	if format == "" {
+		format = services.HistoryFormatBash
+	}
+	
+	data, err := h.historyService.ExportHistory(sessionID, format)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	if format == services.HistoryFormatJSON {
+		return c.Blob(http.StatusOK, echo.MIMEApplicationJSONCharsetUTF8, data)
+	}
+	return c.Blob(http.StatusOK, echo.MIMETextPlainCharsetUTF8, data)
+}
+
+// ImportHistory seeds the history from a shell history file
+func (h *HistoryHandler) ImportHistory(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	var req services.HistoryImportRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	session, err := h.sessionManager.GetSession(sessionID)
+	if err != nil {
+		return respondError(c, http.StatusNotFound, err)
+	}
+	
+	imported, err := h.historyService.ImportHistory(session, &req)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":  "Command history imported",
+		"imported": imported,
+	})
+}
+
+// GetStats returns command frequency, failure and time-of-day statistics
+func (h *HistoryHandler) GetStats(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	top, err := queryInt(c, "top", services.DefaultStatsTop)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	// Hours and weekdays are counted in the server's timezone unless tz names another
+	location := time.Local
+	if tz := c.QueryParam("tz"); tz != "" {
+		if location, err = time.LoadLocation(tz); err != nil {
+			return errorMessage(c, http.StatusBadRequest, "Invalid tz parameter")
+		}
+	}
+	
+	return c.JSON(http.StatusOK, h.historyService.GetStats(sessionID, top, location))
+}
+
+// queryTime parses an optional RFC 3339 query parameter
+func queryTime(c echo.Context, name string) (time.Time, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid %s parameter, expected an RFC 3339 time", name)
+	}
+	return parsed, nil
+}
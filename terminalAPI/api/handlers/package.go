@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"terminalAPI/services"
+)
+
+type PackageHandler struct {
+	packageService *services.PackageService
+}
+
+func NewPackageHandler(ps *services.PackageService) *PackageHandler {
+	return &PackageHandler{
+		packageService: ps,
+	}
+}
+
+// packageError answers package service errors, as bad requests unless
+// installation is disabled or there is no package manager
+func packageError(c echo.Context, err error) error {
+	return respondError(c, http.StatusBadRequest, err)
+}
+
+func (h *PackageHandler) SearchPackages(c echo.Context) error {
+	query := c.QueryParam("q")
+	if query == "" {
+		return errorMessage(c, http.StatusBadRequest, "Query parameter q is required")
+	}
+	
+	limit, err := queryInt(c, "limit", 0)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	packages, err := h.packageService.Search(query, limit)
+	if err != nil {
+		return packageError(c, err)
+	}
+	
+	return c.JSON(http.StatusOK, packages)
+}
+
+func (h *PackageHandler) ListInstalledPackages(c echo.Context) error {
+	limit, err := queryInt(c, "limit", 0)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	packages, err := h.packageService.ListInstalled(c.QueryParam("name"), limit)
+	if err != nil {
+		return packageError(c, err)
+	}
+	
+	return c.JSON(http.StatusOK, packages)
+}
+
+func (h *PackageHandler) InstallPackages(c echo.Context) error {
+	var req services.PackageInstallRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	result, err := h.packageService.Install(&req)
+	if err != nil {
+		return packageError(c, err)
+	}
+	
+	return c.JSON(http.StatusOK, result)
+}
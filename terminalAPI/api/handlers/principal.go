@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+// principalContextKey is where the authenticated caller is stored in the
+// request context
+const principalContextKey = "principal"
+
+// Principal is the API key a request was authenticated with
+type Principal struct {
+	Name  string
+	Admin bool // Admins can see and use every session
+}
+
+// SetPrincipal records the authenticated caller of a request
+func SetPrincipal(c echo.Context, principal *Principal) {
+	c.Set(principalContextKey, principal)
+}
+
+// PrincipalFromContext returns the authenticated caller of a request, or
+// nil when authentication is disabled
+func PrincipalFromContext(c echo.Context) *Principal {
+	principal, _ := c.Get(principalContextKey).(*Principal)
+	return principal
+}
+
+// sessionOwnerFilter returns the owner whose sessions the caller may list,
+// or an empty string for every session
+func sessionOwnerFilter(c echo.Context) string {
+	principal := PrincipalFromContext(c)
+	if principal == nil || principal.Admin {
+		return ""
+	}
+	return principal.Name
+}
+
+// sessionOwner returns the owner to record on sessions the caller creates
+func sessionOwner(c echo.Context) string {
+	if principal := PrincipalFromContext(c); principal != nil {
+		return principal.Name
+	}
+	return ""
+}
+
+// isAdmin reports whether the caller may use admin options, as everyone can
+// when authentication is disabled
+func isAdmin(c echo.Context) bool {
+	principal := PrincipalFromContext(c)
+	return principal == nil || principal.Admin
+}
@@ -1,7 +1,15 @@
 package handlers
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"regexp"
+	"strconv"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"terminalAPI/services"
@@ -12,11 +20,13 @@ type ProcessHandler struct {
 }
 
 type ProcessInputRequest struct {
-	Input string `json:"input"`
+	Input    string `json:"input"`
+	Encoding string `json:"encoding,omitempty"` // "base64" writes the decoded bytes verbatim, without a newline
 }
 
 type ProcessSignalRequest struct {
-	Signal string `json:"signal"` // SIGTERM, SIGKILL, SIGINT, SIGHUP
+	Signal string `json:"signal"` // SIGTERM, SIGKILL, SIGINT, SIGHUP, SIGSTOP, SIGCONT
+	Tree   *bool  `json:"tree,omitempty"` // Signal the whole process group (default true)
 }
 
 func NewProcessHandler(ps *services.ProcessService) *ProcessHandler {
@@ -30,16 +40,16 @@ func (h *ProcessHandler) StartProcess(c echo.Context) error {
 	
 	var req services.CommandRequest
 	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid request body",
-		})
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
 	}
 	
 	processInfo, err := h.processService.StartProcess(sessionID, &req)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	if processInfo.DryRun != nil {
+		return c.JSON(http.StatusOK, processInfo)
 	}
 	
 	return c.JSON(http.StatusCreated, processInfo)
@@ -51,16 +61,12 @@ func (h *ProcessHandler) GetProcess(c echo.Context) error {
 	
 	processes, err := h.processService.ListProcesses(sessionID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusInternalServerError, err)
 	}
 	
 	process, exists := processes[processID]
 	if !exists {
-		return c.JSON(http.StatusNotFound, map[string]string{
-			"error": "Process not found",
-		})
+		return ErrorJSON(c, http.StatusNotFound, CodeProcessNotFound, "Process not found")
 	}
 	
 	return c.JSON(http.StatusOK, process)
@@ -71,9 +77,7 @@ func (h *ProcessHandler) ListProcesses(c echo.Context) error {
 	
 	processes, err := h.processService.ListProcesses(sessionID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusInternalServerError, err)
 	}
 	
 	return c.JSON(http.StatusOK, map[string]interface{}{
@@ -86,17 +90,60 @@ func (h *ProcessHandler) GetProcessOutput(c echo.Context) error {
 	sessionID := c.Param("sessionId")
 	processID := c.Param("processId")
 	
-	output, err := h.processService.GetOutput(sessionID, processID)
+	// Cursors are absolute line indexes; "since" sets both at once
+	since, err := queryInt(c, "since", 0)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	stdoutSince, err := queryInt(c, "stdoutSince", since)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	stderrSince, err := queryInt(c, "stderrSince", since)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
 	}
 	
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"stdout": output.Stdout,
-		"stderr": output.Stderr,
-	})
+	if c.QueryParam("encoding") == "base64" {
+		raw, err := h.processService.GetEncodedOutput(sessionID, processID)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, err)
+		}
+		return c.JSON(http.StatusOK, raw)
+	}
+	
+	filter, err := parseOutputFilter(c)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	output, err := h.processService.GetOutputSince(sessionID, processID, stdoutSince, stderrSince, filter)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusOK, output)
+}
+
+// DownloadRawOutput serves the raw bytes of one stream of a raw mode process,
+// honouring Range requests
+func (h *ProcessHandler) DownloadRawOutput(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	processID := c.Param("processId")
+	stream := c.QueryParam("stream")
+	
+	data, err := h.processService.GetRawOutput(sessionID, processID, stream)
+	if err != nil {
+		return respondError(c, http.StatusNotFound, err)
+	}
+	
+	if stream == "" {
+		stream = "stdout"
+	}
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMEOctetStream)
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", processID+"."+stream))
+	http.ServeContent(c.Response(), c.Request(), "", time.Time{}, bytes.NewReader(data))
+	return nil
 }
 
 func (h *ProcessHandler) SendProcessInput(c echo.Context) error {
@@ -105,16 +152,24 @@ func (h *ProcessHandler) SendProcessInput(c echo.Context) error {
 	
 	var req ProcessInputRequest
 	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid request body",
-		})
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
 	}
 	
-	err := h.processService.SendInput(sessionID, processID, req.Input)
+	var err error
+	switch req.Encoding {
+	case "":
+		err = h.processService.SendInput(sessionID, processID, req.Input)
+	case "base64":
+		data, decodeErr := base64.StdEncoding.DecodeString(req.Input)
+		if decodeErr != nil {
+			return errorMessage(c, http.StatusBadRequest, "Input is not valid base64")
+		}
+		err = h.processService.SendRawInput(sessionID, processID, data)
+	default:
+		return errorMessage(c, http.StatusBadRequest, "Unsupported input encoding: " + req.Encoding)
+	}
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusInternalServerError, err)
 	}
 	
 	return c.JSON(http.StatusOK, map[string]string{
@@ -128,19 +183,245 @@ func (h *ProcessHandler) SignalProcess(c echo.Context) error {
 	
 	var req ProcessSignalRequest
 	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid request body",
-		})
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
 	}
 	
-	err := h.processService.SignalProcess(sessionID, processID, req.Signal)
+	tree := req.Tree == nil || *req.Tree
+	err := h.processService.SignalProcess(sessionID, processID, req.Signal, tree)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusInternalServerError, err)
 	}
 	
 	return c.JSON(http.StatusOK, map[string]string{
 		"message": "Signal sent to process",
 	})
 }
+
+func (h *ProcessHandler) KillAllProcesses(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	// The body is optional; an empty request kills every running process
+	var req services.KillAllRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	result, err := h.processService.KillAll(sessionID, &req)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	return c.JSON(http.StatusOK, result)
+}
+
+func (h *ProcessHandler) GetAutoResponses(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	processID := c.Param("processId")
+	
+	events, err := h.processService.GetAutoResponses(sessionID, processID)
+	if err != nil {
+		return respondError(c, http.StatusNotFound, err)
+	}
+	
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"autoResponses": events,
+		"count":         len(events),
+	})
+}
+
+func (h *ProcessHandler) GetProcessStats(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	processID := c.Param("processId")
+	
+	stats, err := h.processService.GetProcessStats(sessionID, processID)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusOK, stats)
+}
+
+func (h *ProcessHandler) DeleteProcess(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	processID := c.Param("processId")
+	
+	err := h.processService.DeleteProcess(sessionID, processID)
+	if errors.Is(err, services.ErrProcessRunning) {
+		return ErrorJSON(c, http.StatusConflict, CodeProcessRunning, "Process is still running; terminate it first")
+	}
+	if err != nil {
+		return respondError(c, http.StatusNotFound, err)
+	}
+	
+	return c.NoContent(http.StatusNoContent)
+}
+
+// DeleteProcesses bulk-removes process records; only state=completed is supported
+func (h *ProcessHandler) DeleteProcesses(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	if c.QueryParam("state") != "completed" {
+		return errorMessage(c, http.StatusBadRequest, "state=completed is required")
+	}
+	
+	removed, err := h.processService.DeleteCompletedProcesses(sessionID)
+	if err != nil {
+		return respondError(c, http.StatusNotFound, err)
+	}
+	
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"removed": removed,
+	})
+}
+
+// StreamProcessEvents streams process output and the completion event as
+// Server-Sent Events for clients that cannot use WebSockets
+func (h *ProcessHandler) StreamProcessEvents(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	processID := c.Param("processId")
+	
+	sub, err := h.processService.SubscribeOutput(sessionID, processID)
+	if err != nil {
+		return respondError(c, http.StatusNotFound, err)
+	}
+	defer sub.Close()
+	
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.WriteHeader(http.StatusOK)
+	
+	// Replay buffered output unless the client only wants new lines
+	if c.QueryParam("replay") != "false" {
+		now := time.Now()
+		for _, line := range sub.Stdout {
+			writeSSE(res, services.OutputEvent{Type: "stdout", Line: line, Timestamp: now, Replay: true})
+		}
+		for _, line := range sub.Stderr {
+			writeSSE(res, services.OutputEvent{Type: "stderr", Line: line, Timestamp: now, Replay: true})
+		}
+		if len(sub.StdoutRaw) > 0 {
+			writeSSE(res, services.OutputEvent{Type: "stdout", Data: base64.StdEncoding.EncodeToString(sub.StdoutRaw), Timestamp: now, Replay: true})
+		}
+		if len(sub.StderrRaw) > 0 {
+			writeSSE(res, services.OutputEvent{Type: "stderr", Data: base64.StdEncoding.EncodeToString(sub.StderrRaw), Timestamp: now, Replay: true})
+		}
+		res.Flush()
+	}
+	
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+	
+	for {
+		select {
+		case event, ok := <-sub.Events:
+			if !ok {
+				return nil
+			}
+			writeSSE(res, event)
+			res.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(res, ": keepalive\n\n")
+			res.Flush()
+		case <-c.Request().Context().Done():
+			return nil
+		}
+	}
+}
+
+// writeSSE writes a single event in text/event-stream format
+func writeSSE(res *echo.Response, event services.OutputEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event.Type, data)
+}
+
+// queryInt parses an optional integer query parameter
+func queryInt(c echo.Context, name string, defaultValue int) (int, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %s parameter", name)
+	}
+	return parsed, nil
+}
+
+// parseOutputFilter reads the grep, head, tail and maxBytes query
+// parameters, returning nil when none is set
+func parseOutputFilter(c echo.Context) (*services.OutputFilter, error) {
+	filter := &services.OutputFilter{}
+	var err error
+	
+	if pattern := c.QueryParam("grep"); pattern != "" {
+		if filter.Grep, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("Invalid grep pattern: %v", err)
+		}
+	}
+	if filter.Head, err = queryInt(c, "head", 0); err != nil {
+		return nil, err
+	}
+	if filter.Tail, err = queryInt(c, "tail", 0); err != nil {
+		return nil, err
+	}
+	if filter.MaxBytes, err = queryInt(c, "maxBytes", 0); err != nil {
+		return nil, err
+	}
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	
+	if filter.Grep == nil && filter.Head == 0 && filter.Tail == 0 && filter.MaxBytes == 0 {
+		return nil, nil
+	}
+	return filter, nil
+}
+
+// ListLogs returns the process log files kept for a session
+func (h *ProcessHandler) ListLogs(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	logs, err := h.processService.ListLogs(sessionID)
+	if err != nil {
+		return respondError(c, http.StatusNotFound, err)
+	}
+	
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"logs":  logs,
+		"count": len(logs),
+	})
+}
+
+// DownloadLog serves a process log file, supporting Range requests
+func (h *ProcessHandler) DownloadLog(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	name := c.Param("name")
+	
+	path, err := h.processService.GetLogPath(sessionID, name)
+	if err != nil {
+		return respondError(c, http.StatusNotFound, err)
+	}
+	
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextPlainCharsetUTF8)
+	return c.Attachment(path, name)
+}
+
+// RotateLog starts a fresh log file for a running process
+func (h *ProcessHandler) RotateLog(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	processID := c.Param("processId")
+	
+	if err := h.processService.RotateLog(sessionID, processID); err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Log rotated",
+	})
+}
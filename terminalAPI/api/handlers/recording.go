@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"terminalAPI/services"
+)
+
+type RecordingHandler struct {
+	recordingService *services.RecordingService
+}
+
+func NewRecordingHandler(rs *services.RecordingService) *RecordingHandler {
+	return &RecordingHandler{
+		recordingService: rs,
+	}
+}
+
+func (h *RecordingHandler) StartRecording(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	// The body is optional; an empty request uses the default terminal size
+	var req services.RecordingRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	info, err := h.recordingService.StartRecording(sessionID, &req)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	return c.JSON(http.StatusCreated, info)
+}
+
+func (h *RecordingHandler) GetRecording(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	info, err := h.recordingService.GetRecording(sessionID)
+	if err != nil {
+		return respondError(c, http.StatusNotFound, err)
+	}
+	
+	return c.JSON(http.StatusOK, info)
+}
+
+func (h *RecordingHandler) StopRecording(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	info, err := h.recordingService.StopRecording(sessionID)
+	if err != nil {
+		return respondError(c, http.StatusNotFound, err)
+	}
+	
+	return c.JSON(http.StatusOK, info)
+}
+
+func (h *RecordingHandler) ListRecordings(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	recordings, err := h.recordingService.ListRecordings(sessionID)
+	if err != nil {
+		return respondError(c, http.StatusNotFound, err)
+	}
+	
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"recordings": recordings,
+		"count":      len(recordings),
+	})
+}
+
+// DownloadRecording serves a cast file for `asciinema play`
+func (h *RecordingHandler) DownloadRecording(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	name := c.Param("name")
+	
+	path, err := h.recordingService.GetRecordingPath(sessionID, name)
+	if err != nil {
+		return respondError(c, http.StatusNotFound, err)
+	}
+	
+	c.Response().Header().Set(echo.HeaderContentType, "application/x-asciicast")
+	return c.Attachment(path, name)
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"terminalAPI/services"
+)
+
+type SchedulerHandler struct {
+	schedulerService *services.SchedulerService
+}
+
+func NewSchedulerHandler(ss *services.SchedulerService) *SchedulerHandler {
+	return &SchedulerHandler{
+		schedulerService: ss,
+	}
+}
+
+func (h *SchedulerHandler) CreateJob(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	var req services.ScheduleRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	job, err := h.schedulerService.CreateJob(sessionID, &req)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	return c.JSON(http.StatusCreated, job)
+}
+
+func (h *SchedulerHandler) ListJobs(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	jobs, err := h.schedulerService.ListJobs(sessionID)
+	if err != nil {
+		return respondError(c, http.StatusNotFound, err)
+	}
+	
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"schedules": jobs,
+		"count":     len(jobs),
+	})
+}
+
+func (h *SchedulerHandler) GetJob(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	jobID := c.Param("jobId")
+	
+	job, err := h.schedulerService.GetJob(sessionID, jobID)
+	if err != nil {
+		return respondError(c, http.StatusNotFound, err)
+	}
+	
+	return c.JSON(http.StatusOK, job)
+}
+
+func (h *SchedulerHandler) UpdateJob(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	jobID := c.Param("jobId")
+	
+	var req services.ScheduleUpdateRequest
+	if err := c.Bind(&req); err != nil || req.Enabled == nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	job, err := h.schedulerService.SetEnabled(sessionID, jobID, *req.Enabled)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	return c.JSON(http.StatusOK, job)
+}
+
+func (h *SchedulerHandler) DeleteJob(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	jobID := c.Param("jobId")
+	
+	if err := h.schedulerService.DeleteJob(sessionID, jobID); err != nil {
+		return respondError(c, http.StatusNotFound, err)
+	}
+	
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Scheduled job deleted",
+	})
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"terminalAPI/services"
+)
+
+type SecretHandler struct {
+	secretService *services.SecretService
+}
+
+// SetSecretRequest carries the value of a secret; it is never returned
+type SetSecretRequest struct {
+	Value string `json:"value"`
+}
+
+func NewSecretHandler(ss *services.SecretService) *SecretHandler {
+	return &SecretHandler{
+		secretService: ss,
+	}
+}
+
+func (h *SecretHandler) SetSecret(c echo.Context) error {
+	var req SetSecretRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	info, err := h.secretService.SetSecret(c.Param("name"), req.Value)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	return c.JSON(http.StatusOK, info)
+}
+
+func (h *SecretHandler) ListSecrets(c echo.Context) error {
+	secrets := h.secretService.ListSecrets()
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"secrets": secrets,
+		"count":   len(secrets),
+	})
+}
+
+func (h *SecretHandler) DeleteSecret(c echo.Context) error {
+	err := h.secretService.DeleteSecret(c.Param("name"))
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.NoContent(http.StatusNoContent)
+}
@@ -11,6 +11,31 @@ type SessionRequest struct {
 	WorkingDirectory string `json:"workingDirectory"`
 }
 
+type AliasRequest struct {
+	Command string `json:"command"`
+}
+
+type ShellRequest struct {
+	Shell string `json:"shell"` // Absolute path or a name looked up in PATH
+}
+
+// SessionDetails is a session with the time it has left
+type SessionDetails struct {
+	*services.Session
+	// Seconds until the session expires unless it is used; absent when it
+	// never expires
+	TTLSeconds *int64 `json:"ttlSeconds,omitempty"`
+}
+
+func sessionDetails(session *services.Session) *SessionDetails {
+	details := &SessionDetails{Session: session}
+	if ttl, expires := session.TTL(); expires {
+		seconds := int64(ttl.Seconds())
+		details.TTLSeconds = &seconds
+	}
+	return details
+}
+
 type SessionHandler struct {
 	sessionManager *services.SessionManager
 }
@@ -22,24 +47,62 @@ func NewSessionHandler(sm *services.SessionManager) *SessionHandler {
 }
 
 func (h *SessionHandler) CreateSession(c echo.Context) error {
-	session, err := h.sessionManager.CreateSession()
+	// The body is optional; an empty request creates a host session
+	var opts services.SessionOptions
+	if err := c.Bind(&opts); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	if opts.NeverExpire && !isAdmin(c) {
+		return errorMessage(c, http.StatusForbidden, "only admin keys may create sessions that never expire")
+	}
+	opts.Owner = sessionOwner(c)
+	opts.AllowMounts = isAdmin(c)
+	opts.AllowRemote = isAdmin(c)
+	
+	session, err := h.sessionManager.CreateSession(&opts)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusInternalServerError, err)
 	}
 	
-	return c.JSON(http.StatusCreated, session)
+	return c.JSON(http.StatusCreated, sessionDetails(session))
 }
 
 func (h *SessionHandler) GetSession(c echo.Context) error {
 	sessionID := c.Param("sessionId")
 	
-	session, err := h.sessionManager.GetSession(sessionID)
+	// Reading a session does not keep it alive, so ttlSeconds shows the
+	// time it has left
+	session, err := h.sessionManager.PeekSession(sessionID)
+	if err != nil {
+		return respondError(c, http.StatusNotFound, err)
+	}
+	
+	return c.JSON(http.StatusOK, sessionDetails(session))
+}
+
+// TouchSession keeps a session alive for another expiry period
+func (h *SessionHandler) TouchSession(c echo.Context) error {
+	session, err := h.sessionManager.TouchSession(c.Param("sessionId"))
 	if err != nil {
-		return c.JSON(http.StatusNotFound, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusNotFound, err)
+	}
+	
+	return c.JSON(http.StatusOK, sessionDetails(session))
+}
+
+// UpdateSession changes the name, tags or metadata of a session
+func (h *SessionHandler) UpdateSession(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	var update services.SessionUpdate
+	if err := c.Bind(&update); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	session, err := h.sessionManager.UpdateSession(sessionID, &update)
+	if err != nil {
+		return respondError(c, http.StatusNotFound, err)
 	}
 	
 	return c.JSON(http.StatusOK, session)
@@ -49,9 +112,7 @@ func (h *SessionHandler) DeleteSession(c echo.Context) error {
 	sessionID := c.Param("sessionId")
 	
 	if err := h.sessionManager.DeleteSession(sessionID); err != nil {
-		return c.JSON(http.StatusNotFound, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusNotFound, err)
 	}
 	
 	return c.NoContent(http.StatusNoContent)
@@ -62,25 +123,146 @@ func (h *SessionHandler) SetWorkingDirectory(c echo.Context) error {
 	
 	var req SessionRequest
 	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid request body",
-		})
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
 	}
 	
 	if err := h.sessionManager.SetWorkingDirectory(sessionID, req.WorkingDirectory); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": err.Error(),
-		})
+		return respondError(c, http.StatusBadRequest, err)
 	}
 	
 	session, _ := h.sessionManager.GetSession(sessionID)
 	return c.JSON(http.StatusOK, session)
 }
 
+// GetActivity returns a page of a session's activity, filtered by
+// ?category=, ?outcome= and the ?since= and ?until= time range
+func (h *SessionHandler) GetActivity(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	query := services.ActivityQuery{
+		Category: c.QueryParam("category"),
+		Outcome:  c.QueryParam("outcome"),
+	}
+	var err error
+	if query.Limit, err = queryInt(c, "limit", 100); err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	if query.Offset, err = queryInt(c, "offset", 0); err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	if query.Since, err = queryTime(c, "since"); err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	if query.Until, err = queryTime(c, "until"); err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	if query.Limit < 0 || query.Offset < 0 {
+		return errorMessage(c, http.StatusBadRequest, "limit and offset must not be negative")
+	}
+	
+	page, err := h.sessionManager.GetActivity(sessionID, &query)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusOK, page)
+}
+
+// ListSessions lists the caller's sessions, only those with every tag
+// given as ?tag= when set
 func (h *SessionHandler) ListSessions(c echo.Context) error {
-	sessions := h.sessionManager.GetAllSessions()
+	sessions := h.sessionManager.GetAllSessions(sessionOwnerFilter(c))
+	if tags := c.QueryParams()["tag"]; len(tags) > 0 {
+		sessions = services.SessionsWithTags(sessions, tags)
+	}
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"sessions": sessions,
 		"count":    len(sessions),
 	})
 }
+
+func (h *SessionHandler) ResetShell(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	if err := h.sessionManager.ResetPersistentShell(sessionID); err != nil {
+		return respondError(c, http.StatusNotFound, err)
+	}
+	
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Persistent shell reset",
+	})
+}
+
+func (h *SessionHandler) SetShell(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	var req ShellRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	shell, err := h.sessionManager.SetShell(sessionID, req.Shell)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Shell updated",
+		"shell":   shell,
+	})
+}
+
+func (h *SessionHandler) GetLimits(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	limits, err := h.sessionManager.GetLimits(sessionID)
+	if err != nil {
+		return respondError(c, http.StatusNotFound, err)
+	}
+	
+	return c.JSON(http.StatusOK, limits)
+}
+
+func (h *SessionHandler) ListAliases(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	aliases, err := h.sessionManager.GetAliases(sessionID)
+	if err != nil {
+		return respondError(c, http.StatusNotFound, err)
+	}
+	
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"aliases": aliases,
+		"count":   len(aliases),
+	})
+}
+
+func (h *SessionHandler) SetAlias(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	var req AliasRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	name := c.Param("name")
+	if err := h.sessionManager.SetAlias(sessionID, name, req.Command); err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	return c.JSON(http.StatusOK, map[string]string{
+		"name":    name,
+		"command": req.Command,
+	})
+}
+
+func (h *SessionHandler) DeleteAlias(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	// Both an unknown session and an unknown alias are reported as not found
+	if err := h.sessionManager.DeleteAlias(sessionID, c.Param("name")); err != nil {
+		return respondError(c, http.StatusNotFound, err)
+	}
+	
+	return c.NoContent(http.StatusNoContent)
+}
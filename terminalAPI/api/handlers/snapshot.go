@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"terminalAPI/services"
+)
+
+// maxSnapshotUpload bounds import request bodies, leaving room for the
+// archive's headers and manifest next to the files
+const maxSnapshotUpload = services.MaxSnapshotFilesSize + 64*1024*1024
+
+type SnapshotHandler struct {
+	snapshotService *services.SnapshotService
+}
+
+func NewSnapshotHandler(ss *services.SnapshotService) *SnapshotHandler {
+	return &SnapshotHandler{
+		snapshotService: ss,
+	}
+}
+
+// ExportSession downloads a session as a gzipped tar archive, with its
+// working directory's files when ?files=true
+func (h *SnapshotHandler) ExportSession(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	includeFiles := false
+	if value := c.QueryParam("files"); value != "" {
+		var err error
+		if includeFiles, err = strconv.ParseBool(value); err != nil {
+			return errorMessage(c, http.StatusBadRequest, "Invalid files parameter")
+		}
+	}
+	
+	snapshot, err := h.snapshotService.Snapshot(sessionID, includeFiles)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	response := c.Response()
+	response.Header().Set(echo.HeaderContentType, "application/gzip")
+	response.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", "session-"+sessionID+".tar.gz"))
+	response.WriteHeader(http.StatusOK)
+	
+	// The archive is streamed, so a failure past this point can only cut it short
+	if err := h.snapshotService.WriteSnapshot(snapshot, response); err != nil {
+		slog.Error("session export failed", services.LogKeySession, sessionID, "error", err)
+	}
+	return nil
+}
+
+// ImportSession creates a session from an archive made by ExportSession,
+// restoring its files to ?workingDir= or the directory it had
+func (h *SnapshotHandler) ImportSession(c echo.Context) error {
+	body := http.MaxBytesReader(c.Response(), c.Request().Body, maxSnapshotUpload)
+	
+	result, err := h.snapshotService.ImportSession(body, &services.SnapshotImportOptions{
+		WorkingDir:  c.QueryParam("workingDir"),
+		Owner:       sessionOwner(c),
+		AllowMounts: isAdmin(c),
+		AllowRemote: isAdmin(c),
+	})
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return ErrorJSON(c, http.StatusRequestEntityTooLarge, CodeSnapshotTooLarge, err.Error())
+		}
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	return c.JSON(http.StatusCreated, result)
+}
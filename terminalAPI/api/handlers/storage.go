@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"terminalAPI/services"
+)
+
+type StorageHandler struct {
+	storageService *services.StorageService
+}
+
+func NewStorageHandler(ss *services.StorageService) *StorageHandler {
+	return &StorageHandler{
+		storageService: ss,
+	}
+}
+
+// storageError answers storage service errors, passing through client
+// errors from the object store such as a missing bucket or denied access
+func storageError(c echo.Context, err error) error {
+	status := http.StatusBadRequest
+	var apiError *services.StorageError
+	if errors.As(err, &apiError) {
+		status = apiError.Status
+		if status >= 500 || status < 400 {
+			status = http.StatusBadGateway
+		}
+	}
+	return respondError(c, status, err)
+}
+
+// ListObjects lists the objects under a key prefix
+func (h *StorageHandler) ListObjects(c echo.Context) error {
+	var req services.StorageRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	listing, err := h.storageService.List(c.Param("sessionId"), &req)
+	if err != nil {
+		return storageError(c, err)
+	}
+	
+	return c.JSON(http.StatusOK, listing)
+}
+
+// Upload copies a file or directory from the working directory to a bucket
+func (h *StorageHandler) Upload(c echo.Context) error {
+	var req services.StorageRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	transfer, err := h.storageService.Upload(c.Param("sessionId"), &req)
+	if err != nil {
+		return storageError(c, err)
+	}
+	
+	return c.JSON(http.StatusOK, transfer)
+}
+
+// Download copies an object, or the objects under a prefix, into the
+// working directory
+func (h *StorageHandler) Download(c echo.Context) error {
+	var req services.StorageRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	transfer, err := h.storageService.Download(c.Param("sessionId"), &req)
+	if err != nil {
+		return storageError(c, err)
+	}
+	
+	return c.JSON(http.StatusOK, transfer)
+}
@@ -3,10 +3,12 @@ package handlers
 import (
 	"bufio"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/exec"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
@@ -144,25 +146,20 @@ func (h *SystemHandler) GetAvailableShells(c echo.Context) error {
 	// Default to system shell only if we can't get a session-specific one
 	currentShell := ""
 	
-	fmt.Printf("[DEBUG] GetAvailableShells: Session ID from path param: '%s'\n", sessionID)
-	
 	if sessionID == "" {
 		sessionID = c.QueryParam("sessionId")
-		fmt.Printf("[DEBUG] GetAvailableShells: Session ID from query param: '%s'\n", sessionID)
 	}
 	
 	// First try to get the shell from the session
 	shellFound := false
 	if sessionID != "" && h.sessionManager != nil {
-		fmt.Printf("[DEBUG] GetAvailableShells: Getting SHELL for session '%s'\n", sessionID)
-		
 		// Using direct session access for demonstration purposes only
 		envVars, err := h.sessionManager.GetEnvVars(sessionID)
 		if err == nil && envVars != nil {
 			if shell, exists := envVars["SHELL"]; exists && shell != "" {
 				currentShell = shell
 				shellFound = true
-				fmt.Printf("[DEBUG] GetAvailableShells: Found session SHELL: '%s'\n", currentShell)
+				slog.Debug("using session shell", services.LogKeySession, sessionID, "shell", currentShell)
 			}
 		}
 	}
@@ -170,7 +167,7 @@ func (h *SystemHandler) GetAvailableShells(c echo.Context) error {
 	// Only fall back to system shell if we couldn't get one from the session
 	if !shellFound {
 		currentShell = os.Getenv("SHELL")
-		fmt.Printf("[DEBUG] GetAvailableShells: Using system SHELL: '%s'\n", currentShell)
+		slog.Debug("using system shell", "shell", currentShell)
 	}
 	
 	// Include useful debug info in the response
@@ -182,3 +179,77 @@ func (h *SystemHandler) GetAvailableShells(c echo.Context) error {
 		"systemShell":     os.Getenv("SHELL"),
 	})
 }
+
+// GetHardware reports the CPU, memory, disks and GPUs of the server
+func (h *SystemHandler) GetHardware(c echo.Context) error {
+	return c.JSON(http.StatusOK, services.GetHardwareInfo())
+}
+
+// GetProcesses lists host processes, busiest first by default
+func (h *SystemHandler) GetProcesses(c echo.Context) error {
+	query := services.HostProcessQuery{
+		User: c.QueryParam("user"),
+		Name: c.QueryParam("name"),
+		Sort: c.QueryParam("sort"),
+	}
+	
+	var err error
+	if query.MinCPU, err = queryFloat(c, "minCpu"); err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	if query.MinMemoryMB, err = queryFloat(c, "minMemoryMB"); err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	if query.Limit, err = queryInt(c, "limit", 0); err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	// The CPU sample interval is given in milliseconds
+	interval, err := queryInt(c, "interval", 0)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	query.Interval = time.Duration(interval) * time.Millisecond
+	
+	processes, err := h.sessionManager.ListHostProcesses(&query)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	return c.JSON(http.StatusOK, processes)
+}
+
+// queryFloat parses an optional number query parameter, 0 when absent
+func queryFloat(c echo.Context, name string) (float64, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return 0, nil
+	}
+	
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %s parameter", name)
+	}
+	return parsed, nil
+}
+
+// GetListeningPorts lists listening sockets and the processes owning them
+func (h *SystemHandler) GetListeningPorts(c echo.Context) error {
+	port, err := queryInt(c, "port", 0)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	sockets, err := h.sessionManager.ListListeningPorts(&services.PortQuery{
+		Protocol: c.QueryParam("protocol"),
+		Port:     port,
+	})
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"ports": sockets,
+		"count": len(sockets),
+	})
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"terminalAPI/services"
+)
+
+type TemplateHandler struct {
+	templateService *services.TemplateService
+}
+
+func NewTemplateHandler(ts *services.TemplateService) *TemplateHandler {
+	return &TemplateHandler{
+		templateService: ts,
+	}
+}
+
+func (h *TemplateHandler) RegisterTemplate(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	var req services.TemplateRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	template, err := h.templateService.RegisterTemplate(sessionID, &req)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, err)
+	}
+	
+	return c.JSON(http.StatusCreated, template)
+}
+
+func (h *TemplateHandler) ListTemplates(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	
+	templates, err := h.templateService.ListTemplates(sessionID)
+	if err != nil {
+		return respondError(c, http.StatusNotFound, err)
+	}
+	
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"templates": templates,
+		"count":     len(templates),
+	})
+}
+
+func (h *TemplateHandler) GetTemplate(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	name := c.Param("name")
+	
+	template, err := h.templateService.GetTemplate(sessionID, name)
+	if err != nil {
+		return respondError(c, http.StatusNotFound, err)
+	}
+	
+	return c.JSON(http.StatusOK, template)
+}
+
+func (h *TemplateHandler) DeleteTemplate(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	name := c.Param("name")
+	
+	if err := h.templateService.DeleteTemplate(sessionID, name); err != nil {
+		return respondError(c, http.StatusNotFound, err)
+	}
+	
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Template deleted",
+	})
+}
+
+func (h *TemplateHandler) ExecuteTemplate(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+	name := c.Param("name")
+	
+	var req services.TemplateExecuteRequest
+	if err := c.Bind(&req); err != nil {
+		return errorMessage(c, http.StatusBadRequest, "Invalid request body")
+	}
+	
+	output, err := h.templateService.ExecuteTemplate(sessionID, name, &req)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, err)
+	}
+	
+	return c.JSON(http.StatusOK, output)
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"log/slog"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"terminalAPI/services"
+)
+
+// RequestLogger logs every request as a structured line with its request
+// ID, which middleware.RequestID must assign first, and the session it
+// belongs to. Server errors are logged at error level.
+func RequestLogger() echo.MiddlewareFunc {
+	return middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
+		LogRequestID: true,
+		LogMethod:    true,
+		LogURI:       true,
+		LogStatus:    true,
+		LogLatency:   true,
+		LogRemoteIP:  true,
+		LogError:     true,
+		// Let the error handler pick the status before it is logged
+		HandleError: true,
+		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
+			attrs := []any{
+				services.LogKeyRequestID, v.RequestID,
+				"method", v.Method,
+				"uri", v.URI,
+				"route", c.Path(),
+				"status", v.Status,
+				"latency", v.Latency,
+				"remoteIp", v.RemoteIP,
+			}
+			sessionID := c.Param("sessionId")
+			if sessionID == "" {
+				sessionID = c.QueryParam("sessionId")
+			}
+			if sessionID != "" {
+				attrs = append(attrs, services.LogKeySession, sessionID)
+			}
+
+			level := slog.LevelInfo
+			if v.Error != nil {
+				attrs = append(attrs, "error", v.Error.Error())
+			}
+			if v.Status >= 500 {
+				level = slog.LevelError
+			}
+			slog.Log(c.Request().Context(), level, "request", attrs...)
+			return nil
+		},
+	})
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"golang.org/x/time/rate"
+	"terminalAPI/api/handlers"
+)
+
+// commandRoutes run commands synchronously and count towards a session's
+// concurrent command limit
+var commandRoutes = map[string]bool{
+	"POST /sessions/:sessionId/commands":                true,
+	"POST /sessions/:sessionId/commands/batch":          true,
+	"POST /sessions/:sessionId/templates/:name/execute": true,
+}
+
+// RateLimiter allows each caller requestsPerMinute requests to each route,
+// with bursts of up to a minute's worth. It is shared by the REST and gRPC
+// APIs, so a session has the same budget over both.
+type RateLimiter struct {
+	requestsPerMinute int
+	// A token is returned every 60/requestsPerMinute seconds
+	retryAfter string
+	store      *middleware.RateLimiterMemoryStore
+}
+
+// NewRateLimiter returns a limiter of requestsPerMinute requests per caller
+// and route
+func NewRateLimiter(requestsPerMinute int) *RateLimiter {
+	return &RateLimiter{
+		requestsPerMinute: requestsPerMinute,
+		retryAfter:        strconv.Itoa(int(math.Ceil(60 / float64(requestsPerMinute)))),
+		store: middleware.NewRateLimiterMemoryStoreWithConfig(middleware.RateLimiterMemoryStoreConfig{
+			Rate:      rate.Limit(float64(requestsPerMinute) / 60),
+			Burst:     requestsPerMinute,
+			ExpiresIn: 3 * time.Minute,
+		}),
+	}
+}
+
+// Allow reports whether caller may make another request to route, such as
+// "POST /sessions/:sessionId/commands", using up a token if so
+func (l *RateLimiter) Allow(caller, route string) bool {
+	allowed, _ := l.store.Allow(caller + " " + route)
+	return allowed
+}
+
+// RetryAfter is the number of seconds after which a limited caller gets
+// another token
+func (l *RateLimiter) RetryAfter() string {
+	return l.retryAfter
+}
+
+// Message describes the limit to callers that exceeded it
+func (l *RateLimiter) Message() string {
+	return fmt.Sprintf("rate limit of %d requests per minute exceeded", l.requestsPerMinute)
+}
+
+// RateLimit applies limiter to each session's requests. Requests outside a
+// session are counted per API key, or per client address when
+// authentication is disabled. Limited requests get 429 with a Retry-After
+// header.
+func RateLimit(limiter *RateLimiter) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !limiter.Allow(rateLimitCaller(c), c.Request().Method+" "+c.Path()) {
+				c.Response().Header().Set("Retry-After", limiter.RetryAfter())
+				return handlers.ErrorJSON(c, http.StatusTooManyRequests, handlers.CodeRateLimited, limiter.Message())
+			}
+			return next(c)
+		}
+	}
+}
+
+// CommandLimiter allows each session to run at most max commands at a time
+// through the command routes. It is shared by the REST and gRPC APIs;
+// background processes are capped separately by TERMINAL_MAX_PROCESSES.
+type CommandLimiter struct {
+	max     int
+	mutex   sync.Mutex
+	running map[string]int
+}
+
+// NewCommandLimiter returns a limiter of max concurrent commands per session
+func NewCommandLimiter(max int) *CommandLimiter {
+	return &CommandLimiter{max: max, running: make(map[string]int)}
+}
+
+// Limits reports whether route, such as "POST /sessions/:sessionId/commands",
+// runs a command that counts towards the limit
+func (l *CommandLimiter) Limits(route string) bool {
+	return commandRoutes[route]
+}
+
+// Acquire reserves one of the session's command slots, reporting false when
+// they are all taken. Every successful Acquire must be followed by Release.
+func (l *CommandLimiter) Acquire(sessionID string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.running[sessionID] >= l.max {
+		return false
+	}
+	l.running[sessionID]++
+	return true
+}
+
+// Release frees a command slot reserved by Acquire
+func (l *CommandLimiter) Release(sessionID string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.running[sessionID]--; l.running[sessionID] <= 0 {
+		delete(l.running, sessionID)
+	}
+}
+
+// Message describes the limit to callers that reached it
+func (l *CommandLimiter) Message() string {
+	return fmt.Sprintf("session reached its limit of %d concurrent commands", l.max)
+}
+
+// CommandConcurrency applies limiter to the command routes. Further
+// commands get 429 with Retry-After: 1 until one finishes.
+func CommandConcurrency(limiter *CommandLimiter) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			sessionID := c.Param("sessionId")
+			if sessionID == "" || !limiter.Limits(c.Request().Method+" "+c.Path()) {
+				return next(c)
+			}
+
+			if !limiter.Acquire(sessionID) {
+				c.Response().Header().Set("Retry-After", "1")
+				return handlers.ErrorJSON(c, http.StatusTooManyRequests, handlers.CodeCommandLimitReached, limiter.Message())
+			}
+			defer limiter.Release(sessionID)
+			return next(c)
+		}
+	}
+}
+
+// rateLimitCaller identifies who a request is counted against: its session,
+// else its API key, else its client address
+func rateLimitCaller(c echo.Context) string {
+	if sessionID := c.Param("sessionId"); sessionID != "" {
+		return "session:" + sessionID
+	}
+	if sessionID := c.QueryParam("sessionId"); sessionID != "" {
+		return "session:" + sessionID
+	}
+	if principal := handlers.PrincipalFromContext(c); principal != nil {
+		return "key:" + principal.Name
+	}
+	return "ip:" + c.RealIP()
+}
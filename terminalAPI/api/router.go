@@ -1,60 +1,203 @@
 package api
 
 import (
+	"log/slog"
+
 	"github.com/labstack/echo/v4"
 	"terminalAPI/api/handlers"
+	"terminalAPI/config"
 	"terminalAPI/services"
 )
 
-func SetupRoutes(e *echo.Echo, sm *services.SessionManager) {
+// Services are the services behind the routes that the gRPC API shares
+type Services struct {
+	Commands  *services.CommandService
+	Processes *services.ProcessService
+}
+
+func SetupRoutes(e *echo.Echo, sm *services.SessionManager, policy *services.PolicyService, cfg *config.Config) *Services {
 	// Create services
-	hs := services.NewHistoryService(1000)
+	audit := sm.AuditService()
+	hs := services.NewHistoryService(cfg.HistorySize, audit)
+	if err := hs.EnablePersistence(); err != nil {
+		slog.Warn("command history will not be persisted", "error", err)
+	}
 	cs := services.NewCommandService(sm, hs)
 	ps := services.NewProcessService(sm, hs)
+	ps.SetMaxOutputLines(cfg.OutputBufferLines)
 	es := services.NewEnvService(sm)
+	as := services.NewAnalysisService(sm)
+	ts := services.NewTemplateService(sm, cs)
+	ss := services.NewSchedulerService(sm, cs)
+	rs := services.NewRecordingService(sm)
+	snaps := services.NewSnapshotService(sm, hs)
+	pkgs := services.NewPackageService(audit)
+	ds := services.NewDockerService(audit)
+	storage := services.NewStorageService(sm)
+	secrets, err := services.NewSecretService(sm)
+	if err != nil {
+		slog.Warn("secret storage is disabled", "error", err)
+	}
 	
 	// Create handlers
 	sessionHandler := handlers.NewSessionHandler(sm)
 	commandHandler := handlers.NewCommandHandler(cs)
 	processHandler := handlers.NewProcessHandler(ps)
 	envHandler := handlers.NewEnvHandler(es)
-	historyHandler := handlers.NewHistoryHandler(hs)
+	historyHandler := handlers.NewHistoryHandler(hs, sm)
+	analysisHandler := handlers.NewAnalysisHandler(as)
+	templateHandler := handlers.NewTemplateHandler(ts)
+	schedulerHandler := handlers.NewSchedulerHandler(ss)
+	recordingHandler := handlers.NewRecordingHandler(rs)
+	snapshotHandler := handlers.NewSnapshotHandler(snaps)
+	packageHandler := handlers.NewPackageHandler(pkgs)
+	dockerHandler := handlers.NewDockerHandler(ds)
+	storageHandler := handlers.NewStorageHandler(storage)
 	systemHandler := handlers.NewSystemHandlerWithSessionManager(sm)  // Use the new constructor
+	policyHandler := handlers.NewPolicyHandler(policy)
+	auditHandler := handlers.NewAuditHandler(audit)
+	configHandler := handlers.NewConfigHandler(cfg)
 	
 	// Session routes
 	e.POST("/sessions", sessionHandler.CreateSession)
 	e.GET("/sessions/:sessionId", sessionHandler.GetSession)
+	e.PATCH("/sessions/:sessionId", sessionHandler.UpdateSession)
+	e.POST("/sessions/:sessionId/touch", sessionHandler.TouchSession)
 	e.DELETE("/sessions/:sessionId", sessionHandler.DeleteSession)
 	e.PUT("/sessions/:sessionId/cwd", sessionHandler.SetWorkingDirectory)
+	e.GET("/sessions/:sessionId/activity", sessionHandler.GetActivity)
 	e.GET("/sessions", sessionHandler.ListSessions)
+	e.PUT("/sessions/:sessionId/shell", sessionHandler.SetShell)
+	e.DELETE("/sessions/:sessionId/shell", sessionHandler.ResetShell)
+	e.GET("/sessions/:sessionId/limits", sessionHandler.GetLimits)
+	e.GET("/sessions/:sessionId/aliases", sessionHandler.ListAliases)
+	e.PUT("/sessions/:sessionId/aliases/:name", sessionHandler.SetAlias)
+	e.DELETE("/sessions/:sessionId/aliases/:name", sessionHandler.DeleteAlias)
+	
+	// Snapshot routes, to hand a session over to another host
+	e.GET("/sessions/:sessionId/snapshot", snapshotHandler.ExportSession)
+	e.POST("/sessions/import", snapshotHandler.ImportSession)
 	
 	// Command routes
 	e.POST("/sessions/:sessionId/commands", commandHandler.ExecuteCommand)
 	e.POST("/sessions/:sessionId/commands/batch", commandHandler.ExecuteBatchCommands)
+	e.POST("/sessions/:sessionId/commands/analyze", analysisHandler.AnalyzeCommand)
+	e.GET("/sessions/:sessionId/tools", commandHandler.DiscoverTools)
+	
+	// Template routes
+	e.POST("/sessions/:sessionId/templates", templateHandler.RegisterTemplate)
+	e.GET("/sessions/:sessionId/templates", templateHandler.ListTemplates)
+	e.GET("/sessions/:sessionId/templates/:name", templateHandler.GetTemplate)
+	e.DELETE("/sessions/:sessionId/templates/:name", templateHandler.DeleteTemplate)
+	e.POST("/sessions/:sessionId/templates/:name/execute", templateHandler.ExecuteTemplate)
+	
+	// Schedule routes
+	e.POST("/sessions/:sessionId/schedules", schedulerHandler.CreateJob)
+	e.GET("/sessions/:sessionId/schedules", schedulerHandler.ListJobs)
+	e.GET("/sessions/:sessionId/schedules/:jobId", schedulerHandler.GetJob)
+	e.PUT("/sessions/:sessionId/schedules/:jobId", schedulerHandler.UpdateJob)
+	e.DELETE("/sessions/:sessionId/schedules/:jobId", schedulerHandler.DeleteJob)
+	
+	// Recording routes
+	e.POST("/sessions/:sessionId/recording", recordingHandler.StartRecording)
+	e.GET("/sessions/:sessionId/recording", recordingHandler.GetRecording)
+	e.DELETE("/sessions/:sessionId/recording", recordingHandler.StopRecording)
+	e.GET("/sessions/:sessionId/recordings", recordingHandler.ListRecordings)
+	e.GET("/sessions/:sessionId/recordings/:name", recordingHandler.DownloadRecording)
 	
 	// Process routes
 	e.POST("/sessions/:sessionId/processes", processHandler.StartProcess)
 	e.GET("/sessions/:sessionId/processes", processHandler.ListProcesses)
+	e.DELETE("/sessions/:sessionId/processes", processHandler.DeleteProcesses)
+	e.POST("/sessions/:sessionId/processes/kill-all", processHandler.KillAllProcesses)
 	e.GET("/sessions/:sessionId/processes/:processId", processHandler.GetProcess)
+	e.DELETE("/sessions/:sessionId/processes/:processId", processHandler.DeleteProcess)
 	e.GET("/sessions/:sessionId/processes/:processId/output", processHandler.GetProcessOutput)
+	e.GET("/sessions/:sessionId/processes/:processId/output/raw", processHandler.DownloadRawOutput)
+	e.GET("/sessions/:sessionId/processes/:processId/events", processHandler.StreamProcessEvents)
+	e.GET("/sessions/:sessionId/processes/:processId/stats", processHandler.GetProcessStats)
+	e.GET("/sessions/:sessionId/processes/:processId/auto-responses", processHandler.GetAutoResponses)
 	e.POST("/sessions/:sessionId/processes/:processId/input", processHandler.SendProcessInput)
 	e.POST("/sessions/:sessionId/processes/:processId/signal", processHandler.SignalProcess)
+	e.POST("/sessions/:sessionId/processes/:processId/log/rotate", processHandler.RotateLog)
+	
+	// Process log routes
+	e.GET("/sessions/:sessionId/logs", processHandler.ListLogs)
+	e.GET("/sessions/:sessionId/logs/:name", processHandler.DownloadLog)
 	
 	// Environment routes
 	e.GET("/sessions/:sessionId/env", envHandler.GetEnvVars)
 	e.PUT("/sessions/:sessionId/env", envHandler.SetBatchEnvVars)
 	e.PUT("/sessions/:sessionId/env/:key", envHandler.SetEnvVar)
 	e.DELETE("/sessions/:sessionId/env/:key", envHandler.UnsetEnvVar)
+	e.POST("/sessions/:sessionId/env/load-dotenv", envHandler.LoadDotenv)
+	e.POST("/sessions/:sessionId/env/profiles", envHandler.SaveProfile)
+	e.POST("/sessions/:sessionId/env/profiles/:name/apply", envHandler.ApplyProfile)
+	
+	// Environment profile routes, shared by all sessions
+	e.GET("/env/profiles", envHandler.ListProfiles)
+	e.GET("/env/profiles/:name", envHandler.GetProfile)
+	e.DELETE("/env/profiles/:name", envHandler.DeleteProfile)
+	
+	// Secret routes, shared by all sessions; values can be set but never read
+	if secrets != nil {
+		secretHandler := handlers.NewSecretHandler(secrets)
+		e.PUT("/secrets/:name", secretHandler.SetSecret)
+		e.GET("/secrets", secretHandler.ListSecrets)
+		e.DELETE("/secrets/:name", secretHandler.DeleteSecret)
+	}
+	
+	// Object storage routes, signed with credentials from secrets
+	e.POST("/sessions/:sessionId/storage/list", storageHandler.ListObjects)
+	e.POST("/sessions/:sessionId/storage/upload", storageHandler.Upload)
+	e.POST("/sessions/:sessionId/storage/download", storageHandler.Download)
 	
 	// History routes
 	e.GET("/sessions/:sessionId/history", historyHandler.GetHistory)
 	e.GET("/sessions/:sessionId/history/search", historyHandler.SearchHistory)
+	e.GET("/sessions/:sessionId/history/export", historyHandler.ExportHistory)
+	e.GET("/sessions/:sessionId/history/stats", historyHandler.GetStats)
+	e.POST("/sessions/:sessionId/history/import", historyHandler.ImportHistory)
 	e.DELETE("/sessions/:sessionId/history", historyHandler.ClearHistory)
 	
 	// System routes
 	e.GET("/system/info", systemHandler.GetSystemInfo)
 	e.GET("/system/shells", systemHandler.GetAvailableShells)
+	e.GET("/system/hardware", systemHandler.GetHardware)
+	e.GET("/system/processes", systemHandler.GetProcesses)
+	e.GET("/system/network/ports", systemHandler.GetListeningPorts)
+	e.GET("/system/packages/search", packageHandler.SearchPackages)
+	e.GET("/system/packages/installed", packageHandler.ListInstalledPackages)
+	e.POST("/system/packages/install", packageHandler.InstallPackages)
+	
+	// Docker routes, served through the Docker socket
+	e.GET("/docker/containers", dockerHandler.ListContainers)
+	e.GET("/docker/images", dockerHandler.ListImages)
+	e.GET("/docker/containers/:container/logs", dockerHandler.GetLogs)
+	e.POST("/docker/containers/:container/exec", dockerHandler.Exec)
+	e.POST("/docker/containers/:container/start", dockerHandler.StartContainer)
+	e.POST("/docker/containers/:container/stop", dockerHandler.StopContainer)
+	
+	// Access policy routes, always restricted to admin keys
+	e.GET("/policy", policyHandler.GetPolicy)
+	e.PUT("/policy", policyHandler.SetPolicy)
+	
+	// Audit log routes, admin only by default
+	e.GET("/audit", auditHandler.QueryAudit)
+	e.GET("/events", auditHandler.StreamEvents)
+	
+	// Effective configuration
+	e.GET("/config", configHandler.GetConfig)
+	
+	// API description, a browser for it and LLM tool definitions, open
+	// without an API key
+	e.GET("/openapi.json", OpenAPI(e, "Terminal API", "1.0.0"))
+	e.GET("/docs", SwaggerUI)
+	e.GET("/tools/schema", ToolSchemas(e))
+	e.GET("/version", Version("terminalAPI"))
 	
 	// Make sure the session-specific endpoint for shells is registered before other routes
 	e.GET("/sessions/:sessionId/system/shells", systemHandler.GetAvailableShells)
+	
+	return &Services{Commands: cs, Processes: ps}
 }
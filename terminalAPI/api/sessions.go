@@ -0,0 +1,45 @@
+package api
+
+import (
+	"log/slog"
+
+	"github.com/labstack/echo/v4"
+	"terminalAPI/services"
+)
+
+// NodeHeader names the replica that served a request
+const NodeHeader = "X-OSAI-Node"
+
+// SessionSync lets replicas behind a load balancer share sessions through a
+// shared session store: the session a request names is loaded before the
+// request and saved after it. Processes and persistent shells stay on the
+// replica that started them, so requests for them must reach that replica,
+// named in the X-OSAI-Node header of every response.
+func SessionSync(sm *services.SessionManager) echo.MiddlewareFunc {
+	node := sm.Node()
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Response().Header().Set(NodeHeader, node)
+
+			sessionID := requestSessionID(c)
+			if sessionID == "" {
+				return next(c)
+			}
+			sm.SyncSession(sessionID)
+			err := next(c)
+			if saveErr := sm.SaveSession(sessionID); saveErr != nil {
+				slog.Warn("failed to save session to store", services.LogKeySession, sessionID, "error", saveErr)
+			}
+			return err
+		}
+	}
+}
+
+// requestSessionID returns the session a request names, if any
+func requestSessionID(c echo.Context) string {
+	if sessionID := c.Param("sessionId"); sessionID != "" {
+		return sessionID
+	}
+	// Some terminalAPI routes take the session as a query parameter
+	return c.QueryParam("sessionId")
+}
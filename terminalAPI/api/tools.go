@@ -0,0 +1,209 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"terminalAPI/api/handlers"
+)
+
+// metaRoutes describe the API rather than use it, so they are not tools
+var metaRoutes = map[string]bool{
+	"GET /openapi.json": true,
+	"GET /docs":         true,
+	"GET /version":      true,
+	"GET /tools/schema": true,
+}
+
+// maxToolSchemaDepth bounds how deeply referenced schemas are inlined, which
+// only recursive types reach
+const maxToolSchemaDepth = 10
+
+// ToolSchemas serves the routes registered on e as function-calling tool
+// definitions for LLMs, in the format named by the format query parameter:
+// openai (the default), anthropic or gemini
+func ToolSchemas(e *echo.Echo) echo.HandlerFunc {
+	var once sync.Once
+	var tools map[string]interface{}
+	var count int
+	return func(c echo.Context) error {
+		format := c.QueryParam("format")
+		if format == "" {
+			format = "openai"
+		}
+		once.Do(func() {
+			tools, count = buildToolSchemas(e.Routes())
+		})
+		formatted, ok := tools[format]
+		if !ok {
+			return handlers.ErrorJSON(c, http.StatusBadRequest, handlers.CodeInvalidRequest,
+				"Invalid format parameter, expected openai, anthropic or gemini")
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"format": format,
+			"tools":  formatted,
+			"count":  count,
+		})
+	}
+}
+
+// buildToolSchemas returns the tools of routes in each format, and how many
+// there are
+func buildToolSchemas(routes []*echo.Route) (map[string]interface{}, int) {
+	b := newOpenAPIBuilder()
+	var openai, anthropic, gemini []interface{}
+	for _, op := range b.operations(routes) {
+		if metaRoutes[op.Method+" "+op.Path] {
+			continue
+		}
+		description := op.Summary + ". Calls " + op.Method + " /" + APIVersion + op.Path + "."
+		parameters := b.toolParameters(op)
+
+		openai = append(openai, map[string]interface{}{
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":        op.ID,
+				"description": description,
+				"parameters":  parameters,
+			},
+		})
+		anthropic = append(anthropic, map[string]interface{}{
+			"name":         op.ID,
+			"description":  description,
+			"input_schema": parameters,
+		})
+		declaration := map[string]interface{}{
+			"name":        op.ID,
+			"description": description,
+		}
+		// Gemini takes no parameters rather than an empty object
+		if adapted := geminiSchema(parameters); !emptyObject(adapted) {
+			declaration["parameters"] = adapted
+		}
+		gemini = append(gemini, declaration)
+	}
+
+	return map[string]interface{}{
+		"openai":    openai,
+		"anthropic": anthropic,
+		// Gemini groups declarations in a single tool
+		"gemini": []interface{}{
+			map[string]interface{}{"functionDeclarations": gemini},
+		},
+	}, len(openai)
+}
+
+// toolParameters describes the arguments of a tool: its path parameters,
+// its request body as body, and for GET requests optional query parameters
+func (b *openAPIBuilder) toolParameters(op apiOperation) map[string]interface{} {
+	properties := make(map[string]interface{})
+	required := []string{}
+	for _, param := range op.Params {
+		properties[param] = map[string]interface{}{
+			"type":        "string",
+			"description": "Path parameter " + param,
+		}
+		required = append(required, param)
+	}
+	if op.Body != nil {
+		body := b.inline(op.Body, 0)
+		body["description"] = "JSON request body"
+		properties["body"] = body
+		required = append(required, "body")
+	}
+	if op.Method == http.MethodGet {
+		properties["query"] = map[string]interface{}{
+			"type":                 "object",
+			"description":          "Query parameters, as described in the API reference",
+			"additionalProperties": map[string]interface{}{"type": "string"},
+		}
+	}
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+// inline copies a schema with its references to components replaced by the
+// schemas they name, as tool definitions cannot refer to other schemas
+func (b *openAPIBuilder) inline(schema map[string]interface{}, depth int) map[string]interface{} {
+	if ref, ok := schema["$ref"].(string); ok {
+		target, _ := b.schemas[strings.TrimPrefix(ref, "#/components/schemas/")].(map[string]interface{})
+		if target == nil || depth >= maxToolSchemaDepth {
+			return map[string]interface{}{"type": "object"}
+		}
+		return b.inline(target, depth+1)
+	}
+
+	copied := make(map[string]interface{}, len(schema))
+	for key, value := range schema {
+		switch value := value.(type) {
+		case map[string]interface{}:
+			if key == "properties" {
+				properties := make(map[string]interface{}, len(value))
+				for name, property := range value {
+					properties[name] = b.inline(property.(map[string]interface{}), depth)
+				}
+				copied[key] = properties
+			} else {
+				copied[key] = b.inline(value, depth)
+			}
+		default:
+			copied[key] = value
+		}
+	}
+	return copied
+}
+
+// geminiSchema adapts a schema to the OpenAPI subset Gemini accepts, which
+// has no additionalProperties, few formats and no untyped values. Objects
+// left without properties, such as maps, are dropped as Gemini rejects them.
+func geminiSchema(schema map[string]interface{}) map[string]interface{} {
+	adapted := make(map[string]interface{}, len(schema))
+	for key, value := range schema {
+		switch key {
+		case "additionalProperties":
+			continue
+		case "format":
+			if value != "date-time" {
+				continue
+			}
+		case "items":
+			value = geminiSchema(value.(map[string]interface{}))
+		case "properties":
+			properties := make(map[string]interface{})
+			for name, property := range value.(map[string]interface{}) {
+				if property := geminiSchema(property.(map[string]interface{})); !emptyObject(property) {
+					properties[name] = property
+				}
+			}
+			value = properties
+		}
+		adapted[key] = value
+	}
+	if _, typed := adapted["type"]; !typed {
+		adapted["type"] = "object"
+	}
+
+	// Only properties that were kept can be required
+	if required, ok := adapted["required"].([]string); ok {
+		properties, _ := adapted["properties"].(map[string]interface{})
+		kept := []string{}
+		for _, name := range required {
+			if _, ok := properties[name]; ok {
+				kept = append(kept, name)
+			}
+		}
+		adapted["required"] = kept
+	}
+	return adapted
+}
+
+// emptyObject reports whether a schema is an object without properties
+func emptyObject(schema map[string]interface{}) bool {
+	properties, _ := schema["properties"].(map[string]interface{})
+	return schema["type"] == "object" && len(properties) == 0
+}
@@ -0,0 +1,186 @@
+// Package app assembles the terminal API from its configuration: the
+// session manager, authentication, middleware, routes and gRPC server. The
+// terminalAPI command serves it on its own, and the osai command alongside
+// fileAPI.
+package app
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"google.golang.org/grpc"
+	"terminalAPI/api"
+	"terminalAPI/api/handlers"
+	"terminalAPI/config"
+	"terminalAPI/rpc"
+	"terminalAPI/services"
+)
+
+// App is a configured terminal API, ready to serve
+type App struct {
+	Config   *config.Config
+	Sessions *services.SessionManager
+	// REST API
+	Echo *echo.Echo
+	// gRPC API, nil unless Config.GRPCPort is set
+	GRPC *grpc.Server
+}
+
+// New loads the configuration and builds the API. Logging must be
+// configured first.
+func New() (*App, error) {
+	// Audit log of operations, shared directory with fileAPI
+	audit := services.NewAuditService()
+	if err := audit.EnablePersistence(); err != nil {
+		slog.Warn("audit log will not be persisted", "error", err)
+	}
+
+	// Settings from ~/.osai/config.json or OSAI_CONFIG_FILE, overridden by
+	// environment variables
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if cfg.File != "" {
+		slog.Info("loaded configuration", "path", cfg.File)
+	}
+
+	// Initialize session manager
+	sessionManager := services.NewSessionManager()
+	sessionManager.SetAuditService(audit)
+	if err := sessionManager.EnableActivityPersistence(); err != nil {
+		slog.Warn("session activity will not be persisted", "error", err)
+	}
+	sessionManager.SetSessionExpiry(time.Duration(cfg.SessionExpiry))
+	sessionManager.SetDefaultShell(cfg.DefaultShell)
+	sessionManager.SetMaxProcesses(cfg.MaxProcesses)
+	sessionManager.SetProcessRetention(time.Duration(cfg.ProcessRetention), cfg.MaxCompletedProcesses)
+	sessionManager.SetNode(cfg.Node)
+
+	// Sessions shared by replicas behind a load balancer
+	if cfg.RedisURL != "" {
+		store, err := services.NewRedisSessionStore(cfg.RedisURL, "osai:terminal:session:")
+		if err != nil {
+			return nil, err
+		}
+		sessionManager.SetSessionStore(store)
+		slog.Info("sessions shared through Redis", "node", cfg.Node)
+	}
+
+	// Session lifecycle events posted to orchestrators
+	if len(cfg.SessionWebhooks) > 0 {
+		webhooks, err := services.NewSessionWebhooks(cfg.SessionWebhooks, cfg.WebhookSecret)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sessionWebhooks: %w", err)
+		}
+		sessionManager.OnSessionEvent(webhooks.Send)
+		slog.Info("session webhooks enabled", "urls", len(cfg.SessionWebhooks))
+	}
+
+	// Directories sessions may work in, shared with fileAPI
+	if len(cfg.AllowedDirs) > 0 {
+		if err := sessionManager.SetAllowedDirs(cfg.AllowedDirs); err != nil {
+			return nil, fmt.Errorf("invalid allowed directories: %w", err)
+		}
+		cfg.AllowedDirs = sessionManager.AllowedDirs()
+		slog.Info("working directories restricted", "allowedDirs", cfg.AllowedDirs)
+	}
+
+	// Initialize the Echo instance
+	e := echo.New()
+	// Startup is logged through the structured logger instead
+	e.HideBanner = true
+	e.HidePort = true
+	// Errors of routes and middleware get the same body as the handlers'
+	e.HTTPErrorHandler = handlers.HTTPErrorHandler
+
+	// Routes are served under /v1, and still at their unversioned paths
+	e.Pre(api.Versioning())
+
+	// Middleware
+	e.Use(middleware.RequestID())
+	e.Use(api.RequestLogger())
+	e.Use(middleware.Recover())
+	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{AllowOrigins: cfg.CORSOrigins}))
+
+	// Access policy and API key authentication, shared with fileAPI through
+	// OSAI_POLICY_FILE and OSAI_API_KEYS
+	policy, err := services.NewPolicyService(audit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load access policy: %w", err)
+	}
+	keys, err := api.LoadAPIKeys(policy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OSAI_API_KEYS: %w", err)
+	}
+	if len(keys) > 0 {
+		e.Use(api.KeyAuth(keys, policy))
+	}
+	// Sessions are loaded from a shared store before their owner is checked
+	e.Use(api.SessionSync(sessionManager))
+	if len(keys) > 0 {
+		e.Use(api.SessionOwnership(sessionManager))
+		slog.Info("API key authentication enabled", "keys", len(keys))
+	} else {
+		slog.Warn("OSAI_API_KEYS is not set, the API is open to anyone who can reach it")
+	}
+
+	// Request and command limits per session, the rate limit being shared
+	// with fileAPI. The gRPC API counts towards the same limits.
+	var rateLimiter *api.RateLimiter
+	if cfg.RateLimit > 0 {
+		rateLimiter = api.NewRateLimiter(cfg.RateLimit)
+		e.Use(api.RateLimit(rateLimiter))
+		slog.Info("rate limiting enabled", "requestsPerMinute", cfg.RateLimit)
+	}
+	var commandLimiter *api.CommandLimiter
+	if cfg.MaxConcurrentCommands > 0 {
+		commandLimiter = api.NewCommandLimiter(cfg.MaxConcurrentCommands)
+		e.Use(api.CommandConcurrency(commandLimiter))
+	}
+
+	// Setup routes
+	svc := api.SetupRoutes(e, sessionManager, policy, cfg)
+
+	a := &App{Config: cfg, Sessions: sessionManager, Echo: e}
+	// gRPC API on its own port, sharing sessions, keys and policy
+	if cfg.GRPCPort > 0 {
+		a.GRPC = rpc.NewServer(sessionManager, svc.Commands, svc.Processes, keys, policy, rateLimiter, commandLimiter)
+	}
+	return a, nil
+}
+
+// Start serves the REST API, and the gRPC API when enabled, until either
+// stops
+func (a *App) Start() error {
+	errs := make(chan error, 2)
+	if err := a.StartGRPC(errs); err != nil {
+		return err
+	}
+	go func() {
+		slog.Info("starting Terminal API server", "port", a.Config.Port)
+		errs <- a.Echo.Start(fmt.Sprintf(":%d", a.Config.Port))
+	}()
+	return <-errs
+}
+
+// StartGRPC serves the gRPC API in the background when it is enabled,
+// sending to errs when it stops
+func (a *App) StartGRPC(errs chan<- error) error {
+	if a.GRPC == nil {
+		return nil
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", a.Config.GRPCPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen for gRPC: %w", err)
+	}
+	go func() {
+		slog.Info("starting Terminal gRPC server", "port", a.Config.GRPCPort)
+		errs <- fmt.Errorf("gRPC server stopped: %w", a.GRPC.Serve(listener))
+	}()
+	return nil
+}
@@ -0,0 +1,277 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"terminalAPI/services"
+)
+
+// Config holds the settings of the terminal API. Values come from the
+// defaults, then the config file, then environment variables. Secrets are
+// left out of its JSON, so it is served as is by GET /config.
+type Config struct {
+	Port int `json:"port"`
+	// Port of the gRPC API; 0 disables it
+	GRPCPort    int      `json:"grpcPort"`
+	CORSOrigins []string `json:"corsOrigins"`
+	// Directories sessions may work in; empty allows any directory
+	AllowedDirs []string `json:"allowedDirs"`
+	// Requests per minute per session and endpoint; 0 disables the limit
+	RateLimit     int      `json:"rateLimit"`
+	SessionExpiry Duration `json:"sessionExpiry"`
+	// Shell of new sessions; empty uses $SHELL, falling back to /bin/bash
+	DefaultShell string `json:"defaultShell"`
+	// Commands kept in each session's history
+	HistorySize int `json:"historySize"`
+	// Lines of output kept in memory for each background process
+	OutputBufferLines     int      `json:"outputBufferLines"`
+	MaxProcesses          int      `json:"maxProcesses"`
+	ProcessRetention      Duration `json:"processRetention"`
+	MaxCompletedProcesses int      `json:"maxCompletedProcesses"`
+	// Commands a session may run at once; 0 disables the limit
+	MaxConcurrentCommands int `json:"maxConcurrentCommands"`
+	// Redis server replicas share sessions through, such as
+	// redis://:password@host:6379/0; empty keeps sessions in memory. Not
+	// served, as it may hold a password.
+	RedisURL string `json:"-"`
+	// Where sessions are stored, "memory" or "redis"
+	SessionStore string `json:"sessionStore"`
+	// URLs session lifecycle events are posted to, and the key their
+	// payloads are signed with. Not served, as they may hold credentials.
+	SessionWebhooks []string `json:"-"`
+	WebhookSecret   string   `json:"-"`
+	// Name of this replica, defaulting to the host name
+	Node string `json:"node"`
+	// Config file the values were read from, if any
+	File string `json:"file,omitempty"`
+}
+
+// Duration is a time.Duration written as a string such as "24h" in the
+// config file and API
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return errors.New("durations must be strings such as \"30m\"")
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Default returns the settings used when nothing is configured
+func Default() *Config {
+	node, _ := os.Hostname()
+	return &Config{
+		Port:                  8081,
+		CORSOrigins:           []string{"*"},
+		SessionExpiry:         Duration(services.DefaultSessionExpiry),
+		HistorySize:           1000,
+		OutputBufferLines:     services.DefaultMaxOutputLines,
+		MaxProcesses:          services.DefaultMaxProcesses,
+		ProcessRetention:      Duration(services.DefaultProcessRetention),
+		MaxCompletedProcesses: services.DefaultMaxCompletedProcesses,
+		Node:                  node,
+	}
+}
+
+// FilePath returns the config file shared with fileAPI: OSAI_CONFIG_FILE,
+// or ~/.osai/config.json
+func FilePath() string {
+	if path := os.Getenv("OSAI_CONFIG_FILE"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".osai", "config.json")
+}
+
+// Load reads the configuration. The config file is optional unless named by
+// OSAI_CONFIG_FILE. Its top-level corsOrigins, allowedDirs, rateLimit,
+// redisUrl, sessionWebhooks and webhookSecret apply to both services, node
+// names the replica, and its "terminal" object holds the settings of this
+// one.
+func Load() (*Config, error) {
+	cfg := Default()
+
+	path := FilePath()
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := cfg.parseFile(data); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+		cfg.File = path
+	case !errors.Is(err, os.ErrNotExist) || os.Getenv("OSAI_CONFIG_FILE") != "":
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	cfg.SessionStore = "memory"
+	if cfg.RedisURL != "" {
+		cfg.SessionStore = "redis"
+	}
+	return cfg, nil
+}
+
+// parseFile applies the shared settings of a config file, then this
+// service's section
+func (cfg *Config) parseFile(data []byte) error {
+	var file struct {
+		CORSOrigins []string        `json:"corsOrigins"`
+		AllowedDirs []string        `json:"allowedDirs"`
+		RateLimit   int             `json:"rateLimit"`
+		RedisURL    string          `json:"redisUrl"`
+		Webhooks    []string        `json:"sessionWebhooks"`
+		Secret      string          `json:"webhookSecret"`
+		Node        string          `json:"node"`
+		Terminal    json.RawMessage `json:"terminal"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	if file.CORSOrigins != nil {
+		cfg.CORSOrigins = file.CORSOrigins
+	}
+	if file.AllowedDirs != nil {
+		cfg.AllowedDirs = file.AllowedDirs
+	}
+	if file.RateLimit != 0 {
+		cfg.RateLimit = file.RateLimit
+	}
+	if file.RedisURL != "" {
+		cfg.RedisURL = file.RedisURL
+	}
+	if file.Webhooks != nil {
+		cfg.SessionWebhooks = file.Webhooks
+	}
+	if file.Secret != "" {
+		cfg.WebhookSecret = file.Secret
+	}
+	if file.Node != "" {
+		cfg.Node = file.Node
+	}
+	if file.Terminal != nil {
+		return json.Unmarshal(file.Terminal, cfg)
+	}
+	return nil
+}
+
+// applyEnv overrides settings from environment variables, keeping the
+// names the service has always read
+func (cfg *Config) applyEnv() error {
+	ints := map[string]*int{
+		"OSAI_TERMINAL_PORT":                &cfg.Port,
+		"OSAI_TERMINAL_GRPC_PORT":           &cfg.GRPCPort,
+		"OSAI_RATE_LIMIT":                   &cfg.RateLimit,
+		"OSAI_TERMINAL_HISTORY_SIZE":        &cfg.HistorySize,
+		"OSAI_TERMINAL_OUTPUT_BUFFER_LINES": &cfg.OutputBufferLines,
+		"TERMINAL_MAX_PROCESSES":            &cfg.MaxProcesses,
+		"TERMINAL_MAX_COMPLETED_PROCESSES":  &cfg.MaxCompletedProcesses,
+		"TERMINAL_MAX_CONCURRENT_COMMANDS":  &cfg.MaxConcurrentCommands,
+	}
+	for name, field := range ints {
+		if value := os.Getenv(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid %s: %s", name, value)
+			}
+			*field = n
+		}
+	}
+
+	durations := map[string]*Duration{
+		"OSAI_TERMINAL_SESSION_EXPIRY": &cfg.SessionExpiry,
+		"TERMINAL_PROCESS_RETENTION":   &cfg.ProcessRetention,
+	}
+	for name, field := range durations {
+		if value := os.Getenv(name); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("invalid %s: %s", name, value)
+			}
+			*field = Duration(d)
+		}
+	}
+
+	if value := os.Getenv("OSAI_TERMINAL_DEFAULT_SHELL"); value != "" {
+		cfg.DefaultShell = value
+	}
+	if value := os.Getenv("OSAI_CORS_ORIGINS"); value != "" {
+		cfg.CORSOrigins = splitList(value)
+	}
+	if value := os.Getenv("OSAI_ALLOWED_DIRS"); value != "" {
+		cfg.AllowedDirs = filepath.SplitList(value)
+	}
+	if value := os.Getenv("OSAI_REDIS_URL"); value != "" {
+		cfg.RedisURL = value
+	}
+	if value := os.Getenv("OSAI_SESSION_WEBHOOKS"); value != "" {
+		cfg.SessionWebhooks = splitList(value)
+	}
+	if value := os.Getenv("OSAI_WEBHOOK_SECRET"); value != "" {
+		cfg.WebhookSecret = value
+	}
+	if value := os.Getenv("OSAI_NODE"); value != "" {
+		cfg.Node = value
+	}
+	return nil
+}
+
+// validate rejects settings the service cannot run with
+func (cfg *Config) validate() error {
+	switch {
+	case cfg.Port <= 0 || cfg.Port > 65535:
+		return fmt.Errorf("invalid port: %d", cfg.Port)
+	case cfg.GRPCPort < 0 || cfg.GRPCPort > 65535 || cfg.GRPCPort == cfg.Port:
+		return fmt.Errorf("invalid grpcPort: %d", cfg.GRPCPort)
+	case cfg.SessionExpiry <= 0:
+		return errors.New("sessionExpiry must be positive")
+	case cfg.HistorySize <= 0:
+		return errors.New("historySize must be positive")
+	case cfg.OutputBufferLines <= 0:
+		return errors.New("outputBufferLines must be positive")
+	case cfg.MaxProcesses <= 0:
+		return errors.New("maxProcesses must be positive")
+	case cfg.ProcessRetention <= 0:
+		return errors.New("processRetention must be positive")
+	case cfg.MaxCompletedProcesses <= 0:
+		return errors.New("maxCompletedProcesses must be positive")
+	case cfg.RateLimit < 0 || cfg.MaxConcurrentCommands < 0:
+		return errors.New("limits must not be negative")
+	}
+	return nil
+}
+
+// splitList splits a comma-separated list, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
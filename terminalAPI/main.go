@@ -1,29 +1,32 @@
 package main
 
 import (
-	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
 	"log"
-	"terminalAPI/api"
+	"log/slog"
+	"os"
+	"terminalAPI/app"
 	"terminalAPI/services"
 )
 
 func main() {
-	// Initialize session manager
-	sessionManager := services.NewSessionManager()
-	
-	// Initialize the Echo instance
-	e := echo.New()
-	
-	// Middleware
-	e.Use(middleware.Logger())
-	e.Use(middleware.Recover())
-	e.Use(middleware.CORS())
-	
-	// Setup routes
-	api.SetupRoutes(e, sessionManager)
-	
+	// Structured logging, configured through OSAI_LOG_LEVEL, OSAI_LOG_FORMAT
+	// and OSAI_LOG_OUTPUT
+	if err := services.ConfigureLogging(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Configuration, sessions, middleware and routes
+	a, err := app.New()
+	if err != nil {
+		fatal("failed to start", "error", err)
+	}
+
 	// Start server
-	log.Println("Starting Terminal API server on port 8081...")
-	e.Logger.Fatal(e.Start(":8081"))
+	fatal("server stopped", "error", a.Start())
+}
+
+// fatal logs an error that prevents the server from running and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
@@ -0,0 +1,60 @@
+package rpc
+
+import (
+	"context"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"terminalAPI/api"
+)
+
+// limiter applies the request rate and concurrent command limits of the
+// REST API to calls, sharing their counters so a session has one budget
+// over both APIs. Either limit is nil when it is disabled.
+type limiter struct {
+	rate     *api.RateLimiter
+	commands *api.CommandLimiter
+}
+
+// unary runs after the authenticator, so the caller is known and owns the
+// session of the call
+func (l *limiter) unary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	route := routes[info.FullMethod]
+	if l.rate != nil && !l.rate.Allow(rateLimitCaller(ctx, req), route) {
+		grpc.SetHeader(ctx, metadata.Pairs("retry-after", l.rate.RetryAfter()))
+		return nil, status.Error(codes.ResourceExhausted, l.rate.Message())
+	}
+
+	sessionID := requestSessionID(req)
+	if l.commands != nil && sessionID != "" && l.commands.Limits(route) {
+		if !l.commands.Acquire(sessionID) {
+			grpc.SetHeader(ctx, metadata.Pairs("retry-after", "1"))
+			return nil, status.Error(codes.ResourceExhausted, l.commands.Message())
+		}
+		defer l.commands.Release(sessionID)
+	}
+	return handler(ctx, req)
+}
+
+// rateLimitCaller identifies who a call is counted against, as over REST:
+// its session, else its API key, else its client address
+func rateLimitCaller(ctx context.Context, req any) string {
+	if sessionID := requestSessionID(req); sessionID != "" {
+		return "session:" + sessionID
+	}
+	if principal := principalFromContext(ctx); principal != nil {
+		return "key:" + principal.Name
+	}
+	address := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		address = p.Addr.String()
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			address = host
+		}
+	}
+	return "ip:" + address
+}
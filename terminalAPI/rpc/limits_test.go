@@ -0,0 +1,89 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"terminalAPI/api"
+	"terminalAPI/rpc/terminalpb"
+	"terminalAPI/services"
+)
+
+// startServer serves the gRPC API over an in-memory connection and returns
+// a client connection to it and a session with a working directory
+func startServer(t *testing.T, rate *api.RateLimiter, commands *api.CommandLimiter) (*grpc.ClientConn, string) {
+	t.Helper()
+	sm := services.NewSessionManager()
+	hs := services.NewHistoryService(0, nil)
+	server := NewServer(sm, services.NewCommandService(sm, hs), services.NewProcessService(sm, hs), nil, nil, rate, commands)
+
+	listener := bufconn.Listen(1 << 20)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	session, err := sm.CreateSession(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sm.DeleteSession(session.ID) })
+	if err := sm.SetWorkingDirectory(session.ID, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	return conn, session.ID
+}
+
+func TestExecuteCommandIsRateLimited(t *testing.T) {
+	conn, sessionID := startServer(t, api.NewRateLimiter(1), nil)
+	client := terminalpb.NewCommandServiceClient(conn)
+	request := &terminalpb.ExecuteCommandRequest{SessionId: sessionID, Command: "true"}
+
+	if _, err := client.ExecuteCommand(context.Background(), request); err != nil {
+		t.Fatalf("first command: %v", err)
+	}
+	_, err := client.ExecuteCommand(context.Background(), request)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("got %v, want ResourceExhausted over the rate limit", err)
+	}
+
+	// Other routes have their own budget, as over REST
+	processes := terminalpb.NewProcessServiceClient(conn)
+	if _, err := processes.ListProcesses(context.Background(), &terminalpb.ListProcessesRequest{SessionId: sessionID}); err != nil {
+		t.Errorf("list processes: %v", err)
+	}
+}
+
+func TestExecuteCommandSharesCommandLimit(t *testing.T) {
+	commands := api.NewCommandLimiter(1)
+	conn, sessionID := startServer(t, nil, commands)
+	client := terminalpb.NewCommandServiceClient(conn)
+	request := &terminalpb.ExecuteCommandRequest{SessionId: sessionID, Command: "true"}
+
+	// A command running through the REST API holds the session's only slot
+	if !commands.Acquire(sessionID) {
+		t.Fatal("could not reserve the command slot")
+	}
+	_, err := client.ExecuteCommand(context.Background(), request)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("got %v, want ResourceExhausted at the command limit", err)
+	}
+
+	commands.Release(sessionID)
+	if _, err := client.ExecuteCommand(context.Background(), request); err != nil {
+		t.Fatalf("command after the slot was released: %v", err)
+	}
+}
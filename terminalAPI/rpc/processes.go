@@ -0,0 +1,176 @@
+package rpc
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"terminalAPI/rpc/terminalpb"
+	"terminalAPI/services"
+)
+
+type commandServer struct {
+	terminalpb.UnimplementedCommandServiceServer
+	commandService *services.CommandService
+}
+
+func (s *commandServer) ExecuteCommand(ctx context.Context, req *terminalpb.ExecuteCommandRequest) (*terminalpb.CommandOutput, error) {
+	output, err := s.commandService.ExecuteCommand(req.SessionId, &services.CommandRequest{
+		Command:     req.Command,
+		Timeout:     int(req.Timeout),
+		Environment: req.Environment,
+		Persistent:  req.Persistent,
+		RunAs:       req.RunAs,
+		Cwd:         req.Cwd,
+		Secrets:     req.Secrets,
+	})
+	if err != nil {
+		return nil, statusError(codes.Internal, err)
+	}
+	return &terminalpb.CommandOutput{
+		ExitCode:        int32(output.ExitCode),
+		Stdout:          output.Stdout,
+		Stderr:          output.Stderr,
+		ExecutionTime:   output.ExecutionTime,
+		Command:         output.Command,
+		ExpandedCommand: output.ExpandedCommand,
+		WorkingDir:      output.WorkingDir,
+		OutputTruncated: output.OutputTruncated,
+		Cached:          output.Cached,
+	}, nil
+}
+
+type processServer struct {
+	terminalpb.UnimplementedProcessServiceServer
+	processService *services.ProcessService
+}
+
+func (s *processServer) StartProcess(ctx context.Context, req *terminalpb.StartProcessRequest) (*terminalpb.Process, error) {
+	info, err := s.processService.StartProcess(req.SessionId, &services.CommandRequest{
+		Command:     req.Command,
+		Environment: req.Environment,
+		RunAs:       req.RunAs,
+		Cwd:         req.Cwd,
+		Secrets:     req.Secrets,
+		Raw:         req.Raw,
+		LogToFile:   req.LogToFile,
+	})
+	if errors.Is(err, services.ErrProcessLimitReached) {
+		return nil, statusError(codes.ResourceExhausted, err)
+	}
+	if err != nil {
+		return nil, statusError(codes.Internal, err)
+	}
+	return toProcess(info), nil
+}
+
+func (s *processServer) ListProcesses(ctx context.Context, req *terminalpb.ListProcessesRequest) (*terminalpb.ListProcessesResponse, error) {
+	processes, err := s.processService.ListProcesses(req.SessionId)
+	if err != nil {
+		return nil, statusError(codes.Internal, err)
+	}
+	resp := &terminalpb.ListProcessesResponse{}
+	for _, info := range processes {
+		resp.Processes = append(resp.Processes, toProcess(info))
+	}
+	return resp, nil
+}
+
+func (s *processServer) SendInput(ctx context.Context, req *terminalpb.SendInputRequest) (*terminalpb.SendInputResponse, error) {
+	if err := s.processService.SendRawInput(req.SessionId, req.ProcessId, req.Input); err != nil {
+		return nil, statusError(codes.Internal, err)
+	}
+	return &terminalpb.SendInputResponse{}, nil
+}
+
+func (s *processServer) SignalProcess(ctx context.Context, req *terminalpb.SignalProcessRequest) (*terminalpb.SignalProcessResponse, error) {
+	tree := req.Tree == nil || *req.Tree
+	if err := s.processService.SignalProcess(req.SessionId, req.ProcessId, req.Signal, tree); err != nil {
+		return nil, statusError(codes.Internal, err)
+	}
+	return &terminalpb.SignalProcessResponse{}, nil
+}
+
+func (s *processServer) StreamOutput(req *terminalpb.StreamOutputRequest, stream terminalpb.ProcessService_StreamOutputServer) error {
+	sub, err := s.processService.SubscribeOutput(req.SessionId, req.ProcessId)
+	if err != nil {
+		return statusError(codes.NotFound, err)
+	}
+	defer sub.Close()
+
+	// Replay buffered output unless the client only wants new lines
+	if req.Replay == nil || *req.Replay {
+		now := timestamppb.Now()
+		var replay []*terminalpb.OutputEvent
+		for _, line := range sub.Stdout {
+			replay = append(replay, &terminalpb.OutputEvent{Type: "stdout", Line: line, Timestamp: now, Replay: true})
+		}
+		for _, line := range sub.Stderr {
+			replay = append(replay, &terminalpb.OutputEvent{Type: "stderr", Line: line, Timestamp: now, Replay: true})
+		}
+		if len(sub.StdoutRaw) > 0 {
+			replay = append(replay, &terminalpb.OutputEvent{Type: "stdout", Data: sub.StdoutRaw, Timestamp: now, Replay: true})
+		}
+		if len(sub.StderrRaw) > 0 {
+			replay = append(replay, &terminalpb.OutputEvent{Type: "stderr", Data: sub.StderrRaw, Timestamp: now, Replay: true})
+		}
+		for _, event := range replay {
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		select {
+		case event, ok := <-sub.Events:
+			if !ok {
+				return nil
+			}
+			if err := stream.Send(toOutputEvent(event)); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func toProcess(info *services.ProcessInfo) *terminalpb.Process {
+	return &terminalpb.Process{
+		Id:              info.ID,
+		Command:         info.Command,
+		ExpandedCommand: info.ExpandedCommand,
+		StartTime:       timestamp(info.StartTime),
+		IsRunning:       info.IsRunning,
+		ExitCode:        int32(info.ExitCode),
+		Pid:             int32(info.PID),
+		IsPaused:        info.IsPaused,
+		WaitingForInput: info.WaitingForInput,
+		LogFile:         info.LogFile,
+	}
+}
+
+func toOutputEvent(event services.OutputEvent) *terminalpb.OutputEvent {
+	// Raw mode chunks are base64 encoded for JSON, but protobuf carries bytes
+	data, _ := base64.StdEncoding.DecodeString(event.Data)
+	return &terminalpb.OutputEvent{
+		Type:      event.Type,
+		Line:      event.Line,
+		Data:      data,
+		ExitCode:  int32(event.ExitCode),
+		Timestamp: timestamp(event.Timestamp),
+		Replay:    event.Replay,
+	}
+}
+
+// timestamp converts a time, leaving the zero time unset
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
@@ -0,0 +1,255 @@
+// Package rpc serves the gRPC API, which mirrors the session, command and
+// process routes of the REST API with less overhead per call and streams
+// process output instead of requiring polling.
+package rpc
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"terminalAPI/api"
+	"terminalAPI/api/handlers"
+	"terminalAPI/rpc/terminalpb"
+	"terminalAPI/services"
+)
+
+// routes maps each RPC to the REST route it mirrors, whose scope in the
+// access policy the RPC requires
+var routes = map[string]string{
+	terminalpb.SessionService_CreateSession_FullMethodName:       "POST /sessions",
+	terminalpb.SessionService_GetSession_FullMethodName:          "GET /sessions/:sessionId",
+	terminalpb.SessionService_UpdateSession_FullMethodName:       "PATCH /sessions/:sessionId",
+	terminalpb.SessionService_TouchSession_FullMethodName:        "POST /sessions/:sessionId/touch",
+	terminalpb.SessionService_ListSessions_FullMethodName:        "GET /sessions",
+	terminalpb.SessionService_DeleteSession_FullMethodName:       "DELETE /sessions/:sessionId",
+	terminalpb.SessionService_SetWorkingDirectory_FullMethodName: "PUT /sessions/:sessionId/cwd",
+	terminalpb.CommandService_ExecuteCommand_FullMethodName:      "POST /sessions/:sessionId/commands",
+	terminalpb.ProcessService_StartProcess_FullMethodName:        "POST /sessions/:sessionId/processes",
+	terminalpb.ProcessService_ListProcesses_FullMethodName:       "GET /sessions/:sessionId/processes",
+	terminalpb.ProcessService_SendInput_FullMethodName:           "POST /sessions/:sessionId/processes/:processId/input",
+	terminalpb.ProcessService_SignalProcess_FullMethodName:       "POST /sessions/:sessionId/processes/:processId/signal",
+	terminalpb.ProcessService_StreamOutput_FullMethodName:        "GET /sessions/:sessionId/processes/:processId/events",
+}
+
+// NewServer returns a gRPC server for the session, command and process
+// services. Calls are authenticated with the API keys of the REST API, sent
+// as authorization (bearer token) or x-api-key metadata, and no keys means
+// authentication is disabled. Sessions are restricted to the key that
+// created them as over REST, and calls count towards the rate and command
+// limits of the REST API, which are nil when disabled.
+func NewServer(sm *services.SessionManager, cs *services.CommandService, ps *services.ProcessService, keys []*api.APIKey, policy *services.PolicyService, rate *api.RateLimiter, commands *api.CommandLimiter) *grpc.Server {
+	a := &authenticator{keys: keys, policy: policy, sessionManager: sm}
+	l := &limiter{rate: rate, commands: commands}
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(logUnary, a.unary, l.unary),
+		grpc.ChainStreamInterceptor(logStream, a.stream),
+	)
+	terminalpb.RegisterSessionServiceServer(server, &sessionServer{sessionManager: sm})
+	terminalpb.RegisterCommandServiceServer(server, &commandServer{commandService: cs})
+	terminalpb.RegisterProcessServiceServer(server, &processServer{processService: ps})
+	return server
+}
+
+// principalKey is where the authenticated caller is stored in the context
+type principalKey struct{}
+
+// principalFromContext returns the authenticated caller of an RPC, or nil
+// when authentication is disabled
+func principalFromContext(ctx context.Context) *handlers.Principal {
+	principal, _ := ctx.Value(principalKey{}).(*handlers.Principal)
+	return principal
+}
+
+// sessionOwner returns the owner to record on sessions the caller creates
+func sessionOwner(ctx context.Context) string {
+	if principal := principalFromContext(ctx); principal != nil {
+		return principal.Name
+	}
+	return ""
+}
+
+// sessionOwnerFilter returns the owner whose sessions the caller may list,
+// or "" for all of them
+func sessionOwnerFilter(ctx context.Context) string {
+	principal := principalFromContext(ctx)
+	if principal == nil || principal.Admin {
+		return ""
+	}
+	return principal.Name
+}
+
+// authenticator checks the API key, scope and session ownership of calls.
+// With a shared session store, it also loads the session of a call before
+// the ownership check and saves it afterwards, as api.SessionSync does.
+type authenticator struct {
+	keys           []*api.APIKey
+	policy         *services.PolicyService
+	sessionManager *services.SessionManager
+}
+
+func (a *authenticator) unary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := a.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	if sessionID := requestSessionID(req); sessionID != "" {
+		a.sessionManager.SyncSession(sessionID)
+		defer a.saveSession(sessionID)
+	}
+	if err := a.checkOwnership(ctx, req); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *authenticator) stream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	stream := &authenticatedStream{ServerStream: ss, ctx: ctx, authenticator: a}
+	err = handler(srv, stream)
+	if stream.sessionID != "" {
+		a.saveSession(stream.sessionID)
+	}
+	return err
+}
+
+// saveSession writes a session changed by a call back to the session store
+func (a *authenticator) saveSession(sessionID string) {
+	if err := a.sessionManager.SaveSession(sessionID); err != nil {
+		slog.Warn("failed to save session to store", services.LogKeySession, sessionID, "error", err)
+	}
+}
+
+// requestSessionID returns the session a request names, if any
+func requestSessionID(req any) string {
+	if request, ok := req.(interface{ GetSessionId() string }); ok {
+		return request.GetSessionId()
+	}
+	return ""
+}
+
+// authenticate returns the context of a call with its caller, or an error
+// if the key is missing, unknown or lacks the scope the call requires
+func (a *authenticator) authenticate(ctx context.Context, fullMethod string) (context.Context, error) {
+	if len(a.keys) == 0 {
+		return ctx, nil
+	}
+
+	value := ""
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("authorization"); len(values) > 0 {
+		value = strings.TrimPrefix(values[0], "Bearer ")
+	} else if values := md.Get("x-api-key"); len(values) > 0 {
+		value = values[0]
+	}
+	if value == "" {
+		return nil, status.Error(codes.Unauthenticated, "API key required")
+	}
+	key := api.MatchAPIKey(a.keys, value)
+	if key == nil {
+		return nil, status.Error(codes.Unauthenticated, "Invalid API key")
+	}
+
+	method, path, _ := strings.Cut(routes[fullMethod], " ")
+	scope := a.policy.RequiredScope(method, path, services.ScopeExecute)
+	if !a.policy.HasScope(key.Grants, scope) {
+		return nil, status.Errorf(codes.PermissionDenied, "API key %s lacks the %s scope", key.Name, scope)
+	}
+
+	return context.WithValue(ctx, principalKey{}, &handlers.Principal{
+		Name:  key.Name,
+		Admin: a.policy.HasScope(key.Grants, services.ScopeAdmin),
+	}), nil
+}
+
+// checkOwnership reports sessions of other keys as not found, so their IDs
+// cannot be probed; admin keys can use every session
+func (a *authenticator) checkOwnership(ctx context.Context, req any) error {
+	principal := principalFromContext(ctx)
+	if principal == nil || principal.Admin {
+		return nil
+	}
+	sessionID := requestSessionID(req)
+	if sessionID == "" {
+		return nil
+	}
+	if owner, exists := a.sessionManager.SessionOwner(sessionID); exists && owner != principal.Name {
+		return status.Error(codes.NotFound, "session not found or inactive")
+	}
+	return nil
+}
+
+// authenticatedStream carries the caller of a streaming call and checks
+// the session ownership of the request once it is received
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx           context.Context
+	authenticator *authenticator
+	// Session of the request, saved when the call ends
+	sessionID string
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *authenticatedStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if sessionID := requestSessionID(m); sessionID != "" {
+		s.authenticator.sessionManager.SyncSession(sessionID)
+		s.sessionID = sessionID
+	}
+	return s.authenticator.checkOwnership(s.ctx, m)
+}
+
+// logUnary logs every call as a structured line, as RequestLogger does for
+// REST requests
+func logUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	logCall(ctx, info.FullMethod, req, start, err)
+	return resp, err
+}
+
+func logStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	logCall(ss.Context(), info.FullMethod, nil, start, err)
+	return err
+}
+
+func logCall(ctx context.Context, fullMethod string, req any, start time.Time, err error) {
+	code := status.Code(err)
+	attrs := []any{
+		"method", fullMethod,
+		"code", code.String(),
+		"latency", time.Since(start),
+	}
+	if sessionID := requestSessionID(req); sessionID != "" {
+		attrs = append(attrs, services.LogKeySession, sessionID)
+	}
+
+	level := slog.LevelInfo
+	if err != nil {
+		attrs = append(attrs, "error", status.Convert(err).Message())
+	}
+	if code == codes.Internal || code == codes.Unknown {
+		level = slog.LevelError
+	}
+	slog.Log(ctx, level, "rpc", attrs...)
+}
+
+// statusError returns a status error with the message of err
+func statusError(code codes.Code, err error) error {
+	return status.Error(code, err.Error())
+}
@@ -0,0 +1,262 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Activity categories, one for each kind of thing done in a session
+const (
+	ActivitySession   = "session"
+	ActivityCommand   = "command"
+	ActivityProcess   = "process"
+	ActivityEnv       = "env"
+	ActivityAlias     = "alias"
+	ActivityTemplate  = "template"
+	ActivitySchedule  = "schedule"
+	ActivityRecording = "recording"
+	ActivityStorage   = "storage"
+)
+
+// Activity outcomes
+const (
+	ActivitySuccess = "success"
+	ActivityFailure = "failure"
+)
+
+// maxSessionActivity is how many recent entries a session carries in its
+// activityLog; the persisted log keeps every entry
+const maxSessionActivity = 100
+
+// ActivityEntry records one thing done in a session
+type ActivityEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	Category  string    `json:"category"`         // e.g. command or env
+	Target    string    `json:"target,omitempty"` // Command, variable, process or other subject
+	Outcome   string    `json:"outcome"`          // success or failure
+	Message   string    `json:"message"`
+}
+
+// ActivityQuery selects a page of a session's activity. Pages count back
+// from the newest entry, as for history. Zero fields match everything.
+type ActivityQuery struct {
+	Category string
+	Outcome  string
+	Since    time.Time
+	Until    time.Time
+	Limit    int // 0 returns every matching entry
+	Offset   int
+}
+
+// ActivityPage is a page of activity in chronological order
+type ActivityPage struct {
+	Activity []ActivityEntry `json:"activity"`
+	Count    int             `json:"count"`
+	Total    int             `json:"total"` // Entries matching the query
+	Offset   int             `json:"offset"`
+	HasMore  bool            `json:"hasMore"` // Older matching entries exist
+}
+
+// activityRecord is a line of the persisted activity log
+type activityRecord struct {
+	SessionID string `json:"sessionId"`
+	ActivityEntry
+}
+
+// activityLog appends the activity of every session to a file of JSON
+// lines. Until it is enabled, activity is only kept in the sessions.
+type activityLog struct {
+	path  string
+	file  *os.File
+	mutex sync.Mutex
+}
+
+// activityLogPath returns where activity is persisted, or "" when
+// OSAI_ACTIVITY_DIR is "off". The directory is shared with fileAPI, which
+// writes its own file.
+func activityLogPath() string {
+	dir := os.Getenv("OSAI_ACTIVITY_DIR")
+	if dir == "off" {
+		return ""
+	}
+	if dir == "" {
+		dir = filepath.Join(filepath.Dir(defaultLogDir()), "activity")
+	}
+	return filepath.Join(dir, "terminal.jsonl")
+}
+
+// EnableActivityPersistence appends the activity of sessions to
+// terminal.jsonl in OSAI_ACTIVITY_DIR, ~/.osai/activity by default, so it
+// can be queried beyond the last entries a session carries. Setting
+// OSAI_ACTIVITY_DIR to "off" keeps only those.
+func (sm *SessionManager) EnableActivityPersistence() error {
+	path := activityLogPath()
+	if path == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open activity log %s: %v", path, err)
+	}
+
+	sm.activity.mutex.Lock()
+	defer sm.activity.mutex.Unlock()
+	sm.activity.path = path
+	sm.activity.file = file
+	return nil
+}
+
+// write appends an entry to the log when it is persisted
+func (al *activityLog) write(sessionID string, entry ActivityEntry) {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+	if al.file == nil {
+		return
+	}
+
+	data, err := json.Marshal(activityRecord{SessionID: sessionID, ActivityEntry: entry})
+	if err != nil {
+		return
+	}
+	// One write per entry so lines never interleave
+	if _, err := al.file.Write(append(data, '\n')); err != nil {
+		slog.Warn("failed to write activity log", "path", al.path, "error", err)
+	}
+}
+
+// read returns the persisted entries of a session, and false when activity
+// is not persisted. Lines that are not valid entries, such as one cut short
+// by a crash, are skipped.
+func (al *activityLog) read(sessionID string) ([]ActivityEntry, bool, error) {
+	al.mutex.Lock()
+	path := al.path
+	al.mutex.Unlock()
+	if path == "" {
+		return nil, false, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, true, err
+	}
+	defer file.Close()
+
+	var entries []ActivityEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record activityRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err == nil && record.SessionID == sessionID {
+			entries = append(entries, record.ActivityEntry)
+		}
+	}
+	return entries, true, scanner.Err()
+}
+
+// addActivity stamps an entry, adds it to the session's recent activity and
+// persists it. Callers must hold session.Lock or have the only reference to
+// the session.
+func (sm *SessionManager) addActivity(session *Session, entry ActivityEntry) {
+	entry.Timestamp = time.Now().UTC()
+	if entry.Outcome == "" {
+		entry.Outcome = ActivitySuccess
+	}
+
+	session.ActivityLog = append(session.ActivityLog, entry)
+	if len(session.ActivityLog) > maxSessionActivity {
+		session.ActivityLog = session.ActivityLog[len(session.ActivityLog)-maxSessionActivity:]
+	}
+	sm.activity.write(session.ID, entry)
+}
+
+// GetActivity returns a page of a session's activity, read from the
+// persisted log when there is one and from the session's recent activity
+// otherwise
+func (sm *SessionManager) GetActivity(id string, query *ActivityQuery) (*ActivityPage, error) {
+	if query.Limit < 0 || query.Offset < 0 {
+		return nil, errors.New("limit and offset must not be negative")
+	}
+
+	session, err := sm.PeekSession(id)
+	if err != nil {
+		return nil, err
+	}
+	entries, persisted, err := sm.activity.read(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read activity log: %v", err)
+	}
+	if !persisted {
+		session.Lock.Lock()
+		entries = append([]ActivityEntry(nil), session.ActivityLog...)
+		session.Lock.Unlock()
+	}
+
+	return query.Page(entries), nil
+}
+
+// Page returns the page of entries, which are in chronological order, that
+// the query selects
+func (q *ActivityQuery) Page(entries []ActivityEntry) *ActivityPage {
+	var matching []ActivityEntry
+	for _, entry := range entries {
+		if q.matches(&entry) {
+			matching = append(matching, entry)
+		}
+	}
+
+	// Count back from the newest entry; a limit of 0 returns everything
+	end := len(matching) - q.Offset
+	if end < 0 {
+		end = 0
+	}
+	start := 0
+	if q.Limit > 0 && end > q.Limit {
+		start = end - q.Limit
+	}
+
+	result := make([]ActivityEntry, end-start)
+	copy(result, matching[start:end])
+	return &ActivityPage{
+		Activity: result,
+		Count:    len(result),
+		Total:    len(matching),
+		Offset:   q.Offset,
+		HasMore:  start > 0,
+	}
+}
+
+// matches reports whether an entry passes the query
+func (q *ActivityQuery) matches(entry *ActivityEntry) bool {
+	if q.Category != "" && entry.Category != q.Category {
+		return false
+	}
+	if q.Outcome != "" && entry.Outcome != q.Outcome {
+		return false
+	}
+	if !q.Since.IsZero() && entry.Timestamp.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !entry.Timestamp.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// exitOutcome is the outcome of a command or process that exited with code
+func exitOutcome(code int) string {
+	if code != 0 {
+		return ActivityFailure
+	}
+	return ActivitySuccess
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrAliasNotFound is returned for unknown alias names
+var ErrAliasNotFound = errors.New("alias not found")
+
+// Alias names follow bash: no quotes, whitespace, '/', '$' or '='
+var aliasNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.:+@%,-]+$`)
+
+// SetAlias defines or replaces a session alias, e.g. kgd for
+// "kubectl get deploy"
+func (sm *SessionManager) SetAlias(id string, name string, command string) error {
+	if !aliasNamePattern.MatchString(name) || strings.HasPrefix(name, "-") {
+		return fmt.Errorf("invalid alias name: %s", name)
+	}
+	if strings.TrimSpace(command) == "" {
+		return errors.New("alias command must not be empty")
+	}
+
+	session, err := sm.GetSession(id)
+	if err != nil {
+		return err
+	}
+
+	session.Lock.Lock()
+	if session.Aliases == nil {
+		session.Aliases = make(map[string]string)
+	}
+	session.Aliases[name] = command
+	session.Lock.Unlock()
+
+	sm.LogActivity(id, ActivityEntry{
+		Category: ActivityAlias,
+		Target:   name,
+		Message:  fmt.Sprintf("Set alias %s='%s'", name, command),
+	})
+	return nil
+}
+
+// GetAliases returns a copy of a session's aliases
+func (sm *SessionManager) GetAliases(id string) (map[string]string, error) {
+	session, err := sm.GetSession(id)
+	if err != nil {
+		return nil, err
+	}
+
+	session.Lock.Lock()
+	defer session.Lock.Unlock()
+
+	aliases := make(map[string]string, len(session.Aliases))
+	for name, command := range session.Aliases {
+		aliases[name] = command
+	}
+	return aliases, nil
+}
+
+// DeleteAlias removes a session alias
+func (sm *SessionManager) DeleteAlias(id string, name string) error {
+	session, err := sm.GetSession(id)
+	if err != nil {
+		return err
+	}
+
+	session.Lock.Lock()
+	_, exists := session.Aliases[name]
+	delete(session.Aliases, name)
+	session.Lock.Unlock()
+
+	if !exists {
+		return ErrAliasNotFound
+	}
+	sm.LogActivity(id, ActivityEntry{Category: ActivityAlias, Target: name, Message: "Removed alias " + name})
+	return nil
+}
+
+// expandAliases applies the session's aliases to a command line
+func (session *Session) expandAliases(command string) string {
+	session.Lock.Lock()
+	if len(session.Aliases) == 0 {
+		session.Lock.Unlock()
+		return command
+	}
+	aliases := make(map[string]string, len(session.Aliases))
+	for name, value := range session.Aliases {
+		aliases[name] = value
+	}
+	session.Lock.Unlock()
+
+	return expandAliases(command, aliases, map[string]bool{})
+}
+
+// expandAliases replaces aliases in command position the way bash does: the
+// first word of each simple command, after any variable assignments, unless
+// it is quoted or escaped. An alias is not expanded again within its own
+// expansion, and a value ending in a space makes the next word eligible too.
+func expandAliases(command string, aliases map[string]string, expanding map[string]bool) string {
+	var out strings.Builder
+	commandStart := true
+
+	for i := 0; i < len(command); {
+		c := command[i]
+		switch {
+		case c == ' ' || c == '\t':
+			out.WriteByte(c)
+			i++
+			continue
+		case c == ';' || c == '&' || c == '|' || c == '(' || c == '\n':
+			out.WriteByte(c)
+			commandStart = true
+			i++
+			continue
+		case c == ')':
+			out.WriteByte(c)
+			commandStart = false
+			i++
+			continue
+		}
+
+		// Read one word, keeping quoted parts intact
+		start := i
+		plain := true
+		for i < len(command) && !strings.ContainsRune(" \t;&|()\n", rune(command[i])) {
+			switch command[i] {
+			case '\\':
+				plain = false
+				i += 2
+				continue
+			case '\'', '"':
+				plain = false
+				quote := command[i]
+				i++
+				for i < len(command) && command[i] != quote {
+					if quote == '"' && command[i] == '\\' {
+						i++
+					}
+					i++
+				}
+			}
+			i++
+		}
+		if i > len(command) {
+			i = len(command)
+		}
+		word := command[start:i]
+
+		value, isAlias := aliases[word]
+		switch {
+		case commandStart && plain && isAlias && !expanding[word]:
+			nested := make(map[string]bool, len(expanding)+1)
+			for name := range expanding {
+				nested[name] = true
+			}
+			nested[word] = true
+			out.WriteString(expandAliases(value, aliases, nested))
+			commandStart = strings.HasSuffix(value, " ") || strings.HasSuffix(value, "\t")
+		case commandStart && isAssignment(word):
+			out.WriteString(word)
+		default:
+			out.WriteString(word)
+			commandStart = false
+		}
+	}
+	return out.String()
+}
+
+// isAssignment reports whether a word is a NAME=value variable assignment
+func isAssignment(word string) bool {
+	eq := strings.Index(word, "=")
+	return eq > 0 && envNamePattern.MatchString(word[:eq])
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrDirectoryNotAllowed is returned when a working directory lies outside
+// the allowed directories
+var ErrDirectoryNotAllowed = errors.New("directory is outside the allowed directories")
+
+// SetAllowedDirs restricts session working directories to dirs and the
+// directories below them. Each must exist; symlinks are resolved so a link
+// inside an allowed directory cannot lead out of it. No directories lifts
+// the restriction.
+func (sm *SessionManager) SetAllowedDirs(dirs []string) error {
+	var roots []string
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		absPath, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		root, err := filepath.EvalSymlinks(absPath)
+		if err != nil {
+			return fmt.Errorf("allowed directory %s: %w", dir, err)
+		}
+		if info, err := os.Stat(root); err != nil || !info.IsDir() {
+			return fmt.Errorf("allowed directory %s is not a directory", dir)
+		}
+		roots = append(roots, root)
+	}
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.allowedDirs = roots
+	return nil
+}
+
+// AllowedDirs returns the directories sessions may work in, or nil when any
+// directory is allowed
+func (sm *SessionManager) AllowedDirs() []string {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	return append([]string(nil), sm.allowedDirs...)
+}
+
+// checkAllowedDir fails with ErrDirectoryNotAllowed when dir, an absolute
+// path, resolves outside every allowed directory. The caller holds the
+// mutex.
+func (sm *SessionManager) checkAllowedDir(dir string) error {
+	if len(sm.allowedDirs) == 0 {
+		return nil
+	}
+	realPath, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	for _, root := range sm.allowedDirs {
+		rel, err := filepath.Rel(root, realPath)
+		if err == nil && !escapesRoot(rel) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrDirectoryNotAllowed, dir)
+}
+
+// resolveSandboxMounts replaces the sources of a sandbox's extra mounts with
+// their real paths, which must lie inside the allowed directories as
+// working directories do, so that a mount cannot expose the rest of the
+// host. The caller holds the mutex.
+func (sm *SessionManager) resolveSandboxMounts(cfg *SandboxConfig) error {
+	for i, mount := range cfg.Mounts {
+		source, err := filepath.EvalSymlinks(mount.Source)
+		if err != nil {
+			return fmt.Errorf("%w: mount source %s: %v", ErrInvalidSandbox, mount.Source, err)
+		}
+		if strings.ContainsAny(source, ":,") {
+			return fmt.Errorf("%w: mount source %s resolves to %s", ErrInvalidSandbox, mount.Source, source)
+		}
+		if err := sm.checkAllowedDir(source); err != nil {
+			return err
+		}
+		cfg.Mounts[i].Source = source
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"errors"
+	"regexp"
+)
+
+// Categories reported by command analysis
+const (
+	CategoryDestructive = "destructive"
+	CategoryNetwork     = "network"
+	CategoryPrivileged  = "privileged"
+)
+
+// AnalysisRule flags commands matching a pattern as belonging to a category
+type AnalysisRule struct {
+	Name        string
+	Category    string
+	Severity    string // low, medium or high
+	Description string
+	Pattern     *regexp.Regexp
+}
+
+// RuleMatch is a rule that fired for an analyzed command
+type RuleMatch struct {
+	Rule        string `json:"rule"`
+	Category    string `json:"category"`
+	Severity    string `json:"severity"`
+	Description string `json:"description"`
+	Match       string `json:"match"`
+}
+
+// CommandAnalysis is the static classification of a command
+type CommandAnalysis struct {
+	Command              string      `json:"command"`
+	Destructive          bool        `json:"destructive"`
+	NetworkAccess        bool        `json:"networkAccess"`
+	Privileged           bool        `json:"privileged"`
+	Reversible           bool        `json:"reversible"`
+	Risk                 string      `json:"risk"` // none, low, medium or high
+	RequiresConfirmation bool        `json:"requiresConfirmation"`
+	Matches              []RuleMatch `json:"matches"`
+}
+
+type AnalysisRequest struct {
+	Command string `json:"command"`
+}
+
+// AnalysisService classifies commands before they are run so an orchestrator
+// can ask a human to confirm risky ones
+type AnalysisService struct {
+	sessionManager *SessionManager
+	rules          []AnalysisRule
+}
+
+func NewAnalysisService(sm *SessionManager) *AnalysisService {
+	return &AnalysisService{
+		sessionManager: sm,
+		rules:          defaultAnalysisRules(),
+	}
+}
+
+func rule(name, category, severity, description, pattern string) AnalysisRule {
+	return AnalysisRule{
+		Name:        name,
+		Category:    category,
+		Severity:    severity,
+		Description: description,
+		Pattern:     regexp.MustCompile(pattern),
+	}
+}
+
+func defaultAnalysisRules() []AnalysisRule {
+	return []AnalysisRule{
+		// Destructive operations
+		rule("recursive-delete", CategoryDestructive, "high", "Recursively or forcibly deletes files",
+			`\brm\s+(-\S*[rRf]|--recursive|--force)`),
+		rule("delete", CategoryDestructive, "medium", "Deletes files",
+			`(^|[;&|]\s*|\s)(rm|rmdir|unlink)\s`),
+		rule("secure-erase", CategoryDestructive, "high", "Irrecoverably overwrites files",
+			`\bshred\b|\bwipe\b`),
+		rule("filesystem-format", CategoryDestructive, "high", "Creates a filesystem or writes raw disk data",
+			`\bmkfs(\.\w+)?\b|\bdd\b.*\bof=|>\s*/dev/(sd|nvme|hd|vd)`),
+		rule("truncate", CategoryDestructive, "medium", "Truncates file contents",
+			`\btruncate\b|(^|[^>&0-9])>\s*[^>&\s|]`),
+		rule("git-history-rewrite", CategoryDestructive, "high", "Discards uncommitted work or rewrites remote history",
+			`\bgit\s+(reset\s+--hard|clean\s+-\S*f|checkout\s+--\s|push\s+.*(--force|-f\b))`),
+		rule("recursive-permission-change", CategoryDestructive, "medium", "Recursively changes ownership or permissions",
+			`\b(chmod|chown|chgrp)\s+(-\S*R|--recursive)`),
+		rule("process-kill", CategoryDestructive, "medium", "Terminates processes",
+			`\b(kill|killall|pkill)\b`),
+		rule("fork-bomb", CategoryDestructive, "high", "Fork bomb",
+			`:\(\)\s*\{.*:\s*\|\s*:.*\}`),
+		rule("sql-drop", CategoryDestructive, "high", "Drops or truncates database objects",
+			`(?i)\b(drop\s+(table|database|schema)|truncate\s+table)\b`),
+
+		// Network access
+		rule("http-client", CategoryNetwork, "low", "Makes HTTP requests",
+			`\b(curl|wget|http|httpie)\b`),
+		rule("remote-shell", CategoryNetwork, "medium", "Opens a remote shell or copies files over the network",
+			`\b(ssh|scp|sftp|rsync|telnet|ftp)\b`),
+		rule("raw-socket", CategoryNetwork, "medium", "Opens raw network connections",
+			`\b(nc|ncat|netcat|socat)\b`),
+		rule("git-remote", CategoryNetwork, "low", "Talks to a git remote",
+			`\bgit\s+(clone|fetch|pull|push|ls-remote)\b`),
+		rule("package-download", CategoryNetwork, "low", "Downloads packages",
+			`\b(npm|yarn|pnpm)\s+(install|add|i)\b|\bpip3?\s+install\b|\bgo\s+(get|install|mod\s+download)\b|\bcargo\s+(install|fetch)\b|\bdocker\s+(pull|push)\b`),
+		rule("pipe-to-shell", CategoryNetwork, "high", "Executes a downloaded script",
+			`\b(curl|wget)\b[^|]*\|\s*(sudo\s+)?(ba|z|da)?sh\b`),
+
+		// Privileged operations
+		rule("privilege-escalation", CategoryPrivileged, "high", "Runs with elevated privileges",
+			`(^|[;&|]\s*|\s)(sudo|su|doas|pkexec)(\s|$)`),
+		rule("system-package-manager", CategoryPrivileged, "medium", "Modifies system packages",
+			`\b(apt|apt-get|dnf|yum|pacman|zypper|apk)\s+(install|remove|purge|upgrade|update|-S|-R)`),
+		rule("service-control", CategoryPrivileged, "medium", "Controls system services or mounts",
+			`\b(systemctl|service|mount|umount|modprobe|insmod|rmmod)\b`),
+		rule("system-path-write", CategoryPrivileged, "high", "Writes to system directories",
+			`(>|\btee\b|\bcp\b|\bmv\b|\brm\b)[^;&|]*\s/(etc|usr|boot|bin|sbin|lib|var/lib)\b`),
+		rule("power-control", CategoryPrivileged, "high", "Shuts down or reboots the machine",
+			`\b(shutdown|reboot|halt|poweroff)\b`),
+		rule("capabilities", CategoryPrivileged, "high", "Changes setuid bits or capabilities",
+			`\bsetcap\b|\bchmod\s+\S*[ug]\+s`),
+	}
+}
+
+// AnalyzeCommand statically classifies a command against the rule set
+func (as *AnalysisService) AnalyzeCommand(sessionID string, request *AnalysisRequest) (*CommandAnalysis, error) {
+	if _, err := as.sessionManager.GetSession(sessionID); err != nil {
+		return nil, err
+	}
+
+	if request.Command == "" {
+		return nil, errors.New("command is required")
+	}
+
+	analysis := as.Analyze(request.Command)
+
+	as.sessionManager.Audit(sessionID, "command.analyze", as.sessionManager.redact(request.Command), "risk "+string(analysis.Risk), nil)
+
+	return analysis, nil
+}
+
+// Analyze classifies a command without any session context
+func (as *AnalysisService) Analyze(command string) *CommandAnalysis {
+	analysis := &CommandAnalysis{
+		Command: command,
+		Risk:    "none",
+		Matches: []RuleMatch{},
+	}
+
+	severityRank := map[string]int{"none": 0, "low": 1, "medium": 2, "high": 3}
+
+	for _, r := range as.rules {
+		loc := r.Pattern.FindStringIndex(command)
+		if loc == nil {
+			continue
+		}
+		match := command[loc[0]:loc[1]]
+
+		analysis.Matches = append(analysis.Matches, RuleMatch{
+			Rule:        r.Name,
+			Category:    r.Category,
+			Severity:    r.Severity,
+			Description: r.Description,
+			Match:       match,
+		})
+
+		switch r.Category {
+		case CategoryDestructive:
+			analysis.Destructive = true
+		case CategoryNetwork:
+			analysis.NetworkAccess = true
+		case CategoryPrivileged:
+			analysis.Privileged = true
+		}
+
+		if severityRank[r.Severity] > severityRank[analysis.Risk] {
+			analysis.Risk = r.Severity
+		}
+	}
+
+	analysis.Reversible = !analysis.Destructive
+	analysis.RequiresConfirmation = analysis.Risk == "high" || analysis.Destructive || analysis.Privileged
+
+	return analysis
+}
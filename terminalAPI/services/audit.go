@@ -0,0 +1,255 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Audit results
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// auditMemorySize is how many entries are kept for queries when the audit
+// log is not persisted
+const auditMemorySize = 1000
+
+// AuditEntry records one operation: who did what, to what, and how it went
+type AuditEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	Actor     string    `json:"actor,omitempty"` // API key that owns the session
+	SessionID string    `json:"sessionId,omitempty"`
+	Operation string    `json:"operation"`        // e.g. command.execute
+	Target    string    `json:"target,omitempty"` // Command, path, process or other subject
+	Result    string    `json:"result"`           // success or failure
+	Error     string    `json:"error,omitempty"`
+	Detail    string    `json:"detail,omitempty"` // e.g. the exit code
+}
+
+// AuditFilter selects audit entries. Zero fields match everything, and
+// Operation also matches the operations below it, so "process" matches
+// "process.start".
+type AuditFilter struct {
+	SessionID string
+	Operation string
+	From      time.Time
+	To        time.Time
+	Limit     int
+}
+
+// AuditService records operations to an append-only log of JSON lines that
+// can be queried through the API
+type AuditService struct {
+	path   string
+	file   *os.File
+	recent []AuditEntry // Used instead of the file when not persisted
+	mutex  sync.Mutex
+	// Streams entries to GET /events
+	events eventStream
+}
+
+// NewAuditService creates an audit service that keeps recent entries in
+// memory until EnablePersistence is called
+func NewAuditService() *AuditService {
+	return &AuditService{}
+}
+
+// auditLogPath returns where the audit log is persisted, or "" when
+// OSAI_AUDIT_DIR is "off". The directory is shared with fileAPI, which
+// writes its own file.
+func auditLogPath() string {
+	dir := os.Getenv("OSAI_AUDIT_DIR")
+	if dir == "off" {
+		return ""
+	}
+	if dir == "" {
+		dir = filepath.Join(filepath.Dir(defaultLogDir()), "audit")
+	}
+	return filepath.Join(dir, "terminal.jsonl")
+}
+
+// EnablePersistence appends entries to terminal.jsonl in OSAI_AUDIT_DIR,
+// ~/.osai/audit by default. Setting OSAI_AUDIT_DIR to "off" keeps only
+// recent entries in memory.
+func (as *AuditService) EnablePersistence() error {
+	path := auditLogPath()
+	if path == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log %s: %v", path, err)
+	}
+
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+	as.path = path
+	as.file = file
+	return nil
+}
+
+// Record stores an entry, stamped with the current time. A nil err records
+// success, anything else a failure with its message.
+func (as *AuditService) Record(entry AuditEntry, err error) {
+	if as == nil {
+		return
+	}
+
+	entry.Timestamp = time.Now().UTC()
+	entry.Result = AuditSuccess
+	if err != nil {
+		entry.Result = AuditFailure
+		entry.Error = err.Error()
+	}
+
+	attrs := []any{LogKeyOperation, entry.Operation, "result", entry.Result}
+	for _, attr := range [][2]string{
+		{LogKeySession, entry.SessionID},
+		{"actor", entry.Actor},
+		{"target", entry.Target},
+		{"detail", entry.Detail},
+		{"error", entry.Error},
+	} {
+		if attr[1] != "" {
+			attrs = append(attrs, attr[0], attr[1])
+		}
+	}
+	level := slog.LevelInfo
+	if err != nil {
+		level = slog.LevelWarn
+	}
+	slog.Log(context.Background(), level, "audit", attrs...)
+	as.events.publish(entry)
+
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+
+	if as.file == nil {
+		as.recent = append(as.recent, entry)
+		if len(as.recent) > auditMemorySize {
+			as.recent = as.recent[len(as.recent)-auditMemorySize:]
+		}
+		return
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	// One write per entry so lines never interleave
+	if _, err := as.file.Write(append(data, '\n')); err != nil {
+		slog.Warn("failed to write audit log", "path", as.path, "error", err)
+	}
+}
+
+// Query returns the entries matching filter, newest first
+func (as *AuditService) Query(filter AuditFilter) ([]AuditEntry, error) {
+	as.mutex.Lock()
+	path := as.path
+	entries := append([]AuditEntry(nil), as.recent...)
+	as.mutex.Unlock()
+
+	if path != "" {
+		var err error
+		if entries, err = readAuditLog(path); err != nil {
+			return nil, err
+		}
+	}
+
+	matches := []AuditEntry{}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(matches) >= filter.Limit {
+			break
+		}
+		if filter.matches(&entries[i]) {
+			matches = append(matches, entries[i])
+		}
+	}
+	return matches, nil
+}
+
+// matches reports whether an entry passes the filter
+func (f *AuditFilter) matches(entry *AuditEntry) bool {
+	if f.SessionID != "" && entry.SessionID != f.SessionID {
+		return false
+	}
+	if f.Operation != "" && entry.Operation != f.Operation &&
+		!strings.HasPrefix(entry.Operation, f.Operation+".") {
+		return false
+	}
+	if !f.From.IsZero() && entry.Timestamp.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && entry.Timestamp.After(f.To) {
+		return false
+	}
+	return true
+}
+
+// readAuditLog reads every entry of an audit log, skipping lines that are
+// not valid entries, such as one cut short by a crash
+func readAuditLog(path string) ([]AuditEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// SetAuditService sets where operations in sessions are recorded
+func (sm *SessionManager) SetAuditService(audit *AuditService) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.audit = audit
+}
+
+// AuditService returns where operations in sessions are recorded
+func (sm *SessionManager) AuditService() *AuditService {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	return sm.audit
+}
+
+// Audit records an operation in a session, attributed to the API key that
+// owns the session. It must not be called with the session mutex held.
+func (sm *SessionManager) Audit(sessionID, operation, target, detail string, err error) {
+	sm.mutex.RLock()
+	audit := sm.audit
+	var owner string
+	if session, exists := sm.sessions[sessionID]; exists {
+		owner = session.Owner
+	}
+	sm.mutex.RUnlock()
+
+	audit.Record(AuditEntry{
+		Actor:     owner,
+		SessionID: sessionID,
+		Operation: operation,
+		Target:    target,
+		Detail:    detail,
+	}, err)
+}
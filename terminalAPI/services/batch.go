@@ -0,0 +1,198 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Conditions a batch step can be run under, evaluated against its dependencies
+const (
+	RunIfSuccess = "success" // Every dependency ran and exited 0 (default)
+	RunIfFailure = "failure" // At least one dependency failed or could not run
+	RunIfAlways  = "always"  // Once all dependencies have finished, whatever their outcome
+)
+
+// BatchStep is a named command in a batch workflow that can depend on the
+// outcome of other steps
+type BatchStep struct {
+	ID        string   `json:"id"`
+	Command   string   `json:"command"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+	RunIf     string   `json:"runIf,omitempty"`
+}
+
+// orderSteps validates the step graph and returns the steps in dependency order
+func orderSteps(steps []BatchStep) ([]*BatchStep, error) {
+	byID := make(map[string]*BatchStep, len(steps))
+	for i := range steps {
+		step := &steps[i]
+		if step.ID == "" {
+			return nil, fmt.Errorf("step %d is missing an id", i)
+		}
+		if step.Command == "" {
+			return nil, fmt.Errorf("step %s is missing a command", step.ID)
+		}
+		if _, exists := byID[step.ID]; exists {
+			return nil, fmt.Errorf("duplicate step id: %s", step.ID)
+		}
+		switch step.RunIf {
+		case "":
+			step.RunIf = RunIfSuccess
+		case RunIfSuccess, RunIfFailure, RunIfAlways:
+		default:
+			return nil, fmt.Errorf("step %s has unsupported runIf: %s", step.ID, step.RunIf)
+		}
+		byID[step.ID] = step
+	}
+
+	// Kahn's algorithm, keeping request order among steps that are ready
+	pending := make(map[string]int, len(steps))
+	dependents := make(map[string][]string)
+	for _, step := range byID {
+		for _, dep := range step.DependsOn {
+			if _, exists := byID[dep]; !exists {
+				return nil, fmt.Errorf("step %s depends on unknown step %s", step.ID, dep)
+			}
+			pending[step.ID]++
+			dependents[dep] = append(dependents[dep], step.ID)
+		}
+	}
+
+	ordered := make([]*BatchStep, 0, len(steps))
+	done := make(map[string]bool, len(steps))
+	for len(ordered) < len(steps) {
+		progressed := false
+		for i := range steps {
+			step := &steps[i]
+			if done[step.ID] || pending[step.ID] > 0 {
+				continue
+			}
+			done[step.ID] = true
+			ordered = append(ordered, step)
+			for _, dependent := range dependents[step.ID] {
+				pending[dependent]--
+			}
+			progressed = true
+		}
+		if !progressed {
+			var cycle []string
+			for i := range steps {
+				if !done[steps[i].ID] {
+					cycle = append(cycle, steps[i].ID)
+				}
+			}
+			return nil, fmt.Errorf("steps form a dependency cycle: %s", strings.Join(cycle, ", "))
+		}
+	}
+
+	return ordered, nil
+}
+
+// shouldRun evaluates a step's runIf condition against its finished dependencies
+func (step *BatchStep) shouldRun(outcomes map[string]*CommandOutput) bool {
+	failed := false
+	for _, dep := range step.DependsOn {
+		output := outcomes[dep]
+		if output.Skipped || output.ExitCode != 0 {
+			failed = true
+		}
+	}
+
+	switch step.RunIf {
+	case RunIfAlways:
+		return true
+	case RunIfFailure:
+		return failed
+	default:
+		return !failed
+	}
+}
+
+// executeSteps runs a batch workflow. Steps start once their dependencies have
+// finished and run only if their runIf condition holds; otherwise they are
+// reported as skipped. Results keep the order of the request.
+func (cs *CommandService) executeSteps(sessionID string, request *BatchCommandRequest) ([]*CommandOutput, error) {
+	if len(request.Commands) > 0 {
+		return nil, errors.New("a batch cannot have both commands and steps")
+	}
+	if request.Parallel && request.Persistent {
+		return nil, errors.New("parallel batches cannot use persistent mode")
+	}
+
+	ordered, err := orderSteps(request.Steps)
+	if err != nil {
+		return nil, err
+	}
+
+	concurrency := 1
+	if request.Parallel {
+		concurrency = request.MaxConcurrency
+		if concurrency <= 0 || concurrency > len(ordered) {
+			concurrency = len(ordered)
+		}
+	}
+
+	var mutex sync.Mutex
+	outcomes := make(map[string]*CommandOutput, len(ordered))
+	done := make(map[string]chan struct{}, len(ordered))
+	for _, step := range ordered {
+		done[step.ID] = make(chan struct{})
+	}
+
+	run := func(step *BatchStep) {
+		defer close(done[step.ID])
+
+		mutex.Lock()
+		runnable := step.shouldRun(outcomes)
+		mutex.Unlock()
+
+		var output *CommandOutput
+		if runnable {
+			var err error
+			output, err = cs.ExecuteCommand(sessionID, request.commandRequest(step.Command))
+			if err != nil {
+				output = &CommandOutput{Command: step.Command, ExitCode: -1, Error: err.Error()}
+			}
+		} else {
+			output = &CommandOutput{Command: step.Command, Skipped: true}
+		}
+		output.Step = step.ID
+
+		mutex.Lock()
+		outcomes[step.ID] = output
+		mutex.Unlock()
+	}
+
+	if concurrency == 1 {
+		for _, step := range ordered {
+			run(step)
+		}
+	} else {
+		slots := make(chan struct{}, concurrency)
+		var wg sync.WaitGroup
+		for _, step := range ordered {
+			wg.Add(1)
+			go func(step *BatchStep) {
+				defer wg.Done()
+				for _, dep := range step.DependsOn {
+					<-done[dep]
+				}
+				slots <- struct{}{}
+				defer func() { <-slots }()
+				run(step)
+			}(step)
+		}
+		wg.Wait()
+	}
+
+	results := make([]*CommandOutput, 0, len(request.Steps))
+	for _, step := range request.Steps {
+		results = append(results, outcomes[step.ID])
+	}
+
+	cs.sessionManager.Audit(sessionID, "command.batch", "", fmt.Sprintf("%d steps", len(results)), nil)
+
+	return results, nil
+}
@@ -1,13 +1,14 @@
 package services
 
 import (
-	"bytes"
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
-	"os"
 	"os/exec"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,30 +18,66 @@ type CommandOutput struct {
 	Stderr     string `json:"stderr"`
 	ExecutionTime float64 `json:"executionTime"` // In seconds
 	Command    string `json:"command"`
+	ExpandedCommand string `json:"expandedCommand,omitempty"` // What actually ran, when the command used session aliases
+	WorkingDir string `json:"workingDir,omitempty"` // Shell working directory after a persistent command
+	Persistent bool   `json:"persistent,omitempty"`
+	OutputTruncated bool `json:"outputTruncated,omitempty"` // Stdout and stderr together exceeded limits.maxOutputBytes
+	DryRun     *ExecutionPlan `json:"dryRun,omitempty"` // Set instead of output when dryRun was requested
+	Error      string `json:"error,omitempty"`   // Batch command that could not be started
+	Skipped    bool   `json:"skipped,omitempty"` // Batch command not run because an earlier one failed
+	Step       string `json:"step,omitempty"`    // ID of the batch step that produced this output
+	Encoding   string `json:"encoding,omitempty"` // "base64" when stdout and stderr are encoded raw bytes
+	Cached     bool   `json:"cached,omitempty"`   // Result reused from an identical earlier command
+	Attempts   []CommandAttempt `json:"attempts,omitempty"` // Every run of a command with retries, including the last
 }
 
 type CommandService struct {
 	sessionManager *SessionManager
 	historyService *HistoryService
+	cache          *commandCache
 }
 
 type CommandRequest struct {
 	Command     string            `json:"command"`
 	Timeout     int               `json:"timeout,omitempty"` // In seconds, 0 means no timeout
 	Environment map[string]string `json:"environment,omitempty"`
+	Persistent  bool              `json:"persistent,omitempty"` // Run in the session's long-lived shell
+	Limits      *ResourceLimits   `json:"limits,omitempty"`
+	DryRun      bool              `json:"dryRun,omitempty"` // Resolve and return the execution plan without running
+	RunAs       string            `json:"runAs,omitempty"`  // Username to execute as, overriding the session default
+	CallbackURL string            `json:"callbackUrl,omitempty"` // Background processes only: POSTed to on completion
+	Expect      []ExpectRule      `json:"expect,omitempty"`      // Background processes only: automatic answers to prompts
+	Raw         bool              `json:"raw,omitempty"`         // Capture output as bytes, returned base64 encoded
+	LogToFile   bool              `json:"logToFile,omitempty"`   // Background processes only: tee output to a log file
+	Cache       bool              `json:"cache,omitempty"`       // Reuse the result of an identical successful command
+	CacheTTL    int               `json:"cacheTTL,omitempty"`    // In seconds, how long a cached result stays valid
+	Retries     int               `json:"retries,omitempty"`     // Extra attempts after a failure
+	RetryDelay  int               `json:"retryDelay,omitempty"`  // In seconds between attempts
+	RetryOnExitCodes []int        `json:"retryOnExitCodes,omitempty"` // Only retry these exit codes, default any failure
+	Cwd         string            `json:"cwd,omitempty"`         // Directory to run in, inside the session working directory
+	Secrets     []string          `json:"secrets,omitempty"`     // Names of stored secrets to set as environment variables
 }
 
 type BatchCommandRequest struct {
 	Commands     []string          `json:"commands"`
+	Steps        []BatchStep       `json:"steps,omitempty"` // Workflow with dependencies, instead of commands
 	ContinueOnError bool           `json:"continueOnError"`
 	Timeout      int               `json:"timeout,omitempty"` // In seconds, per command
 	Environment  map[string]string `json:"environment,omitempty"`
+	Persistent   bool              `json:"persistent,omitempty"`
+	Limits       *ResourceLimits   `json:"limits,omitempty"`
+	DryRun       bool              `json:"dryRun,omitempty"`
+	RunAs        string            `json:"runAs,omitempty"`
+	Secrets      []string          `json:"secrets,omitempty"`
+	Parallel     bool              `json:"parallel,omitempty"`       // Run commands concurrently
+	MaxConcurrency int             `json:"maxConcurrency,omitempty"` // Parallel workers, defaults to one per command
 }
 
 func NewCommandService(sm *SessionManager, hs *HistoryService) *CommandService {
 	return &CommandService{
 		sessionManager: sm,
 		historyService: hs,
+		cache:          newCommandCache(),
 	}
 }
 
@@ -50,12 +87,166 @@ func (cs *CommandService) ExecuteCommand(sessionID string, request *CommandReque
 		return nil, err
 	}
 	
+	return cs.runCommand(session, request)
+}
+
+// runCommand executes a command in an already resolved session and records
+// it in the audit log, announcing its start to event subscribers
+func (cs *CommandService) runCommand(session *Session, request *CommandRequest) (*CommandOutput, error) {
+	command := cs.sessionManager.redact(request.Command)
+	cs.sessionManager.Announce(session.ID, "command.execute", command)
+	result, err := cs.executeInSession(session, request)
+	
+	detail := ""
+	if result != nil {
+		detail = fmt.Sprintf("exit code %d", result.ExitCode)
+		if result.DryRun != nil {
+			detail = "dry run"
+		}
+	}
+	cs.sessionManager.Audit(session.ID, "command.execute", command, detail, err)
+	
+	return result, err
+}
+
+// executeInSession plans and runs a command in a session
+func (cs *CommandService) executeInSession(session *Session, request *CommandRequest) (*CommandOutput, error) {
+	sessionID := session.ID
+	
 	if session.WorkingDir == "" {
-		return nil, errors.New("working directory not set for session")
+		return nil, ErrNoWorkingDir
+	}
+	
+	if err := request.Limits.Validate(); err != nil {
+		return nil, err
+	}
+	
+	if err := validateEnvNames(request.Environment); err != nil {
+		return nil, err
+	}
+	
+	if request.Persistent && request.Limits != nil {
+		return nil, errors.New("resource limits are not supported for persistent commands")
+	}
+	
+	if request.Persistent && session.Sandbox != nil {
+		return nil, errors.New("persistent mode is not supported for sandboxed sessions")
+	}
+	
+	if err := session.checkRemoteRequest(request); err != nil {
+		return nil, err
+	}
+	
+	if request.Persistent && request.Cwd != "" {
+		return nil, errors.New("cwd is not supported for persistent commands")
+	}
+	
+	cwd, err := session.resolveCwd(request.Cwd)
+	if err != nil {
+		return nil, err
+	}
+	if cwd != request.Cwd {
+		resolved := *request
+		resolved.Cwd = cwd
+		request = &resolved
+	}
+	
+	// History and the response keep the command as typed
+	typed := cs.sessionManager.redact(request.Command)
+	if expanded := session.expandAliases(request.Command); expanded != request.Command {
+		resolved := *request
+		resolved.Command = expanded
+		request = &resolved
+	}
+	
+	request, err = cs.sessionManager.withSecrets(request)
+	if err != nil {
+		return nil, err
+	}
+	
+	plan := buildExecutionPlan(session, request, false, cs.sessionManager.containerRunner, cs.sessionManager.remoteRunner)
+	
+	if request.Persistent && plan.RunAs != "" {
+		return nil, errors.New("runAs is not supported for persistent commands")
+	}
+	
+	if request.Cache && request.Persistent {
+		return nil, errors.New("caching is not supported for persistent commands")
+	}
+	
+	if request.CacheTTL < 0 {
+		return nil, errors.New("cacheTTL must not be negative")
+	}
+	
+	if err := request.validateRetries(); err != nil {
+		return nil, err
+	}
+	
+	if request.DryRun {
+		result := &CommandOutput{
+			Command:    cs.sessionManager.redact(request.Command),
+			Persistent: request.Persistent,
+			DryRun:     cs.sessionManager.redactPlan(plan),
+		}
+		result.recordExpansion(typed)
+		return result, nil
+	}
+	
+	var key string
+	if request.Cache {
+		key = cacheKey(plan, request.Raw)
+		if cached, found := cs.cache.get(key); found {
+			sessionLog(sessionID).Debug("command served from cache", LogKeyOperation, "command.execute", "command", typed)
+			cached.recordExpansion(typed)
+			return cached, nil
+		}
 	}
 	
 	// Record in history
-	cs.historyService.AddToHistory(sessionID, request.Command)
+	historyID := cs.historyService.AddToHistory(sessionID, typed)
+	
+	result, err := cs.executeWithRetries(session, plan, request)
+	if err != nil {
+		return nil, err
+	}
+	cs.historyService.RecordExitCode(sessionID, historyID, result.ExitCode)
+	
+	// Failures may be transient, so only successful results are reused
+	if request.Cache && result.ExitCode == 0 && !result.OutputTruncated {
+		cs.cache.put(key, result, request.cacheTTL())
+	}
+	
+	result.recordExpansion(typed)
+	return result, nil
+}
+
+// recordExpansion reports the command as typed, moving the alias expansion
+// that ran to ExpandedCommand
+func (output *CommandOutput) recordExpansion(typed string) {
+	output.ExpandedCommand = ""
+	if output.Command != typed {
+		output.ExpandedCommand = output.Command
+		output.Command = typed
+	}
+}
+
+// executeOnce runs a resolved command a single time
+func (cs *CommandService) executeOnce(session *Session, plan *ExecutionPlan, request *CommandRequest) (*CommandOutput, error) {
+	sessionID := session.ID
+	
+	cs.sessionManager.emitTerminalOutput(sessionID, []byte("$ "+cs.sessionManager.redact(request.Command)+"\n"))
+	
+	if request.Persistent {
+		result, err := cs.executePersistent(sessionID, plan, request)
+		if err == nil {
+			cs.sessionManager.redactOutput(result)
+			cs.emitOutput(sessionID, result)
+			if request.Raw {
+				result.encodeOutput()
+			}
+		}
+		return result, err
+	}
 	
 	// Create command context
 	ctx := context.Background()
@@ -66,38 +257,30 @@ func (cs *CommandService) ExecuteCommand(sessionID string, request *CommandReque
 		defer cancel()
 	}
 	
-	// Determine which shell to use based on session environment
-	shellPath := "/bin/bash"  // Default shell
-	if shell, exists := session.EnvVars["SHELL"]; exists && shell != "" {
-		shellPath = shell
-	}
-	
-	// Start with system environment
-	env := os.Environ()
-	
-	// Add session environment variables
-	for k, v := range session.EnvVars {
-		env = append(env, fmt.Sprintf("%s=%s", k, v))
-	}
-	
-	// Add request-specific environment variables
-	for k, v := range request.Environment {
-		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	if session.Sandbox != nil {
+		if _, err := cs.sessionManager.containerRunner.EnsureContainer(session); err != nil {
+			return nil, err
+		}
 	}
 	
 	// Create command
-	cmd := exec.CommandContext(ctx, shellPath, "-c", request.Command)
-	cmd.Dir = session.WorkingDir
-	cmd.Env = env
+	cmd := exec.CommandContext(ctx, plan.Shell, plan.Args...)
+	cmd.Dir = plan.hostDir
+	startInProcessGroup(cmd)
+	cmd.Env = plan.Env()
+	cmd.Stdin = plan.Stdin()
+	if err := applyRunAs(cmd, plan); err != nil {
+		return nil, err
+	}
 	
-	// Capture stdout and stderr
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	// Capture stdout and stderr, capped together when an output limit is set
+	stdout, stderr := newOutputBuffers(request.Limits.outputLimit())
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 	
 	// Execute command and measure time
 	startTime := time.Now()
-	err = cmd.Run()
+	err := cmd.Run()
 	executionTime := time.Since(startTime).Seconds()
 	
 	// Create result
@@ -106,6 +289,7 @@ func (cs *CommandService) ExecuteCommand(sessionID string, request *CommandReque
 		Stderr:     stderr.String(),
 		Command:    request.Command,
 		ExecutionTime: executionTime,
+		OutputTruncated: stdout.truncated || stderr.truncated,
 	}
 	
 	if err != nil {
@@ -120,15 +304,41 @@ func (cs *CommandService) ExecuteCommand(sessionID string, request *CommandReque
 	}
 	
 	// Log activity
-	cs.sessionManager.LogActivity(sessionID, fmt.Sprintf("Executed command: %s (exit code: %d)", 
-		request.Command, result.ExitCode))
+	cs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityCommand,
+		Target:   request.Command,
+		Outcome:  exitOutcome(result.ExitCode),
+		Message:  fmt.Sprintf("Executed command: %s (exit code: %d)", request.Command, result.ExitCode),
+	})
 	
-	fmt.Printf("[TERMINAL] Session %s: Command '%s' completed with exit code %d\n", 
-		sessionID, request.Command, result.ExitCode)
+	cs.sessionManager.redactOutput(result)
+	
+	cs.emitOutput(sessionID, result)
+	
+	if request.Raw {
+		result.encodeOutput()
+	}
 	
 	return result, nil
 }
 
+// emitOutput passes a finished command's output to terminal output listeners
+func (cs *CommandService) emitOutput(sessionID string, result *CommandOutput) {
+	if result.Stdout != "" {
+		cs.sessionManager.emitTerminalOutput(sessionID, []byte(result.Stdout))
+	}
+	if result.Stderr != "" {
+		cs.sessionManager.emitTerminalOutput(sessionID, []byte(result.Stderr))
+	}
+}
+
+// encodeOutput base64 encodes stdout and stderr so binary output survives JSON
+func (output *CommandOutput) encodeOutput() {
+	output.Stdout = base64.StdEncoding.EncodeToString([]byte(output.Stdout))
+	output.Stderr = base64.StdEncoding.EncodeToString([]byte(output.Stderr))
+	output.Encoding = "base64"
+}
+
 func (cs *CommandService) ExecuteBatchCommands(sessionID string, request *BatchCommandRequest) ([]*CommandOutput, error) {
 	session, err := cs.sessionManager.GetSession(sessionID)
 	if err != nil {
@@ -136,23 +346,29 @@ func (cs *CommandService) ExecuteBatchCommands(sessionID string, request *BatchC
 	}
 	
 	if session.WorkingDir == "" {
-		return nil, errors.New("working directory not set for session")
+		return nil, ErrNoWorkingDir
+	}
+	
+	if len(request.Steps) > 0 {
+		return cs.executeSteps(sessionID, request)
+	}
+	
+	if request.Parallel {
+		if request.Persistent {
+			return nil, errors.New("parallel batches cannot use persistent mode")
+		}
+		return cs.executeParallel(sessionID, request), nil
 	}
 	
 	results := make([]*CommandOutput, 0, len(request.Commands))
 	
 	for _, cmd := range request.Commands {
-		cmdReq := &CommandRequest{
-			Command:     cmd,
-			Timeout:     request.Timeout,
-			Environment: request.Environment,
-		}
-		
-		output, err := cs.ExecuteCommand(sessionID, cmdReq)
+		output, err := cs.ExecuteCommand(sessionID, request.commandRequest(cmd))
 		if err != nil {
 			if !request.ContinueOnError {
 				return results, err
 			}
+			output = &CommandOutput{Command: cmd, ExitCode: -1, Error: err.Error()}
 		}
 		
 		results = append(results, output)
@@ -166,6 +382,105 @@ func (cs *CommandService) ExecuteBatchCommands(sessionID string, request *BatchC
 	return results, nil
 }
 
+// executeParallel runs batch commands on a bounded pool of workers. Results
+// keep the order of the request; once a command fails without
+// continueOnError, commands that have not started yet are skipped.
+func (cs *CommandService) executeParallel(sessionID string, request *BatchCommandRequest) []*CommandOutput {
+	results := make([]*CommandOutput, len(request.Commands))
+	
+	concurrency := request.MaxConcurrency
+	if concurrency <= 0 || concurrency > len(request.Commands) {
+		concurrency = len(request.Commands)
+	}
+	
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var failed atomic.Bool
+	
+	for i, command := range request.Commands {
+		slots <- struct{}{}
+		if failed.Load() && !request.ContinueOnError {
+			<-slots
+			results[i] = &CommandOutput{Command: command, Skipped: true}
+			continue
+		}
+		
+		wg.Add(1)
+		go func(i int, command string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			
+			output, err := cs.ExecuteCommand(sessionID, request.commandRequest(command))
+			if err != nil {
+				output = &CommandOutput{Command: command, ExitCode: -1, Error: err.Error()}
+			}
+			if output.ExitCode != 0 {
+				failed.Store(true)
+			}
+			results[i] = output
+		}(i, command)
+	}
+	
+	wg.Wait()
+	
+	sessionLog(sessionID).Debug("ran commands in parallel", LogKeyOperation, "command.batch",
+		"commands", len(request.Commands), "concurrency", concurrency)
+	
+	return results
+}
+
+// commandRequest builds the request for a single command of the batch
+func (request *BatchCommandRequest) commandRequest(command string) *CommandRequest {
+	return &CommandRequest{
+		Command:     command,
+		Timeout:     request.Timeout,
+		Environment: request.Environment,
+		Persistent:  request.Persistent,
+		Limits:      request.Limits,
+		DryRun:      request.DryRun,
+		RunAs:       request.RunAs,
+		Secrets:     request.Secrets,
+	}
+}
+
+// executePersistent runs a command in the session's long-lived shell so that
+// directory changes and exported variables carry over to later commands
+func (cs *CommandService) executePersistent(sessionID string, plan *ExecutionPlan, request *CommandRequest) (*CommandOutput, error) {
+	shell, err := cs.sessionManager.GetPersistentShell(sessionID, plan.Shell)
+	if err != nil {
+		return nil, err
+	}
+	
+	startTime := time.Now()
+	shellResult, err := shell.Run(request.Command, request.Environment, time.Duration(request.Timeout)*time.Second)
+	executionTime := time.Since(startTime).Seconds()
+	
+	result := &CommandOutput{
+		Command:       request.Command,
+		ExecutionTime: executionTime,
+		Persistent:    true,
+	}
+	
+	if err != nil {
+		result.ExitCode = -1
+		result.Stderr = err.Error()
+	} else {
+		result.ExitCode = shellResult.ExitCode
+		result.Stdout = shellResult.Stdout
+		result.Stderr = shellResult.Stderr
+		result.WorkingDir = shellResult.WorkingDir
+	}
+	
+	cs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityCommand,
+		Target:   request.Command,
+		Outcome:  exitOutcome(result.ExitCode),
+		Message:  fmt.Sprintf("Executed persistent command: %s (exit code: %d)", request.Command, result.ExitCode),
+	})
+	
+	return result, nil
+}
+
 // Parse a command string into command and arguments
 func parseCommand(command string) []string {
 	// This is a basic implementation. A more robust solution would handle
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Cached results live for DefaultCacheTTL unless the request sets cacheTTL,
+// and at most maxCacheEntries are kept
+const (
+	DefaultCacheTTL = 30 * time.Second
+	maxCacheEntries = 256
+)
+
+// commandCache holds successful command results keyed by everything that
+// determines what a command sees: working directory, command line,
+// environment, user and container
+type commandCache struct {
+	entries map[string]*cacheEntry
+	mutex   sync.Mutex
+}
+
+type cacheEntry struct {
+	output  CommandOutput
+	expires time.Time
+}
+
+func newCommandCache() *commandCache {
+	return &commandCache{
+		entries: make(map[string]*cacheEntry),
+	}
+}
+
+// cacheKey hashes the resolved plan of a request
+func cacheKey(plan *ExecutionPlan, raw bool) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00%s\x00%q\x00%s\x00%s\x00%t\x00", plan.WorkingDir, plan.Shell, plan.Args, plan.RunAs, plan.Container, raw)
+
+	keys := make([]string, 0, len(plan.Environment))
+	for k := range plan.Environment {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(hash, "%s=%s\x00", k, plan.Environment[k])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// get returns a copy of an unexpired result marked as cached
+func (c *commandCache) get(key string) (*CommandOutput, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, exists := c.entries[key]
+	if !exists {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	output := entry.output
+	output.Cached = true
+	return &output, true
+}
+
+// put stores a result, evicting expired entries and then the entry closest
+// to expiring when the cache is full
+func (c *commandCache) put(key string, output *CommandOutput, ttl time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= maxCacheEntries {
+		now := time.Now()
+		oldestKey := ""
+		var oldest time.Time
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+				continue
+			}
+			if oldestKey == "" || entry.expires.Before(oldest) {
+				oldestKey, oldest = k, entry.expires
+			}
+		}
+		if len(c.entries) >= maxCacheEntries {
+			delete(c.entries, oldestKey)
+		}
+	}
+
+	c.entries[key] = &cacheEntry{
+		output:  *output,
+		expires: time.Now().Add(ttl),
+	}
+}
+
+// cacheTTL returns how long the result of a request may be reused
+func (request *CommandRequest) cacheTTL() time.Duration {
+	if request.CacheTTL > 0 {
+		return time.Duration(request.CacheTTL) * time.Second
+	}
+	return DefaultCacheTTL
+}
@@ -6,12 +6,14 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 )
 
 // CommandRunner provides functionality to run commands via the proper shell
 type CommandRunner struct {
 	// Cache of valid shells to avoid repeated filesystem checks
 	validShells map[string]bool
+	mutex       sync.Mutex
 }
 
 // NewCommandRunner creates a new command runner instance
@@ -34,6 +36,9 @@ func (cr *CommandRunner) GetDefaultShell() string {
 
 // IsValidShell checks if a shell path exists and is executable
 func (cr *CommandRunner) IsValidShell(shellPath string) bool {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	
 	// Check cache first
 	if valid, exists := cr.validShells[shellPath]; exists {
 		return valid
@@ -45,21 +50,8 @@ func (cr *CommandRunner) IsValidShell(shellPath string) bool {
 		return false
 	}
 	
-	// Common valid shells
-	commonShells := []string{
-		"/bin/bash", "/bin/sh", "/bin/zsh", 
-		"/usr/bin/bash", "/usr/bin/zsh", 
-		"/usr/local/bin/bash", "/usr/local/bin/zsh",
-	}
-	
-	for _, shell := range commonShells {
-		if shellPath == shell {
-			cr.validShells[shellPath] = true
-			return true
-		}
-	}
-	
-	// For other paths, check if they exist and are executable
+	// Check that the shell exists and is executable, even for common paths
+	// such as /bin/zsh that are not installed everywhere
 	info, err := os.Stat(shellPath)
 	if err != nil {
 		cr.validShells[shellPath] = false
@@ -109,7 +101,7 @@ func (cr *CommandRunner) PrepareCommand(command string, shellPath string, workin
 	}
 	
 	// Start with system environment
-	systemEnv := os.Environ()
+	systemEnv := commandEnviron()
 	
 	// Add custom environment variables
 	if len(env) > 0 {
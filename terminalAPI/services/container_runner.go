@@ -0,0 +1,179 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidSandbox is returned for sandbox configurations that cannot be
+// used
+var ErrInvalidSandbox = errors.New("invalid sandbox configuration")
+
+// ErrSandboxMountsForbidden is returned when a key that is not an admin key
+// asks for extra sandbox mounts, which reach beyond the working directory
+var ErrSandboxMountsForbidden = errors.New("only admin keys may add sandbox mounts")
+
+// SandboxConfig describes the Docker container a sandboxed session's
+// commands run in
+type SandboxConfig struct {
+	Image      string         `json:"image"`
+	Network    string         `json:"network,omitempty"`    // Docker network mode, defaults to "none"
+	Mounts     []SandboxMount `json:"mounts,omitempty"`     // Extra bind mounts besides the working directory
+	WorkingDir string         `json:"workingDir,omitempty"` // Container path the session directory is mounted at, defaults to /workspace
+	Shell      string         `json:"shell,omitempty"`      // Shell inside the container, defaults to /bin/sh
+}
+
+// SandboxMount is a host directory bind-mounted into the container
+type SandboxMount struct {
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	ReadOnly bool   `json:"readOnly,omitempty"`
+}
+
+// ContainerRunner runs session commands inside per-session Docker containers
+// through the docker CLI, alongside CommandRunner for host execution
+type ContainerRunner struct {
+	dockerPath string
+	// Host directory mounted into each running container, keyed by container name
+	mountedDirs map[string]string
+	mutex       sync.Mutex
+}
+
+// NewContainerRunner creates a new container runner instance
+func NewContainerRunner() *ContainerRunner {
+	return &ContainerRunner{
+		dockerPath:  "docker",
+		mountedDirs: make(map[string]string),
+	}
+}
+
+// ApplyDefaults fills unset sandbox options and validates the rest
+func (cfg *SandboxConfig) ApplyDefaults() error {
+	if cfg.Image == "" {
+		return fmt.Errorf("%w: image is required", ErrInvalidSandbox)
+	}
+	if cfg.Network == "" {
+		cfg.Network = "none"
+	}
+	if cfg.WorkingDir == "" {
+		cfg.WorkingDir = "/workspace"
+	}
+	if cfg.Shell == "" {
+		cfg.Shell = "/bin/sh"
+	}
+	for _, mount := range cfg.Mounts {
+		if !strings.HasPrefix(mount.Source, "/") || !strings.HasPrefix(mount.Target, "/") {
+			return fmt.Errorf("%w: mount paths must be absolute: %s:%s", ErrInvalidSandbox, mount.Source, mount.Target)
+		}
+		// docker run -v splits its spec at colons and --mount at commas, so
+		// neither may extend the mount with options of its own
+		if strings.ContainsAny(mount.Source+mount.Target, ":,") {
+			return fmt.Errorf("%w: mount paths must not contain ':' or ',': %s", ErrInvalidSandbox, mount.Source)
+		}
+	}
+	return nil
+}
+
+// IsAvailable reports whether the docker CLI can be found
+func (cr *ContainerRunner) IsAvailable() bool {
+	_, err := exec.LookPath(cr.dockerPath)
+	return err == nil
+}
+
+// ContainerName returns the name of a session's sandbox container
+func (cr *ContainerRunner) ContainerName(sessionID string) string {
+	return "osai-" + sessionID
+}
+
+// EnsureContainer starts the session's container if it is not running, or
+// recreates it when the session working directory has changed
+func (cr *ContainerRunner) EnsureContainer(session *Session) (string, error) {
+	if session.Sandbox == nil {
+		return "", errors.New("session is not sandboxed")
+	}
+
+	name := cr.ContainerName(session.ID)
+
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+
+	if mounted, exists := cr.mountedDirs[name]; exists {
+		if mounted == session.WorkingDir && cr.isRunning(name) {
+			return name, nil
+		}
+		cr.removeLocked(name)
+	}
+
+	cfg := session.Sandbox
+	args := []string{"run", "-d", "--name", name,
+		"--label", "osai.session=" + session.ID,
+		"--network", cfg.Network,
+		"-v", session.WorkingDir + ":" + cfg.WorkingDir,
+		"-w", cfg.WorkingDir,
+	}
+	for _, mount := range cfg.Mounts {
+		spec := mount.Source + ":" + mount.Target
+		if mount.ReadOnly {
+			spec += ":ro"
+		}
+		args = append(args, "-v", spec)
+	}
+	// Keep the container alive regardless of the image's own entrypoint
+	args = append(args, "--entrypoint", "tail", cfg.Image, "-f", "/dev/null")
+
+	output, err := exec.Command(cr.dockerPath, args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to start sandbox container: %s", strings.TrimSpace(string(output)))
+	}
+
+	cr.mountedDirs[name] = session.WorkingDir
+	sessionLog(session.ID).Info("started sandbox container", "container", name, "image", cfg.Image)
+	return name, nil
+}
+
+// ExecArgs returns the docker CLI arguments that run a command line inside
+// a session's container, in the given container directory, with the given
+// environment and optional user. Variables are passed by name only, so their
+// values stay out of the process list; docker reads the values from its own
+// environment, which ExecutionPlan.Env provides.
+func (cr *ContainerRunner) ExecArgs(sessionID string, cfg *SandboxConfig, workDir string, commandLine string, env map[string]string, user string) []string {
+	args := []string{"exec", "-i", "-w", workDir}
+	if user != "" {
+		args = append(args, "-u", user)
+	}
+	for _, k := range slices.Sorted(maps.Keys(env)) {
+		// The host shell path is meaningless inside the container
+		if k == "SHELL" {
+			continue
+		}
+		args = append(args, "-e", k)
+	}
+	return append(args, cr.ContainerName(sessionID), cfg.Shell, "-c", commandLine)
+}
+
+// RemoveContainer stops and deletes a session's container if one exists
+func (cr *ContainerRunner) RemoveContainer(sessionID string) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+
+	name := cr.ContainerName(sessionID)
+	if _, exists := cr.mountedDirs[name]; exists {
+		cr.removeLocked(name)
+		sessionLog(sessionID).Info("removed sandbox container", "container", name)
+	}
+}
+
+func (cr *ContainerRunner) removeLocked(name string) {
+	exec.Command(cr.dockerPath, "rm", "-f", name).Run()
+	delete(cr.mountedDirs, name)
+}
+
+func (cr *ContainerRunner) isRunning(name string) bool {
+	output, err := exec.Command(cr.dockerPath, "inspect", "-f", "{{.State.Running}}", name).Output()
+	return err == nil && strings.TrimSpace(string(output)) == "true"
+}
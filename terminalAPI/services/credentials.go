@@ -0,0 +1,83 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// RunAsUser is a resolved account that commands can be executed as
+type RunAsUser struct {
+	Username   string
+	HomeDir    string
+	Credential *syscall.Credential
+}
+
+// lookupRunAsUser resolves a username to the uid, gid and supplementary
+// groups used to drop privileges for a command
+func lookupRunAsUser(username string) (*RunAsUser, error) {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return nil, fmt.Errorf("unknown user: %s", username)
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported uid for user %s: %s", username, u.Uid)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported gid for user %s: %s", username, u.Gid)
+	}
+
+	if os.Geteuid() != 0 && int(uid) != os.Geteuid() {
+		return nil, errors.New("running commands as another user requires the server to run as root")
+	}
+
+	var groups []uint32
+	if groupIDs, err := u.GroupIds(); err == nil {
+		for _, g := range groupIDs {
+			if id, err := strconv.ParseUint(g, 10, 32); err == nil {
+				groups = append(groups, uint32(id))
+			}
+		}
+	}
+
+	return &RunAsUser{
+		Username: u.Username,
+		HomeDir:  u.HomeDir,
+		Credential: &syscall.Credential{
+			Uid:    uint32(uid),
+			Gid:    uint32(gid),
+			Groups: groups,
+		},
+	}, nil
+}
+
+// applyRunAs switches a prepared command to run as the plan's user, with
+// HOME, USER and LOGNAME pointing at that account
+func applyRunAs(cmd *exec.Cmd, plan *ExecutionPlan) error {
+	if plan.RunAs == "" || plan.Container != "" {
+		return nil
+	}
+
+	runAs, err := lookupRunAsUser(plan.RunAs)
+	if err != nil {
+		return err
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = runAs.Credential
+	cmd.Env = append(cmd.Env,
+		"HOME="+runAs.HomeDir,
+		"USER="+runAs.Username,
+		"LOGNAME="+runAs.Username,
+	)
+	return nil
+}
@@ -0,0 +1,487 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultDockerSocket is used unless DOCKER_HOST names another unix socket
+const DefaultDockerSocket = "/var/run/docker.sock"
+
+// dockerAPIVersion is the Engine API version requests are made against;
+// every supported Docker release understands it
+const dockerAPIVersion = "v1.41"
+
+// Limits for container logs and exec
+const (
+	DefaultDockerLogTail     = 100
+	maxDockerOutputBytes     = 10 * 1024 * 1024
+	DefaultDockerExecTimeout = 60
+)
+
+// ErrDockerUnavailable is returned when the Docker socket cannot be reached
+var ErrDockerUnavailable = errors.New("docker is not available")
+
+// DockerError is an error response from the Docker Engine API
+type DockerError struct {
+	Status  int
+	Message string
+}
+
+func (e *DockerError) Error() string {
+	return e.Message
+}
+
+// DockerContainer is a container as listed by the Docker daemon
+type DockerContainer struct {
+	ID      string            `json:"id"`
+	Name    string            `json:"name"`
+	Image   string            `json:"image"`
+	Command string            `json:"command"`
+	State   string            `json:"state"`  // created, running, paused, restarting, exited or dead
+	Status  string            `json:"status"` // e.g. "Up 2 hours"
+	Created time.Time         `json:"created"`
+	Ports   []DockerPort      `json:"ports"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// DockerPort is a container port and its published host port, if any
+type DockerPort struct {
+	IP          string `json:"ip,omitempty"`
+	PrivatePort int    `json:"privatePort"`
+	PublicPort  int    `json:"publicPort,omitempty"`
+	Type        string `json:"type"`
+}
+
+// DockerImage is a locally available image
+type DockerImage struct {
+	ID      string    `json:"id"`
+	Tags    []string  `json:"tags"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+}
+
+// DockerLogs holds the recent output of a container
+type DockerLogs struct {
+	Container string `json:"container"`
+	Stdout    string `json:"stdout"`
+	Stderr    string `json:"stderr"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// DockerLogQuery selects container log lines
+type DockerLogQuery struct {
+	Tail       int       // Lines from the end, default DefaultDockerLogTail
+	Since      time.Time // Only lines after this time
+	Timestamps bool      // Prefix lines with their RFC 3339 timestamp
+}
+
+// DockerExecRequest runs a command inside a running container
+type DockerExecRequest struct {
+	Command    string            `json:"command"` // Run with sh -c
+	WorkingDir string            `json:"workingDir,omitempty"`
+	Env        map[string]string `json:"env,omitempty"`
+	User       string            `json:"user,omitempty"`
+	Timeout    int               `json:"timeout,omitempty"` // In seconds, default DefaultDockerExecTimeout
+}
+
+// DockerExecResult is the outcome of a command run in a container
+type DockerExecResult struct {
+	Container     string  `json:"container"`
+	Command       string  `json:"command"`
+	ExitCode      int     `json:"exitCode"`
+	Stdout        string  `json:"stdout"`
+	Stderr        string  `json:"stderr"`
+	ExecutionTime float64 `json:"executionTime"` // In seconds
+	Truncated     bool    `json:"truncated,omitempty"`
+}
+
+// DockerService talks to the Docker Engine API over its unix socket
+type DockerService struct {
+	socket string
+	client *http.Client
+	audit  *AuditService
+}
+
+// NewDockerService creates a client for the socket named by DOCKER_HOST
+// (unix:// only) or DefaultDockerSocket. The daemon is not contacted until
+// the first request.
+func NewDockerService(audit *AuditService) *DockerService {
+	socket := DefaultDockerSocket
+	if host := os.Getenv("DOCKER_HOST"); strings.HasPrefix(host, "unix://") {
+		socket = strings.TrimPrefix(host, "unix://")
+	}
+
+	return &DockerService{
+		socket: socket,
+		audit:  audit,
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+// request calls the Engine API and decodes a JSON response into out, if set
+func (ds *DockerService) request(ctx context.Context, method string, path string, query url.Values, body interface{}, out interface{}) error {
+	resp, err := ds.do(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// do sends an Engine API request, turning error statuses into DockerError
+func (ds *DockerService) do(ctx context.Context, method string, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	target := "http://docker/" + dockerAPIVersion + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := ds.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %v", ErrDockerUnavailable, err)
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		var apiError struct {
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		if json.Unmarshal(data, &apiError) != nil || apiError.Message == "" {
+			apiError.Message = strings.TrimSpace(string(data))
+		}
+		return nil, &DockerError{Status: resp.StatusCode, Message: apiError.Message}
+	}
+	return resp, nil
+}
+
+// ListContainers lists running containers, or all of them when all is set
+func (ds *DockerService) ListContainers(all bool) ([]DockerContainer, error) {
+	var raw []struct {
+		ID      string `json:"Id"`
+		Names   []string
+		Image   string
+		Command string
+		State   string
+		Status  string
+		Created int64
+		Ports   []struct {
+			IP          string
+			PrivatePort int
+			PublicPort  int
+			Type        string
+		}
+		Labels map[string]string
+	}
+	query := url.Values{"all": {strconv.FormatBool(all)}}
+	if err := ds.request(context.Background(), http.MethodGet, "/containers/json", query, nil, &raw); err != nil {
+		return nil, err
+	}
+
+	containers := make([]DockerContainer, 0, len(raw))
+	for _, c := range raw {
+		container := DockerContainer{
+			ID:      c.ID,
+			Image:   c.Image,
+			Command: c.Command,
+			State:   c.State,
+			Status:  c.Status,
+			Created: time.Unix(c.Created, 0),
+			Ports:   []DockerPort{},
+			Labels:  c.Labels,
+		}
+		if len(c.Names) > 0 {
+			container.Name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		for _, p := range c.Ports {
+			container.Ports = append(container.Ports, DockerPort(p))
+		}
+		containers = append(containers, container)
+	}
+	return containers, nil
+}
+
+// ListImages lists local images
+func (ds *DockerService) ListImages() ([]DockerImage, error) {
+	var raw []struct {
+		ID       string `json:"Id"`
+		RepoTags []string
+		Size     int64
+		Created  int64
+	}
+	if err := ds.request(context.Background(), http.MethodGet, "/images/json", nil, nil, &raw); err != nil {
+		return nil, err
+	}
+
+	images := make([]DockerImage, 0, len(raw))
+	for _, image := range raw {
+		tags := []string{}
+		for _, tag := range image.RepoTags {
+			if tag != "<none>:<none>" {
+				tags = append(tags, tag)
+			}
+		}
+		images = append(images, DockerImage{
+			ID:      image.ID,
+			Tags:    tags,
+			Size:    image.Size,
+			Created: time.Unix(image.Created, 0),
+		})
+	}
+	return images, nil
+}
+
+// GetLogs returns the recent output of a container
+func (ds *DockerService) GetLogs(container string, query *DockerLogQuery) (*DockerLogs, error) {
+	if err := validateContainerRef(container); err != nil {
+		return nil, err
+	}
+	tail := query.Tail
+	if tail < 0 {
+		return nil, errors.New("tail must not be negative")
+	}
+	if tail == 0 {
+		tail = DefaultDockerLogTail
+	}
+
+	params := url.Values{
+		"stdout":     {"true"},
+		"stderr":     {"true"},
+		"tail":       {strconv.Itoa(tail)},
+		"timestamps": {strconv.FormatBool(query.Timestamps)},
+	}
+	if !query.Since.IsZero() {
+		params.Set("since", strconv.FormatInt(query.Since.Unix(), 10))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tty, err := ds.containerTTY(ctx, container)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ds.do(ctx, http.MethodGet, "/containers/"+url.PathEscape(container)+"/logs", params, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	stdout := &limitedBuffer{limit: maxDockerOutputBytes}
+	stderr := &limitedBuffer{limit: maxDockerOutputBytes}
+	if err := demuxDockerStream(resp.Body, tty, stdout, stderr); err != nil {
+		return nil, err
+	}
+
+	return &DockerLogs{
+		Container: container,
+		Stdout:    stdout.String(),
+		Stderr:    stderr.String(),
+		Truncated: stdout.truncated || stderr.truncated,
+	}, nil
+}
+
+// containerTTY reports whether a container runs with a terminal, in which
+// case its output is not multiplexed
+func (ds *DockerService) containerTTY(ctx context.Context, container string) (bool, error) {
+	var inspect struct {
+		Config struct {
+			Tty bool
+		}
+	}
+	if err := ds.request(ctx, http.MethodGet, "/containers/"+url.PathEscape(container)+"/json", nil, nil, &inspect); err != nil {
+		return false, err
+	}
+	return inspect.Config.Tty, nil
+}
+
+// Exec runs a command in a running container and waits for it to finish,
+// recording it in the audit log
+func (ds *DockerService) Exec(container string, request *DockerExecRequest) (*DockerExecResult, error) {
+	result, err := ds.exec(container, request)
+
+	detail := "container " + container
+	if result != nil {
+		detail += fmt.Sprintf(", exit code %d", result.ExitCode)
+	}
+	ds.audit.Record(AuditEntry{Operation: "docker.exec", Target: request.Command, Detail: detail}, err)
+
+	return result, err
+}
+
+func (ds *DockerService) exec(container string, request *DockerExecRequest) (*DockerExecResult, error) {
+	if err := validateContainerRef(container); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(request.Command) == "" {
+		return nil, errors.New("command is required")
+	}
+	timeout := request.Timeout
+	if timeout < 0 {
+		return nil, errors.New("timeout must not be negative")
+	}
+	if timeout == 0 {
+		timeout = DefaultDockerExecTimeout
+	}
+
+	env := make([]string, 0, len(request.Env))
+	for k, v := range request.Env {
+		env = append(env, k+"="+v)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	var created struct {
+		ID string `json:"Id"`
+	}
+	err := ds.request(ctx, http.MethodPost, "/containers/"+url.PathEscape(container)+"/exec", nil, map[string]interface{}{
+		"Cmd":          []string{"sh", "-c", request.Command},
+		"AttachStdout": true,
+		"AttachStderr": true,
+		"WorkingDir":   request.WorkingDir,
+		"Env":          env,
+		"User":         request.User,
+	}, &created)
+	if err != nil {
+		return nil, err
+	}
+
+	startTime := time.Now()
+	resp, err := ds.do(ctx, http.MethodPost, "/exec/"+created.ID+"/start", nil, map[string]bool{"Detach": false, "Tty": false})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	stdout := &limitedBuffer{limit: maxDockerOutputBytes}
+	stderr := &limitedBuffer{limit: maxDockerOutputBytes}
+	if err := demuxDockerStream(resp.Body, false, stdout, stderr); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("command timed out after %d seconds", timeout)
+		}
+		return nil, err
+	}
+
+	var inspect struct {
+		ExitCode int
+	}
+	if err := ds.request(ctx, http.MethodGet, "/exec/"+created.ID+"/json", nil, nil, &inspect); err != nil {
+		return nil, err
+	}
+
+	return &DockerExecResult{
+		Container:     container,
+		Command:       request.Command,
+		ExitCode:      inspect.ExitCode,
+		Stdout:        stdout.String(),
+		Stderr:        stderr.String(),
+		ExecutionTime: time.Since(startTime).Seconds(),
+		Truncated:     stdout.truncated || stderr.truncated,
+	}, nil
+}
+
+// StartContainer starts a stopped container; starting a running one is a
+// no-op
+func (ds *DockerService) StartContainer(container string) error {
+	if err := validateContainerRef(container); err != nil {
+		return err
+	}
+	err := ds.request(context.Background(), http.MethodPost, "/containers/"+url.PathEscape(container)+"/start", nil, nil, nil)
+	ds.audit.Record(AuditEntry{Operation: "docker.start", Target: container}, err)
+	return err
+}
+
+// StopContainer stops a container, killing it after timeout seconds
+func (ds *DockerService) StopContainer(container string, timeout int) error {
+	if err := validateContainerRef(container); err != nil {
+		return err
+	}
+	if timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+
+	query := url.Values{"t": {strconv.Itoa(timeout)}}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout+30)*time.Second)
+	defer cancel()
+	err := ds.request(ctx, http.MethodPost, "/containers/"+url.PathEscape(container)+"/stop", query, nil, nil)
+	ds.audit.Record(AuditEntry{Operation: "docker.stop", Target: container}, err)
+	return err
+}
+
+// validateContainerRef checks a container ID or name
+func validateContainerRef(container string) error {
+	if container == "" || strings.ContainsAny(container, "/?#") {
+		return fmt.Errorf("invalid container: %s", container)
+	}
+	return nil
+}
+
+// demuxDockerStream splits the output of a container without a terminal,
+// which Docker sends as frames of an 8-byte header (stream type and
+// big-endian length) followed by the payload
+func demuxDockerStream(r io.Reader, tty bool, stdout io.Writer, stderr io.Writer) error {
+	if tty {
+		_, err := io.Copy(stdout, r)
+		return err
+	}
+
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		target := stdout
+		if header[0] == 2 {
+			target = stderr
+		}
+		if _, err := io.CopyN(target, r, size); err != nil {
+			return err
+		}
+	}
+}
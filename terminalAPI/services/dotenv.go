@@ -0,0 +1,253 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DotenvRequest names the file to load, relative to the session working
+// directory
+type DotenvRequest struct {
+	File string `json:"file,omitempty"` // Defaults to .env
+	// Keep variables the session already has instead of overwriting them
+	NoOverride bool `json:"noOverride,omitempty"`
+}
+
+// DotenvResult reports how a dotenv file changed a session's environment
+type DotenvResult struct {
+	File       string   `json:"file"`
+	Set        []string `json:"set"`               // New variables
+	Overridden []string `json:"overridden"`        // Existing variables given a different value
+	Unchanged  []string `json:"unchanged"`         // Existing variables with the same value
+	Skipped    []string `json:"skipped,omitempty"` // Existing variables kept because of noOverride
+}
+
+var dotenvKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// dotenvReference matches $VAR, ${VAR} and ${VAR:-default}
+var dotenvReference = regexp.MustCompile(`\$(?:\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}|([A-Za-z_][A-Za-z0-9_]*))`)
+
+// LoadDotenv reads a dotenv file from the session working directory and
+// merges its variables into the session environment
+func (es *EnvService) LoadDotenv(sessionID string, request *DotenvRequest) (*DotenvResult, error) {
+	session, err := es.sessionManager.GetSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if session.WorkingDir == "" {
+		return nil, ErrNoWorkingDir
+	}
+	if session.Remote != nil {
+		return nil, fmt.Errorf("loading dotenv files is %w", ErrRemoteUnsupported)
+	}
+
+	name := request.File
+	if name == "" {
+		name = ".env"
+	}
+	path, err := resolveSessionFile(session, name)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := es.GetEnvVars(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	variables, order, err := parseDotenv(string(content), func(key string) (string, bool) {
+		if value, exists := current[key]; exists {
+			return value, true
+		}
+		return os.LookupEnv(key)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	result := &DotenvResult{
+		File:       name,
+		Set:        []string{},
+		Overridden: []string{},
+		Unchanged:  []string{},
+	}
+	updates := make(map[string]string)
+	for _, key := range order {
+		value := variables[key]
+		previous, exists := current[key]
+		switch {
+		case !exists:
+			result.Set = append(result.Set, key)
+		case previous == value:
+			result.Unchanged = append(result.Unchanged, key)
+			continue
+		case request.NoOverride:
+			result.Skipped = append(result.Skipped, key)
+			continue
+		default:
+			result.Overridden = append(result.Overridden, key)
+		}
+		updates[key] = value
+	}
+
+	if err := es.SetBatchEnvVars(sessionID, updates); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(result.Set)
+	sort.Strings(result.Overridden)
+	sort.Strings(result.Unchanged)
+	sort.Strings(result.Skipped)
+
+	es.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityEnv,
+		Target:   name,
+		Message:  fmt.Sprintf("Loaded %d variables from %s", len(updates), name),
+	})
+	return result, nil
+}
+
+// resolveSessionFile returns the path of a file that must lie inside the
+// session working directory, including after following symlinks
+func resolveSessionFile(session *Session, name string) (string, error) {
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(session.WorkingDir, path)
+	}
+
+	root, err := filepath.EvalSymlinks(session.WorkingDir)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || escapesRoot(rel) {
+		return "", fmt.Errorf("%s is outside the session working directory", name)
+	}
+	return resolved, nil
+}
+
+// parseDotenv parses dotenv syntax: KEY=value lines with an optional export
+// prefix, # comments, single-quoted literal values, double-quoted values with
+// escapes that may span lines, and $VAR, ${VAR} or ${VAR:-default}
+// references in unquoted and double-quoted values. References resolve to
+// variables defined earlier in the file, then through lookup. It returns the
+// variables and the order their keys first appear in.
+func parseDotenv(content string, lookup func(string) (string, bool)) (map[string]string, []string, error) {
+	variables := make(map[string]string)
+	var order []string
+
+	resolve := func(key string) (string, bool) {
+		if value, exists := variables[key]; exists {
+			return value, true
+		}
+		return lookup(key)
+	}
+
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNumber := i + 1
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return nil, nil, fmt.Errorf("line %d: expected KEY=value", lineNumber)
+		}
+		key := strings.TrimSpace(line[:eq])
+		if !dotenvKeyPattern.MatchString(key) {
+			return nil, nil, fmt.Errorf("line %d: invalid variable name %q", lineNumber, key)
+		}
+		raw := strings.TrimLeft(line[eq+1:], " \t")
+
+		var value string
+		switch {
+		case strings.HasPrefix(raw, "'"):
+			end := strings.Index(raw[1:], "'")
+			if end < 0 {
+				return nil, nil, fmt.Errorf("line %d: unterminated single quote", lineNumber)
+			}
+			value = raw[1 : end+1]
+		case strings.HasPrefix(raw, `"`):
+			// Keep reading lines until the closing quote
+			text := raw[1:]
+			for {
+				if end := closingQuote(text); end >= 0 {
+					text = text[:end]
+					break
+				}
+				if i+1 >= len(lines) {
+					return nil, nil, fmt.Errorf("line %d: unterminated double quote", lineNumber)
+				}
+				i++
+				text += "\n" + lines[i]
+			}
+			value = expandReferences(unescapeDotenv(text), resolve)
+		default:
+			// An unquoted value ends at a comment preceded by whitespace
+			if idx := strings.Index(raw, " #"); idx >= 0 {
+				raw = raw[:idx]
+			}
+			if idx := strings.Index(raw, "\t#"); idx >= 0 {
+				raw = raw[:idx]
+			}
+			value = expandReferences(strings.TrimSpace(raw), resolve)
+		}
+
+		if _, exists := variables[key]; !exists {
+			order = append(order, key)
+		}
+		variables[key] = value
+	}
+	return variables, order, nil
+}
+
+// closingQuote returns the index of the first unescaped double quote
+func closingQuote(text string) int {
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+func unescapeDotenv(text string) string {
+	replacer := strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\r`, "\r", `\"`, `"`, `\\`, `\`, `\$`, "\x00")
+	return replacer.Replace(text)
+}
+
+// expandReferences substitutes variable references; escaped dollars, marked
+// with NUL by unescapeDotenv, are restored literally
+func expandReferences(value string, resolve func(string) (string, bool)) string {
+	expanded := dotenvReference.ReplaceAllStringFunc(value, func(reference string) string {
+		match := dotenvReference.FindStringSubmatch(reference)
+		key := match[1]
+		if key == "" {
+			key = match[3]
+		}
+		if resolved, exists := resolve(key); exists && resolved != "" {
+			return resolved
+		}
+		return match[2]
+	})
+	return strings.ReplaceAll(expanded, "\x00", "$")
+}
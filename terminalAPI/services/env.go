@@ -1,18 +1,28 @@
 package services
 
 import (
-	"errors"
 	"fmt"
+	"log/slog"
+	"sync"
 )
 
 type EnvService struct {
 	sessionManager *SessionManager
+	profiles       map[string]*EnvProfile
+	profilesPath   string
+	profileMutex   sync.Mutex
 }
 
 func NewEnvService(sm *SessionManager) *EnvService {
-	return &EnvService{
+	es := &EnvService{
 		sessionManager: sm,
+		profiles:       make(map[string]*EnvProfile),
+		profilesPath:   envProfilesPath(),
 	}
+	if err := es.loadProfiles(); err != nil {
+		slog.Warn("failed to load environment profiles", "path", es.profilesPath, "error", err)
+	}
+	return es
 }
 
 func (es *EnvService) SetEnvVar(sessionID string, key string, value string) error {
@@ -22,7 +32,15 @@ func (es *EnvService) SetEnvVar(sessionID string, key string, value string) erro
 	es.sessionManager.mutex.RUnlock()
 	
 	if (!exists) {
-		return errors.New("session not found")
+		return ErrSessionNotFound
+	}
+	
+	if key == "SHELL" && session.runsOnHost() {
+		shellPath, err := es.sessionManager.validateShell(value)
+		if err != nil {
+			return err
+		}
+		value = shellPath
 	}
 	
 	// Simple operation with minimal locking
@@ -33,8 +51,9 @@ func (es *EnvService) SetEnvVar(sessionID string, key string, value string) erro
 	session.EnvVars[key] = value
 	session.Lock.Unlock()
 	
-	// Skip logging to avoid deadlocks
-	fmt.Printf("[TERMINAL] Session %s: Set environment variable %s=%s\n", sessionID, key, value)
+	// Skip logging to avoid deadlocks; the value is left out as it may be a
+	// credential
+	es.sessionManager.Audit(sessionID, "env.set", key, "", nil)
 	return nil
 }
 
@@ -45,7 +64,7 @@ func (es *EnvService) GetEnvVars(sessionID string) (map[string]string, error) {
 	es.sessionManager.mutex.RUnlock()
 	
 	if (!exists) {
-		return nil, errors.New("session not found")
+		return nil, ErrSessionNotFound
 	}
 	
 	// Get a snapshot of environment variables
@@ -56,7 +75,7 @@ func (es *EnvService) GetEnvVars(sessionID string) (map[string]string, error) {
 	}
 	session.Lock.Unlock()
 	
-	fmt.Printf("[TERMINAL] Session %s: Retrieved environment variables\n", sessionID)
+	es.sessionManager.Audit(sessionID, "env.list", "", "", nil)
 	return result, nil
 }
 
@@ -67,7 +86,7 @@ func (es *EnvService) UnsetEnvVar(sessionID string, key string) error {
 	es.sessionManager.mutex.RUnlock()
 	
 	if !exists {
-		return errors.New("session not found")
+		return ErrSessionNotFound
 	}
 	
 	// Just delete the key - no complex locking or checks
@@ -78,7 +97,7 @@ func (es *EnvService) UnsetEnvVar(sessionID string, key string) error {
 	}
 	session.Lock.Unlock()
 	
-	fmt.Printf("[TERMINAL] Session %s: Unset environment variable %s\n", sessionID, key)
+	es.sessionManager.Audit(sessionID, "env.unset", key, "", nil)
 	return nil
 }
 
@@ -89,7 +108,15 @@ func (es *EnvService) SetBatchEnvVars(sessionID string, envVars map[string]strin
 	es.sessionManager.mutex.RUnlock()
 	
 	if (!exists) {
-		return errors.New("session not found")
+		return ErrSessionNotFound
+	}
+	
+	if shell, exists := envVars["SHELL"]; exists && session.runsOnHost() {
+		shellPath, err := es.sessionManager.validateShell(shell)
+		if err != nil {
+			return err
+		}
+		envVars["SHELL"] = shellPath
 	}
 	
 	// Single lock for the entire batch update
@@ -104,6 +131,6 @@ func (es *EnvService) SetBatchEnvVars(sessionID string, envVars map[string]strin
 	}
 	session.Lock.Unlock()
 	
-	fmt.Printf("[TERMINAL] Session %s: Set %d environment variables\n", sessionID, len(envVars))
+	es.sessionManager.Audit(sessionID, "env.set", "", fmt.Sprintf("%d variables", len(envVars)), nil)
 	return nil
 }
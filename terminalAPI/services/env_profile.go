@@ -0,0 +1,292 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// EnvProfile is a named set of environment variables that can be applied to
+// any session, e.g. the variables of a dev or staging environment
+type EnvProfile struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Variables   map[string]string `json:"variables"`
+	Owner       string            `json:"owner,omitempty"` // Name of the API key that saved the profile
+	CreatedAt   time.Time         `json:"createdAt"`
+	UpdatedAt   time.Time         `json:"updatedAt"`
+}
+
+// EnvProfileSummary lists a profile without its values
+type EnvProfileSummary struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Keys        []string  `json:"keys"`
+	Owner       string    `json:"owner,omitempty"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// SaveProfileRequest saves a session's variables as a profile
+type SaveProfileRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Keys        []string `json:"keys,omitempty"` // Only save these variables, default all but SHELL
+}
+
+// ApplyProfileRequest applies a profile to a session
+type ApplyProfileRequest struct {
+	Replace bool `json:"replace,omitempty"` // Unset the session's other variables first, except SHELL
+}
+
+// ApplyProfileResult reports how a profile changed a session's environment
+type ApplyProfileResult struct {
+	Profile    string   `json:"profile"`
+	Set        []string `json:"set"`
+	Overridden []string `json:"overridden"` // Variables that had a different value before
+	Removed    []string `json:"removed,omitempty"`
+}
+
+// ErrProfileNotFound is returned for unknown profile names
+var ErrProfileNotFound = errors.New("profile not found")
+
+// ErrProfileNameTaken is returned when saving over a profile another API
+// key owns
+var ErrProfileNameTaken = errors.New("profile name is used by another API key")
+
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// envProfilesPath returns the file profiles are saved in
+func envProfilesPath() string {
+	if path := os.Getenv("TERMINAL_ENV_PROFILES"); path != "" {
+		return path
+	}
+	return filepath.Join(filepath.Dir(defaultLogDir()), "env-profiles.json")
+}
+
+// loadProfiles reads the saved profiles, if any
+func (es *EnvService) loadProfiles() error {
+	data, err := os.ReadFile(es.profilesPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &es.profiles)
+}
+
+// saveProfilesLocked writes the profiles, readable only by the server user
+// since they often hold credentials. Must be called with profileMutex held.
+func (es *EnvService) saveProfilesLocked() error {
+	data, err := json.MarshalIndent(es.profiles, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(es.profilesPath), 0755); err != nil {
+		return err
+	}
+
+	tmp := es.profilesPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, es.profilesPath)
+}
+
+// SaveProfile stores the session's current variables under a profile name,
+// replacing an existing profile of that name. Profiles often hold
+// credentials, so like sessions they belong to the API key that saved them:
+// the owner filter, empty for admins, limits which profiles may be replaced.
+func (es *EnvService) SaveProfile(sessionID string, owner string, filter string, request *SaveProfileRequest) (*EnvProfile, error) {
+	if !profileNamePattern.MatchString(request.Name) {
+		return nil, errors.New("profile name must only contain letters, digits, '.', '_' and '-'")
+	}
+
+	envVars, err := es.GetEnvVars(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	variables := make(map[string]string)
+	if len(request.Keys) > 0 {
+		for _, key := range request.Keys {
+			value, exists := envVars[key]
+			if !exists {
+				return nil, fmt.Errorf("variable %s is not set in the session", key)
+			}
+			variables[key] = value
+		}
+	} else {
+		for key, value := range envVars {
+			// The shell belongs to the session, not to the environment
+			if key != "SHELL" {
+				variables[key] = value
+			}
+		}
+	}
+
+	es.profileMutex.Lock()
+	defer es.profileMutex.Unlock()
+
+	now := time.Now()
+	profile := &EnvProfile{
+		Name:        request.Name,
+		Description: request.Description,
+		Variables:   variables,
+		Owner:       owner,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if existing, exists := es.profiles[request.Name]; exists {
+		if !profileVisible(existing, filter) {
+			return nil, ErrProfileNameTaken
+		}
+		profile.Owner = existing.Owner
+		profile.CreatedAt = existing.CreatedAt
+	}
+
+	previous := es.profiles[request.Name]
+	es.profiles[request.Name] = profile
+	if err := es.saveProfilesLocked(); err != nil {
+		if previous != nil {
+			es.profiles[request.Name] = previous
+		} else {
+			delete(es.profiles, request.Name)
+		}
+		return nil, fmt.Errorf("failed to save profile: %v", err)
+	}
+
+	es.sessionManager.Audit(sessionID, "env.profile.save", request.Name, fmt.Sprintf("%d variables", len(variables)), nil)
+	return profile, nil
+}
+
+// profileVisible reports whether the owner filter, empty for every profile,
+// admits a profile
+func profileVisible(profile *EnvProfile, owner string) bool {
+	return owner == "" || profile.Owner == owner
+}
+
+// ListProfiles returns the owner's profiles, or every profile for an empty
+// owner, without their values
+func (es *EnvService) ListProfiles(owner string) []EnvProfileSummary {
+	es.profileMutex.Lock()
+	defer es.profileMutex.Unlock()
+
+	summaries := make([]EnvProfileSummary, 0, len(es.profiles))
+	for _, profile := range es.profiles {
+		if !profileVisible(profile, owner) {
+			continue
+		}
+		keys := make([]string, 0, len(profile.Variables))
+		for key := range profile.Variables {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		summaries = append(summaries, EnvProfileSummary{
+			Name:        profile.Name,
+			Description: profile.Description,
+			Keys:        keys,
+			Owner:       profile.Owner,
+			UpdatedAt:   profile.UpdatedAt,
+		})
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Name < summaries[j].Name
+	})
+	return summaries
+}
+
+// GetProfile returns a profile with its values. Profiles of other owners
+// are reported as not found.
+func (es *EnvService) GetProfile(name string, owner string) (*EnvProfile, error) {
+	es.profileMutex.Lock()
+	defer es.profileMutex.Unlock()
+
+	profile, exists := es.profiles[name]
+	if !exists || !profileVisible(profile, owner) {
+		return nil, ErrProfileNotFound
+	}
+	return profile, nil
+}
+
+// DeleteProfile removes a profile the owner filter admits
+func (es *EnvService) DeleteProfile(name string, owner string) error {
+	es.profileMutex.Lock()
+	defer es.profileMutex.Unlock()
+
+	profile, exists := es.profiles[name]
+	if !exists || !profileVisible(profile, owner) {
+		return ErrProfileNotFound
+	}
+
+	delete(es.profiles, name)
+	if err := es.saveProfilesLocked(); err != nil {
+		es.profiles[name] = profile
+		return fmt.Errorf("failed to save profiles: %v", err)
+	}
+
+	es.sessionManager.AuditService().Record(AuditEntry{Operation: "env.profile.delete", Target: name}, nil)
+	return nil
+}
+
+// ApplyProfile sets the variables of a profile the owner filter admits in a
+// session
+func (es *EnvService) ApplyProfile(sessionID string, name string, owner string, request *ApplyProfileRequest) (*ApplyProfileResult, error) {
+	profile, err := es.GetProfile(name, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	es.sessionManager.mutex.RLock()
+	session, exists := es.sessionManager.sessions[sessionID]
+	es.sessionManager.mutex.RUnlock()
+
+	if !exists {
+		return nil, ErrSessionNotFound
+	}
+
+	result := &ApplyProfileResult{
+		Profile:    name,
+		Set:        []string{},
+		Overridden: []string{},
+	}
+
+	session.Lock.Lock()
+	if session.EnvVars == nil {
+		session.EnvVars = make(map[string]string)
+	}
+	if request.Replace {
+		for key := range session.EnvVars {
+			if _, kept := profile.Variables[key]; !kept && key != "SHELL" {
+				delete(session.EnvVars, key)
+				result.Removed = append(result.Removed, key)
+			}
+		}
+	}
+	for key, value := range profile.Variables {
+		if previous, exists := session.EnvVars[key]; exists && previous != value {
+			result.Overridden = append(result.Overridden, key)
+		}
+		session.EnvVars[key] = value
+		result.Set = append(result.Set, key)
+	}
+	session.Lock.Unlock()
+
+	sort.Strings(result.Set)
+	sort.Strings(result.Overridden)
+	sort.Strings(result.Removed)
+
+	es.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityEnv,
+		Target:   name,
+		Message:  "Applied environment profile " + name,
+	})
+	es.sessionManager.Audit(sessionID, "env.profile.apply", name, fmt.Sprintf("%d variables", len(result.Set)), nil)
+	return result, nil
+}
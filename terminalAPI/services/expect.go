@@ -0,0 +1,174 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// expectWindow is how much recent output each stream keeps for matching
+const expectWindow = 4096
+
+// ExpectRule answers output matching a pattern by writing a response to the
+// process's stdin
+type ExpectRule struct {
+	Pattern    string `json:"pattern"`
+	Response   string `json:"response"`             // A newline is appended if missing
+	Stream     string `json:"stream,omitempty"`     // stdout (default), stderr or any
+	MaxMatches int    `json:"maxMatches,omitempty"` // 0 means unlimited
+	Secret     bool   `json:"secret,omitempty"`     // Redact the response in the audit log
+}
+
+// ExpectEvent is the audit record of one automatic response
+type ExpectEvent struct {
+	Rule      int       `json:"rule"` // Index into the process's expect rules
+	Pattern   string    `json:"pattern"`
+	Stream    string    `json:"stream"`
+	Matched   string    `json:"matched"`
+	Response  string    `json:"response"`
+	Error     string    `json:"error,omitempty"` // Writing the response failed
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// expectMatcher watches process output and applies expect rules. Matched
+// output is consumed so the same prompt is answered only once.
+type expectMatcher struct {
+	rules   []ExpectRule
+	regexps []*regexp.Regexp
+	matches []int
+	windows map[string][]byte
+	events  []ExpectEvent
+	stdin   io.Writer
+	onMatch func(event ExpectEvent)
+	mutex   sync.Mutex
+}
+
+// newExpectMatcher validates and compiles expect rules
+func newExpectMatcher(rules []ExpectRule) (*expectMatcher, error) {
+	em := &expectMatcher{
+		rules:   rules,
+		regexps: make([]*regexp.Regexp, len(rules)),
+		matches: make([]int, len(rules)),
+		windows: make(map[string][]byte),
+		events:  []ExpectEvent{},
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Pattern == "" {
+			return nil, fmt.Errorf("expect rule %d is missing a pattern", i)
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("expect rule %d has an invalid pattern: %v", i, err)
+		}
+		em.regexps[i] = re
+
+		switch rule.Stream {
+		case "":
+			rule.Stream = "stdout"
+		case "stdout", "stderr", "any":
+		default:
+			return nil, fmt.Errorf("expect rule %d has unsupported stream: %s", i, rule.Stream)
+		}
+		if rule.MaxMatches < 0 {
+			return nil, errors.New("expect maxMatches must not be negative")
+		}
+	}
+
+	return em, nil
+}
+
+// feed adds output from a stream and answers every rule it now satisfies
+func (em *expectMatcher) feed(stream string, data []byte) {
+	em.mutex.Lock()
+	window := append(em.windows[stream], data...)
+	if len(window) > expectWindow {
+		window = window[len(window)-expectWindow:]
+	}
+
+	var answered []ExpectEvent
+	for {
+		rule, loc := em.firstMatch(stream, window)
+		if rule < 0 {
+			break
+		}
+		em.matches[rule]++
+		answered = append(answered, ExpectEvent{
+			Rule:      rule,
+			Pattern:   em.rules[rule].Pattern,
+			Stream:    stream,
+			Matched:   string(window[loc[0]:loc[1]]),
+			Timestamp: time.Now(),
+		})
+		window = window[loc[1]:]
+	}
+	em.windows[stream] = window
+	em.mutex.Unlock()
+
+	for _, event := range answered {
+		response := em.rules[event.Rule].Response
+		if !strings.HasSuffix(response, "\n") {
+			response += "\n"
+		}
+		if _, err := io.WriteString(em.stdin, response); err != nil {
+			event.Error = err.Error()
+		}
+
+		event.Response = em.rules[event.Rule].Response
+		if em.rules[event.Rule].Secret {
+			event.Response = "[redacted]"
+		}
+
+		em.mutex.Lock()
+		em.events = append(em.events, event)
+		em.mutex.Unlock()
+
+		if em.onMatch != nil {
+			em.onMatch(event)
+		}
+	}
+}
+
+// firstMatch returns the rule whose match ends earliest in the window, so
+// prompts are answered in the order they appeared
+func (em *expectMatcher) firstMatch(stream string, window []byte) (int, []int) {
+	best, bestLoc := -1, []int(nil)
+	for i, rule := range em.rules {
+		if rule.Stream != "any" && rule.Stream != stream {
+			continue
+		}
+		if rule.MaxMatches > 0 && em.matches[i] >= rule.MaxMatches {
+			continue
+		}
+		loc := em.regexps[i].FindIndex(window)
+		if loc != nil && loc[1] > loc[0] && (best < 0 || loc[1] < bestLoc[1]) {
+			best, bestLoc = i, loc
+		}
+	}
+	return best, bestLoc
+}
+
+// Events returns the audit log of automatic responses
+func (em *expectMatcher) Events() []ExpectEvent {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+	return append([]ExpectEvent{}, em.events...)
+}
+
+// GetAutoResponses returns the automatic responses made to a process
+func (ps *ProcessService) GetAutoResponses(sessionID string, processID string) ([]ExpectEvent, error) {
+	process, err := ps.sessionManager.GetProcess(sessionID, processID)
+	if err != nil {
+		return nil, err
+	}
+
+	if process.expect == nil {
+		return []ExpectEvent{}, nil
+	}
+	return process.expect.Events(), nil
+}
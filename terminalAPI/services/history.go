@@ -1,24 +1,49 @@
 package services
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
 	"strings"
 	"sync"
 	"time"
 )
 
 type HistoryEntry struct {
+	ID        uint64    `json:"id"`
 	Command   string    `json:"command"`
 	Timestamp time.Time `json:"timestamp"`
+	ExitCode  *int      `json:"exitCode,omitempty"` // Set once the command has finished
 }
 
 type HistoryService struct {
 	history map[string][]HistoryEntry
 	mutex   sync.RWMutex
 	maxSize int
+	store   *historyStore // Nil when history is kept in memory only
+	lastID  uint64
+	audit   *AuditService
 }
 
-func NewHistoryService(maxSize int) *HistoryService {
+// HistoryQuery selects a page of history. Pages count back from the newest
+// entry: offset skips that many of the most recent matching entries.
+type HistoryQuery struct {
+	Limit  int
+	Offset int
+	Since  time.Time // Zero means no lower bound
+	Until  time.Time // Zero means no upper bound
+}
+
+// HistoryPage is a page of history in chronological order
+type HistoryPage struct {
+	History []HistoryEntry `json:"history"`
+	Count   int            `json:"count"`
+	Total   int            `json:"total"`   // Entries matching the time range
+	Offset  int            `json:"offset"`
+	HasMore bool           `json:"hasMore"` // Older matching entries exist
+}
+
+func NewHistoryService(maxSize int, audit *AuditService) *HistoryService {
 	if maxSize <= 0 {
 		maxSize = 1000 // Default history size
 	}
@@ -26,14 +51,74 @@ func NewHistoryService(maxSize int) *HistoryService {
 	return &HistoryService{
 		history: make(map[string][]HistoryEntry),
 		maxSize: maxSize,
+		audit:   audit,
+	}
+}
+
+// EnablePersistence stores history in the database named by
+// TERMINAL_HISTORY_DB, ~/.osai/history.db by default, and loads what earlier
+// runs of the server recorded. Setting TERMINAL_HISTORY_DB to "off" keeps
+// history in memory only.
+func (hs *HistoryService) EnablePersistence() error {
+	path := historyStorePath()
+	if path == "" {
+		return nil
+	}
+	
+	retention, err := historyRetention()
+	if err != nil {
+		return err
+	}
+	
+	store, err := openHistoryStore(path)
+	if err != nil {
+		return fmt.Errorf("failed to open history database %s: %v", path, err)
+	}
+	
+	history, err := store.load(hs.maxSize, retention)
+	if err != nil {
+		store.db.Close()
+		return fmt.Errorf("failed to load history from %s: %v", path, err)
+	}
+	
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+	
+	hs.store = store
+	for sessionID, entries := range history {
+		hs.history[sessionID] = append(entries, hs.history[sessionID]...)
+	}
+	
+	// Continue numbering after the stored entries
+	for _, entries := range hs.history {
+		for _, entry := range entries {
+			if entry.ID > hs.lastID {
+				hs.lastID = entry.ID
+			}
+		}
+	}
+	for _, entries := range hs.history {
+		for i := range entries {
+			if entries[i].ID == 0 {
+				hs.lastID++
+				entries[i].ID = hs.lastID
+			}
+		}
 	}
+	
+	slog.Info("loaded command history", "sessions", len(history), "path", path)
+	return nil
 }
 
-func (hs *HistoryService) AddToHistory(sessionID string, command string) {
+// AddToHistory records a command and returns the ID of its entry, used to
+// record the exit code once the command finishes
+func (hs *HistoryService) AddToHistory(sessionID string, command string) uint64 {
 	hs.mutex.Lock()
 	defer hs.mutex.Unlock()
 	
+	hs.lastID++
 	entry := HistoryEntry{
+		ID:        hs.lastID,
 		Command:   command,
 		Timestamp: time.Now(),
 	}
@@ -47,39 +132,83 @@ func (hs *HistoryService) AddToHistory(sessionID string, command string) {
 	hs.history[sessionID] = append(hs.history[sessionID], entry)
 	
 	// Trim history if it exceeds max size
+	trim := 0
 	if len(hs.history[sessionID]) > hs.maxSize {
-		hs.history[sessionID] = hs.history[sessionID][len(hs.history[sessionID])-hs.maxSize:]
+		trim = len(hs.history[sessionID]) - hs.maxSize
+		hs.history[sessionID] = hs.history[sessionID][trim:]
+	}
+	
+	if hs.store != nil {
+		if err := hs.store.append(sessionID, entry, trim); err != nil {
+			sessionLog(sessionID).Warn("failed to persist history", "error", err)
+		}
 	}
+	return entry.ID
 }
 
-func (hs *HistoryService) GetHistory(sessionID string, limit int) ([]HistoryEntry, error) {
+// RecordExitCode stores the exit code of a finished command on its entry
+func (hs *HistoryService) RecordExitCode(sessionID string, id uint64, exitCode int) {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+	
+	// The entry is almost always among the latest
+	entries := hs.history[sessionID]
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].ID != id {
+			continue
+		}
+		entries[i].ExitCode = &exitCode
+		if hs.store != nil {
+			if err := hs.store.update(sessionID, entries[i]); err != nil {
+				sessionLog(sessionID).Warn("failed to persist history", "error", err)
+			}
+		}
+		return
+	}
+}
+
+func (hs *HistoryService) GetHistory(sessionID string, query *HistoryQuery) (*HistoryPage, error) {
+	if query.Limit < 0 || query.Offset < 0 {
+		return nil, errors.New("limit and offset must not be negative")
+	}
+	
 	hs.mutex.RLock()
 	defer hs.mutex.RUnlock()
 	
-	sessionHistory, exists := hs.history[sessionID]
-	if !exists {
-		return []HistoryEntry{}, nil
+	// Entries matching the time range
+	var matching []HistoryEntry
+	for _, entry := range hs.history[sessionID] {
+		if !query.Since.IsZero() && entry.Timestamp.Before(query.Since) {
+			continue
+		}
+		if !query.Until.IsZero() && !entry.Timestamp.Before(query.Until) {
+			continue
+		}
+		matching = append(matching, entry)
 	}
 	
-	// If limit is 0 or negative, return all history
-	if limit <= 0 {
-		// Return a copy to prevent modification
-		result := make([]HistoryEntry, len(sessionHistory))
-		copy(result, sessionHistory)
-		return result, nil
+	// Count back from the newest entry; a limit of 0 returns everything
+	end := len(matching) - query.Offset
+	if end < 0 {
+		end = 0
 	}
-	
-	// Apply limit
 	start := 0
-	if len(sessionHistory) > limit {
-		start = len(sessionHistory) - limit
+	if query.Limit > 0 && end > query.Limit {
+		start = end - query.Limit
 	}
 	
-	result := make([]HistoryEntry, len(sessionHistory[start:]))
-	copy(result, sessionHistory[start:])
+	// Return a copy to prevent modification
+	result := make([]HistoryEntry, end-start)
+	copy(result, matching[start:end])
 	
-	fmt.Printf("[TERMINAL] Retrieved %d history entries for session %s\n", len(result), sessionID)
-	return result, nil
+	hs.audit.Record(AuditEntry{SessionID: sessionID, Operation: "history.read", Detail: fmt.Sprintf("%d entries", len(result))}, nil)
+	return &HistoryPage{
+		History: result,
+		Count:   len(result),
+		Total:   len(matching),
+		Offset:  query.Offset,
+		HasMore: start > 0,
+	}, nil
 }
 
 func (hs *HistoryService) SearchHistory(sessionID string, query string) ([]HistoryEntry, error) {
@@ -98,8 +227,7 @@ func (hs *HistoryService) SearchHistory(sessionID string, query string) ([]Histo
 		}
 	}
 	
-	fmt.Printf("[TERMINAL] Found %d history entries matching '%s' for session %s\n", 
-		len(results), query, sessionID)
+	hs.audit.Record(AuditEntry{SessionID: sessionID, Operation: "history.search", Target: query, Detail: fmt.Sprintf("%d entries", len(results))}, nil)
 	return results, nil
 }
 
@@ -108,6 +236,11 @@ func (hs *HistoryService) ClearHistory(sessionID string) error {
 	defer hs.mutex.Unlock()
 	
 	hs.history[sessionID] = make([]HistoryEntry, 0)
-	fmt.Printf("[TERMINAL] Cleared history for session %s\n", sessionID)
+	if hs.store != nil {
+		if err := hs.store.clear(sessionID); err != nil {
+			return err
+		}
+	}
+	hs.audit.Record(AuditEntry{SessionID: sessionID, Operation: "history.clear"}, nil)
 	return nil
 }
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// History formats understood by export and import
+const (
+	HistoryFormatBash = "bash"
+	HistoryFormatZsh  = "zsh"
+	HistoryFormatJSON = "json"
+)
+
+// HistoryImportRequest seeds a session's history from a shell history file,
+// either sent as content or read from the session working directory
+type HistoryImportRequest struct {
+	Format  string `json:"format,omitempty"` // bash (default), zsh or json
+	Content string `json:"content,omitempty"`
+	Path    string `json:"path,omitempty"` // e.g. .bash_history, inside the working directory
+}
+
+// zshExtendedLine matches the ": <start>:<elapsed>;<command>" lines zsh
+// writes with EXTENDED_HISTORY
+var zshExtendedLine = regexp.MustCompile(`(?s)^: (\d+):\d+;(.*)$`)
+
+// ExportHistory renders a session's history as a bash or zsh history file
+// or as JSON
+func (hs *HistoryService) ExportHistory(sessionID string, format string) ([]byte, error) {
+	hs.mutex.RLock()
+	entries := make([]HistoryEntry, len(hs.history[sessionID]))
+	copy(entries, hs.history[sessionID])
+	hs.mutex.RUnlock()
+
+	var buf bytes.Buffer
+	switch format {
+	case HistoryFormatBash, "":
+		// Timestamp comments as bash writes them with HISTTIMEFORMAT set
+		for _, entry := range entries {
+			fmt.Fprintf(&buf, "#%d\n%s\n", entry.Timestamp.Unix(), entry.Command)
+		}
+	case HistoryFormatZsh:
+		for _, entry := range entries {
+			command := strings.ReplaceAll(entry.Command, "\n", "\\\n")
+			fmt.Fprintf(&buf, ": %d:0;%s\n", entry.Timestamp.Unix(), command)
+		}
+	case HistoryFormatJSON:
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	default:
+		return nil, fmt.Errorf("unsupported history format: %s", format)
+	}
+
+	hs.audit.Record(AuditEntry{SessionID: sessionID, Operation: "history.export", Target: format, Detail: fmt.Sprintf("%d entries", len(entries))}, nil)
+	return buf.Bytes(), nil
+}
+
+// ImportHistory merges entries parsed from a history file into a session's
+// history by timestamp, keeping the newest entries when the result exceeds
+// the history size. It returns the number of entries parsed.
+func (hs *HistoryService) ImportHistory(session *Session, request *HistoryImportRequest) (int, error) {
+	sessionID := session.ID
+	content := request.Content
+	if request.Path != "" {
+		if content != "" {
+			return 0, errors.New("set either content or path, not both")
+		}
+		data, err := readHistoryFile(session, request.Path)
+		if err != nil {
+			return 0, err
+		}
+		content = string(data)
+	}
+
+	entries, err := parseHistory(content, request.Format)
+	if err != nil {
+		return 0, err
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+
+	for i := range entries {
+		hs.lastID++
+		entries[i].ID = hs.lastID
+	}
+	merged := append(entries, hs.history[sessionID]...)
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Timestamp.Before(merged[j].Timestamp)
+	})
+	if len(merged) > hs.maxSize {
+		merged = merged[len(merged)-hs.maxSize:]
+	}
+
+	if hs.store != nil {
+		if err := hs.store.replace(sessionID, merged); err != nil {
+			return 0, err
+		}
+	}
+	hs.history[sessionID] = merged
+
+	hs.audit.Record(AuditEntry{SessionID: sessionID, Operation: "history.import", Detail: fmt.Sprintf("%d entries", len(entries))}, nil)
+	return len(entries), nil
+}
+
+// parseHistory reads history entries from a history file. Entries without a
+// timestamp are given increasing times ending now so their order is kept.
+func parseHistory(content string, format string) ([]HistoryEntry, error) {
+	var entries []HistoryEntry
+	untimed := 0
+
+	add := func(command string, timestamp time.Time) {
+		if strings.TrimSpace(command) == "" {
+			return
+		}
+		if timestamp.IsZero() {
+			untimed++
+		}
+		entries = append(entries, HistoryEntry{Command: command, Timestamp: timestamp})
+	}
+
+	switch format {
+	case HistoryFormatBash, "":
+		// With timestamps, every line up to the next "#<epoch>" comment
+		// belongs to one command
+		lines := strings.Split(strings.TrimRight(content, "\n"), "\n")
+		var timestamp time.Time
+		var pending []string
+		timed := false
+		for _, line := range lines {
+			if epoch, ok := parseEpochComment(line); ok {
+				if timed {
+					add(strings.Join(pending, "\n"), timestamp)
+				}
+				timestamp, pending, timed = epoch, nil, true
+				continue
+			}
+			if timed {
+				pending = append(pending, line)
+			} else {
+				add(line, time.Time{})
+			}
+		}
+		if timed {
+			add(strings.Join(pending, "\n"), timestamp)
+		}
+	case HistoryFormatZsh:
+		// A trailing backslash continues a command on the next line
+		lines := strings.Split(strings.TrimRight(content, "\n"), "\n")
+		for i := 0; i < len(lines); i++ {
+			line := lines[i]
+			for strings.HasSuffix(line, "\\") && i+1 < len(lines) {
+				i++
+				line = strings.TrimSuffix(line, "\\") + "\n" + lines[i]
+			}
+			if match := zshExtendedLine.FindStringSubmatch(line); match != nil {
+				epoch, _ := strconv.ParseInt(match[1], 10, 64)
+				add(match[2], time.Unix(epoch, 0))
+			} else {
+				add(line, time.Time{})
+			}
+		}
+	case HistoryFormatJSON:
+		if err := json.Unmarshal([]byte(content), &entries); err != nil {
+			return nil, fmt.Errorf("invalid JSON history: %v", err)
+		}
+		filtered := entries[:0]
+		for _, entry := range entries {
+			if strings.TrimSpace(entry.Command) != "" {
+				if entry.Timestamp.IsZero() {
+					untimed++
+				}
+				filtered = append(filtered, entry)
+			}
+		}
+		entries = filtered
+	default:
+		return nil, fmt.Errorf("unsupported history format: %s", format)
+	}
+
+	// Space untimed entries a millisecond apart, the last one now
+	now := time.Now()
+	for i := range entries {
+		if entries[i].Timestamp.IsZero() {
+			untimed--
+			entries[i].Timestamp = now.Add(-time.Duration(untimed) * time.Millisecond)
+		}
+	}
+	return entries, nil
+}
+
+// parseEpochComment recognizes the "#1700000000" lines bash writes before
+// each command when HISTTIMEFORMAT is set
+func parseEpochComment(line string) (time.Time, bool) {
+	if len(line) < 2 || line[0] != '#' {
+		return time.Time{}, false
+	}
+	epoch, err := strconv.ParseInt(line[1:], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(epoch, 0), true
+}
+
+// readHistoryFile reads a history file, which like a dotenv file must lie
+// inside the session working directory
+func readHistoryFile(session *Session, name string) ([]byte, error) {
+	if session.WorkingDir == "" {
+		return nil, ErrNoWorkingDir
+	}
+	if session.Remote != nil {
+		return nil, fmt.Errorf("importing history files is %w", ErrRemoteUnsupported)
+	}
+
+	path, err := resolveSessionFile(session, name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultStatsTop is how many commands and programs stats list by default
+const DefaultStatsTop = 10
+
+// CommandStat summarizes how a command or program has been used
+type CommandStat struct {
+	Command  string `json:"command"`
+	Count    int    `json:"count"`
+	Failures int    `json:"failures"`
+	// Share of runs with a known exit code that failed, from 0 to 1
+	FailureRate float64   `json:"failureRate"`
+	LastUsed    time.Time `json:"lastUsed"`
+
+	finished int
+}
+
+// HistoryStats is computed from a session's history and the exit codes
+// recorded with it
+type HistoryStats struct {
+	TotalCommands  int           `json:"totalCommands"`
+	UniqueCommands int           `json:"uniqueCommands"`
+	Finished       int           `json:"finished"` // Commands with a recorded exit code
+	Failures       int           `json:"failures"`
+	FailureRate    float64       `json:"failureRate"`
+	TopCommands    []CommandStat `json:"topCommands"`
+	TopPrograms    []CommandStat `json:"topPrograms"` // Grouped by the executable run
+	// Commands run in each hour of the day and on each weekday, Sunday first
+	HourOfDay [24]int `json:"hourOfDay"`
+	DayOfWeek [7]int  `json:"dayOfWeek"`
+	Timezone  string  `json:"timezone"`
+}
+
+// GetStats computes usage statistics for a session's history. top limits
+// the command and program lists, and times are bucketed in location.
+func (hs *HistoryService) GetStats(sessionID string, top int, location *time.Location) *HistoryStats {
+	if top <= 0 {
+		top = DefaultStatsTop
+	}
+
+	hs.mutex.RLock()
+	entries := make([]HistoryEntry, len(hs.history[sessionID]))
+	copy(entries, hs.history[sessionID])
+	hs.mutex.RUnlock()
+
+	stats := &HistoryStats{
+		TotalCommands: len(entries),
+		Timezone:      location.String(),
+	}
+	commands := make(map[string]*CommandStat)
+	programs := make(map[string]*CommandStat)
+
+	for _, entry := range entries {
+		local := entry.Timestamp.In(location)
+		stats.HourOfDay[local.Hour()]++
+		stats.DayOfWeek[local.Weekday()]++
+
+		if entry.ExitCode != nil {
+			stats.Finished++
+			if *entry.ExitCode != 0 {
+				stats.Failures++
+			}
+		}
+
+		command := strings.TrimSpace(entry.Command)
+		countUse(commands, command, entry)
+		if program := programName(command); program != "" {
+			countUse(programs, program, entry)
+		}
+	}
+
+	if stats.Finished > 0 {
+		stats.FailureRate = float64(stats.Failures) / float64(stats.Finished)
+	}
+	stats.UniqueCommands = len(commands)
+	stats.TopCommands = topStats(commands, top)
+	stats.TopPrograms = topStats(programs, top)
+	return stats
+}
+
+func countUse(stats map[string]*CommandStat, key string, entry HistoryEntry) {
+	stat, exists := stats[key]
+	if !exists {
+		stat = &CommandStat{Command: key}
+		stats[key] = stat
+	}
+
+	stat.Count++
+	if entry.Timestamp.After(stat.LastUsed) {
+		stat.LastUsed = entry.Timestamp
+	}
+	if entry.ExitCode != nil {
+		stat.finished++
+		if *entry.ExitCode != 0 {
+			stat.Failures++
+		}
+	}
+}
+
+// topStats returns the most used entries, most recently used first on ties
+func topStats(stats map[string]*CommandStat, top int) []CommandStat {
+	result := make([]CommandStat, 0, len(stats))
+	for _, stat := range stats {
+		if stat.finished > 0 {
+			stat.FailureRate = float64(stat.Failures) / float64(stat.finished)
+		}
+		result = append(result, *stat)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].LastUsed.After(result[j].LastUsed)
+	})
+	if len(result) > top {
+		result = result[:top]
+	}
+	return result
+}
+
+// programName returns the executable a command line starts with, skipping
+// variable assignments and sudo
+func programName(command string) string {
+	for _, field := range strings.Fields(command) {
+		if strings.Contains(field, "=") && !strings.HasPrefix(field, "=") {
+			continue
+		}
+		if field == "sudo" {
+			continue
+		}
+		return filepath.Base(field)
+	}
+	return ""
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// History older than DefaultHistoryRetention is dropped when the store is
+// opened, unless TERMINAL_HISTORY_RETENTION sets another duration
+const DefaultHistoryRetention = 30 * 24 * time.Hour
+
+// historyStore persists command history in a bbolt database with one bucket
+// per session, keyed by an increasing sequence number
+type historyStore struct {
+	db *bolt.DB
+}
+
+// historyStorePath returns where history is persisted, or "" when
+// TERMINAL_HISTORY_DB is "off"
+func historyStorePath() string {
+	if path := os.Getenv("TERMINAL_HISTORY_DB"); path != "" {
+		if path == "off" {
+			return ""
+		}
+		return path
+	}
+	return filepath.Join(filepath.Dir(defaultLogDir()), "history.db")
+}
+
+// historyRetention returns how long persisted history is kept
+func historyRetention() (time.Duration, error) {
+	value := os.Getenv("TERMINAL_HISTORY_RETENTION")
+	if value == "" {
+		return DefaultHistoryRetention, nil
+	}
+	retention, err := time.ParseDuration(value)
+	if err != nil || retention <= 0 {
+		return 0, fmt.Errorf("invalid TERMINAL_HISTORY_RETENTION: %s", value)
+	}
+	return retention, nil
+}
+
+func openHistoryStore(path string) (*historyStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	// Fail instead of hanging when another server holds the database
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &historyStore{db: db}, nil
+}
+
+// load returns the stored history of every session, deleting entries older
+// than maxAge and all but the newest maxSize entries of each session
+func (s *historyStore) load(maxSize int, maxAge time.Duration) (map[string][]HistoryEntry, error) {
+	history := make(map[string][]HistoryEntry)
+	cutoff := time.Now().Add(-maxAge)
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var emptied [][]byte
+		err := tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+			var entries []HistoryEntry
+			var keys, stale [][]byte
+			err := bucket.ForEach(func(key, value []byte) error {
+				var entry HistoryEntry
+				if err := json.Unmarshal(value, &entry); err != nil || entry.Timestamp.Before(cutoff) {
+					stale = append(stale, append([]byte(nil), key...))
+					return nil
+				}
+				entries = append(entries, entry)
+				keys = append(keys, append([]byte(nil), key...))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			// Keys are in insertion order, so the oldest come first
+			if excess := len(entries) - maxSize; excess > 0 {
+				stale = append(stale, keys[:excess]...)
+				entries = entries[excess:]
+			}
+
+			for _, key := range stale {
+				if err := bucket.Delete(key); err != nil {
+					return err
+				}
+			}
+
+			if len(entries) == 0 {
+				emptied = append(emptied, append([]byte(nil), name...))
+				return nil
+			}
+			history[string(name)] = entries
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, name := range emptied {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return history, err
+}
+
+// append stores an entry and deletes the given number of oldest entries
+func (s *historyStore) append(sessionID string, entry HistoryEntry, trim int) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(sessionID))
+		if err != nil {
+			return err
+		}
+
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		if err := bucket.Put(key, value); err != nil {
+			return err
+		}
+
+		cursor := bucket.Cursor()
+		for ; trim > 0; trim-- {
+			if first, _ := cursor.First(); first == nil {
+				break
+			}
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// clear deletes a session's stored history
+func (s *historyStore) clear(sessionID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket([]byte(sessionID))
+		if err == bolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+// replace overwrites a session's stored history with the given entries
+func (s *historyStore) replace(sessionID string, entries []HistoryEntry) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket([]byte(sessionID)); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		bucket, err := tx.CreateBucket([]byte(sessionID))
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			value, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			seq, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, seq)
+			if err := bucket.Put(key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// update overwrites the stored copy of an entry, searching from the newest
+func (s *historyStore) update(sessionID string, entry HistoryEntry) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(sessionID))
+		if bucket == nil {
+			return nil
+		}
+
+		cursor := bucket.Cursor()
+		for key, stored := cursor.Last(); key != nil; key, stored = cursor.Prev() {
+			var existing HistoryEntry
+			if json.Unmarshal(stored, &existing) == nil && existing.ID == entry.ID {
+				return bucket.Put(key, value)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,294 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hardwareCommandTimeout bounds each call to nvidia-smi or lspci
+const hardwareCommandTimeout = 5 * time.Second
+
+// HardwareInfo is an inventory of the server's hardware
+type HardwareInfo struct {
+	CPU    CPUInfo       `json:"cpu"`
+	Memory MemoryInfo    `json:"memory"`
+	Disks  []BlockDevice `json:"disks"`
+	GPUs   []GPUInfo     `json:"gpus"`
+}
+
+// CPUInfo describes the processors
+type CPUInfo struct {
+	Model        string   `json:"model"`
+	Vendor       string   `json:"vendor,omitempty"`
+	Architecture string   `json:"architecture"`
+	Cores        int      `json:"cores"`   // Physical cores
+	Threads      int      `json:"threads"` // Logical processors
+	MHz          float64  `json:"mhz,omitempty"`
+	Flags        []string `json:"flags"` // e.g. avx2, or neon on ARM
+}
+
+// MemoryInfo describes system memory in bytes
+type MemoryInfo struct {
+	Total     int64 `json:"total"`
+	Available int64 `json:"available"`
+	SwapTotal int64 `json:"swapTotal"`
+}
+
+// BlockDevice is a disk attached to the server
+type BlockDevice struct {
+	Name       string `json:"name"`
+	Model      string `json:"model,omitempty"`
+	Size       int64  `json:"size"`       // In bytes
+	Rotational bool   `json:"rotational"` // A spinning disk rather than an SSD
+	Removable  bool   `json:"removable"`
+	ReadOnly   bool   `json:"readOnly"`
+}
+
+// GPUInfo describes a graphics or compute device
+type GPUInfo struct {
+	Vendor  string `json:"vendor"`
+	Model   string `json:"model"`
+	Memory  int64  `json:"memory,omitempty"` // In bytes, only known from nvidia-smi
+	Driver  string `json:"driver,omitempty"`
+	Address string `json:"address,omitempty"` // PCI bus address
+	Source  string `json:"source"`            // nvidia-smi or lspci
+}
+
+// GetHardwareInfo collects the hardware inventory from /proc and /sys,
+// and from nvidia-smi and lspci when they are installed. Sections that
+// cannot be read are left empty rather than failing the whole inventory.
+func GetHardwareInfo() *HardwareInfo {
+	return &HardwareInfo{
+		CPU:    readCPUInfo(),
+		Memory: readMemoryInfo(),
+		Disks:  readBlockDevices(),
+		GPUs:   detectGPUs(),
+	}
+}
+
+// readCPUInfo parses /proc/cpuinfo
+func readCPUInfo() CPUInfo {
+	cpu := CPUInfo{
+		Architecture: runtime.GOARCH,
+		Threads:      runtime.NumCPU(),
+		Flags:        []string{},
+	}
+
+	file, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		cpu.Cores = cpu.Threads
+		return cpu
+	}
+	defer file.Close()
+
+	threads := 0
+	physicalID := ""
+	cores := make(map[string]bool) // "physical id/core id" pairs
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "processor":
+			threads++
+		case "model name", "Model":
+			if cpu.Model == "" {
+				cpu.Model = value
+			}
+		case "vendor_id", "CPU implementer":
+			if cpu.Vendor == "" {
+				cpu.Vendor = value
+			}
+		case "cpu MHz":
+			if cpu.MHz == 0 {
+				cpu.MHz, _ = strconv.ParseFloat(value, 64)
+			}
+		case "flags", "Features":
+			if len(cpu.Flags) == 0 {
+				cpu.Flags = strings.Fields(value)
+			}
+		case "physical id":
+			physicalID = value
+		case "core id":
+			cores[physicalID+"/"+value] = true
+		}
+	}
+
+	if threads > 0 {
+		cpu.Threads = threads
+	}
+	cpu.Cores = len(cores)
+	if cpu.Cores == 0 {
+		// Not reported on ARM and in some virtual machines
+		cpu.Cores = cpu.Threads
+	}
+	return cpu
+}
+
+// readMemoryInfo parses /proc/meminfo
+func readMemoryInfo() MemoryInfo {
+	var memory MemoryInfo
+	data, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return memory
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		kb, _ := strconv.ParseInt(fields[1], 10, 64)
+		switch fields[0] {
+		case "MemTotal:":
+			memory.Total = kb * 1024
+		case "MemAvailable:":
+			memory.Available = kb * 1024
+		case "SwapTotal:":
+			memory.SwapTotal = kb * 1024
+		}
+	}
+	return memory
+}
+
+// readBlockDevices lists disks from /sys/block. Devices without a backing
+// device, such as loop, zram and device-mapper volumes, are skipped.
+func readBlockDevices() []BlockDevice {
+	disks := []BlockDevice{}
+	entries, err := os.ReadDir("/sys/block")
+	if err != nil {
+		return disks
+	}
+
+	for _, entry := range entries {
+		dir := filepath.Join("/sys/block", entry.Name())
+		if _, err := os.Stat(filepath.Join(dir, "device")); err != nil {
+			continue
+		}
+		// size is always counted in 512-byte sectors
+		sectors, _ := strconv.ParseInt(readSysFile(filepath.Join(dir, "size")), 10, 64)
+		disks = append(disks, BlockDevice{
+			Name:       entry.Name(),
+			Model:      readSysFile(filepath.Join(dir, "device", "model")),
+			Size:       sectors * 512,
+			Rotational: readSysFile(filepath.Join(dir, "queue", "rotational")) == "1",
+			Removable:  readSysFile(filepath.Join(dir, "removable")) == "1",
+			ReadOnly:   readSysFile(filepath.Join(dir, "ro")) == "1",
+		})
+	}
+	return disks
+}
+
+// readSysFile returns the trimmed contents of a sysfs attribute, or an
+// empty string when it does not exist
+func readSysFile(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// detectGPUs lists NVIDIA GPUs through nvidia-smi, which knows their memory
+// and driver, and any other display controllers through lspci
+func detectGPUs() []GPUInfo {
+	gpus := append([]GPUInfo{}, queryNvidiaSMI()...)
+	haveNvidia := len(gpus) > 0
+
+	for _, gpu := range queryLspci() {
+		if haveNvidia && strings.Contains(strings.ToLower(gpu.Vendor), "nvidia") {
+			continue
+		}
+		gpus = append(gpus, gpu)
+	}
+	return gpus
+}
+
+// queryNvidiaSMI reads NVIDIA GPUs from nvidia-smi
+func queryNvidiaSMI() []GPUInfo {
+	output, err := runHardwareCommand("nvidia-smi",
+		"--query-gpu=name,memory.total,driver_version,pci.bus_id",
+		"--format=csv,noheader,nounits")
+	if err != nil {
+		return nil
+	}
+
+	var gpus []GPUInfo
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(line, ",")
+		if len(fields) < 4 {
+			continue
+		}
+		// memory.total is in MiB with nounits
+		memory, _ := strconv.ParseInt(strings.TrimSpace(fields[1]), 10, 64)
+		gpus = append(gpus, GPUInfo{
+			Vendor:  "NVIDIA Corporation",
+			Model:   strings.TrimSpace(fields[0]),
+			Memory:  memory * 1024 * 1024,
+			Driver:  strings.TrimSpace(fields[2]),
+			Address: strings.TrimSpace(fields[3]),
+			Source:  "nvidia-smi",
+		})
+	}
+	return gpus
+}
+
+// queryLspci reads display controllers from the verbose machine-readable
+// output of lspci, a record of "Key:<tab>value" lines per device separated
+// by blank lines
+func queryLspci() []GPUInfo {
+	output, err := runHardwareCommand("lspci", "-vmm", "-k")
+	if err != nil {
+		return nil
+	}
+
+	var gpus []GPUInfo
+	for _, record := range strings.Split(output, "\n\n") {
+		fields := make(map[string]string)
+		for _, line := range strings.Split(record, "\n") {
+			if key, value, found := strings.Cut(line, ":"); found {
+				fields[key] = strings.TrimSpace(value)
+			}
+		}
+		class := strings.ToLower(fields["Class"])
+		if !strings.Contains(class, "vga") && !strings.Contains(class, "3d") && !strings.Contains(class, "display") {
+			continue
+		}
+		gpus = append(gpus, GPUInfo{
+			Vendor:  fields["Vendor"],
+			Model:   fields["Device"],
+			Driver:  fields["Driver"],
+			Address: fields["Slot"],
+			Source:  "lspci",
+		})
+	}
+	return gpus
+}
+
+// runHardwareCommand runs an inventory tool, failing when it is not
+// installed or does not finish in time
+func runHardwareCommand(name string, args ...string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), hardwareCommandTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, path, args...).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
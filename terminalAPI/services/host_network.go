@@ -0,0 +1,237 @@
+package services
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ListeningSocket is a TCP socket accepting connections or a bound UDP
+// socket on the host
+type ListeningSocket struct {
+	Protocol    string `json:"protocol"` // tcp, tcp6, udp or udp6
+	Address     string `json:"address"`  // 0.0.0.0 or :: when listening on every interface
+	Port        int    `json:"port"`
+	User        string `json:"user"`
+	PID         int    `json:"pid,omitempty"` // 0 when the owner is not visible to the server
+	Process     string `json:"process,omitempty"`
+	CommandLine string `json:"commandLine,omitempty"`
+}
+
+// PortQuery filters a listening socket listing
+type PortQuery struct {
+	Protocol string // tcp or udp, including IPv6 sockets
+	Port     int
+
+	redact func(string) string // Masks secret values in command lines
+}
+
+// Socket states in /proc/net; UDP sockets that are bound but not connected
+// report TCP_CLOSE
+const (
+	tcpListen = "0A"
+	udpClose  = "07"
+)
+
+// ListListeningPorts lists listening sockets with secret values masked in
+// the command lines of their owners
+func (sm *SessionManager) ListListeningPorts(query *PortQuery) ([]ListeningSocket, error) {
+	redacted := *query
+	redacted.redact = sm.redact
+	return ListListeningPorts(&redacted)
+}
+
+// ListListeningPorts enumerates listening sockets from /proc/net and finds
+// the processes owning them through /proc/<pid>/fd. Owners of sockets of
+// other users are only found when the server runs as root.
+func ListListeningPorts(query *PortQuery) ([]ListeningSocket, error) {
+	if _, err := os.Stat("/proc/net/tcp"); err != nil {
+		return nil, errors.New("listing ports requires /proc")
+	}
+	switch query.Protocol {
+	case "", "tcp", "udp":
+	default:
+		return nil, fmt.Errorf("unsupported protocol: %s", query.Protocol)
+	}
+
+	var sockets []ListeningSocket
+	inodes := make(map[string][]int) // Socket inode to indexes in sockets
+	users := make(map[string]string)
+
+	for _, protocol := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		if query.Protocol != "" && !strings.HasPrefix(protocol, query.Protocol) {
+			continue
+		}
+		state := tcpListen
+		if strings.HasPrefix(protocol, "udp") {
+			state = udpClose
+		}
+
+		entries, err := readProcNet(protocol, state)
+		if err != nil {
+			// IPv6 may be disabled
+			continue
+		}
+		for _, entry := range entries {
+			if query.Port != 0 && entry.port != query.Port {
+				continue
+			}
+			inodes[entry.inode] = append(inodes[entry.inode], len(sockets))
+			sockets = append(sockets, ListeningSocket{
+				Protocol: protocol,
+				Address:  entry.address,
+				Port:     entry.port,
+				User:     lookupUser(entry.uid, users),
+			})
+		}
+	}
+
+	if len(sockets) > 0 {
+		for inode, pid := range socketOwners(inodes) {
+			name := ""
+			if stat, err := parseProcStat(pid); err == nil {
+				name = stat.name
+			}
+			commandLine := readCommandLine(pid)
+			if query.redact != nil {
+				commandLine = query.redact(commandLine)
+			}
+			for _, i := range inodes[inode] {
+				sockets[i].PID = pid
+				sockets[i].Process = name
+				sockets[i].CommandLine = commandLine
+			}
+		}
+	}
+
+	sort.SliceStable(sockets, func(i, j int) bool {
+		if sockets[i].Port != sockets[j].Port {
+			return sockets[i].Port < sockets[j].Port
+		}
+		return sockets[i].Protocol < sockets[j].Protocol
+	})
+	if sockets == nil {
+		sockets = []ListeningSocket{}
+	}
+	return sockets, nil
+}
+
+type procNetEntry struct {
+	address string
+	port    int
+	uid     string
+	inode   string
+}
+
+// readProcNet parses /proc/net/<protocol>, keeping sockets in state
+func readProcNet(protocol string, state string) ([]procNetEntry, error) {
+	file, err := os.Open(filepath.Join("/proc/net", protocol))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []procNetEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // Header
+	for scanner.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[3] != state {
+			continue
+		}
+		host, port, found := strings.Cut(fields[1], ":")
+		if !found {
+			continue
+		}
+		ip, err := parseProcNetIP(host)
+		if err != nil {
+			continue
+		}
+		portNumber, err := strconv.ParseUint(port, 16, 16)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, procNetEntry{
+			address: ip.String(),
+			port:    int(portNumber),
+			uid:     fields[7],
+			inode:   fields[9],
+		})
+	}
+	return entries, scanner.Err()
+}
+
+// parseProcNetIP decodes an address from /proc/net, written as hex 32-bit
+// words in host byte order, which is little-endian on supported platforms
+func parseProcNetIP(text string) (net.IP, error) {
+	raw, err := hex.DecodeString(text)
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return nil, fmt.Errorf("invalid address: %s", text)
+	}
+	ip := make(net.IP, len(raw))
+	for word := 0; word < len(raw); word += 4 {
+		for i := 0; i < 4; i++ {
+			ip[word+i] = raw[word+3-i]
+		}
+	}
+	return ip, nil
+}
+
+// socketOwners maps socket inodes to the pid of a process holding them
+func socketOwners(inodes map[string][]int) map[string]int {
+	owners := make(map[string]int)
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return owners
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join("/proc", entry.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			// Processes of other users are not readable without root
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			inode := strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")
+			if _, wanted := inodes[inode]; wanted {
+				if _, found := owners[inode]; !found {
+					owners[inode] = pid
+				}
+			}
+		}
+		if len(owners) == len(inodes) {
+			break
+		}
+	}
+	return owners
+}
+
+// lookupUser resolves a uid to a username, caching lookups in users
+func lookupUser(uid string, users map[string]string) string {
+	if name, cached := users[uid]; cached {
+		return name
+	}
+	name := uid
+	if u, err := user.LookupId(uid); err == nil {
+		name = u.Username
+	}
+	users[uid] = name
+	return name
+}
@@ -0,0 +1,313 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultHostProcessLimit is how many host processes are listed unless the
+// query sets a limit
+const DefaultHostProcessLimit = 100
+
+// DefaultCPUSampleInterval is how long CPU usage is measured over
+const DefaultCPUSampleInterval = 250 * time.Millisecond
+
+// maxCPUSampleInterval keeps a listing request from blocking for long
+const maxCPUSampleInterval = 5 * time.Second
+
+// HostProcess is a process running on the server, managed or not
+type HostProcess struct {
+	PID           int       `json:"pid"`
+	PPID          int       `json:"ppid"`
+	User          string    `json:"user"`
+	State         string    `json:"state"` // R running, S sleeping, D disk wait, Z zombie, T stopped
+	Name          string    `json:"name"`
+	CommandLine   string    `json:"commandLine"`
+	CPUPercent    float64   `json:"cpuPercent"` // Over the sample interval; may exceed 100 with several threads
+	CPUSeconds    float64   `json:"cpuSeconds"` // Total since the process started
+	MemoryRSS     int64     `json:"memoryRSS"`  // Resident set size in bytes
+	MemoryPercent float64   `json:"memoryPercent"`
+	StartTime     time.Time `json:"startTime"`
+}
+
+// HostProcessQuery filters and orders a host process listing
+type HostProcessQuery struct {
+	User        string        // Only processes of this user
+	Name        string        // Case-insensitive substring of the name or command line
+	MinCPU      float64       // Minimum cpuPercent
+	MinMemoryMB float64       // Minimum resident memory
+	Sort        string        // cpu (default), memory, pid or start
+	Limit       int           // Default DefaultHostProcessLimit
+	Interval    time.Duration // CPU sample interval, default DefaultCPUSampleInterval
+
+	redact func(string) string // Masks secret values in command lines
+}
+
+// HostProcessList is one page of host processes
+type HostProcessList struct {
+	Processes []HostProcess `json:"processes"`
+	Count     int           `json:"count"`
+	Total     int           `json:"total"` // Processes matching the filters before the limit
+	SampledMs int64         `json:"sampledMs"`
+}
+
+// procStat holds the fields of /proc/<pid>/stat used here
+type procStat struct {
+	name       string
+	state      string
+	ppid       int
+	cpuTicks   float64
+	startTicks float64
+	rssPages   int64
+}
+
+// parseProcStat reads /proc/<pid>/stat
+func parseProcStat(pid int) (*procStat, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	// The command name may contain spaces, so parse after its closing paren
+	content := string(data)
+	start := strings.Index(content, "(")
+	end := strings.LastIndex(content, ")")
+	if start < 0 || end < start {
+		return nil, errors.New("malformed /proc stat")
+	}
+	fields := strings.Fields(content[end+1:])
+	if len(fields) < 22 {
+		return nil, errors.New("malformed /proc stat")
+	}
+
+	utime, _ := strconv.ParseFloat(fields[11], 64)
+	stime, _ := strconv.ParseFloat(fields[12], 64)
+	ppid, _ := strconv.Atoi(fields[1])
+	startTicks, _ := strconv.ParseFloat(fields[19], 64)
+	rssPages, _ := strconv.ParseInt(fields[21], 10, 64)
+
+	return &procStat{
+		name:       content[start+1 : end],
+		state:      fields[0],
+		ppid:       ppid,
+		cpuTicks:   utime + stime,
+		startTicks: startTicks,
+		rssPages:   rssPages,
+	}, nil
+}
+
+// ListHostProcesses lists the server's processes with secret values masked
+// in their command lines, before the name filter sees them so it cannot be
+// used to guess a value either
+func (sm *SessionManager) ListHostProcesses(query *HostProcessQuery) (*HostProcessList, error) {
+	redacted := *query
+	redacted.redact = sm.redact
+	return ListHostProcesses(&redacted)
+}
+
+// ListHostProcesses lists the server's processes from /proc. CPU usage is
+// measured by sampling every process twice, query.Interval apart.
+func ListHostProcesses(query *HostProcessQuery) (*HostProcessList, error) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		return nil, errors.New("listing host processes requires /proc")
+	}
+	if query.Interval < 0 || query.Interval > maxCPUSampleInterval {
+		return nil, fmt.Errorf("interval must be between 0 and %s", maxCPUSampleInterval)
+	}
+	if query.Limit < 0 {
+		return nil, errors.New("limit must not be negative")
+	}
+	switch query.Sort {
+	case "", "cpu", "memory", "pid", "start":
+	default:
+		return nil, fmt.Errorf("unsupported sort: %s", query.Sort)
+	}
+
+	interval := query.Interval
+	if interval == 0 {
+		interval = DefaultCPUSampleInterval
+	}
+
+	first := sampleProcStats()
+	sampleStart := time.Now()
+	time.Sleep(interval)
+	second := sampleProcStats()
+	elapsed := time.Since(sampleStart).Seconds()
+
+	bootTime := readBootTime()
+	memTotal := readMemTotal()
+	pageSize := int64(os.Getpagesize())
+	users := make(map[string]string)
+	name := strings.ToLower(query.Name)
+
+	processes := make([]HostProcess, 0, len(second))
+	for pid, stat := range second {
+		process := HostProcess{
+			PID:        pid,
+			PPID:       stat.ppid,
+			State:      stat.state,
+			Name:       stat.name,
+			CPUSeconds: stat.cpuTicks / clockTicksPerSecond,
+			MemoryRSS:  stat.rssPages * pageSize,
+		}
+		if previous, exists := first[pid]; exists && elapsed > 0 {
+			process.CPUPercent = (stat.cpuTicks - previous.cpuTicks) / clockTicksPerSecond / elapsed * 100
+		}
+		if memTotal > 0 {
+			process.MemoryPercent = float64(process.MemoryRSS) / float64(memTotal) * 100
+		}
+		if !bootTime.IsZero() {
+			process.StartTime = bootTime.Add(time.Duration(stat.startTicks / clockTicksPerSecond * float64(time.Second)))
+		}
+		process.User = processUser(pid, users)
+		process.CommandLine = readCommandLine(pid)
+		if query.redact != nil {
+			process.CommandLine = query.redact(process.CommandLine)
+		}
+
+		if query.User != "" && process.User != query.User {
+			continue
+		}
+		if name != "" && !strings.Contains(strings.ToLower(process.Name), name) &&
+			!strings.Contains(strings.ToLower(process.CommandLine), name) {
+			continue
+		}
+		if process.CPUPercent < query.MinCPU {
+			continue
+		}
+		if float64(process.MemoryRSS) < query.MinMemoryMB*1024*1024 {
+			continue
+		}
+		processes = append(processes, process)
+	}
+
+	sort.Slice(processes, func(i, j int) bool {
+		a, b := processes[i], processes[j]
+		switch query.Sort {
+		case "memory":
+			if a.MemoryRSS != b.MemoryRSS {
+				return a.MemoryRSS > b.MemoryRSS
+			}
+		case "pid":
+			return a.PID < b.PID
+		case "start":
+			if !a.StartTime.Equal(b.StartTime) {
+				return a.StartTime.After(b.StartTime)
+			}
+		default:
+			if a.CPUPercent != b.CPUPercent {
+				return a.CPUPercent > b.CPUPercent
+			}
+			if a.CPUSeconds != b.CPUSeconds {
+				return a.CPUSeconds > b.CPUSeconds
+			}
+		}
+		return a.PID < b.PID
+	})
+
+	list := &HostProcessList{
+		Total:     len(processes),
+		SampledMs: interval.Milliseconds(),
+	}
+	limit := query.Limit
+	if limit == 0 {
+		limit = DefaultHostProcessLimit
+	}
+	if len(processes) > limit {
+		processes = processes[:limit]
+	}
+	list.Processes = processes
+	list.Count = len(processes)
+	return list, nil
+}
+
+// sampleProcStats reads the stat of every process; processes that exit
+// while being read are skipped
+func sampleProcStats() map[int]*procStat {
+	stats := make(map[int]*procStat)
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return stats
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		if stat, err := parseProcStat(pid); err == nil {
+			stats[pid] = stat
+		}
+	}
+	return stats
+}
+
+// readCommandLine returns a process's arguments joined by spaces, or an
+// empty string for kernel threads
+func readCommandLine(pid int) string {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.ReplaceAll(string(data), "\x00", " "))
+}
+
+// processUser resolves the real uid of a process to a username, caching
+// lookups in users
+func processUser(pid int, users map[string]string) string {
+	file, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "Uid:" {
+			continue
+		}
+		return lookupUser(fields[1], users)
+	}
+	return ""
+}
+
+// readBootTime returns when the system booted, from /proc/stat
+func readBootTime() time.Time {
+	file, err := os.Open("/proc/stat")
+	if err != nil {
+		return time.Time{}
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if value, found := strings.CutPrefix(scanner.Text(), "btime "); found {
+			if seconds, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64); err == nil {
+				return time.Unix(seconds, 0)
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// readMemTotal returns the system memory in bytes, from /proc/meminfo
+func readMemTotal() int64 {
+	data, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, _ := strconv.ParseInt(fields[1], 10, 64)
+			return kb * 1024
+		}
+	}
+	return 0
+}
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListHostProcessesMasksSecrets(t *testing.T) {
+	sm := newRedactingSessionManager("hunter22-in-argv")
+	cmd := exec.Command("/bin/sh", "-c", "sleep 5 # osai-marker hunter22-in-argv")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	list, err := sm.ListHostProcesses(&HostProcessQuery{Name: "osai-marker", Interval: time.Millisecond})
+	if err != nil {
+		t.Skip(err)
+	}
+	var listed *HostProcess
+	for i := range list.Processes {
+		if list.Processes[i].PID == cmd.Process.Pid {
+			listed = &list.Processes[i]
+		}
+	}
+	if listed == nil {
+		t.Fatal("the process with the marker was not listed")
+	}
+	if strings.Contains(listed.CommandLine, "hunter22") || !strings.Contains(listed.CommandLine, secretMask) {
+		t.Errorf("command line not masked: %q", listed.CommandLine)
+	}
+
+	// Filtering by part of a secret must not reveal that it is running
+	list, err = sm.ListHostProcesses(&HostProcessQuery{Name: "hunter22", Interval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, process := range list.Processes {
+		if process.PID == cmd.Process.Pid {
+			t.Error("name filter matched a masked secret")
+		}
+	}
+}
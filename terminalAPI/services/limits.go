@@ -0,0 +1,119 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ResourceLimits caps the resources a single command may consume. Memory,
+// CPU time and open files are enforced with setrlimit through the shell's
+// ulimit builtin; output is capped by the server while capturing.
+type ResourceLimits struct {
+	MaxMemoryMB    int   `json:"maxMemoryMB,omitempty"`
+	MaxCPUSeconds  int   `json:"maxCPUSeconds,omitempty"`
+	MaxOpenFiles   int   `json:"maxOpenFiles,omitempty"`
+	MaxOutputBytes int64 `json:"maxOutputBytes,omitempty"`
+}
+
+// Validate rejects negative limits
+func (l *ResourceLimits) Validate() error {
+	if l == nil {
+		return nil
+	}
+	if l.MaxMemoryMB < 0 || l.MaxCPUSeconds < 0 || l.MaxOpenFiles < 0 || l.MaxOutputBytes < 0 {
+		return errors.New("resource limits must not be negative")
+	}
+	return nil
+}
+
+// WrapCommand prefixes a command with the ulimit calls for its limits. If a
+// limit cannot be applied the command is not run and exits with 126.
+func (l *ResourceLimits) WrapCommand(command string) string {
+	if l == nil {
+		return command
+	}
+
+	var ulimits []string
+	if l.MaxMemoryMB > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -v %d", l.MaxMemoryMB*1024))
+	}
+	if l.MaxCPUSeconds > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -t %d", l.MaxCPUSeconds))
+	}
+	if l.MaxOpenFiles > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -n %d", l.MaxOpenFiles))
+	}
+	if len(ulimits) == 0 {
+		return command
+	}
+
+	return strings.Join(ulimits, " && ") + " || exit 126\n" + command
+}
+
+// outputLimit returns the output byte cap, or 0 for no limit
+func (l *ResourceLimits) outputLimit() int64 {
+	if l == nil {
+		return 0
+	}
+	return l.MaxOutputBytes
+}
+
+// limitedBuffer captures output up to a byte limit and silently discards
+// the rest so a noisy command cannot exhaust server memory. Buffers created
+// together by newOutputBuffers share one limit across stdout and stderr.
+type limitedBuffer struct {
+	buffer    bytes.Buffer
+	limit     int64
+	truncated bool
+	shared    *outputBudget
+}
+
+// outputBudget counts the bytes captured by a pair of limitedBuffers, which
+// are written from separate goroutines
+type outputBudget struct {
+	mutex sync.Mutex
+	used  int64
+}
+
+// newOutputBuffers returns stdout and stderr buffers that together capture
+// at most limit bytes, the same accounting processes use for maxOutputBytes
+func newOutputBuffers(limit int64) (*limitedBuffer, *limitedBuffer) {
+	budget := &outputBudget{}
+	return &limitedBuffer{limit: limit, shared: budget}, &limitedBuffer{limit: limit, shared: budget}
+}
+
+func (lb *limitedBuffer) Write(p []byte) (int, error) {
+	if lb.limit <= 0 {
+		return lb.buffer.Write(p)
+	}
+
+	used := int64(lb.buffer.Len())
+	if lb.shared != nil {
+		lb.shared.mutex.Lock()
+		defer lb.shared.mutex.Unlock()
+		used = lb.shared.used
+	}
+
+	n := len(p)
+	remaining := lb.limit - used
+	if remaining <= 0 {
+		lb.truncated = true
+		return n, nil
+	}
+	if int64(n) > remaining {
+		p = p[:remaining]
+		lb.truncated = true
+	}
+	lb.buffer.Write(p)
+	if lb.shared != nil {
+		lb.shared.used += int64(len(p))
+	}
+	return n, nil
+}
+
+func (lb *limitedBuffer) String() string {
+	return lb.buffer.String()
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Log attribute keys shared by every log line
+const (
+	LogKeySession   = "session"
+	LogKeyRequestID = "request_id"
+	LogKeyOperation = "op"
+)
+
+// ConfigureLogging installs the default structured logger, configured
+// through environment variables shared with fileAPI:
+//
+//   - OSAI_LOG_LEVEL: debug, info (the default), warn or error
+//   - OSAI_LOG_FORMAT: json (the default) or text
+//   - OSAI_LOG_OUTPUT: stdout (the default), stderr or a file to append to
+//
+// Lines written through the standard log package go to the same logger.
+func ConfigureLogging() error {
+	var level slog.Level
+	if value := os.Getenv("OSAI_LOG_LEVEL"); value != "" {
+		if err := level.UnmarshalText([]byte(value)); err != nil {
+			return fmt.Errorf("invalid OSAI_LOG_LEVEL: %s", value)
+		}
+	}
+
+	var output io.Writer = os.Stdout
+	switch value := os.Getenv("OSAI_LOG_OUTPUT"); value {
+	case "", "stdout":
+	case "stderr":
+		output = os.Stderr
+	default:
+		file, err := os.OpenFile(value, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open log file %s: %v", value, err)
+		}
+		output = file
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch format := strings.ToLower(os.Getenv("OSAI_LOG_FORMAT")); format {
+	case "", "json":
+		handler = slog.NewJSONHandler(output, options)
+	case "text":
+		handler = slog.NewTextHandler(output, options)
+	default:
+		return fmt.Errorf("invalid OSAI_LOG_FORMAT: %s", format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// sessionLog returns the logger for messages about a session
+func sessionLog(sessionID string) *slog.Logger {
+	return slog.With(LogKeySession, sessionID)
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"errors"
+	"regexp"
+)
+
+// OutputFilter narrows the lines GetOutputSince returns so large outputs
+// are reduced on the server instead of shipped whole to the client
+type OutputFilter struct {
+	Grep     *regexp.Regexp // Keep only lines matching this pattern
+	Head     int            // Keep the first N matching lines
+	Tail     int            // Keep the last N matching lines
+	MaxBytes int            // Cap the size of the returned lines of each stream
+}
+
+// Validate rejects filters that cannot be applied
+func (f *OutputFilter) Validate() error {
+	if f.Head < 0 || f.Tail < 0 || f.MaxBytes < 0 {
+		return errors.New("head, tail and maxBytes must not be negative")
+	}
+	if f.Head > 0 && f.Tail > 0 {
+		return errors.New("head and tail cannot be combined")
+	}
+	return nil
+}
+
+// apply filters lines whose first element has the absolute index first. It
+// returns the kept lines, the cursor to resume from and how many lines before
+// that cursor were left out. With head set the cursor stops after the last
+// line returned, so the next poll continues where this page ended; otherwise
+// it covers every line given.
+func (f *OutputFilter) apply(lines []string, first int) ([]string, int, int) {
+	if f == nil {
+		return lines, first + len(lines), 0
+	}
+
+	kept := []string{}
+	examined := len(lines)
+	for i, line := range lines {
+		if f.Grep != nil && !f.Grep.MatchString(line) {
+			continue
+		}
+		if f.Head > 0 && len(kept) == f.Head {
+			examined = i
+			break
+		}
+		kept = append(kept, line)
+	}
+
+	if f.Tail > 0 && len(kept) > f.Tail {
+		kept = kept[len(kept)-f.Tail:]
+	}
+
+	if f.MaxBytes > 0 {
+		size := 0
+		if f.Head > 0 {
+			// Paging forward: drop lines from the end and resume at them
+			for i, line := range kept {
+				size += len(line) + 1
+				if size > f.MaxBytes {
+					examined = nthMatchIndex(lines, f.Grep, i)
+					kept = kept[:i]
+					break
+				}
+			}
+		} else {
+			// Otherwise the newest lines are the most useful
+			for i := len(kept) - 1; i >= 0; i-- {
+				size += len(kept[i]) + 1
+				if size > f.MaxBytes {
+					kept = kept[i+1:]
+					break
+				}
+			}
+		}
+	}
+
+	return kept, first + examined, examined - len(kept)
+}
+
+// nthMatchIndex returns the position in lines of the n-th (zero based)
+// line matching pattern, i.e. the number of lines before it
+func nthMatchIndex(lines []string, pattern *regexp.Regexp, n int) int {
+	for i, line := range lines {
+		if pattern != nil && !pattern.MatchString(line) {
+			continue
+		}
+		if n == 0 {
+			return i
+		}
+		n--
+	}
+	return len(lines)
+}
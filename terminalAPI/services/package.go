@@ -0,0 +1,437 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Package managers PackageService can drive, in detection order
+const (
+	PackageManagerApt    = "apt"
+	PackageManagerDnf    = "dnf"
+	PackageManagerPacman = "pacman"
+	PackageManagerBrew   = "brew"
+)
+
+// DefaultPackageLimit caps searches and listings unless the request sets
+// a limit
+const DefaultPackageLimit = 100
+
+// Timeouts for package manager queries and installs
+const (
+	packageQueryTimeout   = time.Minute
+	packageInstallTimeout = 10 * time.Minute
+)
+
+// ErrPackageInstallDisabled is returned for installs unless the server
+// allows them
+var ErrPackageInstallDisabled = errors.New("package installation is disabled, set TERMINAL_ALLOW_PACKAGE_INSTALL=true to enable it")
+
+// ErrNoPackageManager is returned when no supported package manager exists
+var ErrNoPackageManager = errors.New("no supported package manager found (apt, dnf, pacman or brew)")
+
+// Package names as the supported managers spell them, including brew taps
+// (owner/tap/name) and apt architectures (name:arch). Leading dashes are
+// excluded so names cannot be read as options.
+var packageNamePattern = regexp.MustCompile(`^[A-Za-z0-9@][A-Za-z0-9+._@/:-]*$`)
+
+// Package is a package known to the host's package manager
+type Package struct {
+	Name         string `json:"name"`
+	Version      string `json:"version,omitempty"`
+	Architecture string `json:"architecture,omitempty"`
+	Description  string `json:"description,omitempty"`
+	Installed    bool   `json:"installed"`
+}
+
+// PackageList is the result of a package search or listing
+type PackageList struct {
+	Manager  string    `json:"manager"`
+	Packages []Package `json:"packages"`
+	Count    int       `json:"count"`
+	Total    int       `json:"total"` // Matches before the limit
+}
+
+// PackageInstallRequest installs packages with the host's package manager
+type PackageInstallRequest struct {
+	Packages []string `json:"packages"`
+}
+
+// PackageInstallResult reports an install and the versions now installed
+type PackageInstallResult struct {
+	Manager   string    `json:"manager"`
+	ExitCode  int       `json:"exitCode"`
+	Output    string    `json:"output"`
+	Installed []Package `json:"installed"`
+}
+
+// PackageService runs searches, listings and installs through the host's
+// package manager and parses their output
+type PackageService struct {
+	manager      string
+	allowInstall bool
+	audit        *AuditService
+}
+
+// NewPackageService detects the host's package manager. Installs are only
+// allowed when TERMINAL_ALLOW_PACKAGE_INSTALL is true.
+func NewPackageService(audit *AuditService) *PackageService {
+	ps := &PackageService{audit: audit}
+	for _, candidate := range []struct{ manager, binary string }{
+		{PackageManagerApt, "apt-get"},
+		{PackageManagerDnf, "dnf"},
+		{PackageManagerPacman, "pacman"},
+		{PackageManagerBrew, "brew"},
+	} {
+		if _, err := exec.LookPath(candidate.binary); err == nil {
+			ps.manager = candidate.manager
+			break
+		}
+	}
+	ps.allowInstall, _ = strconv.ParseBool(os.Getenv("TERMINAL_ALLOW_PACKAGE_INSTALL"))
+	return ps
+}
+
+// Manager returns the detected package manager, or an empty string
+func (ps *PackageService) Manager() string {
+	return ps.manager
+}
+
+// Search looks up packages by name or description
+func (ps *PackageService) Search(query string, limit int) (*PackageList, error) {
+	if ps.manager == "" {
+		return nil, ErrNoPackageManager
+	}
+	if !packageNamePattern.MatchString(query) {
+		return nil, fmt.Errorf("invalid search query: %s", query)
+	}
+	if limit < 0 {
+		return nil, errors.New("limit must not be negative")
+	}
+
+	installed, err := ps.installedPackages()
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[string]string, len(installed))
+	for _, pkg := range installed {
+		versions[pkg.Name] = pkg.Version
+	}
+
+	var args []string
+	switch ps.manager {
+	case PackageManagerApt:
+		args = []string{"apt-cache", "search", query}
+	case PackageManagerDnf:
+		args = []string{"dnf", "-q", "search", query}
+	case PackageManagerPacman:
+		args = []string{"pacman", "-Ss", query}
+	case PackageManagerBrew:
+		args = []string{"brew", "search", query}
+	}
+	var stdout, stderr bytes.Buffer
+	err = runPackageCommand(packageQueryTimeout, &stdout, &stderr, args...)
+	if err != nil {
+		// Searches without results exit non-zero with some managers
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || strings.TrimSpace(stderr.String()) != "" {
+			return nil, fmt.Errorf("%s search failed: %v %s", ps.manager, err, strings.TrimSpace(stderr.String()))
+		}
+	}
+	output := stdout.String()
+
+	packages := parseSearchOutput(ps.manager, output)
+	for i := range packages {
+		if version, exists := versions[packages[i].Name]; exists {
+			packages[i].Installed = true
+			if packages[i].Version == "" {
+				packages[i].Version = version
+			}
+		}
+	}
+
+	// Exact and prefix matches first
+	sort.SliceStable(packages, func(i, j int) bool {
+		return searchRank(packages[i].Name, query) < searchRank(packages[j].Name, query)
+	})
+	return newPackageList(ps.manager, packages, limit), nil
+}
+
+// ListInstalled lists installed packages, optionally only those whose name
+// contains filter
+func (ps *PackageService) ListInstalled(filter string, limit int) (*PackageList, error) {
+	if ps.manager == "" {
+		return nil, ErrNoPackageManager
+	}
+	if limit < 0 {
+		return nil, errors.New("limit must not be negative")
+	}
+
+	installed, err := ps.installedPackages()
+	if err != nil {
+		return nil, err
+	}
+
+	packages := installed[:0]
+	for _, pkg := range installed {
+		if strings.Contains(pkg.Name, filter) {
+			packages = append(packages, pkg)
+		}
+	}
+	return newPackageList(ps.manager, packages, limit), nil
+}
+
+// Install installs packages non-interactively. The output of the package
+// manager is returned whether or not it succeeded.
+func (ps *PackageService) Install(request *PackageInstallRequest) (*PackageInstallResult, error) {
+	result, err := ps.install(request)
+
+	detail := ps.manager
+	if result != nil {
+		detail += fmt.Sprintf(", exit code %d", result.ExitCode)
+	}
+	ps.audit.Record(AuditEntry{Operation: "package.install", Target: strings.Join(request.Packages, " "), Detail: detail}, err)
+
+	return result, err
+}
+
+func (ps *PackageService) install(request *PackageInstallRequest) (*PackageInstallResult, error) {
+	if !ps.allowInstall {
+		return nil, ErrPackageInstallDisabled
+	}
+	if ps.manager == "" {
+		return nil, ErrNoPackageManager
+	}
+	if len(request.Packages) == 0 {
+		return nil, errors.New("no packages given")
+	}
+	for _, name := range request.Packages {
+		if !packageNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid package name: %s", name)
+		}
+	}
+
+	var args []string
+	switch ps.manager {
+	case PackageManagerApt:
+		args = []string{"apt-get", "install", "-y", "--no-install-recommends"}
+	case PackageManagerDnf:
+		args = []string{"dnf", "install", "-y"}
+	case PackageManagerPacman:
+		args = []string{"pacman", "-S", "--noconfirm", "--needed"}
+	case PackageManagerBrew:
+		args = []string{"brew", "install"}
+	}
+	args = append(args, request.Packages...)
+
+	slog.Info("installing packages", LogKeyOperation, "package.install", "manager", ps.manager, "packages", request.Packages)
+	var output bytes.Buffer
+	err := runPackageCommand(packageInstallTimeout, &output, &output, args...)
+	result := &PackageInstallResult{
+		Manager:   ps.manager,
+		Output:    output.String(),
+		Installed: []Package{},
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return nil, err
+		}
+		result.ExitCode = exitErr.ExitCode()
+	}
+
+	if installed, err := ps.installedPackages(); err == nil {
+		wanted := make(map[string]bool, len(request.Packages))
+		for _, name := range request.Packages {
+			// Taps and architectures are not part of the installed name
+			name = name[strings.LastIndex(name, "/")+1:]
+			name, _, _ = strings.Cut(name, ":")
+			wanted[name] = true
+		}
+		for _, pkg := range installed {
+			if wanted[pkg.Name] {
+				result.Installed = append(result.Installed, pkg)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// installedPackages lists every installed package with its version
+func (ps *PackageService) installedPackages() ([]Package, error) {
+	var args []string
+	switch ps.manager {
+	case PackageManagerApt:
+		args = []string{"dpkg-query", "-W", "-f", "${Package}\t${Version}\t${Architecture}\t${db:Status-Abbrev}\n"}
+	case PackageManagerDnf:
+		args = []string{"rpm", "-qa", "--qf", "%{NAME}\t%{VERSION}-%{RELEASE}\t%{ARCH}\n"}
+	case PackageManagerPacman:
+		args = []string{"pacman", "-Q"}
+	case PackageManagerBrew:
+		args = []string{"brew", "list", "--versions"}
+	}
+	output, err := queryPackages(args...)
+	if err != nil {
+		return nil, fmt.Errorf("listing installed packages failed: %v", err)
+	}
+
+	var packages []Package
+	for _, line := range strings.Split(output, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		pkg := Package{Installed: true}
+		switch ps.manager {
+		case PackageManagerApt, PackageManagerDnf:
+			fields := strings.Split(line, "\t")
+			if len(fields) < 3 {
+				continue
+			}
+			// dpkg also lists removed packages whose configuration remains
+			if ps.manager == PackageManagerApt && (len(fields) < 4 || !strings.HasPrefix(fields[3], "ii")) {
+				continue
+			}
+			pkg.Name, pkg.Version, pkg.Architecture = fields[0], fields[1], fields[2]
+		default:
+			// "name version", brew lists every installed version
+			fields := strings.Fields(line)
+			pkg.Name = fields[0]
+			if len(fields) > 1 {
+				pkg.Version = fields[len(fields)-1]
+			}
+		}
+		packages = append(packages, pkg)
+	}
+
+	sort.Slice(packages, func(i, j int) bool {
+		return packages[i].Name < packages[j].Name
+	})
+	return packages, nil
+}
+
+// parseSearchOutput extracts packages from the output of a search
+func parseSearchOutput(manager string, output string) []Package {
+	var packages []Package
+	lines := strings.Split(output, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		switch manager {
+		case PackageManagerApt:
+			// "name - description"
+			name, description, _ := strings.Cut(line, " - ")
+			packages = append(packages, Package{Name: strings.TrimSpace(name), Description: description})
+		case PackageManagerDnf:
+			// "name.arch : summary", under "=== Name Matched: ... ===" headers
+			if strings.HasPrefix(line, "=") || strings.HasPrefix(line, "Last metadata") {
+				continue
+			}
+			nameArch, description, found := strings.Cut(line, " : ")
+			if !found {
+				continue
+			}
+			pkg := Package{Name: strings.TrimSpace(nameArch), Description: strings.TrimSpace(description)}
+			if dot := strings.LastIndex(pkg.Name, "."); dot > 0 {
+				pkg.Name, pkg.Architecture = pkg.Name[:dot], pkg.Name[dot+1:]
+			}
+			packages = append(packages, pkg)
+		case PackageManagerPacman:
+			// "repo/name version [installed]" followed by an indented description
+			if strings.HasPrefix(line, " ") {
+				continue
+			}
+			fields := strings.Fields(line)
+			pkg := Package{Name: fields[0][strings.Index(fields[0], "/")+1:]}
+			if len(fields) > 1 {
+				pkg.Version = fields[1]
+			}
+			if i+1 < len(lines) && strings.HasPrefix(lines[i+1], " ") {
+				pkg.Description = strings.TrimSpace(lines[i+1])
+			}
+			packages = append(packages, pkg)
+		case PackageManagerBrew:
+			// Names only, under "==> Formulae" and "==> Casks" headers
+			if strings.HasPrefix(line, "==>") {
+				continue
+			}
+			for _, name := range strings.Fields(line) {
+				packages = append(packages, Package{Name: name})
+			}
+		}
+	}
+	return packages
+}
+
+// searchRank orders exact name matches first, then prefix matches
+func searchRank(name string, query string) int {
+	switch {
+	case name == query:
+		return 0
+	case strings.HasPrefix(name, query):
+		return 1
+	default:
+		return 2
+	}
+}
+
+func newPackageList(manager string, packages []Package, limit int) *PackageList {
+	if limit == 0 {
+		limit = DefaultPackageLimit
+	}
+	list := &PackageList{
+		Manager: manager,
+		Total:   len(packages),
+	}
+	if len(packages) > limit {
+		packages = packages[:limit]
+	}
+	if packages == nil {
+		packages = []Package{}
+	}
+	list.Packages = packages
+	list.Count = len(packages)
+	return list
+}
+
+// queryPackages runs a package manager query and returns its output;
+// error messages it prints are included in the returned error
+func queryPackages(args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	err := runPackageCommand(packageQueryTimeout, &stdout, &stderr, args...)
+	if err != nil && strings.TrimSpace(stderr.String()) != "" {
+		err = fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), err
+}
+
+// runPackageCommand runs a package manager without a terminal
+func runPackageCommand(timeout time.Duration, stdout io.Writer, stderr io.Writer, args ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	startInProcessGroup(cmd)
+	cmd.Env = append(commandEnviron(), "DEBIAN_FRONTEND=noninteractive", "HOMEBREW_NO_AUTO_UPDATE=1")
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	return err
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ExecutionPlan is the fully resolved form of a command request: what would
+// be executed, where, and with which environment
+type ExecutionPlan struct {
+	Shell       string            `json:"shell"`
+	Args        []string          `json:"args"`
+	CommandLine string            `json:"commandLine"` // The command after limit wrapping
+	WorkingDir  string            `json:"workingDir"`
+	// Session and request variables layered over the server environment
+	Environment map[string]string `json:"environment"`
+	Persistent  bool              `json:"persistent,omitempty"`
+	Timeout     int               `json:"timeout,omitempty"`
+	Limits      *ResourceLimits   `json:"limits,omitempty"`
+	Container   string            `json:"container,omitempty"` // Sandbox container the command runs in
+	Remote      string            `json:"remote,omitempty"`    // Machine the command runs on, as user@host:port
+	RunAs       string            `json:"runAs,omitempty"`
+	Warnings    []string          `json:"warnings,omitempty"`
+
+	hostDir string // Host directory the command process starts in
+	stdin   []byte // Written to the command's stdin before anything else
+}
+
+// buildExecutionPlan resolves the shell, environment and command line for a
+// request. When verifyShell is set a missing session shell falls back to
+// /bin/bash with a warning instead of failing at execution time. Sandboxed
+// sessions resolve to a docker exec into the session container, and remote
+// sessions to an ssh to their machine.
+func buildExecutionPlan(session *Session, request *CommandRequest, verifyShell bool, runner *ContainerRunner, remote *RemoteRunner) *ExecutionPlan {
+	plan := &ExecutionPlan{
+		Shell:       "/bin/bash", // Default shell
+		WorkingDir:  filepath.Join(session.WorkingDir, request.Cwd),
+		Environment: make(map[string]string),
+		Persistent:  request.Persistent,
+		Timeout:     request.Timeout,
+		Limits:      request.Limits,
+		RunAs:       session.RunAs,
+		hostDir:     session.WorkingDir,
+	}
+	
+	if request.RunAs != "" {
+		plan.RunAs = request.RunAs
+	}
+
+	if shell, exists := session.EnvVars["SHELL"]; exists && shell != "" {
+		if _, err := os.Stat(shell); err == nil || !verifyShell {
+			plan.Shell = shell
+		} else {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("shell %s not found, using %s instead", shell, plan.Shell))
+		}
+	}
+
+	for k, v := range session.EnvVars {
+		plan.Environment[k] = v
+	}
+	for k, v := range request.Environment {
+		plan.Environment[k] = v
+	}
+
+	plan.CommandLine = request.Limits.WrapCommand(request.Command)
+	if session.Sandbox != nil {
+		plan.Shell = runner.dockerPath
+		plan.Container = runner.ContainerName(session.ID)
+		plan.WorkingDir = path.Join(session.Sandbox.WorkingDir, filepath.ToSlash(request.Cwd))
+		plan.Args = runner.ExecArgs(session.ID, session.Sandbox, plan.WorkingDir, plan.CommandLine, plan.Environment, plan.RunAs)
+		plan.Warnings = nil
+	} else if session.Remote != nil {
+		plan.Shell = remote.sshPath
+		plan.Remote = session.Remote.Target()
+		plan.WorkingDir = path.Join(session.WorkingDir, filepath.ToSlash(request.Cwd))
+		plan.Args, plan.stdin = remote.ExecArgs(session.Remote, plan.WorkingDir, plan.CommandLine, plan.Environment)
+		plan.Warnings = nil
+		plan.hostDir = ""
+	} else if request.Persistent {
+		plan.Args = []string{}
+		plan.CommandLine = request.Command
+	} else {
+		plan.Args = []string{"-c", plan.CommandLine}
+		plan.hostDir = plan.WorkingDir
+	}
+
+	return plan
+}
+
+// resolveCwd validates a per-command working directory and returns it
+// relative to the session working directory. The directory may be given
+// relative to the session directory or as an absolute path, which for
+// sandboxed sessions may also be the container path. It must exist and must
+// not lead outside the session directory, including through symlinks.
+func (session *Session) resolveCwd(cwd string) (string, error) {
+	if cwd == "" {
+		return "", nil
+	}
+
+	if session.Remote != nil {
+		// The directory is on the remote machine, so only its form is checked
+		// here; a missing one fails the command there
+		if path.IsAbs(cwd) {
+			cwd = strings.TrimPrefix(path.Clean(cwd), path.Clean(session.WorkingDir)+"/")
+			if path.IsAbs(cwd) {
+				return "", fmt.Errorf("cwd %s is outside the session working directory", cwd)
+			}
+		}
+		rel := path.Clean(cwd)
+		if rel == ".." || strings.HasPrefix(rel, "../") {
+			return "", fmt.Errorf("cwd %s is outside the session working directory", cwd)
+		}
+		return rel, nil
+	}
+
+	if session.Sandbox != nil && path.IsAbs(cwd) {
+		if rel, err := filepath.Rel(session.Sandbox.WorkingDir, cwd); err == nil && !escapesRoot(rel) {
+			cwd = rel
+		}
+	}
+
+	dir := cwd
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(session.WorkingDir, dir)
+	}
+
+	root, err := filepath.EvalSymlinks(session.WorkingDir)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("invalid cwd %s: %v", cwd, err)
+	}
+
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || escapesRoot(rel) {
+		return "", fmt.Errorf("cwd %s is outside the session working directory", cwd)
+	}
+
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", errors.New("cwd is not a directory: " + cwd)
+	}
+	return rel, nil
+}
+
+// escapesRoot reports whether a relative path leads above its base
+func escapesRoot(rel string) bool {
+	return rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// serverOnlyEnv lists server settings that commands must not inherit, since
+// they hold the API keys and the key that encrypts stored secrets
+var serverOnlyEnv = []string{"OSAI_API_KEYS", "TERMINAL_SECRET_KEY"}
+
+// commandEnviron returns the server environment without serverOnlyEnv, as
+// the base environment of every command and shell
+func commandEnviron() []string {
+	var env []string
+	for _, entry := range os.Environ() {
+		name, _, _ := strings.Cut(entry, "=")
+		if !containsString(serverOnlyEnv, name) {
+			env = append(env, entry)
+		}
+	}
+	return env
+}
+
+// Env returns the process environment for the plan. For sandboxed sessions
+// it also holds the values docker exec passes on by name.
+func (p *ExecutionPlan) Env() []string {
+	env := commandEnviron()
+	if p.Remote != "" {
+		// Variables are sent to the remote shell over stdin
+		return env
+	}
+	for k, v := range p.Environment {
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	}
+	return env
+}
+
+// Stdin returns the input the command must start with, or nil
+func (p *ExecutionPlan) Stdin() io.Reader {
+	if len(p.stdin) == 0 {
+		return nil
+	}
+	return bytes.NewReader(p.stdin)
+}
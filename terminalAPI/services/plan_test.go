@@ -0,0 +1,92 @@
+package services
+
+import (
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestCommandsDoNotInheritServerOnlyEnv(t *testing.T) {
+	t.Setenv("OSAI_API_KEYS", "admin:server-api-key")
+	t.Setenv("TERMINAL_SECRET_KEY", "server-secret-key")
+	t.Setenv("OSAI_TEST_VISIBLE", "visible")
+
+	sm := NewSessionManager()
+	session, err := sm.CreateSession(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sm.DeleteSession(session.ID)
+	if err := sm.SetWorkingDirectory(session.ID, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	cs := NewCommandService(sm, NewHistoryService(0, nil))
+
+	for _, persistent := range []bool{false, true} {
+		output, err := cs.ExecuteCommand(session.ID, &CommandRequest{Command: "env", Persistent: persistent})
+		if err != nil {
+			t.Fatalf("persistent=%v: %v", persistent, err)
+		}
+		if !strings.Contains(output.Stdout, "OSAI_TEST_VISIBLE=visible") {
+			t.Errorf("persistent=%v: env output is missing the server environment:\n%s", persistent, output.Stdout)
+		}
+		for _, name := range serverOnlyEnv {
+			if strings.Contains(output.Stdout, name+"=") {
+				t.Errorf("persistent=%v: command inherited %s", persistent, name)
+			}
+		}
+		for _, value := range []string{"server-api-key", "server-secret-key"} {
+			if strings.Contains(output.Stdout, value) {
+				t.Errorf("persistent=%v: command output contains %q", persistent, value)
+			}
+		}
+	}
+}
+
+func TestSandboxAndRemoteEnvStaysOffCommandLine(t *testing.T) {
+	const secret = "s3cr3t value with 'quotes'\nand a newline"
+	request := &CommandRequest{
+		Command:     `printf '%s|' "$TOKEN" "$PLAIN"; cat`,
+		Environment: map[string]string{"TOKEN": secret, "PLAIN": "plain"},
+	}
+
+	sandboxed := &Session{ID: "s1", WorkingDir: t.TempDir(), EnvVars: map[string]string{}, Sandbox: &SandboxConfig{Image: "alpine", Shell: "/bin/sh", WorkingDir: "/workspace"}}
+	plan := buildExecutionPlan(sandboxed, request, false, NewContainerRunner(), NewRemoteRunner())
+	if strings.Contains(strings.Join(plan.Args, " "), "s3cr3t") {
+		t.Errorf("docker exec arguments contain a variable value: %q", plan.Args)
+	}
+	if !strings.Contains(strings.Join(plan.Args, " "), "-e TOKEN") {
+		t.Errorf("docker exec arguments do not pass TOKEN by name: %q", plan.Args)
+	}
+	found := false
+	for _, entry := range plan.Env() {
+		found = found || entry == "TOKEN="+secret
+	}
+	if !found {
+		t.Error("docker CLI environment does not hold the value of TOKEN")
+	}
+
+	remote := &Session{ID: "s2", WorkingDir: t.TempDir(), EnvVars: map[string]string{}, Remote: &RemoteConfig{Host: "example.com", Port: 22, User: "me", Shell: "/bin/sh"}}
+	plan = buildExecutionPlan(remote, request, false, NewContainerRunner(), NewRemoteRunner())
+	if strings.Contains(strings.Join(plan.Args, " "), "s3cr3t") {
+		t.Errorf("ssh arguments contain a variable value: %q", plan.Args)
+	}
+	for _, entry := range plan.Env() {
+		if strings.HasPrefix(entry, "TOKEN=") {
+			t.Error("ssh environment holds TOKEN, which ssh would not forward")
+		}
+	}
+
+	// sshd hands the last argument to the login shell; run it the same way
+	cmd := exec.Command("/bin/sh", "-c", plan.Args[len(plan.Args)-1])
+	cmd.Stdin = io.MultiReader(plan.Stdin(), strings.NewReader("input after the environment\n"))
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("remote command failed: %v\n%s", err, output)
+	}
+	want := secret + "|plain|input after the environment\n"
+	if string(output) != want {
+		t.Errorf("got %q, want %q", output, want)
+	}
+}
@@ -1,8 +1,9 @@
 package services
 
 import (
-	"bufio"
+	"bytes"
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
@@ -19,6 +20,7 @@ import (
 type Process struct {
 	ID          string       `json:"id"`
 	Command     string       `json:"command"`
+	ExpandedCommand string   `json:"expandedCommand,omitempty"` // What actually ran, when the command used session aliases
 	StartTime   time.Time    `json:"startTime"`
 	Cmd         *exec.Cmd    `json:"-"`
 	StdinPipe   io.WriteCloser `json:"-"`
@@ -28,17 +30,31 @@ type Process struct {
 	PID         int          `json:"pid"`
 	ExitCode    int          `json:"exitCode"`
 	Completed   bool         `json:"completed"`
+	Paused      bool         `json:"paused"`
+	EndTime     time.Time    `json:"endTime,omitempty"`
 	Lock        sync.Mutex   `json:"-"`
 	Done        chan struct{} `json:"-"`
+	expect      *expectMatcher
+	prompts     *promptDetector
+	log         *processLog
 }
 
 type ProcessInfo struct {
 	ID         string    `json:"id"`
 	Command    string    `json:"command"`
+	ExpandedCommand string `json:"expandedCommand,omitempty"`
 	StartTime  time.Time `json:"startTime"`
 	IsRunning  bool      `json:"isRunning"`
 	ExitCode   int       `json:"exitCode,omitempty"`
 	PID        int       `json:"pid,omitempty"`
+	IsPaused   bool      `json:"isPaused,omitempty"`
+	ElapsedSeconds float64 `json:"elapsedSeconds,omitempty"`
+	CPUPercent     float64 `json:"cpuPercent,omitempty"`
+	MemoryRSS      int64   `json:"memoryRSS,omitempty"`
+	DryRun         *ExecutionPlan `json:"dryRun,omitempty"` // Set instead of starting when dryRun was requested
+	WaitingForInput bool          `json:"waitingForInput,omitempty"` // Output looks like an unanswered prompt
+	LogFile        string         `json:"logFile,omitempty"` // Name of the process log when logToFile was set
+	Node           string         `json:"node,omitempty"`    // Replica the process runs on
 }
 
 type OutputBuffer struct {
@@ -49,32 +65,214 @@ type OutputBuffer struct {
 	// For real-time streaming
 	StdoutChan  chan string  `json:"-"`
 	StderrChan  chan string  `json:"-"`
+	subscribers map[chan OutputEvent]struct{}
+	closed      bool
+	exitCode    int
+	// Number of lines trimmed from the front of each buffer, so cursors
+	// stay absolute line indexes
+	StdoutTrimmed int `json:"-"`
+	StderrTrimmed int `json:"-"`
+	// Total bytes of stdout and stderr to capture; 0 means unlimited
+	MaxBytes      int64 `json:"-"`
+	capturedBytes int64
+	Truncated     bool `json:"-"`
+	// Raw mode keeps output as byte chunks instead of lines
+	Raw       bool   `json:"-"`
+	StdoutRaw []byte `json:"-"`
+	StderrRaw []byte `json:"-"`
 }
 
+// defaultRawOutputLimit caps raw output per process when no output limit is set
+const defaultRawOutputLimit = 64 * 1024 * 1024
+
+// RawOutput is a process's raw output, base64 encoded for JSON
+type RawOutput struct {
+	Stdout      string `json:"stdout"`
+	Stderr      string `json:"stderr"`
+	StdoutBytes int    `json:"stdoutBytes"`
+	StderrBytes int    `json:"stderrBytes"`
+	Encoding    string `json:"encoding"` // Always "base64"
+	Truncated   bool   `json:"truncated,omitempty"`
+}
+
+// ErrNotRawProcess is returned when raw output is requested from a process
+// that was not started in raw mode
+var ErrNotRawProcess = errors.New("process was not started in raw mode")
+
+// OutputSlice is the portion of a process's output after a pair of cursors
+type OutputSlice struct {
+	Stdout       []string `json:"stdout"`
+	Stderr       []string `json:"stderr"`
+	StdoutCursor int      `json:"stdoutCursor"` // Pass back as stdoutSince to get only newer lines
+	StderrCursor int      `json:"stderrCursor"`
+	// Set when lines before the cursor's position were trimmed from the buffer
+	StdoutMissed int `json:"stdoutMissed,omitempty"`
+	StderrMissed int `json:"stderrMissed,omitempty"`
+	Truncated    bool `json:"truncated,omitempty"` // Stdout and stderr together exceeded limits.maxOutputBytes
+	// Lines before the cursor left out by grep, head, tail or maxBytes
+	StdoutOmitted int `json:"stdoutOmitted,omitempty"`
+	StderrOmitted int `json:"stderrOmitted,omitempty"`
+}
+
+// OutputEvent is a single line of output or a completion notice delivered
+// to streaming subscribers
+type OutputEvent struct {
+	Type      string    `json:"type"` // stdout, stderr or exit
+	Line      string    `json:"line,omitempty"`
+	Data      string    `json:"data,omitempty"` // Base64 encoded chunk for raw mode processes
+	ExitCode  int       `json:"exitCode"`
+	Timestamp time.Time `json:"timestamp"`
+	Replay    bool      `json:"replay,omitempty"` // Line was buffered before the subscriber connected
+}
+
+// ProcessCompletion is the payload POSTed to a process's callbackUrl
+type ProcessCompletion struct {
+	Event           string    `json:"event"` // Always "process.completed"
+	SessionID       string    `json:"sessionId"`
+	ProcessID       string    `json:"processId"`
+	Command         string    `json:"command"`
+	PID             int       `json:"pid"`
+	ExitCode        int       `json:"exitCode"`
+	StartTime       time.Time `json:"startTime"`
+	EndTime         time.Time `json:"endTime"`
+	DurationSeconds float64   `json:"durationSeconds"`
+	StdoutTail      []string  `json:"stdoutTail"`
+	StderrTail      []string  `json:"stderrTail"`
+	OutputTruncated bool      `json:"outputTruncated,omitempty"`
+}
+
+// Number of trailing output lines included in completion callbacks
+const callbackTailLines = 50
+
+// Time allowed for output to drain after a process exits, in case a
+// background child still holds its pipes open
+const outputDrainTimeout = 2 * time.Second
+
 type ProcessService struct {
 	sessionManager *SessionManager
 	historyService *HistoryService
+	webhooks       *WebhookSender
+	logDir         string // Holds a directory of process logs per session
+	maxOutputLines int    // Output lines kept in memory per process
 }
 
+// DefaultMaxOutputLines is how many lines of output each process keeps in
+// memory unless configured otherwise
+const DefaultMaxOutputLines = 10000
+
 func NewProcessService(sm *SessionManager, hs *HistoryService) *ProcessService {
 	return &ProcessService{
 		sessionManager: sm,
 		historyService: hs,
+		webhooks:       NewWebhookSender(),
+		logDir:         defaultLogDir(),
+		maxOutputLines: DefaultMaxOutputLines,
 	}
 }
 
+// SetMaxOutputLines sets how many lines of output processes started
+// afterwards keep in memory
+func (ps *ProcessService) SetMaxOutputLines(max int) {
+	if max > 0 {
+		ps.maxOutputLines = max
+	}
+}
+
+// StartProcess starts a command in the background and records it in the
+// audit log
 func (ps *ProcessService) StartProcess(sessionID string, request *CommandRequest) (*ProcessInfo, error) {
+	info, err := ps.startProcess(sessionID, request)
+	
+	detail := ""
+	if info != nil {
+		detail = "process " + info.ID
+	}
+	ps.sessionManager.Audit(sessionID, "process.start", ps.sessionManager.redact(request.Command), detail, err)
+	
+	return info, err
+}
+
+func (ps *ProcessService) startProcess(sessionID string, request *CommandRequest) (*ProcessInfo, error) {
 	session, err := ps.sessionManager.GetSession(sessionID)
 	if err != nil {
 		return nil, err
 	}
 	
 	if session.WorkingDir == "" {
-		return nil, errors.New("working directory not set for session")
+		return nil, ErrNoWorkingDir
+	}
+	
+	if err := request.Limits.Validate(); err != nil {
+		return nil, err
+	}
+	
+	if request.Persistent {
+		return nil, errors.New("persistent mode is not supported for background processes")
+	}
+	
+	if err := session.checkRemoteRequest(request); err != nil {
+		return nil, err
+	}
+	
+	if request.CallbackURL != "" {
+		if err := ValidateCallbackURL(request.CallbackURL); err != nil {
+			return nil, err
+		}
+	}
+	
+	var expect *expectMatcher
+	if len(request.Expect) > 0 {
+		if expect, err = newExpectMatcher(request.Expect); err != nil {
+			return nil, err
+		}
+	}
+	
+	cwd, err := session.resolveCwd(request.Cwd)
+	if err != nil {
+		return nil, err
+	}
+	if cwd != request.Cwd {
+		resolved := *request
+		resolved.Cwd = cwd
+		request = &resolved
+	}
+	
+	// The command as typed and as reported back, with secret values masked
+	command := ps.sessionManager.redact(request.Command)
+	if expanded := session.expandAliases(request.Command); expanded != request.Command {
+		resolved := *request
+		resolved.Command = expanded
+		request = &resolved
+	}
+	expandedCommand := ""
+	if expanded := ps.sessionManager.redact(request.Command); expanded != command {
+		expandedCommand = expanded
+	}
+	
+	request, err = ps.sessionManager.withSecrets(request)
+	if err != nil {
+		return nil, err
+	}
+	
+	plan := buildExecutionPlan(session, request, true, ps.sessionManager.containerRunner, ps.sessionManager.remoteRunner)
+	for _, warning := range plan.Warnings {
+		sessionLog(sessionID).Warn(warning, LogKeyOperation, "process.start")
+	}
+	
+	if request.DryRun {
+		return &ProcessInfo{
+			Command:         command,
+			ExpandedCommand: expandedCommand,
+			DryRun:          ps.sessionManager.redactPlan(plan),
+		}, nil
+	}
+	
+	if err := ps.sessionManager.CheckProcessCapacity(sessionID); err != nil {
+		return nil, err
 	}
 	
 	// Record in history
-	ps.historyService.AddToHistory(sessionID, request.Command)
+	historyID := ps.historyService.AddToHistory(sessionID, command)
 	
 	// Create command context
 	var ctx context.Context
@@ -86,59 +284,56 @@ func (ps *ProcessService) StartProcess(sessionID string, request *CommandRequest
 		ctx, cancel = context.WithCancel(context.Background())
 	}
 	
-	// Determine which shell to use based on session environment
-	shellPath := "/bin/bash"  // Default shell
-	if shell, exists := session.EnvVars["SHELL"]; exists && shell != "" {
-		// Verify the shell exists and is executable
-		if _, err := os.Stat(shell); err == nil {
-			shellPath = shell
-		} else {
-			// Log warning but continue with default shell
-			fmt.Printf("[WARNING] Session %s: Shell %s not found, using %s instead\n", 
-				sessionID, shell, shellPath)
+	if session.Sandbox != nil {
+		if _, err := ps.sessionManager.containerRunner.EnsureContainer(session); err != nil {
+			cancel()
+			return nil, err
 		}
 	}
 	
-	// Start with system environment
-	env := os.Environ()
-	
-	// Add session environment variables
-	for k, v := range session.EnvVars {
-		env = append(env, fmt.Sprintf("%s=%s", k, v))
-	}
-	
-	// Add request-specific environment variables
-	for k, v := range request.Environment {
-		env = append(env, fmt.Sprintf("%s=%s", k, v))
-	}
-	
 	// Create command
-	cmd := exec.CommandContext(ctx, shellPath, "-c", request.Command)
-	cmd.Dir = session.WorkingDir
-	cmd.Env = env
+	cmd := exec.CommandContext(ctx, plan.Shell, plan.Args...)
+	cmd.Dir = plan.hostDir
+	startInProcessGroup(cmd)
+	cmd.Env = plan.Env()
+	if err := applyRunAs(cmd, plan); err != nil {
+		cancel()
+		return nil, err
+	}
 	
-	// Get pipes for stdin, stdout, stderr
+	// Get pipes for stdin, stdout, stderr. Output uses our own pipes rather
+	// than StdoutPipe so that Wait does not close them before they are drained.
 	stdinPipe, err := cmd.StdinPipe()
 	if err != nil {
 		cancel()
 		return nil, err
 	}
 	
-	stdoutPipe, err := cmd.StdoutPipe()
+	stdoutPipe, stdoutWriter, err := os.Pipe()
 	if err != nil {
 		cancel()
 		return nil, err
 	}
 	
-	stderrPipe, err := cmd.StderrPipe()
+	stderrPipe, stderrWriter, err := os.Pipe()
 	if err != nil {
+		stdoutPipe.Close()
+		stdoutWriter.Close()
 		cancel()
 		return nil, err
 	}
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = stderrWriter
+	closePipes := func() {
+		stdoutPipe.Close()
+		stderrPipe.Close()
+	}
 	
 	// Create output buffer
 	outputBuffer := &OutputBuffer{
-		MaxLines:   10000, // Maximum lines to keep in buffer
+		MaxLines:   ps.maxOutputLines, // Maximum lines to keep in buffer
+		MaxBytes:   request.Limits.outputLimit(),
+		Raw:        request.Raw,
 		StdoutChan: make(chan string, 100),
 		StderrChan: make(chan string, 100),
 	}
@@ -147,7 +342,8 @@ func (ps *ProcessService) StartProcess(sessionID string, request *CommandRequest
 	processID := uuid.New().String()
 	process := &Process{
 		ID:          processID,
-		Command:     request.Command,
+		Command:     command,
+		ExpandedCommand: expandedCommand,
 		StartTime:   time.Now(),
 		Cmd:         cmd,
 		StdinPipe:   stdinPipe,
@@ -156,26 +352,107 @@ func (ps *ProcessService) StartProcess(sessionID string, request *CommandRequest
 		OutputBuffer: outputBuffer,
 		Completed:   false,
 		Done:        make(chan struct{}),
+		expect:      expect,
+		prompts:     newPromptDetector(),
+	}
+	
+	if request.LogToFile {
+		process.log, err = openProcessLog(ps.sessionLogDir(sessionID), processLogMeta{
+			ProcessID: processID,
+			SessionID: sessionID,
+			Command:   command,
+			StartTime: process.StartTime,
+		})
+		if err != nil {
+			stdoutWriter.Close()
+			stderrWriter.Close()
+			closePipes()
+			cancel()
+			return nil, fmt.Errorf("failed to create process log: %v", err)
+		}
+	}
+	
+	// Answer prompts matching the expect rules
+	if expect != nil {
+		expect.stdin = stdinPipe
+		expect.onMatch = func(event ExpectEvent) {
+			process.prompts.inputSent()
+			ps.sessionManager.LogActivity(sessionID, ActivityEntry{
+				Category: ActivityProcess,
+				Target:   processID,
+				Message:  fmt.Sprintf("Auto-responded to '%s' in process %s", event.Matched, processID),
+			})
+			sessionLog(sessionID).Info("auto-responded to prompt", "process", processID, "prompt", event.Matched)
+		}
+	}
+	onData := func(stream string) func(data []byte) {
+		redactor := ps.sessionManager.newStreamRedactor()
+		return func(data []byte) {
+			var redacted []byte
+			if data == nil {
+				redacted = redactor.Flush()
+			} else {
+				process.prompts.feed(data)
+				redacted = redactor.Write(data)
+			}
+			if len(redacted) > 0 {
+				ps.sessionManager.emitTerminalOutput(sessionID, redacted)
+				if process.log != nil {
+					process.log.Write(redacted)
+				}
+			}
+			if expect != nil && data != nil {
+				expect.feed(stream, data)
+			}
+		}
 	}
 	
 	// Start the command
-	if err := cmd.Start(); err != nil {
+	err = cmd.Start()
+	// The child has its own copies of the write ends
+	stdoutWriter.Close()
+	stderrWriter.Close()
+	if err != nil {
+		closePipes()
 		cancel()
+		if process.log != nil {
+			process.log.Close()
+		}
 		return nil, err
 	}
 	
 	process.PID = cmd.Process.Pid
+	if plan.stdin != nil {
+		// A failed write means the process already exited, which Wait reports
+		stdinPipe.Write(plan.stdin)
+	}
+	ps.sessionManager.emitTerminalOutput(sessionID, []byte("$ "+ps.sessionManager.redact(command)+" &\n"))
 	
 	// Register process with session
 	if err := ps.sessionManager.RegisterProcess(sessionID, processID, process); err != nil {
 		cmd.Process.Kill()
+		cmd.Wait()
+		closePipes()
 		cancel()
+		if process.log != nil {
+			process.log.Close()
+		}
 		return nil, err
 	}
 	
 	// Start goroutines to collect output
-	go ps.collectOutput(stdoutPipe, outputBuffer.StdoutChan, &outputBuffer.Stdout, outputBuffer)
-	go ps.collectOutput(stderrPipe, outputBuffer.StderrChan, &outputBuffer.Stderr, outputBuffer)
+	var collectors sync.WaitGroup
+	collectors.Add(2)
+	go func() {
+		defer collectors.Done()
+		ps.collectOutput(stdoutPipe, outputBuffer.StdoutChan, &outputBuffer.Stdout, outputBuffer, "stdout", onData("stdout"))
+	}()
+	go func() {
+		defer collectors.Done()
+		ps.collectOutput(stderrPipe, outputBuffer.StderrChan, &outputBuffer.Stderr, outputBuffer, "stderr", onData("stderr"))
+	}()
+	
+	go process.watchForPrompts()
 	
 	// Wait for process to complete
 	go func() {
@@ -183,8 +460,27 @@ func (ps *ProcessService) StartProcess(sessionID string, request *CommandRequest
 		defer cancel()
 		
 		err := cmd.Wait()
+		
+		// Let the collectors read everything the process wrote before it is
+		// reported as completed
+		drained := make(chan struct{})
+		go func() {
+			collectors.Wait()
+			close(drained)
+		}()
+		select {
+		case <-drained:
+		case <-time.After(outputDrainTimeout):
+		}
+		closePipes()
+		<-drained
+		if process.log != nil {
+			process.log.Close()
+		}
+		
 		process.Lock.Lock()
 		process.Completed = true
+		process.EndTime = time.Now()
 		if err != nil {
 			if exitErr, ok := err.(*exec.ExitError); ok {
 				process.ExitCode = exitErr.ExitCode()
@@ -195,59 +491,162 @@ func (ps *ProcessService) StartProcess(sessionID string, request *CommandRequest
 			process.ExitCode = 0
 		}
 		process.Lock.Unlock()
+		ps.historyService.RecordExitCode(sessionID, historyID, process.ExitCode)
+		
+		ps.sessionManager.LogActivity(sessionID, ActivityEntry{
+			Category: ActivityProcess,
+			Target:   processID,
+			Outcome:  exitOutcome(process.ExitCode),
+			Message:  fmt.Sprintf("Process completed: %s (exit code: %d)", command, process.ExitCode),
+		})
+		ps.sessionManager.Audit(sessionID, "process.exit", command,
+			fmt.Sprintf("process %s exited with code %d", processID, process.ExitCode), nil)
+		
+		// Close output channels; the collectors have stopped sending
+		close(outputBuffer.StdoutChan)
+		close(outputBuffer.StderrChan)
+		outputBuffer.closeSubscribers(process.ExitCode)
 		
-		ps.sessionManager.LogActivity(sessionID, fmt.Sprintf("Process completed: %s (exit code: %d)", 
-			request.Command, process.ExitCode))
-		fmt.Printf("[TERMINAL] Session %s: Process '%s' (ID: %s) completed with exit code %d\n", 
-			sessionID, request.Command, processID, process.ExitCode)
-			
-			// Give some time for any remaining output to be processed before closing channels
-			time.Sleep(100 * time.Millisecond)
-			
-			// Close output channels
-			close(outputBuffer.StdoutChan)
-			close(outputBuffer.StderrChan)
+		if request.CallbackURL != "" {
+			ps.webhooks.Send(request.CallbackURL, process.completion(sessionID))
+		}
 	}()
 	
-	ps.sessionManager.LogActivity(sessionID, fmt.Sprintf("Started process: %s (PID: %d, ID: %s)", 
-		request.Command, process.PID, processID))
-	fmt.Printf("[TERMINAL] Session %s: Started process '%s' with PID %d (ID: %s)\n", 
-		sessionID, request.Command, process.PID, processID)
-	
+	ps.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityProcess,
+		Target:   processID,
+		Message:  fmt.Sprintf("Started process: %s (PID: %d, ID: %s)", command, process.PID, processID),
+	})
 	return &ProcessInfo{
 		ID:        processID,
-		Command:   request.Command,
+		Command:   command,
+		ExpandedCommand: expandedCommand,
 		StartTime: process.StartTime,
 		IsRunning: true,
 		PID:       process.PID,
+		Node:      ps.sessionManager.Node(),
 	}, nil
 }
 
-func (ps *ProcessService) collectOutput(pipe io.ReadCloser, channel chan string, buffer *[]string, outputBuffer *OutputBuffer) {
-	scanner := bufio.NewScanner(pipe)
-	for scanner.Scan() {
-		line := scanner.Text()
-		
-		// Send line to channel for real-time consumers - safely handle closed channel
-		select {
-		case channel <- line:
-			// Successfully sent
-		default:
-			// Channel is either full or closed, just continue without sending
+// maxPendingLine bounds how much of an unterminated line is held before it
+// is stored as a line of its own
+const maxPendingLine = 64 * 1024
+
+// collectOutput reads a process pipe in chunks, storing complete lines in the
+// buffer. onData, if set, sees every raw chunk, including partial lines such
+// as prompts that are not followed by a newline, and is called with nil once
+// the pipe is drained. Raw chunks are masked as a stream before they are
+// stored, since secrets in them are not confined to one line.
+func (ps *ProcessService) collectOutput(pipe io.Reader, channel chan string, buffer *[]string, outputBuffer *OutputBuffer, stream string, onData func(data []byte)) {
+	chunk := make([]byte, 4096)
+	var pending []byte
+	redactor := ps.sessionManager.newStreamRedactor()
+	for {
+		n, err := pipe.Read(chunk)
+		if n > 0 && outputBuffer.Raw {
+			if onData != nil {
+				onData(chunk[:n])
+			}
+			if redacted := redactor.Write(chunk[:n]); len(redacted) > 0 {
+				outputBuffer.storeChunk(redacted, stream)
+			}
+		} else if n > 0 {
+			if onData != nil {
+				onData(chunk[:n])
+			}
+			pending = append(pending, chunk[:n]...)
+			for {
+				idx := bytes.IndexByte(pending, '\n')
+				if idx < 0 {
+					break
+				}
+				ps.storeLine(strings.TrimSuffix(string(pending[:idx]), "\r"), channel, buffer, outputBuffer, stream)
+				pending = pending[idx+1:]
+			}
+			if len(pending) >= maxPendingLine {
+				ps.storeLine(string(pending), channel, buffer, outputBuffer, stream)
+				pending = nil
+			}
 		}
-		
-		// Add to buffer for later retrieval
-		outputBuffer.Lock.Lock()
-		*buffer = append(*buffer, line)
-		
-		// Trim buffer if it exceeds max lines
-		if len(*buffer) > outputBuffer.MaxLines {
-			*buffer = (*buffer)[len(*buffer)-outputBuffer.MaxLines:]
+		if err != nil {
+			if len(pending) > 0 {
+				ps.storeLine(strings.TrimSuffix(string(pending), "\r"), channel, buffer, outputBuffer, stream)
+			}
+			if redacted := redactor.Flush(); len(redacted) > 0 {
+				outputBuffer.storeChunk(redacted, stream)
+			}
+			if onData != nil {
+				onData(nil)
+			}
+			return
+		}
+	}
+}
+
+// storeLine appends a line to the output buffer and delivers it to streaming
+// consumers
+func (ps *ProcessService) storeLine(line string, channel chan string, buffer *[]string, outputBuffer *OutputBuffer, stream string) {
+	line = ps.sessionManager.redact(line)
+	
+	// Send line to channel for real-time consumers - safely handle closed channel
+	select {
+	case channel <- line:
+		// Successfully sent
+	default:
+		// Channel is either full or closed, just continue without sending
+	}
+	
+	// Add to buffer for later retrieval
+	outputBuffer.Lock.Lock()
+	defer outputBuffer.Lock.Unlock()
+	if outputBuffer.MaxBytes > 0 && outputBuffer.capturedBytes+int64(len(line)) > outputBuffer.MaxBytes {
+		// Drop output past the limit but keep draining the pipe
+		outputBuffer.Truncated = true
+		return
+	}
+	outputBuffer.capturedBytes += int64(len(line))
+	*buffer = append(*buffer, line)
+	outputBuffer.publish(OutputEvent{Type: stream, Line: line, Timestamp: time.Now()})
+	
+	// Trim buffer if it exceeds max lines
+	if len(*buffer) > outputBuffer.MaxLines {
+		trimmed := len(*buffer) - outputBuffer.MaxLines
+		*buffer = (*buffer)[trimmed:]
+		if stream == "stderr" {
+			outputBuffer.StderrTrimmed += trimmed
+		} else {
+			outputBuffer.StdoutTrimmed += trimmed
 		}
-		outputBuffer.Lock.Unlock()
 	}
 }
 
+// storeChunk appends raw output, up to the output limit, and delivers it to
+// streaming consumers base64 encoded
+func (ob *OutputBuffer) storeChunk(data []byte, stream string) {
+	ob.Lock.Lock()
+	defer ob.Lock.Unlock()
+	
+	limit := ob.MaxBytes
+	if limit <= 0 {
+		limit = defaultRawOutputLimit
+	}
+	if remaining := limit - ob.capturedBytes; int64(len(data)) > remaining {
+		ob.Truncated = true
+		if remaining <= 0 {
+			return
+		}
+		data = data[:remaining]
+	}
+	ob.capturedBytes += int64(len(data))
+	
+	if stream == "stderr" {
+		ob.StderrRaw = append(ob.StderrRaw, data...)
+	} else {
+		ob.StdoutRaw = append(ob.StdoutRaw, data...)
+	}
+	ob.publish(OutputEvent{Type: stream, Data: base64.StdEncoding.EncodeToString(data), Timestamp: time.Now()})
+}
+
 func (ps *ProcessService) SendInput(sessionID string, processID string, input string) error {
 	process, err := ps.sessionManager.GetProcess(sessionID, processID)
 	if err != nil {
@@ -268,8 +667,16 @@ func (ps *ProcessService) SendInput(sessionID string, processID string, input st
 		return err
 	}
 	
-	ps.sessionManager.LogActivity(sessionID, fmt.Sprintf("Sent input to process %s", processID))
-	fmt.Printf("[TERMINAL] Session %s: Sent input to process %s\n", sessionID, processID)
+	if process.prompts != nil {
+		process.prompts.inputSent()
+	}
+	
+	ps.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityProcess,
+		Target:   processID,
+		Message:  "Sent input to process " + processID,
+	})
+	ps.sessionManager.Audit(sessionID, "process.input", processID, "", nil)
 	
 	return nil
 }
@@ -294,47 +701,327 @@ func (ps *ProcessService) GetOutput(sessionID string, processID string) (*Output
 	copy(outputCopy.Stderr, process.OutputBuffer.Stderr)
 	process.OutputBuffer.Lock.Unlock()
 	
-	ps.sessionManager.LogActivity(sessionID, fmt.Sprintf("Retrieved output from process %s", processID))
-	fmt.Printf("[TERMINAL] Session %s: Retrieved output from process %s\n", sessionID, processID)
+	ps.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityProcess,
+		Target:   processID,
+		Message:  "Retrieved output from process " + processID,
+	})
+	sessionLog(sessionID).Debug("retrieved process output", "process", processID)
 	
 	return outputCopy, nil
 }
 
-func (ps *ProcessService) SignalProcess(sessionID string, processID string, signal string) error {
+// SendRawInput writes bytes to a process's stdin exactly as given
+func (ps *ProcessService) SendRawInput(sessionID string, processID string, data []byte) error {
 	process, err := ps.sessionManager.GetProcess(sessionID, processID)
 	if err != nil {
 		return err
 	}
 	
-	if process.Cmd == nil || process.Cmd.Process == nil {
-		return errors.New("process is not running")
+	if process.StdinPipe == nil {
+		return errors.New("process stdin pipe is not available")
+	}
+	
+	if _, err := process.StdinPipe.Write(data); err != nil {
+		return err
+	}
+	
+	if process.prompts != nil {
+		process.prompts.inputSent()
+	}
+	
+	ps.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityProcess,
+		Target:   processID,
+		Message:  fmt.Sprintf("Sent %d bytes of raw input to process %s", len(data), processID),
+	})
+	ps.sessionManager.Audit(sessionID, "process.input", processID, fmt.Sprintf("%d bytes of raw input", len(data)), nil)
+	
+	return nil
+}
+
+// GetRawOutput returns a copy of a raw mode process's stdout or stderr bytes
+func (ps *ProcessService) GetRawOutput(sessionID string, processID string, stream string) ([]byte, error) {
+	process, err := ps.sessionManager.GetProcess(sessionID, processID)
+	if err != nil {
+		return nil, err
+	}
+	
+	ob := process.OutputBuffer
+	if ob == nil || !ob.Raw {
+		return nil, ErrNotRawProcess
+	}
+	
+	ob.Lock.Lock()
+	defer ob.Lock.Unlock()
+	switch stream {
+	case "stdout", "":
+		return append([]byte{}, ob.StdoutRaw...), nil
+	case "stderr":
+		return append([]byte{}, ob.StderrRaw...), nil
+	}
+	return nil, fmt.Errorf("unknown stream: %s", stream)
+}
+
+// GetEncodedOutput returns a raw mode process's output base64 encoded
+func (ps *ProcessService) GetEncodedOutput(sessionID string, processID string) (*RawOutput, error) {
+	process, err := ps.sessionManager.GetProcess(sessionID, processID)
+	if err != nil {
+		return nil, err
+	}
+	
+	ob := process.OutputBuffer
+	if ob == nil || !ob.Raw {
+		return nil, ErrNotRawProcess
+	}
+	
+	ob.Lock.Lock()
+	defer ob.Lock.Unlock()
+	return &RawOutput{
+		Stdout:      base64.StdEncoding.EncodeToString(ob.StdoutRaw),
+		Stderr:      base64.StdEncoding.EncodeToString(ob.StderrRaw),
+		StdoutBytes: len(ob.StdoutRaw),
+		StderrBytes: len(ob.StderrRaw),
+		Encoding:    "base64",
+		Truncated:   ob.Truncated,
+	}, nil
+}
+
+// GetOutputSince returns the lines written after the given absolute line
+// indexes along with the cursors to use for the next poll. A non-nil filter
+// narrows the lines returned.
+func (ps *ProcessService) GetOutputSince(sessionID string, processID string, stdoutSince int, stderrSince int, filter *OutputFilter) (*OutputSlice, error) {
+	process, err := ps.sessionManager.GetProcess(sessionID, processID)
+	if err != nil {
+		return nil, err
+	}
+	
+	if process.OutputBuffer == nil {
+		return nil, errors.New("output buffer not available")
+	}
+	
+	ob := process.OutputBuffer
+	ob.Lock.Lock()
+	stdout, stdoutCursor, stdoutMissed := sliceSince(ob.Stdout, ob.StdoutTrimmed, stdoutSince)
+	stderr, stderrCursor, stderrMissed := sliceSince(ob.Stderr, ob.StderrTrimmed, stderrSince)
+	truncated := ob.Truncated
+	ob.Lock.Unlock()
+	
+	stdout, stdoutCursor, stdoutOmitted := filter.apply(stdout, stdoutCursor-len(stdout))
+	stderr, stderrCursor, stderrOmitted := filter.apply(stderr, stderrCursor-len(stderr))
+	
+	sessionLog(sessionID).Debug("retrieved process output", "process", processID,
+		"stdoutSince", stdoutSince, "stderrSince", stderrSince)
+	
+	return &OutputSlice{
+		Stdout:        stdout,
+		Stderr:        stderr,
+		StdoutCursor:  stdoutCursor,
+		StderrCursor:  stderrCursor,
+		StdoutMissed:  stdoutMissed,
+		StderrMissed:  stderrMissed,
+		Truncated:     truncated,
+		StdoutOmitted: stdoutOmitted,
+		StderrOmitted: stderrOmitted,
+	}, nil
+}
+
+// sliceSince copies the lines of a trimmed buffer from an absolute index,
+// returning the next cursor and how many requested lines were already trimmed
+func sliceSince(lines []string, trimmed int, since int) ([]string, int, int) {
+	if since < 0 {
+		since = 0
+	}
+	
+	missed := 0
+	start := since - trimmed
+	if start < 0 {
+		missed = -start
+		start = 0
+	}
+	if start > len(lines) {
+		start = len(lines)
 	}
 	
-	var sig syscall.Signal
+	result := make([]string, len(lines)-start)
+	copy(result, lines[start:])
+	return result, trimmed + len(lines), missed
+}
+
+// SubscribeOutput registers a streaming subscriber on a process's output and
+// returns the lines buffered so far. The returned channel is closed after the
+// exit event is delivered.
+func (ps *ProcessService) SubscribeOutput(sessionID string, processID string) (*OutputSubscription, error) {
+	process, err := ps.sessionManager.GetProcess(sessionID, processID)
+	if err != nil {
+		return nil, err
+	}
+	
+	if process.OutputBuffer == nil {
+		return nil, errors.New("output buffer not available")
+	}
+	
+	return process.OutputBuffer.Subscribe(), nil
+}
+
+// parseSignal maps the signal names accepted by the API to signals
+func parseSignal(signal string) (syscall.Signal, error) {
 	switch signal {
 	case "SIGTERM":
-		sig = syscall.SIGTERM
+		return syscall.SIGTERM, nil
 	case "SIGKILL":
-		sig = syscall.SIGKILL
+		return syscall.SIGKILL, nil
 	case "SIGINT":
-		sig = syscall.SIGINT
+		return syscall.SIGINT, nil
 	case "SIGHUP":
-		sig = syscall.SIGHUP
-	default:
-		return fmt.Errorf("unsupported signal: %s", signal)
+		return syscall.SIGHUP, nil
+	case "SIGSTOP":
+		return syscall.SIGSTOP, nil
+	case "SIGCONT":
+		return syscall.SIGCONT, nil
+	}
+	return 0, fmt.Errorf("unsupported signal: %s", signal)
+}
+
+// SignalProcess sends a signal to a process. When tree is set the signal is
+// delivered to the process group, reaching children spawned by the shell.
+func (ps *ProcessService) SignalProcess(sessionID string, processID string, signal string, tree bool) error {
+	process, err := ps.sessionManager.GetProcess(sessionID, processID)
+	if err != nil {
+		return err
 	}
 	
-	err = process.Cmd.Process.Signal(sig)
+	if process.Cmd == nil || process.Cmd.Process == nil {
+		return ErrProcessNotRunning
+	}
+	
+	sig, err := parseSignal(signal)
+	if err != nil {
+		return err
+	}
+	
+	if tree {
+		err = signalProcessTree(process.Cmd.Process.Pid, sig)
+	} else {
+		err = process.Cmd.Process.Signal(sig)
+	}
 	if err != nil {
 		return err
 	}
 	
-	ps.sessionManager.LogActivity(sessionID, fmt.Sprintf("Sent signal %s to process %s", signal, processID))
-	fmt.Printf("[TERMINAL] Session %s: Sent signal %s to process %s\n", sessionID, signal, processID)
+	// Track job-control state so listings show suspended processes
+	switch sig {
+	case syscall.SIGSTOP:
+		process.setPaused(true)
+	case syscall.SIGCONT, syscall.SIGKILL:
+		process.setPaused(false)
+	}
+	
+	ps.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityProcess,
+		Target:   processID,
+		Message:  fmt.Sprintf("Sent signal %s to process %s", signal, processID),
+	})
+	ps.sessionManager.Audit(sessionID, "process.signal", processID, signal, nil)
+	
+	return nil
+}
+
+// KillAllRequest selects the running processes of a session to signal
+type KillAllRequest struct {
+	Signal string `json:"signal,omitempty"` // Defaults to SIGKILL
+	Match  string `json:"match,omitempty"`  // Only processes whose command contains this substring
+	Tree   *bool  `json:"tree,omitempty"`   // Signal whole process groups (default true)
+}
+
+// KillAllResult lists the processes a kill-all reached
+type KillAllResult struct {
+	Signal   string            `json:"signal"`
+	Signaled []string          `json:"signaled"`
+	Failed   map[string]string `json:"failed,omitempty"` // Process ID to error
+}
+
+// KillAll signals every running process in the session, optionally only
+// those whose command contains request.Match
+func (ps *ProcessService) KillAll(sessionID string, request *KillAllRequest) (*KillAllResult, error) {
+	signal := request.Signal
+	if signal == "" {
+		signal = "SIGKILL"
+	}
+	if _, err := parseSignal(signal); err != nil {
+		return nil, err
+	}
+	
+	processes, err := ps.sessionManager.ListProcesses(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	tree := request.Tree == nil || *request.Tree
+	
+	result := &KillAllResult{
+		Signal:   signal,
+		Signaled: []string{},
+	}
+	
+	for id, info := range processes {
+		if !info.IsRunning || !strings.Contains(info.Command, request.Match) {
+			continue
+		}
+		
+		if err := ps.SignalProcess(sessionID, id, signal, tree); err != nil {
+			if result.Failed == nil {
+				result.Failed = make(map[string]string)
+			}
+			result.Failed[id] = err.Error()
+			continue
+		}
+		
+		// A stopped process only acts on catchable signals once resumed
+		if info.IsPaused && signal != "SIGKILL" && signal != "SIGSTOP" && signal != "SIGCONT" {
+			ps.SignalProcess(sessionID, id, "SIGCONT", tree)
+		}
+		result.Signaled = append(result.Signaled, id)
+	}
 	
+	ps.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityProcess,
+		Message:  fmt.Sprintf("Sent %s to %d processes", signal, len(result.Signaled)),
+	})
+	ps.sessionManager.Audit(sessionID, "process.kill-all", "", fmt.Sprintf("sent %s to %d processes", signal, len(result.Signaled)), nil)
+	
+	return result, nil
+}
+
+// DeleteProcess removes the record of a completed process
+func (ps *ProcessService) DeleteProcess(sessionID string, processID string) error {
+	if err := ps.sessionManager.RemoveProcess(sessionID, processID); err != nil {
+		return err
+	}
+	
+	ps.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityProcess,
+		Target:   processID,
+		Message:  "Deleted process record " + processID,
+	})
+	ps.sessionManager.Audit(sessionID, "process.delete", processID, "", nil)
 	return nil
 }
 
+// DeleteCompletedProcesses removes every completed process record
+func (ps *ProcessService) DeleteCompletedProcesses(sessionID string) (int, error) {
+	removed, err := ps.sessionManager.RemoveCompletedProcesses(sessionID)
+	if err != nil {
+		return 0, err
+	}
+	
+	ps.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityProcess,
+		Message:  fmt.Sprintf("Deleted %d completed process records", removed),
+	})
+	ps.sessionManager.Audit(sessionID, "process.delete", "", fmt.Sprintf("%d completed process records", removed), nil)
+	return removed, nil
+}
+
 func (ps *ProcessService) ListProcesses(sessionID string) (map[string]*ProcessInfo, error) {
 	return ps.sessionManager.ListProcesses(sessionID)
 }
@@ -346,7 +1033,20 @@ func (p *Process) IsRunning() bool {
 	return !p.Completed
 }
 
-// Terminate kills the process
+// IsPaused reports whether the process was suspended with SIGSTOP
+func (p *Process) IsPaused() bool {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+	return p.Paused && !p.Completed
+}
+
+func (p *Process) setPaused(paused bool) {
+	p.Lock.Lock()
+	p.Paused = paused
+	p.Lock.Unlock()
+}
+
+// Terminate kills the process and every process in its group
 func (p *Process) Terminate() error {
 	p.Lock.Lock()
 	if p.Completed {
@@ -356,11 +1056,45 @@ func (p *Process) Terminate() error {
 	p.Lock.Unlock()
 	
 	if p.Cmd != nil && p.Cmd.Process != nil {
-		return p.Cmd.Process.Kill()
+		return signalProcessTree(p.Cmd.Process.Pid, syscall.SIGKILL)
 	}
 	return nil
 }
 
+// completion builds the callback payload for a finished process
+func (p *Process) completion(sessionID string) *ProcessCompletion {
+	p.Lock.Lock()
+	payload := &ProcessCompletion{
+		Event:           "process.completed",
+		SessionID:       sessionID,
+		ProcessID:       p.ID,
+		Command:         p.Command,
+		PID:             p.PID,
+		ExitCode:        p.ExitCode,
+		StartTime:       p.StartTime,
+		EndTime:         p.EndTime,
+		DurationSeconds: p.EndTime.Sub(p.StartTime).Seconds(),
+	}
+	p.Lock.Unlock()
+	
+	ob := p.OutputBuffer
+	ob.Lock.Lock()
+	payload.StdoutTail = tailLines(ob.Stdout, callbackTailLines)
+	payload.StderrTail = tailLines(ob.Stderr, callbackTailLines)
+	payload.OutputTruncated = ob.Truncated
+	ob.Lock.Unlock()
+	
+	return payload
+}
+
+// tailLines copies the last n lines
+func tailLines(lines []string, n int) []string {
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return append([]string{}, lines...)
+}
+
 // WaitForCompletion waits for the process to complete
 func (p *Process) WaitForCompletion(timeout time.Duration) (int, error) {
 	if p.Completed {
@@ -377,3 +1111,80 @@ func (p *Process) WaitForCompletion(timeout time.Duration) (int, error) {
 		return 0, errors.New("timeout waiting for process to complete")
 	}
 }
+
+// OutputSubscription is a live view of a process's output
+type OutputSubscription struct {
+	Stdout []string
+	Stderr []string
+	// Bytes captured so far by raw mode processes
+	StdoutRaw []byte
+	StderrRaw []byte
+	Events chan OutputEvent
+	buffer *OutputBuffer
+}
+
+// Close stops delivery of events to the subscription
+func (sub *OutputSubscription) Close() {
+	sub.buffer.Lock.Lock()
+	defer sub.buffer.Lock.Unlock()
+	if _, exists := sub.buffer.subscribers[sub.Events]; exists {
+		delete(sub.buffer.subscribers, sub.Events)
+		close(sub.Events)
+	}
+}
+
+// Subscribe snapshots the buffered output and registers for new events
+func (ob *OutputBuffer) Subscribe() *OutputSubscription {
+	ob.Lock.Lock()
+	defer ob.Lock.Unlock()
+	
+	sub := &OutputSubscription{
+		Stdout: append([]string(nil), ob.Stdout...),
+		Stderr: append([]string(nil), ob.Stderr...),
+		StdoutRaw: append([]byte(nil), ob.StdoutRaw...),
+		StderrRaw: append([]byte(nil), ob.StderrRaw...),
+		Events: make(chan OutputEvent, 1000),
+		buffer: ob,
+	}
+	
+	if ob.closed {
+		sub.Events <- OutputEvent{Type: "exit", ExitCode: ob.exitCode, Timestamp: time.Now()}
+		close(sub.Events)
+		return sub
+	}
+	
+	if ob.subscribers == nil {
+		ob.subscribers = make(map[chan OutputEvent]struct{})
+	}
+	ob.subscribers[sub.Events] = struct{}{}
+	return sub
+}
+
+// publish delivers an event to all subscribers; slow subscribers drop events.
+// Callers must hold ob.Lock.
+func (ob *OutputBuffer) publish(event OutputEvent) {
+	for ch := range ob.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// closeSubscribers sends the exit event and closes every subscriber channel
+func (ob *OutputBuffer) closeSubscribers(exitCode int) {
+	ob.Lock.Lock()
+	defer ob.Lock.Unlock()
+	
+	ob.closed = true
+	ob.exitCode = exitCode
+	exitEvent := OutputEvent{Type: "exit", ExitCode: exitCode, Timestamp: time.Now()}
+	for ch := range ob.subscribers {
+		select {
+		case ch <- exitEvent:
+		default:
+		}
+		close(ch)
+	}
+	ob.subscribers = nil
+}
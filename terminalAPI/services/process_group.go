@@ -0,0 +1,34 @@
+package services
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// startInProcessGroup places the command in its own process group so that
+// signals can reach every process it spawns, and makes context cancellation
+// kill the whole group instead of only the direct child
+func startInProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	cmd.Cancel = func() error {
+		return signalProcessTree(cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// Grandchildren may keep the output pipes open after the group is killed
+	cmd.WaitDelay = 2 * time.Second
+}
+
+// signalProcessTree sends a signal to the process group led by pid, falling
+// back to the single process when no such group exists
+func signalProcessTree(pid int, sig syscall.Signal) error {
+	if pid <= 0 {
+		return syscall.ESRCH
+	}
+	if err := syscall.Kill(-pid, sig); err != syscall.ESRCH {
+		return err
+	}
+	return syscall.Kill(pid, sig)
+}
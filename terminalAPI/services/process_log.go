@@ -0,0 +1,237 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Size at which a process log rotates automatically, and how many rotated
+// files are kept
+const (
+	maxLogFileBytes = 10 * 1024 * 1024
+	maxRotatedLogs  = 5
+)
+
+// ErrLogNotFound is returned for process logs a session does not have
+var ErrLogNotFound = errors.New("log not found")
+
+// LogFile describes a process log on disk
+type LogFile struct {
+	Name      string    `json:"name"`
+	ProcessID string    `json:"processId"`
+	Command   string    `json:"command,omitempty"`
+	Size      int64     `json:"size"`
+	Modified  time.Time `json:"modified"`
+	Rotated   bool      `json:"rotated"` // An older file moved aside by rotation
+}
+
+// processLogMeta is written next to each log so listings can show the
+// command after a restart
+type processLogMeta struct {
+	ProcessID string    `json:"processId"`
+	SessionID string    `json:"sessionId"`
+	Command   string    `json:"command"`
+	StartTime time.Time `json:"startTime"`
+}
+
+// processLog tees a process's output, in arrival order, to a file that
+// rotates by size or on request
+type processLog struct {
+	path  string
+	file  *os.File
+	size  int64
+	mutex sync.Mutex
+}
+
+// defaultLogDir returns the directory holding session log directories
+func defaultLogDir() string {
+	if dir := os.Getenv("TERMINAL_LOG_DIR"); dir != "" {
+		return dir
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".osai", "logs")
+	}
+	return filepath.Join(os.TempDir(), "osai-logs")
+}
+
+// openProcessLog creates the log file and metadata for a process
+func openProcessLog(dir string, meta processLogMeta) (*processLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	metaData, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, meta.ProcessID+".json"), metaData, 0644); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, meta.ProcessID+".log")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &processLog{path: path, file: file}, nil
+}
+
+// Write appends output, rotating first if the file has grown too large
+func (l *processLog) Write(data []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return 0, errors.New("log is closed")
+	}
+	if l.size+int64(len(data)) > maxLogFileBytes && l.size > 0 {
+		if err := l.rotateLocked(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	return n, err
+}
+
+// Rotate moves the current file to .1, shifting older files up
+func (l *processLog) Rotate() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.rotateLocked()
+}
+
+func (l *processLog) rotateLocked() error {
+	if l.file != nil {
+		l.file.Close()
+	}
+
+	os.Remove(fmt.Sprintf("%s.%d", l.path, maxRotatedLogs))
+	for i := maxRotatedLogs - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		l.file = nil
+		return err
+	}
+	l.file = file
+	l.size = 0
+	return nil
+}
+
+// Close flushes and closes the file; later writes fail
+func (l *processLog) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// sessionLogDir returns the directory for a session's process logs
+func (ps *ProcessService) sessionLogDir(sessionID string) string {
+	return filepath.Join(ps.logDir, sessionID)
+}
+
+// ListLogs returns the process logs of a session, newest first
+func (ps *ProcessService) ListLogs(sessionID string) ([]LogFile, error) {
+	if _, err := ps.sessionManager.GetSession(sessionID); err != nil {
+		return nil, err
+	}
+
+	dir := ps.sessionLogDir(sessionID)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []LogFile{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	commands := make(map[string]string)
+	logs := []LogFile{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, ".json") {
+			var meta processLogMeta
+			if data, err := os.ReadFile(filepath.Join(dir, name)); err == nil && json.Unmarshal(data, &meta) == nil {
+				commands[meta.ProcessID] = meta.Command
+			}
+			continue
+		}
+
+		idx := strings.Index(name, ".log")
+		if idx < 0 {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		logs = append(logs, LogFile{
+			Name:      name,
+			ProcessID: name[:idx],
+			Size:      info.Size(),
+			Modified:  info.ModTime(),
+			Rotated:   !strings.HasSuffix(name, ".log"),
+		})
+	}
+
+	for i := range logs {
+		logs[i].Command = commands[logs[i].ProcessID]
+	}
+	sort.Slice(logs, func(i, j int) bool {
+		return logs[i].Modified.After(logs[j].Modified)
+	})
+	return logs, nil
+}
+
+// GetLogPath resolves a log file name within the session's log directory
+func (ps *ProcessService) GetLogPath(sessionID string, name string) (string, error) {
+	if _, err := ps.sessionManager.GetSession(sessionID); err != nil {
+		return "", err
+	}
+
+	if name != filepath.Base(name) || !strings.Contains(name, ".log") {
+		return "", fmt.Errorf("invalid log name: %s", name)
+	}
+
+	path := filepath.Join(ps.sessionLogDir(sessionID), name)
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("%w: %s", ErrLogNotFound, name)
+	}
+	return path, nil
+}
+
+// RotateLog rotates the log of a process that is writing one
+func (ps *ProcessService) RotateLog(sessionID string, processID string) error {
+	process, err := ps.sessionManager.GetProcess(sessionID, processID)
+	if err != nil {
+		return err
+	}
+	if process.log == nil {
+		return errors.New("process is not logging to a file")
+	}
+
+	if err := process.log.Rotate(); err != nil {
+		return err
+	}
+
+	ps.sessionManager.Audit(sessionID, "process.log.rotate", processID, "", nil)
+	return nil
+}
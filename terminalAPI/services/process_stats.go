@@ -0,0 +1,83 @@
+package services
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// clockTicksPerSecond is the kernel USER_HZ used for /proc CPU times
+const clockTicksPerSecond = 100
+
+// ProcessStats describes the resource usage of a managed process
+type ProcessStats struct {
+	ID             string  `json:"id"`
+	PID            int     `json:"pid"`
+	IsRunning      bool    `json:"isRunning"`
+	ElapsedSeconds float64 `json:"elapsedSeconds"`
+	CPUSeconds     float64 `json:"cpuSeconds"`
+	CPUPercent     float64 `json:"cpuPercent"` // Average over the process lifetime
+	MemoryRSS      int64   `json:"memoryRSS"`  // Resident set size in bytes (peak once completed)
+	Source         string  `json:"source"`     // "proc" while running, "rusage" once completed
+}
+
+// GetProcessStats samples the resource usage of a process
+func (ps *ProcessService) GetProcessStats(sessionID string, processID string) (*ProcessStats, error) {
+	process, err := ps.sessionManager.GetProcess(sessionID, processID)
+	if err != nil {
+		return nil, err
+	}
+
+	return process.Stats()
+}
+
+// Stats reads live usage from /proc while the process runs and falls back
+// to the rusage recorded by Wait once it has completed
+func (p *Process) Stats() (*ProcessStats, error) {
+	p.Lock.Lock()
+	completed := p.Completed
+	endTime := p.EndTime
+	p.Lock.Unlock()
+
+	stats := &ProcessStats{
+		ID:        p.ID,
+		PID:       p.PID,
+		IsRunning: !completed,
+	}
+
+	if completed {
+		stats.ElapsedSeconds = endTime.Sub(p.StartTime).Seconds()
+		stats.Source = "rusage"
+		if p.Cmd != nil && p.Cmd.ProcessState != nil {
+			stats.CPUSeconds = (p.Cmd.ProcessState.UserTime() + p.Cmd.ProcessState.SystemTime()).Seconds()
+			if rusage, ok := p.Cmd.ProcessState.SysUsage().(*syscall.Rusage); ok {
+				// Maxrss is reported in kilobytes on Linux
+				stats.MemoryRSS = rusage.Maxrss * 1024
+			}
+		}
+	} else {
+		stats.ElapsedSeconds = time.Since(p.StartTime).Seconds()
+		stats.Source = "proc"
+		cpuSeconds, rss, err := readProcStat(p.PID)
+		if err != nil {
+			return nil, err
+		}
+		stats.CPUSeconds = cpuSeconds
+		stats.MemoryRSS = rss
+	}
+
+	if stats.ElapsedSeconds > 0 {
+		stats.CPUPercent = stats.CPUSeconds / stats.ElapsedSeconds * 100
+	}
+
+	return stats, nil
+}
+
+// readProcStat returns the CPU time and resident memory of a pid from /proc
+func readProcStat(pid int) (float64, int64, error) {
+	stat, err := parseProcStat(pid)
+	if err != nil {
+		return 0, 0, err
+	}
+	return stat.cpuTicks / clockTicksPerSecond, stat.rssPages * int64(os.Getpagesize()), nil
+}
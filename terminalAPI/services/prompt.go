@@ -0,0 +1,138 @@
+package services
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// promptIdleTimeout is how long a process must be silent after prompt-like
+// output before it is reported as waiting for input
+const promptIdleTimeout = 2 * time.Second
+
+// promptPatterns recognises common interactive prompts wherever they appear
+// in the last line of output
+var promptPatterns = regexp.MustCompile(`(?i)(\[y/n\]|\(y/n\)|\[yes/no\]|\(yes/no\)|password|passphrase|press (any key|enter|return)|\(y\)es|continue\?)`)
+
+// promptDetector guesses whether a process is stalled on a prompt from the
+// shape of its latest output and how long it has been quiet
+type promptDetector struct {
+	partial    string // Output after the last newline
+	lastLine   string // Last complete line
+	lastOutput time.Time
+	waiting    bool
+	prompt     string
+	mutex      sync.Mutex
+}
+
+func newPromptDetector() *promptDetector {
+	return &promptDetector{lastOutput: time.Now()}
+}
+
+// feed records new output from either stream
+func (pd *promptDetector) feed(data []byte) {
+	pd.mutex.Lock()
+	defer pd.mutex.Unlock()
+
+	pd.lastOutput = time.Now()
+	pd.waiting = false
+
+	if idx := bytes.LastIndexByte(data, '\n'); idx >= 0 {
+		lines := strings.Split(pd.partial+string(data[:idx]), "\n")
+		pd.lastLine = strings.TrimSuffix(lines[len(lines)-1], "\r")
+		pd.partial = string(data[idx+1:])
+	} else {
+		pd.partial += string(data)
+	}
+	if len(pd.partial) > 256 {
+		pd.partial = pd.partial[len(pd.partial)-256:]
+	}
+}
+
+// inputSent clears the waiting state once the process has been answered
+func (pd *promptDetector) inputSent() {
+	pd.mutex.Lock()
+	defer pd.mutex.Unlock()
+	pd.waiting = false
+	pd.lastOutput = time.Now()
+}
+
+// check updates the waiting state and reports the prompt text when the
+// process has just started waiting
+func (pd *promptDetector) check() (string, bool) {
+	pd.mutex.Lock()
+	defer pd.mutex.Unlock()
+
+	if pd.waiting || time.Since(pd.lastOutput) < promptIdleTimeout {
+		return "", false
+	}
+
+	prompt := ""
+	if looksLikePrompt(pd.partial, true) {
+		prompt = pd.partial
+	} else if pd.partial == "" && looksLikePrompt(pd.lastLine, false) {
+		prompt = pd.lastLine
+	}
+	if prompt == "" {
+		return "", false
+	}
+
+	pd.waiting = true
+	pd.prompt = strings.TrimSpace(prompt)
+	return pd.prompt, true
+}
+
+// Waiting reports whether the process appears to be waiting for input
+func (pd *promptDetector) Waiting() (bool, string) {
+	pd.mutex.Lock()
+	defer pd.mutex.Unlock()
+	return pd.waiting, pd.prompt
+}
+
+// looksLikePrompt matches known prompt phrases, or for output not ending in a
+// newline, a trailing ?, :, > or similar prompt character
+func looksLikePrompt(text string, partial bool) bool {
+	text = strings.TrimRight(text, " \t\r")
+	if text == "" {
+		return false
+	}
+	if promptPatterns.MatchString(text) {
+		return true
+	}
+	return partial && strings.ContainsRune("?:>$#", rune(text[len(text)-1]))
+}
+
+// watchForPrompts polls the detector until the process exits, flagging it
+// and notifying streaming subscribers when it starts waiting for input
+func (p *Process) watchForPrompts() {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.Done:
+			return
+		case <-ticker.C:
+		}
+
+		if p.IsPaused() {
+			continue
+		}
+		if prompt, started := p.prompts.check(); started {
+			p.OutputBuffer.Lock.Lock()
+			p.OutputBuffer.publish(OutputEvent{Type: "waitingForInput", Line: prompt, Timestamp: time.Now()})
+			p.OutputBuffer.Lock.Unlock()
+		}
+	}
+}
+
+// IsWaitingForInput reports whether the process appears stalled on a prompt
+func (p *Process) IsWaitingForInput() bool {
+	if p.prompts == nil || !p.IsRunning() {
+		return false
+	}
+	waiting, _ := p.prompts.Waiting()
+	return waiting
+}
@@ -0,0 +1,337 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Terminal size written to cast headers when a recording does not set one
+const (
+	defaultCastWidth  = 120
+	defaultCastHeight = 40
+)
+
+// ErrAlreadyRecording is returned when starting a second recording of a session
+var ErrAlreadyRecording = errors.New("session is already being recorded")
+
+// ErrRecordingNotFound is returned for recordings a session does not have
+var ErrRecordingNotFound = errors.New("recording not found")
+
+// RecordingService captures a session's commands and output into asciinema
+// v2 cast files so agent runs can be replayed with `asciinema play`
+type RecordingService struct {
+	sessionManager *SessionManager
+	logDir         string
+	active         map[string]*recording // Keyed by session ID
+	mutex          sync.Mutex
+}
+
+// RecordingRequest starts a recording
+type RecordingRequest struct {
+	Title  string `json:"title,omitempty"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+// RecordingInfo describes an active or finished recording
+type RecordingInfo struct {
+	Name      string     `json:"name"`
+	Title     string     `json:"title,omitempty"`
+	StartTime time.Time  `json:"startTime"`
+	EndTime   *time.Time `json:"endTime,omitempty"`
+	Events    int        `json:"events,omitempty"` // Counted while the recording is active
+	Size      int64      `json:"size"`
+	Active    bool       `json:"active"`
+}
+
+type recording struct {
+	info  RecordingInfo
+	file  *os.File
+	mutex sync.Mutex
+}
+
+// castHeader is the first line of an asciinema v2 file
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+func NewRecordingService(sm *SessionManager) *RecordingService {
+	rs := &RecordingService{
+		sessionManager: sm,
+		logDir:         defaultLogDir(),
+		active:         make(map[string]*recording),
+	}
+	sm.OnTerminalOutput(rs.capture)
+	sm.OnSessionClosed(func(sessionID string) {
+		rs.StopRecording(sessionID)
+	})
+	return rs
+}
+
+// recordingDir returns where a session's cast files are kept
+func (rs *RecordingService) recordingDir(sessionID string) string {
+	return filepath.Join(rs.logDir, sessionID, "recordings")
+}
+
+// StartRecording opens a new cast file for the session
+func (rs *RecordingService) StartRecording(sessionID string, request *RecordingRequest) (*RecordingInfo, error) {
+	session, err := rs.sessionManager.GetSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Width < 0 || request.Height < 0 {
+		return nil, errors.New("width and height must not be negative")
+	}
+
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	if _, exists := rs.active[sessionID]; exists {
+		return nil, ErrAlreadyRecording
+	}
+
+	dir := rs.recordingDir(sessionID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	startTime := time.Now()
+	name := startTime.UTC().Format("20060102-150405.000") + ".cast"
+	file, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	header := castHeader{
+		Version:   2,
+		Width:     request.Width,
+		Height:    request.Height,
+		Timestamp: startTime.Unix(),
+		Title:     request.Title,
+		Env: map[string]string{
+			"SHELL": session.EnvVars["SHELL"],
+			"TERM":  "xterm-256color",
+		},
+	}
+	if header.Width == 0 {
+		header.Width = defaultCastWidth
+	}
+	if header.Height == 0 {
+		header.Height = defaultCastHeight
+	}
+
+	line, err := json.Marshal(header)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	rec := &recording{
+		info: RecordingInfo{
+			Name:      name,
+			Title:     request.Title,
+			StartTime: startTime,
+			Size:      int64(len(line) + 1),
+			Active:    true,
+		},
+		file: file,
+	}
+	rs.active[sessionID] = rec
+
+	rs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityRecording,
+		Target:   name,
+		Message:  "Started recording " + name,
+	})
+	rs.sessionManager.Audit(sessionID, "recording.start", name, "", nil)
+
+	info := rec.snapshot()
+	return &info, nil
+}
+
+// StopRecording closes the session's active cast file
+func (rs *RecordingService) StopRecording(sessionID string) (*RecordingInfo, error) {
+	rs.mutex.Lock()
+	rec, exists := rs.active[sessionID]
+	delete(rs.active, sessionID)
+	rs.mutex.Unlock()
+
+	if !exists {
+		return nil, errors.New("session is not being recorded")
+	}
+
+	rec.mutex.Lock()
+	endTime := time.Now()
+	rec.info.EndTime = &endTime
+	rec.info.Active = false
+	rec.file.Close()
+	rec.file = nil
+	rec.mutex.Unlock()
+
+	rs.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityRecording,
+		Target:   rec.info.Name,
+		Message:  "Stopped recording " + rec.info.Name,
+	})
+	rs.sessionManager.Audit(sessionID, "recording.stop", rec.info.Name, "", nil)
+
+	info := rec.snapshot()
+	return &info, nil
+}
+
+// GetRecording returns the session's active recording, if any
+func (rs *RecordingService) GetRecording(sessionID string) (*RecordingInfo, error) {
+	if _, err := rs.sessionManager.GetSession(sessionID); err != nil {
+		return nil, err
+	}
+
+	rs.mutex.Lock()
+	rec, exists := rs.active[sessionID]
+	rs.mutex.Unlock()
+
+	if !exists {
+		return nil, errors.New("session is not being recorded")
+	}
+	info := rec.snapshot()
+	return &info, nil
+}
+
+// ListRecordings returns the cast files of a session, newest first
+func (rs *RecordingService) ListRecordings(sessionID string) ([]RecordingInfo, error) {
+	if _, err := rs.sessionManager.GetSession(sessionID); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(rs.recordingDir(sessionID))
+	if os.IsNotExist(err) {
+		return []RecordingInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rs.mutex.Lock()
+	active := rs.active[sessionID]
+	rs.mutex.Unlock()
+
+	recordings := []RecordingInfo{}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".cast") {
+			continue
+		}
+		if active != nil && active.info.Name == entry.Name() {
+			recordings = append(recordings, active.snapshot())
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		modified := info.ModTime()
+		recording := RecordingInfo{
+			Name:    entry.Name(),
+			EndTime: &modified,
+			Size:    info.Size(),
+		}
+		if header, err := readCastHeader(filepath.Join(rs.recordingDir(sessionID), entry.Name())); err == nil {
+			recording.Title = header.Title
+			recording.StartTime = time.Unix(header.Timestamp, 0)
+		}
+		recordings = append(recordings, recording)
+	}
+
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].Name > recordings[j].Name
+	})
+	return recordings, nil
+}
+
+// readCastHeader reads the header line of a cast file
+func readCastHeader(path string) (*castHeader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var header castHeader
+	if err := json.NewDecoder(file).Decode(&header); err != nil {
+		return nil, err
+	}
+	return &header, nil
+}
+
+// GetRecordingPath resolves a cast file name within the session's recordings
+func (rs *RecordingService) GetRecordingPath(sessionID string, name string) (string, error) {
+	if _, err := rs.sessionManager.GetSession(sessionID); err != nil {
+		return "", err
+	}
+
+	if name != filepath.Base(name) || !strings.HasSuffix(name, ".cast") {
+		return "", fmt.Errorf("invalid recording name: %s", name)
+	}
+
+	path := filepath.Join(rs.recordingDir(sessionID), name)
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("%w: %s", ErrRecordingNotFound, name)
+	}
+	return path, nil
+}
+
+// capture appends terminal output to the session's active recording
+func (rs *RecordingService) capture(sessionID string, data []byte) {
+	rs.mutex.Lock()
+	rec, exists := rs.active[sessionID]
+	rs.mutex.Unlock()
+
+	if exists {
+		rec.write(data)
+	}
+}
+
+// write appends an output event. Line feeds become CRLF as a terminal would
+// show them.
+func (rec *recording) write(data []byte) {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+
+	if rec.file == nil {
+		return
+	}
+
+	elapsed := time.Since(rec.info.StartTime).Seconds()
+	text := strings.ReplaceAll(string(bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))), "\n", "\r\n")
+	line, err := json.Marshal([]interface{}{elapsed, "o", text})
+	if err != nil {
+		return
+	}
+
+	n, _ := rec.file.Write(append(line, '\n'))
+	rec.info.Size += int64(n)
+	rec.info.Events++
+}
+
+func (rec *recording) snapshot() RecordingInfo {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	return rec.info
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os/exec"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrRemoteUnsupported is returned for operations that need the working
+// directory on this host, such as persistent shells, in remote sessions
+var ErrRemoteUnsupported = errors.New("not supported for remote sessions")
+
+// ErrInvalidRemote is returned for remote configurations that cannot be used
+var ErrInvalidRemote = errors.New("invalid remote configuration")
+
+// ErrRemoteForbidden is returned when a key that is not an admin key asks for
+// a remote session, which would reach other machines with the server's own
+// SSH keys and agent
+var ErrRemoteForbidden = errors.New("only admin keys may create remote sessions")
+
+// remoteConnectTimeout bounds how long ssh waits for the remote machine
+const remoteConnectTimeout = 10
+
+// remoteHostPattern matches host names and addresses, and user names, so
+// neither can be taken for an ssh option
+var (
+	remoteHostPattern = regexp.MustCompile(`^[A-Za-z0-9_.:\[\]%-]+$`)
+	remoteUserPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+)
+
+// RemoteConfig describes the machine a remote session's working directory
+// lives on. Commands run there over SSH with the ssh CLI, authenticated by
+// key, and the host key must already be known: remote sessions never trust
+// a host on first use.
+type RemoteConfig struct {
+	Host           string `json:"host"`
+	Port           int    `json:"port,omitempty"`           // Defaults to 22
+	User           string `json:"user"`
+	IdentityFile   string `json:"identityFile,omitempty"`   // Private key on this server; ssh's own keys and agent otherwise
+	KnownHostsFile string `json:"knownHostsFile,omitempty"` // Defaults to ~/.ssh/known_hosts
+	Shell          string `json:"shell,omitempty"`          // Shell on the remote machine, defaults to /bin/sh
+}
+
+// RemoteRunner runs session commands on other machines through the ssh
+// CLI, alongside CommandRunner for host execution
+type RemoteRunner struct {
+	sshPath string
+}
+
+// NewRemoteRunner creates a new remote runner instance
+func NewRemoteRunner() *RemoteRunner {
+	return &RemoteRunner{sshPath: "ssh"}
+}
+
+// ApplyDefaults fills unset remote options and validates the rest
+func (cfg *RemoteConfig) ApplyDefaults() error {
+	if !remoteHostPattern.MatchString(cfg.Host) || strings.HasPrefix(cfg.Host, "-") {
+		return fmt.Errorf("%w: remote host is required and must be a host name or address", ErrInvalidRemote)
+	}
+	if !remoteUserPattern.MatchString(cfg.User) {
+		return fmt.Errorf("%w: remote user is required and must be a user name", ErrInvalidRemote)
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 22
+	}
+	if cfg.Port < 1 || cfg.Port > 65535 {
+		return fmt.Errorf("%w: invalid remote port %d", ErrInvalidRemote, cfg.Port)
+	}
+	for _, file := range []string{cfg.IdentityFile, cfg.KnownHostsFile} {
+		if file != "" && !strings.HasPrefix(file, "/") {
+			return fmt.Errorf("%w: remote key files must be absolute paths: %s", ErrInvalidRemote, file)
+		}
+	}
+	if cfg.Shell == "" {
+		cfg.Shell = "/bin/sh"
+	}
+	if !strings.HasPrefix(cfg.Shell, "/") {
+		return fmt.Errorf("%w: remote shell must be an absolute path: %s", ErrInvalidRemote, cfg.Shell)
+	}
+	return nil
+}
+
+// Target names the remote machine as user@host:port
+func (cfg *RemoteConfig) Target() string {
+	return cfg.User + "@" + cfg.Host + ":" + strconv.Itoa(cfg.Port)
+}
+
+// IsAvailable reports whether the ssh CLI can be found
+func (rr *RemoteRunner) IsAvailable() bool {
+	_, err := exec.LookPath(rr.sshPath)
+	return err == nil
+}
+
+// ExecArgs returns the ssh CLI arguments that run a command line on the
+// remote machine, in the given remote directory, with the given environment.
+// ssh passes the remote command to the user's login shell as one string, so
+// every part of it is quoted. Variable values would be visible in the
+// process list as arguments, so they are returned as a script to write to
+// the command's stdin ahead of anything else: its length on one line, then
+// export statements, which the remote side reads and evaluates before the
+// command starts.
+func (rr *RemoteRunner) ExecArgs(cfg *RemoteConfig, workDir string, commandLine string, env map[string]string) ([]string, []byte) {
+	args := []string{
+		"-T",
+		"-o", "BatchMode=yes",
+		"-o", "StrictHostKeyChecking=yes",
+		"-o", "ConnectTimeout=" + strconv.Itoa(remoteConnectTimeout),
+		"-o", "ServerAliveInterval=15",
+		"-p", strconv.Itoa(cfg.Port),
+		"-l", cfg.User,
+	}
+	if cfg.IdentityFile != "" {
+		args = append(args, "-i", cfg.IdentityFile, "-o", "IdentitiesOnly=yes")
+	}
+	if cfg.KnownHostsFile != "" {
+		args = append(args, "-o", "UserKnownHostsFile="+cfg.KnownHostsFile)
+	}
+
+	var exports strings.Builder
+	for _, k := range slices.Sorted(maps.Keys(env)) {
+		// The host shell path is meaningless on the remote machine
+		if k == "SHELL" {
+			continue
+		}
+		fmt.Fprintf(&exports, "export %s=%s\n", k, shellQuote(env[k]))
+	}
+
+	run := "exec " + shellQuote(cfg.Shell) + " -c " + shellQuote(commandLine)
+	if exports.Len() == 0 {
+		return append(args, "--", cfg.Host, "cd "+shellQuote(workDir)+" && "+run), nil
+	}
+
+	// dd reads the script byte by byte, so the command's own input is left
+	// on stdin
+	script := "cd " + shellQuote(workDir) +
+		` && IFS= read -r __osai_n && __osai_env=$(dd bs=1 count="$__osai_n" 2>/dev/null) && eval "$__osai_env" && unset __osai_n __osai_env && ` + run
+	stdin := []byte(strconv.Itoa(exports.Len()) + "\n" + exports.String())
+	return append(args, "--", cfg.Host, "exec sh -c "+shellQuote(script)), stdin
+}
+
+// CheckDir verifies that an absolute directory exists on the remote machine
+func (rr *RemoteRunner) CheckDir(cfg *RemoteConfig, dir string) error {
+	if !path.IsAbs(dir) {
+		return errors.New("the working directory of a remote session must be an absolute path")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*remoteConnectTimeout*time.Second)
+	defer cancel()
+
+	args, _ := rr.ExecArgs(cfg, "/", "test -d "+shellQuote(dir), nil)
+	output, err := exec.CommandContext(ctx, rr.sshPath, args...).CombinedOutput()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		return errors.New("directory does not exist")
+	default:
+		// ssh exits with 255 when it cannot connect or authenticate
+		message := strings.TrimSpace(string(output))
+		if message == "" {
+			message = err.Error()
+		}
+		return fmt.Errorf("failed to reach %s: %s", cfg.Target(), message)
+	}
+}
+
+// setRemoteWorkingDirectory sets the working directory of a remote session
+// once it is found on the remote machine. The allowed directories describe
+// this host, so they do not apply.
+func (sm *SessionManager) setRemoteWorkingDirectory(session *Session, dir string) (err error) {
+	defer func() {
+		sm.audit.Record(AuditEntry{Actor: session.Owner, SessionID: session.ID, Operation: "session.cwd", Target: dir}, err)
+	}()
+
+	dir = path.Clean(dir)
+	if err := sm.remoteRunner.CheckDir(session.Remote, dir); err != nil {
+		return err
+	}
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	if current, exists := sm.sessions[session.ID]; !exists || current != session || !session.IsActive {
+		return ErrSessionNotFound
+	}
+	session.WorkingDir = dir
+	sm.extendSession(session, time.Now())
+	sm.addActivity(session, ActivityEntry{
+		Category: ActivitySession,
+		Target:   dir,
+		Message:  "Set working directory to " + dir + " on " + session.Remote.Target(),
+	})
+	sm.emitSessionEvent(SessionWorkingDirChanged, session)
+	return nil
+}
+
+// runsOnHost reports whether a session's commands run directly on this
+// host, rather than in a sandbox container or on a remote machine
+func (session *Session) runsOnHost() bool {
+	return session.Sandbox == nil && session.Remote == nil
+}
+
+// checkRemoteRequest refuses what a remote session's commands cannot do:
+// keep a persistent shell or switch users, which need this host
+func (session *Session) checkRemoteRequest(request *CommandRequest) error {
+	if session.Remote == nil {
+		return nil
+	}
+	if request.Persistent {
+		return fmt.Errorf("persistent mode is %w", ErrRemoteUnsupported)
+	}
+	if request.RunAs != "" {
+		return fmt.Errorf("runAs is %w; set the remote user instead", ErrRemoteUnsupported)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Retry bounds: at most maxRetries extra attempts, waiting
+// DefaultRetryDelay between them unless retryDelay is set
+const (
+	maxRetries        = 10
+	DefaultRetryDelay = 1 * time.Second
+)
+
+// CommandAttempt records one run of a retried command
+type CommandAttempt struct {
+	Attempt       int     `json:"attempt"`
+	ExitCode      int     `json:"exitCode"`
+	Stdout        string  `json:"stdout"`
+	Stderr        string  `json:"stderr"`
+	ExecutionTime float64 `json:"executionTime"` // In seconds
+}
+
+// validateRetries checks the retry options of a request
+func (request *CommandRequest) validateRetries() error {
+	if request.Retries < 0 || request.Retries > maxRetries {
+		return fmt.Errorf("retries must be between 0 and %d", maxRetries)
+	}
+	if request.RetryDelay < 0 {
+		return errors.New("retryDelay must not be negative")
+	}
+	return nil
+}
+
+// shouldRetry reports whether an attempt's exit code calls for another try.
+// Without retryOnExitCodes every failure is retried.
+func (request *CommandRequest) shouldRetry(exitCode int) bool {
+	if exitCode == 0 {
+		return false
+	}
+	if len(request.RetryOnExitCodes) == 0 {
+		return true
+	}
+	for _, code := range request.RetryOnExitCodes {
+		if code == exitCode {
+			return true
+		}
+	}
+	return false
+}
+
+func (request *CommandRequest) retryDelay() time.Duration {
+	if request.RetryDelay > 0 {
+		return time.Duration(request.RetryDelay) * time.Second
+	}
+	return DefaultRetryDelay
+}
+
+// executeWithRetries runs a command until it succeeds, fails with an exit
+// code that is not retried, or runs out of retries. The final attempt is
+// returned with every attempt listed in Attempts.
+func (cs *CommandService) executeWithRetries(session *Session, plan *ExecutionPlan, request *CommandRequest) (*CommandOutput, error) {
+	if request.Retries == 0 {
+		return cs.executeOnce(session, plan, request)
+	}
+
+	var attempts []CommandAttempt
+	for attempt := 1; ; attempt++ {
+		result, err := cs.executeOnce(session, plan, request)
+		if err != nil {
+			return nil, err
+		}
+
+		attempts = append(attempts, CommandAttempt{
+			Attempt:       attempt,
+			ExitCode:      result.ExitCode,
+			Stdout:        result.Stdout,
+			Stderr:        result.Stderr,
+			ExecutionTime: result.ExecutionTime,
+		})
+
+		if attempt > request.Retries || !request.shouldRetry(result.ExitCode) {
+			result.Attempts = attempts
+			return result, nil
+		}
+
+		sessionLog(session.ID).Info("retrying command", LogKeyOperation, "command.execute", "command", request.Command,
+			"exitCode", result.ExitCode, "attempt", attempt+1, "attempts", request.Retries+1)
+		time.Sleep(request.retryDelay())
+	}
+}
@@ -0,0 +1,400 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Number of runs kept in each job's history, and bytes of output kept per run
+const (
+	maxJobHistory   = 20
+	maxJobRunOutput = 4096
+)
+
+// ErrJobNotFound is returned for jobs a session has not scheduled
+var ErrJobNotFound = errors.New("scheduled job not found")
+
+// ScheduledJob is a command run on a cron schedule or once after a delay
+type ScheduledJob struct {
+	ID          string            `json:"id"`
+	SessionID   string            `json:"sessionId"`
+	Name        string            `json:"name,omitempty"`
+	Command     string            `json:"command"`
+	Schedule    string            `json:"schedule,omitempty"` // Cron expression for recurring jobs
+	RunAt       *time.Time        `json:"runAt,omitempty"`    // Activation time of a one-shot job
+	Timeout     int               `json:"timeout,omitempty"`
+	Environment map[string]string `json:"environment,omitempty"`
+	Limits      *ResourceLimits   `json:"limits,omitempty"`
+	RunAs       string            `json:"runAs,omitempty"`
+	Enabled     bool              `json:"enabled"`
+	Running     bool              `json:"running"`
+	Completed   bool              `json:"completed"` // One-shot job that has run
+	CreatedAt   time.Time         `json:"createdAt"`
+	NextRun     *time.Time        `json:"nextRun,omitempty"`
+	LastRun     *time.Time        `json:"lastRun,omitempty"`
+	RunCount    int               `json:"runCount"`
+	History     []JobRun          `json:"history"`
+
+	schedule Schedule
+	stop     chan struct{}
+}
+
+// JobRun records one execution of a scheduled job
+type JobRun struct {
+	StartedAt     time.Time `json:"startedAt"`
+	ExitCode      int       `json:"exitCode"`
+	ExecutionTime float64   `json:"executionTime"`
+	Stdout        string    `json:"stdout"`
+	Stderr        string    `json:"stderr"`
+	Error         string    `json:"error,omitempty"`
+	Skipped       bool      `json:"skipped,omitempty"` // The previous run was still in progress
+}
+
+// ScheduleRequest creates a job. Exactly one of schedule, runAt or
+// delaySeconds must be given.
+type ScheduleRequest struct {
+	Name         string            `json:"name,omitempty"`
+	Command      string            `json:"command"`
+	Schedule     string            `json:"schedule,omitempty"`
+	RunAt        *time.Time        `json:"runAt,omitempty"`
+	DelaySeconds int               `json:"delaySeconds,omitempty"`
+	Timeout      int               `json:"timeout,omitempty"`
+	Environment  map[string]string `json:"environment,omitempty"`
+	Limits       *ResourceLimits   `json:"limits,omitempty"`
+	RunAs        string            `json:"runAs,omitempty"`
+}
+
+// ScheduleUpdateRequest enables or disables a job
+type ScheduleUpdateRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// SchedulerService runs commands on a schedule within a session. Jobs are
+// cancelled when their session is deleted or expires, and scheduled runs do
+// not extend the session's expiry.
+type SchedulerService struct {
+	sessionManager *SessionManager
+	commandService *CommandService
+	jobs           map[string]*ScheduledJob
+	mutex          sync.Mutex
+}
+
+func NewSchedulerService(sm *SessionManager, cs *CommandService) *SchedulerService {
+	ss := &SchedulerService{
+		sessionManager: sm,
+		commandService: cs,
+		jobs:           make(map[string]*ScheduledJob),
+	}
+	sm.OnSessionClosed(ss.cancelSessionJobs)
+	return ss
+}
+
+// CreateJob validates and starts a scheduled job
+func (ss *SchedulerService) CreateJob(sessionID string, request *ScheduleRequest) (*ScheduledJob, error) {
+	if _, err := ss.sessionManager.GetSession(sessionID); err != nil {
+		return nil, err
+	}
+
+	if request.Command == "" {
+		return nil, errors.New("command is required")
+	}
+	if err := request.Limits.Validate(); err != nil {
+		return nil, err
+	}
+
+	job := &ScheduledJob{
+		ID:          uuid.New().String(),
+		SessionID:   sessionID,
+		Name:        request.Name,
+		Command:     request.Command,
+		Timeout:     request.Timeout,
+		Environment: request.Environment,
+		Limits:      request.Limits,
+		RunAs:       request.RunAs,
+		CreatedAt:   time.Now(),
+		History:     []JobRun{},
+	}
+
+	kinds := 0
+	if request.Schedule != "" {
+		schedule, err := ParseSchedule(request.Schedule)
+		if err != nil {
+			return nil, err
+		}
+		if schedule.Next(time.Now()).IsZero() {
+			return nil, fmt.Errorf("schedule never fires: %s", request.Schedule)
+		}
+		job.Schedule = request.Schedule
+		job.schedule = schedule
+		kinds++
+	}
+	if request.RunAt != nil {
+		runAt := *request.RunAt
+		job.RunAt = &runAt
+		kinds++
+	}
+	if request.DelaySeconds > 0 {
+		runAt := time.Now().Add(time.Duration(request.DelaySeconds) * time.Second)
+		job.RunAt = &runAt
+		kinds++
+	}
+	if kinds != 1 {
+		return nil, errors.New("exactly one of schedule, runAt or delaySeconds is required")
+	}
+
+	ss.mutex.Lock()
+	ss.jobs[job.ID] = job
+	ss.startLocked(job)
+	ss.mutex.Unlock()
+
+	ss.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivitySchedule,
+		Target:   job.ID,
+		Message:  fmt.Sprintf("Scheduled job %s: %s", job.ID, job.Command),
+	})
+	ss.sessionManager.Audit(sessionID, "schedule.create", job.ID, ss.sessionManager.redact(job.Command), nil)
+
+	return ss.GetJob(sessionID, job.ID)
+}
+
+// GetJob returns a snapshot of a job and its run history
+func (ss *SchedulerService) GetJob(sessionID string, jobID string) (*ScheduledJob, error) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	job, exists := ss.jobs[jobID]
+	if !exists || job.SessionID != sessionID {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, jobID)
+	}
+	return job.snapshot(), nil
+}
+
+// ListJobs returns the session's jobs, oldest first
+func (ss *SchedulerService) ListJobs(sessionID string) ([]*ScheduledJob, error) {
+	if _, err := ss.sessionManager.GetSession(sessionID); err != nil {
+		return nil, err
+	}
+
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	jobs := make([]*ScheduledJob, 0)
+	for _, job := range ss.jobs {
+		if job.SessionID == sessionID {
+			jobs = append(jobs, job.snapshot())
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+	return jobs, nil
+}
+
+// SetEnabled pauses or resumes a job. Re-enabling a recurring job schedules
+// its next run from now.
+func (ss *SchedulerService) SetEnabled(sessionID string, jobID string, enabled bool) (*ScheduledJob, error) {
+	ss.mutex.Lock()
+	job, exists := ss.jobs[jobID]
+	if !exists || job.SessionID != sessionID {
+		ss.mutex.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, jobID)
+	}
+	if job.Completed && enabled {
+		ss.mutex.Unlock()
+		return nil, errors.New("one-shot job has already run")
+	}
+
+	if enabled && !job.Enabled {
+		ss.startLocked(job)
+	} else if !enabled && job.Enabled {
+		ss.stopLocked(job)
+	}
+	ss.mutex.Unlock()
+
+	ss.sessionManager.Audit(sessionID, "schedule.update", jobID, fmt.Sprintf("enabled=%t", enabled), nil)
+	return ss.GetJob(sessionID, jobID)
+}
+
+// DeleteJob cancels and removes a job
+func (ss *SchedulerService) DeleteJob(sessionID string, jobID string) error {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	job, exists := ss.jobs[jobID]
+	if !exists || job.SessionID != sessionID {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, jobID)
+	}
+
+	ss.stopLocked(job)
+	delete(ss.jobs, jobID)
+
+	ss.sessionManager.Audit(sessionID, "schedule.delete", jobID, "", nil)
+	return nil
+}
+
+// cancelSessionJobs removes every job of a closed session
+func (ss *SchedulerService) cancelSessionJobs(sessionID string) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	for id, job := range ss.jobs {
+		if job.SessionID == sessionID {
+			ss.stopLocked(job)
+			delete(ss.jobs, id)
+			sessionLog(sessionID).Info("cancelled scheduled job", "job", id)
+		}
+	}
+}
+
+// startLocked enables a job, computes its first activation and starts its
+// timer loop
+func (ss *SchedulerService) startLocked(job *ScheduledJob) {
+	var next time.Time
+	if job.schedule != nil {
+		next = job.schedule.Next(time.Now())
+	} else {
+		next = *job.RunAt
+	}
+	if next.IsZero() {
+		return
+	}
+
+	job.Enabled = true
+	job.NextRun = &next
+	job.stop = make(chan struct{})
+	go ss.loop(job, job.stop)
+}
+
+// stopLocked disables a job and ends its timer loop
+func (ss *SchedulerService) stopLocked(job *ScheduledJob) {
+	if job.stop != nil {
+		close(job.stop)
+		job.stop = nil
+	}
+	job.Enabled = false
+	job.NextRun = nil
+}
+
+// loop waits for each activation of a job until it is stopped or, for a
+// one-shot job, has run
+func (ss *SchedulerService) loop(job *ScheduledJob, stop chan struct{}) {
+	ss.mutex.Lock()
+	next := *job.NextRun
+	ss.mutex.Unlock()
+
+	for {
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if job.schedule == nil {
+			ss.mutex.Lock()
+			job.Completed = true
+			job.Enabled = false
+			job.NextRun = nil
+			job.stop = nil
+			ss.mutex.Unlock()
+			ss.run(job)
+			return
+		}
+
+		ss.mutex.Lock()
+		next = job.schedule.Next(time.Now())
+		if next.IsZero() {
+			job.Enabled = false
+			job.NextRun = nil
+			job.stop = nil
+			ss.mutex.Unlock()
+			return
+		}
+		job.NextRun = &next
+		ss.mutex.Unlock()
+
+		// Run recurring jobs in the background so a slow run does not delay
+		// the next activation; overlapping activations are skipped
+		go ss.run(job)
+	}
+}
+
+// run executes one activation of a job and records it in the history
+func (ss *SchedulerService) run(job *ScheduledJob) {
+	startedAt := time.Now()
+
+	ss.mutex.Lock()
+	if job.Running {
+		ss.recordLocked(job, JobRun{StartedAt: startedAt, Skipped: true})
+		ss.mutex.Unlock()
+		return
+	}
+	job.Running = true
+	ss.mutex.Unlock()
+
+	run := JobRun{StartedAt: startedAt}
+
+	session, err := ss.sessionManager.PeekSession(job.SessionID)
+	if err == nil {
+		var output *CommandOutput
+		output, err = ss.commandService.runCommand(session, &CommandRequest{
+			Command:     job.Command,
+			Timeout:     job.Timeout,
+			Environment: job.Environment,
+			Limits:      job.Limits,
+			RunAs:       job.RunAs,
+		})
+		if err == nil {
+			run.ExitCode = output.ExitCode
+			run.ExecutionTime = output.ExecutionTime
+			run.Stdout = tailString(output.Stdout, maxJobRunOutput)
+			run.Stderr = tailString(output.Stderr, maxJobRunOutput)
+		}
+	}
+	if err != nil {
+		run.ExitCode = -1
+		run.Error = err.Error()
+	}
+
+	ss.mutex.Lock()
+	job.Running = false
+	job.LastRun = &startedAt
+	job.RunCount++
+	ss.recordLocked(job, run)
+	ss.mutex.Unlock()
+
+	sessionLog(job.SessionID).Info("scheduled job finished", "job", job.ID, "exitCode", run.ExitCode)
+}
+
+func (ss *SchedulerService) recordLocked(job *ScheduledJob, run JobRun) {
+	job.History = append(job.History, run)
+	if len(job.History) > maxJobHistory {
+		job.History = job.History[len(job.History)-maxJobHistory:]
+	}
+}
+
+// snapshot copies a job so it can be serialized without holding the lock
+func (job *ScheduledJob) snapshot() *ScheduledJob {
+	copied := *job
+	copied.History = append([]JobRun{}, job.History...)
+	if job.NextRun != nil {
+		next := *job.NextRun
+		copied.NextRun = &next
+	}
+	copied.stop = nil
+	return &copied
+}
+
+// tailString keeps the last max bytes of s
+func tailString(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[len(s)-max:]
+}
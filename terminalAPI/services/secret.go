@@ -0,0 +1,442 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mask that replaces secret values in output, logs and history
+const secretMask = "********"
+
+// Values shorter than this are not redacted, since masking every occurrence
+// of a two-letter string would mangle unrelated output
+const minRedactLength = 4
+
+// ErrSecretNotFound is returned for unknown secret names
+var ErrSecretNotFound = errors.New("secret not found")
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SecretService stores secrets write-only and encrypted at rest. Commands
+// reference them by name and receive them as environment variables, and
+// their values are masked wherever the server reports output.
+type SecretService struct {
+	sessionManager *SessionManager
+	path           string
+	gcm            cipher.AEAD
+	secrets        map[string]*storedSecret
+	values         map[string]string // Decrypted values, kept for injection and redaction
+	redactor       *strings.Replacer
+	redactValues   []string // Masked values, longest first
+	audit          *AuditService
+	mutex          sync.RWMutex
+}
+
+// SecretInfo describes a secret without revealing its value
+type SecretInfo struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type storedSecret struct {
+	SecretInfo
+	Ciphertext string `json:"ciphertext"` // Base64 of nonce followed by the sealed value
+}
+
+// secretPaths returns the secrets file and the key file used when
+// TERMINAL_SECRET_KEY is not set
+func secretPaths() (string, string) {
+	dir := filepath.Dir(defaultLogDir())
+	path := os.Getenv("TERMINAL_SECRETS_FILE")
+	if path == "" {
+		path = filepath.Join(dir, "secrets.json")
+	}
+	return path, filepath.Join(dir, "secret.key")
+}
+
+// secretKey derives the encryption key from TERMINAL_SECRET_KEY, or reads
+// a random key from keyPath, creating it on first use
+func secretKey(keyPath string) ([]byte, error) {
+	if passphrase := os.Getenv("TERMINAL_SECRET_KEY"); passphrase != "" {
+		key := sha256.Sum256([]byte(passphrase))
+		return key[:], nil
+	}
+
+	key, err := os.ReadFile(keyPath)
+	if err == nil {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("%s must hold a 32 byte key", keyPath)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyPath, key, 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// NewSecretService loads the stored secrets and registers the service with
+// the session manager for injection and redaction
+func NewSecretService(sm *SessionManager) (*SecretService, error) {
+	path, keyPath := secretPaths()
+	key, err := secretKey(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load secret key: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	ss := &SecretService{
+		sessionManager: sm,
+		audit:          sm.AuditService(),
+		path:           path,
+		gcm:            gcm,
+		secrets:        make(map[string]*storedSecret),
+		values:         make(map[string]string),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &ss.secrets); err != nil {
+			return nil, fmt.Errorf("invalid secrets file %s: %v", path, err)
+		}
+		for name, secret := range ss.secrets {
+			value, err := ss.decrypt(secret.Ciphertext)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt secret %s, was the key changed? %v", name, err)
+			}
+			ss.values[name] = value
+		}
+	}
+	ss.rebuildRedactor()
+
+	sm.mutex.Lock()
+	sm.secrets = ss
+	sm.mutex.Unlock()
+	return ss, nil
+}
+
+func (ss *SecretService) encrypt(value string) (string, error) {
+	nonce := make([]byte, ss.gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := ss.gcm.Seal(nonce, nonce, []byte(value), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (ss *SecretService) decrypt(ciphertext string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < ss.gcm.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+	nonce, sealed := sealed[:ss.gcm.NonceSize()], sealed[ss.gcm.NonceSize():]
+	value, err := ss.gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// saveLocked writes the encrypted secrets; must be called with mutex held
+func (ss *SecretService) saveLocked() error {
+	data, err := json.MarshalIndent(ss.secrets, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ss.path), 0700); err != nil {
+		return err
+	}
+	tmp := ss.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ss.path)
+}
+
+// rebuildRedactor prepares the replacer masking every secret value, longest
+// first so a value containing another is masked whole
+func (ss *SecretService) rebuildRedactor() {
+	values := make([]string, 0, len(ss.values))
+	for _, value := range ss.values {
+		if len(value) >= minRedactLength {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		ss.redactor = nil
+		ss.redactValues = nil
+		return
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+	ss.redactValues = values
+	pairs := make([]string, 0, 2*len(values))
+	for _, value := range values {
+		pairs = append(pairs, value, secretMask)
+	}
+	ss.redactor = strings.NewReplacer(pairs...)
+}
+
+// SetSecret stores or replaces a secret
+func (ss *SecretService) SetSecret(name string, value string) (*SecretInfo, error) {
+	if !envNamePattern.MatchString(name) {
+		return nil, errors.New("secret name must be a valid environment variable name")
+	}
+	if value == "" {
+		return nil, errors.New("secret value must not be empty")
+	}
+
+	ciphertext, err := ss.encrypt(value)
+	if err != nil {
+		return nil, err
+	}
+
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	now := time.Now()
+	secret := &storedSecret{
+		SecretInfo: SecretInfo{Name: name, CreatedAt: now, UpdatedAt: now},
+		Ciphertext: ciphertext,
+	}
+	previous, existed := ss.secrets[name]
+	if existed {
+		secret.CreatedAt = previous.CreatedAt
+	}
+
+	ss.secrets[name] = secret
+	if err := ss.saveLocked(); err != nil {
+		if existed {
+			ss.secrets[name] = previous
+		} else {
+			delete(ss.secrets, name)
+		}
+		return nil, fmt.Errorf("failed to save secrets: %v", err)
+	}
+	ss.values[name] = value
+	ss.rebuildRedactor()
+
+	ss.audit.Record(AuditEntry{Operation: "secret.set", Target: name}, nil)
+	info := secret.SecretInfo
+	return &info, nil
+}
+
+// ListSecrets returns the names and times of all secrets
+func (ss *SecretService) ListSecrets() []SecretInfo {
+	ss.mutex.RLock()
+	defer ss.mutex.RUnlock()
+
+	secrets := make([]SecretInfo, 0, len(ss.secrets))
+	for _, secret := range ss.secrets {
+		secrets = append(secrets, secret.SecretInfo)
+	}
+	sort.Slice(secrets, func(i, j int) bool {
+		return secrets[i].Name < secrets[j].Name
+	})
+	return secrets
+}
+
+// DeleteSecret removes a secret. Its value stays masked until the server
+// restarts, so output produced while it was in use is not revealed.
+func (ss *SecretService) DeleteSecret(name string) error {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	secret, exists := ss.secrets[name]
+	if !exists {
+		return ErrSecretNotFound
+	}
+
+	delete(ss.secrets, name)
+	if err := ss.saveLocked(); err != nil {
+		ss.secrets[name] = secret
+		return fmt.Errorf("failed to save secrets: %v", err)
+	}
+
+	ss.audit.Record(AuditEntry{Operation: "secret.delete", Target: name}, nil)
+	return nil
+}
+
+// maskedValues returns the values Redact masks, longest first
+func (ss *SecretService) maskedValues() []string {
+	ss.mutex.RLock()
+	defer ss.mutex.RUnlock()
+	return ss.redactValues
+}
+
+// resolve returns the values of the named secrets
+func (ss *SecretService) resolve(names []string) (map[string]string, error) {
+	ss.mutex.RLock()
+	defer ss.mutex.RUnlock()
+
+	values := make(map[string]string, len(names))
+	for _, name := range names {
+		if _, exists := ss.secrets[name]; !exists {
+			return nil, fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+		}
+		values[name] = ss.values[name]
+	}
+	return values, nil
+}
+
+// Redact masks every known secret value in text
+func (ss *SecretService) Redact(text string) string {
+	ss.mutex.RLock()
+	redactor := ss.redactor
+	ss.mutex.RUnlock()
+
+	if redactor == nil {
+		return text
+	}
+	return redactor.Replace(text)
+}
+
+// streamRedactor masks secrets in output that arrives in chunks, such as
+// reads from a process pipe. Bytes at the end of a chunk that could begin a
+// secret are held back until the next chunk shows whether they do, so a
+// secret split across two reads is still masked whole.
+type streamRedactor struct {
+	sm      *SessionManager
+	pending []byte
+}
+
+func (sm *SessionManager) newStreamRedactor() *streamRedactor {
+	return &streamRedactor{sm: sm}
+}
+
+// Write returns the masked output that can be released after data, which
+// may be empty while a possible secret is held back
+func (r *streamRedactor) Write(data []byte) []byte {
+	buf := append(r.pending, data...)
+	cut := redactCut(buf, r.sm.redactValues())
+	r.pending = append([]byte(nil), buf[cut:]...)
+	if cut == 0 {
+		return nil
+	}
+	return []byte(r.sm.redact(string(buf[:cut])))
+}
+
+// Flush returns whatever is still held back, masked, once the stream ends
+func (r *streamRedactor) Flush() []byte {
+	if len(r.pending) == 0 {
+		return nil
+	}
+	out := []byte(r.sm.redact(string(r.pending)))
+	r.pending = nil
+	return out
+}
+
+// redactCut returns how much of buf can be masked and released: all of it
+// except a tail that is the start of a secret, moved forward past any
+// complete secret the cut would otherwise split
+func redactCut(buf []byte, values []string) int {
+	cut := len(buf)
+	for _, value := range values {
+		for i := max(0, len(buf)-len(value)+1); i < cut; i++ {
+			if strings.HasPrefix(value, string(buf[i:])) {
+				cut = i
+				break
+			}
+		}
+	}
+
+	for moved := true; moved; {
+		moved = false
+		for _, value := range values {
+			from := max(0, cut-len(value)+1)
+			to := min(len(buf), cut+len(value)-1)
+			if from >= to {
+				continue
+			}
+			if idx := strings.Index(string(buf[from:to]), value); idx >= 0 && from+idx < cut {
+				cut = from + idx + len(value)
+				moved = true
+			}
+		}
+	}
+	return cut
+}
+
+// withSecrets returns the request with the secrets it references merged into
+// its environment. Secrets take precedence over variables of the same name.
+func (sm *SessionManager) withSecrets(request *CommandRequest) (*CommandRequest, error) {
+	if len(request.Secrets) == 0 {
+		return request, nil
+	}
+	values, err := sm.resolveSecrets(request.Secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := *request
+	resolved.Environment = make(map[string]string, len(request.Environment)+len(values))
+	for k, v := range request.Environment {
+		resolved.Environment[k] = v
+	}
+	for k, v := range values {
+		resolved.Environment[k] = v
+	}
+	return &resolved, nil
+}
+
+// redactOutput masks secret values in a command's output
+func (sm *SessionManager) redactOutput(result *CommandOutput) {
+	result.Command = sm.redact(result.Command)
+	result.Stdout = sm.redact(result.Stdout)
+	result.Stderr = sm.redact(result.Stderr)
+}
+
+// redactPlan returns a copy of a plan safe to show to clients
+func (sm *SessionManager) redactPlan(plan *ExecutionPlan) *ExecutionPlan {
+	redacted := *plan
+	redacted.CommandLine = sm.redact(plan.CommandLine)
+	redacted.Args = make([]string, len(plan.Args))
+	for i, arg := range plan.Args {
+		redacted.Args[i] = sm.redact(arg)
+	}
+	redacted.Environment = make(map[string]string, len(plan.Environment))
+	for k, v := range plan.Environment {
+		redacted.Environment[k] = sm.redact(v)
+	}
+	return &redacted
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// chunkReader returns one chunk per Read, like a pipe the process writes to
+// in separate calls
+type chunkReader struct {
+	chunks []string
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.chunks[0])
+	r.chunks = r.chunks[1:]
+	return n, nil
+}
+
+func newRedactingSessionManager(values ...string) *SessionManager {
+	ss := &SecretService{values: make(map[string]string)}
+	for i, value := range values {
+		ss.values[string(rune('A'+i))] = value
+	}
+	ss.rebuildRedactor()
+	return &SessionManager{secrets: ss}
+}
+
+func TestStreamRedactorMasksSplitSecrets(t *testing.T) {
+	sm := newRedactingSessionManager("hunter22", "s3cr3t-token")
+
+	tests := []struct {
+		name   string
+		chunks []string
+		want   string
+	}{
+		{"whole", []string{"pw=hunter22\n"}, "pw=********\n"},
+		{"split in two", []string{"pw=hun", "ter22\n"}, "pw=********\n"},
+		{"split in three", []string{"s3c", "r3t-", "token done"}, "******** done"},
+		{"split one byte each", strings.Split("x hunter22 y", ""), "x ******** y"},
+		{"ends in a prefix", []string{"pw=hunt"}, "pw=hunt"},
+		{"prefix then other text", []string{"pw=hunt", "ing\n"}, "pw=hunting\n"},
+		{"adjacent secrets", []string{"hunter2", "2hunter", "22"}, "****************"},
+		{"no secrets", []string{"plain ", "output\n"}, "plain output\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			redactor := sm.newStreamRedactor()
+			var out strings.Builder
+			for _, chunk := range test.chunks {
+				out.Write(redactor.Write([]byte(chunk)))
+			}
+			out.Write(redactor.Flush())
+			if out.String() != test.want {
+				t.Errorf("got %q, want %q", out.String(), test.want)
+			}
+		})
+	}
+}
+
+func TestStreamRedactorReleasesOutputWithoutSecretPrefix(t *testing.T) {
+	sm := newRedactingSessionManager("hunter22")
+	redactor := sm.newStreamRedactor()
+
+	// A prompt that cannot begin a secret must not wait for more output
+	if got := string(redactor.Write([]byte("Password: "))); got != "Password: " {
+		t.Errorf("got %q, want the prompt released at once", got)
+	}
+	if got := string(redactor.Write([]byte("ok hun"))); got != "ok " {
+		t.Errorf("got %q, want the possible secret held back", got)
+	}
+}
+
+func TestCollectOutputMasksSecretSplitAcrossReads(t *testing.T) {
+	sm := newRedactingSessionManager("hunter22")
+	ps := &ProcessService{sessionManager: sm}
+
+	for _, raw := range []bool{false, true} {
+		ob := &OutputBuffer{MaxLines: 100, Raw: raw, StdoutChan: make(chan string, 10)}
+		var seen strings.Builder
+		onData := sm.newStreamRedactor()
+		pipe := &chunkReader{chunks: []string{"token: hun", "ter22\n"}}
+		ps.collectOutput(pipe, ob.StdoutChan, &ob.Stdout, ob, "stdout", func(data []byte) {
+			if data == nil {
+				seen.Write(onData.Flush())
+				return
+			}
+			seen.Write(onData.Write(data))
+		})
+
+		stored := strings.Join(ob.Stdout, "\n")
+		if raw {
+			stored = string(ob.StdoutRaw)
+		}
+		for _, got := range []string{stored, seen.String()} {
+			if strings.Contains(got, "hun") || !strings.Contains(got, secretMask) {
+				t.Errorf("raw=%v: secret split across reads was not masked: %q", raw, got)
+			}
+		}
+	}
+}
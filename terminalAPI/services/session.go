@@ -4,7 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"os/exec"
+	"log/slog"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -18,24 +21,155 @@ type Session struct {
 	LastActive      time.Time         `json:"lastActive"`
 	WorkingDir      string            `json:"workingDir"`
 	IsActive        bool              `json:"isActive"`
-	ExpiresAt       time.Time         `json:"expiresAt"`
-	ActivityLog     []string          `json:"activityLog,omitempty"`
+	ExpiresAt       time.Time         `json:"expiresAt,omitzero"` // Unset when the session never expires
+	ExpirySeconds   int               `json:"expirySeconds,omitempty"` // Idle time before expiry; 0 uses the server's
+	NeverExpire     bool              `json:"neverExpire,omitempty"`   // Lives until deleted
+	ActivityLog     []ActivityEntry   `json:"activityLog,omitempty"` // Recent activity; GET /sessions/{id}/activity has the rest
 	EnvVars         map[string]string `json:"envVars"`
+	Aliases         map[string]string `json:"aliases,omitempty"` // Expanded in commands before execution
 	RunningProcesses map[string]*Process `json:"-"` // Don't expose in JSON
+	Shell           *PersistentShell  `json:"-"`
+	Sandbox         *SandboxConfig    `json:"sandbox,omitempty"` // Run commands in a Docker container
+	Remote          *RemoteConfig     `json:"remote,omitempty"`  // Run commands on another machine over SSH
+	RunAs           string            `json:"runAs,omitempty"`   // Default user commands execute as
+	Backups         bool              `json:"backups,omitempty"` // The file API keeps the previous content of files it overwrites
+	MaxProcesses    int               `json:"maxProcesses"`      // Cap on concurrently running background processes
+	Owner           string            `json:"owner,omitempty"`   // Name of the API key that created the session
+	// Replicas sharing a session store run the session's processes and
+	// shell on the node that received the request
+	Node            string            `json:"node,omitempty"`         // Node that created the session
+	ProcessNodes    map[string]string `json:"processNodes,omitempty"` // Node each process runs on
+	// Labels orchestrators find their sessions by
+	Name            string            `json:"name,omitempty"`
+	Tags            []string          `json:"tags,omitempty"`
+	Metadata        map[string]interface{} `json:"metadata,omitempty"`
+
 	Lock            sync.Mutex        `json:"-"`
 }
 
+// SessionOptions are the optional settings accepted at session creation
+type SessionOptions struct {
+	Sandbox *SandboxConfig `json:"sandbox,omitempty"`
+	// The machine the working directory lives on, instead of this host
+	Remote *RemoteConfig `json:"remote,omitempty"`
+	RunAs  string        `json:"runAs,omitempty"`
+	// Have the file API back up files before every update and patch
+	Backups bool `json:"backups,omitempty"`
+	// Lower the server's cap on running processes for this session
+	MaxProcesses int `json:"maxProcesses,omitempty"`
+	// Seconds without activity before the session expires, instead of the
+	// server's sessionExpiry
+	ExpirySeconds int `json:"expirySeconds,omitempty"`
+	// Keep the session until it is deleted; only admin keys may set it
+	NeverExpire bool `json:"neverExpire,omitempty"`
+	// Labels to find the session by, changed later with UpdateSession
+	Name     string                 `json:"name,omitempty"`
+	Tags     []string               `json:"tags,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	// Set from the authenticated API key, never from the request body
+	Owner string `json:"-"`
+	// Whether the sandbox may have extra mounts, which only admin keys may
+	// add; also never from the request body
+	AllowMounts bool `json:"-"`
+	// Whether the session may be remote, which also only admin keys may ask
+	// for
+	AllowRemote bool `json:"-"`
+}
+
+// SessionLimits reports a session's process capacity
+type SessionLimits struct {
+	MaxProcesses       int `json:"maxProcesses"`
+	RunningProcesses   int `json:"runningProcesses"`
+	AvailableProcesses int `json:"availableProcesses"`
+}
+
+// ErrSessionNotFound is returned for sessions that do not exist, have been
+// deleted or have expired
+var ErrSessionNotFound = errors.New("session not found or inactive")
+
+// ErrNoWorkingDir is returned for commands run before the session has a
+// working directory
+var ErrNoWorkingDir = errors.New("working directory not set for session")
+
+// ErrProcessNotFound is returned for processes a session does not have
+var ErrProcessNotFound = errors.New("process not found")
+
+// ErrProcessLimitReached is returned when a session already runs its
+// maximum number of processes
+var ErrProcessLimitReached = errors.New("process limit reached")
+
+// DefaultSessionExpiry is how long sessions live without activity unless
+// configured otherwise
+const DefaultSessionExpiry = 24 * time.Hour
+
+// DefaultMaxProcesses is the per-session cap on running processes unless
+// configured otherwise
+const DefaultMaxProcesses = 10
+
+// Completed process records are kept for this long, and at most this many
+// per session, unless configured otherwise
+const (
+	DefaultProcessRetention      = 30 * time.Minute
+	DefaultMaxCompletedProcesses = 100
+)
+
+// ErrProcessRunning is returned when removing a process that has not exited
+var ErrProcessRunning = errors.New("process is still running")
+
+// ErrProcessNotRunning is returned when signaling a process that has exited
+var ErrProcessNotRunning = errors.New("process is not running")
+
+// ErrInvalidShell is returned when a session shell does not exist or is not
+// executable
+var ErrInvalidShell = errors.New("invalid shell")
+
 type SessionManager struct {
 	sessions      map[string]*Session
 	mutex         sync.RWMutex
 	sessionExpiry time.Duration
 	cleanupTicker *time.Ticker
+	containerRunner *ContainerRunner
+	remoteRunner  *RemoteRunner
+	maxProcesses  int
+	processRetention      time.Duration
+	maxCompletedProcesses int
+	// Called with the ID of every session that is deleted or expires
+	closeListeners []func(sessionID string)
+	// Called with every session lifecycle event
+	eventListeners []func(event SessionEvent)
+	// Called with terminal output as commands and processes produce it
+	outputListeners []func(sessionID string, data []byte)
+	// Set by NewSecretService to inject and mask secrets
+	secrets *SecretService
+	// Validates shells chosen for sessions
+	shellRunner *CommandRunner
+	// Roots working directories must lie in; empty allows any directory
+	allowedDirs []string
+	// Records operations in sessions
+	audit *AuditService
+	// Shell of new sessions; empty uses $SHELL
+	defaultShell string
+	// Where sessions are stored for replicas to share; sessions holds the
+	// copies with this node's processes and shells
+	store SessionStore
+	// Name of this replica
+	node string
+	// Persists the activity of sessions
+	activity *activityLog
 }
 
 func NewSessionManager() *SessionManager {
 	sm := &SessionManager{
 		sessions:      make(map[string]*Session),
-		sessionExpiry: 24 * time.Hour, // Default 24 hour expiry
+		sessionExpiry: DefaultSessionExpiry,
+		containerRunner: NewContainerRunner(),
+		remoteRunner:  NewRemoteRunner(),
+		shellRunner:   NewCommandRunner(),
+		maxProcesses:  DefaultMaxProcesses,
+		processRetention:      DefaultProcessRetention,
+		maxCompletedProcesses: DefaultMaxCompletedProcesses,
+		store:         NewMemorySessionStore(),
+		activity:      &activityLog{},
 	}
 	
 	// Start cleanup routine
@@ -45,35 +179,151 @@ func NewSessionManager() *SessionManager {
 	return sm
 }
 
+// SetMaxProcesses sets the default cap on running processes for sessions
+// created afterwards
+func (sm *SessionManager) SetMaxProcesses(max int) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	if max > 0 {
+		sm.maxProcesses = max
+	}
+}
+
+// SetProcessRetention sets how long and how many completed process records
+// each session keeps. Zero values leave the current setting unchanged.
+func (sm *SessionManager) SetProcessRetention(maxAge time.Duration, maxCount int) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	if maxAge > 0 {
+		sm.processRetention = maxAge
+	}
+	if maxCount > 0 {
+		sm.maxCompletedProcesses = maxCount
+	}
+}
+
+// SetSessionExpiry sets how long sessions live without activity
+func (sm *SessionManager) SetSessionExpiry(expiry time.Duration) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	if expiry > 0 {
+		sm.sessionExpiry = expiry
+	}
+}
+
+// SetDefaultShell sets the shell of sessions created afterwards. Empty
+// restores the default of $SHELL.
+func (sm *SessionManager) SetDefaultShell(shell string) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.defaultShell = shell
+}
+
 func (sm *SessionManager) cleanupExpiredSessions() {
 	for range sm.cleanupTicker.C {
+		// Other replicas may have kept sessions alive or deleted them
+		if sm.store.Shared() {
+			for _, id := range sm.localSessionIDs() {
+				sm.SyncSession(id)
+			}
+		}
+		
 		sm.mutex.Lock()
 		now := time.Now()
 		for id, session := range sm.sessions {
-			if session.ExpiresAt.Before(now) {
-				// Terminate any running processes
-				for _, proc := range session.RunningProcesses {
-					if proc != nil && proc.Cmd != nil && proc.Cmd.Process != nil {
-						proc.Terminate()
-					}
+			if session.expired(now) {
+				sm.closeSession(session)
+				if err := sm.store.Delete(id); err != nil {
+					sessionLog(id).Warn("failed to delete expired session from store", "error", err)
 				}
-				delete(sm.sessions, id)
-				fmt.Printf("[TERMINAL] Expired inactive session: %s\n", id)
+				sm.audit.Record(AuditEntry{Actor: session.Owner, SessionID: id, Operation: "session.expire"}, nil)
+				sm.emitSessionEvent(SessionExpired, session)
+				continue
 			}
+			sm.pruneCompletedProcesses(session)
 		}
 		sm.mutex.Unlock()
 	}
 }
 
-func (sm *SessionManager) CreateSession() (*Session, error) {
+func (sm *SessionManager) CreateSession(opts *SessionOptions) (*Session, error) {
+	if opts == nil {
+		opts = &SessionOptions{}
+	}
+	
+	if opts.Remote != nil {
+		if !opts.AllowRemote {
+			return nil, ErrRemoteForbidden
+		}
+		if opts.Sandbox != nil {
+			return nil, fmt.Errorf("%w: a session cannot be both sandboxed and remote", ErrInvalidRemote)
+		}
+		if opts.RunAs != "" {
+			return nil, fmt.Errorf("runAs is %w; set the remote user instead", ErrRemoteUnsupported)
+		}
+		if opts.Backups {
+			return nil, fmt.Errorf("backups are %w", ErrRemoteUnsupported)
+		}
+		if err := opts.Remote.ApplyDefaults(); err != nil {
+			return nil, err
+		}
+		if !sm.remoteRunner.IsAvailable() {
+			return nil, errors.New("remote sessions require the ssh CLI")
+		}
+	} else if opts.Sandbox != nil {
+		if err := opts.Sandbox.ApplyDefaults(); err != nil {
+			return nil, err
+		}
+		if len(opts.Sandbox.Mounts) > 0 && !opts.AllowMounts {
+			return nil, ErrSandboxMountsForbidden
+		}
+		if !sm.containerRunner.IsAvailable() {
+			return nil, errors.New("sandboxed sessions require the docker CLI")
+		}
+	} else if opts.RunAs != "" {
+		if _, err := lookupRunAsUser(opts.RunAs); err != nil {
+			return nil, err
+		}
+	}
+	
+	if opts.MaxProcesses < 0 {
+		return nil, errors.New("maxProcesses must not be negative")
+	}
+	if opts.ExpirySeconds < 0 {
+		return nil, fmt.Errorf("%w: expirySeconds must not be negative", ErrInvalidExpiry)
+	}
+	
+	tags, err := validateSessionLabels(opts.Name, opts.Tags, opts.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	metadata := opts.Metadata
+	if len(metadata) == 0 {
+		metadata = nil
+	}
+	
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 	
+	if opts.Sandbox != nil {
+		if err := sm.resolveSandboxMounts(opts.Sandbox); err != nil {
+			return nil, err
+		}
+	}
+	
+	maxProcesses := sm.maxProcesses
+	if opts.MaxProcesses > 0 && opts.MaxProcesses < maxProcesses {
+		maxProcesses = opts.MaxProcesses
+	}
+	
 	id := uuid.New().String()
 	now := time.Now()
 	
-	// Get system default shell
-	shell := os.Getenv("SHELL")
+	// Get the configured or system default shell
+	shell := sm.defaultShell
+	if shell == "" {
+		shell = os.Getenv("SHELL")
+	}
 	if shell == "" {
 		// Default to bash on Unix, cmd on Windows
 		if os.PathSeparator == '/' {
@@ -86,17 +336,32 @@ func (sm *SessionManager) CreateSession() (*Session, error) {
 	session := &Session{
 		ID:              id,
 		CreatedAt:       now,
-		LastActive:      now,
 		WorkingDir:      "",
 		IsActive:        true,
-		ExpiresAt:       now.Add(sm.sessionExpiry),
-		ActivityLog:     []string{fmt.Sprintf("%s: Session created", now.Format(time.RFC3339))},
+		ExpirySeconds:   opts.ExpirySeconds,
+		NeverExpire:     opts.NeverExpire,
 		EnvVars:         map[string]string{"SHELL": shell},
 		RunningProcesses: make(map[string]*Process),
+		Sandbox:         opts.Sandbox,
+		Remote:          opts.Remote,
+		RunAs:           opts.RunAs,
+		Backups:         opts.Backups,
+		MaxProcesses:    maxProcesses,
+		Owner:           opts.Owner,
+		Node:            sm.node,
+		Name:            opts.Name,
+		Tags:            tags,
+		Metadata:        metadata,
 	}
+	sm.extendSession(session, now)
+	sm.addActivity(session, ActivityEntry{Category: ActivitySession, Message: "Session created"})
 	
+	if err := sm.store.Save(session); err != nil {
+		return nil, fmt.Errorf("failed to store session: %w", err)
+	}
 	sm.sessions[id] = session
-	fmt.Printf("[TERMINAL] Created new session: %s\n", id)
+	sm.audit.Record(AuditEntry{Actor: session.Owner, SessionID: id, Operation: "session.create"}, nil)
+	sm.emitSessionEvent(SessionCreated, session)
 	return session, nil
 }
 
@@ -106,25 +371,113 @@ func (sm *SessionManager) GetSession(id string) (*Session, error) {
 	
 	session, exists := sm.sessions[id]
 	if !exists || !session.IsActive {
-		return nil, errors.New("session not found or inactive")
+		return nil, ErrSessionNotFound
 	}
 	
 	// Update last active time and extend expiry
-	now := time.Now()
-	session.LastActive = now
-	session.ExpiresAt = now.Add(sm.sessionExpiry)
+	sm.extendSession(session, time.Now())
 	return session, nil
 }
 
-func (sm *SessionManager) DeleteSession(id string) error {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
+// SessionOwner returns the owner of a session and whether it exists
+func (sm *SessionManager) SessionOwner(id string) (string, bool) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
 	
 	session, exists := sm.sessions[id]
 	if !exists {
-		return errors.New("session not found")
+		return "", false
+	}
+	return session.Owner, true
+}
+
+// PeekSession returns an active session without extending its expiry, for
+// background work and reads that should not keep a session alive
+func (sm *SessionManager) PeekSession(id string) (*Session, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	
+	session, exists := sm.sessions[id]
+	if !exists || !session.IsActive {
+		return nil, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+// OnSessionClosed registers a function to call when a session is deleted or
+// expires. Listeners run in their own goroutine.
+func (sm *SessionManager) OnSessionClosed(listener func(sessionID string)) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.closeListeners = append(sm.closeListeners, listener)
+}
+
+// OnTerminalOutput registers a function to receive what a terminal attached
+// to a session would show: each command line as it starts, followed by its
+// output. Listeners are called synchronously and must not block.
+func (sm *SessionManager) OnTerminalOutput(listener func(sessionID string, data []byte)) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.outputListeners = append(sm.outputListeners, listener)
+}
+
+// emitTerminalOutput delivers output to the terminal output listeners
+func (sm *SessionManager) emitTerminalOutput(sessionID string, data []byte) {
+	sm.mutex.RLock()
+	listeners := sm.outputListeners
+	sm.mutex.RUnlock()
+	
+	for _, listener := range listeners {
+		listener(sessionID, data)
+	}
+}
+
+// redact masks secret values in text reported back to clients
+func (sm *SessionManager) redact(text string) string {
+	sm.mutex.RLock()
+	secrets := sm.secrets
+	sm.mutex.RUnlock()
+	
+	if secrets == nil {
+		return text
+	}
+	return secrets.Redact(text)
+}
+
+// redactValues returns the secret values redact masks
+func (sm *SessionManager) redactValues() []string {
+	sm.mutex.RLock()
+	secrets := sm.secrets
+	sm.mutex.RUnlock()
+	
+	if secrets == nil {
+		return nil
 	}
+	return secrets.maskedValues()
+}
+
+// resolveSecrets returns the values of the named secrets
+func (sm *SessionManager) resolveSecrets(names []string) (map[string]string, error) {
+	sm.mutex.RLock()
+	secrets := sm.secrets
+	sm.mutex.RUnlock()
 	
+	if secrets == nil {
+		return nil, errors.New("secret storage is not available")
+	}
+	return secrets.resolve(names)
+}
+
+// notifySessionClosed must be called with sm.mutex held
+func (sm *SessionManager) notifySessionClosed(id string) {
+	for _, listener := range sm.closeListeners {
+		go listener(id)
+	}
+}
+
+// closeSession stops what a session runs on this node and forgets it. Callers
+// must hold sm.mutex for writing.
+func (sm *SessionManager) closeSession(session *Session) {
 	// Terminate all running processes
 	for _, proc := range session.RunningProcesses {
 		if proc != nil && proc.Cmd != nil && proc.Cmd.Process != nil {
@@ -132,19 +485,58 @@ func (sm *SessionManager) DeleteSession(id string) error {
 		}
 	}
 	
-	delete(sm.sessions, id)
-	fmt.Printf("[TERMINAL] Deleted session: %s\n", id)
-	return nil
+	if session.Shell != nil {
+		session.Shell.Close()
+	}
+	
+	if session.Sandbox != nil {
+		go sm.containerRunner.RemoveContainer(session.ID)
+	}
+	
+	delete(sm.sessions, session.ID)
+	sm.notifySessionClosed(session.ID)
 }
 
-func (sm *SessionManager) SetWorkingDirectory(id string, dir string) error {
+func (sm *SessionManager) DeleteSession(id string) error {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 	
 	session, exists := sm.sessions[id]
+	if !exists {
+		return ErrSessionNotFound
+	}
+	
+	if err := sm.store.Delete(id); err != nil {
+		return fmt.Errorf("failed to delete session from store: %w", err)
+	}
+	sm.closeSession(session)
+	sm.audit.Record(AuditEntry{Actor: session.Owner, SessionID: id, Operation: "session.delete"}, nil)
+	sm.emitSessionEvent(SessionDeleted, session)
+	return nil
+}
+
+func (sm *SessionManager) SetWorkingDirectory(id string, dir string) (err error) {
+	sm.mutex.RLock()
+	session, exists := sm.sessions[id]
+	sm.mutex.RUnlock()
+	if exists && session.Remote != nil {
+		// Checked over SSH, without holding up other sessions
+		return sm.setRemoteWorkingDirectory(session, dir)
+	}
+	
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	
+	session, exists = sm.sessions[id]
 	if (!exists || !session.IsActive) {
-		return errors.New("session not found or inactive")
+		return ErrSessionNotFound
 	}
+	defer func() {
+		sm.audit.Record(AuditEntry{Actor: session.Owner, SessionID: id, Operation: "session.cwd", Target: dir}, err)
+		if err == nil {
+			sm.emitSessionEvent(SessionWorkingDirChanged, session)
+		}
+	}()
 	
 	// Check if directory exists
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
@@ -157,52 +549,94 @@ func (sm *SessionManager) SetWorkingDirectory(id string, dir string) error {
 		return err
 	}
 	
+	if err := sm.checkAllowedDir(absPath); err != nil {
+		return err
+	}
+	
 	now := time.Now()
 	session.WorkingDir = absPath
-	session.LastActive = now
-	session.ExpiresAt = now.Add(sm.sessionExpiry)
-	session.ActivityLog = append(session.ActivityLog, fmt.Sprintf("%s: Set working directory to %s", 
-		now.Format(time.RFC3339), absPath))
+	sm.extendSession(session, now)
+	sm.addActivity(session, ActivityEntry{
+		Category: ActivitySession,
+		Target:   absPath,
+		Message:  "Set working directory to " + absPath,
+	})
 	
-	fmt.Printf("[TERMINAL] Session %s: Set working directory to %s\n", id, absPath)
 	return nil
 }
 
-func (sm *SessionManager) LogActivity(id string, activity string) error {
+// LogActivity records something done in a session. The timestamp is set
+// here, and an empty outcome is a success.
+func (sm *SessionManager) LogActivity(id string, entry ActivityEntry) error {
 	// Use separate locks to avoid deadlocks
 	sm.mutex.RLock()
 	session, exists := sm.sessions[id]
 	sm.mutex.RUnlock()
 	
 	if !exists || !session.IsActive {
-		return errors.New("session not found or inactive")
+		return ErrSessionNotFound
 	}
 	
-	now := time.Now()
-	logEntry := fmt.Sprintf("%s: %s", now.Format(time.RFC3339), activity)
+	entry.Target = sm.redact(entry.Target)
+	entry.Message = sm.redact(entry.Message)
 	
 	// Lock only while updating activity log
 	session.Lock.Lock()
-	session.ActivityLog = append(session.ActivityLog, logEntry)
-	
-	// Maintain a reasonable log size
-	if len(session.ActivityLog) > 100 {
-		session.ActivityLog = session.ActivityLog[len(session.ActivityLog)-100:]
-	}
+	sm.addActivity(session, entry)
 	session.Lock.Unlock()
 	
 	return nil
 }
 
-func (sm *SessionManager) GetAllSessions() []*Session {
+// GetAllSessions lists the sessions created by owner, or every session when
+// owner is empty
+func (sm *SessionManager) GetAllSessions(owner string) []*Session {
+	stored, err := sm.store.List()
+	if err != nil {
+		slog.Warn("failed to list stored sessions, listing this node's", "error", err)
+	}
+	
 	sm.mutex.RLock()
 	defer sm.mutex.RUnlock()
 	
-	sessions := make([]*Session, 0, len(sm.sessions))
-	for _, session := range sm.sessions {
-		sessionCopy := *session // Create a copy to avoid exposing running processes
-		sessionCopy.RunningProcesses = nil
-		sessions = append(sessions, &sessionCopy)
+	if err != nil {
+		stored = make([]*Session, 0, len(sm.sessions))
+		for _, session := range sm.sessions {
+			stored = append(stored, session)
+		}
+	}
+	
+	sessions := make([]*Session, 0, len(stored))
+	for _, session := range stored {
+		if owner != "" && session.Owner != owner {
+			continue
+		}
+		// Create a copy to avoid exposing running processes
+		sessionCopy := &Session{
+			ID:          session.ID,
+			CreatedAt:   session.CreatedAt,
+			LastActive:  session.LastActive,
+			WorkingDir:  session.WorkingDir,
+			IsActive:    session.IsActive,
+			ExpiresAt:   session.ExpiresAt,
+			ExpirySeconds: session.ExpirySeconds,
+			NeverExpire: session.NeverExpire,
+			ActivityLog: session.ActivityLog,
+			EnvVars:     session.EnvVars,
+			Aliases:     session.Aliases,
+			Sandbox:     session.Sandbox,
+			Remote:      session.Remote,
+			RunAs:       session.RunAs,
+			Backups:     session.Backups,
+			MaxProcesses: session.MaxProcesses,
+			Owner:       session.Owner,
+			Node:        session.Node,
+			ProcessNodes: session.ProcessNodes,
+			Name:        session.Name,
+			Tags:        session.Tags,
+			Metadata:    session.Metadata,
+		}
+		sessions = append(sessions, sessionCopy)
 	}
 	
 	return sessions
@@ -214,11 +648,15 @@ func (sm *SessionManager) SetEnvVar(id string, key string, value string) error {
 	
 	session, exists := sm.sessions[id]
 	if !exists || !session.IsActive {
-		return errors.New("session not found or inactive")
+		return ErrSessionNotFound
 	}
 	
 	session.EnvVars[key] = value
-	sm.LogActivity(id, fmt.Sprintf("Set environment variable: %s=%s", key, value))
+	sm.LogActivity(id, ActivityEntry{
+		Category: ActivityEnv,
+		Target:   key,
+		Message:  fmt.Sprintf("Set environment variable: %s=%s", key, value),
+	})
 	return nil
 }
 
@@ -228,7 +666,7 @@ func (sm *SessionManager) GetEnvVars(id string) (map[string]string, error) {
 	
 	session, exists := sm.sessions[id]
 	if !exists || !session.IsActive {
-		return nil, errors.New("session not found or inactive")
+		return nil, ErrSessionNotFound
 	}
 	
 	// Return a copy to prevent modification
@@ -246,12 +684,16 @@ func (sm *SessionManager) UnsetEnvVar(id string, key string) error {
 	
 	session, exists := sm.sessions[id]
 	if !exists || !session.IsActive {
-		return errors.New("session not found or inactive")
+		return ErrSessionNotFound
 	}
 	
 	if _, exists := session.EnvVars[key]; exists {
 		delete(session.EnvVars, key)
-		sm.LogActivity(id, fmt.Sprintf("Unset environment variable: %s", key))
+		sm.LogActivity(id, ActivityEntry{
+			Category: ActivityEnv,
+			Target:   key,
+			Message:  "Unset environment variable: " + key,
+		})
 	}
 	
 	return nil
@@ -264,10 +706,138 @@ func (sm *SessionManager) RegisterProcess(sessionID string, processID string, pr
 	
 	session, exists := sm.sessions[sessionID]
 	if !exists || !session.IsActive {
-		return errors.New("session not found or inactive")
+		return ErrSessionNotFound
+	}
+	
+	if err := session.checkProcessCapacity(); err != nil {
+		return err
 	}
 	
 	session.RunningProcesses[processID] = process
+	sm.pruneCompletedProcesses(session)
+	return nil
+}
+
+// pruneCompletedProcesses drops completed process records older than the
+// retention period and the oldest ones beyond the retained count. Callers
+// must hold sm.mutex for writing.
+func (sm *SessionManager) pruneCompletedProcesses(session *Session) {
+	cutoff := time.Now().Add(-sm.processRetention)
+	
+	var completed []*Process
+	for id, proc := range session.RunningProcesses {
+		if proc == nil || proc.IsRunning() {
+			continue
+		}
+		if proc.EndTime.Before(cutoff) {
+			delete(session.RunningProcesses, id)
+			continue
+		}
+		completed = append(completed, proc)
+	}
+	
+	if excess := len(completed) - sm.maxCompletedProcesses; excess > 0 {
+		sort.Slice(completed, func(i, j int) bool {
+			return completed[i].EndTime.Before(completed[j].EndTime)
+		})
+		for _, proc := range completed[:excess] {
+			delete(session.RunningProcesses, proc.ID)
+		}
+	}
+}
+
+// RemoveProcess deletes the record of a completed process
+func (sm *SessionManager) RemoveProcess(sessionID string, processID string) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	
+	session, exists := sm.sessions[sessionID]
+	if !exists || !session.IsActive {
+		return ErrSessionNotFound
+	}
+	
+	proc, exists := session.RunningProcesses[processID]
+	if !exists {
+		return ErrProcessNotFound
+	}
+	if proc != nil && proc.IsRunning() {
+		return ErrProcessRunning
+	}
+	
+	delete(session.RunningProcesses, processID)
+	return nil
+}
+
+// RemoveCompletedProcesses deletes every completed process record of a
+// session and returns how many were removed
+func (sm *SessionManager) RemoveCompletedProcesses(sessionID string) (int, error) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	
+	session, exists := sm.sessions[sessionID]
+	if !exists || !session.IsActive {
+		return 0, ErrSessionNotFound
+	}
+	
+	removed := 0
+	for id, proc := range session.RunningProcesses {
+		if proc == nil || !proc.IsRunning() {
+			delete(session.RunningProcesses, id)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// CheckProcessCapacity reports ErrProcessLimitReached if the session cannot
+// start another process. RegisterProcess enforces the same check.
+func (sm *SessionManager) CheckProcessCapacity(sessionID string) error {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	
+	session, exists := sm.sessions[sessionID]
+	if !exists || !session.IsActive {
+		return ErrSessionNotFound
+	}
+	return session.checkProcessCapacity()
+}
+
+// GetLimits returns the session's process capacity
+func (sm *SessionManager) GetLimits(sessionID string) (*SessionLimits, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	
+	session, exists := sm.sessions[sessionID]
+	if !exists || !session.IsActive {
+		return nil, ErrSessionNotFound
+	}
+	
+	running := session.runningProcessCount()
+	available := session.MaxProcesses - running
+	if available < 0 {
+		available = 0
+	}
+	return &SessionLimits{
+		MaxProcesses:       session.MaxProcesses,
+		RunningProcesses:   running,
+		AvailableProcesses: available,
+	}, nil
+}
+
+func (session *Session) runningProcessCount() int {
+	running := 0
+	for _, proc := range session.RunningProcesses {
+		if proc != nil && proc.IsRunning() {
+			running++
+		}
+	}
+	return running
+}
+
+func (session *Session) checkProcessCapacity() error {
+	if session.runningProcessCount() >= session.MaxProcesses {
+		return fmt.Errorf("%w: session already runs %d processes", ErrProcessLimitReached, session.MaxProcesses)
+	}
 	return nil
 }
 
@@ -278,7 +848,7 @@ func (sm *SessionManager) UnregisterProcess(sessionID string, processID string)
 	
 	session, exists := sm.sessions[sessionID]
 	if !exists {
-		return errors.New("session not found")
+		return ErrSessionNotFound
 	}
 	
 	delete(session.RunningProcesses, processID)
@@ -292,12 +862,15 @@ func (sm *SessionManager) GetProcess(sessionID string, processID string) (*Proce
 	
 	session, exists := sm.sessions[sessionID]
 	if !exists || !session.IsActive {
-		return nil, errors.New("session not found or inactive")
+		return nil, ErrSessionNotFound
 	}
 	
 	process, exists := session.RunningProcesses[processID]
 	if !exists {
-		return nil, errors.New("process not found")
+		if node := session.processNode(processID); node != "" && node != sm.node {
+			return nil, fmt.Errorf("%w on this node, it runs on node %s", ErrProcessNotFound, node)
+		}
+		return nil, ErrProcessNotFound
 	}
 	
 	return process, nil
@@ -310,7 +883,7 @@ func (sm *SessionManager) ListProcesses(sessionID string) (map[string]*ProcessIn
 	
 	session, exists := sm.sessions[sessionID]
 	if !exists || !session.IsActive {
-		return nil, errors.New("session not found or inactive")
+		return nil, ErrSessionNotFound
 	}
 	
 	processInfos := make(map[string]*ProcessInfo)
@@ -319,10 +892,22 @@ func (sm *SessionManager) ListProcesses(sessionID string) (map[string]*ProcessIn
 			processInfos[id] = &ProcessInfo{
 				ID:         id,
 				Command:    process.Command,
+				ExpandedCommand: process.ExpandedCommand,
 				StartTime:  process.StartTime,
 				IsRunning:  process.IsRunning(),
+				IsPaused:   process.IsPaused(),
+				WaitingForInput: process.IsWaitingForInput(),
 				ExitCode:   process.ExitCode,
 				PID:        process.PID,
+				Node:       sm.node,
+			}
+			if process.log != nil {
+				processInfos[id].LogFile = process.ID + ".log"
+			}
+			if stats, err := process.Stats(); err == nil {
+				processInfos[id].ElapsedSeconds = stats.ElapsedSeconds
+				processInfos[id].CPUPercent = stats.CPUPercent
+				processInfos[id].MemoryRSS = stats.MemoryRSS
 			}
 		}
 	}
@@ -330,6 +915,122 @@ func (sm *SessionManager) ListProcesses(sessionID string) (map[string]*ProcessIn
 	return processInfos, nil
 }
 
+// GetPersistentShell returns the session's long-running shell, starting one
+// if none exists or the previous one has exited
+func (sm *SessionManager) GetPersistentShell(sessionID string, shellPath string) (*PersistentShell, error) {
+	sm.mutex.RLock()
+	session, exists := sm.sessions[sessionID]
+	sm.mutex.RUnlock()
+	
+	if !exists || !session.IsActive {
+		return nil, ErrSessionNotFound
+	}
+	
+	// The old shell is closed after the session is unlocked, so that nothing
+	// waits on a shell while holding the session
+	session.Lock.Lock()
+	old := session.Shell
+	if old != nil && old.IsAlive() && old.ShellPath == shellPath {
+		session.Lock.Unlock()
+		return old, nil
+	}
+	
+	shell, err := NewPersistentShell(shellPath, session.WorkingDir, buildShellEnv(session.EnvVars))
+	if err == nil {
+		session.Shell = shell
+	}
+	session.Lock.Unlock()
+	
+	if err != nil {
+		return nil, err
+	}
+	if old != nil {
+		old.Close()
+	}
+	sessionLog(sessionID).Info("started persistent shell", "shell", shellPath, "pid", shell.PID())
+	return shell, nil
+}
+
+// SetShell validates and sets the shell a session runs commands with. The
+// shell may be an absolute path or a name looked up in PATH. A running
+// persistent shell is replaced on the next persistent command.
+func (sm *SessionManager) SetShell(sessionID string, shell string) (shellPath string, err error) {
+	sm.mutex.RLock()
+	session, exists := sm.sessions[sessionID]
+	sm.mutex.RUnlock()
+	
+	if !exists || !session.IsActive {
+		return "", ErrSessionNotFound
+	}
+	defer func() {
+		sm.Audit(sessionID, "session.shell", shell, "", err)
+	}()
+	if session.Sandbox != nil {
+		return "", errors.New("the shell of a sandboxed session cannot be changed")
+	}
+	if session.Remote != nil {
+		return "", errors.New("the shell of a remote session is set by its remote configuration")
+	}
+	
+	shellPath, err = sm.validateShell(shell)
+	if err != nil {
+		return "", err
+	}
+	
+	session.Lock.Lock()
+	if session.EnvVars == nil {
+		session.EnvVars = make(map[string]string)
+	}
+	session.EnvVars["SHELL"] = shellPath
+	session.Lock.Unlock()
+	
+	sm.LogActivity(sessionID, ActivityEntry{
+		Category: ActivitySession,
+		Target:   shellPath,
+		Message:  "Set shell: " + shellPath,
+	})
+	return shellPath, nil
+}
+
+// validateShell resolves a shell name or path and checks that it can be
+// executed
+func (sm *SessionManager) validateShell(shell string) (string, error) {
+	shellPath := shell
+	if shell != "" && !strings.Contains(shell, "/") {
+		if resolved, err := exec.LookPath(shell); err == nil {
+			shellPath = resolved
+		}
+	}
+	
+	if !sm.shellRunner.IsValidShell(shellPath) {
+		return "", fmt.Errorf("%w: %s", ErrInvalidShell, shell)
+	}
+	return shellPath, nil
+}
+
+// ResetPersistentShell closes the session's long-running shell, if any
+func (sm *SessionManager) ResetPersistentShell(sessionID string) error {
+	sm.mutex.RLock()
+	session, exists := sm.sessions[sessionID]
+	sm.mutex.RUnlock()
+	
+	if !exists || !session.IsActive {
+		return ErrSessionNotFound
+	}
+	
+	session.Lock.Lock()
+	shell := session.Shell
+	session.Shell = nil
+	session.Lock.Unlock()
+	
+	if shell != nil {
+		shell.Close()
+		sessionLog(sessionID).Info("closed persistent shell")
+	}
+	
+	return nil
+}
+
 // Helper function to validate shell paths
 func isValidShellPath(path string) bool {
     // Basic path validation
@@ -0,0 +1,58 @@
+package services
+
+import "time"
+
+// Session lifecycle events
+const (
+	SessionCreated           = "session.created"
+	SessionExpired           = "session.expired"
+	SessionDeleted           = "session.deleted"
+	SessionWorkingDirChanged = "session.cwd"
+)
+
+// SessionEvent reports a change in the lifecycle of a session, with the
+// labels an orchestrator finds its sessions by
+type SessionEvent struct {
+	Event      string    `json:"event"`
+	SessionID  string    `json:"sessionId"`
+	Timestamp  time.Time `json:"timestamp"`
+	Owner      string    `json:"owner,omitempty"`
+	Name       string    `json:"name,omitempty"`
+	Tags       []string  `json:"tags,omitempty"`
+	WorkingDir string    `json:"workingDir,omitempty"`
+	Node       string    `json:"node,omitempty"` // Replica the event happened on
+}
+
+// OnSessionEvent registers a function to call when a session is created,
+// expires, is deleted or changes its working directory. Listeners run in
+// their own goroutine.
+func (sm *SessionManager) OnSessionEvent(listener func(event SessionEvent)) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.eventListeners = append(sm.eventListeners, listener)
+}
+
+// emitSessionEvent delivers an event about session to the listeners.
+// Callers must hold sm.mutex.
+func (sm *SessionManager) emitSessionEvent(event string, session *Session) {
+	if len(sm.eventListeners) == 0 {
+		return
+	}
+
+	session.Lock.Lock()
+	e := SessionEvent{
+		Event:      event,
+		SessionID:  session.ID,
+		Timestamp:  time.Now(),
+		Owner:      session.Owner,
+		Name:       session.Name,
+		Tags:       append([]string(nil), session.Tags...),
+		WorkingDir: session.WorkingDir,
+		Node:       sm.node,
+	}
+	session.Lock.Unlock()
+
+	for _, listener := range sm.eventListeners {
+		go listener(e)
+	}
+}
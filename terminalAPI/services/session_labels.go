@@ -0,0 +1,132 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidSessionLabels is returned when a session name, tags or metadata
+// are rejected
+var ErrInvalidSessionLabels = errors.New("invalid session labels")
+
+// Limits on the labels of a session
+const (
+	MaxSessionNameLength   = 256
+	MaxSessionTags         = 32
+	MaxSessionTagLength    = 128
+	MaxSessionMetadataSize = 16 * 1024 // Bytes of metadata as JSON
+)
+
+// SessionUpdate changes the labels orchestrators find sessions by. Fields
+// that are left out stay as they are; an empty list or object clears them.
+type SessionUpdate struct {
+	Name     *string                `json:"name,omitempty"`
+	Tags     []string               `json:"tags,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// UpdateSession changes the name, tags or metadata of a session
+func (sm *SessionManager) UpdateSession(id string, update *SessionUpdate) (session *Session, err error) {
+	defer func() {
+		sm.Audit(id, "session.update", "", "", err)
+	}()
+
+	name := ""
+	if update.Name != nil {
+		name = *update.Name
+	}
+	tags, err := validateSessionLabels(name, update.Tags, update.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err = sm.GetSession(id)
+	if err != nil {
+		return nil, err
+	}
+
+	session.Lock.Lock()
+	if update.Name != nil {
+		session.Name = name
+	}
+	if update.Tags != nil {
+		session.Tags = tags
+	}
+	if update.Metadata != nil {
+		session.Metadata = update.Metadata
+		if len(update.Metadata) == 0 {
+			session.Metadata = nil
+		}
+	}
+	session.Lock.Unlock()
+
+	return session, nil
+}
+
+// validateSessionLabels checks labels against the limits and returns the
+// tags trimmed and without duplicates
+func validateSessionLabels(name string, tags []string, metadata map[string]interface{}) ([]string, error) {
+	if len(name) > MaxSessionNameLength {
+		return nil, fmt.Errorf("%w: name is longer than %d characters", ErrInvalidSessionLabels, MaxSessionNameLength)
+	}
+	if len(tags) > MaxSessionTags {
+		return nil, fmt.Errorf("%w: more than %d tags", ErrInvalidSessionLabels, MaxSessionTags)
+	}
+
+	var cleaned []string
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		switch {
+		case tag == "":
+			return nil, fmt.Errorf("%w: tags must not be empty", ErrInvalidSessionLabels)
+		case len(tag) > MaxSessionTagLength:
+			return nil, fmt.Errorf("%w: tag is longer than %d characters", ErrInvalidSessionLabels, MaxSessionTagLength)
+		case seen[tag]:
+			continue
+		}
+		seen[tag] = true
+		cleaned = append(cleaned, tag)
+	}
+
+	if len(metadata) > 0 {
+		data, err := json.Marshal(metadata)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSessionLabels, err)
+		}
+		if len(data) > MaxSessionMetadataSize {
+			return nil, fmt.Errorf("%w: metadata is larger than %d bytes", ErrInvalidSessionLabels, MaxSessionMetadataSize)
+		}
+	}
+	return cleaned, nil
+}
+
+// HasTags reports whether a session has every one of tags
+func (session *Session) HasTags(tags []string) bool {
+	for _, tag := range tags {
+		found := false
+		for _, own := range session.Tags {
+			if own == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// SessionsWithTags returns the sessions that have every one of tags
+func SessionsWithTags(sessions []*Session, tags []string) []*Session {
+	matching := make([]*Session, 0, len(sessions))
+	for _, session := range sessions {
+		if session.HasTags(tags) {
+			matching = append(matching, session)
+		}
+	}
+	return matching
+}
@@ -0,0 +1,332 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// SessionStore holds the sessions replicas of the API share. Processes,
+// persistent shells and sandbox containers are not stored: they stay on the
+// node that started them, which Session.Node and Session.ProcessNodes name.
+type SessionStore interface {
+	// Load returns a stored session, or nil if there is none
+	Load(id string) (*Session, error)
+	// Save stores a session until it expires
+	Save(session *Session) error
+	Delete(id string) error
+	List() ([]*Session, error)
+	// Shared reports whether other replicas may change the stored sessions
+	Shared() bool
+}
+
+// SetSessionStore sets where sessions are stored, in memory by default.
+// Sessions created before are not moved.
+func (sm *SessionManager) SetSessionStore(store SessionStore) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.store = store
+}
+
+// SetNode names this replica in the sessions and processes it hosts
+func (sm *SessionManager) SetNode(node string) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.node = node
+}
+
+// Node returns the name of this replica
+func (sm *SessionManager) Node() string {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	return sm.node
+}
+
+// SyncSession brings this node's copy of a session up to date with a shared
+// session store: sessions created on other replicas are adopted, and those
+// deleted or expired there are closed. Errors reaching the store are logged
+// and the local copy used.
+func (sm *SessionManager) SyncSession(id string) {
+	if !sm.store.Shared() {
+		return
+	}
+	stored, err := sm.store.Load(id)
+	if err != nil {
+		sessionLog(id).Warn("failed to load session from store", "error", err)
+		return
+	}
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	session, exists := sm.sessions[id]
+	switch {
+	case stored == nil && exists:
+		sm.closeSession(session)
+	case stored == nil:
+	case !exists:
+		sm.sessions[id] = stored
+	default:
+		session.Lock.Lock()
+		session.updateFrom(stored)
+		session.Lock.Unlock()
+	}
+}
+
+// SaveSession writes this node's copy of a session back to a shared session
+// store, along with the processes it runs here
+func (sm *SessionManager) SaveSession(id string) error {
+	if !sm.store.Shared() {
+		return nil
+	}
+
+	sm.mutex.RLock()
+	session, exists := sm.sessions[id]
+	if exists {
+		session.Lock.Lock()
+		session.recordProcessNodes(sm.node)
+		session.Lock.Unlock()
+	}
+	sm.mutex.RUnlock()
+
+	if !exists {
+		return nil
+	}
+	return sm.store.Save(session)
+}
+
+// localSessionIDs lists the sessions this node holds
+func (sm *SessionManager) localSessionIDs() []string {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	ids := make([]string, 0, len(sm.sessions))
+	for id := range sm.sessions {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// updateFrom copies the stored fields of a session, keeping the later of the
+// activity times. Processes and the shell stay as they are.
+func (session *Session) updateFrom(stored *Session) {
+	if stored.LastActive.After(session.LastActive) {
+		session.LastActive = stored.LastActive
+	}
+	if stored.ExpiresAt.After(session.ExpiresAt) {
+		session.ExpiresAt = stored.ExpiresAt
+	}
+	session.WorkingDir = stored.WorkingDir
+	session.IsActive = stored.IsActive
+	session.ActivityLog = stored.ActivityLog
+	session.EnvVars = stored.EnvVars
+	session.Aliases = stored.Aliases
+	session.Sandbox = stored.Sandbox
+	session.Remote = stored.Remote
+	session.RunAs = stored.RunAs
+	session.Backups = stored.Backups
+	session.MaxProcesses = stored.MaxProcesses
+	session.Owner = stored.Owner
+	session.Node = stored.Node
+	session.ProcessNodes = stored.ProcessNodes
+	session.Name = stored.Name
+	session.Tags = stored.Tags
+	session.Metadata = stored.Metadata
+	session.ExpirySeconds = stored.ExpirySeconds
+	session.NeverExpire = stored.NeverExpire
+}
+
+// recordProcessNodes notes the processes running on node, keeping those
+// recorded for other nodes. Callers must hold sm.mutex and session.Lock.
+func (session *Session) recordProcessNodes(node string) {
+	processNodes := make(map[string]string)
+	for id, other := range session.ProcessNodes {
+		if other != node {
+			processNodes[id] = other
+		}
+	}
+	for id := range session.RunningProcesses {
+		processNodes[id] = node
+	}
+	if len(processNodes) == 0 {
+		processNodes = nil
+	}
+	session.ProcessNodes = processNodes
+}
+
+// processNode returns the node a process of the session runs on, if known
+func (session *Session) processNode(processID string) string {
+	session.Lock.Lock()
+	defer session.Lock.Unlock()
+	return session.ProcessNodes[processID]
+}
+
+// MemorySessionStore keeps sessions in this process, the default for a
+// single replica
+type MemorySessionStore struct {
+	sessions map[string]*Session
+	mutex    sync.RWMutex
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]*Session)}
+}
+
+func (s *MemorySessionStore) Load(id string) (*Session, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.sessions[id], nil
+}
+
+func (s *MemorySessionStore) Save(session *Session) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sessions[session.ID] = session
+	return nil
+}
+
+func (s *MemorySessionStore) Delete(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *MemorySessionStore) List() ([]*Session, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+func (s *MemorySessionStore) Shared() bool {
+	return false
+}
+
+// redisTimeout bounds each call to Redis
+const redisTimeout = 5 * time.Second
+
+// RedisSessionStore keeps sessions in Redis as JSON, under keys that expire
+// with the sessions, so every replica using the same Redis sees them
+type RedisSessionStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisSessionStore connects to the Redis server at url, such as
+// redis://:password@host:6379/0, storing sessions under keys starting with
+// prefix
+func NewRedisSessionStore(url string, prefix string) (*RedisSessionStore, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	return &RedisSessionStore{client: client, prefix: prefix}, nil
+}
+
+func (s *RedisSessionStore) Load(id string) (*Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	data, err := s.client.Get(ctx, s.prefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeSession(data)
+}
+
+func (s *RedisSessionStore) Save(session *Session) error {
+	session.Lock.Lock()
+	data, err := json.Marshal(session)
+	ttl, expires := session.TTL()
+	id := session.ID
+	session.Lock.Unlock()
+	if err != nil {
+		return err
+	}
+	if !expires {
+		// Kept until deleted
+		ttl = 0
+	} else if ttl <= 0 {
+		return s.Delete(id)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return s.client.Set(ctx, s.prefix+id, data, ttl).Err()
+}
+
+func (s *RedisSessionStore) Delete(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return s.client.Del(ctx, s.prefix+id).Err()
+}
+
+func (s *RedisSessionStore) List() ([]*Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	var keys []string
+	iter := s.client.Scan(ctx, 0, s.prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]*Session, 0, len(values))
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			// Expired between SCAN and MGET
+			continue
+		}
+		session, err := decodeSession([]byte(data))
+		if err != nil {
+			slog.Warn("skipping unreadable session in Redis", "key", keys[i], "error", err)
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+func (s *RedisSessionStore) Shared() bool {
+	return true
+}
+
+func decodeSession(data []byte) (*Session, error) {
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	session.RunningProcesses = make(map[string]*Process)
+	return &session, nil
+}
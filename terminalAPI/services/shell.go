@@ -0,0 +1,262 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidEnvName is returned for environment variables whose names are
+// not shell identifiers
+var ErrInvalidEnvName = errors.New("invalid environment variable name")
+
+// PersistentShell is a long-running shell owned by a session. Commands are
+// written to its stdin one at a time and their results are delimited by a
+// unique marker, so `cd`, exported variables and shell functions survive
+// between commands.
+type PersistentShell struct {
+	ShellPath string
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	stdout    chan string
+	stderr    chan string
+	// Held while a command runs, so commands run one at a time; closed is
+	// apart from it so the shell can be checked and closed meanwhile
+	mutex  sync.Mutex
+	closed atomic.Bool
+	// Set once the shell process was reaped, when its PID may be reused
+	exited atomic.Bool
+}
+
+// ShellResult holds the delimited output of a single command run in a
+// persistent shell
+type ShellResult struct {
+	Stdout     string
+	Stderr     string
+	ExitCode   int
+	WorkingDir string
+}
+
+// NewPersistentShell starts a shell in workingDir with the given environment
+func NewPersistentShell(shellPath string, workingDir string, env []string) (*PersistentShell, error) {
+	cmd := exec.Command(shellPath)
+	cmd.Dir = workingDir
+	cmd.Env = env
+	// Own process group so Close also reaches commands running in the shell
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdoutPipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderrPipe, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	shell := &PersistentShell{
+		ShellPath: shellPath,
+		cmd:       cmd,
+		stdin:     stdin,
+		stdout:    make(chan string, 1000),
+		stderr:    make(chan string, 1000),
+	}
+
+	go shell.readLines(stdoutPipe, shell.stdout)
+	go shell.readLines(stderrPipe, shell.stderr)
+	go func() {
+		cmd.Wait()
+		shell.exited.Store(true)
+		shell.closed.Store(true)
+	}()
+
+	return shell, nil
+}
+
+func (s *PersistentShell) readLines(pipe io.Reader, channel chan string) {
+	defer close(channel)
+	reader := bufio.NewReader(pipe)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			channel <- line
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// IsAlive reports whether the underlying shell process is still running
+func (s *PersistentShell) IsAlive() bool {
+	return !s.closed.Load()
+}
+
+// PID returns the process ID of the shell
+func (s *PersistentShell) PID() int {
+	if s.cmd == nil || s.cmd.Process == nil {
+		return 0
+	}
+	return s.cmd.Process.Pid
+}
+
+// Run executes a command in the shell and waits for its delimited result.
+// Commands run with stdin redirected from /dev/null so they cannot consume
+// the control stream. env is exported for this command only: the variables
+// get back the values they had, or are unset, once it ends. A timeout of 0
+// means no timeout; on timeout the shell is closed because the running
+// command cannot be interrupted in isolation.
+func (s *PersistentShell) Run(command string, env map[string]string, timeout time.Duration) (*ShellResult, error) {
+	if err := validateEnvNames(env); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed.Load() {
+		return nil, errors.New("persistent shell has exited")
+	}
+
+	marker := "__OSAI_DONE_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+
+	var script, restore strings.Builder
+	i := 0
+	for k, v := range env {
+		// Save whether and how the variable was set, to put it back after
+		saved, wasSet := fmt.Sprintf("__osai_env_%d", i), fmt.Sprintf("__osai_set_%d", i)
+		script.WriteString(fmt.Sprintf("%s=${%s+1}; %s=${%s-}; export %s=%s\n", wasSet, k, saved, k, k, shellQuote(v)))
+		restore.WriteString(fmt.Sprintf("if [ -n \"$%s\" ]; then %s=$%s; else unset %s; fi; unset %s %s\n", wasSet, k, saved, k, saved, wasSet))
+		i++
+	}
+	script.WriteString(fmt.Sprintf("eval %s </dev/null\n", shellQuote(command)))
+	script.WriteString("__osai_ec=$?\n")
+	script.WriteString(restore.String())
+	script.WriteString(fmt.Sprintf("printf '%%s %%d %%s\\n' '%s' \"$__osai_ec\" \"$PWD\"; printf '%%s\\n' '%s' >&2\n",
+		marker, marker))
+
+	if _, err := io.WriteString(s.stdin, script.String()); err != nil {
+		return nil, err
+	}
+
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	result := &ShellResult{}
+	var stdout, stderr strings.Builder
+	stdoutDone, stderrDone := false, false
+
+	for !stdoutDone || !stderrDone {
+		select {
+		case line, ok := <-s.stdout:
+			if !ok {
+				return nil, errors.New("persistent shell exited while running command")
+			}
+			if idx := strings.Index(line, marker); idx >= 0 {
+				stdout.WriteString(line[:idx])
+				fields := strings.SplitN(strings.TrimSuffix(line[idx+len(marker):], "\n"), " ", 3)
+				if len(fields) >= 2 {
+					result.ExitCode, _ = strconv.Atoi(fields[1])
+				}
+				if len(fields) == 3 {
+					result.WorkingDir = fields[2]
+				}
+				stdoutDone = true
+				continue
+			}
+			stdout.WriteString(line)
+		case line, ok := <-s.stderr:
+			if !ok {
+				return nil, errors.New("persistent shell exited while running command")
+			}
+			if idx := strings.Index(line, marker); idx >= 0 {
+				stderr.WriteString(line[:idx])
+				stderrDone = true
+				continue
+			}
+			stderr.WriteString(line)
+		case <-timer:
+			s.Close()
+			return nil, errors.New("command timed out; persistent shell was reset")
+		}
+	}
+
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	return result, nil
+}
+
+// Close terminates the shell process and its process group, which holds
+// any command running in the shell and the background jobs it started,
+// even when the shell itself already exited. It does not wait for them, so
+// it can be called while holding the session's lock.
+func (s *PersistentShell) Close() error {
+	s.closed.Store(true)
+	s.stdin.Close()
+	if s.cmd == nil || s.cmd.Process == nil {
+		return nil
+	}
+	pid := s.cmd.Process.Pid
+	if !s.exited.Load() {
+		return signalProcessTree(pid, syscall.SIGKILL)
+	}
+
+	// The kernel does not hand out the PID of a process group while the
+	// group has members, so jobs left behind by the shell keep its PID from
+	// being reused. A process with that PID means they are gone and the PID
+	// now belongs to someone else, whose group must not be signalled.
+	if err := syscall.Kill(pid, 0); err != syscall.ESRCH {
+		return nil
+	}
+	if err := syscall.Kill(-pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return err
+	}
+	return nil
+}
+
+// validateEnvNames fails with ErrInvalidEnvName unless every name of env
+// is a shell identifier, which the shell cannot read as anything else
+func validateEnvNames(env map[string]string) error {
+	for name := range env {
+		if !envNamePattern.MatchString(name) {
+			return fmt.Errorf("%w: %q", ErrInvalidEnvName, name)
+		}
+	}
+	return nil
+}
+
+// shellQuote wraps a value in single quotes so the shell treats it literally
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// buildShellEnv merges the server environment with session variables
+func buildShellEnv(sessionEnv map[string]string) []string {
+	env := commandEnviron()
+	for k, v := range sessionEnv {
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	}
+	return env
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// processGone reports whether pid no longer runs, counting zombies that
+// are waiting to be reaped as gone
+func processGone(pid int) bool {
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return true
+	}
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] == "Z"
+}
+
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCloseKillsJobsOfExitedShell(t *testing.T) {
+	shell, err := NewPersistentShell("/bin/sh", t.TempDir(), commandEnviron())
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := shell.Run("sleep 300 >/dev/null 2>&1 & echo $!", nil, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := strconv.Atoi(strings.TrimSpace(result.Stdout))
+	if err != nil {
+		t.Fatalf("unexpected job PID %q", result.Stdout)
+	}
+	defer syscall.Kill(job, syscall.SIGKILL)
+
+	// The shell dies on its own, leaving the job in its process group
+	syscall.Kill(shell.PID(), syscall.SIGKILL)
+	waitFor(t, "the shell to exit", func() bool { return !shell.IsAlive() })
+	if processGone(job) {
+		t.Fatal("background job exited with the shell")
+	}
+
+	if err := shell.Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the background job to be killed", func() bool { return processGone(job) })
+}
@@ -0,0 +1,516 @@
+package services
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SnapshotVersion is written to every snapshot; imports refuse newer ones
+const SnapshotVersion = 1
+
+// MaxSnapshotFilesSize bounds the bytes of working directory files a
+// snapshot carries, on export and on import
+const MaxSnapshotFilesSize = 512 * 1024 * 1024
+
+// maxSnapshotManifestSize bounds the session.json of an imported snapshot
+const maxSnapshotManifestSize = 16 * 1024 * 1024
+
+// Entries of a snapshot archive: the manifest comes first, then the files
+// of the working directory
+const (
+	snapshotManifestName  = "session.json"
+	snapshotWorkdirPrefix = "workdir/"
+)
+
+// ErrInvalidSnapshot is returned when an archive is not a session snapshot
+// this server can import
+var ErrInvalidSnapshot = errors.New("invalid session snapshot")
+
+// ErrSnapshotTooLarge is returned when a working directory holds more than
+// MaxSnapshotFilesSize bytes
+var ErrSnapshotTooLarge = errors.New("session snapshot is too large")
+
+// SessionSnapshot is the portable state of a session, written as
+// session.json at the start of a snapshot archive. Processes, shells and
+// sandbox containers are not part of it.
+type SessionSnapshot struct {
+	Version       int                    `json:"version"`
+	ExportedAt    time.Time              `json:"exportedAt"`
+	Node          string                 `json:"node,omitempty"` // Host the session was exported from
+	SessionID     string                 `json:"sessionId"`
+	Name          string                 `json:"name,omitempty"`
+	Tags          []string               `json:"tags,omitempty"`
+	Metadata      map[string]interface{} `json:"metadata,omitempty"`
+	WorkingDir    string                 `json:"workingDir,omitempty"`
+	EnvVars       map[string]string      `json:"envVars,omitempty"`
+	Aliases       map[string]string      `json:"aliases,omitempty"`
+	ActivityLog   []ActivityEntry        `json:"activityLog,omitempty"`
+	History       []HistoryEntry         `json:"history,omitempty"`
+	Sandbox       *SandboxConfig         `json:"sandbox,omitempty"`
+	Remote        *RemoteConfig          `json:"remote,omitempty"`
+	RunAs         string                 `json:"runAs,omitempty"`
+	Backups       bool                   `json:"backups,omitempty"`
+	MaxProcesses  int                    `json:"maxProcesses,omitempty"`
+	ExpirySeconds int                    `json:"expirySeconds,omitempty"`
+	// The archive holds the working directory's files under workdir/
+	IncludesFiles bool `json:"includesFiles"`
+}
+
+// SnapshotImportOptions are the settings of an import that are not in the
+// snapshot
+type SnapshotImportOptions struct {
+	// Directory to restore the working directory to, instead of the one the
+	// session had
+	WorkingDir string
+	// Set from the authenticated API key
+	Owner string
+	// Whether the snapshot's sandbox may have extra mounts, for admin keys
+	AllowMounts bool
+	// Whether the snapshot may be of a remote session, for admin keys
+	AllowRemote bool
+}
+
+// SnapshotImportResult reports the session an import created
+type SnapshotImportResult struct {
+	Session *Session `json:"session"`
+	Files   int      `json:"files"` // Files restored into the working directory
+	// Parts of the snapshot this host could not restore
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// SnapshotService exports sessions as archives and imports them, so an
+// agent's session can be handed over to another host
+type SnapshotService struct {
+	sessionManager *SessionManager
+	historyService *HistoryService
+}
+
+func NewSnapshotService(sm *SessionManager, hs *HistoryService) *SnapshotService {
+	return &SnapshotService{
+		sessionManager: sm,
+		historyService: hs,
+	}
+}
+
+// Snapshot captures a session's state for WriteSnapshot. With includeFiles
+// the session must have a working directory within MaxSnapshotFilesSize.
+func (s *SnapshotService) Snapshot(sessionID string, includeFiles bool) (*SessionSnapshot, error) {
+	session, err := s.sessionManager.GetSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	session.Lock.Lock()
+	snapshot := &SessionSnapshot{
+		Version:       SnapshotVersion,
+		ExportedAt:    time.Now(),
+		Node:          session.Node,
+		SessionID:     session.ID,
+		Name:          session.Name,
+		Tags:          append([]string(nil), session.Tags...),
+		Metadata:      session.Metadata,
+		WorkingDir:    session.WorkingDir,
+		EnvVars:       copyStringMap(session.EnvVars),
+		Aliases:       copyStringMap(session.Aliases),
+		ActivityLog:   append([]ActivityEntry(nil), session.ActivityLog...),
+		Sandbox:       session.Sandbox,
+		Remote:        session.Remote,
+		RunAs:         session.RunAs,
+		Backups:       session.Backups,
+		MaxProcesses:  session.MaxProcesses,
+		ExpirySeconds: session.ExpirySeconds,
+		IncludesFiles: includeFiles,
+	}
+	session.Lock.Unlock()
+
+	if snapshot.Node == "" {
+		snapshot.Node = s.sessionManager.Node()
+	}
+
+	s.historyService.mutex.RLock()
+	snapshot.History = append([]HistoryEntry(nil), s.historyService.history[sessionID]...)
+	s.historyService.mutex.RUnlock()
+
+	if includeFiles {
+		if snapshot.WorkingDir == "" {
+			return nil, errors.New("session has no working directory to export")
+		}
+		if snapshot.Remote != nil {
+			return nil, fmt.Errorf("exporting files is %w", ErrRemoteUnsupported)
+		}
+		if _, err := walkSnapshotFiles(snapshot.WorkingDir, nil); err != nil {
+			return nil, err
+		}
+	}
+	return snapshot, nil
+}
+
+// WriteSnapshot writes a snapshot to w as a gzipped tar archive:
+// session.json, then the regular files and directories of the working
+// directory under workdir/ if the snapshot includes them. Symlinks and
+// special files are left out.
+func (s *SnapshotService) WriteSnapshot(snapshot *SessionSnapshot, w io.Writer) (err error) {
+	files := 0
+	defer func() {
+		s.sessionManager.Audit(snapshot.SessionID, "session.export", "", fmt.Sprintf("%d entries, %d files", len(snapshot.History), files), err)
+	}()
+
+	manifest, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	header := &tar.Header{
+		Name:     snapshotManifestName,
+		Mode:     0600,
+		Size:     int64(len(manifest)),
+		ModTime:  snapshot.ExportedAt,
+		Typeflag: tar.TypeReg,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := tw.Write(manifest); err != nil {
+		return err
+	}
+
+	if snapshot.IncludesFiles {
+		files, err = walkSnapshotFiles(snapshot.WorkingDir, tw)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// walkSnapshotFiles adds the regular files and directories below root to
+// tw, or only counts them when tw is nil, failing once they hold more than
+// MaxSnapshotFilesSize bytes. It returns the number of files.
+func walkSnapshotFiles(root string, tw *tar.Writer) (int, error) {
+	files := 0
+	var size int64
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+
+		if !info.IsDir() {
+			files++
+			size += info.Size()
+			if size > MaxSnapshotFilesSize {
+				return fmt.Errorf("%w: the working directory holds more than %d bytes", ErrSnapshotTooLarge, MaxSnapshotFilesSize)
+			}
+		}
+		if tw == nil {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		// Owners are not carried over to another host
+		header.Name = snapshotWorkdirPrefix + filepath.ToSlash(rel)
+		header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
+		if info.IsDir() {
+			header.Name += "/"
+			return tw.WriteHeader(header)
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		// Copy the size written in the header even if the file has changed since
+		_, err = io.CopyN(tw, file, header.Size)
+		return err
+	})
+	return files, err
+}
+
+// ImportSession creates a session from a snapshot archive read from r. The
+// working directory's files are restored to opts.WorkingDir, or to the
+// directory the session had, which must be empty or not exist yet. Settings
+// this host cannot honour, such as a missing shell, are reported as
+// warnings; on any other failure the new session is deleted again.
+func (s *SnapshotService) ImportSession(r io.Reader, opts *SnapshotImportOptions) (result *SnapshotImportResult, err error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	tr := tar.NewReader(gz)
+
+	header, err := tr.Next()
+	if err != nil || header.Name != snapshotManifestName {
+		return nil, fmt.Errorf("%w: the archive does not start with %s", ErrInvalidSnapshot, snapshotManifestName)
+	}
+	var snapshot SessionSnapshot
+	if err := json.NewDecoder(io.LimitReader(tr, maxSnapshotManifestSize)).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	if snapshot.Version < 1 || snapshot.Version > SnapshotVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidSnapshot, snapshot.Version)
+	}
+
+	sm := s.sessionManager
+	session, err := sm.CreateSession(&SessionOptions{
+		Sandbox:       snapshot.Sandbox,
+		Remote:        snapshot.Remote,
+		RunAs:         snapshot.RunAs,
+		Backups:       snapshot.Backups,
+		MaxProcesses:  snapshot.MaxProcesses,
+		ExpirySeconds: snapshot.ExpirySeconds,
+		Name:          snapshot.Name,
+		Tags:          snapshot.Tags,
+		Metadata:      snapshot.Metadata,
+		Owner:         opts.Owner,
+		AllowMounts:   opts.AllowMounts,
+		AllowRemote:   opts.AllowRemote,
+	})
+	if err != nil {
+		return nil, err
+	}
+	imported := &SnapshotImportResult{Session: session}
+	defer func() {
+		sm.Audit(session.ID, "session.import", snapshot.SessionID, fmt.Sprintf("%d files", imported.Files), err)
+		if err != nil {
+			sm.DeleteSession(session.ID)
+		}
+	}()
+
+	// The exported activity is kept, with its own timestamps, in place of the
+	// new session's
+	session.Lock.Lock()
+	session.ActivityLog = nil
+	for _, entry := range snapshot.ActivityLog {
+		session.ActivityLog = append(session.ActivityLog, entry)
+		sm.activity.write(session.ID, entry)
+	}
+	sm.addActivity(session, ActivityEntry{
+		Category: ActivitySession,
+		Target:   snapshot.SessionID,
+		Message: fmt.Sprintf("Session imported from %s, exported from %s at %s",
+			snapshot.SessionID, snapshot.Node, snapshot.ExportedAt.Format(time.RFC3339)),
+	})
+	session.Lock.Unlock()
+
+	dir := opts.WorkingDir
+	if dir == "" {
+		dir = snapshot.WorkingDir
+	}
+	if snapshot.IncludesFiles {
+		if dir == "" {
+			return nil, fmt.Errorf("%w: the snapshot has files but no working directory", ErrInvalidSnapshot)
+		}
+		if imported.Files, err = s.restoreFiles(tr, dir, snapshot.RunAs); err != nil {
+			return nil, err
+		}
+	}
+	if dir != "" {
+		if err := sm.SetWorkingDirectory(session.ID, dir); err != nil {
+			// A directory asked for, or restored, must be usable
+			if opts.WorkingDir != "" || snapshot.IncludesFiles {
+				return nil, err
+			}
+			imported.Warnings = append(imported.Warnings, fmt.Sprintf("working directory %s was not restored: %v", dir, err))
+		}
+	}
+
+	envVars := copyStringMap(snapshot.EnvVars)
+	if shell, exists := envVars["SHELL"]; exists && snapshot.Sandbox == nil && snapshot.Remote == nil {
+		if shellPath, err := sm.validateShell(shell); err != nil {
+			// Keep this host's default shell
+			delete(envVars, "SHELL")
+			imported.Warnings = append(imported.Warnings, fmt.Sprintf("shell %s was not restored: %v", shell, err))
+		} else {
+			envVars["SHELL"] = shellPath
+		}
+	}
+	session.Lock.Lock()
+	for key, value := range envVars {
+		session.EnvVars[key] = value
+	}
+	session.Aliases = copyStringMap(snapshot.Aliases)
+	session.Lock.Unlock()
+
+	if len(snapshot.History) > 0 {
+		data, err := json.Marshal(snapshot.History)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := s.historyService.ImportHistory(session, &HistoryImportRequest{Format: HistoryFormatJSON, Content: string(data)}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := sm.SaveSession(session.ID); err != nil {
+		return nil, err
+	}
+	return imported, nil
+}
+
+// restoreFiles extracts the workdir/ entries of a snapshot into dir, which
+// must lie within the allowed directories and be empty or not exist, and
+// gives them to runAs if set. It returns the number of files.
+func (s *SnapshotService) restoreFiles(tr *tar.Reader, dir string, runAs string) (int, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.checkRestoreDir(dir); err != nil {
+		return 0, err
+	}
+	// Files are only written into a fresh directory, so none of its entries
+	// can be a symlink leading elsewhere
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return 0, fmt.Errorf("working directory %s is not empty", dir)
+	} else if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+
+	uid, gid := -1, -1
+	if runAs != "" {
+		user, err := lookupRunAsUser(runAs)
+		if err != nil {
+			return 0, err
+		}
+		uid, gid = int(user.Credential.Uid), int(user.Credential.Gid)
+	}
+
+	files := 0
+	var size int64
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return files, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+		}
+
+		name, found := strings.CutPrefix(header.Name, snapshotWorkdirPrefix)
+		name = path.Clean(name)
+		if !found || name == "." || path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return files, fmt.Errorf("%w: unexpected entry %s", ErrInvalidSnapshot, header.Name)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		mode := os.FileMode(header.Mode).Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode|0700); err != nil {
+				return files, err
+			}
+		case tar.TypeReg:
+			size += header.Size
+			if size > MaxSnapshotFilesSize {
+				return files, fmt.Errorf("%w: the working directory holds more than %d bytes", ErrSnapshotTooLarge, MaxSnapshotFilesSize)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return files, err
+			}
+			if err := writeSnapshotFile(target, tr, mode); err != nil {
+				return files, err
+			}
+			files++
+		default:
+			// Exports hold no other entries
+			continue
+		}
+
+		if uid >= 0 {
+			if err := os.Lchown(target, uid, gid); err != nil {
+				return files, err
+			}
+		}
+		os.Chtimes(target, header.ModTime, header.ModTime)
+	}
+}
+
+// writeSnapshotFile creates a file that must not exist yet with the
+// contents of an archive entry
+func writeSnapshotFile(target string, r io.Reader, mode os.FileMode) error {
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// checkRestoreDir fails with ErrDirectoryNotAllowed when dir, or the
+// nearest of its parents that exists, lies outside the allowed directories
+func (s *SnapshotService) checkRestoreDir(dir string) error {
+	existing := dir
+	for {
+		if _, err := os.Stat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		existing = parent
+	}
+
+	sm := s.sessionManager
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	if err := sm.checkAllowedDir(existing); errors.Is(err, ErrDirectoryNotAllowed) {
+		return fmt.Errorf("%w: %s", ErrDirectoryNotAllowed, dir)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func copyStringMap(values map[string]string) map[string]string {
+	if values == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(values))
+	for key, value := range values {
+		copied[key] = value
+	}
+	return copied
+}
@@ -0,0 +1,531 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Object store providers
+const (
+	StorageS3    = "s3"
+	StorageGCS   = "gcs"
+	StorageMinIO = "minio"
+)
+
+// Secrets holding object store credentials unless a request names others
+const (
+	DefaultAccessKeySecret    = "AWS_ACCESS_KEY_ID"
+	DefaultSecretKeySecret    = "AWS_SECRET_ACCESS_KEY"
+	DefaultSessionTokenSecret = "AWS_SESSION_TOKEN"
+)
+
+// Limits for transfers: S3 takes objects up to 5 GiB in a single upload,
+// and a request moves at most maxStorageObjects objects
+const (
+	DefaultStorageTimeout = 30 * time.Minute
+	maxStorageObjectSize  = 5 << 30
+	maxStorageObjects     = 10000
+)
+
+// ErrInvalidStorageRequest is returned for requests naming no bucket, an
+// unknown provider or a malformed endpoint
+var ErrInvalidStorageRequest = errors.New("invalid storage request")
+
+// StorageRequest names a bucket, the objects in it and a path in the
+// session working directory. Credentials are never passed in the request:
+// they are read from stored secrets.
+type StorageRequest struct {
+	Provider  string `json:"provider,omitempty"` // s3 (default), gcs or minio
+	Endpoint  string `json:"endpoint,omitempty"` // Required for minio, e.g. http://localhost:9000
+	Region    string `json:"region,omitempty"`   // us-east-1 by default, auto for gcs
+	Bucket    string `json:"bucket"`
+	Key       string `json:"key,omitempty"`       // Object key, or a prefix ending in / for many objects
+	Path      string `json:"path,omitempty"`      // Relative to the session working directory, which it defaults to
+	PathStyle *bool  `json:"pathStyle,omitempty"` // Address buckets as endpoint/bucket; default for minio
+	Overwrite bool   `json:"overwrite,omitempty"` // Replace existing files when downloading
+	Timeout   int    `json:"timeout,omitempty"`   // In seconds, DefaultStorageTimeout by default
+
+	// Names of the secrets holding the credentials, by default
+	// DefaultAccessKeySecret, DefaultSecretKeySecret and, if it is stored,
+	// DefaultSessionTokenSecret
+	AccessKeySecret    string `json:"accessKeySecret,omitempty"`
+	SecretKeySecret    string `json:"secretKeySecret,omitempty"`
+	SessionTokenSecret string `json:"sessionTokenSecret,omitempty"`
+}
+
+// StorageTransferResult is the outcome of transferring one object
+type StorageTransferResult struct {
+	Key     string `json:"key"`
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// StorageTransfer reports an upload or download
+type StorageTransfer struct {
+	Bucket      string                  `json:"bucket"`
+	Key         string                  `json:"key"`
+	Path        string                  `json:"path"`
+	Transferred int                     `json:"transferred"`
+	Failed      int                     `json:"failed"`
+	Bytes       int64                   `json:"bytes"`
+	Results     []StorageTransferResult `json:"results"`
+}
+
+func (t *StorageTransfer) add(result StorageTransferResult) {
+	if result.Success {
+		t.Transferred++
+		t.Bytes += result.Size
+	} else {
+		t.Failed++
+	}
+	t.Results = append(t.Results, result)
+}
+
+// StorageListing holds the objects under a prefix
+type StorageListing struct {
+	Bucket    string          `json:"bucket"`
+	Prefix    string          `json:"prefix"`
+	Objects   []StorageObject `json:"objects"`
+	Count     int             `json:"count"`
+	Truncated bool            `json:"truncated,omitempty"` // More than maxStorageObjects objects
+}
+
+// StorageService moves files between session working directories and S3,
+// Google Cloud Storage or MinIO buckets
+type StorageService struct {
+	sessionManager *SessionManager
+	http           *http.Client
+}
+
+func NewStorageService(sm *SessionManager) *StorageService {
+	return &StorageService{
+		sessionManager: sm,
+		http:           &http.Client{},
+	}
+}
+
+// client returns a client for the request's bucket, signed with the
+// credentials from its secrets
+func (ss *StorageService) client(request *StorageRequest) (*storageClient, error) {
+	if request.Bucket == "" {
+		return nil, fmt.Errorf("%w: bucket is required", ErrInvalidStorageRequest)
+	}
+
+	provider := request.Provider
+	if provider == "" {
+		provider = StorageS3
+	}
+	region := request.Region
+	endpoint := request.Endpoint
+	pathStyle := false
+	switch provider {
+	case StorageS3:
+		if region == "" {
+			region = "us-east-1"
+		}
+		if endpoint == "" {
+			endpoint = "https://s3." + region + ".amazonaws.com"
+		}
+	case StorageGCS:
+		if region == "" {
+			region = "auto"
+		}
+		if endpoint == "" {
+			endpoint = "https://storage.googleapis.com"
+		}
+	case StorageMinIO:
+		if endpoint == "" {
+			return nil, fmt.Errorf("%w: endpoint is required for minio", ErrInvalidStorageRequest)
+		}
+		if region == "" {
+			region = "us-east-1"
+		}
+		pathStyle = true
+	default:
+		return nil, fmt.Errorf("%w: provider must be s3, gcs or minio", ErrInvalidStorageRequest)
+	}
+	if request.PathStyle != nil {
+		pathStyle = *request.PathStyle
+	}
+	// Bucket names with dots do not match the certificates of virtual hosts
+	if strings.Contains(request.Bucket, ".") {
+		pathStyle = true
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: endpoint must be an http or https URL", ErrInvalidStorageRequest)
+	}
+	u.Path = ""
+
+	credentials, err := ss.credentials(request)
+	if err != nil {
+		return nil, err
+	}
+	return &storageClient{
+		http:        ss.http,
+		endpoint:    u,
+		region:      region,
+		bucket:      request.Bucket,
+		pathStyle:   pathStyle,
+		credentials: *credentials,
+	}, nil
+}
+
+// credentials reads the credentials a request names from stored secrets
+func (ss *StorageService) credentials(request *StorageRequest) (*storageCredentials, error) {
+	accessKey := request.AccessKeySecret
+	if accessKey == "" {
+		accessKey = DefaultAccessKeySecret
+	}
+	secretKey := request.SecretKeySecret
+	if secretKey == "" {
+		secretKey = DefaultSecretKeySecret
+	}
+	values, err := ss.sessionManager.resolveSecrets([]string{accessKey, secretKey})
+	if err != nil {
+		return nil, err
+	}
+	credentials := &storageCredentials{AccessKey: values[accessKey], SecretKey: values[secretKey]}
+
+	token := request.SessionTokenSecret
+	optional := token == ""
+	if optional {
+		token = DefaultSessionTokenSecret
+	}
+	values, err = ss.sessionManager.resolveSecrets([]string{token})
+	switch {
+	case err == nil:
+		credentials.SessionToken = values[token]
+	case !optional || !errors.Is(err, ErrSecretNotFound):
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// begin checks the session and prepares a client and a context bounded by
+// the request's timeout
+func (ss *StorageService) begin(sessionID string, request *StorageRequest) (*Session, *storageClient, context.Context, context.CancelFunc, error) {
+	session, err := ss.sessionManager.GetSession(sessionID)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if session.WorkingDir == "" {
+		return nil, nil, nil, nil, ErrNoWorkingDir
+	}
+	if session.Remote != nil {
+		return nil, nil, nil, nil, fmt.Errorf("object storage is %w", ErrRemoteUnsupported)
+	}
+	client, err := ss.client(request)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	timeout := DefaultStorageTimeout
+	if request.Timeout > 0 {
+		timeout = time.Duration(request.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	return session, client, ctx, cancel, nil
+}
+
+// List returns the objects under the request's key, at most
+// maxStorageObjects of them
+func (ss *StorageService) List(sessionID string, request *StorageRequest) (*StorageListing, error) {
+	_, client, ctx, cancel, err := ss.begin(sessionID, request)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	objects, truncated, err := client.list(ctx, request.Key, maxStorageObjects)
+	if err != nil {
+		return nil, err
+	}
+	return &StorageListing{
+		Bucket:    request.Bucket,
+		Prefix:    request.Key,
+		Objects:   objects,
+		Count:     len(objects),
+		Truncated: truncated,
+	}, nil
+}
+
+// Upload copies a file, or a directory with every file in it, from the
+// session working directory to the bucket. A file goes to the request's
+// key, or under it when the key is empty or ends in /; the files of a
+// directory go under the key by their path in it. Symlinks are skipped.
+// Each file succeeds or fails on its own.
+func (ss *StorageService) Upload(sessionID string, request *StorageRequest) (*StorageTransfer, error) {
+	session, client, ctx, cancel, err := ss.begin(sessionID, request)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	source, err := resolveSessionFile(session, sessionPath(request.Path))
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+
+	transfer := &StorageTransfer{Bucket: request.Bucket, Key: request.Key, Path: sessionPath(request.Path), Results: []StorageTransferResult{}}
+	if !info.IsDir() {
+		key := request.Key
+		if key == "" || strings.HasSuffix(key, "/") {
+			key += filepath.Base(source)
+		}
+		transfer.add(ss.upload(ctx, client, source, transfer.Path, key))
+	} else {
+		prefix := request.Key
+		if prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		err = filepath.WalkDir(source, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.Type().IsRegular() {
+				return nil
+			}
+			if transfer.Transferred+transfer.Failed == maxStorageObjects {
+				return fmt.Errorf("%w: a directory may hold at most %d files", ErrInvalidStorageRequest, maxStorageObjects)
+			}
+			rel, err := filepath.Rel(source, file)
+			if err != nil {
+				return err
+			}
+			key := prefix + filepath.ToSlash(rel)
+			transfer.add(ss.upload(ctx, client, file, filepath.Join(transfer.Path, rel), key))
+			return ctx.Err()
+		})
+	}
+
+	ss.record(sessionID, "storage.upload", transfer, "Uploaded", err)
+	if err != nil {
+		return nil, err
+	}
+	return transfer, nil
+}
+
+// upload puts a single file, hashing it first since S3 checks the payload
+// against its signed hash
+func (ss *StorageService) upload(ctx context.Context, client *storageClient, file string, relPath string, key string) StorageTransferResult {
+	result := StorageTransferResult{Key: key, Path: relPath}
+	fail := func(err error) StorageTransferResult {
+		result.Error = err.Error()
+		return result
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return fail(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fail(err)
+	}
+	if info.Size() > maxStorageObjectSize {
+		return fail(fmt.Errorf("%s is over the %d byte limit of a single upload", relPath, int64(maxStorageObjectSize)))
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return fail(err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fail(err)
+	}
+
+	resp, err := client.do(ctx, http.MethodPut, key, nil, f, info.Size(), hex.EncodeToString(hash.Sum(nil)))
+	if err != nil {
+		return fail(err)
+	}
+	resp.Body.Close()
+	result.Size = info.Size()
+	result.Success = true
+	return result
+}
+
+// Download copies an object, or every object under a key ending in /, from
+// the bucket into the session working directory. A single object goes to
+// the request's path, or into it when the path is a directory; objects
+// under a prefix go into the path, which is created if needed, by their
+// key after the prefix. Existing files are kept unless the request sets
+// Overwrite, and keys leading outside the working directory are refused.
+// Each object succeeds or fails on its own.
+func (ss *StorageService) Download(sessionID string, request *StorageRequest) (*StorageTransfer, error) {
+	session, client, ctx, cancel, err := ss.begin(sessionID, request)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	relPath := sessionPath(request.Path)
+	transfer := &StorageTransfer{Bucket: request.Bucket, Key: request.Key, Path: relPath, Results: []StorageTransferResult{}}
+	if request.Key != "" && !strings.HasSuffix(request.Key, "/") {
+		target := relPath
+		if info, err := os.Stat(filepath.Join(session.WorkingDir, relPath)); err == nil && info.IsDir() {
+			target = filepath.Join(relPath, path.Base(request.Key))
+		}
+		transfer.add(ss.download(ctx, session, client, request.Key, target, request.Overwrite))
+	} else {
+		var objects []StorageObject
+		var truncated bool
+		objects, truncated, err = client.list(ctx, request.Key, maxStorageObjects)
+		if err == nil && truncated {
+			err = fmt.Errorf("%w: more than %d objects under %q", ErrInvalidStorageRequest, maxStorageObjects, request.Key)
+		}
+		for _, object := range objects {
+			if err != nil {
+				break
+			}
+			name := strings.TrimPrefix(object.Key, request.Key)
+			if name == "" || strings.HasSuffix(name, "/") {
+				continue // Folder placeholders
+			}
+			transfer.add(ss.download(ctx, session, client, object.Key, filepath.Join(relPath, filepath.FromSlash(name)), request.Overwrite))
+			err = ctx.Err()
+		}
+	}
+
+	ss.record(sessionID, "storage.download", transfer, "Downloaded", err)
+	if err != nil {
+		return nil, err
+	}
+	return transfer, nil
+}
+
+// download gets a single object into a temporary file renamed into place
+// once complete, so a failed download leaves any existing file as it was
+func (ss *StorageService) download(ctx context.Context, session *Session, client *storageClient, key string, relPath string, overwrite bool) StorageTransferResult {
+	result := StorageTransferResult{Key: key, Path: relPath}
+	fail := func(err error) StorageTransferResult {
+		result.Error = err.Error()
+		return result
+	}
+
+	target, err := resolveSessionTarget(session, relPath)
+	if err != nil {
+		return fail(err)
+	}
+	if info, err := os.Lstat(target); err == nil {
+		if info.IsDir() {
+			return fail(fmt.Errorf("%s is a directory", relPath))
+		}
+		if !overwrite {
+			return fail(fmt.Errorf("%s: %w", relPath, os.ErrExist))
+		}
+	}
+
+	resp, err := client.do(ctx, http.MethodGet, key, nil, nil, 0, "")
+	if err != nil {
+		return fail(err)
+	}
+	defer resp.Body.Close()
+
+	dir := filepath.Dir(target)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fail(err)
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(target)+".download-*")
+	if err != nil {
+		return fail(err)
+	}
+	defer os.Remove(tmp.Name())
+	size, err := io.Copy(tmp, resp.Body)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), target)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	result.Size = size
+	result.Success = true
+	return result
+}
+
+// record audits a transfer and logs it in the session's activity
+func (ss *StorageService) record(sessionID string, operation string, transfer *StorageTransfer, verb string, err error) {
+	target := transfer.Bucket + "/" + transfer.Key
+	detail := fmt.Sprintf("%s: %d objects, %d failed, %d bytes", transfer.Path, transfer.Transferred, transfer.Failed, transfer.Bytes)
+	ss.sessionManager.Audit(sessionID, operation, target, detail, err)
+
+	entry := ActivityEntry{
+		Category: ActivityStorage,
+		Target:   target,
+		Message:  fmt.Sprintf("%s %d objects (%d bytes) between %s and %s", verb, transfer.Transferred, transfer.Bytes, transfer.Path, target),
+	}
+	if err != nil || transfer.Failed > 0 {
+		entry.Outcome = ActivityFailure
+	}
+	ss.sessionManager.LogActivity(sessionID, entry)
+}
+
+// sessionPath returns a request path cleaned, with the working directory
+// as .
+func sessionPath(name string) string {
+	if name == "" {
+		return "."
+	}
+	return filepath.Clean(name)
+}
+
+// resolveSessionTarget returns the path of a file that may not exist yet
+// and must lie inside the session working directory, including after
+// following symlinks in the part of the path that exists
+func resolveSessionTarget(session *Session, name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return "", fmt.Errorf("%s must be relative to the session working directory", name)
+	}
+	root, err := filepath.EvalSymlinks(session.WorkingDir)
+	if err != nil {
+		return "", err
+	}
+	target := filepath.Join(root, name)
+	if rel, err := filepath.Rel(root, target); err != nil || escapesRoot(rel) {
+		return "", fmt.Errorf("%s is outside the session working directory", name)
+	}
+
+	// The deepest existing directory decides where the file really goes
+	existing := filepath.Dir(target)
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(root, resolved); err != nil || escapesRoot(rel) {
+		return "", fmt.Errorf("%s is outside the session working directory", name)
+	}
+	if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return "", fmt.Errorf("%s is a symlink", name)
+	}
+	return target, nil
+}
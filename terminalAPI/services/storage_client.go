@@ -0,0 +1,267 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StorageError is an error response from an object store
+type StorageError struct {
+	Status  int
+	Code    string // e.g. NoSuchKey or AccessDenied
+	Message string
+}
+
+func (e *StorageError) Error() string {
+	if e.Code == "" {
+		return e.Message
+	}
+	return e.Code + ": " + e.Message
+}
+
+// storageCredentials sign requests to an object store
+type storageCredentials struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+// storageClient speaks the S3 API, which S3, MinIO and Google Cloud Storage
+// (through its XML API and HMAC keys) all understand, signing requests with
+// AWS Signature Version 4
+type storageClient struct {
+	http        *http.Client
+	endpoint    *url.URL
+	region      string
+	bucket      string
+	pathStyle   bool
+	credentials storageCredentials
+}
+
+// objectURL returns the URL of an object, or of the bucket for an empty key
+func (sc *storageClient) objectURL(key string, query url.Values) *url.URL {
+	u := *sc.endpoint
+	if sc.pathStyle {
+		u.Path = "/" + sc.bucket + "/" + key
+	} else {
+		u.Host = sc.bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	// Sent as signed, since url.URL would leave some characters unescaped
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+// do sends a signed request. body may be nil; payloadHash is the hex SHA-256
+// of body, which S3 checks against what it receives. Error statuses are
+// turned into StorageError.
+func (sc *storageClient) do(ctx context.Context, method string, key string, query url.Values, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	u := sc.objectURL(key, query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	if payloadHash == "" {
+		payloadHash = emptyPayloadHash
+	}
+	sc.sign(req, u, payloadHash, time.Now().UTC())
+
+	resp, err := sc.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		apiError := &StorageError{Status: resp.StatusCode}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		var parsed struct {
+			Code    string
+			Message string
+		}
+		if xml.Unmarshal(data, &parsed) == nil {
+			apiError.Code = parsed.Code
+			apiError.Message = parsed.Message
+		}
+		if apiError.Message == "" {
+			apiError.Message = strings.TrimSpace(string(data))
+		}
+		if apiError.Message == "" {
+			apiError.Message = resp.Status
+		}
+		return nil, apiError
+	}
+	return resp, nil
+}
+
+// emptyPayloadHash is the SHA-256 of no bytes
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign adds the AWS Signature Version 4 headers to a request
+func (sc *storageClient) sign(req *http.Request, u *url.URL, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if sc.credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sc.credentials.SessionToken)
+	}
+
+	headers := map[string]string{"host": u.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		u.EscapedPath(),
+		u.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + sc.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+sc.credentials.SecretKey), date)
+	key = hmacSHA256(key, sc.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sc.credentials.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes query parameters sorted by name, as signing
+// requires and as they are sent
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		values := append([]string(nil), query[name]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything but unreserved characters and,
+// unless encodeSlash is set, slashes
+func uriEncode(text string, encodeSlash bool) string {
+	var encoded strings.Builder
+	for _, b := range []byte(text) {
+		switch {
+		case b >= 'A' && b <= 'Z', b >= 'a' && b <= 'z', b >= '0' && b <= '9',
+			b == '-', b == '_', b == '.', b == '~':
+			encoded.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			encoded.WriteByte(b)
+		default:
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return encoded.String()
+}
+
+// StorageObject is an object in a bucket
+type StorageObject struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// list returns the objects whose keys start with prefix, at most limit
+// of them, and whether there were more
+func (sc *storageClient) list(ctx context.Context, prefix string, limit int) ([]StorageObject, bool, error) {
+	objects := []StorageObject{}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}}
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := sc.do(ctx, http.MethodGet, "", query, nil, 0, "")
+		if err != nil {
+			return nil, false, err
+		}
+		var page struct {
+			Contents []struct {
+				Key          string
+				Size         int64
+				ETag         string
+				LastModified time.Time
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid bucket listing: %v", err)
+		}
+
+		for _, object := range page.Contents {
+			if len(objects) == limit {
+				return objects, true, nil
+			}
+			objects = append(objects, StorageObject{
+				Key:          object.Key,
+				Size:         object.Size,
+				ETag:         strings.Trim(object.ETag, `"`),
+				LastModified: object.LastModified,
+			})
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, false, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
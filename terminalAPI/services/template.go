@@ -0,0 +1,290 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// templateParam matches a {{name}} placeholder in a command template
+var templateParam = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// ErrTemplateNotFound is returned for templates a session has not registered
+var ErrTemplateNotFound = errors.New("template not found")
+
+// ErrInvalidTemplateParams is returned when execution parameters do not match
+// a template's placeholders
+var ErrInvalidTemplateParams = errors.New("invalid template parameters")
+
+// CommandTemplate is a reusable command line with named parameters
+type CommandTemplate struct {
+	Name        string            `json:"name"`
+	Template    string            `json:"template"`
+	Description string            `json:"description,omitempty"`
+	Parameters  []string          `json:"parameters"`
+	Defaults    map[string]string `json:"defaults,omitempty"`
+	CreatedAt   time.Time         `json:"createdAt"`
+}
+
+// TemplateRequest registers or replaces a template
+type TemplateRequest struct {
+	Name        string            `json:"name"`
+	Template    string            `json:"template"`
+	Description string            `json:"description,omitempty"`
+	Defaults    map[string]string `json:"defaults,omitempty"`
+}
+
+// TemplateExecuteRequest runs a template. The embedded command options apply
+// to the rendered command; its command field is ignored.
+type TemplateExecuteRequest struct {
+	Parameters map[string]string `json:"parameters"`
+	CommandRequest
+}
+
+// TemplateService stores per-session command templates and runs them with
+// parameters substituted as single, literally quoted shell words
+type TemplateService struct {
+	sessionManager *SessionManager
+	commandService *CommandService
+	templates      map[string]map[string]*CommandTemplate
+	mutex          sync.RWMutex
+}
+
+func NewTemplateService(sm *SessionManager, cs *CommandService) *TemplateService {
+	ts := &TemplateService{
+		sessionManager: sm,
+		commandService: cs,
+		templates:      make(map[string]map[string]*CommandTemplate),
+	}
+	sm.OnSessionClosed(ts.deleteSessionTemplates)
+	return ts
+}
+
+// deleteSessionTemplates forgets the templates of a deleted or expired session
+func (ts *TemplateService) deleteSessionTemplates(sessionID string) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	delete(ts.templates, sessionID)
+}
+
+// RegisterTemplate validates and stores a template, replacing any template
+// with the same name
+func (ts *TemplateService) RegisterTemplate(sessionID string, request *TemplateRequest) (*CommandTemplate, error) {
+	if _, err := ts.sessionManager.GetSession(sessionID); err != nil {
+		return nil, err
+	}
+
+	if request.Name == "" {
+		return nil, errors.New("template name is required")
+	}
+	if request.Template == "" {
+		return nil, errors.New("template is required")
+	}
+
+	params, err := parseTemplate(request.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range request.Defaults {
+		if !containsString(params, name) {
+			return nil, fmt.Errorf("default given for unknown parameter: %s", name)
+		}
+	}
+
+	template := &CommandTemplate{
+		Name:        request.Name,
+		Template:    request.Template,
+		Description: request.Description,
+		Parameters:  params,
+		Defaults:    request.Defaults,
+		CreatedAt:   time.Now(),
+	}
+
+	ts.mutex.Lock()
+	if _, exists := ts.templates[sessionID]; !exists {
+		ts.templates[sessionID] = make(map[string]*CommandTemplate)
+	}
+	ts.templates[sessionID][template.Name] = template
+	ts.mutex.Unlock()
+
+	ts.sessionManager.LogActivity(sessionID, ActivityEntry{
+		Category: ActivityTemplate,
+		Target:   template.Name,
+		Message:  "Registered template: " + template.Name,
+	})
+	ts.sessionManager.Audit(sessionID, "template.register", template.Name, "", nil)
+
+	return template, nil
+}
+
+// GetTemplate returns a single template by name
+func (ts *TemplateService) GetTemplate(sessionID string, name string) (*CommandTemplate, error) {
+	if _, err := ts.sessionManager.GetSession(sessionID); err != nil {
+		return nil, err
+	}
+
+	ts.mutex.RLock()
+	defer ts.mutex.RUnlock()
+
+	template, exists := ts.templates[sessionID][name]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+	return template, nil
+}
+
+// ListTemplates returns a session's templates sorted by name
+func (ts *TemplateService) ListTemplates(sessionID string) ([]*CommandTemplate, error) {
+	if _, err := ts.sessionManager.GetSession(sessionID); err != nil {
+		return nil, err
+	}
+
+	ts.mutex.RLock()
+	defer ts.mutex.RUnlock()
+
+	templates := make([]*CommandTemplate, 0, len(ts.templates[sessionID]))
+	for _, template := range ts.templates[sessionID] {
+		templates = append(templates, template)
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].Name < templates[j].Name
+	})
+	return templates, nil
+}
+
+// DeleteTemplate removes a template
+func (ts *TemplateService) DeleteTemplate(sessionID string, name string) error {
+	if _, err := ts.sessionManager.GetSession(sessionID); err != nil {
+		return err
+	}
+
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	if _, exists := ts.templates[sessionID][name]; !exists {
+		return fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+	delete(ts.templates[sessionID], name)
+
+	ts.sessionManager.Audit(sessionID, "template.delete", name, "", nil)
+	return nil
+}
+
+// ExecuteTemplate renders a template with the given parameters and runs it
+// through the command service
+func (ts *TemplateService) ExecuteTemplate(sessionID string, name string, request *TemplateExecuteRequest) (*CommandOutput, error) {
+	template, err := ts.GetTemplate(sessionID, name)
+	if err != nil {
+		return nil, err
+	}
+
+	command, err := template.Render(request.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	cmdReq := request.CommandRequest
+	cmdReq.Command = command
+
+	ts.sessionManager.Audit(sessionID, "template.execute", name, ts.sessionManager.redact(command), nil)
+
+	return ts.commandService.ExecuteCommand(sessionID, &cmdReq)
+}
+
+// Render substitutes parameters into the template. Every value is single
+// quoted so it reaches the command as exactly one literal argument.
+func (t *CommandTemplate) Render(params map[string]string) (string, error) {
+	for name := range params {
+		if !containsString(t.Parameters, name) {
+			return "", fmt.Errorf("%w: unknown parameter %s", ErrInvalidTemplateParams, name)
+		}
+	}
+
+	var missing []string
+	rendered := templateParam.ReplaceAllStringFunc(t.Template, func(placeholder string) string {
+		name := templateParam.FindStringSubmatch(placeholder)[1]
+		value, ok := params[name]
+		if !ok {
+			value, ok = t.Defaults[name]
+		}
+		if !ok {
+			missing = append(missing, name)
+			return placeholder
+		}
+		return shellQuote(value)
+	})
+
+	if len(missing) > 0 {
+		return "", fmt.Errorf("%w: missing %s", ErrInvalidTemplateParams, strings.Join(missing, ", "))
+	}
+	return rendered, nil
+}
+
+// parseTemplate returns the distinct parameter names of a template. Placeholders
+// must stand outside shell quotes, since values are quoted on substitution.
+func parseTemplate(template string) ([]string, error) {
+	matches := templateParam.FindAllStringSubmatchIndex(template, -1)
+	if len(matches) == 0 && strings.Contains(template, "{{") {
+		return nil, errors.New("template contains a malformed placeholder")
+	}
+
+	var params []string
+	var quote byte
+	pos := 0
+	for _, match := range matches {
+		if strings.Contains(template[pos:match[0]], "{{") {
+			return nil, errors.New("template contains a malformed placeholder")
+		}
+		quote = scanQuotes(template[pos:match[0]], quote)
+		if quote != 0 {
+			return nil, fmt.Errorf("placeholder %s must not be inside quotes", template[match[0]:match[1]])
+		}
+		name := template[match[2]:match[3]]
+		if !containsString(params, name) {
+			params = append(params, name)
+		}
+		pos = match[1]
+	}
+	if strings.Contains(template[pos:], "{{") {
+		return nil, errors.New("template contains a malformed placeholder")
+	}
+
+	return params, nil
+}
+
+// scanQuotes tracks which shell quote is open after reading text, starting
+// from the given open quote (0 for none)
+func scanQuotes(text string, quote byte) byte {
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			}
+		case c == '\\':
+			i++ // Escaped character, outside single quotes
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		}
+	}
+	return quote
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTools are checked when a tool discovery request names none
+var DefaultTools = []string{
+	"git", "make", "gcc", "go", "node", "npm", "python3", "pip3",
+	"java", "cargo", "docker", "kubectl", "curl",
+}
+
+// maxTools caps how many tools one request may check
+const maxTools = 50
+
+// toolCheckTimeout bounds each lookup and version command, so a tool that
+// waits for input cannot stall discovery
+const toolCheckTimeout = 5 * time.Second
+
+// Tools that do not understand --version
+var toolVersionArgs = map[string]string{
+	"go":   "version",
+	"java": "-version",
+	"ssh":  "-V",
+}
+
+var toolNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.+-]+$`)
+
+// ToolInfo reports whether a tool is available to a session's commands
+type ToolInfo struct {
+	Name    string `json:"name"`
+	Found   bool   `json:"found"`
+	Path    string `json:"path,omitempty"`
+	Version string `json:"version,omitempty"` // First line of the version output
+	Error   string `json:"error,omitempty"`
+}
+
+// DiscoverTools looks up tools the way the session's commands would find
+// them, through the session PATH, user and sandbox, and reports their paths
+// and versions. Tools are checked concurrently and returned in request order.
+func (cs *CommandService) DiscoverTools(sessionID string, names []string) ([]ToolInfo, error) {
+	session, err := cs.sessionManager.GetSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(names) == 0 {
+		names = DefaultTools
+	}
+	if len(names) > maxTools {
+		return nil, fmt.Errorf("at most %d tools can be checked at once", maxTools)
+	}
+	for _, name := range names {
+		if !toolNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid tool name: %s", name)
+		}
+	}
+
+	if session.Sandbox != nil {
+		if _, err := cs.sessionManager.containerRunner.EnsureContainer(session); err != nil {
+			return nil, err
+		}
+	}
+
+	tools := make([]ToolInfo, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			tools[i] = cs.checkTool(session, name)
+		}(i, name)
+	}
+	wg.Wait()
+
+	cs.sessionManager.Audit(sessionID, "tools.check", strings.Join(names, " "), "", nil)
+	return tools, nil
+}
+
+// checkTool resolves one tool with command -v and runs its version command
+func (cs *CommandService) checkTool(session *Session, name string) ToolInfo {
+	tool := ToolInfo{Name: name}
+
+	output, err := cs.runQuiet(session, "command -v "+name)
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			tool.Error = err.Error()
+		}
+		return tool
+	}
+	path := strings.TrimSpace(output)
+	if path == "" {
+		return tool
+	}
+	tool.Found = true
+	tool.Path = path
+
+	versionArg := toolVersionArgs[name]
+	if versionArg == "" {
+		versionArg = "--version"
+	}
+	output, err = cs.runQuiet(session, shellQuote(path)+" "+versionArg+" </dev/null")
+	tool.Version = firstLine(output)
+	if tool.Version == "" && err != nil {
+		tool.Error = "version check failed: " + err.Error()
+	}
+	return tool
+}
+
+// runQuiet runs a short command as the session would, without recording it,
+// and returns its combined output
+func (cs *CommandService) runQuiet(session *Session, command string) (string, error) {
+	plan := buildExecutionPlan(session, &CommandRequest{Command: command}, true, cs.sessionManager.containerRunner, cs.sessionManager.remoteRunner)
+
+	ctx, cancel := context.WithTimeout(context.Background(), toolCheckTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, plan.Shell, plan.Args...)
+	cmd.Dir = plan.hostDir
+	startInProcessGroup(cmd)
+	cmd.Env = plan.Env()
+	cmd.Stdin = plan.Stdin()
+	if err := applyRunAs(cmd, plan); err != nil {
+		return "", err
+	}
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return output.String(), errors.New("timed out")
+	}
+	return output.String(), err
+}
+
+// firstLine returns the first non-empty line of output
+func firstLine(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// WebhookSender POSTs JSON payloads to callback URLs, retrying failed
+// deliveries with exponential backoff
+type WebhookSender struct {
+	client         *http.Client
+	maxAttempts    int
+	initialBackoff time.Duration
+	// Signs payloads when set
+	secret string
+}
+
+// SignatureHeader carries the HMAC-SHA256 of a payload, as "sha256=<hex>",
+// when webhooks are signed
+const SignatureHeader = "X-OSAI-Signature"
+
+func NewWebhookSender() *WebhookSender {
+	return &WebhookSender{
+		client:         &http.Client{Timeout: 10 * time.Second},
+		maxAttempts:    5,
+		initialBackoff: time.Second,
+	}
+}
+
+// SetSecret signs every payload with secret, so receivers can check it came
+// from this server
+func (ws *WebhookSender) SetSecret(secret string) {
+	ws.secret = secret
+}
+
+// ValidateCallbackURL checks that a callback URL is an absolute http(s) URL
+func ValidateCallbackURL(callbackURL string) error {
+	parsed, err := url.Parse(callbackURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("invalid callback URL: %s", callbackURL)
+	}
+	return nil
+}
+
+// Send delivers a payload in the background
+func (ws *WebhookSender) Send(callbackURL string, payload interface{}) {
+	go func() {
+		if err := ws.deliver(callbackURL, payload); err != nil {
+			slog.Warn("webhook delivery failed", "url", callbackURL, "error", err)
+		}
+	}()
+}
+
+// deliver POSTs the payload until it is accepted, the receiver rejects it
+// outright or the attempts run out
+func (ws *WebhookSender) deliver(callbackURL string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	backoff := ws.initialBackoff
+	var lastErr error
+	for attempt := 1; attempt <= ws.maxAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		req, err := http.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if ws.secret != "" {
+			mac := hmac.New(sha256.New, []byte(ws.secret))
+			mac.Write(body)
+			req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		}
+
+		resp, err := ws.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			slog.Debug("webhook delivered", "url", callbackURL, "attempt", attempt)
+			return nil
+		}
+		lastErr = fmt.Errorf("receiver responded with status %d", resp.StatusCode)
+
+		// Client errors other than timeouts and throttling will not succeed on retry
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+			resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+			return lastErr
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("no delivery attempts made")
+	}
+	return fmt.Errorf("giving up after %d attempts: %w", ws.maxAttempts, lastErr)
+}
+
+// SessionWebhooks POSTs session lifecycle events to the URLs an
+// orchestrator configured, so it can reconcile its sessions with ours
+type SessionWebhooks struct {
+	urls   []string
+	sender *WebhookSender
+}
+
+// NewSessionWebhooks posts events to urls, signed with secret if set
+func NewSessionWebhooks(urls []string, secret string) (*SessionWebhooks, error) {
+	for _, callbackURL := range urls {
+		if err := ValidateCallbackURL(callbackURL); err != nil {
+			return nil, err
+		}
+	}
+	sender := NewWebhookSender()
+	sender.SetSecret(secret)
+	return &SessionWebhooks{urls: urls, sender: sender}, nil
+}
+
+// Send delivers an event to every URL in the background
+func (w *SessionWebhooks) Send(event SessionEvent) {
+	for _, callbackURL := range w.urls {
+		w.sender.Send(callbackURL, event)
+	}
+}